@@ -216,6 +216,7 @@ func runCapabilities() {
 		interfaces.AuthTypeJWT,
 		interfaces.AuthTypeAPIKey,
 		interfaces.AuthTypeBasic,
+		interfaces.AuthTypeSSHKey,
 	}
 	for _, authType := range auths {
 		fmt.Printf("  • %s\n", authType)
@@ -105,6 +105,7 @@ func main() {
 		interfaces.AuthTypeJWT,
 		interfaces.AuthTypeAPIKey,
 		interfaces.AuthTypeBasic,
+		interfaces.AuthTypeSSHKey,
 	} {
 		fmt.Printf("  • %s\n", authType)
 	}
@@ -0,0 +1,196 @@
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/sho7650/media-sync/internal/checksum"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys recorded by Ingest
+const (
+	// KeyChecksum is the checksum of the content as algorithm:hex. Sources
+	// that know it set it before ingestion to have the download verified.
+	KeyChecksum = "checksum"
+	// KeySize is the content size in bytes
+	KeySize = "size_bytes"
+	// KeyChecksums lists every checksum known to match the content: the
+	// recorded one and a verified source checksum of another algorithm,
+	// such as an MD5 ETag, so the item can be matched by either
+	KeyChecksums = "checksums"
+)
+
+// IngestOptions control how stream content is spooled into the store
+type IngestOptions struct {
+	// Algorithm computes the recorded checksum, SHA-256 by default; blobs
+	// are named by their SHA-256 digest whatever it is
+	Algorithm string
+	// Verify checks the content against the checksum in the stream
+	// metadata and its Content-Length header, when present. Checksums of
+	// algorithms that are not registered cannot be checked, so content
+	// carrying one is rejected.
+	Verify bool
+}
+
+// Content reads a stored blob; it implements
+// interfaces.ReopenableContent so every output can read it from the start
+type Content struct {
+	*os.File
+	store  *Store
+	digest string
+}
+
+// Ensure Content implements ReopenableContent
+var _ interfaces.ReopenableContent = (*Content)(nil)
+
+// OpenContent returns re-openable content for a stored blob
+func (s *Store) OpenContent(digest string) (*Content, error) {
+	f, err := s.Open(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &Content{File: f, store: s, digest: digest}, nil
+}
+
+// Reopen returns a new reader positioned at the start of the blob
+func (c *Content) Reopen() (io.ReadCloser, error) {
+	return c.store.OpenContent(c.digest)
+}
+
+// Digest returns the digest of the blob being read
+func (c *Content) Digest() string { return c.digest }
+
+// Ingest spools the content of data into the store, referenced by data.ID,
+// hashing it as it is written. Content that fails verification is rejected
+// with an error wrapping interfaces.ErrStreamRejected and checksum.ErrMismatch,
+// or checksum.ErrUnknownAlgorithm when its checksum cannot be checked, and
+// nothing is stored. On success data.Content reads the stored blob and the
+// metadata records its checksum and size. The item's references to blobs of
+// earlier content, such as the original of transformed content, are
+// released. Content already read from this store is not copied again.
+func (s *Store) Ingest(ctx context.Context, data *interfaces.DataStream, opts IngestOptions) (Blob, error) {
+	if data == nil || data.Content == nil {
+		return Blob{}, fmt.Errorf("no content to ingest")
+	}
+	if data.ID == "" {
+		return Blob{}, fmt.Errorf("media item ID cannot be empty")
+	}
+	algorithm := opts.Algorithm
+	if algorithm == "" {
+		algorithm = checksum.SHA256
+	}
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+
+	if c, ok := data.Content.(*Content); ok && c.store == s {
+		b, err := s.Ref(ctx, c.digest, data.ID)
+		if err != nil {
+			return Blob{}, err
+		}
+		if err := s.supersede(ctx, data.ID, b.Digest); err != nil {
+			return Blob{}, err
+		}
+		if algorithm == Algorithm {
+			data.Metadata[KeyChecksum] = b.Digest
+			data.Metadata[KeyChecksums] = []string{b.Digest}
+			data.Metadata[KeySize] = b.Size
+			return b, nil
+		}
+		// Another algorithm is recorded, so the blob is hashed again
+		if data.Content, err = s.OpenContent(b.Digest); err != nil {
+			return Blob{}, err
+		}
+		_ = c.Close()
+	}
+
+	algorithms := []string{algorithm}
+	expected, _ := data.Metadata[KeyChecksum].(string)
+	if opts.Verify && expected != "" {
+		expectedAlgorithm, _, err := checksum.Parse(expected)
+		if err != nil {
+			_ = data.Content.Close()
+			return Blob{}, fmt.Errorf("%w: %s: %w", interfaces.ErrStreamRejected, data.ID, err)
+		}
+		if _, err := checksum.New(expectedAlgorithm); err != nil {
+			_ = data.Content.Close()
+			return Blob{}, fmt.Errorf("%w: %s: %w", interfaces.ErrStreamRejected, data.ID, err)
+		}
+		algorithms = append(algorithms, expectedAlgorithm)
+	}
+
+	tmp, hasher, err := s.spool(ctx, data.Content, algorithms...)
+	_ = data.Content.Close()
+	data.Content = nil
+	if err != nil {
+		return Blob{}, err
+	}
+	defer os.Remove(tmp)
+
+	if opts.Verify {
+		if err := verify(data, hasher, expected); err != nil {
+			return Blob{}, fmt.Errorf("%w: %s: %w", interfaces.ErrStreamRejected, data.ID, err)
+		}
+	}
+	b, err := s.commit(ctx, tmp, hasher, data.ID)
+	if err != nil {
+		return Blob{}, err
+	}
+	if err := s.supersede(ctx, data.ID, b.Digest); err != nil {
+		return Blob{}, err
+	}
+	if data.Content, err = s.OpenContent(b.Digest); err != nil {
+		return Blob{}, err
+	}
+	sum, err := hasher.Sum(algorithm)
+	if err != nil {
+		return Blob{}, err
+	}
+	sums := []string{sum}
+	if opts.Verify && expected != "" {
+		if expectedAlgorithm, _, _ := checksum.Parse(expected); expectedAlgorithm != algorithm {
+			sums = append(sums, checksum.Normalize(expected))
+		}
+	}
+	data.Metadata[KeyChecksum] = sum
+	data.Metadata[KeyChecksums] = sums
+	data.Metadata[KeySize] = b.Size
+	return b, nil
+}
+
+// verify checks spooled content against the size and checksum the source
+// supplied
+func verify(data *interfaces.DataStream, hasher *checksum.Hasher, expected string) error {
+	if length, ok := data.Headers["Content-Length"]; ok {
+		size, err := strconv.ParseInt(length, 10, 64)
+		if err == nil && size != hasher.Size() {
+			return fmt.Errorf("%w: expected %d bytes, got %d", checksum.ErrMismatch, size, hasher.Size())
+		}
+	}
+	if expected == "" {
+		return nil
+	}
+	return hasher.Verify(expected)
+}
+
+// supersede releases the references from mediaID to blobs other than the
+// one holding its current content
+func (s *Store) supersede(ctx context.Context, mediaID, digest string) error {
+	digests, err := s.refs.MediaBlobs(ctx, mediaID)
+	if err != nil {
+		return err
+	}
+	for _, old := range digests {
+		if old == digest {
+			continue
+		}
+		if _, err := s.Release(ctx, old, mediaID); err != nil {
+			return fmt.Errorf("failed to release superseded blob %s: %w", old, err)
+		}
+	}
+	return nil
+}
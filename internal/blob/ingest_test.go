@@ -0,0 +1,170 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/checksum"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func stream(content string, metadata map[string]interface{}, headers map[string]string) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "tumblr-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: metadata,
+		Headers:  headers,
+		Content:  io.NopCloser(strings.NewReader(content)),
+	}
+}
+
+func readAll(t *testing.T, rc io.ReadCloser) string {
+	t.Helper()
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestIngest_ReopenableContent(t *testing.T) {
+	ctx := context.Background()
+	store, db := newStore(t)
+	data := stream("photo bytes", nil, map[string]string{"Content-Length": "11"})
+
+	b, err := store.Ingest(ctx, data, IngestOptions{Verify: true})
+	require.NoError(t, err)
+	assert.Equal(t, digestOf("photo bytes"), b.Digest)
+	assert.Equal(t, b.Digest, data.Metadata[KeyChecksum])
+	assert.Equal(t, int64(11), data.Metadata[KeySize])
+
+	reopenable, ok := data.Content.(interfaces.ReopenableContent)
+	require.True(t, ok)
+	again, err := reopenable.Reopen()
+	require.NoError(t, err)
+	assert.Equal(t, "photo bytes", readAll(t, data.Content))
+	assert.Equal(t, "photo bytes", readAll(t, again), "each reader starts at the beginning")
+
+	refs, err := db.BlobRefs(ctx, b.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tumblr-1"}, refs)
+}
+
+func TestIngest_Verification(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newStore(t)
+
+	verified := stream("photo bytes", map[string]interface{}{KeyChecksum: digestOf("photo bytes")}, nil)
+	_, err := store.Ingest(ctx, verified, IngestOptions{Verify: true})
+	assert.NoError(t, err)
+
+	corrupt := stream("photo bytez", map[string]interface{}{KeyChecksum: digestOf("photo bytes")}, nil)
+	_, err = store.Ingest(ctx, corrupt, IngestOptions{Verify: true})
+	assert.ErrorIs(t, err, interfaces.ErrStreamRejected)
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+	_, err = store.Stat(digestOf("photo bytez"))
+	assert.ErrorIs(t, err, ErrNotFound, "nothing is stored")
+
+	truncated := stream("photo", nil, map[string]string{"Content-Length": "11"})
+	_, err = store.Ingest(ctx, truncated, IngestOptions{Verify: true})
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+
+	unverifiable := stream("photo bytes", map[string]interface{}{KeyChecksum: "crc32:0a1b2c3d"}, nil)
+	_, err = store.Ingest(ctx, unverifiable, IngestOptions{Verify: true})
+	assert.ErrorIs(t, err, interfaces.ErrStreamRejected)
+	assert.ErrorIs(t, err, checksum.ErrUnknownAlgorithm, "unregistered algorithms cannot be checked")
+
+	unchecked := stream("transformed", map[string]interface{}{KeyChecksum: digestOf("photo bytes")}, nil)
+	_, err = store.Ingest(ctx, unchecked, IngestOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, digestOf("transformed"), unchecked.Metadata[KeyChecksum], "the checksum describes the stored content")
+	assert.Equal(t, []string{digestOf("transformed")}, unchecked.Metadata[KeyChecksums], "unverified checksums are not kept")
+
+	etag, ok := checksum.FromETag(`"5D41402ABC4B2A76B9719D911017C592"`)
+	require.True(t, ok)
+	fromETag := stream("hello", map[string]interface{}{KeyChecksum: etag}, nil)
+	_, err = store.Ingest(ctx, fromETag, IngestOptions{Verify: true, Algorithm: checksum.BLAKE3})
+	require.NoError(t, err)
+	blake3 := "blake3:ea8f163db38682925e4491c5e58d4bb3506ef8c14eb78a86e908c5624a67200f"
+	assert.Equal(t, blake3, fromETag.Metadata[KeyChecksum])
+	assert.Equal(t, []string{blake3, etag}, fromETag.Metadata[KeyChecksums], "the source checksum is kept for matching")
+}
+
+// closeTracker records whether the content was closed
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestIngest_RejectedContentIsClosed(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newStore(t)
+
+	for _, expected := range []string{"not a checksum", "crc32:0a1b2c3d"} {
+		content := &closeTracker{Reader: strings.NewReader("photo bytes")}
+		data := stream("", map[string]interface{}{KeyChecksum: expected}, nil)
+		data.Content = content
+		_, err := store.Ingest(ctx, data, IngestOptions{Verify: true})
+		assert.ErrorIs(t, err, interfaces.ErrStreamRejected, expected)
+		assert.True(t, content.closed, expected)
+	}
+}
+
+func TestIngest_AlreadyStored(t *testing.T) {
+	ctx := context.Background()
+	store, db := newStore(t)
+	data := stream("photo bytes", nil, nil)
+	first, err := store.Ingest(ctx, data, IngestOptions{})
+	require.NoError(t, err)
+	content := data.Content
+
+	data.ID = "tumblr-1/copy"
+	data.Metadata[KeyChecksums] = []string{"md5:5d41402abc4b2a76b9719d911017c592"}
+	second, err := store.Ingest(ctx, data, IngestOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Same(t, content, data.Content, "stored content is not copied again")
+	assert.Equal(t, []string{first.Digest}, data.Metadata[KeyChecksums], "stale checksums are replaced")
+
+	refs, err := db.BlobRefs(ctx, first.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tumblr-1", "tumblr-1/copy"}, refs)
+}
+
+func TestIngest_ReleasesSupersededContent(t *testing.T) {
+	ctx := context.Background()
+	store, db := newStore(t)
+	original, err := store.Ingest(ctx, stream("photo bytes", nil, nil), IngestOptions{})
+	require.NoError(t, err)
+	_, err = store.Put(ctx, "tumblr-2", strings.NewReader("photo bytes"))
+	require.NoError(t, err)
+	shared, err := store.Ingest(ctx, stream("shared bytes", nil, nil), IngestOptions{})
+	require.NoError(t, err)
+	_, err = store.Put(ctx, "tumblr-2", strings.NewReader("shared bytes"))
+	require.NoError(t, err)
+	draft, err := store.Ingest(ctx, stream("draft", nil, nil), IngestOptions{})
+	require.NoError(t, err)
+
+	transformed, err := store.Ingest(ctx, stream("transformed", nil, nil), IngestOptions{})
+	require.NoError(t, err)
+
+	digests, err := db.MediaBlobs(ctx, "tumblr-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{transformed.Digest}, digests, "only the current content is referenced")
+	refs, err := db.BlobRefs(ctx, shared.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tumblr-2"}, refs)
+	_, err = store.Stat(original.Digest)
+	assert.NoError(t, err, "blobs other items use are kept")
+	_, err = store.Stat(draft.Digest)
+	assert.ErrorIs(t, err, ErrNotFound, "unused blobs are deleted")
+}
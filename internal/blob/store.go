@@ -0,0 +1,252 @@
+// Package blob stores media content on disk under its SHA-256 digest.
+// Identical bytes downloaded by different services are kept once, and each
+// blob is reference-counted by the media items using it so that storage,
+// transforms and outputs can all share one copy of a file.
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/checksum"
+	"github.com/sho7650/media-sync/internal/storage"
+)
+
+// Algorithm is the hash naming blobs, and the prefix of their digests
+const Algorithm = checksum.SHA256
+
+// ErrNotFound is returned for digests without a stored blob
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidDigest is returned for digests not of the form sha256:<hex>
+var ErrInvalidDigest = errors.New("invalid blob digest")
+
+// Blob describes stored content
+type Blob struct {
+	// Digest is the checksum naming the blob, as sha256:<hex>
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	// Path is the file holding the content; it must not be modified
+	Path string `json:"path"`
+}
+
+// Store keeps blobs under root, sharded by the first two bytes of their
+// digest as root/sha256/ab/cd/abcd…, and records references in an index
+type Store struct {
+	root string
+	refs storage.BlobIndex
+
+	// mu orders writes and deletions, so a blob released by one item is
+	// never removed while another item is adding a reference to it
+	mu sync.Mutex
+}
+
+// NewStore creates a store rooted at root, creating the directory if needed
+func NewStore(root string, refs storage.BlobIndex) (*Store, error) {
+	if root == "" {
+		return nil, fmt.Errorf("blob store root cannot be empty")
+	}
+	if refs == nil {
+		return nil, fmt.Errorf("blob store requires a reference index")
+	}
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create blob store: %w", err)
+	}
+	return &Store{root: root, refs: refs}, nil
+}
+
+// ParseDigest returns the hex part of a sha256:<hex> digest
+func ParseDigest(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, Algorithm+":")
+	if !ok || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDigest, digest)
+	}
+	if _, err := hex.DecodeString(sum); err != nil || strings.ToLower(sum) != sum {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDigest, digest)
+	}
+	return sum, nil
+}
+
+// Path returns the file a blob is, or would be, stored in
+func (s *Store) Path(digest string) (string, error) {
+	sum, err := ParseDigest(digest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, Algorithm, sum[:2], sum[2:4], sum), nil
+}
+
+// Put stores the content of r and references it from mediaID. Content
+// already in the store is not written again; the spooled copy is discarded.
+func (s *Store) Put(ctx context.Context, mediaID string, r io.Reader) (Blob, error) {
+	if mediaID == "" {
+		return Blob{}, fmt.Errorf("media item ID cannot be empty")
+	}
+	tmp, hasher, err := s.spool(ctx, r)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer os.Remove(tmp)
+	return s.commit(ctx, tmp, hasher, mediaID)
+}
+
+// spool writes r to a temporary file in the store, hashing it with the
+// store's algorithm and any others given on the way
+func (s *Store) spool(ctx context.Context, r io.Reader, algorithms ...string) (string, *checksum.Hasher, error) {
+	hasher, err := checksum.NewHasher(append([]string{Algorithm}, algorithms...)...)
+	if err != nil {
+		return "", nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "blob-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+
+	_, err = io.Copy(io.MultiWriter(tmp, hasher), contextReader{ctx, r})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", nil, fmt.Errorf("failed to spool blob: %w", err)
+	}
+	return tmp.Name(), hasher, nil
+}
+
+// commit renames a spooled file into place, unless the store already holds
+// the same content, and references it from mediaID
+func (s *Store) commit(ctx context.Context, tmp string, hasher *checksum.Hasher, mediaID string) (Blob, error) {
+	digest, err := hasher.Sum(Algorithm)
+	if err != nil {
+		return Blob{}, err
+	}
+	path, err := s.Path(digest)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return Blob{}, fmt.Errorf("failed to create blob directory: %w", err)
+		}
+		if err := os.Chmod(tmp, 0o440); err != nil {
+			return Blob{}, fmt.Errorf("failed to protect blob: %w", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return Blob{}, fmt.Errorf("failed to store blob: %w", err)
+		}
+	} else if err != nil {
+		return Blob{}, fmt.Errorf("failed to stat blob: %w", err)
+	}
+	if err := s.refs.AddBlobRef(ctx, digest, mediaID); err != nil {
+		return Blob{}, err
+	}
+	return Blob{Digest: digest, Size: hasher.Size(), Path: path}, nil
+}
+
+// Ref adds a reference from mediaID to a stored blob
+func (s *Store) Ref(ctx context.Context, digest, mediaID string) (Blob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.Stat(digest)
+	if err != nil {
+		return Blob{}, err
+	}
+	if err := s.refs.AddBlobRef(ctx, digest, mediaID); err != nil {
+		return Blob{}, err
+	}
+	return b, nil
+}
+
+// Release drops the reference from mediaID to a blob and deletes the blob
+// once nothing refers to it. It reports whether the blob was deleted.
+func (s *Store) Release(ctx context.Context, digest, mediaID string) (bool, error) {
+	path, err := s.Path(digest)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	remaining, err := s.refs.RemoveBlobRef(ctx, digest, mediaID)
+	if err != nil || remaining > 0 {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return true, nil
+}
+
+// ReleaseMedia releases every blob referenced by mediaID
+func (s *Store) ReleaseMedia(ctx context.Context, mediaID string) error {
+	digests, err := s.refs.MediaBlobs(ctx, mediaID)
+	if err != nil {
+		return err
+	}
+	for _, digest := range digests {
+		if _, err := s.Release(ctx, digest, mediaID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat describes a stored blob
+func (s *Store) Stat(digest string) (Blob, error) {
+	path, err := s.Path(digest)
+	if err != nil {
+		return Blob{}, err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Blob{}, fmt.Errorf("%w: %s", ErrNotFound, digest)
+	}
+	if err != nil {
+		return Blob{}, fmt.Errorf("failed to stat blob: %w", err)
+	}
+	return Blob{Digest: digest, Size: info.Size(), Path: path}, nil
+}
+
+// Open returns a reader for the content of a stored blob
+func (s *Store) Open(digest string) (*os.File, error) {
+	path, err := s.Path(digest)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, digest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return f, nil
+}
+
+// contextReader stops copying once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,114 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/storage"
+)
+
+func newStore(t *testing.T) (*Store, *storage.SQLiteStorage) {
+	t.Helper()
+	db := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, db.Initialize(context.Background()))
+	t.Cleanup(func() { _ = db.Close() })
+
+	store, err := NewStore(filepath.Join(t.TempDir(), "blobs"), db)
+	require.NoError(t, err)
+	return store, db
+}
+
+func digestOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestStore_PutDeduplicates(t *testing.T) {
+	ctx := context.Background()
+	store, db := newStore(t)
+
+	first, err := store.Put(ctx, "tumblr-1", strings.NewReader("same bytes"))
+	require.NoError(t, err)
+	second, err := store.Put(ctx, "flickr-9", strings.NewReader("same bytes"))
+	require.NoError(t, err)
+
+	assert.Equal(t, digestOf("same bytes"), first.Digest)
+	assert.Equal(t, first, second)
+	assert.Equal(t, int64(10), first.Size)
+	sum := strings.TrimPrefix(first.Digest, "sha256:")
+	assert.Equal(t, filepath.Join(store.root, "sha256", sum[:2], sum[2:4], sum), first.Path)
+
+	refs, err := db.BlobRefs(ctx, first.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flickr-9", "tumblr-1"}, refs)
+
+	spooled, err := os.ReadDir(filepath.Join(store.root, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, spooled, "spool files are cleaned up")
+
+	f, err := store.Open(first.Digest)
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, "same bytes", string(b))
+}
+
+func TestStore_ReleaseDeletesUnreferenced(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newStore(t)
+
+	b, err := store.Put(ctx, "tumblr-1", strings.NewReader("shared"))
+	require.NoError(t, err)
+	_, err = store.Ref(ctx, b.Digest, "webdav-4")
+	require.NoError(t, err)
+
+	deleted, err := store.Release(ctx, b.Digest, "tumblr-1")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	_, err = store.Stat(b.Digest)
+	require.NoError(t, err, "still referenced by webdav-4")
+
+	require.NoError(t, store.ReleaseMedia(ctx, "webdav-4"))
+	_, err = store.Stat(b.Digest)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Open(b.Digest)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.Ref(ctx, b.Digest, "immich-5")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStore_InvalidDigest(t *testing.T) {
+	store, _ := newStore(t)
+	for _, digest := range []string{
+		"",
+		"md5:d41d8cd98f00b204e9800998ecf8427e",
+		"sha256:abc",
+		"sha256:" + strings.Repeat("G", 64),
+		"sha256:../../" + strings.Repeat("0", 58),
+		strings.ToUpper(digestOf("x")[7:]),
+	} {
+		_, err := store.Path(digest)
+		assert.ErrorIs(t, err, ErrInvalidDigest, digest)
+	}
+}
+
+func TestStore_PutCanceled(t *testing.T) {
+	store, _ := newStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := store.Put(ctx, "tumblr-1", strings.NewReader("never stored"))
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = store.Stat(digestOf("never stored"))
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,192 @@
+// Package checksum computes media checksums with pluggable hash algorithms.
+// Checksums are written as "algorithm:hex", such as "sha256:9f86d0…", so
+// values produced by different algorithms are never compared.
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"lukechampine.com/blake3"
+)
+
+// Registered algorithms
+const (
+	// SHA256 is the default algorithm
+	SHA256 = "sha256"
+	// BLAKE3 is a faster cryptographic alternative to SHA-256
+	BLAKE3 = "blake3"
+	// XXH64 is a fast non-cryptographic hash for change detection
+	XXH64 = "xxh64"
+	// MD5 is only used to match checksums published by sources, such as
+	// object store ETags
+	MD5 = "md5"
+)
+
+var (
+	// ErrUnknownAlgorithm is returned for algorithms that are not registered
+	ErrUnknownAlgorithm = errors.New("unknown checksum algorithm")
+	// ErrMalformed is returned for checksums not of the form algorithm:hex
+	ErrMalformed = errors.New("malformed checksum")
+	// ErrMismatch is returned when content does not match its checksum
+	ErrMismatch = errors.New("checksum mismatch")
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func() hash.Hash{
+		SHA256: sha256.New,
+		BLAKE3: func() hash.Hash { return blake3.New(32, nil) },
+		XXH64:  func() hash.Hash { return xxhash.New() },
+		MD5:    md5.New,
+	}
+)
+
+// Register adds a hash algorithm under name, replacing any existing one
+func Register(name string, newHash func() hash.Hash) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = newHash
+}
+
+// New returns a hash for the named algorithm
+func New(algorithm string) (hash.Hash, error) {
+	registryMu.RLock()
+	newHash, ok := registry[algorithm]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+	}
+	return newHash(), nil
+}
+
+// Algorithms lists the registered algorithms in name order
+func Algorithms() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Format writes a checksum as algorithm:hex
+func Format(algorithm string, sum []byte) string {
+	return algorithm + ":" + hex.EncodeToString(sum)
+}
+
+// Parse splits a checksum into its algorithm and lowercase hex digest
+func Parse(s string) (algorithm, digest string, err error) {
+	algorithm, digest, ok := strings.Cut(s, ":")
+	digest = strings.ToLower(digest)
+	if !ok || algorithm == "" || digest == "" {
+		return "", "", fmt.Errorf("%w: %q", ErrMalformed, s)
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", "", fmt.Errorf("%w: %q", ErrMalformed, s)
+	}
+	return algorithm, digest, nil
+}
+
+// Normalize returns a checksum as algorithm and lowercase hex. Bare
+// 64-digit hex values, as recorded before algorithms were, are SHA-256;
+// anything else that does not parse is returned unchanged.
+func Normalize(s string) string {
+	if algorithm, digest, err := Parse(s); err == nil {
+		return algorithm + ":" + digest
+	}
+	if len(s) == 2*sha256.Size {
+		if _, err := hex.DecodeString(s); err == nil {
+			return SHA256 + ":" + strings.ToLower(s)
+		}
+	}
+	return s
+}
+
+// FromETag returns the MD5 checksum an HTTP ETag carries, as object stores
+// publish for content uploaded in one part. Weak and multipart ETags, and
+// opaque ones, are not checksums of the content.
+func FromETag(etag string) (string, bool) {
+	if strings.HasPrefix(etag, "W/") {
+		return "", false
+	}
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != 2*md5.Size {
+		return "", false
+	}
+	sum, err := hex.DecodeString(etag)
+	if err != nil {
+		return "", false
+	}
+	return Format(MD5, sum), true
+}
+
+// Hasher computes checksums with several algorithms in one pass over the
+// content written to it
+type Hasher struct {
+	hashes map[string]hash.Hash
+	size   int64
+}
+
+// NewHasher creates a hasher for the named algorithms
+func NewHasher(algorithms ...string) (*Hasher, error) {
+	h := &Hasher{hashes: make(map[string]hash.Hash, len(algorithms))}
+	for _, algorithm := range algorithms {
+		if _, ok := h.hashes[algorithm]; ok {
+			continue
+		}
+		hash, err := New(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		h.hashes[algorithm] = hash
+	}
+	return h, nil
+}
+
+// Write hashes p with every algorithm
+func (h *Hasher) Write(p []byte) (int, error) {
+	for _, hash := range h.hashes {
+		hash.Write(p)
+	}
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+// Size returns the number of bytes written
+func (h *Hasher) Size() int64 { return h.size }
+
+// Sum returns the checksum of the content written so far, as algorithm:hex
+func (h *Hasher) Sum(algorithm string) (string, error) {
+	hash, ok := h.hashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("%w: %q is not computed by this hasher", ErrUnknownAlgorithm, algorithm)
+	}
+	return Format(algorithm, hash.Sum(nil)), nil
+}
+
+// Verify compares the content written so far with expected
+func (h *Hasher) Verify(expected string) error {
+	algorithm, digest, err := Parse(expected)
+	if err != nil {
+		return err
+	}
+	actual, err := h.Sum(algorithm)
+	if err != nil {
+		return err
+	}
+	if actual != algorithm+":"+digest {
+		return fmt.Errorf("%w: expected %s, got %s", ErrMismatch, expected, actual)
+	}
+	return nil
+}
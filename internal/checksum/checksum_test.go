@@ -0,0 +1,129 @@
+package checksum
+
+import (
+	"crypto/sha1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const helloSHA256 = "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestHasher(t *testing.T) {
+	h, err := NewHasher(SHA256, SHA256)
+	require.NoError(t, err)
+	_, _ = h.Write([]byte("hel"))
+	_, _ = h.Write([]byte("lo"))
+
+	sum, err := h.Sum(SHA256)
+	require.NoError(t, err)
+	assert.Equal(t, helloSHA256, sum)
+	assert.Equal(t, int64(5), h.Size())
+
+	assert.NoError(t, h.Verify("sha256:2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824"), "hex case is ignored")
+	assert.ErrorIs(t, h.Verify("sha256:00"), ErrMismatch)
+	assert.ErrorIs(t, h.Verify("2cf24dba"), ErrMalformed)
+	assert.ErrorIs(t, h.Verify("md5:5d41402abc4b2a76b9719d911017c592"), ErrUnknownAlgorithm, "md5 is not computed by this hasher")
+	assert.ErrorIs(t, h.Verify("sha512:00"), ErrUnknownAlgorithm)
+}
+
+func TestRegister(t *testing.T) {
+	_, err := NewHasher("test-sha1")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+
+	Register("test-sha1", sha1.New)
+	assert.Contains(t, Algorithms(), "test-sha1")
+	h, err := NewHasher("test-sha1")
+	require.NoError(t, err)
+	_, _ = h.Write([]byte("hello"))
+	assert.NoError(t, h.Verify("test-sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"))
+}
+
+func TestParse(t *testing.T) {
+	algorithm, digest, err := Parse("sha256:ABCDEF")
+	require.NoError(t, err)
+	assert.Equal(t, "sha256", algorithm)
+	assert.Equal(t, "abcdef", digest)
+
+	for _, s := range []string{"", "abcdef", ":abcdef", "sha256:", "sha256:xyz"} {
+		_, _, err := Parse(s)
+		assert.ErrorIs(t, err, ErrMalformed, s)
+	}
+}
+
+// counting returns the input of the official BLAKE3 test vectors
+func counting(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestAlgorithms(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		input     []byte
+		want      string
+	}{
+		{BLAKE3, nil, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+		{BLAKE3, []byte("abc"), "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+		{BLAKE3, counting(1023), "10108970eeda3eb932baac1428c7a2163b0e924c9a9e25b35bba72b28f70bd11"},
+		{BLAKE3, counting(1024), "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+		{BLAKE3, counting(1025), "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+		{BLAKE3, counting(3072), "b98cb0ff3623be03326b373de6b9095218513e64f1ee2edd2525c7ad1e5cffd2"},
+		{XXH64, nil, "ef46db3751d8e999"},
+		{XXH64, []byte("abc"), "44bc2cf5ad770999"},
+		{XXH64, []byte("Nobody inspects the spammish repetition"), "fbcea83c8a378bf1"},
+		{MD5, []byte("hello"), "5d41402abc4b2a76b9719d911017c592"},
+	}
+	for _, tt := range tests {
+		// Odd write sizes cross block, stripe and chunk boundaries
+		for _, step := range []int{1, 7, 64, 1 << 20} {
+			h, err := NewHasher(tt.algorithm)
+			require.NoError(t, err)
+			for p := tt.input; len(p) > 0; {
+				n := min(step, len(p))
+				_, _ = h.Write(p[:n])
+				p = p[n:]
+			}
+			sum, err := h.Sum(tt.algorithm)
+			require.NoError(t, err)
+			assert.Equal(t, tt.algorithm+":"+tt.want, sum, "%s of %d bytes in writes of %d", tt.algorithm, len(tt.input), step)
+		}
+	}
+
+	h, err := New(BLAKE3)
+	require.NoError(t, err)
+	_, _ = h.Write(counting(5000))
+	first := h.Sum(nil)
+	assert.Equal(t, first, h.Sum(nil), "Sum does not change the state")
+	h.Reset()
+	_, _ = h.Write(counting(5000))
+	assert.Equal(t, first, h.Sum(nil))
+}
+
+func TestNormalize(t *testing.T) {
+	legacy := "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824"
+	assert.Equal(t, helloSHA256, Normalize(legacy))
+	assert.Equal(t, helloSHA256, Normalize("sha256:"+legacy))
+	assert.Equal(t, "xxh64:44bc2cf5ad770999", Normalize("xxh64:44BC2CF5AD770999"))
+	assert.Equal(t, "opaque-id", Normalize("opaque-id"))
+}
+
+func TestFromETag(t *testing.T) {
+	sum, ok := FromETag(`"5D41402ABC4B2A76B9719D911017C592"`)
+	assert.True(t, ok)
+	assert.Equal(t, "md5:5d41402abc4b2a76b9719d911017c592", sum)
+
+	for _, etag := range []string{
+		`W/"5d41402abc4b2a76b9719d911017c592"`,
+		`"5d41402abc4b2a76b9719d911017c592-3"`,
+		`"v1"`,
+		"",
+	} {
+		_, ok := FromETag(etag)
+		assert.False(t, ok, etag)
+	}
+}
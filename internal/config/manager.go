@@ -11,11 +11,16 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Validator checks a configuration beyond its own fields, such as by
+// building the pipelines it describes
+type Validator func(ctx context.Context, config *Config) error
+
 // ConfigManager handles configuration loading, validation, and hot reload
 type ConfigManager struct {
 	currentConfig *Config
 	mutex         sync.RWMutex
 	watchers      map[string]chan ConfigChangeEvent
+	validators    []Validator
 }
 
 // NewConfigManager creates a new configuration manager
@@ -53,6 +58,14 @@ func (cm *ConfigManager) LoadFromFile(ctx context.Context, filePath string) (*Co
 	return &config, nil
 }
 
+// AddValidator adds a check that ValidateConfig runs after its own, so on
+// every load and reload
+func (cm *ConfigManager) AddValidator(v Validator) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.validators = append(cm.validators, v)
+}
+
 // ValidateConfig validates the entire configuration
 func (cm *ConfigManager) ValidateConfig(ctx context.Context, config *Config) error {
 	// Validate global configuration
@@ -67,6 +80,22 @@ func (cm *ConfigManager) ValidateConfig(ctx context.Context, config *Config) err
 		}
 	}
 
+	// Validate each pipeline against the services it connects
+	for name, pipeline := range config.Pipelines {
+		if err := pipeline.Validate(config.Services); err != nil {
+			return fmt.Errorf("pipeline '%s' validation failed: %w", name, err)
+		}
+	}
+
+	cm.mutex.RLock()
+	validators := cm.validators
+	cm.mutex.RUnlock()
+	for _, v := range validators {
+		if err := v(ctx, config); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
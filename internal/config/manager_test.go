@@ -179,7 +179,7 @@ func TestConfigManager_Validation(t *testing.T) {
 					Type:   "invalid",
 					Plugin: "tumblr",
 				},
-				wantErr: "type must be 'input' or 'output'",
+				wantErr: "type must be 'input', 'output' or 'transform'",
 			},
 			{
 				name: "empty plugin",
@@ -214,6 +214,50 @@ func TestConfigManager_Validation(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("Pipelines must reference services of the right type", func(t *testing.T) {
+		services := map[string]ServiceConfig{
+			"tumblr":  {Name: "tumblr", Type: "input", Plugin: "tumblr"},
+			"mapping": {Name: "mapping", Type: "transform", Plugin: "mapping"},
+			"webdav":  {Name: "webdav", Type: "output", Plugin: "webdav"},
+		}
+		tests := []struct {
+			name     string
+			pipeline PipelineConfig
+			wantErr  string
+		}{
+			{"valid", PipelineConfig{Input: "tumblr", Transforms: []string{"mapping"}, Outputs: []string{"webdav"}}, ""},
+			{"missing input", PipelineConfig{Outputs: []string{"webdav"}}, "input cannot be empty"},
+			{"missing outputs", PipelineConfig{Input: "tumblr"}, "at least one output"},
+			{"unknown transform", PipelineConfig{Input: "tumblr", Transforms: []string{"resize"}, Outputs: []string{"webdav"}}, "unknown service 'resize'"},
+			{"wrong type", PipelineConfig{Input: "tumblr", Outputs: []string{"mapping"}}, "service 'mapping' is transform, not output"},
+			{"routed", PipelineConfig{Input: "tumblr", Outputs: []string{"webdav"}, Routes: map[string][]string{"archive": {"webdav"}}}, ""},
+			{"empty route", PipelineConfig{Input: "tumblr", Outputs: []string{"webdav"}, Routes: map[string][]string{"archive": nil}}, "route 'archive' needs at least one output"},
+			{"route outside pipeline", PipelineConfig{Input: "tumblr", Outputs: []string{"webdav"}, Routes: map[string][]string{"archive": {"mapping"}}}, "not an output of the pipeline"},
+		}
+
+		manager := NewConfigManager()
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				config := &Config{
+					Services:  services,
+					Pipelines: map[string]PipelineConfig{"main": tt.pipeline},
+					Global: GlobalConfig{
+						Database: DatabaseConfig{Path: "./test.db"},
+						Workers:  3,
+					},
+				}
+
+				err := manager.ValidateConfig(ctx, config)
+				if tt.wantErr == "" {
+					assert.NoError(t, err)
+					return
+				}
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			})
+		}
+	})
 }
 
 func TestConfigManager_HotReload(t *testing.T) {
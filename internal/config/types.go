@@ -2,13 +2,15 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"time"
 )
 
 // Config represents the complete application configuration
 type Config struct {
-	Services map[string]ServiceConfig `yaml:"services"`
-	Global   GlobalConfig             `yaml:"global"`
+	Services  map[string]ServiceConfig  `yaml:"services"`
+	Pipelines map[string]PipelineConfig `yaml:"pipelines"`
+	Global    GlobalConfig              `yaml:"global"`
 }
 
 // ServiceConfig represents configuration for a single service
@@ -20,11 +22,26 @@ type ServiceConfig struct {
 	Settings map[string]interface{} `yaml:"settings"`
 }
 
+// PipelineConfig connects an input service through transforms to outputs,
+// each named by its key in Config.Services
+type PipelineConfig struct {
+	Input      string   `yaml:"input"`
+	Transforms []string `yaml:"transforms"`
+	Outputs    []string `yaml:"outputs"`
+	// Routes names groups of outputs. A stream whose "route" metadata, set
+	// by the filter transform, names a route is published only to its
+	// outputs; other streams go to every output.
+	Routes map[string][]string `yaml:"routes"`
+	// Strict validates each item's metadata against the declared schemas
+	Strict bool `yaml:"strict"`
+}
+
 // GlobalConfig represents global application settings
 type GlobalConfig struct {
 	Database DatabaseConfig `yaml:"database"`
 	Workers  int            `yaml:"workers"`
 	Timeout  string         `yaml:"timeout"`
+	HTTP     HTTPConfig     `yaml:"http"`
 }
 
 // DatabaseConfig represents database configuration
@@ -32,6 +49,14 @@ type DatabaseConfig struct {
 	Path string `yaml:"path"`
 }
 
+// HTTPConfig represents the defaults of the HTTP clients handed to plugins
+type HTTPConfig struct {
+	UserAgent string `yaml:"user_agent"`
+	// Proxy is an http, https, socks5 or socks5h URL, or "direct"
+	Proxy    string `yaml:"proxy"`
+	CacheDir string `yaml:"cache_dir"`
+}
+
 // ConfigChangeEvent represents a configuration change event
 type ConfigChangeEvent struct {
 	Type  string
@@ -45,8 +70,8 @@ func (s *ServiceConfig) Validate() error {
 		return fmt.Errorf("service name cannot be empty")
 	}
 
-	if s.Type != "input" && s.Type != "output" {
-		return fmt.Errorf("service type must be 'input' or 'output', got: %s", s.Type)
+	if s.Type != "input" && s.Type != "output" && s.Type != "transform" {
+		return fmt.Errorf("service type must be 'input', 'output' or 'transform', got: %s", s.Type)
 	}
 
 	if s.Plugin == "" {
@@ -72,5 +97,71 @@ func (g *GlobalConfig) Validate() error {
 		}
 	}
 
+	if p := g.HTTP.Proxy; p != "" && p != "direct" {
+		u, err := url.Parse(p)
+		if err != nil {
+			return fmt.Errorf("invalid http proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("http proxy scheme must be http, https, socks5 or socks5h, got: %s", u.Scheme)
+		}
+	}
+
 	return nil
 }
+
+// Validate checks that the pipeline refers to services of the right types
+func (p *PipelineConfig) Validate(services map[string]ServiceConfig) error {
+	if p.Input == "" {
+		return fmt.Errorf("pipeline input cannot be empty")
+	}
+	if len(p.Outputs) == 0 {
+		return fmt.Errorf("pipeline needs at least one output")
+	}
+
+	check := func(name, wantType string) error {
+		service, ok := services[name]
+		if !ok {
+			return fmt.Errorf("unknown service '%s'", name)
+		}
+		if service.Type != wantType {
+			return fmt.Errorf("service '%s' is %s, not %s", name, service.Type, wantType)
+		}
+		return nil
+	}
+	if err := check(p.Input, "input"); err != nil {
+		return err
+	}
+	for _, name := range p.Transforms {
+		if err := check(name, "transform"); err != nil {
+			return err
+		}
+	}
+	for _, name := range p.Outputs {
+		if err := check(name, "output"); err != nil {
+			return err
+		}
+	}
+	for route, outputs := range p.Routes {
+		if len(outputs) == 0 {
+			return fmt.Errorf("route '%s' needs at least one output", route)
+		}
+		for _, name := range outputs {
+			if !contains(p.Outputs, name) {
+				return fmt.Errorf("route '%s' output '%s' is not an output of the pipeline", route, name)
+			}
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
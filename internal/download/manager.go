@@ -0,0 +1,606 @@
+// Package download fetches large media files for input plugins. Files are
+// downloaded as parallel byte ranges into a partial file whose completed
+// chunks are recorded beside it, so an interrupted download resumes where
+// it stopped, even after a restart. Bandwidth is capped across all
+// downloads and requests are limited per host.
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/checksum"
+	"github.com/sho7650/media-sync/internal/ratelimit"
+)
+
+// ErrChanged is returned when the remote file changes between requests; the
+// partial download is discarded so the next attempt starts afresh
+var ErrChanged = errors.New("remote file changed during download")
+
+const (
+	defaultChunkSize = 8 << 20
+	defaultParallel  = 4
+	defaultPerHost   = 4
+	defaultRetries   = 3
+	defaultBackoff   = 500 * time.Millisecond
+	reportInterval   = time.Second
+)
+
+// Config configures a Manager
+type Config struct {
+	// Dir holds partial and completed downloads
+	Dir    string
+	Client *http.Client
+	// ChunkSize is the size of each byte range
+	ChunkSize int64
+	// Parallel is the number of ranges fetched at once for one download
+	Parallel int
+	// PerHost caps the requests in flight to one host across downloads
+	PerHost int
+	// BytesPerSecond caps the total bandwidth; zero means unlimited
+	BytesPerSecond int64
+	// Retries is the number of times a failed range is retried; negative
+	// disables retries
+	Retries int
+	// Report publishes progress, typically a plugin's SetHealthDetail
+	Report func(key string, value interface{})
+}
+
+// Request describes one file to download
+type Request struct {
+	URL string
+	// Key identifies the download across restarts, the URL by default;
+	// sources with expiring signed URLs should use a stable item ID
+	Key    string
+	Header http.Header
+	// Size is the expected size in bytes, zero when unknown
+	Size int64
+	// Checksum is the expected checksum as algorithm:hex, if known;
+	// checksums of unregistered algorithms cannot be verified and fail the
+	// download with checksum.ErrUnknownAlgorithm
+	Checksum string
+	// Algorithm computes Result.Checksum, SHA-256 by default
+	Algorithm string
+}
+
+// Result describes a completed download
+type Result struct {
+	// Path is the downloaded file; the caller removes it once consumed
+	Path     string
+	Size     int64
+	Checksum string
+	// Resumed is the number of bytes reused from an earlier attempt
+	Resumed int64
+}
+
+// Progress describes a download in flight
+type Progress struct {
+	URL string `json:"url"`
+	// Size is -1 while unknown
+	Size     int64 `json:"size"`
+	Received int64 `json:"received"`
+}
+
+// Manager runs downloads
+type Manager struct {
+	cfg     Config
+	limiter *ratelimit.Limiter
+	backoff time.Duration
+
+	mu         sync.Mutex
+	hosts      map[string]chan struct{}
+	active     map[string]*Progress
+	total      int64
+	lastReport time.Time
+}
+
+// NewManager creates a download manager, creating cfg.Dir if needed
+func NewManager(cfg Config) (*Manager, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("download directory cannot be empty")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = defaultChunkSize
+	}
+	if cfg.Parallel <= 0 {
+		cfg.Parallel = defaultParallel
+	}
+	if cfg.PerHost <= 0 {
+		cfg.PerHost = defaultPerHost
+	}
+	switch {
+	case cfg.Retries == 0:
+		cfg.Retries = defaultRetries
+	case cfg.Retries < 0:
+		cfg.Retries = 0
+	}
+	return &Manager{
+		cfg:     cfg,
+		limiter: ratelimit.New(cfg.BytesPerSecond),
+		backoff: defaultBackoff,
+		hosts:   make(map[string]chan struct{}),
+		active:  make(map[string]*Progress),
+	}, nil
+}
+
+// Progress returns the downloads in flight, ordered by URL
+func (m *Manager) Progress() []Progress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshot()
+}
+
+func (m *Manager) snapshot() []Progress {
+	progress := make([]Progress, 0, len(m.active))
+	for _, p := range m.active {
+		progress = append(progress, *p)
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].URL < progress[j].URL })
+	return progress
+}
+
+// report publishes progress, at most once per interval unless forced
+func (m *Manager) report(force bool) {
+	if m.cfg.Report == nil {
+		return
+	}
+	m.mu.Lock()
+	if !force && time.Since(m.lastReport) < reportInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastReport = time.Now()
+	downloads, total := m.snapshot(), m.total
+	m.mu.Unlock()
+
+	m.cfg.Report("downloads", downloads)
+	m.cfg.Report("downloads_active", len(downloads))
+	m.cfg.Report("download_bytes", total)
+}
+
+// received counts n bytes transferred for the download named name
+func (m *Manager) received(name string, n int) {
+	m.mu.Lock()
+	if p, ok := m.active[name]; ok {
+		p.Received += int64(n)
+	}
+	m.total += int64(n)
+	m.mu.Unlock()
+	m.report(false)
+}
+
+// progress replaces the size and received bytes of a download
+func (m *Manager) progress(name string, size, received int64) {
+	m.mu.Lock()
+	if p, ok := m.active[name]; ok {
+		p.Size, p.Received = size, received
+	}
+	m.mu.Unlock()
+	m.report(true)
+}
+
+// host acquires a request slot for the host of u
+func (m *Manager) host(ctx context.Context, u *url.URL) (func(), error) {
+	m.mu.Lock()
+	slots, ok := m.hosts[u.Host]
+	if !ok {
+		slots = make(chan struct{}, m.cfg.PerHost)
+		m.hosts[u.Host] = slots
+	}
+	m.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// download is one Download call in progress
+type download struct {
+	m     *Manager
+	req   Request
+	url   *url.URL
+	name  string
+	part  string
+	state string
+	// size is the length the server reported, or -1 when unknown
+	size int64
+}
+
+// Download fetches req.URL into the download directory, resuming an earlier
+// partial download of the same key when the remote file is unchanged. The
+// file is verified against req.Size and req.Checksum; on a mismatch it is
+// deleted and the error wraps checksum.ErrMismatch. The whole file is
+// checked, including chunks reused from an earlier attempt, and its size
+// against the length the server reported.
+func (m *Manager) Download(ctx context.Context, req Request) (Result, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Result{}, fmt.Errorf("invalid download URL %q", req.URL)
+	}
+	if req.Algorithm == "" {
+		req.Algorithm = checksum.SHA256
+	}
+	algorithms := []string{req.Algorithm}
+	if req.Checksum != "" {
+		algorithm, _, err := checksum.Parse(req.Checksum)
+		if err != nil {
+			return Result{}, err
+		}
+		if _, err := checksum.New(algorithm); err != nil {
+			return Result{}, err
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	hasher, err := checksum.NewHasher(algorithms...)
+	if err != nil {
+		return Result{}, err
+	}
+
+	key := req.Key
+	if key == "" {
+		key = req.URL
+	}
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:16])
+	d := &download{
+		m: m, req: req, url: u, name: name,
+		part:  filepath.Join(m.cfg.Dir, name+".part"),
+		state: filepath.Join(m.cfg.Dir, name+".json"),
+		size:  -1,
+	}
+
+	m.mu.Lock()
+	if _, busy := m.active[name]; busy {
+		m.mu.Unlock()
+		return Result{}, fmt.Errorf("download of %s is already in progress", key)
+	}
+	m.active[name] = &Progress{URL: req.URL, Size: -1}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.active, name)
+		m.mu.Unlock()
+		m.report(true)
+	}()
+
+	resumed, err := d.fetch(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	return d.finish(hasher, resumed)
+}
+
+// fetch downloads the file into the partial file and returns the bytes
+// reused from an earlier attempt
+func (d *download) fetch(ctx context.Context) (int64, error) {
+	resp, release, err := d.get(ctx, "bytes=0-0", "")
+	if err != nil {
+		return 0, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		size, err := totalSize(resp.Header.Get("Content-Range"))
+		drain(resp.Body)
+		release()
+		if err != nil {
+			// Without a total there is nothing to split into ranges
+			return 0, d.whole(ctx)
+		}
+		if d.req.Size > 0 && size != d.req.Size {
+			return 0, fmt.Errorf("%w: expected %d bytes, server has %d", checksum.ErrMismatch, d.req.Size, size)
+		}
+		return d.ranges(ctx, size, validator(resp.Header))
+	case http.StatusOK:
+		defer release()
+		return 0, d.write(ctx, resp)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Empty files have no first byte to request
+		drain(resp.Body)
+		release()
+		return 0, d.whole(ctx)
+	default:
+		drain(resp.Body)
+		release()
+		return 0, fmt.Errorf("download %s: unexpected status %s", d.req.URL, resp.Status)
+	}
+}
+
+// whole downloads the file in a single request
+func (d *download) whole(ctx context.Context) error {
+	resp, release, err := d.get(ctx, "", "")
+	if err != nil {
+		return err
+	}
+	defer release()
+	if resp.StatusCode != http.StatusOK {
+		drain(resp.Body)
+		return fmt.Errorf("download %s: unexpected status %s", d.req.URL, resp.Status)
+	}
+	return d.write(ctx, resp)
+}
+
+// write streams a full response into a fresh partial file
+func (d *download) write(ctx context.Context, resp *http.Response) error {
+	defer resp.Body.Close()
+	_ = os.Remove(d.state)
+	d.size = resp.ContentLength
+	d.m.progress(d.name, resp.ContentLength, 0)
+
+	f, err := os.OpenFile(d.part, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create partial file: %w", err)
+	}
+	_, err = io.Copy(f, d.paced(ctx, resp.Body))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("download %s: %w", d.req.URL, err)
+	}
+	return nil
+}
+
+// ranges downloads the missing chunks of a file of the given size in
+// parallel, recording each completed chunk
+func (d *download) ranges(ctx context.Context, size int64, validator string) (int64, error) {
+	d.size = size
+	chunkSize := d.m.cfg.ChunkSize
+	st, err := loadState(d.state)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read download state: %w", err)
+	}
+	if info, statErr := os.Stat(d.part); statErr != nil || info.Size() != size || !st.matches(size, validator, chunkSize) {
+		st = &state{URL: d.req.URL, Size: size, Validator: validator, ChunkSize: chunkSize, Done: make([]bool, chunks(size, chunkSize))}
+	}
+	resumed := st.completed()
+
+	f, err := os.OpenFile(d.part, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return 0, fmt.Errorf("failed to allocate partial file: %w", err)
+	}
+	if err := st.save(d.state); err != nil {
+		return 0, fmt.Errorf("failed to write download state: %w", err)
+	}
+	d.m.progress(d.name, size, resumed)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pending := make(chan int)
+	go func() {
+		defer close(pending)
+		for i, done := range st.Done {
+			if done {
+				continue
+			}
+			select {
+			case pending <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < d.m.cfg.Parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				start, end := chunkRange(i, size, chunkSize)
+				err := d.chunk(ctx, f, start, end, validator)
+				if err == nil {
+					// A chunk is only recorded once its bytes are on disk,
+					// so a crash cannot leave it marked done but unwritten
+					err = f.Sync()
+				}
+				mu.Lock()
+				if err == nil {
+					st.Done[i] = true
+					err = st.save(d.state)
+				}
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if errors.Is(firstErr, ErrChanged) {
+		_ = os.Remove(d.state)
+		_ = os.Remove(d.part)
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return resumed, ctx.Err()
+}
+
+// chunk downloads one byte range, retrying transient failures
+func (d *download) chunk(ctx context.Context, f *os.File, start, end int64, validator string) error {
+	var err error
+	for attempt := 0; attempt <= d.m.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(d.m.backoff << (attempt - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		var written int64
+		written, err = d.fetchRange(ctx, f, start, end, validator)
+		if err == nil || errors.Is(err, ErrChanged) || ctx.Err() != nil {
+			return err
+		}
+		// Bytes of a failed attempt are fetched again
+		d.m.mu.Lock()
+		if p, ok := d.m.active[d.name]; ok {
+			p.Received -= written
+		}
+		d.m.mu.Unlock()
+	}
+	return fmt.Errorf("download %s: bytes %d-%d failed after %d attempts: %w", d.req.URL, start, end, d.m.cfg.Retries+1, err)
+}
+
+func (d *download) fetchRange(ctx context.Context, f *os.File, start, end int64, validator string) (int64, error) {
+	resp, release, err := d.get(ctx, fmt.Sprintf("bytes=%d-%d", start, end), validator)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// If-Range answers with the whole file once the validator changes
+		return 0, fmt.Errorf("%w: %s", ErrChanged, d.req.URL)
+	default:
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if got := resp.Header.Get("Content-Range"); !strings.HasPrefix(got, fmt.Sprintf("bytes %d-%d/", start, end)) {
+		return 0, fmt.Errorf("unexpected Content-Range %q for bytes %d-%d", got, start, end)
+	}
+
+	length := end - start + 1
+	n, err := io.Copy(io.NewOffsetWriter(f, start), d.paced(ctx, io.LimitReader(resp.Body, length)))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// get issues a GET request, holding a slot for the host until release
+func (d *download) get(ctx context.Context, byteRange, ifRange string) (*http.Response, func(), error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.req.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range d.req.Header {
+		req.Header[name] = values
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+		// Compressed transfers would break byte offsets
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	if ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
+
+	release, err := d.m.host(ctx, d.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := d.m.cfg.Client.Do(req)
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("download %s: %w", d.req.URL, err)
+	}
+	return resp, release, nil
+}
+
+func (d *download) paced(ctx context.Context, r io.Reader) io.Reader {
+	return d.m.limiter.Reader(ctx, r, func(n int) { d.m.received(d.name, n) })
+}
+
+// finish verifies the partial file and moves it into place
+func (d *download) finish(hasher *checksum.Hasher, resumed int64) (Result, error) {
+	discard := func(err error) (Result, error) {
+		_ = os.Remove(d.part)
+		_ = os.Remove(d.state)
+		return Result{}, fmt.Errorf("download %s: %w", d.req.URL, err)
+	}
+
+	f, err := os.Open(d.part)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to open partial file: %w", err)
+	}
+	_, err = io.Copy(hasher, f)
+	f.Close()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to hash partial file: %w", err)
+	}
+	size := d.size
+	if d.req.Size > 0 {
+		size = d.req.Size
+	}
+	if size >= 0 && hasher.Size() != size {
+		return discard(fmt.Errorf("%w: expected %d bytes, got %d", checksum.ErrMismatch, size, hasher.Size()))
+	}
+	if d.req.Checksum != "" {
+		if err := hasher.Verify(d.req.Checksum); err != nil {
+			return discard(err)
+		}
+	}
+	sum, err := hasher.Sum(d.req.Algorithm)
+	if err != nil {
+		return Result{}, err
+	}
+
+	path := filepath.Join(d.m.cfg.Dir, d.name)
+	if err := os.Rename(d.part, path); err != nil {
+		return Result{}, fmt.Errorf("failed to move download into place: %w", err)
+	}
+	_ = os.Remove(d.state)
+	return Result{Path: path, Size: hasher.Size(), Checksum: sum, Resumed: resumed}, nil
+}
+
+// totalSize reads the complete length from a Content-Range header
+func totalSize(contentRange string) (int64, error) {
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok || total == "*" {
+		return 0, fmt.Errorf("no total length in Content-Range %q", contentRange)
+	}
+	return strconv.ParseInt(total, 10, 64)
+}
+
+// validator returns the strong ETag, or else Last-Modified, for If-Range
+func validator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+func drain(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	_ = body.Close()
+}
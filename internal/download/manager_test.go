@@ -0,0 +1,249 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/checksum"
+)
+
+// server serves a file with Range and If-Range support, counting range
+// requests and the requests in flight
+type server struct {
+	mu       sync.Mutex
+	content  []byte
+	etag     string
+	ranges   []string
+	fail     func(r *http.Request) bool
+	noRanges bool
+
+	inFlight, maxInFlight int32
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := atomic.AddInt32(&s.inFlight, 1)
+	defer atomic.AddInt32(&s.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&s.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&s.maxInFlight, max, n) {
+			break
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	s.mu.Lock()
+	content, etag, fail := s.content, s.etag, s.fail
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.mu.Unlock()
+
+	if fail != nil && fail(r) {
+		http.Error(w, "flaky", http.StatusBadGateway)
+		return
+	}
+	if s.noRanges {
+		_, _ = w.Write(content)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(content))
+}
+
+func (s *server) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func payload(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i*7 + i/251)
+	}
+	return b
+}
+
+func sha(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func newManager(t *testing.T, dir string, cfg Config) *Manager {
+	t.Helper()
+	cfg.Dir = dir
+	m, err := NewManager(cfg)
+	require.NoError(t, err)
+	m.backoff = time.Millisecond
+	return m
+}
+
+func TestDownload_ParallelRanges(t *testing.T) {
+	content := payload(100_000)
+	srv := &server{content: content, etag: `"v1"`}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	var (
+		mu      sync.Mutex
+		details = map[string]interface{}{}
+	)
+	m := newManager(t, t.TempDir(), Config{
+		ChunkSize: 16 << 10, Parallel: 8, PerHost: 2,
+		Report: func(key string, value interface{}) {
+			mu.Lock()
+			details[key] = value
+			mu.Unlock()
+		},
+	})
+
+	res, err := m.Download(context.Background(), Request{URL: ts.URL + "/video.mp4", Size: 100_000, Checksum: sha(content)})
+	require.NoError(t, err)
+	b, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.Equal(t, sha(content), res.Checksum)
+	assert.Equal(t, int64(100_000), res.Size)
+
+	assert.Len(t, srv.requested(), 8, "a probe and seven 16 KiB ranges")
+	assert.LessOrEqual(t, atomic.LoadInt32(&srv.maxInFlight), int32(2), "per-host limit")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 0, details["downloads_active"])
+	assert.Equal(t, int64(100_000), details["download_bytes"])
+	assert.Empty(t, m.Progress())
+}
+
+func TestDownload_ResumesAfterRestart(t *testing.T) {
+	content := payload(64 << 10)
+	srv := &server{content: content, etag: `"v1"`}
+	srv.fail = func(r *http.Request) bool { return strings.HasPrefix(r.Header.Get("Range"), "bytes=49152-") }
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	dir := t.TempDir()
+	req := Request{URL: ts.URL + "/video.mp4?sig=1", Key: "tumblr-1"}
+
+	first := newManager(t, dir, Config{ChunkSize: 16 << 10, Parallel: 1, Retries: -1})
+	_, err := first.Download(context.Background(), req)
+	require.Error(t, err)
+
+	srv.mu.Lock()
+	srv.fail, srv.ranges = nil, nil
+	srv.mu.Unlock()
+	// A restarted process resumes under the same key despite a new signature
+	req.URL = ts.URL + "/video.mp4?sig=2"
+	second := newManager(t, dir, Config{ChunkSize: 16 << 10, Parallel: 1})
+	res, err := second.Download(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(48<<10), res.Resumed)
+	assert.Equal(t, []string{"bytes=0-0", "bytes=49152-65535"}, srv.requested())
+	b, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+}
+
+func TestDownload_VerifiesResumedChunks(t *testing.T) {
+	content := payload(64 << 10)
+	srv := &server{content: content, etag: `"v1"`}
+	srv.fail = func(r *http.Request) bool { return strings.HasPrefix(r.Header.Get("Range"), "bytes=49152-") }
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	dir := t.TempDir()
+	req := Request{URL: ts.URL + "/video.mp4", Key: "tumblr-1", Checksum: sha(content)}
+
+	m := newManager(t, dir, Config{ChunkSize: 16 << 10, Parallel: 1, Retries: -1})
+	_, err := m.Download(context.Background(), req)
+	require.Error(t, err)
+	parts, err := filepath.Glob(filepath.Join(dir, "*.part"))
+	require.NoError(t, err)
+	require.Len(t, parts, 1)
+	f, err := os.OpenFile(parts[0], os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{^content[0]}, 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	srv.mu.Lock()
+	srv.fail = nil
+	srv.mu.Unlock()
+	_, err = m.Download(context.Background(), req)
+	assert.ErrorIs(t, err, checksum.ErrMismatch, "a damaged chunk from the earlier attempt is caught")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDownload_RemoteChanged(t *testing.T) {
+	srv := &server{content: payload(64 << 10), etag: `"v1"`}
+	srv.fail = func(r *http.Request) bool { return r.Header.Get("Range") == "bytes=49152-65535" }
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	m := newManager(t, t.TempDir(), Config{ChunkSize: 16 << 10, Parallel: 1, Retries: -1})
+	req := Request{URL: ts.URL + "/video.mp4"}
+
+	_, err := m.Download(context.Background(), req)
+	require.Error(t, err)
+
+	updated := bytes.Repeat([]byte("new"), 30_000)
+	srv.mu.Lock()
+	srv.content, srv.etag, srv.fail = updated, `"v2"`, nil
+	srv.mu.Unlock()
+
+	res, err := m.Download(context.Background(), req)
+	require.NoError(t, err)
+	assert.Zero(t, res.Resumed, "chunks of the old version are discarded")
+	b, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, updated, b)
+}
+
+func TestDownload_Verification(t *testing.T) {
+	content := payload(10_000)
+	ts := httptest.NewServer(&server{content: content, etag: `"v1"`})
+	defer ts.Close()
+	dir := t.TempDir()
+	m := newManager(t, dir, Config{ChunkSize: 4096})
+
+	_, err := m.Download(context.Background(), Request{URL: ts.URL + "/a", Checksum: sha([]byte("other"))})
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "corrupt downloads are deleted")
+
+	_, err = m.Download(context.Background(), Request{URL: ts.URL + "/a", Size: 9_999})
+	assert.ErrorIs(t, err, checksum.ErrMismatch)
+
+	_, err = m.Download(context.Background(), Request{URL: ts.URL + "/a", Checksum: "crc32:0a1b2c3d"})
+	assert.ErrorIs(t, err, checksum.ErrUnknownAlgorithm, "unverifiable checksums are not skipped")
+
+	_, err = m.Download(context.Background(), Request{URL: "ftp://example.com/a"})
+	assert.Error(t, err)
+}
+
+func TestDownload_WithoutRangeSupport(t *testing.T) {
+	content := payload(20_000)
+	srv := &server{content: content, noRanges: true}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	m := newManager(t, t.TempDir(), Config{ChunkSize: 4096})
+
+	res, err := m.Download(context.Background(), Request{URL: ts.URL + "/a", Checksum: sha(content)})
+	require.NoError(t, err)
+	assert.Len(t, srv.requested(), 1, "the full response to the probe is used")
+	b, err := os.ReadFile(res.Path)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+}
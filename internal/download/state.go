@@ -0,0 +1,78 @@
+package download
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// state records which chunks of a partial file are complete, and the size
+// and validator of the remote file, so a later attempt only resumes the
+// same content. The URL is informational: signed URLs change between
+// attempts of one download key.
+type state struct {
+	URL       string `json:"url"`
+	Size      int64  `json:"size"`
+	Validator string `json:"validator,omitempty"`
+	ChunkSize int64  `json:"chunk_size"`
+	Done      []bool `json:"done"`
+}
+
+// matches reports whether a recorded state can be resumed for a download
+func (s *state) matches(size int64, validator string, chunkSize int64) bool {
+	return s != nil && s.Size == size && s.Validator == validator &&
+		s.ChunkSize == chunkSize && int64(len(s.Done)) == chunks(size, chunkSize)
+}
+
+// completed returns the number of bytes in complete chunks
+func (s *state) completed() int64 {
+	var n int64
+	for i, done := range s.Done {
+		if done {
+			start, end := chunkRange(i, s.Size, s.ChunkSize)
+			n += end - start + 1
+		}
+	}
+	return n
+}
+
+func loadState(path string) (*state, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		// A torn write leaves nothing to resume
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// save writes the state through a temporary file so a crash never leaves
+// a truncated record
+func (s *state) save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func chunks(size, chunkSize int64) int64 {
+	return (size + chunkSize - 1) / chunkSize
+}
+
+// chunkRange returns the inclusive byte range of chunk i
+func chunkRange(i int, size, chunkSize int64) (int64, int64) {
+	start := int64(i) * chunkSize
+	return start, min(start+chunkSize, size) - 1
+}
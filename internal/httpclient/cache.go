@@ -0,0 +1,204 @@
+package httpclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxCacheEntry bounds the bodies the cache keeps; larger responses, such
+// as media downloads, stream through untouched
+const maxCacheEntry = 8 << 20
+
+// cache keeps validated responses on disk, one metadata and one body file
+// per request key
+type cache struct {
+	dir string
+}
+
+// entry is the metadata of a cached response
+type entry struct {
+	URL      string      `json:"url"`
+	Header   http.Header `json:"header"`
+	Size     int64       `json:"size"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+// cacheable reports whether a request may be answered from the cache
+func cacheable(req *http.Request) bool {
+	return req.Method == http.MethodGet && req.Header.Get("Range") == "" &&
+		!hasDirective(req.Header, "no-store")
+}
+
+// conditional reports whether the caller revalidates a copy of its own, so
+// a 304 must reach it rather than be answered from the cache
+func conditional(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+// storable reports whether a response can be revalidated later
+func storable(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || hasDirective(resp.Header, "no-store") {
+		return false
+	}
+	if resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		return false
+	}
+	return resp.ContentLength <= maxCacheEntry
+}
+
+func hasDirective(h http.Header, directive string) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key identifies a request; credentials are part of it so responses are
+// never shared between accounts
+func (c *cache) key(req *http.Request) string {
+	h := sha256.New()
+	for _, part := range []string{req.URL.String(), req.Header.Get("Authorization"), req.Header.Get("Accept")} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return filepath.Join(c.dir, hex.EncodeToString(h.Sum(nil)))
+}
+
+// load returns the cached entry of a request, or nil
+func (c *cache) load(req *http.Request) *entry {
+	b, err := os.ReadFile(c.key(req) + ".json")
+	if err != nil {
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil || e.URL != req.URL.String() {
+		return nil
+	}
+	return &e
+}
+
+// revalidate adds the entry's validators to a request
+func (e *entry) revalidate(req *http.Request) {
+	if etag := e.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		req.Header.Set("If-Modified-Since", lm)
+	}
+}
+
+// respond builds the response to a revalidated request from the cached
+// body, with the headers of the 304 applied
+func (c *cache) respond(req *http.Request, e *entry, notModified *http.Response) (*http.Response, error) {
+	body, err := os.Open(c.key(req) + ".body")
+	if err != nil {
+		return nil, err
+	}
+	header := e.Header.Clone()
+	for k, v := range notModified.Header {
+		header[k] = v
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         notModified.Proto,
+		ProtoMajor:    notModified.ProtoMajor,
+		ProtoMinor:    notModified.ProtoMinor,
+		Header:        header,
+		Body:          body,
+		ContentLength: e.Size,
+		Request:       req,
+	}, nil
+}
+
+// store returns a body that copies the response into the cache as it is
+// read; the entry is written once the body has been read to the end
+func (c *cache) store(req *http.Request, resp *http.Response) io.ReadCloser {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return resp.Body
+	}
+	tmp, err := os.CreateTemp(c.dir, "body-*")
+	if err != nil {
+		return resp.Body
+	}
+	return &cachingBody{
+		ReadCloser: resp.Body,
+		tmp:        tmp,
+		path:       c.key(req),
+		entry:      entry{URL: req.URL.String(), Header: resp.Header.Clone(), StoredAt: time.Now()},
+	}
+}
+
+// cachingBody tees a response body into a temporary file
+type cachingBody struct {
+	io.ReadCloser
+	tmp   *os.File
+	path  string
+	entry entry
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.tmp != nil && n > 0 {
+		b.entry.Size += int64(n)
+		if b.entry.Size > maxCacheEntry {
+			b.discard()
+		} else if _, werr := b.tmp.Write(p[:n]); werr != nil {
+			b.discard()
+		}
+	}
+	if err == io.EOF && b.tmp != nil {
+		b.commit()
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	if b.tmp != nil {
+		b.discard()
+	}
+	return b.ReadCloser.Close()
+}
+
+// commit retires the old metadata, then publishes the body and the new
+// metadata, so a reader never pairs metadata with another version's body
+func (b *cachingBody) commit() {
+	tmp := b.tmp
+	b.tmp = nil
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	_ = os.Remove(b.path + ".json")
+	if err := os.Rename(tmp.Name(), b.path+".body"); err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	meta, err := json.Marshal(b.entry)
+	if err != nil {
+		return
+	}
+	metaTmp := b.path + ".json.tmp"
+	if err := os.WriteFile(metaTmp, meta, 0o644); err != nil {
+		return
+	}
+	_ = os.Rename(metaTmp, b.path+".json")
+}
+
+func (b *cachingBody) discard() {
+	tmp := b.tmp
+	b.tmp = nil
+	_ = tmp.Close()
+	_ = os.Remove(tmp.Name())
+}
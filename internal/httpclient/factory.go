@@ -0,0 +1,219 @@
+// Package httpclient builds the HTTP clients plugins use. The host creates
+// one Factory from the global configuration and hands it to every plugin
+// through its configuration; each service then gets a client with its own
+// proxy, User-Agent, timeout and optional on-disk response cache, and its
+// requests are counted in per-service metrics.
+package httpclient
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/config"
+)
+
+// ProxyDirect disables proxying, including proxies from the environment
+const ProxyDirect = "direct"
+
+const (
+	// DefaultUserAgent identifies media-sync when the host configures none
+	DefaultUserAgent = "media-sync (+https://github.com/sho7650/media-sync)"
+	defaultTimeout   = 30 * time.Second
+	dialTimeout      = 30 * time.Second
+)
+
+// Options configure a Factory
+type Options struct {
+	// Timeout bounds each request, including reading the body
+	Timeout time.Duration
+	// UserAgent is sent by every client; services may add their own in
+	// front of it but not remove it
+	UserAgent string
+	// Proxy is the default proxy of every service, see ClientOptions.Proxy
+	Proxy string
+	// CacheDir enables the response cache for clients that ask for it
+	CacheDir string
+}
+
+// OptionsFromConfig reads factory options from the global configuration
+func OptionsFromConfig(g config.GlobalConfig) (Options, error) {
+	opts := Options{UserAgent: g.HTTP.UserAgent, Proxy: g.HTTP.Proxy, CacheDir: g.HTTP.CacheDir}
+	if g.Timeout != "" {
+		timeout, err := time.ParseDuration(g.Timeout)
+		if err != nil {
+			return Options{}, fmt.Errorf("invalid timeout format: %w", err)
+		}
+		opts.Timeout = timeout
+	}
+	return opts, nil
+}
+
+// ClientOptions configure the client of one service
+type ClientOptions struct {
+	// Timeout overrides the host timeout for this service
+	Timeout time.Duration
+	// DefaultTimeout applies when neither the service nor the host sets one
+	DefaultTimeout time.Duration
+	// Proxy is an http, https, socks5 or socks5h URL, or ProxyDirect. Empty
+	// uses the host proxy and then the environment.
+	Proxy string
+	// UserAgent is sent in front of the host User-Agent
+	UserAgent string
+	// Cache revalidates GET responses carrying an ETag or Last-Modified
+	// against a copy on disk instead of downloading them again
+	Cache bool
+	// Control vets every connection the client dials, as in net.Dialer.
+	// Proxies from the environment are ignored with it, since they would
+	// connect on the client's behalf.
+	Control       func(network, address string, c syscall.RawConn) error
+	CheckRedirect func(req *http.Request, via []*http.Request) error
+}
+
+// Factory builds per-service HTTP clients and collects their metrics
+type Factory struct {
+	opts Options
+
+	mu      sync.Mutex
+	metrics map[string]*serviceMetrics
+}
+
+// NewFactory creates a factory, checking the default proxy
+func NewFactory(opts Options) (*Factory, error) {
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+	if opts.Proxy != "" && opts.Proxy != ProxyDirect {
+		if _, err := parseProxy(opts.Proxy); err != nil {
+			return nil, err
+		}
+	}
+	return &Factory{opts: opts, metrics: make(map[string]*serviceMetrics)}, nil
+}
+
+// Client returns a new client for the named service
+func (f *Factory) Client(service string, opts ClientOptions) (*http.Client, error) {
+	if service == "" {
+		return nil, fmt.Errorf("service name cannot be empty")
+	}
+	timeout := firstPositive(opts.Timeout, f.opts.Timeout, opts.DefaultTimeout, defaultTimeout)
+
+	proxy, err := f.proxy(opts)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: min(timeout, dialTimeout), KeepAlive: 30 * time.Second, Control: opts.Control}
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = proxy
+	base.DialContext = dialer.DialContext
+
+	userAgent := f.opts.UserAgent
+	if opts.UserAgent != "" && opts.UserAgent != userAgent {
+		userAgent = opts.UserAgent + " " + userAgent
+	}
+	rt := &transport{base: base, userAgent: userAgent, metrics: f.serviceMetrics(service)}
+	if opts.Cache && f.opts.CacheDir != "" {
+		rt.cache = &cache{dir: filepath.Join(f.opts.CacheDir, safeName(service))}
+	}
+	return &http.Client{Timeout: timeout, Transport: rt, CheckRedirect: opts.CheckRedirect}, nil
+}
+
+// proxy resolves the proxy of a client: the service's, the host's, and
+// then the environment's
+func (f *Factory) proxy(opts ClientOptions) (func(*http.Request) (*url.URL, error), error) {
+	setting := opts.Proxy
+	if setting == "" {
+		setting = f.opts.Proxy
+	}
+	switch {
+	case setting == ProxyDirect:
+		return nil, nil
+	case setting != "":
+		u, err := parseProxy(setting)
+		if err != nil {
+			return nil, err
+		}
+		return http.ProxyURL(u), nil
+	case opts.Control != nil:
+		return nil, nil
+	default:
+		return http.ProxyFromEnvironment, nil
+	}
+}
+
+// Metrics returns a snapshot of the request metrics of every service
+func (f *Factory) Metrics() map[string]Metrics {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	snapshot := make(map[string]Metrics, len(f.metrics))
+	for service, m := range f.metrics {
+		snapshot[service] = m.snapshot()
+	}
+	return snapshot
+}
+
+// Services lists the services that have clients, in name order
+func (f *Factory) Services() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0, len(f.metrics))
+	for name := range f.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *Factory) serviceMetrics(service string) *serviceMetrics {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m, ok := f.metrics[service]
+	if !ok {
+		m = &serviceMetrics{status: make(map[string]int64)}
+		f.metrics[service] = m
+	}
+	return m
+}
+
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", s, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https, socks5 or socks5h", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: no host", s)
+	}
+	return u, nil
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// safeName turns a service name into a directory name
+func safeName(s string) string {
+	name := unsafeName.ReplaceAllString(s, "_")
+	if name == "." || name == ".." {
+		name = "_"
+	}
+	return name
+}
+
+func firstPositive(values ...time.Duration) time.Duration {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
@@ -0,0 +1,214 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/config"
+)
+
+func get(t *testing.T, client *http.Client, url string) (*http.Response, string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(b)
+}
+
+func TestClient_UserAgent(t *testing.T) {
+	var got atomic.Value
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.UserAgent())
+	}))
+	defer ts.Close()
+
+	f, err := NewFactory(Options{UserAgent: "media-sync/1.0"})
+	require.NoError(t, err)
+
+	plain, err := f.Client("plain", ClientOptions{})
+	require.NoError(t, err)
+	get(t, plain, ts.URL)
+	assert.Equal(t, "media-sync/1.0", got.Load())
+
+	custom, err := f.Client("custom", ClientOptions{UserAgent: "uploader/2"})
+	require.NoError(t, err)
+	get(t, custom, ts.URL)
+	assert.Equal(t, "uploader/2 media-sync/1.0", got.Load(), "the host product is kept")
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "explicit")
+	resp, err := custom.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "explicit", got.Load())
+	assert.Equal(t, "explicit", req.Header.Get("User-Agent"), "the caller's request is not modified")
+}
+
+func TestClient_CacheRevalidates(t *testing.T) {
+	var full, notModified int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nostore" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		_, _ = io.WriteString(w, "page body")
+	}))
+	defer ts.Close()
+
+	f, err := NewFactory(Options{CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	client, err := f.Client("feeds", ClientOptions{Cache: true})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		resp, body := get(t, client, ts.URL+"/feed")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "page body", body)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&full))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&notModified))
+
+	get(t, client, ts.URL+"/nostore")
+	get(t, client, ts.URL+"/nostore")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&full), "no-store responses are not kept")
+
+	m := f.Metrics()["feeds"]
+	assert.Equal(t, int64(5), m.Requests)
+	assert.Equal(t, int64(2), m.CacheHits)
+	assert.Equal(t, map[string]int64{"2xx": 3, "3xx": 2}, m.Status)
+	assert.Equal(t, int64(len("page body")*3), m.BytesReceived, "cached bodies are not counted as received")
+
+	uncached, err := f.Client("other", ClientOptions{})
+	require.NoError(t, err)
+	get(t, uncached, ts.URL+"/feed")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&full), "the cache is opt-in per service")
+}
+
+func TestClient_CachePassesCallerValidators(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = io.WriteString(w, "page body")
+	}))
+	defer ts.Close()
+
+	f, err := NewFactory(Options{CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	client, err := f.Client("feeds", ClientOptions{Cache: true})
+	require.NoError(t, err)
+	get(t, client, ts.URL)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", `"v1"`)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	drain(resp.Body)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "a caller revalidating its own copy sees the 304")
+	assert.Zero(t, f.Metrics()["feeds"].CacheHits)
+}
+
+func TestClient_CacheIgnoresPartialReads(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer ts.Close()
+
+	f, err := NewFactory(Options{CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	client, err := f.Client("svc", ClientOptions{Cache: true})
+	require.NoError(t, err)
+
+	resp, err := client.Get(ts.URL)
+	require.NoError(t, err)
+	_, err = resp.Body.Read(make([]byte, 10))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	_, body := get(t, client, ts.URL)
+	assert.Len(t, body, 1000)
+	assert.Zero(t, f.Metrics()["svc"].CacheHits, "a truncated body is never served")
+}
+
+func TestClient_Proxy(t *testing.T) {
+	var proxied atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Store(r.URL.String())
+	}))
+	defer proxy.Close()
+
+	f, err := NewFactory(Options{Proxy: proxy.URL})
+	require.NoError(t, err)
+	client, err := f.Client("svc", ClientOptions{})
+	require.NoError(t, err)
+	get(t, client, "http://media.example/a.jpg")
+	assert.Equal(t, "http://media.example/a.jpg", proxied.Load())
+
+	direct, err := f.Client("direct", ClientOptions{Proxy: ProxyDirect})
+	require.NoError(t, err)
+	_, err = direct.Get("http://media.invalid/a.jpg")
+	assert.Error(t, err, "the host proxy is bypassed")
+	assert.Equal(t, int64(1), f.Metrics()["direct"].Failures)
+
+	for _, p := range []string{"ftp://proxy:21", "socks5://", "::"} {
+		_, err := f.Client("bad", ClientOptions{Proxy: p})
+		assert.Error(t, err, p)
+	}
+	_, err = f.Client("socks", ClientOptions{Proxy: "socks5h://127.0.0.1:1080"})
+	assert.NoError(t, err)
+	_, err = NewFactory(Options{Proxy: "gopher://proxy"})
+	assert.Error(t, err)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	f, err := NewFactory(Options{})
+	require.NoError(t, err)
+	c, err := f.Client("a", ClientOptions{DefaultTimeout: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, c.Timeout)
+	c, err = f.Client("a", ClientOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultTimeout, c.Timeout)
+
+	opts, err := OptionsFromConfig(config.GlobalConfig{Timeout: "10s", HTTP: config.HTTPConfig{UserAgent: "ua"}})
+	require.NoError(t, err)
+	f, err = NewFactory(opts)
+	require.NoError(t, err)
+	c, err = f.Client("a", ClientOptions{DefaultTimeout: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, c.Timeout, "the host timeout wins over plugin defaults")
+	c, err = f.Client("a", ClientOptions{Timeout: time.Second})
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, c.Timeout)
+
+	_, err = OptionsFromConfig(config.GlobalConfig{Timeout: "soon"})
+	assert.Error(t, err)
+	_, err = f.Client("", ClientOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"a"}, f.Services())
+}
@@ -0,0 +1,127 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics counts the requests of one service
+type Metrics struct {
+	Requests int64 `json:"requests"`
+	// Failures are requests that received no response
+	Failures int64 `json:"failures"`
+	// CacheHits are responses served from the cache after revalidation
+	CacheHits int64 `json:"cache_hits"`
+	// Status counts responses by class, such as "2xx"
+	Status        map[string]int64 `json:"status"`
+	BytesReceived int64            `json:"bytes_received"`
+	// Latency is the total time spent waiting for response headers
+	Latency time.Duration `json:"latency"`
+}
+
+type serviceMetrics struct {
+	mu sync.Mutex
+	Metrics
+	status map[string]int64
+}
+
+func (m *serviceMetrics) snapshot() Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.Metrics
+	s.Status = make(map[string]int64, len(m.status))
+	for class, n := range m.status {
+		s.Status[class] = n
+	}
+	return s
+}
+
+func (m *serviceMetrics) record(resp *http.Response, err error, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Requests++
+	m.Latency += latency
+	if err != nil {
+		m.Failures++
+		return
+	}
+	m.status[strconv.Itoa(resp.StatusCode/100)+"xx"]++
+}
+
+func (m *serviceMetrics) add(field *int64, n int64) {
+	m.mu.Lock()
+	*field += n
+	m.mu.Unlock()
+}
+
+// transport applies the User-Agent policy, the cache and metrics on top of
+// a base transport
+type transport struct {
+	base      http.RoundTripper
+	userAgent string
+	cache     *cache
+	metrics   *serviceMetrics
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	var cached *entry
+	if t.cache != nil && cacheable(req) && !conditional(req) {
+		cached = t.cache.load(req)
+		if cached != nil {
+			cached.revalidate(req)
+		}
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	t.metrics.record(resp, err, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cached != nil && resp.StatusCode == http.StatusNotModified:
+		drain(resp.Body)
+		if hit, openErr := t.cache.respond(req, cached, resp); openErr == nil {
+			t.metrics.add(&t.metrics.CacheHits, 1)
+			return hit, nil
+		}
+		// The cached body vanished, so fetch it again without validators
+		stripped := req.Clone(req.Context())
+		stripped.Header.Del("If-None-Match")
+		stripped.Header.Del("If-Modified-Since")
+		return t.RoundTrip(stripped)
+	case t.cache != nil && cacheable(req) && storable(resp):
+		resp.Body = t.cache.store(req, resp)
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, metrics: t.metrics}
+	return resp, nil
+}
+
+// countingBody counts the bytes read from a response body
+type countingBody struct {
+	io.ReadCloser
+	metrics *serviceMetrics
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.metrics.add(&b.metrics.BytesReceived, int64(n))
+	}
+	return n, err
+}
+
+func drain(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	_ = body.Close()
+}
@@ -0,0 +1,176 @@
+package audiometa
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	jpegData = []byte{0xFF, 0xD8, 0xFF, 0xE0, 1, 2, 3}
+	pngData  = []byte("\x89PNG\r\n\x1a\nimage")
+)
+
+func TestRead_ID3v23(t *testing.T) {
+	file := join(
+		id3v2(3,
+			id3Frame(3, "TIT2", utf16Text("Café Song")),
+			id3Frame(3, "TPE1", utf8Text("Alice")),
+			id3Frame(3, "TALB", utf8Text("Holiday")),
+			id3Frame(3, "TRCK", utf8Text("3/12")),
+			id3Frame(3, "TCON", utf8Text("(17)")),
+			id3Frame(3, "APIC", apic("image/png", 0, pngData)),
+			id3Frame(3, "APIC", apic("image/jpg", PictureFrontCover, jpegData)),
+		),
+		make([]byte, 32), // padding
+		mp3Frames(4, 100),
+	)
+
+	tags, err := Read(file, true)
+	require.NoError(t, err)
+	assert.Equal(t, FormatMP3, tags.Format)
+	assert.Equal(t, "Café Song", tags.Title)
+	assert.Equal(t, "Alice", tags.Artist)
+	assert.Equal(t, "Holiday", tags.Album)
+	assert.Equal(t, 3, tags.Track)
+	assert.Equal(t, 12, tags.TrackTotal)
+	assert.Equal(t, "Rock", tags.Genre)
+	assert.InDelta(t, 2.612, tags.Duration.Seconds(), 0.001)
+	require.NotNil(t, tags.Picture)
+	assert.Equal(t, "image/jpeg", tags.Picture.MIMEType)
+	assert.Equal(t, jpegData, tags.Picture.Data)
+	assert.Equal(t, []string{SourceID3v2, SourceStream}, tags.Sources)
+}
+
+func TestRead_ID3v24AndV1(t *testing.T) {
+	audio := mp3Frames(10, 0)
+	file := join(
+		id3v2(4,
+			id3Frame(4, "TIT2", utf8Text("Episode 12")),
+			id3Frame(4, "TDRC", utf8Text("2024-02-03")),
+		),
+		audio,
+		id3v1("Old title", "Bob", "Podcast", "2023", 7, 17),
+	)
+
+	tags, err := Read(file, true)
+	require.NoError(t, err)
+	assert.Equal(t, "Episode 12", tags.Title, "ID3v2 takes precedence")
+	assert.Equal(t, "Bob", tags.Artist)
+	assert.Equal(t, "Podcast", tags.Album)
+	assert.Equal(t, 7, tags.Track)
+	assert.Equal(t, "2024-02-03", tags.Date)
+	assert.InDelta(t, float64(len(audio))*8/128000, tags.Duration.Seconds(), 0.001)
+	assert.Equal(t, []string{SourceID3v2, SourceID3v1, SourceStream}, tags.Sources)
+
+	prefix, err := Read(file[:len(file)-200], false)
+	require.NoError(t, err)
+	assert.Empty(t, prefix.Artist)
+	assert.Zero(t, prefix.Duration, "constant bitrate needs the whole file")
+}
+
+func TestRead_FLAC(t *testing.T) {
+	file := join([]byte("fLaC"),
+		flacBlock(flacStreamInfo, false, streamInfo(441000)),
+		flacBlock(flacPicture, false, flacPictureBlock("image/png", pngData)),
+		flacBlock(flacVorbisComment, true, vorbisComments(
+			"TITLE=Nocturne", "artist=Chopin", "ALBUM=Nocturnes", "TRACKNUMBER=2", "TRACKTOTAL=21", "DATE=1832",
+		)),
+		[]byte{0xFF, 0xF8},
+	)
+
+	tags, err := Read(file, true)
+	require.NoError(t, err)
+	assert.Equal(t, FormatFLAC, tags.Format)
+	assert.Equal(t, "Nocturne", tags.Title)
+	assert.Equal(t, "Chopin", tags.Artist)
+	assert.Equal(t, 2, tags.Track)
+	assert.Equal(t, 21, tags.TrackTotal)
+	assert.Equal(t, 10*time.Second, tags.Duration)
+	require.NotNil(t, tags.Picture)
+	assert.Equal(t, pngData, tags.Picture.Data)
+	assert.Equal(t, []string{SourceVorbis}, tags.Sources)
+}
+
+func TestRead_Ogg(t *testing.T) {
+	picture := base64.StdEncoding.EncodeToString(flacPictureBlock("image/jpeg", jpegData))
+	vorbis := oggPages(44100*5,
+		join([]byte("\x01vorbis"), le32(0), []byte{2}, le32(44100), make([]byte, 14)),
+		join([]byte("\x03vorbis"), vorbisComments("TITLE=Field Recording", "ARTIST=Carol", "METADATA_BLOCK_PICTURE="+picture), []byte{1}),
+		make([]byte, 300),
+		make([]byte, 40),
+	)
+	tags, err := Read(vorbis, true)
+	require.NoError(t, err)
+	assert.Equal(t, FormatOgg, tags.Format)
+	assert.Equal(t, "Field Recording", tags.Title)
+	assert.Equal(t, 5*time.Second, tags.Duration)
+	require.NotNil(t, tags.Picture)
+	assert.Equal(t, jpegData, tags.Picture.Data)
+
+	opus := oggPages(48000*3+312,
+		join([]byte("OpusHead"), []byte{1, 2}, []byte{0x38, 0x01}, le32(48000), make([]byte, 3)),
+		join([]byte("OpusTags"), vorbisComments("TITLE=Voice memo")),
+		make([]byte, 20),
+	)
+	tags, err = Read(opus, true)
+	require.NoError(t, err)
+	assert.Equal(t, "Voice memo", tags.Title)
+	assert.Equal(t, 3*time.Second, tags.Duration)
+
+	tags, err = Read(opus, false)
+	require.NoError(t, err)
+	assert.Zero(t, tags.Duration)
+}
+
+func TestRead_MP4(t *testing.T) {
+	ilst := mkbox("ilst",
+		mkbox("\xa9nam", dataAtom(dataUTF8, []byte("Interview"))),
+		mkbox("\xa9ART", dataAtom(dataUTF8, []byte("Dave"))),
+		mkbox("aART", dataAtom(dataUTF8, []byte("Various"))),
+		mkbox("trkn", dataAtom(0, []byte{0, 0, 0, 4, 0, 9, 0, 0})),
+		mkbox("gnre", dataAtom(0, []byte{0, 9})),
+		mkbox("covr", dataAtom(dataJPEG, jpegData)),
+	)
+	moov := mkbox("moov",
+		mkbox("mvhd", make([]byte, 12), be32(1000), be32(61500), make([]byte, 80)),
+		mkbox("udta", mkbox("meta", make([]byte, 4), mkbox("hdlr", make([]byte, 25)), ilst)),
+	)
+	file := join(mkbox("ftyp", []byte("M4A "), be32(0)), moov, mkbox("mdat", make([]byte, 16)))
+
+	tags, err := Read(file, true)
+	require.NoError(t, err)
+	assert.Equal(t, FormatMP4, tags.Format)
+	assert.Equal(t, "Interview", tags.Title)
+	assert.Equal(t, "Dave", tags.Artist)
+	assert.Equal(t, "Various", tags.AlbumArtist)
+	assert.Equal(t, 4, tags.Track)
+	assert.Equal(t, 9, tags.TrackTotal)
+	assert.Equal(t, "Jazz", tags.Genre, "gnre is one-based")
+	assert.Equal(t, 61500*time.Millisecond, tags.Duration)
+	require.NotNil(t, tags.Picture)
+	assert.Equal(t, "image/jpeg", tags.Picture.MIMEType)
+}
+
+func TestRead_Unknown(t *testing.T) {
+	_, err := Read([]byte("plain text, not audio"), true)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+	_, err = Read(nil, true)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	tags, err := Read(bytes.Repeat([]byte{0}, 8), true)
+	assert.Nil(t, tags)
+	assert.Error(t, err)
+}
+
+func TestGenreName(t *testing.T) {
+	assert.Equal(t, "Rock", genreName("17"))
+	assert.Equal(t, "Remix", genreName("(RX)"))
+	assert.Equal(t, "Indie", genreName("(17)Indie"))
+	assert.Equal(t, "Lo-Fi Beats", genreName("Lo-Fi Beats"))
+	assert.Equal(t, "", genreName("(250)"))
+}
@@ -0,0 +1,125 @@
+package audiometa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+)
+
+func join(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func le32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// id3v2 builds a tag of the given major version from frames
+func id3v2(version byte, frames ...[]byte) []byte {
+	body := join(frames...)
+	return join([]byte{'I', 'D', '3', version, 0, 0}, syncsafeBytes(len(body)), body)
+}
+
+func id3Frame(version byte, id string, payload []byte) []byte {
+	size := be32(uint32(len(payload)))
+	if version == 4 {
+		size = syncsafeBytes(len(payload))
+	}
+	return join([]byte(id), size, []byte{0, 0}, payload)
+}
+
+func utf8Text(s string) []byte { return join([]byte{encodingUTF8}, []byte(s)) }
+
+func utf16Text(s string) []byte {
+	b := []byte{encodingUTF16, 0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func apic(mimeType string, pictureType byte, data []byte) []byte {
+	return join([]byte{encodingLatin1}, []byte(mimeType), []byte{0, pictureType}, []byte("cover\x00"), data)
+}
+
+func id3v1(title, artist, album, year string, track, genre byte) []byte {
+	field := func(s string, n int) []byte { return append([]byte(s), make([]byte, n-len(s))...) }
+	return join([]byte("TAG"), field(title, 30), field(artist, 30), field(album, 30), field(year, 4),
+		field("", 28), []byte{0, track, genre})
+}
+
+// mp3Frames builds MPEG-1 Layer III frames at 128 kbit/s and 44.1 kHz; a
+// Xing header in the first frame carries xingFrames when it is positive
+func mp3Frames(count, xingFrames int) []byte {
+	const frameSize = 417
+	var out []byte
+	for i := 0; i < count; i++ {
+		frame := make([]byte, frameSize)
+		copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+		if i == 0 && xingFrames > 0 {
+			copy(frame[36:], join([]byte("Xing"), be32(1), be32(uint32(xingFrames))))
+		}
+		out = append(out, frame...)
+	}
+	return out
+}
+
+func vorbisComments(fields ...string) []byte {
+	b := join(le32(6), []byte("fixture"[:6]), le32(uint32(len(fields))))
+	for _, f := range fields {
+		b = join(b, le32(uint32(len(f))), []byte(f))
+	}
+	return b
+}
+
+func flacPictureBlock(mimeType string, data []byte) []byte {
+	return join(be32(PictureFrontCover), be32(uint32(len(mimeType))), []byte(mimeType), be32(0),
+		be32(1), be32(1), be32(24), be32(0), be32(uint32(len(data))), data)
+}
+
+func flacBlock(typ byte, last bool, data []byte) []byte {
+	if last {
+		typ |= 0x80
+	}
+	return join([]byte{typ, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data)
+}
+
+// streamInfo describes 44.1 kHz stereo 16-bit audio of the given samples
+func streamInfo(samples uint64) []byte {
+	b := make([]byte, 34)
+	v := uint64(44100)<<44 | uint64(1)<<41 | uint64(15)<<36 | samples
+	binary.BigEndian.PutUint64(b[10:], v)
+	return b
+}
+
+// oggPages lays out packets as one page each for a single stream, with the
+// last page at granule
+func oggPages(granule uint64, packets ...[]byte) []byte {
+	var out []byte
+	for i, p := range packets {
+		var segments []byte
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			segments = append(segments, 255)
+		}
+		segments = append(segments, byte(n))
+		pos := uint64(0)
+		if i == len(packets)-1 {
+			pos = granule
+		}
+		header := join([]byte("OggS"), []byte{0, 0}, binary.LittleEndian.AppendUint64(nil, pos),
+			le32(0x1234), le32(uint32(i)), le32(0), []byte{byte(len(segments))}, segments)
+		out = join(out, header, p)
+	}
+	return out
+}
+
+func mkbox(typ string, parts ...[]byte) []byte {
+	payload := join(parts...)
+	return join(be32(uint32(8+len(payload))), []byte(typ), payload)
+}
+
+func dataAtom(kind uint32, value []byte) []byte {
+	return mkbox("data", be32(kind), be32(0), value)
+}
@@ -0,0 +1,277 @@
+package audiometa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const id3v1Size = 128
+
+// ID3v2 text encodings
+const (
+	encodingLatin1  = 0
+	encodingUTF16   = 1
+	encodingUTF16BE = 2
+	encodingUTF8    = 3
+)
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// removeUnsync undoes ID3v2 unsynchronisation, which inserts a zero after
+// every 0xFF byte
+func removeUnsync(b []byte) []byte {
+	if !bytes.Contains(b, []byte{0xFF, 0x00}) {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
+
+// parseID3v2 reads an ID3v2.2, 2.3 or 2.4 tag at the start of b and returns
+// its total length
+func parseID3v2(b []byte) (*Tags, int, bool) {
+	if len(b) < 10 || string(b[:3]) != "ID3" || b[3] < 2 || b[3] > 4 {
+		return nil, 0, false
+	}
+	version, flags := b[3], b[5]
+	size := syncsafe(b[6:10])
+	total := 10 + size
+	if flags&0x10 != 0 {
+		total += 10
+	}
+	body := b[10:min(len(b), 10+size)]
+	if flags&0x80 != 0 && version < 4 {
+		body = removeUnsync(body)
+	}
+	if flags&0x40 != 0 && version > 2 && len(body) >= 4 {
+		// Skip the extended header
+		skip := int(binary.BigEndian.Uint32(body)) + 4
+		if version == 4 {
+			skip = syncsafe(body)
+		}
+		body = body[min(len(body), skip):]
+	}
+
+	tags := &Tags{}
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var frameSize int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			frameSize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(body[4:]))
+			frameFlags = binary.BigEndian.Uint16(body[8:])
+		case 4:
+			frameSize = syncsafe(body[4:8])
+			frameFlags = binary.BigEndian.Uint16(body[8:])
+		}
+		if frameSize < 0 || headerLen+frameSize > len(body) {
+			break
+		}
+		frame := body[headerLen : headerLen+frameSize]
+		body = body[headerLen+frameSize:]
+
+		if version == 4 {
+			if frameFlags&0x0001 != 0 && len(frame) >= 4 {
+				// Data length indicator
+				frame = frame[4:]
+			}
+			if frameFlags&0x0002 != 0 {
+				frame = removeUnsync(frame)
+			}
+		}
+		if frameFlags&0x000C != 0 && version == 4 || frameFlags&0x00C0 != 0 && version == 3 {
+			// Compressed or encrypted frames are skipped
+			continue
+		}
+		tags.frame(id, frame)
+	}
+	return tags, total, true
+}
+
+// frame applies one ID3v2 frame; v2.2 frame IDs are three characters
+func (t *Tags) frame(id string, b []byte) {
+	switch id {
+	case "TIT2", "TT2":
+		t.Title = textFrame(b)
+	case "TPE1", "TP1":
+		t.Artist = textFrame(b)
+	case "TALB", "TAL":
+		t.Album = textFrame(b)
+	case "TPE2", "TP2":
+		t.AlbumArtist = textFrame(b)
+	case "TRCK", "TRK":
+		t.Track, t.TrackTotal = splitNumber(textFrame(b))
+	case "TPOS", "TPA":
+		t.Disc, t.DiscTotal = splitNumber(textFrame(b))
+	case "TDRC", "TYER", "TYE":
+		if t.Date == "" || id == "TDRC" {
+			t.Date = textFrame(b)
+		}
+	case "TCON", "TCO":
+		t.Genre = genreName(textFrame(b))
+	case "TLEN", "TLE":
+		if ms, err := strconv.Atoi(textFrame(b)); err == nil && ms > 0 {
+			t.Duration = time.Duration(ms) * time.Millisecond
+		}
+	case "APIC":
+		t.setPicture(apicFrame(b))
+	case "PIC":
+		t.setPicture(picFrame(b))
+	}
+}
+
+// textFrame decodes the first value of a text information frame
+func textFrame(b []byte) string {
+	if len(b) < 1 {
+		return ""
+	}
+	s, _ := decodeText(b[0], b[1:])
+	return strings.TrimSpace(s)
+}
+
+// decodeText decodes a NUL-terminated string in an ID3v2 encoding and
+// returns the bytes after the terminator
+func decodeText(encoding byte, b []byte) (string, []byte) {
+	wide := encoding == encodingUTF16 || encoding == encodingUTF16BE
+	end, termLen := len(b), 0
+	if wide {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				end, termLen = i, 2
+				break
+			}
+		}
+	} else if i := bytes.IndexByte(b, 0); i >= 0 {
+		end, termLen = i, 1
+	}
+	text, rest := b[:end], b[end+termLen:]
+
+	switch encoding {
+	case encodingUTF16, encodingUTF16BE:
+		var order binary.ByteOrder = binary.BigEndian
+		if len(text) >= 2 && encoding == encodingUTF16 {
+			switch {
+			case text[0] == 0xFF && text[1] == 0xFE:
+				order, text = binary.LittleEndian, text[2:]
+			case text[0] == 0xFE && text[1] == 0xFF:
+				text = text[2:]
+			}
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			units[i] = order.Uint16(text[i*2:])
+		}
+		return string(utf16.Decode(units)), rest
+	case encodingUTF8:
+		return string(text), rest
+	}
+	return latin1(text), rest
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// apicFrame decodes an attached picture: encoding, MIME type, picture
+// type, description and image data
+func apicFrame(b []byte) *Picture {
+	if len(b) < 4 {
+		return nil
+	}
+	encoding := b[0]
+	end := bytes.IndexByte(b[1:], 0)
+	if end < 0 || 1+end+2 > len(b) {
+		return nil
+	}
+	p := &Picture{MIMEType: pictureMIMEType(latin1(b[1 : 1+end]))}
+	rest := b[1+end+1:]
+	p.Type = int(rest[0])
+	p.Description, p.Data = decodeText(encoding, rest[1:])
+	return p
+}
+
+// pictureMIMEType normalizes the loose MIME types taggers write, such as
+// "jpg" or "image/jpg"
+func pictureMIMEType(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return ""
+	}
+	if !strings.Contains(s, "/") {
+		s = "image/" + s
+	}
+	if s == "image/jpg" {
+		return "image/jpeg"
+	}
+	return s
+}
+
+// picFrame decodes the ID3v2.2 picture frame, which has a three character
+// image format instead of a MIME type
+func picFrame(b []byte) *Picture {
+	if len(b) < 6 {
+		return nil
+	}
+	p := &Picture{Type: int(b[4])}
+	switch strings.ToUpper(string(b[1:4])) {
+	case "PNG":
+		p.MIMEType = "image/png"
+	default:
+		p.MIMEType = "image/jpeg"
+	}
+	p.Description, p.Data = decodeText(b[0], b[5:])
+	return p
+}
+
+// parseID3v1 reads the 128-byte ID3v1 or ID3v1.1 tag at the end of b
+func parseID3v1(b []byte) (*Tags, bool) {
+	if len(b) < id3v1Size {
+		return nil, false
+	}
+	tag := b[len(b)-id3v1Size:]
+	if string(tag[:3]) != "TAG" {
+		return nil, false
+	}
+	field := func(f []byte) string {
+		if i := bytes.IndexByte(f, 0); i >= 0 {
+			f = f[:i]
+		}
+		return strings.TrimSpace(latin1(f))
+	}
+	tags := &Tags{
+		Title:  field(tag[3:33]),
+		Artist: field(tag[33:63]),
+		Album:  field(tag[63:93]),
+		Date:   field(tag[93:97]),
+	}
+	if comment := tag[97:127]; comment[28] == 0 && comment[29] != 0 {
+		tags.Track = int(comment[29])
+	}
+	if genre := int(tag[127]); genre < len(id3Genres) {
+		tags.Genre = id3Genres[genre]
+	}
+	return tags, true
+}
@@ -0,0 +1,142 @@
+package audiometa
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+)
+
+// Well-known types of MP4 metadata data atoms
+const (
+	dataUTF8 = 1
+	dataJPEG = 13
+	dataPNG  = 14
+	dataBMP  = 27
+)
+
+// boxes splits b into ISO base media boxes, stopping at the first
+// malformed one
+func boxes(b []byte, visit func(typ string, data []byte)) {
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return
+			}
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return
+		}
+		visit(string(b[4:8]), b[header:size])
+		b = b[size:]
+	}
+}
+
+func child(b []byte, typ string) []byte {
+	var found []byte
+	boxes(b, func(t string, data []byte) {
+		if t == typ && found == nil {
+			found = data
+		}
+	})
+	return found
+}
+
+// readMP4 reads the duration from mvhd and iTunes-style metadata from
+// moov/udta/meta/ilst
+func readMP4(b []byte, tags *Tags) {
+	moov := child(b, "moov")
+	if moov == nil {
+		return
+	}
+	mp4 := &Tags{}
+	if mvhd := child(moov, "mvhd"); len(mvhd) >= 20 {
+		var timescale uint32
+		var duration uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			timescale, duration = binary.BigEndian.Uint32(mvhd[20:]), binary.BigEndian.Uint64(mvhd[24:])
+		} else {
+			timescale, duration = binary.BigEndian.Uint32(mvhd[12:]), uint64(binary.BigEndian.Uint32(mvhd[16:]))
+		}
+		if timescale > 0 {
+			mp4.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+		}
+	}
+
+	meta := child(child(moov, "udta"), "meta")
+	if meta == nil {
+		meta = child(moov, "meta")
+	}
+	if len(meta) >= 4 {
+		// meta is a full box with version and flags
+		boxes(child(meta[4:], "ilst"), func(typ string, item []byte) {
+			mp4.item(typ, item)
+		})
+	}
+	tags.merge(mp4, SourceMP4)
+}
+
+// item applies one ilst entry, whose value is held in a data atom of a
+// type, a locale and the payload
+func (t *Tags) item(typ string, item []byte) {
+	data := child(item, "data")
+	if len(data) < 8 {
+		return
+	}
+	kind, value := binary.BigEndian.Uint32(data)&0xFFFFFF, data[8:]
+	text := func() string {
+		if kind != dataUTF8 {
+			return ""
+		}
+		return string(value)
+	}
+	pair := func() (int, int) {
+		// Reserved, number and total as 16-bit integers
+		if len(value) < 6 {
+			return 0, 0
+		}
+		return int(binary.BigEndian.Uint16(value[2:])), int(binary.BigEndian.Uint16(value[4:]))
+	}
+
+	switch typ {
+	case "\xa9nam":
+		t.Title = text()
+	case "\xa9ART":
+		t.Artist = text()
+	case "\xa9alb":
+		t.Album = text()
+	case "aART":
+		t.AlbumArtist = text()
+	case "\xa9day":
+		t.Date = text()
+	case "\xa9gen":
+		t.Genre = text()
+	case "gnre":
+		// ID3v1 genre index plus one
+		if len(value) >= 2 {
+			if i := int(binary.BigEndian.Uint16(value)); i > 0 {
+				t.Genre = genreName(strconv.Itoa(i - 1))
+			}
+		}
+	case "trkn":
+		t.Track, t.TrackTotal = pair()
+	case "disk":
+		t.Disc, t.DiscTotal = pair()
+	case "covr":
+		p := &Picture{Type: PictureFrontCover, Data: value}
+		switch kind {
+		case dataJPEG:
+			p.MIMEType = "image/jpeg"
+		case dataPNG:
+			p.MIMEType = "image/png"
+		case dataBMP:
+			p.MIMEType = "image/bmp"
+		}
+		t.setPicture(p)
+	}
+}
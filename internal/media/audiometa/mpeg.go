@@ -0,0 +1,119 @@
+package audiometa
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// MPEG audio versions as encoded in the frame header
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+)
+
+// Bitrates in kbit/s by version group and layer, indexed by the header's
+// bitrate index
+var bitrates = map[[2]int][16]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var sampleRates = [3]int{44100, 48000, 32000}
+
+// frameHeader is a decoded MPEG audio frame header
+type frameHeader struct {
+	version    int
+	layer      int
+	bitrate    int // bit/s
+	sampleRate int
+	mono       bool
+}
+
+func parseFrameHeader(b []byte) (frameHeader, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return frameHeader{}, false
+	}
+	h := frameHeader{version: int(b[1]>>3) & 3, layer: 4 - int(b[1]>>1)&3}
+	bitrateIndex, rateIndex := int(b[2]>>4), int(b[2]>>2)&3
+	if h.version == 1 || h.layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return frameHeader{}, false
+	}
+	group := 2
+	if h.version == mpeg1 {
+		group = 1
+	}
+	h.bitrate = bitrates[[2]int{group, h.layer}][bitrateIndex] * 1000
+	h.sampleRate = sampleRates[rateIndex]
+	switch h.version {
+	case mpeg2:
+		h.sampleRate /= 2
+	case mpeg25:
+		h.sampleRate /= 4
+	}
+	h.mono = b[3]>>6 == 3
+	return h, true
+}
+
+func (h frameHeader) samplesPerFrame() int {
+	switch {
+	case h.layer == 1:
+		return 384
+	case h.layer == 3 && h.version != mpeg1:
+		return 576
+	}
+	return 1152
+}
+
+// findFrame returns the offset of the first MPEG audio frame, allowing for
+// padding after an ID3v2 tag
+func findFrame(b []byte) (int, bool) {
+	const limit = 64 << 10
+	for i := 0; i+4 <= len(b) && i < limit; i++ {
+		if _, ok := parseFrameHeader(b[i:]); ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// mpegDuration reads the frame count of a Xing, Info or VBRI header, or
+// estimates a constant bitrate duration from the size of complete audio
+func mpegDuration(audio []byte, complete bool) (time.Duration, bool) {
+	h, ok := parseFrameHeader(audio)
+	if !ok {
+		return 0, false
+	}
+	sideInfo := 32
+	switch {
+	case h.version == mpeg1 && h.mono:
+		sideInfo = 17
+	case h.version != mpeg1 && h.mono:
+		sideInfo = 9
+	case h.version != mpeg1:
+		sideInfo = 17
+	}
+
+	frames := 0
+	if xing := 4 + sideInfo; len(audio) >= xing+12 {
+		tag := string(audio[xing : xing+4])
+		if (tag == "Xing" || tag == "Info") && binary.BigEndian.Uint32(audio[xing+4:])&1 != 0 {
+			frames = int(binary.BigEndian.Uint32(audio[xing+8:]))
+		}
+	}
+	if vbri := 4 + 32; frames == 0 && len(audio) >= vbri+18 && string(audio[vbri:vbri+4]) == "VBRI" {
+		frames = int(binary.BigEndian.Uint32(audio[vbri+14:]))
+	}
+	if frames > 0 {
+		seconds := float64(frames) * float64(h.samplesPerFrame()) / float64(h.sampleRate)
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if !complete || h.bitrate == 0 {
+		return 0, false
+	}
+	return time.Duration(float64(len(audio)) * 8 / float64(h.bitrate) * float64(time.Second)), true
+}
@@ -0,0 +1,208 @@
+// Package audiometa reads title, artist, album, track numbering, duration
+// and embedded cover art from ID3v2/ID3v1 tagged MP3 files, FLAC and Ogg
+// (Vorbis, Opus) files with Vorbis comments, and MP4 audio with iTunes
+// metadata atoms.
+package audiometa
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tag formats reported in Tags.Sources
+const (
+	SourceID3v2  = "id3v2"
+	SourceID3v1  = "id3v1"
+	SourceVorbis = "vorbis"
+	SourceMP4    = "mp4"
+	SourceStream = "stream"
+)
+
+// Container formats recognised by Read
+const (
+	FormatMP3  = "mp3"
+	FormatFLAC = "flac"
+	FormatOgg  = "ogg"
+	FormatMP4  = "mp4"
+)
+
+// ErrUnknownFormat is returned by Read for unrecognised audio files
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// PictureFrontCover is the ID3 and FLAC picture type of a front cover
+const PictureFrontCover = 3
+
+// Picture is an embedded image, usually cover art
+type Picture struct {
+	MIMEType    string
+	Type        int
+	Description string
+	Data        []byte
+}
+
+// Tags are the descriptive fields of an audio file; zero values are absent
+type Tags struct {
+	Format      string
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Track       int
+	TrackTotal  int
+	Disc        int
+	DiscTotal   int
+	Date        string
+	Genre       string
+	Duration    time.Duration
+	Picture     *Picture
+	// Sources lists which tag formats contributed, and SourceStream when
+	// the duration was read from the audio stream
+	Sources []string
+}
+
+// Read parses the tags of an audio file. b may be a prefix of the file
+// when complete is false; trailing ID3v1 tags and durations that need the
+// end of the stream are then not reported.
+func Read(b []byte, complete bool) (*Tags, error) {
+	tags := &Tags{}
+	audio := b
+	if id3, n, ok := parseID3v2(b); ok {
+		tags.merge(id3, SourceID3v2)
+		audio = b[min(n, len(b)):]
+	}
+
+	switch {
+	case strings.HasPrefix(string(audio[:min(len(audio), 4)]), "fLaC"):
+		tags.Format = FormatFLAC
+		readFLAC(audio, tags)
+	case strings.HasPrefix(string(audio[:min(len(audio), 4)]), "OggS"):
+		tags.Format = FormatOgg
+		readOgg(audio, complete, tags)
+	case len(audio) >= 8 && string(audio[4:8]) == "ftyp":
+		tags.Format = FormatMP4
+		readMP4(audio, tags)
+	default:
+		start, ok := findFrame(audio)
+		if !ok {
+			if len(tags.Sources) == 0 {
+				return nil, ErrUnknownFormat
+			}
+			break
+		}
+		tags.Format = FormatMP3
+		end := len(audio)
+		if complete {
+			if v1, ok := parseID3v1(b); ok {
+				tags.merge(v1, SourceID3v1)
+				end -= id3v1Size
+			}
+		}
+		if d, ok := mpegDuration(audio[start:max(start, end)], complete); ok && tags.Duration == 0 {
+			tags.Duration = d
+			tags.Sources = append(tags.Sources, SourceStream)
+		}
+	}
+	return tags, nil
+}
+
+// merge fills the fields of t that are still empty from other
+func (t *Tags) merge(other *Tags, source string) {
+	contributed := false
+	setString := func(dst *string, v string) {
+		if *dst == "" && v != "" {
+			*dst, contributed = v, true
+		}
+	}
+	setInt := func(dst *int, v int) {
+		if *dst == 0 && v != 0 {
+			*dst, contributed = v, true
+		}
+	}
+	setString(&t.Title, other.Title)
+	setString(&t.Artist, other.Artist)
+	setString(&t.Album, other.Album)
+	setString(&t.AlbumArtist, other.AlbumArtist)
+	setString(&t.Date, other.Date)
+	setString(&t.Genre, other.Genre)
+	setInt(&t.Track, other.Track)
+	setInt(&t.TrackTotal, other.TrackTotal)
+	setInt(&t.Disc, other.Disc)
+	setInt(&t.DiscTotal, other.DiscTotal)
+	if t.Duration == 0 && other.Duration > 0 {
+		t.Duration, contributed = other.Duration, true
+	}
+	if other.Picture != nil && (t.Picture == nil || t.Picture.Type != PictureFrontCover && other.Picture.Type == PictureFrontCover) {
+		t.Picture, contributed = other.Picture, true
+	}
+	if contributed {
+		t.Sources = append(t.Sources, source)
+	}
+}
+
+// setPicture keeps the front cover, or else the first picture
+func (t *Tags) setPicture(p *Picture) {
+	if p == nil || len(p.Data) == 0 {
+		return
+	}
+	if t.Picture == nil || (t.Picture.Type != PictureFrontCover && p.Type == PictureFrontCover) {
+		t.Picture = p
+	}
+}
+
+// splitNumber reads "3" or "3/12" track and disc numbers
+func splitNumber(s string) (n, total int) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		total, _ = strconv.Atoi(strings.TrimSpace(s[i+1:]))
+		s = s[:i]
+	}
+	n, _ = strconv.Atoi(strings.TrimSpace(s))
+	return max(n, 0), max(total, 0)
+}
+
+// genreName resolves numeric ID3 genre references such as "(17)" or "17"
+func genreName(s string) string {
+	s = strings.TrimSpace(s)
+	ref := s
+	if strings.HasPrefix(s, "(") {
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return s
+		}
+		if rest := strings.TrimSpace(s[end+1:]); rest != "" {
+			// "(17)Rock" carries its own refinement
+			return rest
+		}
+		ref = s[1:end]
+	}
+	switch ref {
+	case "RX":
+		return "Remix"
+	case "CR":
+		return "Cover"
+	}
+	if i, err := strconv.Atoi(ref); err == nil {
+		if i >= 0 && i < len(id3Genres) {
+			return id3Genres[i]
+		}
+		return ""
+	}
+	return s
+}
+
+// id3Genres are the ID3v1 genres, which ID3v2 and MP4 gnre atoms refer to
+// by index
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+}
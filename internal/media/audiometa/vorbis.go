@@ -0,0 +1,275 @@
+package audiometa
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// parseComments reads a Vorbis comment block: a vendor string followed by
+// KEY=value fields, all with little-endian lengths
+func parseComments(b []byte) *Tags {
+	tags := &Tags{}
+	if len(b) < 8 {
+		return tags
+	}
+	vendor := int(binary.LittleEndian.Uint32(b))
+	if 4+vendor+4 > len(b) {
+		return tags
+	}
+	b = b[4+vendor:]
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+	for i := 0; i < count && len(b) >= 4; i++ {
+		n := int(binary.LittleEndian.Uint32(b))
+		if n < 0 || 4+n > len(b) {
+			break
+		}
+		field := string(b[4 : 4+n])
+		b = b[4+n:]
+
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		set := func(dst *string) {
+			if *dst == "" {
+				*dst = value
+			}
+		}
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			set(&tags.Title)
+		case "ARTIST":
+			set(&tags.Artist)
+		case "ALBUM":
+			set(&tags.Album)
+		case "ALBUMARTIST", "ALBUM ARTIST":
+			set(&tags.AlbumArtist)
+		case "DATE", "YEAR":
+			set(&tags.Date)
+		case "GENRE":
+			set(&tags.Genre)
+		case "TRACKNUMBER":
+			track, total := splitNumber(value)
+			tags.Track = track
+			if total > 0 {
+				tags.TrackTotal = total
+			}
+		case "TRACKTOTAL", "TOTALTRACKS":
+			tags.TrackTotal, _ = splitNumber(value)
+		case "DISCNUMBER":
+			disc, total := splitNumber(value)
+			tags.Disc = disc
+			if total > 0 {
+				tags.DiscTotal = total
+			}
+		case "DISCTOTAL", "TOTALDISCS":
+			tags.DiscTotal, _ = splitNumber(value)
+		case "METADATA_BLOCK_PICTURE":
+			if raw, err := base64.StdEncoding.DecodeString(value); err == nil {
+				tags.setPicture(parseFLACPicture(raw))
+			}
+		}
+	}
+	return tags
+}
+
+// parseFLACPicture reads a FLAC PICTURE block, also used base64 encoded in
+// Ogg comments
+func parseFLACPicture(b []byte) *Picture {
+	read := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint32(b))
+		if n < 0 || 4+n > len(b) {
+			return nil, false
+		}
+		v := b[4 : 4+n]
+		b = b[4+n:]
+		return v, true
+	}
+	if len(b) < 4 {
+		return nil
+	}
+	p := &Picture{Type: int(binary.BigEndian.Uint32(b))}
+	b = b[4:]
+	mimeType, ok := read()
+	if !ok {
+		return nil
+	}
+	description, ok := read()
+	if !ok || len(b) < 16 {
+		return nil
+	}
+	// Width, height, depth and palette size
+	b = b[16:]
+	data, ok := read()
+	if !ok {
+		return nil
+	}
+	p.MIMEType = pictureMIMEType(string(mimeType))
+	p.Description = string(description)
+	p.Data = data
+	return p
+}
+
+// readFLAC walks the metadata blocks after the fLaC marker
+func readFLAC(b []byte, tags *Tags) {
+	flac := &Tags{}
+	b = b[4:]
+	for len(b) >= 4 {
+		last, typ := b[0]&0x80 != 0, b[0]&0x7F
+		n := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		if 4+n > len(b) {
+			break
+		}
+		block := b[4 : 4+n]
+		b = b[4+n:]
+
+		switch typ {
+		case flacStreamInfo:
+			if len(block) >= 18 {
+				// 20 bits of sample rate, 3 of channels, 5 of bit depth and
+				// 36 of total samples
+				v := binary.BigEndian.Uint64(block[10:])
+				rate, samples := v>>44, v&(1<<36-1)
+				if rate > 0 && samples > 0 {
+					flac.Duration = time.Duration(float64(samples) / float64(rate) * float64(time.Second))
+				}
+			}
+		case flacVorbisComment:
+			comments := parseComments(block)
+			comments.Duration, comments.Picture = flac.Duration, flac.Picture
+			flac = comments
+		case flacPicture:
+			flac.setPicture(parseFLACPicture(block))
+		}
+		if last {
+			break
+		}
+	}
+	tags.merge(flac, SourceVorbis)
+}
+
+// oggPage is one page of an Ogg bitstream
+type oggPage struct {
+	granule  uint64
+	serial   uint32
+	segments []byte
+	payload  []byte
+	size     int
+}
+
+func parseOggPage(b []byte) (oggPage, bool) {
+	if len(b) < 27 || string(b[:4]) != "OggS" {
+		return oggPage{}, false
+	}
+	count := int(b[26])
+	if 27+count > len(b) {
+		return oggPage{}, false
+	}
+	segments := b[27 : 27+count]
+	n := 0
+	for _, s := range segments {
+		n += int(s)
+	}
+	if 27+count+n > len(b) {
+		return oggPage{}, false
+	}
+	return oggPage{
+		granule:  binary.LittleEndian.Uint64(b[6:]),
+		serial:   binary.LittleEndian.Uint32(b[14:]),
+		segments: segments,
+		payload:  b[27+count : 27+count+n],
+		size:     27 + count + n,
+	}, true
+}
+
+// readOgg reassembles the identification and comment packets of the first
+// logical stream. The duration comes from the granule position of the last
+// page, so it is only read from complete files.
+func readOgg(b []byte, complete bool, tags *Tags) {
+	var packets [][]byte
+	var packet []byte
+	var serial uint32
+	for off, first := 0, true; off < len(b) && len(packets) < 2; first = false {
+		page, ok := parseOggPage(b[off:])
+		if !ok {
+			break
+		}
+		off += page.size
+		if first {
+			serial = page.serial
+		} else if page.serial != serial {
+			continue
+		}
+		payload := page.payload
+		for _, s := range page.segments {
+			packet = append(packet, payload[:s]...)
+			payload = payload[s:]
+			if s < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+	}
+	if len(packets) < 2 {
+		return
+	}
+
+	var rate uint64
+	var preSkip uint64
+	var comments []byte
+	id, header := packets[0], packets[1]
+	switch {
+	case bytes.HasPrefix(id, []byte("\x01vorbis")) && len(id) >= 16:
+		rate = uint64(binary.LittleEndian.Uint32(id[12:]))
+		if bytes.HasPrefix(header, []byte("\x03vorbis")) {
+			comments = header[7:]
+		}
+	case bytes.HasPrefix(id, []byte("OpusHead")) && len(id) >= 12:
+		// Opus granule positions always count 48 kHz samples
+		rate, preSkip = 48000, uint64(binary.LittleEndian.Uint16(id[10:]))
+		if bytes.HasPrefix(header, []byte("OpusTags")) {
+			comments = header[8:]
+		}
+	default:
+		return
+	}
+
+	ogg := parseComments(comments)
+	if complete && rate > 0 {
+		if granule, ok := lastGranule(b, serial); ok && granule > preSkip {
+			ogg.Duration = time.Duration(float64(granule-preSkip) / float64(rate) * float64(time.Second))
+		}
+	}
+	tags.merge(ogg, SourceVorbis)
+}
+
+// lastGranule finds the granule position of the last page of a stream
+func lastGranule(b []byte, serial uint32) (uint64, bool) {
+	const window = 64 << 10
+	tail := b[max(0, len(b)-window):]
+	for i := len(tail) - 27; i >= 0; i-- {
+		i = bytes.LastIndex(tail[:i+4], []byte("OggS"))
+		if i < 0 {
+			break
+		}
+		if page, ok := parseOggPage(tail[i:]); ok && page.serial == serial && page.granule != ^uint64(0) {
+			return page.granule, true
+		}
+	}
+	return 0, false
+}
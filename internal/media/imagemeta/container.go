@@ -0,0 +1,499 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// Container formats recognised by Scan
+const (
+	FormatJPEG = "jpeg"
+	FormatTIFF = "tiff"
+	FormatHEIF = "heif"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+// ErrUnknownFormat is returned by Scan for unrecognised containers
+var ErrUnknownFormat = errors.New("unknown image container")
+
+// Identifiers of the metadata payloads inside image containers
+const (
+	JPEGEXIFHeader        = "Exif\x00\x00"
+	JPEGXMPHeader         = "http://ns.adobe.com/xap/1.0/\x00"
+	JPEGExtendedXMPHeader = "http://ns.adobe.com/xmp/extension/\x00"
+	PhotoshopHeader       = "Photoshop 3.0\x00"
+	PNGXMPKeyword         = "XML:com.adobe.xmp"
+
+	// PhotoshopIPTC and PhotoshopIPTCDigest are image resource IDs
+	PhotoshopIPTC       uint16 = 0x0404
+	PhotoshopIPTCDigest uint16 = 0x0425
+)
+
+var (
+	jpegEXIFPrefix  = []byte(JPEGEXIFHeader)
+	jpegXMPPrefix   = []byte(JPEGXMPHeader)
+	photoshopPrefix = []byte(PhotoshopHeader)
+	pngSignature    = []byte("\x89PNG\r\n\x1a\n")
+	pngXMPKeyword   = []byte(PNGXMPKeyword + "\x00")
+)
+
+// Blocks are the raw metadata payloads embedded in an image. EXIF starts at
+// the TIFF header, XMP is the RDF packet and IPTC holds IIM records.
+type Blocks struct {
+	Format string
+	EXIF   []byte
+	XMP    []byte
+	IPTC   []byte
+}
+
+// Scan locates the metadata blocks in an image. b may be a prefix of the
+// file; blocks that lie beyond it are not reported.
+func Scan(b []byte) (Blocks, error) {
+	switch {
+	case len(b) >= 3 && b[0] == 0xFF && b[1] == 0xD8 && b[2] == 0xFF:
+		return scanJPEG(b), nil
+	case len(b) >= 4 && (bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*"))):
+		return scanTIFF(b), nil
+	case bytes.HasPrefix(b, pngSignature):
+		return scanPNG(b), nil
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return scanWebP(b), nil
+	case len(b) >= 12 && string(b[4:8]) == "ftyp" && isHEIFBrand(b):
+		return scanHEIF(b), nil
+	}
+	return Blocks{}, ErrUnknownFormat
+}
+
+// JPEGSegment is one marker segment of a JPEG file before the scan data
+type JPEGSegment struct {
+	Marker byte
+	// Offset and End bound the whole segment including marker and length
+	Offset, End int
+	// Payload excludes the marker and length bytes
+	Payload []byte
+}
+
+// JPEGSegments lists the marker segments up to the start of scan. The
+// returned offset is where the SOS segment (and entropy-coded data) begins,
+// or -1 when b ends first.
+func JPEGSegments(b []byte) ([]JPEGSegment, int) {
+	var segments []JPEGSegment
+	pos := 2
+	for pos+4 <= len(b) {
+		if b[pos] != 0xFF {
+			return segments, -1
+		}
+		marker := b[pos+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte
+			pos++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			pos += 2
+			continue
+		case marker == 0xDA:
+			return segments, pos
+		}
+
+		length := int(binary.BigEndian.Uint16(b[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(b) {
+			return segments, -1
+		}
+		segments = append(segments, JPEGSegment{Marker: marker, Offset: pos, End: end, Payload: b[pos+4 : end]})
+		pos = end
+	}
+	return segments, -1
+}
+
+func scanJPEG(b []byte) Blocks {
+	blocks := Blocks{Format: FormatJPEG}
+	segments, _ := JPEGSegments(b)
+	for _, seg := range segments {
+		switch {
+		case seg.Marker == 0xE1 && bytes.HasPrefix(seg.Payload, jpegEXIFPrefix) && blocks.EXIF == nil:
+			blocks.EXIF = seg.Payload[len(jpegEXIFPrefix):]
+		case seg.Marker == 0xE1 && bytes.HasPrefix(seg.Payload, jpegXMPPrefix) && blocks.XMP == nil:
+			blocks.XMP = seg.Payload[len(jpegXMPPrefix):]
+		case seg.Marker == 0xED && bytes.HasPrefix(seg.Payload, photoshopPrefix) && blocks.IPTC == nil:
+			blocks.IPTC = photoshopResource(seg.Payload[len(photoshopPrefix):], PhotoshopIPTC)
+		}
+	}
+	return blocks
+}
+
+// photoshopResource extracts one image resource block ("8BIM") by ID
+func photoshopResource(b []byte, id uint16) []byte {
+	for _, res := range ParsePhotoshopResources(b) {
+		if res.ID == id {
+			return res.Data
+		}
+	}
+	return nil
+}
+
+func scanTIFF(b []byte) Blocks {
+	blocks := Blocks{Format: FormatTIFF, EXIF: b}
+	if e, err := ParseEXIF(b); err == nil {
+		if t, ok := find(e.IFD0, TagXMP); ok {
+			blocks.XMP = t.Value
+		}
+		if t, ok := find(e.IFD0, TagIPTC); ok {
+			blocks.IPTC = t.Value
+		}
+	}
+	return blocks
+}
+
+// PNGChunk is one chunk of a PNG file
+type PNGChunk struct {
+	Type string
+	// Offset and End bound the whole chunk including length, type and CRC
+	Offset, End int
+	Data        []byte
+}
+
+// PNGChunks lists the complete chunks contained in b
+func PNGChunks(b []byte) []PNGChunk {
+	var chunks []PNGChunk
+	pos := len(pngSignature)
+	for pos+12 <= len(b) {
+		length := int(binary.BigEndian.Uint32(b[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(b) {
+			break
+		}
+		chunk := PNGChunk{Type: string(b[pos+4 : pos+8]), Offset: pos, End: end, Data: b[pos+8 : pos+8+length]}
+		chunks = append(chunks, chunk)
+		pos = end
+		if chunk.Type == "IEND" {
+			break
+		}
+	}
+	return chunks
+}
+
+func scanPNG(b []byte) Blocks {
+	blocks := Blocks{Format: FormatPNG}
+	for _, chunk := range PNGChunks(b) {
+		switch {
+		case chunk.Type == "eXIf":
+			blocks.EXIF = chunk.Data
+		case chunk.Type == "iTXt" && bytes.HasPrefix(chunk.Data, pngXMPKeyword):
+			// keyword NUL, compression flag, method, language NUL, translated keyword NUL, text
+			rest := chunk.Data[len(pngXMPKeyword):]
+			if len(rest) < 2 || rest[0] != 0 {
+				continue
+			}
+			rest = rest[2:]
+			for i := 0; i < 2; i++ {
+				idx := bytes.IndexByte(rest, 0)
+				if idx < 0 {
+					rest = nil
+					break
+				}
+				rest = rest[idx+1:]
+			}
+			blocks.XMP = rest
+		}
+	}
+	return blocks
+}
+
+// RIFFChunk is one top-level chunk of a WebP file
+type RIFFChunk struct {
+	FourCC string
+	// Offset and End bound the whole chunk including header and padding
+	Offset, End int
+	Data        []byte
+}
+
+// WebPChunks lists the complete top-level chunks contained in b
+func WebPChunks(b []byte) []RIFFChunk {
+	var chunks []RIFFChunk
+	pos := 12
+	for pos+8 <= len(b) {
+		size := int(binary.LittleEndian.Uint32(b[pos+4:]))
+		dataEnd := pos + 8 + size
+		if size < 0 || dataEnd > len(b) {
+			break
+		}
+		end := dataEnd + size%2
+		if end > len(b) {
+			end = len(b)
+		}
+		chunks = append(chunks, RIFFChunk{FourCC: string(b[pos : pos+4]), Offset: pos, End: end, Data: b[pos+8 : dataEnd]})
+		pos = end
+	}
+	return chunks
+}
+
+func scanWebP(b []byte) Blocks {
+	blocks := Blocks{Format: FormatWebP}
+	for _, chunk := range WebPChunks(b) {
+		switch chunk.FourCC {
+		case "EXIF":
+			blocks.EXIF = bytes.TrimPrefix(chunk.Data, jpegEXIFPrefix)
+		case "XMP ":
+			blocks.XMP = chunk.Data
+		}
+	}
+	return blocks
+}
+
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"mif1": true, "msf1": true, "avif": true, "avis": true,
+}
+
+func isHEIFBrand(b []byte) bool {
+	size := int(binary.BigEndian.Uint32(b))
+	if size < 16 || size > len(b) {
+		size = len(b)
+	}
+	if heifBrands[string(b[8:12])] {
+		return true
+	}
+	for pos := 16; pos+4 <= size; pos += 4 {
+		if heifBrands[string(b[pos:pos+4])] {
+			return true
+		}
+	}
+	return false
+}
+
+// isoBox is an ISO base media file format box
+type isoBox struct {
+	typ  string
+	data []byte
+}
+
+// isoBoxes splits b into boxes; truncated trailing boxes are dropped
+func isoBoxes(b []byte) []isoBox {
+	var boxes []isoBox
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return boxes
+		}
+		boxes = append(boxes, isoBox{typ: typ, data: b[header:size]})
+		b = b[size:]
+	}
+	return boxes
+}
+
+type heifItem struct {
+	itemType    string
+	contentType string
+	extents     [][2]uint64
+}
+
+// scanHEIF resolves the Exif and XMP items listed in the meta box
+func scanHEIF(b []byte) Blocks {
+	blocks := Blocks{Format: FormatHEIF}
+
+	var meta []byte
+	for _, box := range isoBoxes(b) {
+		if box.typ == "meta" && len(box.data) >= 4 {
+			meta = box.data[4:]
+			break
+		}
+	}
+	if meta == nil {
+		return blocks
+	}
+
+	items := make(map[uint32]*heifItem)
+	for _, box := range isoBoxes(meta) {
+		switch box.typ {
+		case "iinf":
+			parseIINF(box.data, items)
+		case "iloc":
+			parseILOC(box.data, items)
+		}
+	}
+
+	for _, item := range items {
+		data := item.read(b)
+		if data == nil {
+			continue
+		}
+		switch {
+		case item.itemType == "Exif" && len(data) >= 4:
+			offset := uint64(binary.BigEndian.Uint32(data)) + 4
+			if offset < uint64(len(data)) && blocks.EXIF == nil {
+				blocks.EXIF = data[offset:]
+			}
+		case item.itemType == "mime" && item.contentType == "application/rdf+xml" && blocks.XMP == nil:
+			blocks.XMP = data
+		}
+	}
+	return blocks
+}
+
+func (item *heifItem) read(b []byte) []byte {
+	if len(item.extents) == 0 {
+		return nil
+	}
+	var out []byte
+	for _, ext := range item.extents {
+		if ext[0]+ext[1] > uint64(len(b)) {
+			return nil
+		}
+		out = append(out, b[ext[0]:ext[0]+ext[1]]...)
+	}
+	return out
+}
+
+func parseIINF(b []byte, items map[uint32]*heifItem) {
+	if len(b) < 6 {
+		return
+	}
+	pos := 6
+	if b[0] != 0 {
+		pos = 8
+	}
+	if pos > len(b) {
+		return
+	}
+	for _, box := range isoBoxes(b[pos:]) {
+		if box.typ != "infe" || len(box.data) < 4 {
+			continue
+		}
+		version := box.data[0]
+		d := box.data[4:]
+		var id uint32
+		switch {
+		case version == 2 && len(d) >= 8:
+			id = uint32(binary.BigEndian.Uint16(d))
+			d = d[4:]
+		case version == 3 && len(d) >= 10:
+			id = binary.BigEndian.Uint32(d)
+			d = d[6:]
+		default:
+			continue
+		}
+		item := itemFor(items, id)
+		item.itemType = string(d[:4])
+		if item.itemType == "mime" {
+			// item_name NUL, content_type NUL
+			parts := bytes.SplitN(d[4:], []byte{0}, 3)
+			if len(parts) >= 2 {
+				item.contentType = string(parts[1])
+			}
+		}
+	}
+}
+
+func parseILOC(b []byte, items map[uint32]*heifItem) {
+	if len(b) < 8 {
+		return
+	}
+	version := b[0]
+	offsetSize := int(b[4] >> 4)
+	lengthSize := int(b[4] & 0x0F)
+	baseOffsetSize := int(b[5] >> 4)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(b[5] & 0x0F)
+	}
+
+	r := &byteReader{b: b, pos: 6}
+	var count uint64
+	if version < 2 {
+		count = r.uint(2)
+	} else {
+		count = r.uint(4)
+	}
+
+	for i := uint64(0); i < count && r.ok(); i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.uint(2))
+		} else {
+			id = uint32(r.uint(4))
+		}
+		construction := uint64(0)
+		if version == 1 || version == 2 {
+			construction = r.uint(2) & 0x0F
+		}
+		r.uint(2) // data_reference_index
+		base := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+
+		var extents [][2]uint64
+		for j := uint64(0); j < extentCount && r.ok(); j++ {
+			if indexSize > 0 {
+				r.uint(indexSize)
+			}
+			offset := r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			extents = append(extents, [2]uint64{base + offset, length})
+		}
+		// Only items stored at file offsets are supported
+		if construction == 0 && r.ok() {
+			itemFor(items, id).extents = extents
+		}
+	}
+}
+
+func itemFor(items map[uint32]*heifItem, id uint32) *heifItem {
+	item, ok := items[id]
+	if !ok {
+		item = &heifItem{}
+		items[id] = item
+	}
+	return item
+}
+
+// byteReader reads big-endian integers of 0, 2, 4 or 8 bytes
+type byteReader struct {
+	b   []byte
+	pos int
+	err bool
+}
+
+func (r *byteReader) uint(size int) uint64 {
+	if size == 0 {
+		return 0
+	}
+	if r.err || r.pos+size > len(r.b) {
+		r.err = true
+		return 0
+	}
+	var v uint64
+	switch size {
+	case 1:
+		v = uint64(r.b[r.pos])
+	case 2:
+		v = uint64(binary.BigEndian.Uint16(r.b[r.pos:]))
+	case 4:
+		v = uint64(binary.BigEndian.Uint32(r.b[r.pos:]))
+	case 8:
+		v = binary.BigEndian.Uint64(r.b[r.pos:])
+	default:
+		r.err = true
+		return 0
+	}
+	r.pos += size
+	return v
+}
+
+func (r *byteReader) ok() bool {
+	return !r.err
+}
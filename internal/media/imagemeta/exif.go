@@ -0,0 +1,515 @@
+// Package imagemeta locates and decodes the EXIF, XMP and IPTC metadata
+// embedded in image files.
+package imagemeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidEXIF is returned when a TIFF structure cannot be decoded
+var ErrInvalidEXIF = errors.New("invalid exif data")
+
+// TIFF field types
+const (
+	TypeByte      uint16 = 1
+	TypeASCII     uint16 = 2
+	TypeShort     uint16 = 3
+	TypeLong      uint16 = 4
+	TypeRational  uint16 = 5
+	TypeSByte     uint16 = 6
+	TypeUndefined uint16 = 7
+	TypeSShort    uint16 = 8
+	TypeSLong     uint16 = 9
+	TypeSRational uint16 = 10
+	TypeFloat     uint16 = 11
+	TypeDouble    uint16 = 12
+)
+
+var typeSizes = map[uint16]uint32{
+	TypeByte: 1, TypeASCII: 1, TypeShort: 2, TypeLong: 4, TypeRational: 8,
+	TypeSByte: 1, TypeUndefined: 1, TypeSShort: 2, TypeSLong: 4, TypeSRational: 8,
+	TypeFloat: 4, TypeDouble: 8,
+}
+
+// Tag IDs used by this package
+const (
+	TagImageDescription  uint16 = 0x010E
+	TagMake              uint16 = 0x010F
+	TagModel             uint16 = 0x0110
+	TagOrientation       uint16 = 0x0112
+	TagSoftware          uint16 = 0x0131
+	TagDateTime          uint16 = 0x0132
+	TagArtist            uint16 = 0x013B
+	TagXMP               uint16 = 0x02BC
+	TagCopyright         uint16 = 0x8298
+	TagExposureTime      uint16 = 0x829A
+	TagFNumber           uint16 = 0x829D
+	TagIPTC              uint16 = 0x83BB
+	TagExifIFD           uint16 = 0x8769
+	TagGPSIFD            uint16 = 0x8825
+	TagISO               uint16 = 0x8827
+	TagDateTimeOriginal  uint16 = 0x9003
+	TagOffsetTimeOrig    uint16 = 0x9011
+	TagFocalLength       uint16 = 0x920A
+	TagMakerNote         uint16 = 0x927C
+	TagUserComment       uint16 = 0x9286
+	TagPixelXDimension   uint16 = 0xA002
+	TagPixelYDimension   uint16 = 0xA003
+	TagInteropIFD        uint16 = 0xA005
+	TagCameraOwnerName   uint16 = 0xA430
+	TagBodySerialNumber  uint16 = 0xA431
+	TagLensMake          uint16 = 0xA433
+	TagLensModel         uint16 = 0xA434
+	TagLensSerialNumber  uint16 = 0xA435
+	TagGPSLatitudeRef    uint16 = 0x0001
+	TagGPSLatitude       uint16 = 0x0002
+	TagGPSLongitudeRef   uint16 = 0x0003
+	TagGPSLongitude      uint16 = 0x0004
+	TagGPSAltitudeRef    uint16 = 0x0005
+	TagGPSAltitude       uint16 = 0x0006
+	TagGPSImageDirection uint16 = 0x0011
+)
+
+// maxIFDEntries guards against corrupt entry counts
+const maxIFDEntries = 4096
+
+// Tag is one raw IFD entry; Value holds the field bytes in the byte order of
+// the file they came from
+type Tag struct {
+	ID    uint16
+	Type  uint16
+	Count uint32
+	Value []byte
+
+	order binary.ByteOrder
+}
+
+// EXIF holds the entries of IFD0, the Exif sub-IFD and the GPS sub-IFD
+type EXIF struct {
+	Order binary.ByteOrder
+	IFD0  []Tag
+	Exif  []Tag
+	GPS   []Tag
+
+	// Thumbnail reports that IFD0 links to a thumbnail IFD, which is not decoded
+	Thumbnail bool
+}
+
+// ParseEXIF decodes a TIFF-structured EXIF block, starting at its "II" or
+// "MM" byte order mark
+func ParseEXIF(b []byte) (*EXIF, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("%w: header too short", ErrInvalidEXIF)
+	}
+
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: unknown byte order", ErrInvalidEXIF)
+	}
+	if order.Uint16(b[2:]) != 42 {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidEXIF)
+	}
+
+	e := &EXIF{Order: order}
+	ifd0Offset := order.Uint32(b[4:])
+	ifd0, err := readIFD(b, order, ifd0Offset)
+	if err != nil {
+		return nil, err
+	}
+	e.IFD0 = ifd0
+	// readIFD has validated the entry count
+	if next := uint64(ifd0Offset) + 2 + uint64(order.Uint16(b[ifd0Offset:]))*12; next+4 <= uint64(len(b)) {
+		e.Thumbnail = order.Uint32(b[next:]) != 0
+	}
+
+	// Broken sub-IFDs are dropped rather than failing the whole block
+	if t, ok := find(ifd0, TagExifIFD); ok {
+		if off, ok := t.Uint(0); ok {
+			e.Exif, _ = readIFD(b, order, off)
+		}
+	}
+	if t, ok := find(ifd0, TagGPSIFD); ok {
+		if off, ok := t.Uint(0); ok {
+			e.GPS, _ = readIFD(b, order, off)
+		}
+	}
+	return e, nil
+}
+
+func readIFD(b []byte, order binary.ByteOrder, offset uint32) ([]Tag, error) {
+	if uint64(offset)+2 > uint64(len(b)) {
+		return nil, fmt.Errorf("%w: ifd offset %d out of range", ErrInvalidEXIF, offset)
+	}
+	count := int(order.Uint16(b[offset:]))
+	if count > maxIFDEntries || int(offset)+2+count*12 > len(b) {
+		return nil, fmt.Errorf("%w: ifd at %d truncated", ErrInvalidEXIF, offset)
+	}
+
+	tags := make([]Tag, 0, count)
+	for i := 0; i < count; i++ {
+		entry := b[int(offset)+2+i*12:]
+		t := Tag{
+			ID:    order.Uint16(entry),
+			Type:  order.Uint16(entry[2:]),
+			Count: order.Uint32(entry[4:]),
+			order: order,
+		}
+		size, ok := typeSizes[t.Type]
+		if !ok {
+			continue
+		}
+		total := uint64(size) * uint64(t.Count)
+		if total <= 4 {
+			t.Value = append([]byte(nil), entry[8:8+total]...)
+		} else {
+			valueOffset := uint64(order.Uint32(entry[8:]))
+			if valueOffset+total > uint64(len(b)) {
+				continue
+			}
+			t.Value = append([]byte(nil), b[valueOffset:valueOffset+total]...)
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+func find(tags []Tag, id uint16) (Tag, bool) {
+	for _, t := range tags {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Tag{}, false
+}
+
+// Lookup returns the first tag with the given ID in IFD0 or the Exif sub-IFD
+func (e *EXIF) Lookup(id uint16) (Tag, bool) {
+	if t, ok := find(e.IFD0, id); ok {
+		return t, true
+	}
+	return find(e.Exif, id)
+}
+
+// LookupGPS returns a tag from the GPS sub-IFD
+func (e *EXIF) LookupGPS(id uint16) (Tag, bool) {
+	return find(e.GPS, id)
+}
+
+// String returns an ASCII tag value without trailing NULs and padding
+func (e *EXIF) String(id uint16) string {
+	t, ok := e.Lookup(id)
+	if !ok {
+		return ""
+	}
+	return t.String()
+}
+
+// Orientation returns the EXIF orientation (1-8), defaulting to 1
+func (e *EXIF) Orientation() int {
+	if t, ok := e.Lookup(TagOrientation); ok {
+		if v, ok := t.Uint(0); ok && v >= 1 && v <= 8 {
+			return int(v)
+		}
+	}
+	return 1
+}
+
+// SetUint replaces the value of an unsigned integer tag in IFD0 or the Exif
+// sub-IFD with a single value, as a LONG when it does not fit the tag's
+// SHORT type. It reports whether the value changed; absent tags are left
+// absent.
+func (e *EXIF) SetUint(id uint16, v uint32) bool {
+	order := e.Order
+	if order == nil {
+		order = binary.LittleEndian
+	}
+	for _, tags := range [][]Tag{e.IFD0, e.Exif} {
+		for i, t := range tags {
+			if t.ID != id {
+				continue
+			}
+			if old, ok := t.Uint(0); ok && old == v && t.Count == 1 {
+				return false
+			}
+			t = Tag{ID: id, Type: TypeLong, Count: 1, Value: make([]byte, 4), order: order}
+			if v <= 0xFFFF && tags[i].Type == TypeShort {
+				t.Type, t.Value = TypeShort, make([]byte, 2)
+				order.PutUint16(t.Value, uint16(v))
+			} else {
+				order.PutUint32(t.Value, v)
+			}
+			tags[i] = t
+			return true
+		}
+	}
+	return false
+}
+
+// DateTimeOriginal returns the capture time. Without an OffsetTimeOriginal
+// tag the wall-clock time is interpreted as UTC.
+func (e *EXIF) DateTimeOriginal() (time.Time, bool) {
+	value := e.String(TagDateTimeOriginal)
+	if value == "" {
+		value = e.String(TagDateTime)
+	}
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if offset := e.String(TagOffsetTimeOrig); offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t, true
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Coordinates returns the GPS latitude and longitude in decimal degrees
+func (e *EXIF) Coordinates() (lat, lon float64, ok bool) {
+	latTag, ok1 := e.LookupGPS(TagGPSLatitude)
+	lonTag, ok2 := e.LookupGPS(TagGPSLongitude)
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+	lat, ok1 = latTag.degrees()
+	lon, ok2 = lonTag.degrees()
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+	if ref, ok := e.LookupGPS(TagGPSLatitudeRef); ok && strings.HasPrefix(ref.String(), "S") {
+		lat = -lat
+	}
+	if ref, ok := e.LookupGPS(TagGPSLongitudeRef); ok && strings.HasPrefix(ref.String(), "W") {
+		lon = -lon
+	}
+	return lat, lon, true
+}
+
+// Altitude returns the GPS altitude in meters, negative below sea level
+func (e *EXIF) Altitude() (float64, bool) {
+	t, ok := e.LookupGPS(TagGPSAltitude)
+	if !ok {
+		return 0, false
+	}
+	alt, ok := t.Float(0)
+	if !ok {
+		return 0, false
+	}
+	if ref, ok := e.LookupGPS(TagGPSAltitudeRef); ok {
+		if v, ok := ref.Uint(0); ok && v == 1 {
+			alt = -alt
+		}
+	}
+	return alt, true
+}
+
+// String returns the tag value as text
+func (t Tag) String() string {
+	return strings.TrimSpace(strings.TrimRight(string(t.Value), "\x00"))
+}
+
+// Uint returns the i-th value of an unsigned integer tag
+func (t Tag) Uint(i int) (uint32, bool) {
+	switch t.Type {
+	case TypeByte, TypeUndefined:
+		if i < len(t.Value) {
+			return uint32(t.Value[i]), true
+		}
+	case TypeShort:
+		if (i+1)*2 <= len(t.Value) {
+			return uint32(t.order.Uint16(t.Value[i*2:])), true
+		}
+	case TypeLong:
+		if (i+1)*4 <= len(t.Value) {
+			return t.order.Uint32(t.Value[i*4:]), true
+		}
+	}
+	return 0, false
+}
+
+// Rational returns the numerator and denominator of the i-th rational value
+func (t Tag) Rational(i int) (num, den int64, ok bool) {
+	if (t.Type != TypeRational && t.Type != TypeSRational) || (i+1)*8 > len(t.Value) {
+		return 0, 0, false
+	}
+	n, d := t.order.Uint32(t.Value[i*8:]), t.order.Uint32(t.Value[i*8+4:])
+	if t.Type == TypeSRational {
+		return int64(int32(n)), int64(int32(d)), true
+	}
+	return int64(n), int64(d), true
+}
+
+// Float returns the i-th value of a numeric tag as a float
+func (t Tag) Float(i int) (float64, bool) {
+	switch t.Type {
+	case TypeRational, TypeSRational:
+		n, d, ok := t.Rational(i)
+		if !ok || d == 0 {
+			return 0, false
+		}
+		return float64(n) / float64(d), true
+	case TypeFloat:
+		if (i+1)*4 <= len(t.Value) {
+			return float64(math.Float32frombits(t.order.Uint32(t.Value[i*4:]))), true
+		}
+	case TypeDouble:
+		if (i+1)*8 <= len(t.Value) {
+			return math.Float64frombits(t.order.Uint64(t.Value[i*8:])), true
+		}
+	default:
+		if v, ok := t.Uint(i); ok {
+			return float64(v), true
+		}
+	}
+	return 0, false
+}
+
+// degrees converts a degrees/minutes/seconds rational triple
+func (t Tag) degrees() (float64, bool) {
+	d, ok1 := t.Float(0)
+	m, ok2 := t.Float(1)
+	s, ok3 := t.Float(2)
+	if !ok1 || !ok2 || !ok3 {
+		return 0, false
+	}
+	return d + m/60 + s/3600, true
+}
+
+// Encode serializes IFD0 and the Exif and GPS sub-IFDs as a TIFF block in
+// e.Order, which must be the byte order of the tag values. Sub-IFD pointers
+// are regenerated from the tag lists; the thumbnail and interoperability
+// IFDs are not written.
+func (e *EXIF) Encode() []byte {
+	order := e.Order
+	if order == nil {
+		order = binary.LittleEndian
+	}
+
+	ifd0 := withoutTags(e.IFD0, TagExifIFD, TagGPSIFD)
+	exif := withoutTags(e.Exif, TagInteropIFD)
+	gps := e.GPS
+
+	pointer := func(id uint16) Tag {
+		return Tag{ID: id, Type: TypeLong, Count: 1, Value: make([]byte, 4), order: order}
+	}
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, pointer(TagExifIFD))
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, pointer(TagGPSIFD))
+	}
+
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset
+	if len(exif) > 0 {
+		gpsOffset += ifdSize(exif)
+	}
+	for _, t := range ifd0 {
+		switch t.ID {
+		case TagExifIFD:
+			order.PutUint32(t.Value, uint32(exifOffset))
+		case TagGPSIFD:
+			order.PutUint32(t.Value, uint32(gpsOffset))
+		}
+	}
+
+	out := make([]byte, 8, gpsOffset+ifdSize(gps))
+	if order == binary.LittleEndian {
+		copy(out, "II")
+	} else {
+		copy(out, "MM")
+	}
+	order.PutUint16(out[2:], 42)
+	order.PutUint32(out[4:], 8)
+
+	out = appendIFD(out, order, ifd0)
+	if len(exif) > 0 {
+		out = appendIFD(out, order, exif)
+	}
+	if len(gps) > 0 {
+		out = appendIFD(out, order, gps)
+	}
+	return out
+}
+
+func withoutTags(tags []Tag, ids ...uint16) []Tag {
+	out := make([]Tag, 0, len(tags))
+	for _, t := range tags {
+		skip := false
+		for _, id := range ids {
+			if t.ID == id {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// ifdSize is the encoded size of an IFD including out-of-line values
+func ifdSize(tags []Tag) int {
+	size := 2 + len(tags)*12 + 4
+	for _, t := range tags {
+		if n := len(t.Value); n > 4 {
+			size += n + n%2
+		}
+	}
+	return size
+}
+
+// appendIFD writes the entries sorted by tag ID followed by their values
+func appendIFD(out []byte, order binary.ByteOrder, tags []Tag) []byte {
+	sorted := append([]Tag(nil), tags...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	dataOffset := len(out) + 2 + len(sorted)*12 + 4
+	var data []byte
+
+	var word [4]byte
+	putUint16 := func(v uint16) {
+		order.PutUint16(word[:], v)
+		out = append(out, word[:2]...)
+	}
+	putUint32 := func(v uint32) {
+		order.PutUint32(word[:], v)
+		out = append(out, word[:]...)
+	}
+
+	putUint16(uint16(len(sorted)))
+	for _, t := range sorted {
+		putUint16(t.ID)
+		putUint16(t.Type)
+		putUint32(t.Count)
+		if len(t.Value) <= 4 {
+			var inline [4]byte
+			copy(inline[:], t.Value)
+			out = append(out, inline[:]...)
+			continue
+		}
+		putUint32(uint32(dataOffset + len(data)))
+		data = append(data, t.Value...)
+		if len(t.Value)%2 != 0 {
+			data = append(data, 0)
+		}
+	}
+	putUint32(0)
+	return append(out, data...)
+}
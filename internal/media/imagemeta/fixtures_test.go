@@ -0,0 +1,366 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testTag is an IFD entry for the TIFF fixture builder
+type testTag struct {
+	id    uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiTag(id uint16, s string) testTag {
+	v := append([]byte(s), 0)
+	return testTag{id: id, typ: TypeASCII, count: uint32(len(v)), value: v}
+}
+
+func shortTag(order binary.ByteOrder, id uint16, v uint16) testTag {
+	b := make([]byte, 2)
+	order.PutUint16(b, v)
+	return testTag{id: id, typ: TypeShort, count: 1, value: b}
+}
+
+func byteTag(id uint16, v byte) testTag {
+	return testTag{id: id, typ: TypeByte, count: 1, value: []byte{v}}
+}
+
+func rationalTag(order binary.ByteOrder, id uint16, pairs ...uint32) testTag {
+	b := make([]byte, len(pairs)*4)
+	for i, p := range pairs {
+		order.PutUint32(b[i*4:], p)
+	}
+	return testTag{id: id, typ: TypeRational, count: uint32(len(pairs) / 2), value: b}
+}
+
+func undefinedTag(id uint16, v []byte) testTag {
+	return testTag{id: id, typ: TypeUndefined, count: uint32(len(v)), value: v}
+}
+
+// buildTIFF lays out IFD0 followed by the optional Exif and GPS sub-IFDs
+func buildTIFF(order binary.ByteOrder, ifd0, exif, gps []testTag) []byte {
+	ifdSize := func(tags []testTag) int {
+		size := 2 + len(tags)*12 + 4
+		for _, t := range tags {
+			if n := len(t.value); n > 4 {
+				size += n + n%2
+			}
+		}
+		return size
+	}
+
+	ifd0 = append([]testTag(nil), ifd0...)
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, testTag{id: TagExifIFD, typ: TypeLong, count: 1, value: make([]byte, 4)})
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, testTag{id: TagGPSIFD, typ: TypeLong, count: 1, value: make([]byte, 4)})
+	}
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset
+	if len(exif) > 0 {
+		gpsOffset += ifdSize(exif)
+	}
+	for i := range ifd0 {
+		switch ifd0[i].id {
+		case TagExifIFD:
+			order.PutUint32(ifd0[i].value, uint32(exifOffset))
+		case TagGPSIFD:
+			order.PutUint32(ifd0[i].value, uint32(gpsOffset))
+		}
+	}
+
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	_ = binary.Write(&buf, order, uint16(42))
+	_ = binary.Write(&buf, order, uint32(8))
+
+	writeIFD := func(tags []testTag) {
+		sort.Slice(tags, func(i, j int) bool { return tags[i].id < tags[j].id })
+		start := buf.Len()
+		dataOffset := start + 2 + len(tags)*12 + 4
+		var data bytes.Buffer
+
+		_ = binary.Write(&buf, order, uint16(len(tags)))
+		for _, t := range tags {
+			_ = binary.Write(&buf, order, t.id)
+			_ = binary.Write(&buf, order, t.typ)
+			_ = binary.Write(&buf, order, t.count)
+			if len(t.value) <= 4 {
+				v := make([]byte, 4)
+				copy(v, t.value)
+				buf.Write(v)
+				continue
+			}
+			_ = binary.Write(&buf, order, uint32(dataOffset+data.Len()))
+			data.Write(t.value)
+			if len(t.value)%2 != 0 {
+				data.WriteByte(0)
+			}
+		}
+		_ = binary.Write(&buf, order, uint32(0))
+		buf.Write(data.Bytes())
+	}
+
+	writeIFD(ifd0)
+	if len(exif) > 0 {
+		writeIFD(append([]testTag(nil), exif...))
+	}
+	if len(gps) > 0 {
+		writeIFD(append([]testTag(nil), gps...))
+	}
+	return buf.Bytes()
+}
+
+// sampleTIFF is a little-endian EXIF block with camera, date and GPS tags
+func sampleTIFF() []byte {
+	le := binary.LittleEndian
+	return buildTIFF(le,
+		[]testTag{
+			asciiTag(TagMake, "Fujifilm"),
+			asciiTag(TagModel, "X-T4"),
+			shortTag(le, TagOrientation, 6),
+			asciiTag(TagSoftware, "Firmware 1.2"),
+			asciiTag(TagArtist, "Alice"),
+		},
+		[]testTag{
+			asciiTag(TagDateTimeOriginal, "2023:08:14 18:32:05"),
+			asciiTag(TagOffsetTimeOrig, "+09:00"),
+			rationalTag(le, TagExposureTime, 1, 250),
+			rationalTag(le, TagFNumber, 28, 10),
+			shortTag(le, TagISO, 400),
+			rationalTag(le, TagFocalLength, 350, 10),
+			asciiTag(TagLensModel, "XF35mmF1.4 R"),
+			asciiTag(TagBodySerialNumber, "12345678"),
+			undefinedTag(TagMakerNote, []byte("FUJIFILM-private-maker-note")),
+		},
+		[]testTag{
+			asciiTag(TagGPSLatitudeRef, "N"),
+			rationalTag(le, TagGPSLatitude, 35, 1, 39, 1, 2940, 100),
+			asciiTag(TagGPSLongitudeRef, "E"),
+			rationalTag(le, TagGPSLongitude, 139, 1, 44, 1, 2880, 100),
+			byteTag(TagGPSAltitudeRef, 0),
+			rationalTag(le, TagGPSAltitude, 4050, 100),
+		},
+	)
+}
+
+const sampleXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    xmp:Rating="4"
+    photoshop:City="Tokyo">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Shibuya at dusk</rdf:li></rdf:Alt></dc:title>
+   <dc:subject><rdf:Bag><rdf:li>city</rdf:li><rdf:li>night</rdf:li></rdf:Bag></dc:subject>
+   <dc:creator><rdf:Seq><rdf:li>Alice Example</rdf:li></rdf:Seq></dc:creator>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+// sampleIPTC returns IIM records with keywords, caption and location
+func sampleIPTC() []byte {
+	var buf bytes.Buffer
+	add := func(dataset byte, value string) {
+		buf.Write([]byte{0x1C, 2, dataset})
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(value)))
+		buf.WriteString(value)
+	}
+	buf.Write([]byte{0x1C, 1, 90, 0, 3, 0x1B, 0x25, 0x47}) // record 1 charset
+	add(IPTCKeywords, "street")
+	add(IPTCKeywords, "night")
+	add(IPTCCaption, "Crossing in the rain")
+	add(IPTCByline, "Alice Example")
+	add(IPTCCountry, "Japan")
+	return buf.Bytes()
+}
+
+func samplePixels() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 30), G: uint8(y * 60), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// buildJPEG encodes a small image and inserts the metadata segments after SOI
+func buildJPEG(t *testing.T, exif []byte, xmp string, iptc []byte) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	require.NoError(t, jpeg.Encode(&encoded, samplePixels(), &jpeg.Options{Quality: 90}))
+
+	var segments bytes.Buffer
+	if exif != nil {
+		segments.Write(jpegSegment(0xE1, append([]byte("Exif\x00\x00"), exif...)))
+	}
+	if xmp != "" {
+		segments.Write(jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)))
+	}
+	if iptc != nil {
+		var resource bytes.Buffer
+		resource.WriteString("Photoshop 3.0\x00")
+		resource.WriteString("8BIM")
+		_ = binary.Write(&resource, binary.BigEndian, uint16(0x0404))
+		resource.Write([]byte{0, 0}) // empty pascal name, padded
+		_ = binary.Write(&resource, binary.BigEndian, uint32(len(iptc)))
+		resource.Write(iptc)
+		if len(iptc)%2 != 0 {
+			resource.WriteByte(0)
+		}
+		segments.Write(jpegSegment(0xED, resource.Bytes()))
+	}
+
+	out := append([]byte{0xFF, 0xD8}, segments.Bytes()...)
+	return append(out, encoded.Bytes()[2:]...)
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], typ)
+	chunk = append(chunk, data...)
+	crc := crc32.NewIEEE()
+	crc.Write(chunk[4:])
+	return binary.BigEndian.AppendUint32(chunk, crc.Sum32())
+}
+
+// buildPNG encodes a small image and inserts eXIf, iTXt XMP and a tEXt chunk after IHDR
+func buildPNG(t *testing.T, exif []byte, xmp string) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, samplePixels()))
+	b := encoded.Bytes()
+
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(b[8:]))
+	out := append([]byte(nil), b[:ihdrEnd]...)
+	if exif != nil {
+		out = append(out, pngChunk("eXIf", exif)...)
+	}
+	if xmp != "" {
+		out = append(out, pngChunk("iTXt", append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...))...)
+	}
+	out = append(out, pngChunk("tEXt", []byte("Author\x00Alice Example"))...)
+	return append(out, b[ihdrEnd:]...)
+}
+
+func riffChunk(fourCC string, data []byte) []byte {
+	chunk := []byte(fourCC)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 != 0 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// buildWebP assembles an extended-format WebP container around opaque image data
+func buildWebP(exif []byte, xmp string) []byte {
+	vp8x := make([]byte, 10)
+	if exif != nil {
+		vp8x[0] |= 0x08
+	}
+	if xmp != "" {
+		vp8x[0] |= 0x04
+	}
+
+	body := []byte("WEBP")
+	body = append(body, riffChunk("VP8X", vp8x)...)
+	body = append(body, riffChunk("VP8L", []byte{0x2F, 0x00, 0x00, 0x00, 0x00, 0x01, 0x02})...)
+	if exif != nil {
+		body = append(body, riffChunk("EXIF", exif)...)
+	}
+	if xmp != "" {
+		body = append(body, riffChunk("XMP ", []byte(xmp))...)
+	}
+
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(body)))
+	return append(out, body...)
+}
+
+func isoBoxBytes(typ string, payload ...[]byte) []byte {
+	var body []byte
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	box = append(box, typ...)
+	return append(box, body...)
+}
+
+// buildHEIF assembles a minimal HEIF file whose Exif and XMP items live in mdat
+func buildHEIF(exif []byte, xmp string) []byte {
+	exifItem := append([]byte{0, 0, 0, 6}, append([]byte("Exif\x00\x00"), exif...)...)
+	xmpItem := []byte(xmp)
+
+	infe := func(id uint16, typ string, extra []byte) []byte {
+		p := []byte{2, 0, 0, 0}
+		p = binary.BigEndian.AppendUint16(p, id)
+		p = append(p, 0, 0)
+		p = append(p, typ...)
+		p = append(p, 0) // empty item name
+		return isoBoxBytes("infe", append(p, extra...))
+	}
+	iinf := isoBoxBytes("iinf", []byte{0, 0, 0, 0, 0, 2},
+		infe(1, "Exif", nil),
+		infe(2, "mime", []byte("application/rdf+xml\x00")))
+
+	ftyp := isoBoxBytes("ftyp", []byte("heic"), []byte{0, 0, 0, 0}, []byte("mif1heic"))
+
+	// iloc v0 with 4-byte offsets and lengths; offsets patched below
+	ilocPayload := func(exifOffset, xmpOffset uint32) []byte {
+		p := []byte{0, 0, 0, 0, 0x44, 0x00}
+		p = binary.BigEndian.AppendUint16(p, 2)
+		for _, item := range []struct {
+			id     uint16
+			offset uint32
+			length int
+		}{{1, exifOffset, len(exifItem)}, {2, xmpOffset, len(xmpItem)}} {
+			p = binary.BigEndian.AppendUint16(p, item.id)
+			p = binary.BigEndian.AppendUint16(p, 0) // data_reference_index
+			p = binary.BigEndian.AppendUint16(p, 1) // extent_count
+			p = binary.BigEndian.AppendUint32(p, item.offset)
+			p = binary.BigEndian.AppendUint32(p, uint32(item.length))
+		}
+		return p
+	}
+
+	meta := func(exifOffset, xmpOffset uint32) []byte {
+		return isoBoxBytes("meta", []byte{0, 0, 0, 0}, iinf, isoBoxBytes("iloc", ilocPayload(exifOffset, xmpOffset)))
+	}
+
+	prefix := len(ftyp) + len(meta(0, 0)) + 8
+	m := meta(uint32(prefix), uint32(prefix+len(exifItem)))
+	out := append(append([]byte(nil), ftyp...), m...)
+	return append(out, isoBoxBytes("mdat", exifItem, xmpItem)...)
+}
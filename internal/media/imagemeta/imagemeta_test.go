@@ -0,0 +1,231 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEXIF(t *testing.T) {
+	e, err := ParseEXIF(sampleTIFF())
+	require.NoError(t, err)
+
+	assert.Equal(t, "Fujifilm", e.String(TagMake))
+	assert.Equal(t, "X-T4", e.String(TagModel))
+	assert.Equal(t, "XF35mmF1.4 R", e.String(TagLensModel))
+	assert.Equal(t, 6, e.Orientation())
+
+	captured, ok := e.DateTimeOriginal()
+	require.True(t, ok)
+	assert.True(t, captured.Equal(time.Date(2023, 8, 14, 9, 32, 5, 0, time.UTC)))
+
+	lat, lon, ok := e.Coordinates()
+	require.True(t, ok)
+	assert.InDelta(t, 35.658167, lat, 1e-5)
+	assert.InDelta(t, 139.741333, lon, 1e-5)
+
+	alt, ok := e.Altitude()
+	require.True(t, ok)
+	assert.InDelta(t, 40.5, alt, 1e-9)
+
+	tag, ok := e.Lookup(TagExposureTime)
+	require.True(t, ok)
+	num, den, ok := tag.Rational(0)
+	require.True(t, ok)
+	assert.Equal(t, []int64{1, 250}, []int64{num, den})
+}
+
+func TestParseEXIF_BigEndianAndReferences(t *testing.T) {
+	be := binary.BigEndian
+	b := buildTIFF(be,
+		[]testTag{asciiTag(TagModel, "Pixel"), asciiTag(TagDateTime, "2020:01:02 03:04:05")},
+		nil,
+		[]testTag{
+			asciiTag(TagGPSLatitudeRef, "S"),
+			rationalTag(be, TagGPSLatitude, 33, 1, 52, 1, 0, 1),
+			asciiTag(TagGPSLongitudeRef, "W"),
+			rationalTag(be, TagGPSLongitude, 70, 1, 30, 1, 0, 1),
+			byteTag(TagGPSAltitudeRef, 1),
+			rationalTag(be, TagGPSAltitude, 10, 1),
+		},
+	)
+
+	e, err := ParseEXIF(b)
+	require.NoError(t, err)
+	assert.Equal(t, "Pixel", e.String(TagModel))
+	assert.Equal(t, 1, e.Orientation())
+
+	captured, ok := e.DateTimeOriginal()
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), captured)
+
+	lat, lon, ok := e.Coordinates()
+	require.True(t, ok)
+	assert.InDelta(t, -33.866667, lat, 1e-5)
+	assert.InDelta(t, -70.5, lon, 1e-5)
+
+	alt, _ := e.Altitude()
+	assert.Equal(t, -10.0, alt)
+}
+
+func TestParseEXIF_Invalid(t *testing.T) {
+	_, err := ParseEXIF([]byte("nope"))
+	assert.ErrorIs(t, err, ErrInvalidEXIF)
+
+	_, err = ParseEXIF([]byte("II*\x00\xff\xff\x00\x00"))
+	assert.ErrorIs(t, err, ErrInvalidEXIF)
+
+	// Truncated value data is skipped, not fatal
+	b := sampleTIFF()
+	e, err := ParseEXIF(b[:100])
+	require.NoError(t, err)
+	assert.Equal(t, 6, e.Orientation())
+	assert.Empty(t, e.GPS)
+}
+
+func TestScan_JPEG(t *testing.T) {
+	b := buildJPEG(t, sampleTIFF(), sampleXMP, sampleIPTC())
+
+	blocks, err := Scan(b)
+	require.NoError(t, err)
+	assert.Equal(t, FormatJPEG, blocks.Format)
+	assert.Equal(t, sampleTIFF(), blocks.EXIF)
+	assert.Equal(t, sampleXMP, string(blocks.XMP))
+	assert.Equal(t, sampleIPTC(), blocks.IPTC)
+
+	segments, sos := JPEGSegments(b)
+	assert.Greater(t, sos, 0)
+	assert.Equal(t, byte(0xE1), segments[0].Marker)
+}
+
+func TestScan_TIFF(t *testing.T) {
+	blocks, err := Scan(sampleTIFF())
+	require.NoError(t, err)
+	assert.Equal(t, FormatTIFF, blocks.Format)
+	assert.Equal(t, sampleTIFF(), blocks.EXIF)
+}
+
+func TestScan_HEIF(t *testing.T) {
+	blocks, err := Scan(buildHEIF(sampleTIFF(), sampleXMP))
+	require.NoError(t, err)
+	assert.Equal(t, FormatHEIF, blocks.Format)
+	assert.Equal(t, sampleTIFF(), blocks.EXIF)
+	assert.Equal(t, sampleXMP, string(blocks.XMP))
+}
+
+func TestScan_PNGAndWebP(t *testing.T) {
+	blocks, err := Scan(buildPNG(t, sampleTIFF(), sampleXMP))
+	require.NoError(t, err)
+	assert.Equal(t, FormatPNG, blocks.Format)
+	assert.Equal(t, sampleTIFF(), blocks.EXIF)
+	assert.Equal(t, sampleXMP, string(blocks.XMP))
+
+	blocks, err = Scan(buildWebP(sampleTIFF(), sampleXMP))
+	require.NoError(t, err)
+	assert.Equal(t, FormatWebP, blocks.Format)
+	assert.Equal(t, sampleTIFF(), blocks.EXIF)
+	assert.Equal(t, sampleXMP, string(blocks.XMP))
+}
+
+func TestScan_TruncatedAndUnknown(t *testing.T) {
+	b := buildJPEG(t, sampleTIFF(), sampleXMP, nil)
+	blocks, err := Scan(b[:40])
+	require.NoError(t, err)
+	assert.Nil(t, blocks.EXIF)
+
+	_, err = Scan([]byte("GIF89a"))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestParseXMP(t *testing.T) {
+	x, err := ParseXMP([]byte(sampleXMP))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Shibuya at dusk", x.First("dc:title"))
+	assert.Equal(t, []string{"city", "night"}, x["dc:subject"])
+	assert.Equal(t, "Alice Example", x.First("dc:creator"))
+	assert.Equal(t, "4", x.First("xmp:Rating"))
+	assert.Equal(t, "Tokyo", x.First("photoshop:City"))
+	assert.Equal(t, "", x.First("dc:description"))
+
+	_, err = ParseXMP([]byte("<x:xmpmeta><rdf:RDF"))
+	assert.Error(t, err)
+}
+
+func TestParseIPTC(t *testing.T) {
+	i := ParseIPTC(sampleIPTC())
+
+	assert.Equal(t, []string{"street", "night"}, i[IPTCKeywords])
+	assert.Equal(t, "Crossing in the rain", i.First(IPTCCaption))
+	assert.Equal(t, "Alice Example", i.First(IPTCByline))
+	assert.Equal(t, "Japan", i.First(IPTCCountry))
+	assert.Empty(t, i[90], "record 1 datasets are ignored")
+
+	latin := ParseIPTC([]byte{0x1C, 2, IPTCCity, 0, 6, 'Z', 0xFC, 'r', 'i', 'c', 'h'})
+	assert.Equal(t, "Zürich", latin.First(IPTCCity))
+}
+
+func TestEXIF_EncodeRoundTrip(t *testing.T) {
+	e, err := ParseEXIF(sampleTIFF())
+	require.NoError(t, err)
+
+	e.GPS = nil
+	e.Exif = withoutTags(e.Exif, TagMakerNote)
+	encoded, err := ParseEXIF(e.Encode())
+	require.NoError(t, err)
+
+	assert.Equal(t, "Fujifilm", encoded.String(TagMake))
+	assert.Equal(t, 6, encoded.Orientation())
+	_, ok := encoded.DateTimeOriginal()
+	assert.True(t, ok)
+	_, _, ok = encoded.Coordinates()
+	assert.False(t, ok)
+	_, ok = encoded.Lookup(TagMakerNote)
+	assert.False(t, ok)
+	assert.Equal(t, "12345678", encoded.String(TagBodySerialNumber))
+}
+
+func TestStripXMP(t *testing.T) {
+	remove := func(name string) bool {
+		return name == "photoshop:City" || name == "dc:creator"
+	}
+	out, removed, err := StripXMP([]byte(sampleXMP), remove)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"photoshop:City", "dc:creator"}, removed)
+
+	x, err := ParseXMP(out)
+	require.NoError(t, err)
+	assert.Empty(t, x["photoshop:City"])
+	assert.Empty(t, x["dc:creator"])
+	assert.Equal(t, "Shibuya at dusk", x.First("dc:title"))
+	assert.Equal(t, "4", x.First("xmp:Rating"))
+
+	same, removed, err := StripXMP([]byte(sampleXMP), func(string) bool { return false })
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.Equal(t, sampleXMP, string(same))
+}
+
+func TestIPTCAndPhotoshopRoundTrip(t *testing.T) {
+	datasets := ParseIPTCDatasets(sampleIPTC())
+	require.Len(t, datasets, 6)
+	assert.Equal(t, sampleIPTC(), EncodeIPTC(datasets))
+
+	resources := []PhotoshopResource{
+		{ID: PhotoshopIPTC, Data: sampleIPTC()},
+		{ID: 0x03ED, Name: "res", Data: []byte{1, 2, 3}},
+	}
+	parsed := ParsePhotoshopResources(EncodePhotoshopResources(resources))
+	assert.Equal(t, resources, parsed)
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "GPSLatitude", TagName(IFDGPS, TagGPSLatitude))
+	assert.Equal(t, "MakerNote", TagName(IFDExif, TagMakerNote))
+	assert.Equal(t, "0xBEEF", TagName(IFD0, 0xBEEF))
+	assert.Equal(t, "City", IPTCName(IPTCCity))
+	assert.Equal(t, "2:200", IPTCName(200))
+}
@@ -0,0 +1,113 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode/utf8"
+)
+
+// IPTC application record (2:xx) datasets
+const (
+	IPTCObjectName    = 5
+	IPTCKeywords      = 25
+	IPTCDateCreated   = 55
+	IPTCTimeCreated   = 60
+	IPTCByline        = 80
+	IPTCCity          = 90
+	IPTCProvinceState = 95
+	IPTCCountry       = 101
+	IPTCHeadline      = 105
+	IPTCCopyright     = 116
+	IPTCCaption       = 120
+)
+
+// IPTC maps application record dataset numbers to their values
+type IPTC map[int][]string
+
+// IPTCDataset is one raw IIM dataset
+type IPTCDataset struct {
+	Record  int
+	Dataset int
+	Value   []byte
+}
+
+// ParseIPTCDatasets splits an IIM block into datasets in file order,
+// stopping at the first malformed dataset
+func ParseIPTCDatasets(b []byte) []IPTCDataset {
+	var out []IPTCDataset
+	for len(b) >= 5 && b[0] == 0x1C {
+		record, dataset := int(b[1]), int(b[2])
+		size := int(binary.BigEndian.Uint16(b[3:]))
+		b = b[5:]
+
+		if size&0x8000 != 0 {
+			// Extended dataset: the low bits give the length of the length field
+			n := size & 0x7FFF
+			if n > 4 || n > len(b) {
+				break
+			}
+			size = 0
+			for _, c := range b[:n] {
+				size = size<<8 | int(c)
+			}
+			b = b[n:]
+		}
+		if size > len(b) {
+			break
+		}
+
+		out = append(out, IPTCDataset{Record: record, Dataset: dataset, Value: b[:size]})
+		b = b[size:]
+	}
+	return out
+}
+
+// EncodeIPTC serializes datasets as an IIM block
+func EncodeIPTC(datasets []IPTCDataset) []byte {
+	var out []byte
+	for _, ds := range datasets {
+		out = append(out, 0x1C, byte(ds.Record), byte(ds.Dataset))
+		if len(ds.Value) < 0x8000 {
+			out = binary.BigEndian.AppendUint16(out, uint16(len(ds.Value)))
+		} else {
+			out = append(out, 0x80, 0x04)
+			out = binary.BigEndian.AppendUint32(out, uint32(len(ds.Value)))
+		}
+		out = append(out, ds.Value...)
+	}
+	return out
+}
+
+// ParseIPTC decodes the application record (record 2) datasets of an IIM
+// block; datasets in other records are skipped
+func ParseIPTC(b []byte) IPTC {
+	out := make(IPTC)
+	for _, ds := range ParseIPTCDatasets(b) {
+		if ds.Record != 2 {
+			continue
+		}
+		value := strings.TrimSpace(string(ds.Value))
+		if !utf8.ValidString(value) {
+			value = latin1(ds.Value)
+		}
+		out[ds.Dataset] = append(out[ds.Dataset], value)
+	}
+	return out
+}
+
+// First returns the first value of a dataset
+func (i IPTC) First(dataset int) string {
+	if values := i[dataset]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// latin1 decodes ISO-8859-1 text, the default IIM character set
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return strings.TrimSpace(string(runes))
+}
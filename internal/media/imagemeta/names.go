@@ -0,0 +1,77 @@
+package imagemeta
+
+import "fmt"
+
+// IFD identifies which directory a tag belongs to
+type IFD string
+
+const (
+	IFD0    IFD = "ifd0"
+	IFDExif IFD = "exif"
+	IFDGPS  IFD = "gps"
+)
+
+var tagNames = map[IFD]map[uint16]string{
+	IFD0: {
+		0x0100: "ImageWidth", 0x0101: "ImageLength", 0x010E: "ImageDescription",
+		0x010F: "Make", 0x0110: "Model", 0x0112: "Orientation",
+		0x011A: "XResolution", 0x011B: "YResolution", 0x0128: "ResolutionUnit",
+		0x0131: "Software", 0x0132: "DateTime", 0x013B: "Artist",
+		0x013E: "WhitePoint", 0x013F: "PrimaryChromaticities", 0x0211: "YCbCrCoefficients",
+		0x0213: "YCbCrPositioning", 0x0214: "ReferenceBlackWhite", 0x02BC: "XMLPacket",
+		0x8298: "Copyright", 0x83BB: "IPTCNAA", 0x8769: "ExifIFDPointer", 0x8825: "GPSInfoIFDPointer",
+		0x9C9B: "XPTitle", 0x9C9C: "XPComment", 0x9C9D: "XPAuthor", 0x9C9E: "XPKeywords", 0x9C9F: "XPSubject",
+	},
+	IFDExif: {
+		0x829A: "ExposureTime", 0x829D: "FNumber", 0x8822: "ExposureProgram",
+		0x8827: "ISOSpeedRatings", 0x8830: "SensitivityType", 0x9000: "ExifVersion",
+		0x9003: "DateTimeOriginal", 0x9004: "DateTimeDigitized", 0x9010: "OffsetTime",
+		0x9011: "OffsetTimeOriginal", 0x9012: "OffsetTimeDigitized", 0x9101: "ComponentsConfiguration",
+		0x9201: "ShutterSpeedValue", 0x9202: "ApertureValue", 0x9203: "BrightnessValue",
+		0x9204: "ExposureBiasValue", 0x9205: "MaxApertureValue", 0x9207: "MeteringMode",
+		0x9208: "LightSource", 0x9209: "Flash", 0x920A: "FocalLength", 0x927C: "MakerNote",
+		0x9286: "UserComment", 0x9290: "SubSecTime", 0x9291: "SubSecTimeOriginal",
+		0x9292: "SubSecTimeDigitized", 0xA000: "FlashpixVersion", 0xA001: "ColorSpace",
+		0xA002: "PixelXDimension", 0xA003: "PixelYDimension", 0xA005: "InteroperabilityIFDPointer",
+		0xA217: "SensingMethod", 0xA300: "FileSource", 0xA301: "SceneType",
+		0xA401: "CustomRendered", 0xA402: "ExposureMode", 0xA403: "WhiteBalance",
+		0xA404: "DigitalZoomRatio", 0xA405: "FocalLengthIn35mmFilm", 0xA406: "SceneCaptureType",
+		0xA420: "ImageUniqueID", 0xA430: "CameraOwnerName", 0xA431: "BodySerialNumber",
+		0xA432: "LensSpecification", 0xA433: "LensMake", 0xA434: "LensModel",
+		0xA435: "LensSerialNumber",
+	},
+	IFDGPS: {
+		0x0000: "GPSVersionID", 0x0001: "GPSLatitudeRef", 0x0002: "GPSLatitude",
+		0x0003: "GPSLongitudeRef", 0x0004: "GPSLongitude", 0x0005: "GPSAltitudeRef",
+		0x0006: "GPSAltitude", 0x0007: "GPSTimeStamp", 0x0008: "GPSSatellites",
+		0x000C: "GPSSpeedRef", 0x000D: "GPSSpeed", 0x0010: "GPSImgDirectionRef",
+		0x0011: "GPSImgDirection", 0x0012: "GPSMapDatum", 0x001B: "GPSProcessingMethod",
+		0x001D: "GPSDateStamp", 0x001F: "GPSHPositioningError",
+	},
+}
+
+// TagName returns the EXIF name of a tag, or its hexadecimal ID when unknown
+func TagName(ifd IFD, id uint16) string {
+	if name, ok := tagNames[ifd][id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+var iptcNames = map[int]string{
+	5: "ObjectName", 7: "EditStatus", 10: "Urgency", 15: "Category", 20: "SupplementalCategories",
+	25: "Keywords", 40: "SpecialInstructions", 55: "DateCreated", 60: "TimeCreated",
+	62: "DigitalCreationDate", 63: "DigitalCreationTime", 65: "OriginatingProgram",
+	80: "By-line", 85: "By-lineTitle", 90: "City", 92: "Sub-location",
+	95: "Province-State", 100: "Country-PrimaryLocationCode", 101: "Country-PrimaryLocationName",
+	103: "OriginalTransmissionReference", 105: "Headline", 110: "Credit", 115: "Source",
+	116: "CopyrightNotice", 118: "Contact", 120: "Caption-Abstract", 122: "Writer-Editor",
+}
+
+// IPTCName returns the IIM name of an application record dataset
+func IPTCName(dataset int) string {
+	if name, ok := iptcNames[dataset]; ok {
+		return name
+	}
+	return fmt.Sprintf("2:%d", dataset)
+}
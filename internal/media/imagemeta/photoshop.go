@@ -0,0 +1,73 @@
+package imagemeta
+
+import "encoding/binary"
+
+// PhotoshopResource is one "8BIM" image resource block of a Photoshop IRB,
+// as stored in JPEG APP13 segments after PhotoshopHeader
+type PhotoshopResource struct {
+	ID   uint16
+	Name string
+	Data []byte
+}
+
+// ParsePhotoshopResources splits an image resource section into blocks,
+// stopping at the first malformed block
+func ParsePhotoshopResources(b []byte) []PhotoshopResource {
+	var out []PhotoshopResource
+	for len(b) >= 12 && string(b[:4]) == "8BIM" {
+		res := PhotoshopResource{ID: binary.BigEndian.Uint16(b[4:])}
+
+		// Pascal name, padded to an even length including the length byte
+		nameLen := int(b[6])
+		padded := nameLen + 1
+		if padded%2 != 0 {
+			padded++
+		}
+		pos := 6 + padded
+		if pos+4 > len(b) {
+			break
+		}
+		res.Name = string(b[7 : 7+nameLen])
+
+		size := int(binary.BigEndian.Uint32(b[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(b) {
+			break
+		}
+		res.Data = b[pos : pos+size]
+		out = append(out, res)
+
+		if size%2 != 0 {
+			size++
+		}
+		if pos+size > len(b) {
+			break
+		}
+		b = b[pos+size:]
+	}
+	return out
+}
+
+// EncodePhotoshopResources serializes resource blocks
+func EncodePhotoshopResources(resources []PhotoshopResource) []byte {
+	var out []byte
+	for _, res := range resources {
+		out = append(out, "8BIM"...)
+		out = binary.BigEndian.AppendUint16(out, res.ID)
+		name := res.Name
+		if len(name) > 255 {
+			name = name[:255]
+		}
+		out = append(out, byte(len(name)))
+		out = append(out, name...)
+		if (len(name)+1)%2 != 0 {
+			out = append(out, 0)
+		}
+		out = binary.BigEndian.AppendUint32(out, uint32(len(res.Data)))
+		out = append(out, res.Data...)
+		if len(res.Data)%2 != 0 {
+			out = append(out, 0)
+		}
+	}
+	return out
+}
@@ -0,0 +1,268 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Well-known XMP namespaces and the prefixes used for property names
+const (
+	NamespaceRDF          = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	NamespaceDC           = "http://purl.org/dc/elements/1.1/"
+	NamespaceXMP          = "http://ns.adobe.com/xap/1.0/"
+	NamespacePhotoshop    = "http://ns.adobe.com/photoshop/1.0/"
+	NamespaceEXIF         = "http://ns.adobe.com/exif/1.0/"
+	NamespaceTIFF         = "http://ns.adobe.com/tiff/1.0/"
+	NamespaceIptc4xmpCore = "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+	NamespaceXMPMM        = "http://ns.adobe.com/xap/1.0/mm/"
+	NamespaceLightroom    = "http://ns.adobe.com/lightroom/1.0/"
+	NamespaceAux          = "http://ns.adobe.com/exif/1.0/aux/"
+	NamespaceExifEX       = "http://cipa.jp/exif/1.0/"
+	NamespaceIptc4xmpExt  = "http://iptc.org/std/Iptc4xmpExt/2008-02-29/"
+)
+
+var xmpPrefixes = map[string]string{
+	NamespaceDC:           "dc",
+	NamespaceXMP:          "xmp",
+	NamespacePhotoshop:    "photoshop",
+	NamespaceEXIF:         "exif",
+	NamespaceTIFF:         "tiff",
+	NamespaceIptc4xmpCore: "Iptc4xmpCore",
+	NamespaceXMPMM:        "xmpMM",
+	NamespaceLightroom:    "lr",
+	NamespaceAux:          "aux",
+	NamespaceExifEX:       "exifEX",
+	NamespaceIptc4xmpExt:  "Iptc4xmpExt",
+}
+
+// XMP maps property names such as "dc:subject" to their values. Arrays
+// (rdf:Bag, rdf:Seq, rdf:Alt) yield one value per item; structured values
+// are flattened to their leaf properties.
+type XMP map[string][]string
+
+// ParseXMP decodes the simple and array properties of an XMP packet
+func ParseXMP(b []byte) (XMP, error) {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	dec.Strict = false
+
+	props := make(XMP)
+	// Stack of property names for the open elements; "" for rdf containers
+	var stack []string
+	var text strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid xmp: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			text.Reset()
+			name := ""
+			if t.Name.Space != NamespaceRDF && t.Name.Space != "adobe:ns:meta/" {
+				name = PropertyName(t.Name.Space, t.Name.Local)
+			}
+			// Shorthand properties appear as attributes of rdf:Description
+			if t.Name.Space == NamespaceRDF && t.Name.Local == "Description" {
+				for _, attr := range t.Attr {
+					if attr.Name.Space == "xmlns" || attr.Name.Space == NamespaceRDF || attr.Name.Space == "" {
+						continue
+					}
+					key := PropertyName(attr.Name.Space, attr.Name.Local)
+					props[key] = append(props[key], attr.Value)
+				}
+			}
+			stack = append(stack, name)
+
+		case xml.CharData:
+			text.Write(t)
+
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+			stack = stack[:len(stack)-1]
+			if value == "" {
+				continue
+			}
+			if name := innermostProperty(stack, t); name != "" {
+				props[name] = append(props[name], value)
+			}
+		}
+	}
+	return props, nil
+}
+
+// innermostProperty returns the property a closing element's text belongs
+// to: the element itself, or the nearest enclosing property for rdf:li
+func innermostProperty(stack []string, end xml.EndElement) string {
+	if end.Name.Space != NamespaceRDF {
+		return PropertyName(end.Name.Space, end.Name.Local)
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] != "" {
+			return stack[i]
+		}
+	}
+	return ""
+}
+
+// PropertyName returns "prefix:local" for known namespaces and the
+// namespace URI joined with the local name otherwise
+func PropertyName(space, local string) string {
+	if prefix, ok := xmpPrefixes[space]; ok {
+		return prefix + ":" + local
+	}
+	return space + local
+}
+
+// First returns the first value of a property
+func (x XMP) First(name string) string {
+	if values := x[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// StripXMP removes the top-level properties for which remove returns true,
+// whether written as elements or as rdf:Description attributes. The packet
+// text is edited in place so formatting and namespace declarations survive.
+// It returns the edited packet and the names of the removed properties.
+func StripXMP(packet []byte, remove func(name string) bool) ([]byte, []string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	dec.Strict = false
+
+	type element struct {
+		description bool
+		cut         bool
+		start       int
+	}
+	type edit struct {
+		start, end  int
+		replacement []byte
+	}
+
+	var (
+		scopes  []map[string]string
+		stack   []element
+		edits   []edit
+		removed []string
+		cutting bool
+	)
+	resolve := func(prefix string) string {
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if uri, ok := scopes[i][prefix]; ok {
+				return uri
+			}
+		}
+		return prefix
+	}
+
+	for {
+		start := int(dec.InputOffset())
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid xmp: %w", err)
+		}
+		end := int(dec.InputOffset())
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			scope := make(map[string]string)
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns":
+					scope[attr.Name.Local] = attr.Value
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					scope[""] = attr.Value
+				}
+			}
+			scopes = append(scopes, scope)
+
+			uri := resolve(t.Name.Space)
+			el := element{description: uri == NamespaceRDF && t.Name.Local == "Description", start: start}
+			inDescription := len(stack) > 0 && stack[len(stack)-1].description
+
+			if !cutting && inDescription {
+				if name := PropertyName(uri, t.Name.Local); remove(name) {
+					el.cut = true
+					cutting = true
+					removed = append(removed, name)
+				}
+			}
+			if el.description && !cutting {
+				tag := packet[start:end]
+				edited := tag
+				for _, attr := range t.Attr {
+					if attr.Name.Space == "" || attr.Name.Space == "xmlns" {
+						continue
+					}
+					attrURI := resolve(attr.Name.Space)
+					if attrURI == NamespaceRDF {
+						continue
+					}
+					if name := PropertyName(attrURI, attr.Name.Local); remove(name) {
+						edited = removeAttribute(edited, attr.Name.Space+":"+attr.Name.Local)
+						removed = append(removed, name)
+					}
+				}
+				if len(edited) != len(tag) {
+					edits = append(edits, edit{start: start, end: end, replacement: edited})
+				}
+			}
+			stack = append(stack, el)
+
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			el := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			scopes = scopes[:len(scopes)-1]
+			if el.cut {
+				edits = append(edits, edit{start: el.start, end: end})
+				cutting = false
+			}
+		}
+	}
+
+	if len(edits) == 0 {
+		return packet, nil, nil
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	out := make([]byte, 0, len(packet))
+	pos := 0
+	for _, e := range edits {
+		out = append(out, packet[pos:e.start]...)
+		out = append(out, e.replacement...)
+		pos = e.end
+	}
+	out = append(out, packet[pos:]...)
+	return out, removed, nil
+}
+
+// removeAttribute deletes the first qname="value" attribute from a start tag
+func removeAttribute(tag []byte, qname string) []byte {
+	re := regexp.MustCompile(`\s+` + regexp.QuoteMeta(qname) + `\s*=\s*("[^"]*"|'[^']*')`)
+	loc := re.FindIndex(tag)
+	if loc == nil {
+		return tag
+	}
+	out := append([]byte(nil), tag[:loc[0]]...)
+	return append(out, tag[loc[1]:]...)
+}
@@ -0,0 +1,196 @@
+// Package imaging decodes, orients, resizes and encodes still images using
+// only pure Go codecs.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // registers the WebP decoder
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+)
+
+// Formats produced by Encode
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+// ErrUnsupportedFormat is returned for images no registered codec can decode
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// ErrTooManyPixels is returned by Decode for images above the pixel limit
+var ErrTooManyPixels = errors.New("image exceeds pixel limit")
+
+// Decode decodes a JPEG, PNG, GIF or WebP image, the first frame for
+// animations, after checking its dimensions against maxPixels (0 for no
+// limit). It returns the image and the name of its format.
+func Decode(b []byte, maxPixels int) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, "", ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if maxPixels > 0 && cfg.Width*cfg.Height > maxPixels {
+		return nil, format, fmt.Errorf("%w: %dx%d", ErrTooManyPixels, cfg.Width, cfg.Height)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, format, err
+	}
+	return img, format, nil
+}
+
+// Orientation returns the EXIF orientation of an encoded image, or 1
+func Orientation(b []byte) int {
+	blocks, err := imagemeta.Scan(b)
+	if err != nil || blocks.EXIF == nil {
+		return 1
+	}
+	e, err := imagemeta.ParseEXIF(blocks.EXIF)
+	if err != nil {
+		return 1
+	}
+	if o := e.Orientation(); o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// Orient applies an EXIF orientation so the result displays upright
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	// Orientations 5-8 swap the axes
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:], row[x*4:x*4+4])
+		}
+	}
+	return dst
+}
+
+// toRGBA returns img as an RGBA image whose bounds start at the origin
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// FitSize scales w x h to fit inside maxW x maxH, preserving the aspect
+// ratio; it never enlarges unless upscale is set
+func FitSize(w, h, maxW, maxH int, upscale bool) (int, int) {
+	if w <= 0 || h <= 0 {
+		return 0, 0
+	}
+	if !upscale && w <= maxW && h <= maxH {
+		return w, h
+	}
+	scale := min(float64(maxW)/float64(w), float64(maxH)/float64(h))
+	return max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5))
+}
+
+// Fit scales img to fit inside maxW x maxH
+func Fit(img image.Image, maxW, maxH int, upscale bool) image.Image {
+	b := img.Bounds()
+	w, h := FitSize(b.Dx(), b.Dy(), maxW, maxH, upscale)
+	if w == b.Dx() && h == b.Dy() {
+		return img
+	}
+	return scale(img, b, w, h)
+}
+
+// Fill scales img to cover w x h and crops the centre to exactly that size
+func Fill(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return img
+	}
+
+	// Crop the source to the target aspect ratio first, then scale
+	srcW, srcH := b.Dx(), b.Dy()
+	cropW, cropH := srcW, srcW*h/w
+	if cropH > srcH {
+		cropW, cropH = srcH*w/h, srcH
+	}
+	x0 := b.Min.X + (srcW-cropW)/2
+	y0 := b.Min.Y + (srcH-cropH)/2
+	return scale(img, image.Rect(x0, y0, x0+cropW, y0+cropH), w, h)
+}
+
+func scale(img image.Image, src image.Rectangle, w, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+// Encode writes img in the given format; quality applies to JPEG (1-100)
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatGIF:
+		return gif.Encode(w, img, nil)
+	}
+	return fmt.Errorf("%w: cannot encode %q", ErrUnsupportedFormat, format)
+}
+
+// ContentType returns the MIME type of an Encode format
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Opaque reports whether every pixel of img is fully opaque
+func Opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
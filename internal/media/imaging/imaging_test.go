@@ -0,0 +1,133 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+)
+
+// tinyWebP is a 1x1 transparent lossless WebP
+var tinyWebP = []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+
+// gradient gives every pixel of a w x h image a distinct colour
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 40), G: uint8(y * 40), B: 7, A: 255})
+		}
+	}
+	return img
+}
+
+func TestOrient(t *testing.T) {
+	src := gradient(3, 2)
+	at := func(x, y int) color.Color { return src.At(x, y) }
+
+	tests := []struct {
+		orientation int
+		size        image.Point
+		topLeft     color.Color
+	}{
+		{1, image.Pt(3, 2), at(0, 0)},
+		{2, image.Pt(3, 2), at(2, 0)},
+		{3, image.Pt(3, 2), at(2, 1)},
+		{4, image.Pt(3, 2), at(0, 1)},
+		{5, image.Pt(2, 3), at(0, 0)},
+		{6, image.Pt(2, 3), at(0, 1)},
+		{7, image.Pt(2, 3), at(2, 1)},
+		{8, image.Pt(2, 3), at(2, 0)},
+	}
+	for _, tt := range tests {
+		out := Orient(src, tt.orientation)
+		assert.Equal(t, tt.size, out.Bounds().Size(), "orientation %d", tt.orientation)
+		assert.Equal(t, tt.topLeft, out.At(0, 0), "orientation %d", tt.orientation)
+	}
+
+	// Rotating clockwise moves the bottom-left pixel to the top-left
+	rotated := Orient(src, 6)
+	assert.Equal(t, at(0, 0), rotated.At(1, 0))
+	assert.Equal(t, at(2, 1), rotated.At(0, 2))
+}
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		w, h, maxW, maxH int
+		upscale          bool
+		wantW, wantH     int
+	}{
+		{4000, 3000, 2048, 2048, false, 2048, 1536},
+		{3000, 4000, 1024, 1024, false, 768, 1024},
+		{800, 600, 2048, 2048, false, 800, 600},
+		{800, 600, 1600, 1600, true, 1600, 1200},
+		{10000, 1, 100, 100, false, 100, 1},
+	}
+	for _, tt := range tests {
+		w, h := FitSize(tt.w, tt.h, tt.maxW, tt.maxH, tt.upscale)
+		assert.Equal(t, []int{tt.wantW, tt.wantH}, []int{w, h})
+	}
+}
+
+func TestFitAndFill(t *testing.T) {
+	src := gradient(40, 20)
+
+	assert.Equal(t, image.Pt(10, 5), Fit(src, 10, 10, false).Bounds().Size())
+	assert.Same(t, src, Fit(src, 100, 100, false))
+	assert.Equal(t, image.Pt(8, 8), Fill(src, 8, 8).Bounds().Size())
+	assert.Equal(t, image.Pt(30, 10), Fill(src, 30, 10).Bounds().Size())
+}
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, gradient(4, 3)))
+
+	img, format, err := Decode(buf.Bytes(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, image.Pt(4, 3), img.Bounds().Size())
+
+	_, _, err = Decode(buf.Bytes(), 11)
+	assert.ErrorIs(t, err, ErrTooManyPixels)
+
+	img, format, err = Decode(tinyWebP, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "webp", format)
+	assert.Equal(t, image.Pt(1, 1), img.Bounds().Size())
+	assert.False(t, Opaque(img))
+
+	_, _, err = Decode([]byte("not an image"), 0)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestOrientationAndEncode(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, gradient(4, 3), FormatJPEG, 80))
+	plain := buf.Bytes()
+	assert.Equal(t, 1, Orientation(plain))
+
+	e := &imagemeta.EXIF{Order: binary.BigEndian, IFD0: []imagemeta.Tag{{
+		ID: imagemeta.TagOrientation, Type: imagemeta.TypeShort, Count: 1,
+		Value: binary.BigEndian.AppendUint16(nil, 8),
+	}}}
+	app1 := append([]byte(imagemeta.JPEGEXIFHeader), e.Encode()...)
+	withEXIF := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	withEXIF = binary.BigEndian.AppendUint16(withEXIF, uint16(len(app1)+2))
+	withEXIF = append(withEXIF, app1...)
+	withEXIF = append(withEXIF, plain[2:]...)
+	assert.Equal(t, 8, Orientation(withEXIF))
+
+	_, err := jpeg.Decode(bytes.NewReader(withEXIF))
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, Encode(&buf, gradient(1, 1), "bmp", 0), ErrUnsupportedFormat)
+	assert.Equal(t, "image/png", ContentType(FormatPNG))
+}
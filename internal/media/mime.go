@@ -0,0 +1,65 @@
+package media
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// preferredExtensions overrides mime.ExtensionsByType, which returns
+// extensions in lexical order (".jfif" before ".jpg")
+var preferredExtensions = map[string]string{
+	"image/jpeg":       ".jpg",
+	"image/png":        ".png",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"image/heic":       ".heic",
+	"image/heif":       ".heif",
+	"image/avif":       ".avif",
+	"image/tiff":       ".tif",
+	"video/mp4":        ".mp4",
+	"video/quicktime":  ".mov",
+	"video/webm":       ".webm",
+	"video/x-matroska": ".mkv",
+	"video/3gpp":       ".3gp",
+	"audio/mpeg":       ".mp3",
+	"audio/mp4":        ".m4a",
+	"audio/flac":       ".flac",
+	"audio/ogg":        ".ogg",
+	"audio/aac":        ".aac",
+	"audio/wav":        ".wav",
+	"text/plain":       ".txt",
+	"text/html":        ".html",
+	"text/markdown":    ".md",
+}
+
+// Extension returns the conventional file extension for a content type, or ""
+func Extension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// TypeOf maps a content type to a media type, or "" when it is not media
+func TypeOf(contentType string) interfaces.MediaType {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return interfaces.MediaTypePhoto
+	case strings.HasPrefix(contentType, "video/"):
+		return interfaces.MediaTypeVideo
+	case strings.HasPrefix(contentType, "audio/"):
+		return interfaces.MediaTypeAudio
+	case strings.HasPrefix(contentType, "text/"):
+		return interfaces.MediaTypeText
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+package media
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func TestExtension(t *testing.T) {
+	assert.Equal(t, ".jpg", Extension("image/jpeg"))
+	assert.Equal(t, ".mov", Extension("video/quicktime"))
+	assert.Equal(t, ".png", Extension("image/png; charset=binary"))
+	assert.Equal(t, "", Extension(""))
+	assert.Equal(t, "", Extension("application/x-unknown-type"))
+}
+
+func TestTypeOf(t *testing.T) {
+	assert.Equal(t, interfaces.MediaTypePhoto, TypeOf("image/webp"))
+	assert.Equal(t, interfaces.MediaTypeVideo, TypeOf("video/mp4"))
+	assert.Equal(t, interfaces.MediaTypeAudio, TypeOf("audio/flac"))
+	assert.Equal(t, interfaces.MediaTypeText, TypeOf("text/html"))
+	assert.Equal(t, interfaces.MediaType(""), TypeOf("application/zip"))
+}
@@ -0,0 +1,46 @@
+package media
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Peek reads up to n bytes from rc and returns them together with a
+// replacement reader that yields the full, unconsumed stream. Closing the
+// replacement closes rc, and when rc is ReopenableContent so is the
+// replacement. The buffer grows as data arrives, so n may be a generous
+// limit.
+func Peek(rc io.ReadCloser, n int) ([]byte, io.ReadCloser, error) {
+	buf, err := io.ReadAll(io.LimitReader(rc, int64(n)))
+	if err != nil {
+		return nil, rc, err
+	}
+
+	peeked := &peekedReader{Reader: io.MultiReader(bytes.NewReader(buf), rc), closer: rc}
+	if r, ok := rc.(interfaces.ReopenableContent); ok {
+		return buf, &reopenablePeekedReader{peekedReader: peeked, source: r}, nil
+	}
+	return buf, peeked, nil
+}
+
+type peekedReader struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (p *peekedReader) Close() error {
+	return p.closer.Close()
+}
+
+// reopenablePeekedReader keeps the source's Reopen, so consumers can still
+// read stored content again from the start
+type reopenablePeekedReader struct {
+	*peekedReader
+	source interfaces.ReopenableContent
+}
+
+func (p *reopenablePeekedReader) Reopen() (io.ReadCloser, error) {
+	return p.source.Reopen()
+}
@@ -0,0 +1,121 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+type trackingCloser struct {
+	io.Reader
+	closed bool
+}
+
+func (t *trackingCloser) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestPeek(t *testing.T) {
+	src := &trackingCloser{Reader: strings.NewReader("hello, world")}
+
+	head, rc, err := Peek(src, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(head))
+
+	all, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(all))
+
+	require.NoError(t, rc.Close())
+	assert.True(t, src.closed)
+}
+
+// reopenable is stored content that can be read again from the start
+type reopenable struct {
+	trackingCloser
+	content string
+}
+
+func (r *reopenable) Reopen() (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(r.content)), nil
+}
+
+func TestPeek_KeepsReopenableContent(t *testing.T) {
+	src := &reopenable{trackingCloser: trackingCloser{Reader: strings.NewReader("hello, world")}, content: "hello, world"}
+
+	head, rc, err := Peek(src, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(head))
+
+	r, ok := rc.(interfaces.ReopenableContent)
+	require.True(t, ok, "the replacement is still reopenable")
+	again, err := r.Reopen()
+	require.NoError(t, err)
+	all, err := io.ReadAll(again)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(all))
+
+	all, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(all))
+	require.NoError(t, rc.Close())
+	assert.True(t, src.closed)
+
+	_, plain, err := Peek(io.NopCloser(strings.NewReader("abc")), 1)
+	require.NoError(t, err)
+	_, ok = plain.(interfaces.ReopenableContent)
+	assert.False(t, ok)
+}
+
+func TestPeek_ShortStream(t *testing.T) {
+	head, rc, err := Peek(io.NopCloser(strings.NewReader("abc")), 10)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(head))
+
+	all, _ := io.ReadAll(rc)
+	assert.Equal(t, "abc", string(all))
+}
+
+func TestPeek_ReadError(t *testing.T) {
+	failing := io.NopCloser(io.MultiReader(strings.NewReader("ab"), errReader{}))
+	_, rc, err := Peek(failing, 10)
+	assert.Error(t, err)
+	assert.NotNil(t, rc)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("boom") }
+
+func TestReadAll(t *testing.T) {
+	rc := &trackingCloser{Reader: strings.NewReader("12345")}
+	b, err := ReadAll(rc, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "12345", string(b))
+	assert.True(t, rc.closed)
+
+	_, err = ReadAll(io.NopCloser(strings.NewReader("123456")), 5)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := ContextReader(ctx, strings.NewReader("12345"))
+
+	buf := make([]byte, 2)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "12", string(buf[:n]))
+
+	cancel()
+	_, err = r.Read(buf)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,127 @@
+// Package phash computes 64-bit perceptual hashes of images. Visually
+// similar images, such as the same photo re-encoded or rescaled by another
+// platform, have hashes a small Hamming distance apart.
+package phash
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// Supported algorithms
+const (
+	// DHash compares the brightness of horizontally adjacent pixels
+	DHash = "dhash"
+	// PHash thresholds the low frequencies of a discrete cosine transform
+	PHash = "phash"
+)
+
+// Algorithms lists the supported algorithm names
+var Algorithms = []string{DHash, PHash}
+
+// Compute hashes img with the named algorithm
+func Compute(algorithm string, img image.Image) (uint64, error) {
+	switch algorithm {
+	case DHash:
+		return Difference(img), nil
+	case PHash:
+		return Perceptual(img), nil
+	}
+	return 0, fmt.Errorf("unknown perceptual hash algorithm %q", algorithm)
+}
+
+// Difference computes the dHash of img: a 9x8 greyscale thumbnail where
+// each bit records whether a pixel is brighter than its right neighbour
+func Difference(img image.Image) uint64 {
+	g := grey(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if g[y*9+x] > g[y*9+x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// Perceptual computes the pHash of img: the 8x8 lowest frequencies of the
+// DCT of a 32x32 greyscale thumbnail, thresholded at their median
+func Perceptual(img image.Image) uint64 {
+	const size, low = 32, 8
+	g := grey(img, size, size)
+
+	coeffs := make([]float64, 0, low*low)
+	for v := 0; v < low; v++ {
+		for u := 0; u < low; u++ {
+			coeffs = append(coeffs, dct(g, size, u, v))
+		}
+	}
+
+	// The DC term only reflects overall brightness and is left out of the median
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h uint64
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// dct returns the (u, v) coefficient of the 2D DCT-II of an n x n block
+func dct(g []float64, n, u, v int) float64 {
+	var sum float64
+	for y := 0; y < n; y++ {
+		cy := math.Cos(float64(2*y+1) * float64(v) * math.Pi / float64(2*n))
+		for x := 0; x < n; x++ {
+			cx := math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*n))
+			sum += g[y*n+x] * cx * cy
+		}
+	}
+	return sum
+}
+
+// grey scales img to w x h and returns its luminance row by row
+func grey(img image.Image, w, h int) []float64 {
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	out := make([]float64, w*h)
+	for i, p := range dst.Pix {
+		out[i] = float64(p)
+	}
+	return out
+}
+
+// Distance returns the number of differing bits between two hashes
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Format renders a hash as 16 lowercase hex digits
+func Format(h uint64) string {
+	return fmt.Sprintf("%016x", h)
+}
+
+// Parse reads a hash written by Format
+func Parse(s string) (uint64, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("perceptual hash must be 16 hex digits, got %q", s)
+	}
+	h, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid perceptual hash %q: %w", s, err)
+	}
+	return h, nil
+}
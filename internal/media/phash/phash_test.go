@@ -0,0 +1,82 @@
+package phash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/draw"
+)
+
+// scene draws soft shapes on a gradient, loosely resembling a photo
+func scene(w, h int, seed float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := 0.5 + 0.25*math.Sin(seed*fx*7) + 0.25*math.Cos(seed*fy*5+fx*3)
+			if math.Hypot(fx-0.3, fy-0.6) < 0.2 {
+				v = 1 - v
+			}
+			c := uint8(v * 255)
+			img.Set(x, y, color.RGBA{R: c, G: uint8(float64(c) * 0.8), B: uint8(255 * fy), A: 255})
+		}
+	}
+	return img
+}
+
+// reencode simulates another platform: downscale and lossy JPEG
+func reencode(t *testing.T, img image.Image, w, h, quality int) image.Image {
+	t.Helper()
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, small, &jpeg.Options{Quality: quality}))
+	out, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+	return out
+}
+
+func TestHashesMatchReencodedImages(t *testing.T) {
+	original := scene(320, 240, 3)
+	reencoded := reencode(t, original, 200, 150, 40)
+	other := scene(320, 240, 11)
+
+	for _, algorithm := range Algorithms {
+		a, err := Compute(algorithm, original)
+		require.NoError(t, err)
+		b, err := Compute(algorithm, reencoded)
+		require.NoError(t, err)
+		c, err := Compute(algorithm, other)
+		require.NoError(t, err)
+
+		assert.LessOrEqual(t, Distance(a, b), 6, algorithm)
+		assert.Greater(t, Distance(a, c), 16, algorithm)
+	}
+
+	_, err := Compute("ahash", original)
+	assert.Error(t, err)
+}
+
+func TestFormatParse(t *testing.T) {
+	h := uint64(0x00ff00ff12345678)
+	assert.Equal(t, "00ff00ff12345678", Format(h))
+
+	parsed, err := Parse(Format(h))
+	require.NoError(t, err)
+	assert.Equal(t, h, parsed)
+
+	_, err = Parse("123")
+	assert.Error(t, err)
+	_, err = Parse("zzzzzzzzzzzzzzzz")
+	assert.Error(t, err)
+
+	assert.Equal(t, 0, Distance(h, h))
+	assert.Equal(t, 64, Distance(0, math.MaxUint64))
+}
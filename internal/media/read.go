@@ -0,0 +1,42 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrTooLarge is returned by ReadAll when the content exceeds the limit
+var ErrTooLarge = errors.New("content exceeds size limit")
+
+// ReadAll reads rc into memory and closes it, failing with ErrTooLarge once
+// more than limit bytes arrive
+func ReadAll(rc io.ReadCloser, limit int64) ([]byte, error) {
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, ErrTooLarge
+	}
+	return b, nil
+}
+
+// ContextReader returns a reader that fails with the context error once ctx
+// is done, so a copy from r stops between reads
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,39 @@
+package media
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// RemoteName derives the path of the item relative to the root of a remote
+// output from its "path" or "filename" metadata, or from its ID and content
+// type. The result is cleaned and cannot escape the root.
+func RemoteName(data *interfaces.DataStream) (string, error) {
+	name, _ := data.Metadata["path"].(string)
+	if name == "" {
+		name, _ = data.Metadata["filename"].(string)
+	}
+	if name == "" {
+		if data.ID == "" {
+			return "", fmt.Errorf("data stream has no ID or filename")
+		}
+		name = data.ID + Extension(data.Headers["Content-Type"])
+	}
+
+	cleaned := path.Clean("/" + name)
+	if cleaned == "/" {
+		return "", fmt.Errorf("invalid remote name %q", name)
+	}
+	return strings.TrimPrefix(cleaned, "/"), nil
+}
+
+// SetMetadata records a value in the stream metadata, creating the map if needed
+func SetMetadata(data *interfaces.DataStream, key string, value interface{}) {
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	data.Metadata[key] = value
+}
@@ -0,0 +1,41 @@
+package media
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func TestRemoteName(t *testing.T) {
+	tests := []struct {
+		name string
+		data *interfaces.DataStream
+		want string
+	}{
+		{"path", &interfaces.DataStream{ID: "1", Metadata: map[string]interface{}{"path": "2024/beach.jpg", "filename": "x.jpg"}}, "2024/beach.jpg"},
+		{"filename", &interfaces.DataStream{ID: "1", Metadata: map[string]interface{}{"filename": "beach.jpg"}}, "beach.jpg"},
+		{"id and type", &interfaces.DataStream{ID: "post-1", Headers: map[string]string{"Content-Type": "image/png"}}, "post-1.png"},
+		{"escape", &interfaces.DataStream{Metadata: map[string]interface{}{"path": "../../etc/passwd"}}, "etc/passwd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RemoteName(tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := RemoteName(&interfaces.DataStream{})
+	assert.Error(t, err)
+	_, err = RemoteName(&interfaces.DataStream{Metadata: map[string]interface{}{"path": "/"}})
+	assert.Error(t, err)
+}
+
+func TestSetMetadata(t *testing.T) {
+	data := &interfaces.DataStream{}
+	SetMetadata(data, "remote_path", "a/b.jpg")
+	assert.Equal(t, "a/b.jpg", data.Metadata["remote_path"])
+}
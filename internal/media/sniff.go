@@ -0,0 +1,115 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+)
+
+// SniffLen is the number of leading bytes Sniff considers
+const SniffLen = 512
+
+// OctetStream is the content type of data Sniff cannot identify
+const OctetStream = "application/octet-stream"
+
+// Sniff returns the content type of data from its leading bytes. It
+// recognizes common image, video and audio containers by their magic bytes
+// and falls back to http.DetectContentType for everything else.
+func Sniff(head []byte) string {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "image/gif"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "image/tiff"
+	case riff(head, "WEBP"):
+		return "image/webp"
+	case riff(head, "WAVE"):
+		return "audio/wav"
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return "audio/ogg"
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "audio/mpeg"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// MPEG audio frame sync; layer bits of zero mean AAC in ADTS
+		if head[1]&0x06 == 0 {
+			return "audio/aac"
+		}
+		return "audio/mpeg"
+	}
+	if ct := sniffISOBMFF(head); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(head)
+}
+
+func riff(head []byte, form string) bool {
+	return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == form
+}
+
+// sniffISOBMFF identifies ISO base media files (MP4, QuickTime, HEIF) by
+// their ftyp brands
+func sniffISOBMFF(head []byte) string {
+	if len(head) < 12 {
+		return ""
+	}
+	switch string(head[4:8]) {
+	case "ftyp":
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		// QuickTime files may omit ftyp
+		return "video/quicktime"
+	default:
+		return ""
+	}
+
+	size := int(binary.BigEndian.Uint32(head))
+	if size < 16 || size > len(head) {
+		size = len(head)
+	}
+	brands := [][]byte{head[8:12]}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, head[i:i+4])
+	}
+
+	has := func(names ...string) bool {
+		for _, b := range brands {
+			for _, name := range names {
+				if string(b) == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch major := string(head[8:12]); {
+	case major == "avif" || major == "avis":
+		return "image/avif"
+	case has("heic", "heix", "heim", "heis", "hevc", "hevx"):
+		return "image/heic"
+	case major == "mif1" || major == "msf1":
+		if has("avif") {
+			return "image/avif"
+		}
+		return "image/heif"
+	case major == "qt  ":
+		return "video/quicktime"
+	case major == "M4A " || major == "M4B ":
+		return "audio/mp4"
+	case major[:3] == "3gp" || major[:3] == "3g2":
+		return "video/3gpp"
+	}
+	return "video/mp4"
+}
@@ -0,0 +1,39 @@
+package media
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSniff(t *testing.T) {
+	tests := map[string][]byte{
+		"image/jpeg":                {0xFF, 0xD8, 0xFF, 0xE0},
+		"image/png":                 []byte("\x89PNG\r\n\x1a\n\x00\x00"),
+		"image/gif":                 []byte("GIF89a\x01\x00"),
+		"image/tiff":                []byte("II*\x00\x08\x00"),
+		"image/webp":                []byte("RIFF\x00\x00\x00\x00WEBPVP8 "),
+		"audio/wav":                 []byte("RIFF\x00\x00\x00\x00WAVEfmt "),
+		"video/webm":                []byte("\x1a\x45\xdf\xa3\x9f\x42\x86\x81\x01\x42\x82\x84webm"),
+		"video/x-matroska":          []byte("\x1a\x45\xdf\xa3\x9f\x42\x82\x88matroska"),
+		"audio/flac":                []byte("fLaC\x00\x00"),
+		"audio/ogg":                 []byte("OggS\x00\x02"),
+		"audio/mpeg":                []byte("ID3\x04\x00"),
+		"audio/aac":                 {0xFF, 0xF1, 0x50, 0x80},
+		"video/mp4":                 box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")),
+		"video/quicktime":           box("ftyp", []byte("qt  \x00\x00\x02\x00qt  ")),
+		"audio/mp4":                 box("ftyp", []byte("M4A \x00\x00\x00\x00M4A mp42")),
+		"video/3gpp":                box("ftyp", []byte("3gp4\x00\x00\x00\x00")),
+		"image/heic":                box("ftyp", []byte("mif1\x00\x00\x00\x00mif1heic")),
+		"image/heif":                box("ftyp", []byte("mif1\x00\x00\x00\x00mif1")),
+		"image/avif":                box("ftyp", []byte("avif\x00\x00\x00\x00avifmif1")),
+		"text/plain; charset=utf-8": []byte("hello"),
+		OctetStream:                 {0x00, 0x01, 0x02, 0x03},
+	}
+	for want, head := range tests {
+		assert.Equal(t, want, Sniff(head), "%q", head)
+	}
+
+	assert.Equal(t, "video/quicktime", Sniff(box("moov", make([]byte, 8))), "QuickTime without ftyp")
+	assert.Equal(t, "audio/mpeg", Sniff([]byte{0xFF, 0xFB, 0x90, 0x00}), "MPEG frame sync")
+}
@@ -0,0 +1,199 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"mime"
+)
+
+var (
+	// ErrTruncated is returned by Validate when the data ends early
+	ErrTruncated = errors.New("content is truncated")
+	// ErrCorrupt is returned by Validate when the data is malformed
+	ErrCorrupt = errors.New("content is corrupt")
+)
+
+// validators check the structure of complete files by content type
+var validators = map[string]func([]byte) error{
+	"image/jpeg":      validateJPEG,
+	"image/png":       validatePNG,
+	"video/mp4":       validateMP4,
+	"video/quicktime": validateQuickTime,
+	"audio/mp4":       validateMP4,
+}
+
+// Validatable reports whether Validate checks the structure of contentType
+func Validatable(contentType string) bool {
+	_, ok := validators[baseType(contentType)]
+	return ok
+}
+
+// Validate checks that b is a complete, well-formed file of contentType,
+// returning an error wrapping ErrTruncated or ErrCorrupt. Types Validate
+// does not know are accepted.
+func Validate(contentType string, b []byte) error {
+	validate, ok := validators[baseType(contentType)]
+	if !ok {
+		return nil
+	}
+	return validate(b)
+}
+
+func baseType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mediaType
+}
+
+// validateJPEG walks the marker segments up to the scan data, requires a
+// frame header before it and an EOI marker after it
+func validateJPEG(b []byte) error {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return fmt.Errorf("%w: missing JPEG SOI marker", ErrCorrupt)
+	}
+	frame := false
+	for i := 2; ; {
+		if i+2 > len(b) {
+			return fmt.Errorf("%w: JPEG ends before scan data", ErrTruncated)
+		}
+		if b[i] != 0xFF {
+			return fmt.Errorf("%w: expected JPEG marker at offset %d", ErrCorrupt, i)
+		}
+		marker := b[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Standalone markers
+			i += 2
+			continue
+		case marker == 0xD9:
+			return fmt.Errorf("%w: JPEG ends without image data", ErrCorrupt)
+		}
+
+		if i+4 > len(b) {
+			return fmt.Errorf("%w: JPEG ends before scan data", ErrTruncated)
+		}
+		length := int(binary.BigEndian.Uint16(b[i+2:]))
+		if length < 2 {
+			return fmt.Errorf("%w: JPEG segment %#x has length %d", ErrCorrupt, marker, length)
+		}
+		if i+2+length > len(b) {
+			return fmt.Errorf("%w: JPEG segment %#x runs past the end", ErrTruncated, marker)
+		}
+		// SOF0-SOF15, excluding DHT (C4), JPG (C8) and DAC (CC)
+		if marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC {
+			frame = true
+		}
+		if marker == 0xDA {
+			if !frame {
+				return fmt.Errorf("%w: JPEG scan before frame header", ErrCorrupt)
+			}
+			if !bytes.Contains(b[i+2+length:], []byte{0xFF, 0xD9}) {
+				return fmt.Errorf("%w: JPEG has no EOI marker", ErrTruncated)
+			}
+			return nil
+		}
+		i += 2 + length
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// validatePNG checks the bounds and CRC of every chunk, that IHDR comes
+// first, that there is image data and that the stream ends with IEND
+func validatePNG(b []byte) error {
+	if !bytes.HasPrefix(b, pngSignature) {
+		return fmt.Errorf("%w: missing PNG signature", ErrCorrupt)
+	}
+	idat := false
+	for i, n := len(pngSignature), 0; ; n++ {
+		if i+12 > len(b) {
+			return fmt.Errorf("%w: PNG ends before IEND", ErrTruncated)
+		}
+		length := int64(binary.BigEndian.Uint32(b[i:]))
+		typ := string(b[i+4 : i+8])
+		if int64(i)+12+length > int64(len(b)) {
+			return fmt.Errorf("%w: PNG chunk %s runs past the end", ErrTruncated, typ)
+		}
+		end := i + 8 + int(length)
+		if crc32.ChecksumIEEE(b[i+4:end]) != binary.BigEndian.Uint32(b[end:]) {
+			return fmt.Errorf("%w: PNG chunk %s fails its CRC", ErrCorrupt, typ)
+		}
+		if n == 0 && typ != "IHDR" {
+			return fmt.Errorf("%w: PNG starts with %s instead of IHDR", ErrCorrupt, typ)
+		}
+		switch typ {
+		case "IDAT":
+			idat = true
+		case "IEND":
+			if !idat {
+				return fmt.Errorf("%w: PNG has no IDAT chunk", ErrCorrupt)
+			}
+			return nil
+		}
+		i = end + 4
+	}
+}
+
+func validateMP4(b []byte) error {
+	return validateBoxes(b, true)
+}
+
+func validateQuickTime(b []byte) error {
+	return validateBoxes(b, false)
+}
+
+// validateBoxes walks the top-level ISO base media boxes, requiring them to
+// tile the file exactly and to include a moov box. MP4 files must start
+// with ftyp; QuickTime files may omit it.
+func validateBoxes(b []byte, needFtyp bool) error {
+	moov := false
+	for i := int64(0); i < int64(len(b)); {
+		rest := int64(len(b)) - i
+		if rest < 8 {
+			return fmt.Errorf("%w: box header at offset %d is cut short", ErrTruncated, i)
+		}
+		size := int64(binary.BigEndian.Uint32(b[i:]))
+		typ := string(b[i+4 : i+8])
+		header := int64(8)
+		switch size {
+		case 0:
+			// Box extends to the end of the file
+			size = rest
+		case 1:
+			if rest < 16 {
+				return fmt.Errorf("%w: box %q header is cut short", ErrTruncated, typ)
+			}
+			u := binary.BigEndian.Uint64(b[i+8:])
+			if u > uint64(rest) {
+				return fmt.Errorf("%w: box %q runs past the end", ErrTruncated, typ)
+			}
+			size, header = int64(u), 16
+		}
+		if size < header {
+			return fmt.Errorf("%w: box %q has size %d", ErrCorrupt, typ, size)
+		}
+		if size > rest {
+			return fmt.Errorf("%w: box %q runs past the end", ErrTruncated, typ)
+		}
+		if i == 0 && needFtyp && typ != "ftyp" {
+			return fmt.Errorf("%w: file starts with box %q instead of ftyp", ErrCorrupt, typ)
+		}
+		if typ == "moov" {
+			moov = true
+		}
+		i += size
+	}
+	if !moov {
+		return fmt.Errorf("%w: no moov box", ErrCorrupt)
+	}
+	return nil
+}
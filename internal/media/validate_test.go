@@ -0,0 +1,91 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// box builds an ISO base media box
+func box(typ string, payload []byte) []byte {
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], typ)
+	return append(b, payload...)
+}
+
+func encoded(t *testing.T, encode func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	img.Set(3, 3, color.White)
+	var buf bytes.Buffer
+	require.NoError(t, encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestValidate_JPEG(t *testing.T) {
+	valid := encoded(t, func(w *bytes.Buffer, img image.Image) error { return jpeg.Encode(w, img, nil) })
+	assert.NoError(t, Validate("image/jpeg", valid))
+
+	assert.ErrorIs(t, Validate("image/jpeg", valid[:len(valid)-2]), ErrTruncated)
+	assert.ErrorIs(t, Validate("image/jpeg", valid[:40]), ErrTruncated)
+	assert.ErrorIs(t, Validate("image/jpeg", []byte("not a jpeg")), ErrCorrupt)
+	assert.ErrorIs(t, Validate("image/jpeg", []byte{0xFF, 0xD8, 0xFF, 0xD9, 0x00}), ErrCorrupt)
+
+	noFrame := []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0x00, 0xFF, 0xD9}
+	assert.ErrorIs(t, Validate("image/jpeg", noFrame), ErrCorrupt)
+}
+
+func TestValidate_PNG(t *testing.T) {
+	valid := encoded(t, func(w *bytes.Buffer, img image.Image) error { return png.Encode(w, img) })
+	assert.NoError(t, Validate("image/png", valid))
+	assert.NoError(t, Validate("image/png; charset=binary", valid))
+
+	assert.ErrorIs(t, Validate("image/png", valid[:len(valid)-6]), ErrTruncated)
+	assert.ErrorIs(t, Validate("image/png", valid[:20]), ErrTruncated)
+
+	corrupt := bytes.Clone(valid)
+	corrupt[40] ^= 0xFF
+	err := Validate("image/png", corrupt)
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.Contains(t, err.Error(), "CRC")
+}
+
+func TestValidate_MP4(t *testing.T) {
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00isom"))
+	moov := box("moov", box("mvhd", make([]byte, 100)))
+	mdat := box("mdat", make([]byte, 64))
+	valid := bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+	assert.NoError(t, Validate("video/mp4", valid))
+
+	assert.ErrorIs(t, Validate("video/mp4", valid[:len(valid)-1]), ErrTruncated)
+	assert.ErrorIs(t, Validate("video/mp4", append(bytes.Clone(valid), 0, 0, 0)), ErrTruncated)
+	assert.ErrorIs(t, Validate("video/mp4", bytes.Join([][]byte{ftyp, mdat}, nil)), ErrCorrupt, "no moov")
+	assert.ErrorIs(t, Validate("video/mp4", bytes.Join([][]byte{moov, mdat}, nil)), ErrCorrupt, "no ftyp")
+	assert.NoError(t, Validate("video/quicktime", bytes.Join([][]byte{moov, mdat}, nil)))
+
+	// A zero size extends to the end and size 1 uses a 64-bit size
+	open := append(bytes.Join([][]byte{ftyp, moov}, nil), 0, 0, 0, 0, 'm', 'd', 'a', 't', 1, 2, 3)
+	assert.NoError(t, Validate("video/mp4", open))
+	large := []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 20, 1, 2, 3, 4}
+	assert.NoError(t, Validate("video/mp4", bytes.Join([][]byte{ftyp, moov, large}, nil)))
+	large[15] = 40
+	assert.ErrorIs(t, Validate("video/mp4", bytes.Join([][]byte{ftyp, moov, large}, nil)), ErrTruncated)
+}
+
+func TestValidatable(t *testing.T) {
+	assert.True(t, Validatable("image/jpeg"))
+	assert.True(t, Validatable("video/quicktime"))
+	assert.False(t, Validatable("image/gif"))
+	assert.NoError(t, Validate("image/gif", []byte("anything")))
+}
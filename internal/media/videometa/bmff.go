@@ -0,0 +1,317 @@
+package videometa
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// mp4Epoch is the origin of ISO base media timestamps
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Box types that may appear at the top level of an ISO base media file
+var topLevelBoxes = map[string]bool{
+	"ftyp": true, "moov": true, "mdat": true, "free": true, "skip": true,
+	"wide": true, "pnot": true, "uuid": true, "meta": true, "moof": true,
+}
+
+func isBoxType(typ string) bool {
+	return topLevelBoxes[typ]
+}
+
+// box is an ISO base media box with its payload
+type box struct {
+	typ  string
+	data []byte
+}
+
+// boxes splits b into its child boxes, stopping at the first malformed one
+func boxes(b []byte) []box {
+	var out []box
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		typ := string(b[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return out
+			}
+			size, header = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < header || size > uint64(len(b)) {
+			return out
+		}
+		out = append(out, box{typ: typ, data: b[header:size]})
+		b = b[size:]
+	}
+	return out
+}
+
+func child(b []byte, typ string) []byte {
+	for _, c := range boxes(b) {
+		if c.typ == typ {
+			return c.data
+		}
+	}
+	return nil
+}
+
+// probeBMFF walks the top-level boxes to the moov box and parses it
+func probeBMFF(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Format: FormatMP4}
+	for off := int64(0); off+8 <= size; {
+		header, err := readAt(r, off, min(16, size-off), size)
+		if err != nil {
+			return nil, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		typ := string(header[4:8])
+		headerLen := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			if len(header) < 16 {
+				return nil, ErrIncomplete
+			}
+			boxSize, headerLen = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if boxSize < headerLen {
+			return nil, ErrMalformed
+		}
+
+		switch typ {
+		case "ftyp":
+			if len(header) >= 12 && string(header[8:12]) == "qt  " {
+				info.Format = FormatQuickTime
+			}
+		case "moov":
+			if boxSize > maxMetadataBytes {
+				return nil, ErrMalformed
+			}
+			moov, err := readAt(r, off+headerLen, boxSize-headerLen, size)
+			if err != nil {
+				return nil, err
+			}
+			if err := parseMoov(moov, info); err != nil {
+				return nil, err
+			}
+			return info, nil
+		}
+		off += boxSize
+	}
+	return nil, ErrIncomplete
+}
+
+func parseMoov(moov []byte, info *Info) error {
+	mvhd := child(moov, "mvhd")
+	if mvhd == nil {
+		return ErrMalformed
+	}
+	created, timescale, duration, ok := parseTimes(mvhd)
+	if !ok {
+		return ErrMalformed
+	}
+	if timescale > 0 {
+		info.Duration = scaleDuration(duration, timescale)
+	}
+	if created > 0 {
+		info.CreatedAt = mp4Epoch.Add(time.Duration(created) * time.Second)
+	}
+
+	for _, c := range boxes(moov) {
+		switch c.typ {
+		case "trak":
+			parseTrak(c.data, info)
+		case "udta":
+			parseUserData(c.data, info)
+		case "meta":
+			parseMeta(c.data, info)
+		}
+	}
+	if info.Rotation == 90 || info.Rotation == 270 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	return nil
+}
+
+// parseTimes reads the creation time, timescale and duration shared by the
+// mvhd and mdhd layouts
+func parseTimes(b []byte) (created uint64, timescale uint32, duration uint64, ok bool) {
+	if len(b) < 4 {
+		return 0, 0, 0, false
+	}
+	if b[0] == 1 {
+		if len(b) < 32 {
+			return 0, 0, 0, false
+		}
+		return binary.BigEndian.Uint64(b[4:]), binary.BigEndian.Uint32(b[20:]), binary.BigEndian.Uint64(b[24:]), true
+	}
+	if len(b) < 20 {
+		return 0, 0, 0, false
+	}
+	duration = uint64(binary.BigEndian.Uint32(b[16:]))
+	if duration == math.MaxUint32 {
+		// All ones means the duration is unknown
+		duration = 0
+	}
+	return uint64(binary.BigEndian.Uint32(b[4:])), binary.BigEndian.Uint32(b[12:]), duration, true
+}
+
+func scaleDuration(units uint64, timescale uint32) time.Duration {
+	return time.Duration(float64(units) / float64(timescale) * float64(time.Second))
+}
+
+func parseTrak(trak []byte, info *Info) {
+	mdia := child(trak, "mdia")
+	handler := ""
+	if hdlr := child(mdia, "hdlr"); len(hdlr) >= 12 {
+		handler = string(hdlr[8:12])
+	}
+	var codec string
+	var entry []byte
+	if stsd := child(child(child(mdia, "minf"), "stbl"), "stsd"); len(stsd) >= 8 {
+		if entries := boxes(stsd[8:]); len(entries) > 0 {
+			codec, entry = entries[0].typ, entries[0].data
+		}
+	}
+
+	switch handler {
+	case "vide":
+		if info.VideoCodec != "" {
+			return
+		}
+		info.VideoCodec = codecName(codec)
+		width, height, rotation := parseTrackHeader(child(trak, "tkhd"))
+		if (width == 0 || height == 0) && len(entry) >= 28 {
+			// Visual sample entries store the coded size after 24 bytes
+			width, height = int(binary.BigEndian.Uint16(entry[24:])), int(binary.BigEndian.Uint16(entry[26:]))
+		}
+		info.Width, info.Height, info.Rotation = width, height, rotation
+	case "soun":
+		if info.AudioCodec == "" {
+			info.AudioCodec = codecName(codec)
+		}
+	default:
+		return
+	}
+
+	if info.Duration == 0 {
+		if _, timescale, duration, ok := parseTimes(child(mdia, "mdhd")); ok && timescale > 0 {
+			info.Duration = scaleDuration(duration, timescale)
+		}
+	}
+}
+
+// parseTrackHeader reads the presentation size and rotation of a tkhd box
+func parseTrackHeader(tkhd []byte) (width, height, rotation int) {
+	offset := 4 + 20
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		offset = 4 + 32
+	}
+	// reserved(8) layer(2) group(2) volume(2) reserved(2) matrix(36) width(4) height(4)
+	matrix := offset + 16
+	if len(tkhd) < matrix+44 {
+		return 0, 0, 0
+	}
+	at := func(i int) int32 { return int32(binary.BigEndian.Uint32(tkhd[matrix+i*4:])) }
+	a, b, c, d := at(0), at(1), at(3), at(4)
+	switch {
+	case a == 0 && b > 0 && c < 0 && d == 0:
+		rotation = 90
+	case a < 0 && b == 0 && c == 0 && d < 0:
+		rotation = 180
+	case a == 0 && b < 0 && c > 0 && d == 0:
+		rotation = 270
+	}
+	width = int(binary.BigEndian.Uint32(tkhd[matrix+36:]) >> 16)
+	height = int(binary.BigEndian.Uint32(tkhd[matrix+40:]) >> 16)
+	return width, height, rotation
+}
+
+// parseUserData reads the QuickTime ©xyz location and ©day date atoms
+func parseUserData(udta []byte, info *Info) {
+	for _, c := range boxes(udta) {
+		switch c.typ {
+		case "\xa9xyz":
+			if s, ok := userDataString(c.data); ok && info.Location == nil {
+				info.Location, _ = parseISO6709(s)
+			}
+		case "\xa9day":
+			if s, ok := userDataString(c.data); ok && info.CreatedAt.IsZero() {
+				info.CreatedAt, _ = parseDate(s)
+			}
+		case "meta":
+			parseMeta(c.data, info)
+		}
+	}
+}
+
+// userDataString reads a QuickTime international text atom: a 16-bit
+// length and language code followed by the text
+func userDataString(b []byte) (string, bool) {
+	if len(b) < 4 {
+		return "", false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if 4+n > len(b) {
+		return "", false
+	}
+	return string(b[4 : 4+n]), true
+}
+
+// parseMeta reads QuickTime metadata, whose ilst items are indexed into a
+// keys box. The MP4 form of meta is a full box with version and flags.
+func parseMeta(meta []byte, info *Info) {
+	if len(meta) >= 4 && binary.BigEndian.Uint32(meta) == 0 {
+		meta = meta[4:]
+	}
+	var keys []string
+	if k := child(meta, "keys"); len(k) >= 8 {
+		for _, entry := range boxes(k[8:]) {
+			keys = append(keys, entry.typ+":"+string(entry.data))
+		}
+	}
+	for _, item := range boxes(child(meta, "ilst")) {
+		key := item.typ
+		if index := int(binary.BigEndian.Uint32([]byte(item.typ))); index >= 1 && index <= len(keys) {
+			key = keys[index-1]
+		}
+		data := child(item.data, "data")
+		if len(data) < 8 {
+			continue
+		}
+		value := string(data[8:])
+		switch key {
+		case "mdta:com.apple.quicktime.location.ISO6709", "\xa9xyz":
+			if loc, ok := parseISO6709(value); ok {
+				info.Location = loc
+			}
+		case "mdta:com.apple.quicktime.creationdate":
+			// The local capture time takes precedence over mvhd, which
+			// encoders often fill with the time of muxing
+			if t, ok := parseDate(value); ok {
+				info.CreatedAt = t
+			}
+		case "\xa9day":
+			if t, ok := parseDate(value); ok && info.CreatedAt.IsZero() {
+				info.CreatedAt = t
+			}
+		}
+	}
+}
+
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02T15:04:05Z0700", "2006-01-02", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
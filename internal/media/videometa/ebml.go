@@ -0,0 +1,274 @@
+package videometa
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Matroska element IDs, with their length markers
+const (
+	idEBML           = 0x1A45DFA3
+	idDocType        = 0x4282
+	idSegment        = 0x18538067
+	idInfo           = 0x1549A966
+	idTimestampScale = 0x2AD7B1
+	idDuration       = 0x4489
+	idDateUTC        = 0x4461
+	idTracks         = 0x1654AE6B
+	idTrackEntry     = 0xAE
+	idTrackType      = 0x83
+	idCodecID        = 0x86
+	idVideo          = 0xE0
+	idPixelWidth     = 0xB0
+	idPixelHeight    = 0xBA
+)
+
+// Matroska track types
+const (
+	trackVideo = 1
+	trackAudio = 2
+)
+
+// matroskaEpoch is the origin of DateUTC
+var matroskaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// unknownSize marks elements whose size was not written, such as live
+// streamed clusters
+const unknownSize = math.MaxUint64
+
+// element is an EBML element with its payload
+type element struct {
+	id   uint64
+	data []byte
+}
+
+// vint decodes an EBML variable-length integer, keeping the length marker
+// for IDs. It returns the value and its encoded length, or zero length when
+// b is too short or invalid.
+func vint(b []byte, keepMarker bool) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+	n := bits.LeadingZeros8(b[0]) + 1
+	if n > 8 || len(b) < n {
+		return 0, 0
+	}
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= 0xFF >> n
+	}
+	allOnes := v == uint64(0xFF>>n)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+		allOnes = allOnes && c == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return unknownSize, n
+	}
+	return v, n
+}
+
+// header decodes an element ID and size
+func header(b []byte) (id, size uint64, n int) {
+	id, idLen := vint(b, true)
+	if idLen == 0 || idLen > 4 {
+		return 0, 0, 0
+	}
+	size, sizeLen := vint(b[idLen:], false)
+	if sizeLen == 0 {
+		return 0, 0, 0
+	}
+	return id, size, idLen + sizeLen
+}
+
+// elements splits b into its child elements, stopping at the first
+// malformed one
+func elements(b []byte) []element {
+	var out []element
+	for len(b) > 0 {
+		id, size, n := header(b)
+		if n == 0 || size > uint64(len(b)-n) {
+			return out
+		}
+		out = append(out, element{id: id, data: b[n : n+int(size)]})
+		b = b[n+int(size):]
+	}
+	return out
+}
+
+func uintValue(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func floatValue(b []byte) (float64, bool) {
+	switch len(b) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), true
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), true
+	}
+	return 0, false
+}
+
+// probeMatroska reads the EBML header and walks the segment to its Info
+// and Tracks elements, which precede the clusters in practice
+func probeMatroska(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{Format: FormatMatroska}
+
+	head, err := readAt(r, 0, min(size, 64), size)
+	if err != nil {
+		return nil, err
+	}
+	id, ebmlSize, n := header(head)
+	if id != idEBML || ebmlSize == unknownSize {
+		return nil, ErrMalformed
+	}
+	end := int64(n) + int64(ebmlSize)
+	ebml, err := readAt(r, int64(n), int64(ebmlSize), size)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range elements(ebml) {
+		if e.id == idDocType && string(e.data) == "webm" {
+			info.Format = FormatWebM
+		}
+	}
+
+	off, segmentEnd, err := segment(r, end, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var haveInfo, haveTracks bool
+	for off < segmentEnd && !(haveInfo && haveTracks) {
+		b, err := readAt(r, off, min(12, size-off), size)
+		if err != nil {
+			return nil, err
+		}
+		id, elemSize, n := header(b)
+		if n == 0 {
+			return nil, truncatedOr(len(b) < 12)
+		}
+		if elemSize == unknownSize {
+			// Only clusters are streamed with unknown sizes; nothing after
+			// them can be located
+			break
+		}
+		switch id {
+		case idInfo, idTracks:
+			if elemSize > maxMetadataBytes {
+				return nil, ErrMalformed
+			}
+			data, err := readAt(r, off+int64(n), int64(elemSize), size)
+			if err != nil {
+				return nil, err
+			}
+			if id == idInfo {
+				parseSegmentInfo(data, info)
+				haveInfo = true
+			} else {
+				parseTracks(data, info)
+				haveTracks = true
+			}
+		}
+		off += int64(n) + int64(elemSize)
+	}
+	if !haveInfo {
+		return nil, ErrIncomplete
+	}
+	return info, nil
+}
+
+// segment locates the payload of the Segment element following the EBML
+// header
+func segment(r io.ReaderAt, off, size int64) (start, end int64, err error) {
+	b, err := readAt(r, off, min(12, size-off), size)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, segmentSize, n := header(b)
+	if n == 0 {
+		return 0, 0, truncatedOr(len(b) < 12)
+	}
+	if id != idSegment {
+		return 0, 0, ErrMalformed
+	}
+	start = off + int64(n)
+	if segmentSize == unknownSize || start+int64(segmentSize) > size {
+		return start, size, nil
+	}
+	return start, start + int64(segmentSize), nil
+}
+
+// truncatedOr reports ErrIncomplete when an element header may have been
+// cut short, and ErrMalformed otherwise
+func truncatedOr(short bool) error {
+	if short {
+		return ErrIncomplete
+	}
+	return ErrMalformed
+}
+
+func parseSegmentInfo(b []byte, info *Info) {
+	scale := uint64(1_000_000)
+	var duration float64
+	for _, e := range elements(b) {
+		switch e.id {
+		case idTimestampScale:
+			if v := uintValue(e.data); v > 0 {
+				scale = v
+			}
+		case idDuration:
+			duration, _ = floatValue(e.data)
+		case idDateUTC:
+			if len(e.data) == 8 {
+				info.CreatedAt = matroskaEpoch.Add(time.Duration(int64(binary.BigEndian.Uint64(e.data))))
+			}
+		}
+	}
+	if duration > 0 {
+		info.Duration = time.Duration(duration * float64(scale))
+	}
+}
+
+func parseTracks(b []byte, info *Info) {
+	for _, entry := range elements(b) {
+		if entry.id != idTrackEntry {
+			continue
+		}
+		var trackType uint64
+		var codec string
+		var width, height int
+		for _, e := range elements(entry.data) {
+			switch e.id {
+			case idTrackType:
+				trackType = uintValue(e.data)
+			case idCodecID:
+				codec = string(e.data)
+			case idVideo:
+				for _, v := range elements(e.data) {
+					switch v.id {
+					case idPixelWidth:
+						width = int(uintValue(v.data))
+					case idPixelHeight:
+						height = int(uintValue(v.data))
+					}
+				}
+			}
+		}
+		switch {
+		case trackType == trackVideo && info.VideoCodec == "":
+			info.VideoCodec = codecName(codec)
+			info.Width, info.Height = width, height
+		case trackType == trackAudio && info.AudioCodec == "":
+			info.AudioCodec = codecName(codec)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package videometa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func join(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+// mkbox builds an ISO base media box
+func mkbox(typ string, parts ...[]byte) []byte {
+	payload := join(parts...)
+	return join(u32(uint32(8+len(payload))), []byte(typ), payload)
+}
+
+func fullBox(typ string, version byte, parts ...[]byte) []byte {
+	return mkbox(typ, append([][]byte{{version, 0, 0, 0}}, parts...)...)
+}
+
+func mp4Seconds(t time.Time) uint32 {
+	return uint32(t.Sub(mp4Epoch) / time.Second)
+}
+
+func mvhd(created time.Time, timescale, duration uint32) []byte {
+	return fullBox("mvhd", 0, u32(mp4Seconds(created)), u32(mp4Seconds(created)), u32(timescale), u32(duration), make([]byte, 80))
+}
+
+// matrix is a tkhd transformation; a, b, c and d are 16.16 fixed point
+func matrix(a, b, c, d int32) []byte {
+	m := make([]byte, 36)
+	binary.BigEndian.PutUint32(m[0:], uint32(a))
+	binary.BigEndian.PutUint32(m[4:], uint32(b))
+	binary.BigEndian.PutUint32(m[12:], uint32(c))
+	binary.BigEndian.PutUint32(m[16:], uint32(d))
+	binary.BigEndian.PutUint32(m[32:], 1<<30)
+	return m
+}
+
+var identity = matrix(1<<16, 0, 0, 1<<16)
+
+func tkhd(width, height uint32, m []byte) []byte {
+	return fullBox("tkhd", 0, make([]byte, 20), make([]byte, 16), m, u32(width<<16), u32(height<<16))
+}
+
+func trak(handler, codec string, header []byte, entry []byte) []byte {
+	stsd := fullBox("stsd", 0, u32(1), mkbox(codec, entry))
+	return mkbox("trak",
+		header,
+		mkbox("mdia",
+			fullBox("mdhd", 0, make([]byte, 8), u32(1000), u32(0), make([]byte, 4)),
+			fullBox("hdlr", 0, u32(0), []byte(handler), make([]byte, 12)),
+			mkbox("minf", mkbox("stbl", stsd)),
+		),
+	)
+}
+
+// quickTimeMeta builds a QuickTime meta box with keyed metadata items
+func quickTimeMeta(items map[string]string) []byte {
+	var keys, ilst [][]byte
+	i := uint32(0)
+	for _, key := range []string{"com.apple.quicktime.location.ISO6709", "com.apple.quicktime.creationdate"} {
+		value, ok := items[key]
+		if !ok {
+			continue
+		}
+		i++
+		keys = append(keys, mkbox("mdta", []byte(key)))
+		ilst = append(ilst, mkbox(string(u32(i)), mkbox("data", u32(1), u32(0), []byte(value))))
+	}
+	return mkbox("meta",
+		fullBox("hdlr", 0, u32(0), []byte("mdta"), make([]byte, 12)),
+		fullBox("keys", 0, u32(i), join(keys...)),
+		mkbox("ilst", ilst...),
+	)
+}
+
+// ebml builds a Matroska element; sizes use 8-byte vints
+func ebml(id uint32, parts ...[]byte) []byte {
+	payload := join(parts...)
+	idBytes := u32(id)
+	for len(idBytes) > 1 && idBytes[0] == 0 {
+		idBytes = idBytes[1:]
+	}
+	return join(idBytes, u64(uint64(len(payload))|1<<56), payload)
+}
+
+func ebmlUint(id uint32, v uint64) []byte { return ebml(id, u64(v)) }
+
+func ebmlFloat(id uint32, v float64) []byte { return ebml(id, u64(math.Float64bits(v))) }
+
+func matroska(docType string, created time.Time, duration float64, tracks ...[]byte) []byte {
+	return join(
+		ebml(idEBML, ebml(idDocType, []byte(docType))),
+		ebml(idSegment,
+			ebml(0x114D9B74), // SeekHead
+			ebml(idInfo,
+				ebmlUint(idTimestampScale, 1_000_000),
+				ebmlFloat(idDuration, duration),
+				ebml(idDateUTC, u64(uint64(created.Sub(matroskaEpoch)))),
+			),
+			ebml(idTracks, tracks...),
+			ebml(0x1F43B675, make([]byte, 32)), // Cluster
+		),
+	)
+}
+
+func mkvTrack(trackType uint64, codec string, width, height uint64) []byte {
+	parts := [][]byte{ebmlUint(idTrackType, trackType), ebml(idCodecID, []byte(codec))}
+	if width > 0 {
+		parts = append(parts, ebml(idVideo, ebmlUint(idPixelWidth, width), ebmlUint(idPixelHeight, height)))
+	}
+	return ebml(idTrackEntry, parts...)
+}
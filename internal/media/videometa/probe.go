@@ -0,0 +1,138 @@
+// Package videometa reads duration, dimensions, codecs, creation time and
+// location from ISO base media (MP4, QuickTime) and Matroska (WebM)
+// containers without decoding any media.
+package videometa
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Container formats recognised by Probe
+const (
+	FormatMP4       = "mp4"
+	FormatQuickTime = "quicktime"
+	FormatMatroska  = "matroska"
+	FormatWebM      = "webm"
+)
+
+var (
+	// ErrUnknownFormat is returned by Probe for unrecognised containers
+	ErrUnknownFormat = errors.New("unknown video container")
+	// ErrIncomplete is returned by Probe when the data ends before the
+	// container metadata; for MP4 files the moov box may follow the media
+	ErrIncomplete = errors.New("container metadata not found in data")
+	// ErrMalformed is returned by Probe for inconsistent container structure
+	ErrMalformed = errors.New("malformed video container")
+)
+
+// maxMetadataBytes bounds the size of a metadata box or element read into
+// memory
+const maxMetadataBytes = 64 << 20
+
+// Info is the container-level metadata of a video. Width and Height are the
+// display dimensions, already swapped for 90 and 270 degree rotations.
+type Info struct {
+	Format     string
+	Duration   time.Duration
+	Width      int
+	Height     int
+	Rotation   int
+	VideoCodec string
+	AudioCodec string
+	CreatedAt  time.Time
+	Location   *Location
+}
+
+// Location is a recording position; Altitude is nil when unknown
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  *float64
+}
+
+// Probe reads the container metadata of the size bytes of r. r may hold a
+// prefix of the file, in which case ErrIncomplete reports that the
+// metadata lies beyond it.
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	head := make([]byte, 12)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+	switch {
+	case len(head) >= 4 && string(head[:4]) == "\x1a\x45\xdf\xa3":
+		return probeMatroska(r, size)
+	case len(head) >= 8 && isBoxType(string(head[4:8])):
+		return probeBMFF(r, size)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// readAt reads exactly n bytes at off, reporting ErrIncomplete when they
+// lie beyond size
+func readAt(r io.ReaderAt, off, n, size int64) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > size {
+		return nil, ErrIncomplete
+	}
+	b := make([]byte, n)
+	got, err := r.ReadAt(b, off)
+	switch {
+	case int64(got) == n:
+		return b, nil
+	case err == nil, errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return nil, ErrIncomplete
+	}
+	return nil, err
+}
+
+var iso6709 = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)?`)
+
+// parseISO6709 reads decimal-degree ISO 6709 strings such as
+// "+35.6586+139.7454+040.123/"
+func parseISO6709(s string) (*Location, bool) {
+	m := iso6709.FindStringSubmatch(s)
+	if m == nil {
+		return nil, false
+	}
+	lat, err1 := strconv.ParseFloat(m[1], 64)
+	lon, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil, false
+	}
+	loc := &Location{Latitude: lat, Longitude: lon}
+	if m[3] != "" {
+		if alt, err := strconv.ParseFloat(m[3], 64); err == nil {
+			loc.Altitude = &alt
+		}
+	}
+	return loc, true
+}
+
+// codecNames maps sample entry types and Matroska codec IDs to short names
+var codecNames = map[string]string{
+	"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc", "dvh1": "hevc", "dvhe": "hevc",
+	"av01": "av1", "vp08": "vp8", "vp09": "vp9", "mp4v": "mpeg4", "jpeg": "mjpeg",
+	"apch": "prores", "apcn": "prores", "apcs": "prores", "apco": "prores", "ap4h": "prores",
+	"mp4a": "aac", ".mp3": "mp3", "ac-3": "ac3", "ec-3": "eac3", "Opus": "opus", "fLaC": "flac",
+	"alac": "alac", "sowt": "pcm", "twos": "pcm", "lpcm": "pcm", "ipcm": "pcm",
+
+	"V_MPEG4/ISO/AVC": "h264", "V_MPEGH/ISO/HEVC": "hevc", "V_AV1": "av1", "V_VP8": "vp8", "V_VP9": "vp9",
+	"V_MPEG4/ISO/ASP": "mpeg4", "V_MJPEG": "mjpeg", "V_THEORA": "theora",
+	"A_OPUS": "opus", "A_VORBIS": "vorbis", "A_AAC": "aac", "A_FLAC": "flac", "A_MPEG/L3": "mp3",
+	"A_AC3": "ac3", "A_EAC3": "eac3", "A_PCM/INT/LIT": "pcm",
+}
+
+func codecName(id string) string {
+	if name, ok := codecNames[id]; ok {
+		return name
+	}
+	if len(id) > 6 && id[:6] == "A_AAC/" {
+		return "aac"
+	}
+	return id
+}
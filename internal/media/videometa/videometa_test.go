@@ -0,0 +1,127 @@
+package videometa
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var created = time.Date(2024, 2, 3, 10, 20, 30, 0, time.UTC)
+
+func probe(b []byte) (*Info, error) {
+	return Probe(bytes.NewReader(b), int64(len(b)))
+}
+
+func visualEntry(width, height uint16) []byte {
+	return join(make([]byte, 24), u16(width), u16(height), make([]byte, 50))
+}
+
+func TestProbe_MP4(t *testing.T) {
+	moov := mkbox("moov",
+		mvhd(created, 600, 600*12+300),
+		trak("vide", "avc1", tkhd(1920, 1080, identity), visualEntry(1920, 1080)),
+		trak("soun", "mp4a", tkhd(0, 0, identity), nil),
+		mkbox("udta", mkbox("\xa9xyz", u16(17), u16(0x15c7), []byte("+35.6586+139.7454/"))),
+	)
+	ftyp := mkbox("ftyp", []byte("isom"), u32(512), []byte("isomavc1"))
+	mdat := mkbox("mdat", make([]byte, 256))
+
+	for name, file := range map[string][]byte{
+		"faststart": join(ftyp, moov, mdat),
+		"moov last": join(ftyp, mdat, moov),
+	} {
+		info, err := probe(file)
+		require.NoError(t, err, name)
+		assert.Equal(t, FormatMP4, info.Format)
+		assert.Equal(t, 12500*time.Millisecond, info.Duration)
+		assert.Equal(t, 1920, info.Width)
+		assert.Equal(t, 1080, info.Height)
+		assert.Equal(t, "h264", info.VideoCodec)
+		assert.Equal(t, "aac", info.AudioCodec)
+		assert.Equal(t, created, info.CreatedAt)
+		require.NotNil(t, info.Location)
+		assert.Equal(t, 35.6586, info.Location.Latitude)
+		assert.Equal(t, 139.7454, info.Location.Longitude)
+		assert.Nil(t, info.Location.Altitude)
+	}
+
+	_, err := probe(join(ftyp, mdat, moov)[:len(ftyp)+len(mdat)+20])
+	assert.ErrorIs(t, err, ErrIncomplete, "moov beyond a prefix")
+	_, err = probe(join(ftyp, mdat))
+	assert.ErrorIs(t, err, ErrIncomplete)
+	_, err = probe(join(ftyp, mkbox("moov", mkbox("free"))))
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestProbe_QuickTime(t *testing.T) {
+	rotated := matrix(0, 1<<16, -1<<16, 0)
+	moov := mkbox("moov",
+		mvhd(created, 1000, 3000),
+		trak("vide", "hvc1", tkhd(1920, 1080, rotated), visualEntry(1920, 1080)),
+		quickTimeMeta(map[string]string{
+			"com.apple.quicktime.location.ISO6709": "+35.6586+139.7454+040.123/",
+			"com.apple.quicktime.creationdate":     "2024-02-03T19:20:30+0900",
+		}),
+	)
+	file := join(mkbox("ftyp", []byte("qt  "), u32(0), []byte("qt  ")), mkbox("wide"), mkbox("mdat", make([]byte, 16)), moov)
+
+	info, err := probe(file)
+	require.NoError(t, err)
+	assert.Equal(t, FormatQuickTime, info.Format)
+	assert.Equal(t, 3*time.Second, info.Duration)
+	assert.Equal(t, 90, info.Rotation)
+	assert.Equal(t, 1080, info.Width)
+	assert.Equal(t, 1920, info.Height)
+	assert.Equal(t, "hevc", info.VideoCodec)
+	assert.True(t, created.Equal(info.CreatedAt), info.CreatedAt)
+	require.NotNil(t, info.Location)
+	require.NotNil(t, info.Location.Altitude)
+	assert.Equal(t, 40.123, *info.Location.Altitude)
+}
+
+func TestProbe_Matroska(t *testing.T) {
+	file := matroska("webm", created, 4500,
+		mkvTrack(1, "V_VP9", 1280, 720),
+		mkvTrack(2, "A_OPUS", 0, 0),
+	)
+	info, err := probe(file)
+	require.NoError(t, err)
+	assert.Equal(t, FormatWebM, info.Format)
+	assert.Equal(t, 4500*time.Millisecond, info.Duration)
+	assert.Equal(t, 1280, info.Width)
+	assert.Equal(t, 720, info.Height)
+	assert.Equal(t, "vp9", info.VideoCodec)
+	assert.Equal(t, "opus", info.AudioCodec)
+	assert.Equal(t, created, info.CreatedAt)
+	assert.Nil(t, info.Location)
+
+	info, err = probe(matroska("matroska", created, 1000, mkvTrack(1, "V_MPEG4/ISO/AVC", 640, 480)))
+	require.NoError(t, err)
+	assert.Equal(t, FormatMatroska, info.Format)
+	assert.Equal(t, "h264", info.VideoCodec)
+
+	_, err = probe(file[:60])
+	assert.ErrorIs(t, err, ErrIncomplete)
+}
+
+func TestProbe_Unknown(t *testing.T) {
+	_, err := probe([]byte("GIF89a....."))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+	_, err = probe(nil)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestParseISO6709(t *testing.T) {
+	loc, ok := parseISO6709("-33.8688+151.2093-005.5/")
+	require.True(t, ok)
+	assert.Equal(t, -33.8688, loc.Latitude)
+	assert.Equal(t, -5.5, *loc.Altitude)
+
+	_, ok = parseISO6709("+95.0+10.0/")
+	assert.False(t, ok)
+	_, ok = parseISO6709("somewhere")
+	assert.False(t, ok)
+}
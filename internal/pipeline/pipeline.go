@@ -0,0 +1,366 @@
+// Package pipeline connects an input service through transforms to output
+// services, checking declared schemas when the pipeline is built and,
+// in strict mode, each item's metadata as it flows through.
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sho7650/media-sync/internal/blob"
+	"github.com/sho7650/media-sync/internal/checksum"
+	"github.com/sho7650/media-sync/internal/config"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+	"github.com/sho7650/media-sync/pkg/core/schema"
+)
+
+var (
+	// ErrIncompatible is returned by Build when a stage cannot accept what
+	// the stage before it produces
+	ErrIncompatible = errors.New("incompatible pipeline stages")
+	// ErrSchemaViolation is returned in strict mode when an item's metadata
+	// does not match a declared schema
+	ErrSchemaViolation = errors.New("metadata violates schema")
+)
+
+// stage is a service with its compiled metadata schemas; nil schemas are
+// undeclared
+type stage struct {
+	name    string
+	service interfaces.Service
+	input   *schema.Schema
+	output  *schema.Schema
+}
+
+// Pipeline runs items from an input service through transforms to outputs
+type Pipeline struct {
+	name       string
+	strict     bool
+	input      interfaces.InputService
+	transforms []stage
+	outputs    []stage
+	routes     map[string][]stage
+
+	spool     *blob.Store
+	algorithm string
+}
+
+// Build connects the services cfg names, looked up by their configuration
+// keys. Each transform's ValidateSchema is called with the schema produced
+// so far, and any declared metadata schema must satisfy the next stage's
+// input schema. Transforms that declare no output schema pass the incoming
+// one through.
+func Build(name string, cfg config.PipelineConfig, services map[string]interfaces.Service) (*Pipeline, error) {
+	p := &Pipeline{name: name, strict: cfg.Strict}
+
+	svc, ok := services[cfg.Input]
+	if !ok {
+		return nil, fmt.Errorf("pipeline %s: unknown input service %q", name, cfg.Input)
+	}
+	if p.input, ok = svc.(interfaces.InputService); !ok {
+		return nil, fmt.Errorf("pipeline %s: service %q is not an input service", name, cfg.Input)
+	}
+	_, produced, err := declaredSchemas(svc)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %s: service %q: %w", name, cfg.Input, err)
+	}
+	current, from := produced, cfg.Input
+
+	for _, transformName := range cfg.Transforms {
+		svc, ok := services[transformName]
+		if !ok {
+			return nil, fmt.Errorf("pipeline %s: unknown transform service %q", name, transformName)
+		}
+		transform, ok := svc.(interfaces.TransformService)
+		if !ok {
+			return nil, fmt.Errorf("pipeline %s: service %q is not a transform service", name, transformName)
+		}
+		if err := transform.ValidateSchema(current); err != nil {
+			return nil, fmt.Errorf("%w: %s -> %s: %v", ErrIncompatible, from, transformName, err)
+		}
+
+		s, err := p.connect(svc, transformName, from, current)
+		if err != nil {
+			return nil, err
+		}
+		p.transforms = append(p.transforms, s)
+
+		if _, out, _ := declaredSchemas(svc); out.Metadata != nil || out.Type != "" {
+			if out.Type == "" {
+				out.Type = current.Type
+			}
+			if out.Metadata == nil {
+				out.Metadata = current.Metadata
+			}
+			current = out
+		}
+		from = transformName
+	}
+
+	if len(cfg.Outputs) == 0 {
+		return nil, fmt.Errorf("pipeline %s: no output services", name)
+	}
+	for _, outputName := range cfg.Outputs {
+		svc, ok := services[outputName]
+		if !ok {
+			return nil, fmt.Errorf("pipeline %s: unknown output service %q", name, outputName)
+		}
+		if _, ok := svc.(interfaces.OutputService); !ok {
+			return nil, fmt.Errorf("pipeline %s: service %q is not an output service", name, outputName)
+		}
+		s, err := p.connect(svc, outputName, from, current)
+		if err != nil {
+			return nil, err
+		}
+		p.outputs = append(p.outputs, s)
+	}
+
+	for route, names := range cfg.Routes {
+		if len(names) == 0 {
+			return nil, fmt.Errorf("pipeline %s: route %q has no outputs", name, route)
+		}
+		if p.routes == nil {
+			p.routes = make(map[string][]stage)
+		}
+		for _, outputName := range names {
+			s, ok := p.output(outputName)
+			if !ok {
+				return nil, fmt.Errorf("pipeline %s: route %q output %q is not an output of the pipeline", name, route, outputName)
+			}
+			p.routes[route] = append(p.routes[route], s)
+		}
+	}
+	return p, nil
+}
+
+func (p *Pipeline) output(name string) (stage, bool) {
+	for _, s := range p.outputs {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return stage{}, false
+}
+
+// targets returns the outputs data is published to: those of its route
+// when a transform routed it, and every output otherwise
+func (p *Pipeline) targets(data *interfaces.DataStream) ([]stage, error) {
+	route, _ := data.Metadata[interfaces.MetaRoute].(string)
+	if route == "" {
+		return p.outputs, nil
+	}
+	outputs, ok := p.routes[route]
+	if !ok {
+		return nil, fmt.Errorf("pipeline %s: %s is routed to %q, which has no outputs", p.name, data.ID, route)
+	}
+	return outputs, nil
+}
+
+// connect compiles the schemas of a stage and checks that the schema
+// produced by the previous stage satisfies its input
+func (p *Pipeline) connect(svc interfaces.Service, name, from string, produced interfaces.Schema) (stage, error) {
+	accepted, declared, err := declaredSchemas(svc)
+	if err != nil {
+		return stage{}, fmt.Errorf("pipeline %s: service %q: %w", p.name, name, err)
+	}
+	s := stage{name: name, service: svc}
+	if s.input, err = compile(accepted); err != nil {
+		return stage{}, fmt.Errorf("pipeline %s: service %q input schema: %w", p.name, name, err)
+	}
+	if s.output, err = compile(declared); err != nil {
+		return stage{}, fmt.Errorf("pipeline %s: service %q output schema: %w", p.name, name, err)
+	}
+
+	if accepted.Type != "" && produced.Type != "" && accepted.Type != produced.Type {
+		return stage{}, fmt.Errorf("%w: %s -> %s: produces %s streams, accepts %s", ErrIncompatible, from, name, produced.Type, accepted.Type)
+	}
+	upstream, err := compile(produced)
+	if err != nil {
+		return stage{}, fmt.Errorf("pipeline %s: schema produced before %q: %w", p.name, name, err)
+	}
+	if err := schema.Satisfies(upstream, s.input); err != nil {
+		return stage{}, fmt.Errorf("%w: %s -> %s: %v", ErrIncompatible, from, name, err)
+	}
+	return s, nil
+}
+
+// declaredSchemas returns the schemas svc declares, or empty ones
+func declaredSchemas(svc interfaces.Service) (input, output interfaces.Schema, err error) {
+	provider, ok := svc.(interfaces.SchemaProvider)
+	if !ok {
+		return interfaces.Schema{}, interfaces.Schema{}, nil
+	}
+	input, output = provider.InputSchema(), provider.OutputSchema()
+	if _, err := compile(input); err != nil {
+		return input, output, fmt.Errorf("input schema: %w", err)
+	}
+	if _, err := compile(output); err != nil {
+		return input, output, fmt.Errorf("output schema: %w", err)
+	}
+	return input, output, nil
+}
+
+func compile(s interfaces.Schema) (*schema.Schema, error) {
+	if s.Metadata == nil {
+		return nil, nil
+	}
+	return schema.Compile(s.Metadata)
+}
+
+// SetSpool stores stream content in store before the transforms run,
+// verifying it against any checksum and size the source supplied, and
+// again before publishing if a transform replaced it. Outputs then each
+// reopen the stored copy instead of sharing an in-memory buffer. The
+// checksum recorded in metadata uses algorithm, SHA-256 when empty.
+func (p *Pipeline) SetSpool(store *blob.Store, algorithm string) error {
+	if algorithm == "" {
+		algorithm = checksum.SHA256
+	}
+	if _, err := checksum.New(algorithm); err != nil {
+		return fmt.Errorf("pipeline %s: %w", p.name, err)
+	}
+	p.spool, p.algorithm = store, algorithm
+	return nil
+}
+
+// Name returns the pipeline name
+func (p *Pipeline) Name() string { return p.name }
+
+// Run retrieves one stream from the input and processes it
+func (p *Pipeline) Run(ctx context.Context, req interfaces.RetrievalRequest) error {
+	data, err := p.input.Retrieve(ctx, req)
+	if err != nil {
+		return fmt.Errorf("pipeline %s: retrieve: %w", p.name, err)
+	}
+	return p.Process(ctx, data)
+}
+
+// Process runs data through the transforms and publishes the result and
+// any derived streams to every output, or to the outputs of the route a
+// transform assigned them. Items a transform drops with
+// interfaces.ErrStreamDropped are skipped without an error, while rejected
+// items fail with an error wrapping interfaces.ErrStreamRejected, as does
+// content failing verification when spooling. Without a spool, content is
+// buffered in memory when there is more than one output. The pipeline name
+// is recorded in data.Context for transforms that only apply to some
+// pipelines.
+func (p *Pipeline) Process(ctx context.Context, data *interfaces.DataStream) error {
+	if data == nil {
+		return fmt.Errorf("pipeline %s: no stream to process", p.name)
+	}
+	data.Context.Pipeline = p.name
+	if err := p.ingest(ctx, data, true); err != nil {
+		return err
+	}
+	for _, s := range p.transforms {
+		if err := p.check(s.name, "input", s.input, data); err != nil {
+			return err
+		}
+		out, err := s.service.(interfaces.TransformService).Transform(ctx, data)
+		if errors.Is(err, interfaces.ErrStreamDropped) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("pipeline %s: transform %s: %w", p.name, s.name, err)
+		}
+		if out == nil {
+			return fmt.Errorf("pipeline %s: transform %s returned no stream", p.name, s.name)
+		}
+		data = out
+		if err := p.check(s.name, "output", s.output, data); err != nil {
+			return err
+		}
+	}
+
+	streams := append([]*interfaces.DataStream{data}, data.Derived...)
+	for _, stream := range streams {
+		if err := p.publish(ctx, stream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ingest spools the content of data when the pipeline has a spool
+func (p *Pipeline) ingest(ctx context.Context, data *interfaces.DataStream, verify bool) error {
+	if p.spool == nil || data.Content == nil {
+		return nil
+	}
+	opts := blob.IngestOptions{Algorithm: p.algorithm, Verify: verify}
+	if _, err := p.spool.Ingest(ctx, data, opts); err != nil {
+		return fmt.Errorf("pipeline %s: spool %s: %w", p.name, data.ID, err)
+	}
+	return nil
+}
+
+func (p *Pipeline) publish(ctx context.Context, data *interfaces.DataStream) error {
+	outputs, err := p.targets(data)
+	if err != nil {
+		if data.Content != nil {
+			_ = data.Content.Close()
+		}
+		return err
+	}
+	if err := p.ingest(ctx, data, false); err != nil {
+		return err
+	}
+	reopenable, _ := data.Content.(interfaces.ReopenableContent)
+
+	var content []byte
+	if reopenable == nil && len(outputs) > 1 && data.Content != nil {
+		var err error
+		if content, err = io.ReadAll(data.Content); err != nil {
+			return fmt.Errorf("pipeline %s: read %s: %w", p.name, data.ID, err)
+		}
+		_ = data.Content.Close()
+	}
+
+	for i, s := range outputs {
+		// Each output's reader is closed once it has published, so handles
+		// do not pile up across outputs and derived streams
+		var closer io.Closer
+		switch {
+		case content != nil:
+			data.Content = io.NopCloser(bytes.NewReader(content))
+		case reopenable != nil && i > 0:
+			rc, err := reopenable.Reopen()
+			if err != nil {
+				return fmt.Errorf("pipeline %s: reopen %s: %w", p.name, data.ID, err)
+			}
+			data.Content, closer = rc, rc
+		case reopenable != nil:
+			closer = reopenable
+		}
+		err := p.check(s.name, "input", s.input, data)
+		if err == nil {
+			if err = s.service.(interfaces.OutputService).Publish(ctx, data); err != nil {
+				err = fmt.Errorf("pipeline %s: output %s: %w", p.name, s.name, err)
+			}
+		}
+		if closer != nil {
+			_ = closer.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check validates metadata against a declared schema in strict mode
+func (p *Pipeline) check(stageName, side string, s *schema.Schema, data *interfaces.DataStream) error {
+	if !p.strict || s == nil {
+		return nil
+	}
+	metadata := data.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	if err := s.Validate(metadata); err != nil {
+		return fmt.Errorf("%w: pipeline %s: %s %s of %s: %v", ErrSchemaViolation, p.name, stageName, side, data.ID, err)
+	}
+	return nil
+}
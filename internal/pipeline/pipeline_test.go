@@ -0,0 +1,353 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/blob"
+	"github.com/sho7650/media-sync/internal/config"
+	"github.com/sho7650/media-sync/internal/storage"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// fakeService implements the Service methods shared by the fakes below
+type fakeService struct {
+	input, output interfaces.Schema
+}
+
+func (f *fakeService) Start(ctx context.Context) error { return nil }
+func (f *fakeService) Stop(ctx context.Context) error  { return nil }
+func (f *fakeService) Health() interfaces.ServiceHealth {
+	return interfaces.ServiceHealth{Status: interfaces.StatusHealthy}
+}
+func (f *fakeService) Info() interfaces.ServiceInfo          { return interfaces.ServiceInfo{Name: "fake"} }
+func (f *fakeService) Capabilities() []interfaces.Capability { return nil }
+func (f *fakeService) InputSchema() interfaces.Schema        { return f.input }
+func (f *fakeService) OutputSchema() interfaces.Schema       { return f.output }
+
+type fakeInput struct {
+	fakeService
+	data *interfaces.DataStream
+}
+
+func (f *fakeInput) Retrieve(ctx context.Context, req interfaces.RetrievalRequest) (*interfaces.DataStream, error) {
+	return f.data, nil
+}
+func (f *fakeInput) SupportedModes() []interfaces.SyncMode { return nil }
+func (f *fakeInput) Authenticate(ctx context.Context, creds interfaces.Credentials) error {
+	return nil
+}
+
+type fakeTransform struct {
+	fakeService
+	mediaType string
+	apply     func(*interfaces.DataStream) (*interfaces.DataStream, error)
+}
+
+func (f *fakeTransform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	return f.apply(data)
+}
+
+func (f *fakeTransform) ValidateSchema(schema interfaces.Schema) error {
+	if f.mediaType != "" && schema.Type != "" && schema.Type != f.mediaType {
+		return fmt.Errorf("only %s streams are supported", f.mediaType)
+	}
+	return nil
+}
+
+type fakeOutput struct {
+	fakeService
+	published []string
+	contents  []string
+}
+
+func (f *fakeOutput) Publish(ctx context.Context, data *interfaces.DataStream) error {
+	f.published = append(f.published, data.ID)
+	if data.Content != nil {
+		b, err := io.ReadAll(data.Content)
+		if err != nil {
+			return err
+		}
+		f.contents = append(f.contents, string(b))
+	}
+	return nil
+}
+func (f *fakeOutput) ConfigureDestination(config interfaces.DestinationConfig) error { return nil }
+
+var titled = map[string]interface{}{
+	"type":     "object",
+	"required": []interface{}{"title"},
+	"properties": map[string]interface{}{
+		"title": map[string]interface{}{"type": "string", "minLength": 1},
+	},
+}
+
+func setTitle(title interface{}) func(*interfaces.DataStream) (*interfaces.DataStream, error) {
+	return func(data *interfaces.DataStream) (*interfaces.DataStream, error) {
+		data.Metadata["title"] = title
+		return data, nil
+	}
+}
+
+func TestBuild_ChecksSchemas(t *testing.T) {
+	input := &fakeInput{fakeService: fakeService{output: interfaces.Schema{Type: "photo"}}}
+	titler := &fakeTransform{
+		fakeService: fakeService{output: interfaces.Schema{Metadata: map[string]interface{}{
+			"type":     "object",
+			"required": []interface{}{"title"},
+			"properties": map[string]interface{}{
+				"title": map[string]interface{}{"type": "string", "minLength": 3},
+			},
+		}}},
+		apply: setTitle("Sunset"),
+	}
+	passthrough := &fakeTransform{apply: setTitle("x")}
+	videoOnly := &fakeTransform{mediaType: "video"}
+	needsTitle := &fakeOutput{fakeService: fakeService{input: interfaces.Schema{Metadata: titled}}}
+	needsPhoto := &fakeOutput{fakeService: fakeService{input: interfaces.Schema{Type: "photo"}}}
+	broken := &fakeOutput{fakeService: fakeService{input: interfaces.Schema{Metadata: map[string]interface{}{"type": 1}}}}
+
+	services := map[string]interfaces.Service{
+		"in": input, "titler": titler, "passthrough": passthrough, "video": videoOnly,
+		"needs-title": needsTitle, "needs-photo": needsPhoto, "broken": broken,
+	}
+
+	_, err := Build("ok", config.PipelineConfig{Input: "in", Transforms: []string{"titler", "passthrough"}, Outputs: []string{"needs-title", "needs-photo"}}, services)
+	assert.NoError(t, err, "passthrough keeps the titler's output schema")
+
+	_, err = Build("undeclared", config.PipelineConfig{Input: "in", Outputs: []string{"needs-title"}}, services)
+	assert.NoError(t, err, "undeclared metadata is left to strict mode")
+
+	_, err = Build("type", config.PipelineConfig{Input: "in", Transforms: []string{"video"}, Outputs: []string{"needs-photo"}}, services)
+	assert.ErrorIs(t, err, ErrIncompatible)
+
+	loose := &fakeTransform{fakeService: fakeService{output: interfaces.Schema{Metadata: map[string]interface{}{"type": "object"}}}}
+	services["loose"] = loose
+	_, err = Build("required", config.PipelineConfig{Input: "in", Transforms: []string{"loose"}, Outputs: []string{"needs-title"}}, services)
+	assert.ErrorIs(t, err, ErrIncompatible)
+	assert.Contains(t, err.Error(), `loose -> needs-title`)
+	assert.Contains(t, err.Error(), `required property "title"`)
+
+	for _, cfg := range []config.PipelineConfig{
+		{Input: "missing", Outputs: []string{"needs-photo"}},
+		{Input: "titler", Outputs: []string{"needs-photo"}},
+		{Input: "in", Transforms: []string{"needs-photo"}, Outputs: []string{"needs-photo"}},
+		{Input: "in", Outputs: []string{"titler"}},
+		{Input: "in"},
+		{Input: "in", Outputs: []string{"broken"}},
+	} {
+		_, err := Build("bad", cfg, services)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestPipeline_Process(t *testing.T) {
+	data := &interfaces.DataStream{
+		ID:       "item-1",
+		Metadata: map[string]interface{}{},
+		Content:  io.NopCloser(strings.NewReader("bytes")),
+		Derived:  []*interfaces.DataStream{{ID: "item-1/thumb", Metadata: map[string]interface{}{"title": "Thumb"}}},
+	}
+	input := &fakeInput{data: data}
+	first, second := &fakeOutput{}, &fakeOutput{}
+	services := map[string]interfaces.Service{
+		"in":     input,
+		"titler": &fakeTransform{apply: setTitle("Sunset")},
+		"a":      first,
+		"b":      second,
+	}
+
+	p, err := Build("main", config.PipelineConfig{Input: "in", Transforms: []string{"titler"}, Outputs: []string{"a", "b"}}, services)
+	require.NoError(t, err)
+	assert.Equal(t, "main", p.Name())
+	require.NoError(t, p.Run(context.Background(), interfaces.RetrievalRequest{}))
+
+	assert.Equal(t, []string{"item-1", "item-1/thumb"}, first.published)
+	assert.Equal(t, "main", data.Context.Pipeline)
+	assert.Equal(t, []string{"bytes"}, first.contents)
+	assert.Equal(t, []string{"bytes"}, second.contents, "each output reads the full content")
+}
+
+func TestPipeline_Routes(t *testing.T) {
+	archive, social := &fakeOutput{}, &fakeOutput{}
+	route := func(name string) func(*interfaces.DataStream) (*interfaces.DataStream, error) {
+		return func(data *interfaces.DataStream) (*interfaces.DataStream, error) {
+			if name != "" {
+				data.Metadata[interfaces.MetaRoute] = name
+			}
+			return data, nil
+		}
+	}
+	services := map[string]interfaces.Service{
+		"in":      &fakeInput{},
+		"old":     &fakeTransform{apply: route("archive")},
+		"none":    &fakeTransform{apply: route("")},
+		"unknown": &fakeTransform{apply: route("elsewhere")},
+		"archive": archive,
+		"social":  social,
+	}
+	cfg := func(transform string) config.PipelineConfig {
+		return config.PipelineConfig{
+			Input: "in", Transforms: []string{transform}, Outputs: []string{"archive", "social"},
+			Routes: map[string][]string{"archive": {"archive"}},
+		}
+	}
+	item := func(id string) *interfaces.DataStream {
+		return &interfaces.DataStream{ID: id, Metadata: map[string]interface{}{}, Content: io.NopCloser(strings.NewReader(id))}
+	}
+
+	p, err := Build("routed", cfg("old"), services)
+	require.NoError(t, err)
+	require.NoError(t, p.Process(context.Background(), item("old-1")))
+	p, err = Build("plain", cfg("none"), services)
+	require.NoError(t, err)
+	require.NoError(t, p.Process(context.Background(), item("new-1")))
+
+	assert.Equal(t, []string{"old-1", "new-1"}, archive.published)
+	assert.Equal(t, []string{"new-1"}, social.published, "routed items skip the other outputs")
+	assert.Equal(t, []string{"old-1", "new-1"}, archive.contents)
+
+	p, err = Build("unknown", cfg("unknown"), services)
+	require.NoError(t, err)
+	assert.Error(t, p.Process(context.Background(), item("lost-1")))
+	assert.Len(t, archive.published, 2)
+
+	bad := cfg("old")
+	bad.Routes = map[string][]string{"archive": {"in"}}
+	_, err = Build("bad", bad, services)
+	assert.Error(t, err)
+}
+
+func TestPipeline_DropsAndStrictMode(t *testing.T) {
+	out := &fakeOutput{fakeService: fakeService{input: interfaces.Schema{Metadata: titled}}}
+	services := map[string]interfaces.Service{
+		"in": &fakeInput{},
+		"drop": &fakeTransform{apply: func(*interfaces.DataStream) (*interfaces.DataStream, error) {
+			return nil, fmt.Errorf("%w: reblog", interfaces.ErrStreamDropped)
+		}},
+		"empty-title": &fakeTransform{apply: setTitle("")},
+		"out":         out,
+	}
+	item := func() *interfaces.DataStream {
+		return &interfaces.DataStream{ID: "item-1", Metadata: map[string]interface{}{}}
+	}
+
+	p, err := Build("drop", config.PipelineConfig{Input: "in", Transforms: []string{"drop"}, Outputs: []string{"out"}, Strict: true}, services)
+	require.NoError(t, err)
+	assert.NoError(t, p.Process(context.Background(), item()))
+	assert.Empty(t, out.published)
+
+	lenient, err := Build("lenient", config.PipelineConfig{Input: "in", Transforms: []string{"empty-title"}, Outputs: []string{"out"}}, services)
+	require.NoError(t, err)
+	assert.NoError(t, lenient.Process(context.Background(), item()))
+	assert.Equal(t, []string{"item-1"}, out.published)
+
+	strict, err := Build("strict", config.PipelineConfig{Input: "in", Transforms: []string{"empty-title"}, Outputs: []string{"out"}, Strict: true}, services)
+	require.NoError(t, err)
+	err = strict.Process(context.Background(), item())
+	assert.ErrorIs(t, err, ErrSchemaViolation)
+	assert.Contains(t, err.Error(), "/title: must be at least 1 characters")
+	assert.Len(t, out.published, 1)
+}
+
+func TestPipeline_Spool(t *testing.T) {
+	db := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, db.Initialize(context.Background()))
+	defer db.Close()
+	store, err := blob.NewStore(filepath.Join(t.TempDir(), "blobs"), db)
+	require.NoError(t, err)
+
+	var transformed interfaces.ReopenableContent
+	first, second := &fakeOutput{}, &fakeOutput{}
+	services := map[string]interfaces.Service{
+		"in": &fakeInput{},
+		"upper": &fakeTransform{apply: func(data *interfaces.DataStream) (*interfaces.DataStream, error) {
+			transformed, _ = data.Content.(interfaces.ReopenableContent)
+			b, err := io.ReadAll(data.Content)
+			if err != nil {
+				return nil, err
+			}
+			data.Content = io.NopCloser(strings.NewReader(strings.ToUpper(string(b))))
+			return data, nil
+		}},
+		"a": first,
+		"b": second,
+	}
+	p, err := Build("main", config.PipelineConfig{Input: "in", Transforms: []string{"upper"}, Outputs: []string{"a", "b"}}, services)
+	require.NoError(t, err)
+	assert.Error(t, p.SetSpool(store, "crc7"))
+	require.NoError(t, p.SetSpool(store, ""))
+
+	const sourceSum = "sha256:277089d91c0bdf4f2e6862ba7e4a07605119431f5d13f726dd352b06f1b206a9"
+	data := &interfaces.DataStream{
+		ID:       "item-1",
+		Metadata: map[string]interface{}{blob.KeyChecksum: sourceSum},
+		Content:  io.NopCloser(strings.NewReader("bytes")),
+	}
+	require.NoError(t, p.Process(context.Background(), data))
+	assert.NotNil(t, transformed, "transforms read the spooled source")
+	assert.Equal(t, []string{"BYTES"}, first.contents)
+	assert.Equal(t, []string{"BYTES"}, second.contents, "each output reopens the spooled content")
+	assert.Equal(t, int64(5), data.Metadata[blob.KeySize])
+	assert.NotEqual(t, sourceSum, data.Metadata[blob.KeyChecksum], "the checksum describes the published content")
+
+	corrupt := &interfaces.DataStream{
+		ID:       "item-2",
+		Metadata: map[string]interface{}{blob.KeyChecksum: sourceSum},
+		Content:  io.NopCloser(strings.NewReader("bytez")),
+	}
+	err = p.Process(context.Background(), corrupt)
+	assert.ErrorIs(t, err, interfaces.ErrStreamRejected)
+	assert.Len(t, first.contents, 1)
+}
+
+// reopenable counts the readers of its content that are open
+type reopenable struct {
+	io.Reader
+	open *int
+}
+
+func (r *reopenable) Close() error { *r.open--; return nil }
+func (r *reopenable) Reopen() (io.ReadCloser, error) {
+	*r.open++
+	return &reopenable{Reader: strings.NewReader("bytes"), open: r.open}, nil
+}
+
+// openOutput records how many readers are open when it publishes
+type openOutput struct {
+	fakeOutput
+	open *int
+	seen []int
+}
+
+func (o *openOutput) Publish(ctx context.Context, data *interfaces.DataStream) error {
+	o.seen = append(o.seen, *o.open)
+	return o.fakeOutput.Publish(ctx, data)
+}
+
+func TestPipeline_ClosesEachOutputsReader(t *testing.T) {
+	open := 1
+	outputs := []*openOutput{{open: &open}, {open: &open}, {open: &open}}
+	services := map[string]interfaces.Service{"in": &fakeInput{}, "a": outputs[0], "b": outputs[1], "c": outputs[2]}
+	p, err := Build("main", config.PipelineConfig{Input: "in", Outputs: []string{"a", "b", "c"}}, services)
+	require.NoError(t, err)
+
+	data := &interfaces.DataStream{
+		ID: "item-1", Metadata: map[string]interface{}{},
+		Content: &reopenable{Reader: strings.NewReader("bytes"), open: &open},
+		Derived: []*interfaces.DataStream{{ID: "item-1/thumb", Metadata: map[string]interface{}{}}},
+	}
+	require.NoError(t, p.Process(context.Background(), data))
+	for _, out := range outputs {
+		assert.Equal(t, []int{1, 0}, out.seen, "only the reader being published is open")
+		assert.Equal(t, []string{"bytes"}, out.contents)
+	}
+	assert.Zero(t, open)
+}
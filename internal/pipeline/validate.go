@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sho7650/media-sync/internal/config"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Validate builds every pipeline in cfg from services that create makes
+// from their configuration, without starting them, so stages whose schemas
+// are incompatible are reported when the configuration is loaded rather
+// than when a pipeline first runs
+func Validate(cfg *config.Config, create func(name string, svc config.ServiceConfig) (interfaces.Service, error)) error {
+	names := make([]string, 0, len(cfg.Pipelines))
+	for name := range cfg.Pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	services := make(map[string]interfaces.Service)
+	for _, name := range names {
+		pc := cfg.Pipelines[name]
+		refs := append(append([]string{pc.Input}, pc.Transforms...), pc.Outputs...)
+		for _, ref := range refs {
+			if _, ok := services[ref]; ok {
+				continue
+			}
+			svc, ok := cfg.Services[ref]
+			if !ok {
+				// Build reports the unknown service
+				continue
+			}
+			created, err := create(ref, svc)
+			if err != nil {
+				return fmt.Errorf("pipeline %s: service %q: %w", name, ref, err)
+			}
+			services[ref] = created
+		}
+		if _, err := Build(name, pc, services); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validator returns a config.Validator that runs Validate with plugins
+// created by the factory registered under each service's plugin name and
+// configured with its settings; add it with ConfigManager.AddValidator
+func Validator(factories *plugins.FactoryRegistry) config.Validator {
+	return func(ctx context.Context, cfg *config.Config) error {
+		return Validate(cfg, func(name string, svc config.ServiceConfig) (interfaces.Service, error) {
+			factory, err := factories.GetFactory(svc.Plugin)
+			if err != nil {
+				return nil, err
+			}
+			p, err := factory.CreatePlugin(plugins.PluginConfig{
+				Name:     name,
+				Type:     svc.Type,
+				Enabled:  svc.Enabled,
+				Settings: svc.Settings,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if len(svc.Settings) > 0 {
+				if err := p.Configure(svc.Settings); err != nil {
+					return nil, err
+				}
+			}
+			return p, nil
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/config"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+type inputPlugin struct{ fakeInput }
+
+func (p *inputPlugin) GetMetadata() plugins.PluginMetadata           { return plugins.PluginMetadata{} }
+func (p *inputPlugin) Configure(config map[string]interface{}) error { return nil }
+
+// outputPlugin requires a title when configured with require_title
+type outputPlugin struct{ fakeOutput }
+
+func (p *outputPlugin) GetMetadata() plugins.PluginMetadata { return plugins.PluginMetadata{} }
+func (p *outputPlugin) Configure(config map[string]interface{}) error {
+	if config["require_title"] == true {
+		p.input = interfaces.Schema{Metadata: titled}
+	}
+	return nil
+}
+
+func TestValidator(t *testing.T) {
+	factories := plugins.NewFactoryRegistry()
+	require.NoError(t, factories.RegisterFactory("feed", plugins.PluginFactoryFunc(func(plugins.PluginConfig) (plugins.Plugin, error) {
+		loose := interfaces.Schema{Metadata: map[string]interface{}{"type": "object"}}
+		return &inputPlugin{fakeInput{fakeService: fakeService{output: loose}}}, nil
+	})))
+	require.NoError(t, factories.RegisterFactory("archive", plugins.PluginFactoryFunc(func(plugins.PluginConfig) (plugins.Plugin, error) {
+		return &outputPlugin{}, nil
+	})))
+
+	manager := config.NewConfigManager()
+	manager.AddValidator(Validator(factories))
+
+	load := func(outputSettings string) error {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(`
+global:
+  database: {path: ./test.db}
+  workers: 1
+services:
+  feed: {name: feed, type: input, plugin: feed, enabled: true}
+  archive: {name: archive, type: output, plugin: archive, enabled: true, settings: `+outputSettings+`}
+pipelines:
+  main: {input: feed, outputs: [archive]}
+`), 0o644))
+		_, err := manager.LoadFromFile(context.Background(), path)
+		return err
+	}
+
+	assert.NoError(t, load("{}"))
+	err := load("{require_title: true}")
+	assert.ErrorIs(t, err, ErrIncompatible, "the feed does not declare a title")
+}
+
+func TestValidate_UnknownPlugin(t *testing.T) {
+	cfg := &config.Config{
+		Services:  map[string]config.ServiceConfig{"in": {Name: "in", Type: "input", Plugin: "missing"}},
+		Pipelines: map[string]config.PipelineConfig{"main": {Input: "in", Outputs: []string{"out"}}},
+	}
+	err := Validator(plugins.NewFactoryRegistry())(context.Background(), cfg)
+	assert.ErrorIs(t, err, plugins.ErrFactoryNotFound)
+}
@@ -0,0 +1,131 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// BasePlugin provides the lifecycle, health and metadata plumbing shared by
+// concrete plugin implementations. Plugins embed it and add their own
+// Configure, Capabilities and service-specific methods.
+type BasePlugin struct {
+	metadata PluginMetadata
+	author   string
+
+	mu        sync.RWMutex
+	running   bool
+	lastError error
+	details   map[string]interface{}
+	createdAt time.Time
+	http      *httpclient.Factory
+}
+
+// NewBasePlugin creates a base plugin from the loader configuration
+func NewBasePlugin(config PluginConfig, pluginType, description string) *BasePlugin {
+	if description == "" {
+		description = config.Description
+	}
+	return &BasePlugin{
+		metadata: PluginMetadata{
+			Name:        config.Name,
+			Version:     config.Version,
+			Type:        pluginType,
+			Description: description,
+		},
+		author:    "media-sync",
+		details:   make(map[string]interface{}),
+		createdAt: time.Now(),
+		http:      config.HTTP,
+	}
+}
+
+// Start marks the plugin as running
+func (b *BasePlugin) Start(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.running = true
+	b.lastError = nil
+	return nil
+}
+
+// Stop marks the plugin as stopped
+func (b *BasePlugin) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.running = false
+	return nil
+}
+
+// IsRunning reports whether the plugin has been started
+func (b *BasePlugin) IsRunning() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.running
+}
+
+// Health reports the plugin health derived from its lifecycle state and last error
+func (b *BasePlugin) Health() interfaces.ServiceHealth {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	details := make(map[string]interface{}, len(b.details))
+	for k, v := range b.details {
+		details[k] = v
+	}
+
+	health := interfaces.ServiceHealth{
+		Status:    interfaces.StatusHealthy,
+		Message:   fmt.Sprintf("%s is running", b.metadata.Name),
+		Timestamp: time.Now(),
+		Details:   details,
+	}
+
+	switch {
+	case !b.running:
+		health.Status = interfaces.StatusStopped
+		health.Message = fmt.Sprintf("%s is stopped", b.metadata.Name)
+	case b.lastError != nil:
+		health.Status = interfaces.StatusWarning
+		health.Message = b.lastError.Error()
+	}
+
+	return health
+}
+
+// Info returns service information built from the plugin metadata
+func (b *BasePlugin) Info() interfaces.ServiceInfo {
+	return interfaces.ServiceInfo{
+		Name:        b.metadata.Name,
+		Version:     b.metadata.Version,
+		Type:        b.metadata.Type,
+		Description: b.metadata.Description,
+		Author:      b.author,
+		CreatedAt:   b.createdAt,
+	}
+}
+
+// GetMetadata returns the plugin metadata
+func (b *BasePlugin) GetMetadata() PluginMetadata {
+	return b.metadata
+}
+
+// RecordError stores the latest operational error, or clears it when err is nil
+func (b *BasePlugin) RecordError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastError = err
+}
+
+// SetHealthDetail publishes a value in ServiceHealth.Details
+func (b *BasePlugin) SetHealthDetail(key string, value interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.details[key] = value
+}
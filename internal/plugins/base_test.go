@@ -0,0 +1,75 @@
+package plugins
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func TestBasePlugin_Lifecycle(t *testing.T) {
+	base := NewBasePlugin(PluginConfig{Name: "base", Version: "1.0.0", Description: "from config"}, "output", "")
+
+	metadata := base.GetMetadata()
+	require.NoError(t, metadata.Validate())
+	assert.Equal(t, "from config", metadata.Description)
+	assert.Equal(t, interfaces.StatusStopped, base.Health().Status)
+
+	ctx := context.Background()
+	require.NoError(t, base.Start(ctx))
+	assert.True(t, base.IsRunning())
+	assert.Equal(t, interfaces.StatusHealthy, base.Health().Status)
+
+	base.RecordError(fmt.Errorf("upload failed"))
+	health := base.Health()
+	assert.Equal(t, interfaces.StatusWarning, health.Status)
+	assert.Equal(t, "upload failed", health.Message)
+
+	base.RecordError(nil)
+	assert.Equal(t, interfaces.StatusHealthy, base.Health().Status)
+
+	require.NoError(t, base.Stop(ctx))
+	assert.Equal(t, interfaces.StatusStopped, base.Health().Status)
+}
+
+func TestBasePlugin_HealthDetailsAreCopied(t *testing.T) {
+	base := NewBasePlugin(PluginConfig{Name: "base", Version: "1.0.0"}, "input", "desc")
+	base.SetHealthDetail("queued", 3)
+
+	details := base.Health().Details
+	assert.Equal(t, 3, details["queued"])
+
+	details["queued"] = 99
+	assert.Equal(t, 3, base.Health().Details["queued"])
+
+	info := base.Info()
+	assert.Equal(t, "input", info.Type)
+	assert.Equal(t, "desc", info.Description)
+}
+
+func TestBasePlugin_HTTPClient(t *testing.T) {
+	factory, err := httpclient.NewFactory(httpclient.Options{})
+	require.NoError(t, err)
+	base := NewBasePlugin(PluginConfig{Name: "svc", Version: "1.0.0", HTTP: factory}, "output", "")
+
+	client, err := base.HTTPClient(Settings{"timeout": "5s"}, httpclient.ClientOptions{DefaultTimeout: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, client.Timeout)
+	assert.Equal(t, []string{"svc"}, factory.Services(), "clients come from the supplied factory")
+
+	_, err = base.HTTPClient(Settings{"proxy": "ftp://proxy"}, httpclient.ClientOptions{})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = base.HTTPClient(Settings{"timeout": "-1s"}, httpclient.ClientOptions{})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	standalone := NewBasePlugin(PluginConfig{Name: "alone", Version: "1.0.0"}, "output", "")
+	client, err = standalone.HTTPClient(nil, httpclient.ClientOptions{DefaultTimeout: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, client.Timeout)
+}
@@ -3,6 +3,8 @@ package plugins
 import (
 	"fmt"
 	"strings"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
 )
 
 // PluginConfig defines the configuration for a plugin
@@ -13,6 +15,9 @@ type PluginConfig struct {
 	Description string                 `yaml:"description,omitempty" json:"description,omitempty"`
 	Enabled     bool                   `yaml:"enabled" json:"enabled"`
 	Settings    map[string]interface{} `yaml:"settings,omitempty" json:"settings,omitempty"`
+	// HTTP is supplied by the host; plugins get their clients from it
+	// through BasePlugin.HTTPClient
+	HTTP *httpclient.Factory `yaml:"-" json:"-"`
 }
 
 // Validate checks if the PluginConfig is valid
@@ -51,6 +56,7 @@ func (c *PluginConfig) Clone() PluginConfig {
 		Version:     c.Version,
 		Description: c.Description,
 		Enabled:     c.Enabled,
+		HTTP:        c.HTTP,
 	}
 	
 	// Deep copy settings
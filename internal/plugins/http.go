@@ -0,0 +1,49 @@
+package plugins
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
+)
+
+// defaultHTTP is the factory shared by plugins created without one, so their
+// metrics are collected in one place and it is not rebuilt on every call
+var defaultHTTP = sync.OnceValues(func() (*httpclient.Factory, error) {
+	return httpclient.NewFactory(httpclient.Options{})
+})
+
+// HTTPClient returns a client for this plugin from the host's factory. The
+// "timeout", "proxy", "user_agent" and "http_cache" settings override opts;
+// a plugin created without a factory, as in tests, gets a shared one with
+// the built-in defaults.
+func (b *BasePlugin) HTTPClient(settings Settings, opts httpclient.ClientOptions) (*http.Client, error) {
+	timeout, err := settings.Duration("timeout", opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if timeout < 0 {
+		return nil, fmt.Errorf("%w: timeout must not be negative", ErrInvalidConfig)
+	}
+	cache, err := settings.Bool("http_cache", opts.Cache)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	opts.Timeout = timeout
+	opts.Cache = cache
+	opts.Proxy = settings.String("proxy", opts.Proxy)
+	opts.UserAgent = settings.String("user_agent", opts.UserAgent)
+
+	factory := b.http
+	if factory == nil {
+		if factory, err = defaultHTTP(); err != nil {
+			return nil, err
+		}
+	}
+	client, err := factory.Client(b.metadata.Name, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return client, nil
+}
@@ -0,0 +1,34 @@
+package plugins
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/config"
+	"github.com/sho7650/media-sync/internal/httpclient"
+)
+
+func TestBasePlugin_HTTPClientTimeout(t *testing.T) {
+	opts, err := httpclient.OptionsFromConfig(config.GlobalConfig{Timeout: "10s"})
+	require.NoError(t, err)
+	factory, err := httpclient.NewFactory(opts)
+	require.NoError(t, err)
+	plugin := NewBasePlugin(PluginConfig{Name: "uploader", Type: "output", HTTP: factory}, "output", "")
+	uploads := httpclient.ClientOptions{DefaultTimeout: 5 * time.Minute}
+
+	client, err := plugin.HTTPClient(Settings{}, uploads)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, client.Timeout, "the global timeout wins over the plugin default")
+
+	client, err = plugin.HTTPClient(Settings{"timeout": "2m"}, uploads)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, client.Timeout, "a service timeout overrides the global one")
+
+	standalone := NewBasePlugin(PluginConfig{Name: "uploader", Type: "output"}, "output", "")
+	client, err = standalone.HTTPClient(Settings{}, uploads)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, client.Timeout, "the plugin default applies without a global timeout")
+}
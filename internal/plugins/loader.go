@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
 )
 
 // PluginLoader handles dynamic plugin loading
@@ -15,6 +17,7 @@ type PluginLoader struct {
 	registry  *PluginRegistry
 	factories *FactoryRegistry
 	discovery *PluginDiscovery
+	http      *httpclient.Factory
 }
 
 // NewPluginLoader creates a new plugin loader
@@ -26,6 +29,12 @@ func NewPluginLoader(registry *PluginRegistry, factories *FactoryRegistry) *Plug
 	}
 }
 
+// SetHTTPFactory supplies the HTTP client factory to plugins loaded
+// without one
+func (l *PluginLoader) SetHTTPFactory(f *httpclient.Factory) {
+	l.http = f
+}
+
 // LoadPlugin loads a plugin from configuration
 func (l *PluginLoader) LoadPlugin(config PluginConfig) error {
 	// Skip disabled plugins
@@ -38,6 +47,10 @@ func (l *PluginLoader) LoadPlugin(config PluginConfig) error {
 		return NewPluginError(config.Name, "validation", err)
 	}
 	
+	if config.HTTP == nil {
+		config.HTTP = l.http
+	}
+	
 	// Get factory for plugin type
 	factory, err := l.factories.GetFactory(config.Type)
 	if err != nil {
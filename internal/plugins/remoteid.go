@@ -0,0 +1,50 @@
+package plugins
+
+import "fmt"
+
+// RemoteID returns the ID an output recorded under the metadata key for
+// scope, usually the URL of the server it publishes to. IDs recorded for
+// other scopes, such as another output in the same pipeline, are ignored.
+func RemoteID(metadata map[string]interface{}, key, scope string) string {
+	switch ids := metadata[key].(type) {
+	case map[string]interface{}:
+		if id, ok := ids[scope].(string); ok {
+			return id
+		}
+	case map[string]string:
+		return ids[scope]
+	case map[interface{}]interface{}:
+		if id, ok := ids[scope].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// SetRemoteID records id under the metadata key for scope, keeping the IDs
+// recorded for other scopes. The map is copied so streams sharing it are
+// not affected.
+func SetRemoteID(metadata map[string]interface{}, key, scope, id string) {
+	ids := map[string]interface{}{scope: id}
+	switch existing := metadata[key].(type) {
+	case map[string]interface{}:
+		for k, v := range existing {
+			if k != scope {
+				ids[k] = v
+			}
+		}
+	case map[string]string:
+		for k, v := range existing {
+			if k != scope {
+				ids[k] = v
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, v := range existing {
+			if s := fmt.Sprint(k); s != scope {
+				ids[s] = v
+			}
+		}
+	}
+	metadata[key] = ids
+}
@@ -0,0 +1,34 @@
+package plugins
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteID_ScopedPerServer(t *testing.T) {
+	metadata := map[string]interface{}{}
+	assert.Empty(t, RemoteID(metadata, "asset_ids", "https://a.example"))
+
+	SetRemoteID(metadata, "asset_ids", "https://a.example", "a-1")
+	SetRemoteID(metadata, "asset_ids", "https://b.example", "b-1")
+	assert.Equal(t, "a-1", RemoteID(metadata, "asset_ids", "https://a.example"))
+	assert.Equal(t, "b-1", RemoteID(metadata, "asset_ids", "https://b.example"))
+	assert.Empty(t, RemoteID(metadata, "asset_ids", "https://c.example"))
+
+	// Maps decoded from YAML or JSON are read as well
+	decoded := map[string]interface{}{"asset_ids": map[interface{}]interface{}{"https://a.example": "a-2"}}
+	assert.Equal(t, "a-2", RemoteID(decoded, "asset_ids", "https://a.example"))
+	SetRemoteID(decoded, "asset_ids", "https://b.example", "b-2")
+	assert.Equal(t, map[string]interface{}{"https://a.example": "a-2", "https://b.example": "b-2"}, decoded["asset_ids"])
+}
+
+func TestSetRemoteID_CopiesSharedMap(t *testing.T) {
+	shared := map[string]interface{}{"https://a.example": "a-1"}
+	first := map[string]interface{}{"asset_ids": shared}
+	second := map[string]interface{}{"asset_ids": shared}
+
+	SetRemoteID(first, "asset_ids", "https://b.example", "b-1")
+	assert.Equal(t, "b-1", RemoteID(first, "asset_ids", "https://b.example"))
+	assert.Empty(t, RemoteID(second, "asset_ids", "https://b.example"))
+}
@@ -0,0 +1,202 @@
+package plugins
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Settings wraps a plugin settings map with typed accessors. Values may come
+// from YAML or JSON, so numbers are accepted as any integer or float type and
+// durations as either Go duration strings or a number of seconds.
+type Settings map[string]interface{}
+
+// Has reports whether key is present
+func (s Settings) Has(key string) bool {
+	_, ok := s[key]
+	return ok
+}
+
+// String returns the string value for key or def when unset
+func (s Settings) String(key, def string) string {
+	v, ok := s[key]
+	if !ok || v == nil {
+		return def
+	}
+	if str, ok := v.(string); ok {
+		return str
+	}
+	return fmt.Sprint(v)
+}
+
+// Int returns the integer value for key or def when unset
+func (s Settings) Int(key string, def int) (int, error) {
+	v, ok := s[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	case string:
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return def, fmt.Errorf("%w: %s must be an integer", ErrInvalidConfig, key)
+		}
+		return i, nil
+	}
+	return def, fmt.Errorf("%w: %s must be an integer", ErrInvalidConfig, key)
+}
+
+// Float returns the floating point value for key or def when unset
+func (s Settings) Float(key string, def float64) (float64, error) {
+	v, ok := s[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return def, fmt.Errorf("%w: %s must be a number", ErrInvalidConfig, key)
+		}
+		return f, nil
+	}
+	return def, fmt.Errorf("%w: %s must be a number", ErrInvalidConfig, key)
+}
+
+// Bool returns the boolean value for key or def when unset
+func (s Settings) Bool(key string, def bool) (bool, error) {
+	v, ok := s[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch b := v.(type) {
+	case bool:
+		return b, nil
+	case string:
+		parsed, err := strconv.ParseBool(b)
+		if err != nil {
+			return def, fmt.Errorf("%w: %s must be a boolean", ErrInvalidConfig, key)
+		}
+		return parsed, nil
+	}
+	return def, fmt.Errorf("%w: %s must be a boolean", ErrInvalidConfig, key)
+}
+
+// Duration returns the duration value for key or def when unset
+func (s Settings) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := s[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch d := v.(type) {
+	case string:
+		parsed, err := time.ParseDuration(d)
+		if err != nil {
+			return def, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, key, err)
+		}
+		return parsed, nil
+	case int:
+		return time.Duration(d) * time.Second, nil
+	case int64:
+		return time.Duration(d) * time.Second, nil
+	case float64:
+		return time.Duration(d * float64(time.Second)), nil
+	}
+	return def, fmt.Errorf("%w: %s must be a duration", ErrInvalidConfig, key)
+}
+
+// StringSlice returns the list of strings for key, accepting a single string
+func (s Settings) StringSlice(key string) ([]string, error) {
+	v, ok := s[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch list := v.(type) {
+	case string:
+		return []string{list}, nil
+	case []string:
+		return list, nil
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s must be a list of strings", ErrInvalidConfig, key)
+			}
+			out = append(out, str)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%w: %s must be a list of strings", ErrInvalidConfig, key)
+}
+
+// Map returns the nested settings for key
+func (s Settings) Map(key string) (Settings, error) {
+	v, ok := s[key]
+	if !ok || v == nil {
+		return Settings{}, nil
+	}
+	out, ok := toSettings(v)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s must be a mapping", ErrInvalidConfig, key)
+	}
+	return out, nil
+}
+
+// MapSlice returns the list of nested settings for key
+func (s Settings) MapSlice(key string) ([]Settings, error) {
+	v, ok := s[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	var items []interface{}
+	switch list := v.(type) {
+	case []interface{}:
+		items = list
+	case []map[string]interface{}:
+		for _, m := range list {
+			items = append(items, m)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s must be a list of mappings", ErrInvalidConfig, key)
+	}
+
+	out := make([]Settings, 0, len(items))
+	for _, item := range items {
+		m, ok := toSettings(item)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s must be a list of mappings", ErrInvalidConfig, key)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// toSettings converts the mapping types produced by YAML and JSON decoders
+func toSettings(v interface{}) (Settings, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return Settings(m), true
+	case Settings:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(Settings, len(m))
+		for k, val := range m {
+			out[fmt.Sprint(k)] = val
+		}
+		return out, true
+	}
+	return nil, false
+}
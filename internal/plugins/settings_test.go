@@ -0,0 +1,92 @@
+package plugins
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettings_TypedAccessors(t *testing.T) {
+	s := Settings{
+		"name":     "dav",
+		"port":     22,
+		"retries":  float64(3),
+		"ratio":    "0.5",
+		"enabled":  "true",
+		"timeout":  "1m30s",
+		"interval": 5,
+		"tags":     []interface{}{"a", "b"},
+		"nested":   map[string]interface{}{"key": "value"},
+		"rules": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[interface{}]interface{}{"name": "b"},
+		},
+	}
+
+	assert.Equal(t, "dav", s.String("name", ""))
+	assert.Equal(t, "fallback", s.String("missing", "fallback"))
+
+	port, err := s.Int("port", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 22, port)
+
+	retries, err := s.Int("retries", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, retries)
+
+	ratio, err := s.Float("ratio", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, ratio)
+
+	enabled, err := s.Bool("enabled", false)
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	timeout, err := s.Duration("timeout", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, timeout)
+
+	interval, err := s.Duration("interval", 0)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, interval)
+
+	tags, err := s.StringSlice("tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, tags)
+
+	nested, err := s.Map("nested")
+	require.NoError(t, err)
+	assert.Equal(t, "value", nested.String("key", ""))
+
+	rules, err := s.MapSlice("rules")
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "b", rules[1].String("name", ""))
+}
+
+func TestSettings_InvalidValues(t *testing.T) {
+	s := Settings{
+		"port":    "twenty",
+		"enabled": 1,
+		"timeout": "soon",
+		"tags":    []interface{}{1},
+		"rules":   []interface{}{"a"},
+	}
+
+	_, err := s.Int("port", 0)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = s.Bool("enabled", false)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = s.Duration("timeout", 0)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = s.StringSlice("tags")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	_, err = s.MapSlice("rules")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,86 @@
+// Package ratelimit paces work, such as bytes read from disk or the
+// network, to a shared rate.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter paces work to a number of units per second by handing out
+// consecutive time slots; a nil Limiter does not limit
+type Limiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+// New returns a limiter for perSecond units, or nil when perSecond is not
+// positive
+func New(perSecond int64) *Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &Limiter{rate: float64(perSecond)}
+}
+
+// Wait blocks until n more units fit within the rate
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// readBuffer bounds each read so pacing stays smooth under a low rate
+const readBuffer = 32 << 10
+
+// Reader paces reads from r to the limiter's rate in bytes, calling count,
+// when not nil, with the bytes of each read
+func (l *Limiter) Reader(ctx context.Context, r io.Reader, count func(n int)) io.Reader {
+	return &pacedReader{ctx: ctx, r: r, limiter: l, count: count}
+}
+
+type pacedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *Limiter
+	count   func(n int)
+}
+
+func (p *pacedReader) Read(b []byte) (int, error) {
+	if len(b) > readBuffer {
+		b = b[:readBuffer]
+	}
+	n, err := p.r.Read(b)
+	if n > 0 {
+		if waitErr := p.limiter.Wait(p.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+		if p.count != nil {
+			p.count(n)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(100_000)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(context.Background(), 10_000))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.Wait(ctx, 100_000), context.Canceled)
+	assert.NoError(t, (*Limiter)(nil).Wait(context.Background(), 1<<30))
+}
+
+func TestLimiter_Reader(t *testing.T) {
+	var counted int
+	r := New(1<<20).Reader(context.Background(), bytes.NewReader(make([]byte, 100_000)), func(n int) { counted += n })
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Len(t, b, 100_000)
+	assert.Equal(t, 100_000, counted)
+
+	b, err = io.ReadAll((*Limiter)(nil).Reader(context.Background(), bytes.NewReader([]byte("abc")), nil))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(b))
+}
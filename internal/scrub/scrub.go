@@ -0,0 +1,400 @@
+// Package scrub re-verifies the local files of stored media against their
+// checksums, and the blobs they reference against their digests, to catch
+// bit rot. Every check is recorded with its time and result, so missing and
+// corrupt files can be listed, and damaged files can be restored from
+// another copy.
+package scrub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/blob"
+	"github.com/sho7650/media-sync/internal/checksum"
+	"github.com/sho7650/media-sync/internal/ratelimit"
+	"github.com/sho7650/media-sync/internal/storage"
+)
+
+const (
+	defaultInterval  = 30 * 24 * time.Hour
+	defaultBatchSize = 100
+)
+
+// Store lists the items due for a check, and the blobs they reference, and
+// records the results
+type Store interface {
+	DueForVerification(ctx context.Context, before time.Time, limit int) ([]*storage.MediaItem, error)
+	RecordVerification(ctx context.Context, v storage.Verification) error
+	MediaBlobs(ctx context.Context, mediaID string) ([]string, error)
+}
+
+// Source fetches another copy of an item, such as from the service it came
+// from or from an output that holds it
+type Source struct {
+	Name  string
+	Fetch func(ctx context.Context, item *storage.MediaItem) (io.ReadCloser, error)
+}
+
+// Config configures a Scrubber
+type Config struct {
+	// Interval is how long a check stays current; items checked more
+	// recently are skipped. It defaults to 30 days.
+	Interval time.Duration
+	// BatchSize is the number of items loaded at a time, 100 by default
+	BatchSize int
+	// BytesPerSecond bounds reads and repairs across the scrub, so it does
+	// not saturate the disks; zero is unlimited
+	BytesPerSecond int64
+	// FilesPerSecond bounds the files checked, for archives of small files
+	// where seeks rather than bytes are the cost; zero is unlimited
+	FilesPerSecond int64
+	// Repair restores missing and corrupt files from Sources, tried in
+	// order; a copy is only used once it matches the checksum
+	Repair  bool
+	Sources []Source
+	// Blobs is the store holding the blobs items reference; each one is
+	// verified against its digest. Without it only local files are checked.
+	Blobs *blob.Store
+	// Report, when set, receives the results of each pass as health
+	// details, such as from BasePlugin.SetHealthDetail
+	Report func(key string, value interface{})
+}
+
+// Summary counts the results of a pass
+type Summary struct {
+	Checked      int   `json:"checked"`
+	OK           int   `json:"ok"`
+	Missing      int   `json:"missing"`
+	Corrupt      int   `json:"corrupt"`
+	Repaired     int   `json:"repaired"`
+	Unverifiable int   `json:"unverifiable"`
+	Bytes        int64 `json:"bytes"`
+}
+
+func (s *Summary) add(status storage.VerificationStatus, size int64) {
+	s.Checked++
+	s.Bytes += size
+	switch status {
+	case storage.VerificationOK:
+		s.OK++
+	case storage.VerificationMissing:
+		s.Missing++
+	case storage.VerificationCorrupt:
+		s.Corrupt++
+	case storage.VerificationRepaired:
+		s.Repaired++
+	case storage.VerificationUnverifiable:
+		s.Unverifiable++
+	}
+}
+
+// Scrubber checks local files against their recorded checksums
+type Scrubber struct {
+	store Store
+	cfg   Config
+	bytes *ratelimit.Limiter
+	files *ratelimit.Limiter
+	now   func() time.Time
+}
+
+// New creates a scrubber
+func New(store Store, cfg Config) (*Scrubber, error) {
+	if store == nil {
+		return nil, fmt.Errorf("scrub store cannot be nil")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	for _, src := range cfg.Sources {
+		if src.Fetch == nil {
+			return nil, fmt.Errorf("scrub source %q has no fetch function", src.Name)
+		}
+	}
+	return &Scrubber{
+		store: store,
+		cfg:   cfg,
+		bytes: ratelimit.New(cfg.BytesPerSecond),
+		files: ratelimit.New(cfg.FilesPerSecond),
+		now:   time.Now,
+	}, nil
+}
+
+// Run scrubs in the background: it makes a pass, then waits every before
+// the next, until ctx is done. A pass that fails is reported and retried at
+// the next round.
+func (s *Scrubber) Run(ctx context.Context, every time.Duration) error {
+	if every <= 0 {
+		return fmt.Errorf("scrub period must be positive, got %s", every)
+	}
+	for {
+		if _, err := s.Pass(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s.report("scrub_error", err.Error())
+		}
+		timer := time.NewTimer(every)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Pass checks every item that is due and records the results
+func (s *Scrubber) Pass(ctx context.Context) (Summary, error) {
+	var summary Summary
+	before := s.now().Add(-s.cfg.Interval)
+	for {
+		items, err := s.store.DueForVerification(ctx, before, s.cfg.BatchSize)
+		if err != nil {
+			return summary, err
+		}
+		if len(items) == 0 {
+			break
+		}
+		for _, item := range items {
+			v, size, err := s.check(ctx, item)
+			if err != nil {
+				return summary, err
+			}
+			if err := s.store.RecordVerification(ctx, v); err != nil {
+				return summary, err
+			}
+			summary.add(v.Status, size)
+		}
+	}
+
+	s.report("scrub_last_pass", s.now())
+	s.report("scrub_checked", summary.Checked)
+	s.report("scrub_missing", summary.Missing)
+	s.report("scrub_corrupt", summary.Corrupt)
+	s.report("scrub_repaired", summary.Repaired)
+	return summary, nil
+}
+
+// Check verifies the local file of one item, repairing it if configured,
+// and returns the result without recording it
+func (s *Scrubber) Check(ctx context.Context, item *storage.MediaItem) (storage.Verification, error) {
+	v, _, err := s.check(ctx, item)
+	return v, err
+}
+
+// target is a file holding the content of an item and the checksum it
+// must match; label names blobs in details
+type target struct {
+	label string
+	path  string
+	sum   string
+}
+
+// severity orders results so an item reports its worst file
+var severity = map[storage.VerificationStatus]int{
+	storage.VerificationOK:           0,
+	storage.VerificationRepaired:     1,
+	storage.VerificationUnverifiable: 2,
+	storage.VerificationCorrupt:      3,
+	storage.VerificationMissing:      4,
+}
+
+// check returns the result of an item and the bytes read to reach it: the
+// worst result of its local file and its blobs. The only errors are those
+// of ctx, as an interrupted read says nothing about the file, and failures
+// to list the item's blobs.
+func (s *Scrubber) check(ctx context.Context, item *storage.MediaItem) (storage.Verification, int64, error) {
+	if err := s.files.Wait(ctx, 1); err != nil {
+		return storage.Verification{}, 0, err
+	}
+	targets, err := s.targets(ctx, item)
+	if err != nil {
+		return storage.Verification{}, 0, err
+	}
+
+	status, size := storage.VerificationOK, int64(0)
+	var details []string
+	if len(targets) == 0 {
+		status = storage.VerificationUnverifiable
+		details = append(details, "no local file or blob store to check")
+	}
+	for _, t := range targets {
+		st, detail, n := s.checkTarget(ctx, item, t)
+		if err := ctx.Err(); err != nil {
+			return storage.Verification{}, 0, err
+		}
+		size += n
+		if severity[st] > severity[status] {
+			status = st
+		}
+		if detail != "" {
+			if t.label != "" {
+				detail = t.label + ": " + detail
+			}
+			details = append(details, detail)
+		}
+	}
+	return storage.Verification{
+		MediaID: item.ID, Status: status, Detail: strings.Join(details, "; "), VerifiedAt: s.now(),
+	}, size, nil
+}
+
+// targets lists the local file of an item and the blobs it references
+func (s *Scrubber) targets(ctx context.Context, item *storage.MediaItem) ([]target, error) {
+	var targets []target
+	if item.LocalPath != "" {
+		targets = append(targets, target{path: item.LocalPath, sum: item.Checksum})
+	}
+	if s.cfg.Blobs == nil {
+		return targets, nil
+	}
+	digests, err := s.store.MediaBlobs(ctx, item.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs of %s: %w", item.ID, err)
+	}
+	for _, digest := range digests {
+		// An invalid digest leaves the path empty, which verify reports
+		path, _ := s.cfg.Blobs.Path(digest)
+		targets = append(targets, target{label: "blob " + digest, path: path, sum: digest})
+	}
+	return targets, nil
+}
+
+// checkTarget verifies one file, repairing it if configured
+func (s *Scrubber) checkTarget(ctx context.Context, item *storage.MediaItem, t target) (storage.VerificationStatus, string, int64) {
+	status, detail, size := s.verify(ctx, t.path, t.sum)
+	if ctx.Err() != nil || !s.cfg.Repair {
+		return status, detail, size
+	}
+	if status != storage.VerificationMissing && status != storage.VerificationCorrupt {
+		return status, detail, size
+	}
+	name, err := s.repair(ctx, item, t)
+	if err != nil {
+		return status, fmt.Sprintf("%s; repair failed: %v", detail, err), size
+	}
+	return storage.VerificationRepaired, fmt.Sprintf("%s; restored from %s", detail, name), size
+}
+
+// verify hashes a file and compares it with its checksum
+func (s *Scrubber) verify(ctx context.Context, path, sum string) (storage.VerificationStatus, string, int64) {
+	if path == "" {
+		return storage.VerificationUnverifiable, fmt.Sprintf("no file for checksum %q", sum), 0
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return storage.VerificationMissing, "file not found", 0
+	}
+	if err != nil {
+		return storage.VerificationCorrupt, err.Error(), 0
+	}
+	defer f.Close()
+
+	sum = checksum.Normalize(sum)
+	hasher, err := hasherFor(sum)
+	if err != nil {
+		return storage.VerificationUnverifiable, err.Error(), 0
+	}
+	if _, err := io.Copy(hasher, s.bytes.Reader(ctx, f, nil)); err != nil {
+		return storage.VerificationCorrupt, fmt.Sprintf("read failed: %v", err), hasher.Size()
+	}
+	if err := hasher.Verify(sum); err != nil {
+		return storage.VerificationCorrupt, err.Error(), hasher.Size()
+	}
+	return storage.VerificationOK, "", hasher.Size()
+}
+
+// repair restores a file of an item from the first source whose copy
+// matches its checksum and returns the source's name
+func (s *Scrubber) repair(ctx context.Context, item *storage.MediaItem, t target) (string, error) {
+	if len(s.cfg.Sources) == 0 {
+		return "", fmt.Errorf("no sources to restore from")
+	}
+	var errs []error
+	for _, src := range s.cfg.Sources {
+		err := s.restore(ctx, item, t, src)
+		if err == nil {
+			return src.Name, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", src.Name, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+// restore writes a copy from src next to the file and moves it into place
+// once it matches the checksum
+func (s *Scrubber) restore(ctx context.Context, item *storage.MediaItem, t target, src Source) error {
+	sum := checksum.Normalize(t.sum)
+	hasher, err := hasherFor(sum)
+	if err != nil {
+		return err
+	}
+	r, err := src.Fetch(ctx, item)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dir := filepath.Dir(t.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".scrub-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(io.MultiWriter(tmp, hasher), s.bytes.Reader(ctx, r, nil))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := hasher.Verify(sum); err != nil {
+		return err
+	}
+
+	mode := fs.FileMode(0o644)
+	if t.label != "" {
+		// Blobs are written read-only by the store
+		mode = 0o440
+	}
+	if info, err := os.Stat(t.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
+
+// hasherFor returns a hasher for the algorithm of sum, failing for
+// checksums that cannot be verified
+func hasherFor(sum string) (*checksum.Hasher, error) {
+	algorithm, _, err := checksum.Parse(sum)
+	if err != nil {
+		return nil, err
+	}
+	return checksum.NewHasher(algorithm)
+}
+
+func (s *Scrubber) report(key string, value interface{}) {
+	if s.cfg.Report != nil {
+		s.cfg.Report(key, value)
+	}
+}
@@ -0,0 +1,260 @@
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/blob"
+	"github.com/sho7650/media-sync/internal/download"
+	"github.com/sho7650/media-sync/internal/storage"
+)
+
+func sha(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+type fixture struct {
+	db  *storage.SQLiteStorage
+	dir string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	dir := t.TempDir()
+	db := storage.NewSQLiteStorage(filepath.Join(dir, "media.db"))
+	require.NoError(t, db.Initialize(context.Background()))
+	t.Cleanup(func() { _ = db.Close() })
+	return &fixture{db: db, dir: dir}
+}
+
+// add stores an item whose file, when content is not nil, holds content
+func (f *fixture) add(t *testing.T, id, checksum string, content *string) *storage.MediaItem {
+	t.Helper()
+	path := filepath.Join(f.dir, "archive", id+".jpg")
+	if content != nil {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(*content), 0o640))
+	}
+	now := time.Now().UTC()
+	item := &storage.MediaItem{
+		ID: id, ServiceID: "tumblr", ExternalID: id, Type: "photo", URL: "https://example.com/" + id,
+		LocalPath: path, Checksum: checksum, Metadata: map[string]interface{}{}, CreatedAt: now, SyncedAt: now,
+	}
+	require.NoError(t, f.db.StoreMedia(context.Background(), item))
+	return item
+}
+
+func ptr(s string) *string { return &s }
+
+func (f *fixture) status(t *testing.T, id string) storage.Verification {
+	t.Helper()
+	v, err := f.db.GetVerification(context.Background(), id)
+	require.NoError(t, err)
+	require.NotNil(t, v, id)
+	return *v
+}
+
+func TestPass(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.add(t, "good", sha("good bytes"), ptr("good bytes"))
+	f.add(t, "rotten", sha("good bytes"), ptr("good bytez"))
+	f.add(t, "gone", sha("gone bytes"), nil)
+	f.add(t, "opaque", "etag-1234", ptr("bytes"))
+	now := time.Now().UTC()
+	require.NoError(t, f.db.StoreMedia(ctx, &storage.MediaItem{
+		ID: "remote", ServiceID: "tumblr", ExternalID: "remote", Type: "photo", URL: "https://example.com/remote",
+		Checksum: sha("x"), Metadata: map[string]interface{}{}, CreatedAt: now, SyncedAt: now,
+	}))
+
+	details := map[string]interface{}{}
+	s, err := New(f.db, Config{BatchSize: 2, Report: func(k string, v interface{}) { details[k] = v }})
+	require.NoError(t, err)
+
+	summary, err := s.Pass(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Checked: 4, OK: 1, Missing: 1, Corrupt: 1, Unverifiable: 1, Bytes: 20}, summary,
+		"items without a local file are not checked")
+
+	assert.Equal(t, storage.VerificationOK, f.status(t, "good").Status)
+	rotten := f.status(t, "rotten")
+	assert.Equal(t, storage.VerificationCorrupt, rotten.Status)
+	assert.Contains(t, rotten.Detail, "checksum mismatch")
+	assert.WithinDuration(t, time.Now(), rotten.VerifiedAt, time.Minute)
+	assert.Equal(t, storage.VerificationMissing, f.status(t, "gone").Status)
+	assert.Equal(t, storage.VerificationUnverifiable, f.status(t, "opaque").Status)
+
+	flagged, err := f.db.ListVerifications(ctx, storage.VerificationCorrupt)
+	require.NoError(t, err)
+	require.Len(t, flagged, 1)
+	assert.Equal(t, "rotten", flagged[0].MediaID)
+	assert.Equal(t, 1, details["scrub_corrupt"])
+	assert.Equal(t, 1, details["scrub_missing"])
+
+	summary, err = s.Pass(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, summary.Checked, "recently checked items are skipped")
+
+	s.now = func() time.Time { return time.Now().Add(31 * 24 * time.Hour) }
+	summary, err = s.Pass(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, summary.Checked, "checks expire after the interval")
+}
+
+func TestPass_Repair(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	rotten := f.add(t, "rotten", sha("good bytes"), ptr("good bytez"))
+	gone := f.add(t, "gone", sha("gone bytes"), nil)
+	f.add(t, "lost", sha("lost bytes"), nil)
+
+	copies := map[string]string{"rotten": "good bytes", "gone": "gone bytes", "lost": "other bytes"}
+	var asked []string
+	s, err := New(f.db, Config{
+		Repair: true,
+		Sources: []Source{
+			{Name: "offline", Fetch: func(ctx context.Context, item *storage.MediaItem) (io.ReadCloser, error) {
+				return nil, errors.New("unreachable")
+			}},
+			{Name: "webdav", Fetch: func(ctx context.Context, item *storage.MediaItem) (io.ReadCloser, error) {
+				asked = append(asked, item.ID)
+				return io.NopCloser(strings.NewReader(copies[item.ID])), nil
+			}},
+		},
+	})
+	require.NoError(t, err)
+
+	summary, err := s.Pass(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Repaired)
+	assert.Equal(t, 1, summary.Missing)
+	assert.ElementsMatch(t, []string{"rotten", "gone", "lost"}, asked)
+
+	for _, item := range []*storage.MediaItem{rotten, gone} {
+		v := f.status(t, item.ID)
+		assert.Equal(t, storage.VerificationRepaired, v.Status)
+		assert.Contains(t, v.Detail, "restored from webdav")
+		b, err := os.ReadFile(item.LocalPath)
+		require.NoError(t, err)
+		assert.Equal(t, copies[item.ID], string(b))
+	}
+	info, err := os.Stat(rotten.LocalPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm(), "the file mode is kept")
+
+	lost := f.status(t, "lost")
+	assert.Equal(t, storage.VerificationMissing, lost.Status)
+	assert.Contains(t, lost.Detail, "offline: unreachable")
+	assert.Contains(t, lost.Detail, "webdav: checksum mismatch")
+	entries, err := os.ReadDir(filepath.Dir(rotten.LocalPath))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "rejected copies are not left behind")
+}
+
+func TestPass_Blobs(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	blobs, err := blob.NewStore(filepath.Join(f.dir, "blobs"), f.db)
+	require.NoError(t, err)
+	now := time.Now().UTC()
+	for _, id := range []string{"kept", "rotten"} {
+		require.NoError(t, f.db.StoreMedia(ctx, &storage.MediaItem{
+			ID: id, ServiceID: "tumblr", ExternalID: id, Type: "photo", URL: "https://example.com/" + id,
+			Checksum: sha(id + " bytes"), Metadata: map[string]interface{}{}, CreatedAt: now, SyncedAt: now,
+		}))
+		_, err := blobs.Put(ctx, id, strings.NewReader(id+" bytes"))
+		require.NoError(t, err)
+	}
+	path, err := blobs.Path(sha("rotten bytes"))
+	require.NoError(t, err)
+	require.NoError(t, os.Chmod(path, 0o640))
+	require.NoError(t, os.WriteFile(path, []byte("rotten bytez"), 0o640))
+
+	s, err := New(f.db, Config{Blobs: blobs})
+	require.NoError(t, err)
+	summary, err := s.Pass(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Checked: 2, OK: 1, Corrupt: 1, Bytes: 22}, summary, "items stored only as blobs are checked")
+	rotten := f.status(t, "rotten")
+	assert.Equal(t, storage.VerificationCorrupt, rotten.Status)
+	assert.Contains(t, rotten.Detail, "blob "+sha("rotten bytes"))
+
+	s, err = New(f.db, Config{Blobs: blobs, Repair: true, Sources: []Source{{
+		Name: "origin",
+		Fetch: func(ctx context.Context, item *storage.MediaItem) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(item.ID + " bytes")), nil
+		},
+	}}})
+	require.NoError(t, err)
+	s.now = func() time.Time { return time.Now().Add(31 * 24 * time.Hour) }
+	summary, err = s.Pass(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Repaired)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "rotten bytes", string(b))
+}
+
+func TestDownloadSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "a.jpg", time.Time{}, bytes.NewReader([]byte("gone bytes")))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	f := newFixture(t)
+	item := f.add(t, "gone", sha("gone bytes"), nil)
+	item.URL = ts.URL + "/a.jpg"
+	m, err := download.NewManager(download.Config{Dir: filepath.Join(f.dir, "downloads")})
+	require.NoError(t, err)
+
+	s, err := New(f.db, Config{Repair: true, Sources: []Source{DownloadSource(m)}})
+	require.NoError(t, err)
+	v, err := s.Check(ctx, item)
+	require.NoError(t, err)
+	assert.Equal(t, storage.VerificationRepaired, v.Status)
+	b, err := os.ReadFile(item.LocalPath)
+	require.NoError(t, err)
+	assert.Equal(t, "gone bytes", string(b))
+
+	entries, err := os.ReadDir(filepath.Join(f.dir, "downloads"))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the downloaded copy is removed")
+}
+
+func TestRun_Cancel(t *testing.T) {
+	f := newFixture(t)
+	f.add(t, "first", sha("good bytes"), ptr("good bytes"))
+	f.add(t, "second", sha("good bytes"), ptr("good bytes"))
+	// The first read is paid for by waiting before the next one
+	s, err := New(f.db, Config{BytesPerSecond: 1})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Run(ctx, time.Hour), context.DeadlineExceeded)
+	assert.Equal(t, storage.VerificationOK, f.status(t, "first").Status)
+	v, err := f.db.GetVerification(context.Background(), "second")
+	require.NoError(t, err)
+	assert.Nil(t, v, "an interrupted check is not recorded")
+
+	_, err = New(nil, Config{})
+	assert.Error(t, err)
+	_, err = New(f.db, Config{Sources: []Source{{Name: "empty"}}})
+	assert.Error(t, err)
+}
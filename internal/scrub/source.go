@@ -0,0 +1,56 @@
+package scrub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sho7650/media-sync/internal/checksum"
+	"github.com/sho7650/media-sync/internal/download"
+	"github.com/sho7650/media-sync/internal/storage"
+)
+
+// DownloadSource fetches items again from the URL they were synced from,
+// through a download manager
+func DownloadSource(m *download.Manager) Source {
+	return Source{
+		Name: "origin",
+		Fetch: func(ctx context.Context, item *storage.MediaItem) (io.ReadCloser, error) {
+			if item.URL == "" {
+				return nil, fmt.Errorf("item has no source URL")
+			}
+			req := download.Request{URL: item.URL, Key: "scrub-" + item.ID, Size: item.SizeBytes}
+			if sum := checksum.Normalize(item.Checksum); isChecksum(sum) {
+				req.Checksum = sum
+			}
+			res, err := m.Download(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			f, err := os.Open(res.Path)
+			if err != nil {
+				return nil, err
+			}
+			return &downloaded{File: f}, nil
+		},
+	}
+}
+
+func isChecksum(s string) bool {
+	_, _, err := checksum.Parse(s)
+	return err == nil
+}
+
+// downloaded deletes the downloaded copy once it has been read
+type downloaded struct {
+	*os.File
+}
+
+func (d *downloaded) Close() error {
+	err := d.File.Close()
+	if removeErr := os.Remove(d.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Ensure SQLiteStorage implements BlobIndex
+var _ BlobIndex = (*SQLiteStorage)(nil)
+
+// createBlobRefsTable creates the blob reference table. Media IDs are not
+// foreign keys: blobs are written, and referenced, before their item is
+// stored.
+func (s *SQLiteStorage) createBlobRefsTable(ctx context.Context) error {
+	table := `
+		CREATE TABLE IF NOT EXISTS blob_refs (
+			digest TEXT NOT NULL,
+			media_id TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (digest, media_id)
+		)`
+	if _, err := s.db.ExecContext(ctx, table); err != nil {
+		return fmt.Errorf("failed to create blob_refs table: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS idx_blob_refs_media ON blob_refs(media_id)"); err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	return nil
+}
+
+// AddBlobRef records that a media item uses a blob; adding an existing
+// reference again is a no-op
+func (s *SQLiteStorage) AddBlobRef(ctx context.Context, digest, mediaID string) error {
+	if !s.IsReady() {
+		return fmt.Errorf("storage not ready")
+	}
+	if digest == "" || mediaID == "" {
+		return fmt.Errorf("blob digest and media item ID cannot be empty")
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO blob_refs (digest, media_id, created_at) VALUES (?, ?, ?)`,
+		digest, mediaID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to add blob reference: %w", err)
+	}
+	return nil
+}
+
+// RemoveBlobRef drops the reference of a media item to a blob and returns
+// the number of references left
+func (s *SQLiteStorage) RemoveBlobRef(ctx context.Context, digest, mediaID string) (int, error) {
+	if !s.IsReady() {
+		return 0, fmt.Errorf("storage not ready")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+			// Log rollback error but preserve original error
+			_ = rollbackErr
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blob_refs WHERE digest = ? AND media_id = ?`, digest, mediaID); err != nil {
+		return 0, fmt.Errorf("failed to remove blob reference: %w", err)
+	}
+	var remaining int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM blob_refs WHERE digest = ?`, digest).Scan(&remaining); err != nil {
+		return 0, fmt.Errorf("failed to count blob references: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return remaining, nil
+}
+
+// BlobRefs returns the IDs of the media items using a blob
+func (s *SQLiteStorage) BlobRefs(ctx context.Context, digest string) ([]string, error) {
+	return s.queryStrings(ctx, `SELECT media_id FROM blob_refs WHERE digest = ? ORDER BY media_id`, digest)
+}
+
+// MediaBlobs returns the digests of the blobs a media item uses
+func (s *SQLiteStorage) MediaBlobs(ctx context.Context, mediaID string) ([]string, error) {
+	return s.queryStrings(ctx, `SELECT digest FROM blob_refs WHERE media_id = ? ORDER BY digest`, mediaID)
+}
+
+func (s *SQLiteStorage) queryStrings(ctx context.Context, query string, arg string) ([]string, error) {
+	if !s.IsReady() {
+		return nil, fmt.Errorf("storage not ready")
+	}
+	rows, err := s.db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rows: %w", err)
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			// Log close error but preserve original error
+			_ = closeErr
+		}
+	}()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return values, nil
+}
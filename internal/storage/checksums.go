@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sho7650/media-sync/internal/checksum"
+)
+
+// Ensure SQLiteStorage implements ChecksumIndex
+var _ ChecksumIndex = (*SQLiteStorage)(nil)
+
+// metadataChecksums is the metadata key under which blob.Ingest lists every
+// checksum known to match an item's content
+const metadataChecksums = "checksums"
+
+// prefixLegacyChecksums records the algorithm of checksums stored before
+// it was part of the value: bare 64-digit hex values are SHA-256. It is
+// idempotent, so it runs whenever a database is opened.
+const prefixLegacyChecksums = `UPDATE media_items SET checksum = 'sha256:' || lower(checksum)
+	WHERE length(checksum) = 64 AND lower(checksum) NOT GLOB '*[^0-9a-f]*'`
+
+// createChecksumsTable creates the table of checksums by algorithm, then
+// migrates legacy checksums and indexes those of items stored before it
+// existed
+func (s *SQLiteStorage) createChecksumsTable(ctx context.Context) error {
+	table := `
+		CREATE TABLE IF NOT EXISTS media_checksums (
+			media_id TEXT NOT NULL REFERENCES media_items(id) ON DELETE CASCADE,
+			algorithm TEXT NOT NULL,
+			digest TEXT NOT NULL,
+			PRIMARY KEY (media_id, algorithm)
+		)`
+	if _, err := s.db.ExecContext(ctx, table); err != nil {
+		return fmt.Errorf("failed to create media_checksums table: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS idx_media_checksums ON media_checksums(algorithm, digest)"); err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(ctx, prefixLegacyChecksums); err != nil {
+		return fmt.Errorf("failed to migrate checksums: %w", err)
+	}
+	backfill := `
+		INSERT OR IGNORE INTO media_checksums (media_id, algorithm, digest)
+		SELECT id, substr(checksum, 1, instr(checksum, ':') - 1), lower(substr(checksum, instr(checksum, ':') + 1))
+		FROM media_items WHERE instr(checksum, ':') > 1`
+	if _, err := s.db.ExecContext(ctx, backfill); err != nil {
+		return fmt.Errorf("failed to index checksums: %w", err)
+	}
+	return nil
+}
+
+func insertChecksum(ctx context.Context, db execer, mediaID, algorithm, digest string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO media_checksums (media_id, algorithm, digest) VALUES (?, ?, ?)`,
+		mediaID, algorithm, digest)
+	if err != nil {
+		return fmt.Errorf("failed to store checksum: %w", err)
+	}
+	return nil
+}
+
+// itemChecksums returns the checksums of an item: its own and those listed
+// in its metadata. Values that are not algorithm:hex are ignored.
+func itemChecksums(item *MediaItem) map[string]string {
+	values := []string{item.Checksum}
+	switch listed := item.Metadata[metadataChecksums].(type) {
+	case []string:
+		values = append(values, listed...)
+	case []interface{}:
+		for _, v := range listed {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	sums := make(map[string]string, len(values))
+	for _, v := range values {
+		algorithm, digest, err := checksum.Parse(checksum.Normalize(v))
+		if err != nil {
+			continue
+		}
+		if _, ok := sums[algorithm]; !ok {
+			sums[algorithm] = digest
+		}
+	}
+	return sums
+}
+
+// AddChecksum records another checksum of a stored item's content, such
+// as one a source publishes later; it replaces any of the same algorithm
+func (s *SQLiteStorage) AddChecksum(ctx context.Context, mediaID, sum string) error {
+	if !s.IsReady() {
+		return fmt.Errorf("storage not ready")
+	}
+	if mediaID == "" {
+		return fmt.Errorf("media item ID cannot be empty")
+	}
+	algorithm, digest, err := checksum.Parse(checksum.Normalize(sum))
+	if err != nil {
+		return err
+	}
+	return insertChecksum(ctx, s.db, mediaID, algorithm, digest)
+}
+
+// Checksums returns every recorded checksum of an item, in algorithm order
+func (s *SQLiteStorage) Checksums(ctx context.Context, mediaID string) ([]string, error) {
+	return s.queryStrings(ctx,
+		`SELECT algorithm || ':' || digest FROM media_checksums WHERE media_id = ? ORDER BY algorithm`,
+		mediaID)
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	helloSHA256 = "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	helloMD5    = "md5:5d41402abc4b2a76b9719d911017c592"
+	helloXXH64  = "xxh64:26c7827d889f6da3"
+)
+
+func TestSQLiteStorage_ChecksumsByAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	storage := setupTestDB(t).(*SQLiteStorage)
+	defer func() {
+		if err := storage.Close(); err != nil {
+			t.Logf("Failed to close storage: %v", err)
+		}
+	}()
+
+	now := time.Now().UTC()
+	require.NoError(t, storage.StoreMedia(ctx, &MediaItem{
+		ID: "s3-1", ServiceID: "s3", ExternalID: "1", Type: "photo", URL: "https://example.com/1",
+		Checksum:  helloSHA256,
+		Metadata:  map[string]interface{}{"checksums": []interface{}{helloSHA256, strings.ToUpper(helloMD5[4:]), helloMD5}},
+		CreatedAt: now, SyncedAt: now,
+	}))
+
+	sums, err := storage.Checksums(ctx, "s3-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{helloMD5, helloSHA256}, sums)
+
+	for _, sum := range []string{helloSHA256, strings.ToUpper(helloSHA256[7:]), helloMD5} {
+		dup, err := storage.IsDuplicate(ctx, "s3", sum)
+		require.NoError(t, err)
+		assert.True(t, dup, sum)
+	}
+
+	// The same hex under another algorithm is a different checksum
+	dup, err := storage.IsDuplicate(ctx, "s3", "blake3:"+helloSHA256[7:])
+	require.NoError(t, err)
+	assert.False(t, dup)
+	dup, err = storage.IsDuplicate(ctx, "s3", helloXXH64)
+	require.NoError(t, err)
+	assert.False(t, dup, "no xxh64 checksum is recorded yet")
+
+	require.NoError(t, storage.AddChecksum(ctx, "s3-1", helloXXH64))
+	dup, err = storage.IsDuplicate(ctx, "s3", helloXXH64)
+	require.NoError(t, err)
+	assert.True(t, dup)
+	dup, err = storage.IsDuplicate(ctx, "other", helloXXH64)
+	require.NoError(t, err)
+	assert.False(t, dup)
+
+	assert.Error(t, storage.AddChecksum(ctx, "s3-1", "not-a-checksum"))
+}
+
+func TestSQLiteStorage_ChecksumMigration(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	storage := NewSQLiteStorage(path)
+	require.NoError(t, storage.Initialize(ctx))
+
+	// Rows written before algorithms were recorded
+	now := time.Now().UTC()
+	for id, sum := range map[string]string{"old-1": strings.ToUpper(helloSHA256[7:]), "old-2": "opaque"} {
+		_, err := storage.db.ExecContext(ctx, `
+			INSERT INTO media_items (id, service_id, external_id, type, url, local_path, metadata, checksum, size_bytes, created_at, synced_at)
+			VALUES (?, 'tumblr', ?, 'photo', 'https://example.com', '', '{}', ?, 0, ?, ?)`,
+			id, id, sum, now, now)
+		require.NoError(t, err)
+	}
+	_, err := storage.db.ExecContext(ctx, `DELETE FROM media_checksums`)
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	storage = NewSQLiteStorage(path)
+	require.NoError(t, storage.Initialize(ctx))
+	defer storage.Close()
+
+	item, err := storage.GetMedia(ctx, "old-1")
+	require.NoError(t, err)
+	assert.Equal(t, helloSHA256, item.Checksum)
+	sums, err := storage.Checksums(ctx, "old-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{helloSHA256}, sums)
+	dup, err := storage.IsDuplicate(ctx, "tumblr", helloSHA256)
+	require.NoError(t, err)
+	assert.True(t, dup)
+
+	item, err = storage.GetMedia(ctx, "old-2")
+	require.NoError(t, err)
+	assert.Equal(t, "opaque", item.Checksum, "values of unknown form are left alone")
+	dup, err = storage.IsDuplicate(ctx, "tumblr", "opaque")
+	require.NoError(t, err)
+	assert.True(t, dup)
+}
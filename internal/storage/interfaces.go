@@ -56,3 +56,84 @@ type MediaQuery struct {
 	EndTime   *time.Time
 	Limit     int
 }
+
+// PerceptualIndex finds visually similar media across services by the
+// perceptual hashes recorded for each item
+type PerceptualIndex interface {
+	StorePerceptualHash(ctx context.Context, mediaID string, hash PerceptualHash) error
+	FindNearDuplicates(ctx context.Context, query NearDuplicateQuery) ([]NearDuplicate, error)
+}
+
+// PerceptualHash is a 64-bit perceptual hash and the algorithm producing it
+type PerceptualHash struct {
+	Algorithm string `json:"algorithm"`
+	Value     uint64 `json:"value"`
+}
+
+// NearDuplicateQuery selects items whose hash is within MaxDistance bits
+type NearDuplicateQuery struct {
+	Hash        PerceptualHash
+	MaxDistance int
+	// ExcludeID omits the item the hash was computed from
+	ExcludeID string
+	Limit     int
+}
+
+// NearDuplicate is a media item matching a NearDuplicateQuery
+type NearDuplicate struct {
+	Item     *MediaItem `json:"item"`
+	Distance int        `json:"distance"`
+}
+
+// BlobIndex reference-counts content-addressed blobs by the media items
+// using them, so a blob is only deleted once no item refers to it
+type BlobIndex interface {
+	AddBlobRef(ctx context.Context, digest, mediaID string) error
+	// RemoveBlobRef drops one reference and returns the references left
+	RemoveBlobRef(ctx context.Context, digest, mediaID string) (int, error)
+	BlobRefs(ctx context.Context, digest string) ([]string, error)
+	MediaBlobs(ctx context.Context, mediaID string) ([]string, error)
+}
+
+// VerificationStatus is the outcome of an integrity check of the local file
+// and stored blobs of an item
+type VerificationStatus string
+
+// Integrity check outcomes
+const (
+	VerificationOK VerificationStatus = "ok"
+	// VerificationMissing means the local file or a blob does not exist
+	VerificationMissing VerificationStatus = "missing"
+	// VerificationCorrupt means the file does not match the checksum or
+	// cannot be read
+	VerificationCorrupt VerificationStatus = "corrupt"
+	// VerificationRepaired means a missing or corrupt file was fetched again
+	VerificationRepaired VerificationStatus = "repaired"
+	// VerificationUnverifiable means the checksum is of no registered
+	// algorithm; the file exists but its content is not checked
+	VerificationUnverifiable VerificationStatus = "unverifiable"
+)
+
+// Verification is the latest integrity check result of an item
+type Verification struct {
+	MediaID    string             `json:"media_id" db:"media_id"`
+	Status     VerificationStatus `json:"status" db:"status"`
+	Detail     string             `json:"detail,omitempty" db:"detail"`
+	VerifiedAt time.Time          `json:"verified_at" db:"verified_at"`
+}
+
+// VerificationIndex records integrity check results and finds the items due
+// for another check
+type VerificationIndex interface {
+	RecordVerification(ctx context.Context, v Verification) error
+	GetVerification(ctx context.Context, mediaID string) (*Verification, error)
+	ListVerifications(ctx context.Context, status VerificationStatus) ([]Verification, error)
+	DueForVerification(ctx context.Context, before time.Time, limit int) ([]*MediaItem, error)
+}
+
+// ChecksumIndex records several checksums per item, as algorithm:hex, so
+// items can be matched by checksums of any recorded algorithm
+type ChecksumIndex interface {
+	AddChecksum(ctx context.Context, mediaID, checksum string) error
+	Checksums(ctx context.Context, mediaID string) ([]string, error)
+}
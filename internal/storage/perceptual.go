@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sho7650/media-sync/internal/media/phash"
+)
+
+// Ensure SQLiteStorage implements PerceptualIndex
+var _ PerceptualIndex = (*SQLiteStorage)(nil)
+
+// perceptualBands splits each hash into 16-bit bands. Two hashes within
+// fewer than perceptualBands bits of each other share at least one band, so
+// such queries only inspect rows matching a band through an index.
+const perceptualBands = 4
+
+func hashBands(h uint64) [perceptualBands]int64 {
+	var bands [perceptualBands]int64
+	for i := range bands {
+		bands[i] = int64(h >> (48 - 16*i) & 0xFFFF)
+	}
+	return bands
+}
+
+// createPerceptualTable creates the perceptual hash table and its band indexes
+func (s *SQLiteStorage) createPerceptualTable(ctx context.Context) error {
+	table := `
+		CREATE TABLE IF NOT EXISTS perceptual_hashes (
+			media_id TEXT NOT NULL REFERENCES media_items(id) ON DELETE CASCADE,
+			algorithm TEXT NOT NULL,
+			hash INTEGER NOT NULL,
+			band0 INTEGER NOT NULL,
+			band1 INTEGER NOT NULL,
+			band2 INTEGER NOT NULL,
+			band3 INTEGER NOT NULL,
+			PRIMARY KEY (media_id, algorithm)
+		)`
+	if _, err := s.db.ExecContext(ctx, table); err != nil {
+		return fmt.Errorf("failed to create perceptual_hashes table: %w", err)
+	}
+
+	for i := 0; i < perceptualBands; i++ {
+		index := fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_phash_band%d ON perceptual_hashes(algorithm, band%d)", i, i)
+		if _, err := s.db.ExecContext(ctx, index); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+	return nil
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertPerceptualHash(ctx context.Context, db execer, mediaID string, hash PerceptualHash) error {
+	bands := hashBands(hash.Value)
+	_, err := db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO perceptual_hashes (
+			media_id, algorithm, hash, band0, band1, band2, band3
+		) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		mediaID, hash.Algorithm, int64(hash.Value), bands[0], bands[1], bands[2], bands[3],
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store perceptual hash: %w", err)
+	}
+	return nil
+}
+
+// metadataHashes returns the perceptual hashes recorded in item metadata
+// under their algorithm names; values that do not parse are ignored
+func metadataHashes(metadata map[string]interface{}) []PerceptualHash {
+	var hashes []PerceptualHash
+	for _, algorithm := range phash.Algorithms {
+		s, ok := metadata[algorithm].(string)
+		if !ok {
+			continue
+		}
+		if value, err := phash.Parse(s); err == nil {
+			hashes = append(hashes, PerceptualHash{Algorithm: algorithm, Value: value})
+		}
+	}
+	return hashes
+}
+
+// StorePerceptualHash records or replaces the hash of a stored media item
+func (s *SQLiteStorage) StorePerceptualHash(ctx context.Context, mediaID string, hash PerceptualHash) error {
+	if !s.IsReady() {
+		return fmt.Errorf("storage not ready")
+	}
+	if mediaID == "" {
+		return fmt.Errorf("media item ID cannot be empty")
+	}
+	if err := validAlgorithm(hash.Algorithm); err != nil {
+		return err
+	}
+	return insertPerceptualHash(ctx, s.db, mediaID, hash)
+}
+
+// FindNearDuplicates returns items of any service whose hash is within
+// query.MaxDistance bits, closest first
+func (s *SQLiteStorage) FindNearDuplicates(ctx context.Context, query NearDuplicateQuery) ([]NearDuplicate, error) {
+	if !s.IsReady() {
+		return nil, fmt.Errorf("storage not ready")
+	}
+	if err := validAlgorithm(query.Hash.Algorithm); err != nil {
+		return nil, err
+	}
+	if query.MaxDistance < 0 || query.MaxDistance > 64 {
+		return nil, fmt.Errorf("max distance must be between 0 and 64, got %d", query.MaxDistance)
+	}
+
+	sqlQuery := `
+		SELECT p.hash, m.id, m.service_id, m.external_id, m.type, m.url, m.local_path,
+		       m.metadata, m.checksum, m.size_bytes, m.created_at, m.synced_at
+		FROM perceptual_hashes p JOIN media_items m ON m.id = p.media_id
+		WHERE p.algorithm = ?`
+	args := []interface{}{query.Hash.Algorithm}
+
+	if query.MaxDistance < perceptualBands {
+		var conditions []string
+		for i, band := range hashBands(query.Hash.Value) {
+			conditions = append(conditions, fmt.Sprintf("p.band%d = ?", i))
+			args = append(args, band)
+		}
+		sqlQuery += " AND (" + strings.Join(conditions, " OR ") + ")"
+	}
+	if query.ExcludeID != "" {
+		sqlQuery += " AND p.media_id != ?"
+		args = append(args, query.ExcludeID)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query perceptual hashes: %w", err)
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			// Log close error but preserve original error
+			_ = closeErr
+		}
+	}()
+
+	var results []NearDuplicate
+	for rows.Next() {
+		var (
+			hash         int64
+			metadataJSON string
+		)
+		item := &MediaItem{}
+		err := rows.Scan(
+			&hash, &item.ID, &item.ServiceID, &item.ExternalID, &item.Type,
+			&item.URL, &item.LocalPath, &metadataJSON, &item.Checksum,
+			&item.SizeBytes, &item.CreatedAt, &item.SyncedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan perceptual hash: %w", err)
+		}
+
+		distance := phash.Distance(uint64(hash), query.Hash.Value)
+		if distance > query.MaxDistance {
+			continue
+		}
+		if err := json.Unmarshal([]byte(metadataJSON), &item.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+		results = append(results, NearDuplicate{Item: item, Distance: distance})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		return results[i].Item.ID < results[j].Item.ID
+	})
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
+
+func validAlgorithm(algorithm string) error {
+	for _, known := range phash.Algorithms {
+		if algorithm == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown perceptual hash algorithm %q", algorithm)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media/phash"
+)
+
+func TestSQLiteStorage_NearDuplicates(t *testing.T) {
+	ctx := context.Background()
+	storage := setupTestDB(t).(*SQLiteStorage)
+	defer func() {
+		if err := storage.Close(); err != nil {
+			t.Logf("Failed to close storage: %v", err)
+		}
+	}()
+
+	const base = uint64(0xF0F0F0F0AAAA5555)
+	items := []struct {
+		id, service string
+		dhash       uint64
+	}{
+		{"tumblr-1", "tumblr", base},
+		{"flickr-9", "flickr", base ^ 0b101},                 // 2 bits apart
+		{"mastodon-3", "mastodon", base ^ 0xFF},              // 8 bits apart
+		{"webdav-4", "webdav", ^base},                        // unrelated
+		{"immich-5", "immich", base ^ 0x0001_0001_0001_0001}, // 4 bits, one per band
+	}
+	now := time.Now().UTC()
+	for _, it := range items {
+		require.NoError(t, storage.StoreMedia(ctx, &MediaItem{
+			ID: it.id, ServiceID: it.service, ExternalID: it.id, Type: "photo",
+			URL: "https://example.com/" + it.id, Checksum: "sha256:" + it.id,
+			Metadata:  map[string]interface{}{"dhash": phash.Format(it.dhash), "phash": "not-a-hash"},
+			CreatedAt: now, SyncedAt: now,
+		}))
+	}
+
+	query := NearDuplicateQuery{Hash: PerceptualHash{Algorithm: "dhash", Value: base}, MaxDistance: 3}
+	results, err := storage.FindNearDuplicates(ctx, query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tumblr-1", "flickr-9"}, resultIDs(results))
+	assert.Equal(t, []int{0, 2}, resultDistances(results))
+	assert.Equal(t, "flickr", results[1].Item.ServiceID)
+
+	query.ExcludeID = "tumblr-1"
+	query.MaxDistance = 10
+	results, err = storage.FindNearDuplicates(ctx, query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flickr-9", "immich-5", "mastodon-3"}, resultIDs(results))
+
+	query.Limit = 1
+	results, err = storage.FindNearDuplicates(ctx, query)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flickr-9"}, resultIDs(results))
+
+	// Invalid metadata hashes are not indexed
+	results, err = storage.FindNearDuplicates(ctx, NearDuplicateQuery{Hash: PerceptualHash{Algorithm: "phash"}, MaxDistance: 64})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	_, err = storage.FindNearDuplicates(ctx, NearDuplicateQuery{Hash: PerceptualHash{Algorithm: "ahash"}})
+	assert.Error(t, err)
+	_, err = storage.FindNearDuplicates(ctx, NearDuplicateQuery{Hash: PerceptualHash{Algorithm: "dhash"}, MaxDistance: 65})
+	assert.Error(t, err)
+}
+
+func TestSQLiteStorage_StorePerceptualHash(t *testing.T) {
+	ctx := context.Background()
+	storage := setupTestDB(t).(*SQLiteStorage)
+	defer func() {
+		if err := storage.Close(); err != nil {
+			t.Logf("Failed to close storage: %v", err)
+		}
+	}()
+
+	now := time.Now().UTC()
+	require.NoError(t, storage.StoreMedia(ctx, &MediaItem{
+		ID: "item-1", ServiceID: "tumblr", ExternalID: "1", Type: "photo",
+		URL: "https://example.com/1", Checksum: "sha256:1", CreatedAt: now, SyncedAt: now,
+	}))
+
+	hash := PerceptualHash{Algorithm: "phash", Value: 1<<63 | 42}
+	require.NoError(t, storage.StorePerceptualHash(ctx, "item-1", hash))
+	// Replacing keeps a single row per item and algorithm
+	require.NoError(t, storage.StorePerceptualHash(ctx, "item-1", hash))
+
+	results, err := storage.FindNearDuplicates(ctx, NearDuplicateQuery{Hash: hash})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"item-1"}, resultIDs(results))
+
+	assert.Error(t, storage.StorePerceptualHash(ctx, "", hash))
+	assert.Error(t, storage.StorePerceptualHash(ctx, "item-1", PerceptualHash{Algorithm: "md5"}))
+	assert.Error(t, storage.StorePerceptualHash(ctx, "missing", hash), "foreign key enforced")
+}
+
+func resultIDs(results []NearDuplicate) []string {
+	ids := make([]string, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.Item.ID)
+	}
+	return ids
+}
+
+func resultDistances(results []NearDuplicate) []int {
+	distances := make([]int, 0, len(results))
+	for _, r := range results {
+		distances = append(distances, r.Distance)
+	}
+	return distances
+}
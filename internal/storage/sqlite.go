@@ -9,6 +9,8 @@ import (
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/sho7650/media-sync/internal/checksum"
 )
 
 // SQLiteStorage implements StorageManager using SQLite
@@ -114,7 +116,7 @@ func (s *SQLiteStorage) StoreMedia(ctx context.Context, item *MediaItem) error {
 
 	_, err = tx.ExecContext(ctx, query,
 		item.ID, item.ServiceID, item.ExternalID, item.Type, item.URL,
-		item.LocalPath, string(metadataJSON), item.Checksum, item.SizeBytes,
+		item.LocalPath, string(metadataJSON), checksum.Normalize(item.Checksum), item.SizeBytes,
 		item.CreatedAt, item.SyncedAt,
 	)
 
@@ -122,6 +124,18 @@ func (s *SQLiteStorage) StoreMedia(ctx context.Context, item *MediaItem) error {
 		return fmt.Errorf("failed to store media item: %w", err)
 	}
 
+	for algorithm, digest := range itemChecksums(item) {
+		if err := insertChecksum(ctx, tx, item.ID, algorithm, digest); err != nil {
+			return err
+		}
+	}
+
+	for _, hash := range metadataHashes(item.Metadata) {
+		if err := insertPerceptualHash(ctx, tx, item.ID, hash); err != nil {
+			return err
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -173,16 +187,27 @@ func (s *SQLiteStorage) GetMedia(ctx context.Context, id string) (*MediaItem, er
 	return item, nil
 }
 
-// IsDuplicate checks if a media item with the given checksum exists for the service
-func (s *SQLiteStorage) IsDuplicate(ctx context.Context, serviceID, checksum string) (bool, error) {
+// IsDuplicate checks if a media item with the given checksum exists for
+// the service. Checksums are compared within their algorithm, against every
+// checksum recorded for an item; values that are not algorithm:hex are
+// compared with the items' own checksums as they are.
+func (s *SQLiteStorage) IsDuplicate(ctx context.Context, serviceID, sum string) (bool, error) {
 	if !s.IsReady() {
 		return false, fmt.Errorf("storage not ready")
 	}
 
 	query := `SELECT COUNT(*) FROM media_items WHERE service_id = ? AND checksum = ?`
+	args := []interface{}{serviceID, sum}
+	if algorithm, digest, err := checksum.Parse(checksum.Normalize(sum)); err == nil {
+		query = `
+			SELECT COUNT(*) FROM media_checksums c
+			JOIN media_items m ON m.id = c.media_id
+			WHERE m.service_id = ? AND c.algorithm = ? AND c.digest = ?`
+		args = []interface{}{serviceID, algorithm, digest}
+	}
 
 	var count int
-	err := s.db.QueryRowContext(ctx, query, serviceID, checksum).Scan(&count)
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("failed to check duplicate: %w", err)
 	}
@@ -373,5 +398,14 @@ func (s *SQLiteStorage) createTables(ctx context.Context) error {
 		return fmt.Errorf("failed to create sync_states table: %w", err)
 	}
 
-	return nil
+	if err := s.createPerceptualTable(ctx); err != nil {
+		return err
+	}
+	if err := s.createChecksumsTable(ctx); err != nil {
+		return err
+	}
+	if err := s.createVerificationsTable(ctx); err != nil {
+		return err
+	}
+	return s.createBlobRefsTable(ctx)
 }
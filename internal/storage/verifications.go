@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Ensure SQLiteStorage implements VerificationIndex
+var _ VerificationIndex = (*SQLiteStorage)(nil)
+
+// createVerificationsTable creates the table of integrity check results,
+// one row per item holding its latest result
+func (s *SQLiteStorage) createVerificationsTable(ctx context.Context) error {
+	table := `
+		CREATE TABLE IF NOT EXISTS media_verifications (
+			media_id TEXT PRIMARY KEY REFERENCES media_items(id) ON DELETE CASCADE,
+			status TEXT NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			verified_at DATETIME NOT NULL
+		)`
+	if _, err := s.db.ExecContext(ctx, table); err != nil {
+		return fmt.Errorf("failed to create media_verifications table: %w", err)
+	}
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_media_verifications_status ON media_verifications(status)",
+		"CREATE INDEX IF NOT EXISTS idx_media_verifications_time ON media_verifications(verified_at)",
+	}
+	for _, index := range indexes {
+		if _, err := s.db.ExecContext(ctx, index); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+	return nil
+}
+
+// RecordVerification stores the latest integrity check result of an item
+func (s *SQLiteStorage) RecordVerification(ctx context.Context, v Verification) error {
+	if !s.IsReady() {
+		return fmt.Errorf("storage not ready")
+	}
+	if v.MediaID == "" {
+		return fmt.Errorf("media item ID cannot be empty")
+	}
+	if v.Status == "" {
+		return fmt.Errorf("verification status cannot be empty")
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO media_verifications (media_id, status, detail, verified_at)
+		VALUES (?, ?, ?, ?)`,
+		v.MediaID, string(v.Status), v.Detail, v.VerifiedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record verification: %w", err)
+	}
+	return nil
+}
+
+// GetVerification returns the latest integrity check result of an item,
+// or nil if it was never checked
+func (s *SQLiteStorage) GetVerification(ctx context.Context, mediaID string) (*Verification, error) {
+	if !s.IsReady() {
+		return nil, fmt.Errorf("storage not ready")
+	}
+	v := &Verification{MediaID: mediaID}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT status, detail, verified_at FROM media_verifications WHERE media_id = ?`, mediaID,
+	).Scan(&v.Status, &v.Detail, &v.VerifiedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get verification: %w", err)
+	}
+	return v, nil
+}
+
+// ListVerifications returns the items whose latest result has the given
+// status, such as the missing or corrupt ones, oldest check first
+func (s *SQLiteStorage) ListVerifications(ctx context.Context, status VerificationStatus) ([]Verification, error) {
+	if !s.IsReady() {
+		return nil, fmt.Errorf("storage not ready")
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT media_id, status, detail, verified_at FROM media_verifications
+		WHERE status = ? ORDER BY verified_at, media_id`, string(status))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query verifications: %w", err)
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			// Log close error but preserve original error
+			_ = closeErr
+		}
+	}()
+
+	var results []Verification
+	for rows.Next() {
+		var v Verification
+		if err := rows.Scan(&v.MediaID, &v.Status, &v.Detail, &v.VerifiedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan verification: %w", err)
+		}
+		results = append(results, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return results, nil
+}
+
+// DueForVerification returns up to limit items with a local file or a blob
+// reference that were never checked or last checked before the given time,
+// least recently checked first
+func (s *SQLiteStorage) DueForVerification(ctx context.Context, before time.Time, limit int) ([]*MediaItem, error) {
+	if !s.IsReady() {
+		return nil, fmt.Errorf("storage not ready")
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive, got %d", limit)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.id, m.service_id, m.external_id, m.type, m.url, m.local_path,
+		       m.metadata, m.checksum, m.size_bytes, m.created_at, m.synced_at
+		FROM media_items m LEFT JOIN media_verifications v ON v.media_id = m.id
+		WHERE (m.local_path != '' OR EXISTS (SELECT 1 FROM blob_refs b WHERE b.media_id = m.id))
+		  AND (v.verified_at IS NULL OR v.verified_at < ?)
+		ORDER BY v.verified_at IS NOT NULL, v.verified_at, m.id
+		LIMIT ?`, before.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query media items: %w", err)
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil {
+			// Log close error but preserve original error
+			_ = closeErr
+		}
+	}()
+
+	var results []*MediaItem
+	for rows.Next() {
+		item := &MediaItem{}
+		var metadataJSON string
+		err := rows.Scan(
+			&item.ID, &item.ServiceID, &item.ExternalID, &item.Type,
+			&item.URL, &item.LocalPath, &metadataJSON, &item.Checksum,
+			&item.SizeBytes, &item.CreatedAt, &item.SyncedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan media item: %w", err)
+		}
+		if err := json.Unmarshal([]byte(metadataJSON), &item.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata: %w", err)
+		}
+		results = append(results, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+	return results, nil
+}
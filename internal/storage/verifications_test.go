@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStorage_Verifications(t *testing.T) {
+	ctx := context.Background()
+	storage := setupTestDB(t).(*SQLiteStorage)
+	defer func() {
+		if err := storage.Close(); err != nil {
+			t.Logf("Failed to close storage: %v", err)
+		}
+	}()
+
+	now := time.Now().UTC()
+	for _, id := range []string{"a", "b", "c", "remote", "blob"} {
+		path := "/archive/" + id + ".jpg"
+		if id == "remote" || id == "blob" {
+			path = ""
+		}
+		require.NoError(t, storage.StoreMedia(ctx, &MediaItem{
+			ID: id, ServiceID: "tumblr", ExternalID: id, Type: "photo", URL: "https://example.com/" + id,
+			LocalPath: path, Checksum: helloSHA256, Metadata: map[string]interface{}{}, CreatedAt: now, SyncedAt: now,
+		}))
+	}
+
+	require.NoError(t, storage.AddBlobRef(ctx, helloSHA256, "blob"))
+
+	v, err := storage.GetVerification(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, v, "never checked")
+
+	require.NoError(t, storage.RecordVerification(ctx, Verification{
+		MediaID: "a", Status: VerificationOK, VerifiedAt: now.Add(-48 * time.Hour),
+	}))
+	require.NoError(t, storage.RecordVerification(ctx, Verification{
+		MediaID: "b", Status: VerificationCorrupt, Detail: "checksum mismatch", VerifiedAt: now,
+	}))
+
+	due, err := storage.DueForVerification(ctx, now.Add(-24*time.Hour), 10)
+	require.NoError(t, err)
+	var ids []string
+	for _, item := range due {
+		ids = append(ids, item.ID)
+	}
+	assert.ElementsMatch(t, []string{"c", "blob"}, ids[:2], "unchecked items come first")
+	assert.Equal(t, []string{"a"}, ids[2:], "items without a file or blob are skipped")
+
+	due, err = storage.DueForVerification(ctx, now.Add(-24*time.Hour), 1)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Contains(t, []string{"c", "blob"}, due[0].ID)
+
+	corrupt, err := storage.ListVerifications(ctx, VerificationCorrupt)
+	require.NoError(t, err)
+	require.Len(t, corrupt, 1)
+	assert.Equal(t, "b", corrupt[0].MediaID)
+	assert.Equal(t, "checksum mismatch", corrupt[0].Detail)
+
+	// A new result replaces the previous one
+	require.NoError(t, storage.RecordVerification(ctx, Verification{
+		MediaID: "b", Status: VerificationRepaired, VerifiedAt: now,
+	}))
+	v, err = storage.GetVerification(ctx, "b")
+	require.NoError(t, err)
+	require.NotNil(t, v)
+	assert.Equal(t, VerificationRepaired, v.Status)
+	assert.WithinDuration(t, now, v.VerifiedAt, time.Second)
+
+	assert.Error(t, storage.RecordVerification(ctx, Verification{MediaID: "a"}))
+	_, err = storage.DueForVerification(ctx, now, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,345 @@
+package interfaces
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Canonical metadata keys. Services name fields differently; transforms
+// map them onto these keys so outputs and storage can rely on them.
+const (
+	MetaTitle       = "title"
+	MetaDescription = "description"
+	MetaAuthor      = "author"
+	MetaTags        = "tags"
+	MetaCapturedAt  = "captured_at"
+	MetaPublishedAt = "published_at"
+	MetaSourceURL   = "source_url"
+	MetaLatitude    = "gps_latitude"
+	MetaLongitude   = "gps_longitude"
+	MetaAltitude    = "gps_altitude"
+	MetaWidth       = "width"
+	MetaHeight      = "height"
+	MetaDuration    = "duration"
+)
+
+// FieldType is the representation of a canonical field in Metadata
+type FieldType string
+
+const (
+	// FieldString is a string
+	FieldString FieldType = "string"
+	// FieldStrings is a []string
+	FieldStrings FieldType = "[]string"
+	// FieldTime is an RFC 3339 string
+	FieldTime FieldType = "time"
+	// FieldNumber is a float64
+	FieldNumber FieldType = "number"
+	// FieldInteger is a non-negative int
+	FieldInteger FieldType = "integer"
+	// FieldSeconds is a non-negative float64 number of seconds
+	FieldSeconds FieldType = "seconds"
+)
+
+// CanonicalFields maps each canonical key to its type
+var CanonicalFields = map[string]FieldType{
+	MetaTitle:       FieldString,
+	MetaDescription: FieldString,
+	MetaAuthor:      FieldString,
+	MetaTags:        FieldStrings,
+	MetaCapturedAt:  FieldTime,
+	MetaPublishedAt: FieldTime,
+	MetaSourceURL:   FieldString,
+	MetaLatitude:    FieldNumber,
+	MetaLongitude:   FieldNumber,
+	MetaAltitude:    FieldNumber,
+	MetaWidth:       FieldInteger,
+	MetaHeight:      FieldInteger,
+	MetaDuration:    FieldSeconds,
+}
+
+// CanonicalMetadata is the typed form of the canonical keys; zero values
+// mean the key is absent
+type CanonicalMetadata struct {
+	Title       string
+	Description string
+	Author      string
+	Tags        []string
+	CapturedAt  time.Time
+	PublishedAt time.Time
+	SourceURL   string
+	Latitude    *float64
+	Longitude   *float64
+	Altitude    *float64
+	Width       int
+	Height      int
+	Duration    time.Duration
+}
+
+// ParseCanonicalMetadata reads the canonical keys of metadata, failing on
+// the first value that does not have its canonical type. Other keys are
+// ignored.
+func ParseCanonicalMetadata(metadata map[string]interface{}) (*CanonicalMetadata, error) {
+	c := &CanonicalMetadata{}
+	for _, key := range canonicalKeys() {
+		v, ok := metadata[key]
+		if !ok || v == nil {
+			continue
+		}
+		if err := c.set(key, v); err != nil {
+			return nil, fmt.Errorf("metadata %s: %w", key, err)
+		}
+	}
+	if c.Latitude != nil && math.Abs(*c.Latitude) > 90 {
+		return nil, fmt.Errorf("metadata %s: %v is out of range", MetaLatitude, *c.Latitude)
+	}
+	if c.Longitude != nil && math.Abs(*c.Longitude) > 180 {
+		return nil, fmt.Errorf("metadata %s: %v is out of range", MetaLongitude, *c.Longitude)
+	}
+	return c, nil
+}
+
+// ValidateMetadata checks that the canonical keys present in metadata have
+// their canonical types
+func ValidateMetadata(metadata map[string]interface{}) error {
+	_, err := ParseCanonicalMetadata(metadata)
+	return err
+}
+
+func (c *CanonicalMetadata) set(key string, v interface{}) error {
+	var err error
+	switch CanonicalFields[key] {
+	case FieldString:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got %T", v)
+		}
+		switch key {
+		case MetaTitle:
+			c.Title = s
+		case MetaDescription:
+			c.Description = s
+		case MetaAuthor:
+			c.Author = s
+		case MetaSourceURL:
+			c.SourceURL = s
+		}
+	case FieldStrings:
+		c.Tags, err = stringList(v)
+	case FieldTime:
+		var ts time.Time
+		if ts, err = timeValue(v); err == nil {
+			if key == MetaCapturedAt {
+				c.CapturedAt = ts
+			} else {
+				c.PublishedAt = ts
+			}
+		}
+	case FieldNumber:
+		var f float64
+		if f, err = floatValue(v); err == nil {
+			switch key {
+			case MetaLatitude:
+				c.Latitude = &f
+			case MetaLongitude:
+				c.Longitude = &f
+			case MetaAltitude:
+				c.Altitude = &f
+			}
+		}
+	case FieldInteger:
+		var f float64
+		if f, err = floatValue(v); err == nil {
+			if f < 0 || f != math.Trunc(f) {
+				return fmt.Errorf("expected non-negative integer, got %v", v)
+			}
+			if key == MetaWidth {
+				c.Width = int(f)
+			} else {
+				c.Height = int(f)
+			}
+		}
+	case FieldSeconds:
+		var f float64
+		if f, err = floatValue(v); err == nil {
+			if f < 0 {
+				return fmt.Errorf("expected non-negative seconds, got %v", v)
+			}
+			c.Duration = time.Duration(f * float64(time.Second))
+		}
+	}
+	return err
+}
+
+// Map returns the canonical keys that are set, in their Metadata representation
+func (c *CanonicalMetadata) Map() map[string]interface{} {
+	m := make(map[string]interface{})
+	setString := func(key, s string) {
+		if s != "" {
+			m[key] = s
+		}
+	}
+	setString(MetaTitle, c.Title)
+	setString(MetaDescription, c.Description)
+	setString(MetaAuthor, c.Author)
+	setString(MetaSourceURL, c.SourceURL)
+	if len(c.Tags) > 0 {
+		m[MetaTags] = append([]string(nil), c.Tags...)
+	}
+	if !c.CapturedAt.IsZero() {
+		m[MetaCapturedAt] = c.CapturedAt.UTC().Format(time.RFC3339)
+	}
+	if !c.PublishedAt.IsZero() {
+		m[MetaPublishedAt] = c.PublishedAt.UTC().Format(time.RFC3339)
+	}
+	for key, f := range map[string]*float64{MetaLatitude: c.Latitude, MetaLongitude: c.Longitude, MetaAltitude: c.Altitude} {
+		if f != nil {
+			m[key] = *f
+		}
+	}
+	if c.Width > 0 {
+		m[MetaWidth] = c.Width
+	}
+	if c.Height > 0 {
+		m[MetaHeight] = c.Height
+	}
+	if c.Duration > 0 {
+		m[MetaDuration] = c.Duration.Seconds()
+	}
+	return m
+}
+
+// CanonicalSchema describes the canonical metadata of a media type; Fields
+// maps each key to its FieldType and Metadata holds the equivalent JSON Schema
+func CanonicalSchema(mediaType MediaType) Schema {
+	fields := make(map[string]interface{}, len(CanonicalFields))
+	properties := make(map[string]interface{}, len(CanonicalFields))
+	for key, ft := range CanonicalFields {
+		fields[key] = string(ft)
+		properties[key] = fieldJSONSchema(key, ft)
+	}
+	return Schema{
+		Type:     string(mediaType),
+		Fields:   fields,
+		Metadata: map[string]interface{}{"type": "object", "properties": properties},
+	}
+}
+
+func fieldJSONSchema(key string, ft FieldType) map[string]interface{} {
+	switch ft {
+	case FieldStrings:
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}
+	case FieldTime:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case FieldNumber:
+		switch key {
+		case MetaLatitude:
+			return map[string]interface{}{"type": "number", "minimum": -90, "maximum": 90}
+		case MetaLongitude:
+			return map[string]interface{}{"type": "number", "minimum": -180, "maximum": 180}
+		}
+		return map[string]interface{}{"type": "number"}
+	case FieldInteger:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case FieldSeconds:
+		return map[string]interface{}{"type": "number", "minimum": 0}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// CheckCanonicalSchema reports schema fields that use a canonical key with
+// a different type. Fields outside the canonical model are not checked.
+func CheckCanonicalSchema(schema Schema) error {
+	keys := make([]string, 0, len(schema.Fields))
+	for key := range schema.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		want, ok := CanonicalFields[key]
+		if !ok {
+			continue
+		}
+		var got string
+		switch v := schema.Fields[key].(type) {
+		case string:
+			got = v
+		case FieldType:
+			got = string(v)
+		default:
+			return fmt.Errorf("schema field %s: type must be a string, got %T", key, v)
+		}
+		if got != "" && got != string(want) {
+			return fmt.Errorf("schema field %s: canonical type is %s, not %s", key, want, got)
+		}
+	}
+	return nil
+}
+
+func canonicalKeys() []string {
+	keys := make([]string, 0, len(CanonicalFields))
+	for key := range CanonicalFields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stringList accepts []string and the []interface{} JSON decoding produces
+func stringList(v interface{}) ([]string, error) {
+	switch list := v.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected list of strings, found %T", item)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("expected list of strings, got %T", v)
+}
+
+func timeValue(v interface{}) (time.Time, error) {
+	switch ts := v.(type) {
+	case time.Time:
+		return ts, nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("expected RFC 3339 time, got %q", ts)
+		}
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("expected RFC 3339 time, got %T", v)
+}
+
+// floatValue accepts any numeric type, as JSON and YAML decoding vary
+func floatValue(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint:
+		return float64(n), nil
+	case uint32:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	}
+	return 0, fmt.Errorf("expected number, got %T", v)
+}
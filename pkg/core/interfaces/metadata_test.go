@@ -0,0 +1,72 @@
+package interfaces
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCanonicalMetadata(t *testing.T) {
+	md := map[string]interface{}{
+		MetaTitle:       "Sunset",
+		MetaTags:        []interface{}{"beach", "evening"},
+		MetaCapturedAt:  "2024-02-03T10:20:30Z",
+		MetaLatitude:    48.85,
+		MetaLongitude:   2,
+		MetaWidth:       float64(4000),
+		MetaHeight:      3000,
+		MetaDuration:    1.5,
+		"service_field": struct{}{},
+	}
+
+	c, err := ParseCanonicalMetadata(md)
+	require.NoError(t, err)
+	assert.Equal(t, "Sunset", c.Title)
+	assert.Equal(t, []string{"beach", "evening"}, c.Tags)
+	assert.Equal(t, time.Date(2024, 2, 3, 10, 20, 30, 0, time.UTC), c.CapturedAt)
+	require.NotNil(t, c.Longitude)
+	assert.Equal(t, 2.0, *c.Longitude)
+	assert.Nil(t, c.Altitude)
+	assert.Equal(t, 4000, c.Width)
+	assert.Equal(t, 1500*time.Millisecond, c.Duration)
+
+	assert.Equal(t, map[string]interface{}{
+		MetaTitle:      "Sunset",
+		MetaTags:       []string{"beach", "evening"},
+		MetaCapturedAt: "2024-02-03T10:20:30Z",
+		MetaLatitude:   48.85,
+		MetaLongitude:  2.0,
+		MetaWidth:      4000,
+		MetaHeight:     3000,
+		MetaDuration:   1.5,
+	}, c.Map())
+}
+
+func TestValidateMetadata(t *testing.T) {
+	assert.NoError(t, ValidateMetadata(nil))
+	for _, md := range []map[string]interface{}{
+		{MetaTitle: 42},
+		{MetaTags: "a,b"},
+		{MetaTags: []interface{}{"a", 1}},
+		{MetaPublishedAt: "yesterday"},
+		{MetaLatitude: "48.85"},
+		{MetaLatitude: 91.0},
+		{MetaLongitude: -181},
+		{MetaWidth: 10.5},
+		{MetaHeight: -1},
+		{MetaDuration: -2.0},
+	} {
+		assert.Error(t, ValidateMetadata(md), md)
+	}
+}
+
+func TestCheckCanonicalSchema(t *testing.T) {
+	assert.NoError(t, CheckCanonicalSchema(CanonicalSchema(MediaTypePhoto)))
+	assert.NoError(t, CheckCanonicalSchema(Schema{Fields: map[string]interface{}{
+		MetaWidth: FieldInteger, MetaTitle: "", "likes": "number",
+	}}))
+	assert.Error(t, CheckCanonicalSchema(Schema{Fields: map[string]interface{}{MetaTags: "string"}}))
+	assert.Error(t, CheckCanonicalSchema(Schema{Fields: map[string]interface{}{MetaWidth: 1}}))
+}
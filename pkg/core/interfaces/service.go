@@ -2,10 +2,24 @@ package interfaces
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 )
 
+// ErrStreamDropped is returned by transforms that intentionally drop a
+// stream; pipelines skip the item rather than treating it as a failure
+var ErrStreamDropped = errors.New("stream dropped")
+
+// ErrStreamRejected is returned by transforms that refuse a stream whose
+// content is invalid; unlike ErrStreamDropped it is a failure, and the
+// wrapping error gives the reason
+var ErrStreamRejected = errors.New("stream rejected")
+
+// MetaRoute is the metadata key holding the route a transform assigned to a
+// stream; pipelines publish routed streams only to the outputs of that route
+const MetaRoute = "route"
+
 // Service defines the basic contract for all services
 type Service interface {
 	// Service lifecycle
@@ -80,6 +94,17 @@ type DataStream struct {
 	Content  io.ReadCloser          `json:"-"`
 	Headers  map[string]string      `json:"headers"`
 	Context  StreamContext          `json:"context"`
+
+	// Derived holds streams produced from this one by transforms, such as
+	// renditions; each refers back to it through Context.ParentID
+	Derived []*DataStream `json:"derived,omitempty"`
+}
+
+// ReopenableContent is DataStream content backed by stored data, such as a
+// spooled file, that each consumer can read again from the start
+type ReopenableContent interface {
+	io.ReadCloser
+	Reopen() (io.ReadCloser, error)
 }
 
 // RetrievalRequest defines parameters for data retrieval
@@ -108,6 +133,18 @@ type DestinationConfig struct {
 type Schema struct {
 	Type   string                 `json:"type"`
 	Fields map[string]interface{} `json:"fields"`
+
+	// Metadata is a JSON Schema document describing DataStream.Metadata;
+	// nil leaves metadata undeclared
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// SchemaProvider is implemented by services that declare the streams they
+// accept and produce, so pipelines can check adjacent stages when they are
+// built. An empty Schema leaves that side undeclared.
+type SchemaProvider interface {
+	InputSchema() Schema
+	OutputSchema() Schema
 }
 
 // Enums and supporting types
@@ -146,6 +183,7 @@ const (
 	AuthTypeJWT    AuthType = "jwt"
 	AuthTypeAPIKey AuthType = "apikey"
 	AuthTypeBasic  AuthType = "basic"
+	AuthTypeSSHKey AuthType = "sshkey"
 )
 
 // Supporting structs
@@ -153,6 +191,9 @@ type StreamContext struct {
 	Source      string    `json:"source"`
 	CreatedAt   time.Time `json:"created_at"`
 	ProcessedAt time.Time `json:"processed_at"`
+	ParentID    string    `json:"parent_id,omitempty"`
+	// Pipeline names the pipeline processing the stream
+	Pipeline string `json:"pipeline,omitempty"`
 }
 
 type TimeRange struct {
@@ -0,0 +1,275 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Satisfies reports whether every value valid against out is also valid
+// against in, so a stage producing out can feed a stage accepting in. It
+// returns a *ValidationError whose paths point into in.
+//
+// The check is conservative where it can decide and permissive where out
+// says nothing: a subschema without type or structure keywords, or a
+// property out does not describe while leaving additional properties open,
+// is assumed compatible and left to runtime validation. Combinators in out
+// and not in in are not analysed.
+func Satisfies(out, in *Schema) error {
+	errs := satisfies(out, in, "")
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: errs}
+}
+
+func satisfies(out, in *Schema, path string) []Error {
+	if in == nil || in.always != nil && *in.always {
+		return nil
+	}
+	if out != nil && out.always != nil && !*out.always {
+		return nil
+	}
+	if in.always != nil {
+		return []Error{{path, "input accepts no value"}}
+	}
+	if !out.described() {
+		return nil
+	}
+
+	// Enumerated outputs are checked exactly
+	if out.hasConst || out.enum != nil {
+		values := out.enum
+		if out.hasConst {
+			values = []interface{}{out.constant}
+		}
+		var errs []Error
+		for _, v := range values {
+			for _, e := range in.validate(v, path) {
+				errs = append(errs, Error{e.Path, fmt.Sprintf("output value %s: %s", render(v), e.Message)})
+			}
+		}
+		return errs
+	}
+
+	var errs []Error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, Error{path, fmt.Sprintf(format, args...)})
+	}
+
+	for _, sub := range in.allOf {
+		errs = append(errs, satisfies(out, sub, path)...)
+	}
+	for _, c := range []struct {
+		keyword  string
+		branches []*Schema
+	}{{"anyOf", in.anyOf}, {"oneOf", in.oneOf}} {
+		if c.branches == nil {
+			continue
+		}
+		matched := false
+		for _, sub := range c.branches {
+			if len(satisfies(out, sub, path)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("output does not satisfy any schema in %s", c.keyword)
+		}
+	}
+	if in.hasConst || in.enum != nil {
+		fail("input accepts only enumerated values but output is not enumerated")
+	}
+
+	if len(in.types) > 0 && len(out.types) > 0 {
+		for _, t := range out.types {
+			if !matchesTypeName(in.types, t) {
+				fail("output may be %s, input accepts %s", t, strings.Join(in.types, " or "))
+			}
+		}
+	}
+
+	if out.may(TypeNumber) || out.may(TypeInteger) {
+		errs = append(errs, numberBounds(out, in, path)...)
+	}
+	if out.may(TypeString) {
+		if in.minLength != nil && (out.minLength == nil || *out.minLength < *in.minLength) {
+			fail("output strings may be shorter than minLength %d", *in.minLength)
+		}
+		if in.maxLength != nil && (out.maxLength == nil || *out.maxLength > *in.maxLength) {
+			fail("output strings may be longer than maxLength %d", *in.maxLength)
+		}
+		if in.pattern != nil && (out.pattern == nil || out.pattern.String() != in.pattern.String()) {
+			fail("output strings are not guaranteed to match pattern %s", in.pattern)
+		}
+		if checkedFormats[in.format] && out.format != in.format {
+			fail("output strings are not guaranteed to be %s", in.format)
+		}
+	}
+	if out.may(TypeArray) {
+		errs = append(errs, arrayBounds(out, in, path)...)
+	}
+	if out.may(TypeObject) {
+		errs = append(errs, objectShape(out, in, path)...)
+	}
+	return errs
+}
+
+// described reports whether s constrains values at all
+func (s *Schema) described() bool {
+	if s == nil {
+		return false
+	}
+	if s.always != nil {
+		return !*s.always
+	}
+	return len(s.types) > 0 || s.enum != nil || s.hasConst || s.properties != nil ||
+		s.required != nil || s.items != nil || s.additionalProperties != nil
+}
+
+// may reports whether values of s can have type t; schemas without a type
+// are treated as objects when they describe properties
+func (s *Schema) may(t string) bool {
+	if len(s.types) == 0 {
+		switch t {
+		case TypeObject:
+			return s.properties != nil || s.required != nil || s.additionalProperties != nil
+		case TypeArray:
+			return s.items != nil
+		}
+		return false
+	}
+	for _, have := range s.types {
+		if have == t || t == TypeInteger && have == TypeNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesTypeName(types []string, t string) bool {
+	for _, have := range types {
+		if have == t || have == TypeNumber && t == TypeInteger {
+			return true
+		}
+	}
+	return false
+}
+
+// lower returns the effective lower bound of s and whether it is exclusive
+func (s *Schema) lower() (float64, bool, bool) {
+	switch {
+	case s.exclusiveMinimum != nil && (s.minimum == nil || *s.exclusiveMinimum >= *s.minimum):
+		return *s.exclusiveMinimum, true, true
+	case s.minimum != nil:
+		return *s.minimum, false, true
+	}
+	return 0, false, false
+}
+
+// upper returns the effective upper bound of s and whether it is exclusive
+func (s *Schema) upper() (float64, bool, bool) {
+	switch {
+	case s.exclusiveMaximum != nil && (s.maximum == nil || *s.exclusiveMaximum <= *s.maximum):
+		return *s.exclusiveMaximum, true, true
+	case s.maximum != nil:
+		return *s.maximum, false, true
+	}
+	return 0, false, false
+}
+
+func numberBounds(out, in *Schema, path string) []Error {
+	var errs []Error
+	if inMin, inExcl, ok := in.lower(); ok {
+		outMin, outExcl, has := out.lower()
+		if !has || outMin < inMin || outMin == inMin && inExcl && !outExcl {
+			errs = append(errs, Error{path, fmt.Sprintf("output numbers may be below the input minimum %v", inMin)})
+		}
+	}
+	if inMax, inExcl, ok := in.upper(); ok {
+		outMax, outExcl, has := out.upper()
+		if !has || outMax > inMax || outMax == inMax && inExcl && !outExcl {
+			errs = append(errs, Error{path, fmt.Sprintf("output numbers may be above the input maximum %v", inMax)})
+		}
+	}
+	if in.multipleOf != nil {
+		if out.multipleOf == nil {
+			errs = append(errs, Error{path, fmt.Sprintf("output numbers are not guaranteed to be multiples of %v", *in.multipleOf)})
+		} else if q := *out.multipleOf / *in.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			errs = append(errs, Error{path, fmt.Sprintf("multipleOf %v does not divide output multipleOf %v", *in.multipleOf, *out.multipleOf)})
+		}
+	}
+	return errs
+}
+
+func arrayBounds(out, in *Schema, path string) []Error {
+	var errs []Error
+	if in.minItems != nil && (out.minItems == nil || *out.minItems < *in.minItems) {
+		errs = append(errs, Error{path, fmt.Sprintf("output arrays may have fewer than %d items", *in.minItems)})
+	}
+	if in.maxItems != nil && (out.maxItems == nil || *out.maxItems > *in.maxItems) {
+		errs = append(errs, Error{path, fmt.Sprintf("output arrays may have more than %d items", *in.maxItems)})
+	}
+	if in.uniqueItems && !out.uniqueItems {
+		errs = append(errs, Error{path, "output arrays may contain duplicate items"})
+	}
+	if in.items.described() {
+		if out.items == nil {
+			errs = append(errs, Error{path + "/items", "output array items are not described"})
+		} else {
+			errs = append(errs, satisfies(out.items, in.items, path+"/items")...)
+		}
+	}
+	return errs
+}
+
+func objectShape(out, in *Schema, path string) []Error {
+	var errs []Error
+	guaranteed := make(map[string]bool, len(out.required))
+	for _, name := range out.required {
+		guaranteed[name] = true
+	}
+	for _, name := range in.required {
+		if !guaranteed[name] {
+			errs = append(errs, Error{path, fmt.Sprintf("required property %q is not guaranteed by the output", name)})
+		}
+	}
+
+	for _, name := range sortedKeys(in.properties) {
+		inProp := in.properties[name]
+		child := path + "/properties/" + escapePointer(name)
+		switch {
+		case out.properties[name] != nil:
+			errs = append(errs, satisfies(out.properties[name], inProp, child)...)
+		case out.additionalProperties != nil:
+			errs = append(errs, satisfies(out.additionalProperties, inProp, child)...)
+		}
+	}
+
+	if in.additionalProperties != nil {
+		child := path + "/additionalProperties"
+		for _, name := range sortedKeys(out.properties) {
+			if in.properties[name] == nil {
+				errs = append(errs, satisfies(out.properties[name], in.additionalProperties, child)...)
+			}
+		}
+		switch {
+		case out.additionalProperties != nil:
+			errs = append(errs, satisfies(out.additionalProperties, in.additionalProperties, child)...)
+		case in.additionalProperties.always != nil && !*in.additionalProperties.always:
+			errs = append(errs, Error{child, "output may include properties the input does not allow"})
+		}
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]*Schema) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,656 @@
+// Package schema validates values against JSON Schema documents and checks
+// whether one schema's values always satisfy another. It implements the
+// validation keywords of draft 2020-12 that describe data shapes; $ref and
+// dynamic references are not supported, and annotation keywords such as
+// title and default are ignored.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// JSON types
+const (
+	TypeNull    = "null"
+	TypeBoolean = "boolean"
+	TypeObject  = "object"
+	TypeArray   = "array"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeString  = "string"
+)
+
+// Schema is a compiled JSON Schema
+type Schema struct {
+	doc map[string]interface{}
+
+	// boolean schemas: true accepts everything, false nothing
+	always *bool
+
+	types    []string
+	enum     []interface{}
+	constant interface{}
+	hasConst bool
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	minProperties        *int
+	maxProperties        *int
+
+	items       *Schema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+	format    string
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+}
+
+// unsupported lists keywords that would change validation results but are
+// not implemented, so documents using them are rejected rather than
+// silently accepted
+var unsupported = []string{
+	"$ref", "$dynamicRef", "patternProperties", "dependentRequired",
+	"dependentSchemas", "if", "prefixItems", "contains", "unevaluatedProperties",
+	"unevaluatedItems", "propertyNames",
+}
+
+// Parse compiles a JSON Schema document
+func Parse(data []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid schema JSON: %w", err)
+	}
+	return Compile(doc)
+}
+
+// Compile compiles a decoded schema document: a map as produced by JSON or
+// YAML decoding, or a boolean
+func Compile(doc interface{}) (*Schema, error) {
+	return compile(doc, "#")
+}
+
+// MustCompile is like Compile but panics on error; it is meant for
+// schemas declared in code
+func MustCompile(doc interface{}) *Schema {
+	s, err := Compile(doc)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func compile(doc interface{}, path string) (*Schema, error) {
+	if b, ok := doc.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	m, ok := toMap(doc)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean, got %T", path, doc)
+	}
+	for _, keyword := range unsupported {
+		if _, ok := m[keyword]; ok {
+			return nil, fmt.Errorf("%s: keyword %s is not supported", path, keyword)
+		}
+	}
+
+	s := &Schema{doc: m}
+	var err error
+	if s.types, err = compileTypes(m["type"], path); err != nil {
+		return nil, err
+	}
+	if v, ok := m["enum"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", path)
+		}
+		s.enum = make([]interface{}, len(list))
+		for i, item := range list {
+			s.enum[i] = normalize(item)
+		}
+	}
+	if v, ok := m["const"]; ok {
+		s.constant, s.hasConst = normalize(v), true
+	}
+
+	if v, ok := m["properties"]; ok {
+		props, ok := toMap(v)
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", path)
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compile(sub, path+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		if s.required, err = stringList(v); err != nil {
+			return nil, fmt.Errorf("%s/required: %w", path, err)
+		}
+	}
+	if v, ok := m["additionalProperties"]; ok {
+		if s.additionalProperties, err = compile(v, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := m["items"]; ok {
+		if s.items, err = compile(v, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := m["not"]; ok {
+		if s.not, err = compile(v, path+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, target := range map[string]*[]*Schema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		v, ok := m[keyword]
+		if !ok {
+			continue
+		}
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-empty array", path, keyword)
+		}
+		for i, sub := range list {
+			compiled, err := compile(sub, fmt.Sprintf("%s/%s/%d", path, keyword, i))
+			if err != nil {
+				return nil, err
+			}
+			*target = append(*target, compiled)
+		}
+	}
+
+	for keyword, target := range map[string]**int{
+		"minProperties": &s.minProperties, "maxProperties": &s.maxProperties,
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+	} {
+		if v, ok := m[keyword]; ok {
+			n, ok := number(normalize(v))
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("%s/%s: must be a non-negative integer", path, keyword)
+			}
+			i := int(n)
+			*target = &i
+		}
+	}
+	for keyword, target := range map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf": &s.multipleOf,
+	} {
+		if v, ok := m[keyword]; ok {
+			n, ok := number(normalize(v))
+			if !ok {
+				return nil, fmt.Errorf("%s/%s: must be a number", path, keyword)
+			}
+			*target = &n
+		}
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return nil, fmt.Errorf("%s/multipleOf: must be positive", path)
+	}
+	if v, ok := m["uniqueItems"]; ok {
+		if s.uniqueItems, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("%s/uniqueItems: must be a boolean", path)
+		}
+	}
+	if v, ok := m["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", path)
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", path, err)
+		}
+	}
+	if v, ok := m["format"]; ok {
+		if s.format, ok = v.(string); !ok {
+			return nil, fmt.Errorf("%s/format: must be a string", path)
+		}
+	}
+	return s, nil
+}
+
+func compileTypes(v interface{}, path string) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	types, err := stringList(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s/type: %w", path, err)
+	}
+	for _, t := range types {
+		switch t {
+		case TypeNull, TypeBoolean, TypeObject, TypeArray, TypeNumber, TypeInteger, TypeString:
+		default:
+			return nil, fmt.Errorf("%s/type: unknown type %q", path, t)
+		}
+	}
+	return types, nil
+}
+
+// Document returns the schema document, or nil for boolean schemas
+func (s *Schema) Document() map[string]interface{} { return s.doc }
+
+// MarshalJSON encodes the schema document
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	if s.always != nil {
+		return json.Marshal(*s.always)
+	}
+	return json.Marshal(s.doc)
+}
+
+// Error describes one validation failure at a JSON Pointer into the value
+type Error struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e Error) String() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError lists every failure found in a value
+type ValidationError struct {
+	Errors []Error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.String()
+	}
+	return "schema validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks v against the schema, returning a *ValidationError that
+// lists every failure
+func (s *Schema) Validate(v interface{}) error {
+	errs := s.validate(normalize(v), "")
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: errs}
+}
+
+func (s *Schema) validate(v interface{}, path string) []Error {
+	if s.always != nil {
+		if *s.always {
+			return nil
+		}
+		return []Error{{path, "no value is allowed"}}
+	}
+
+	var errs []Error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, Error{path, fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.types) > 0 && !matchesType(s.types, v) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return errs
+	}
+	if s.hasConst && !equal(v, s.constant) {
+		fail("must equal %s", render(s.constant))
+	}
+	if s.enum != nil && !containsValue(s.enum, v) {
+		fail("must be one of %s", render(s.enum))
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		errs = append(errs, s.validateObject(val, path)...)
+	case []interface{}:
+		errs = append(errs, s.validateArray(val, path)...)
+	case float64:
+		s.validateNumber(val, fail)
+	case string:
+		s.validateString(val, fail)
+	}
+
+	for _, sub := range s.allOf {
+		errs = append(errs, sub.validate(v, path)...)
+	}
+	if s.anyOf != nil {
+		if count := countValid(s.anyOf, v, path); count == 0 {
+			fail("must match at least one schema in anyOf")
+		}
+	}
+	if s.oneOf != nil {
+		if count := countValid(s.oneOf, v, path); count != 1 {
+			fail("must match exactly one schema in oneOf, matched %d", count)
+		}
+	}
+	if s.not != nil && len(s.not.validate(v, path)) == 0 {
+		fail("must not match the schema in not")
+	}
+	return errs
+}
+
+func countValid(schemas []*Schema, v interface{}, path string) int {
+	count := 0
+	for _, sub := range schemas {
+		if len(sub.validate(v, path)) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Schema) validateObject(obj map[string]interface{}, path string) []Error {
+	var errs []Error
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			errs = append(errs, Error{path, fmt.Sprintf("missing required property %q", name)})
+		}
+	}
+	if s.minProperties != nil && len(obj) < *s.minProperties {
+		errs = append(errs, Error{path, fmt.Sprintf("must have at least %d properties", *s.minProperties)})
+	}
+	if s.maxProperties != nil && len(obj) > *s.maxProperties {
+		errs = append(errs, Error{path, fmt.Sprintf("must have at most %d properties", *s.maxProperties)})
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := path + "/" + escapePointer(name)
+		if sub, ok := s.properties[name]; ok {
+			errs = append(errs, sub.validate(obj[name], child)...)
+		} else if s.additionalProperties != nil {
+			if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+				errs = append(errs, Error{child, "additional property is not allowed"})
+				continue
+			}
+			errs = append(errs, s.additionalProperties.validate(obj[name], child)...)
+		}
+	}
+	return errs
+}
+
+func (s *Schema) validateArray(list []interface{}, path string) []Error {
+	var errs []Error
+	if s.minItems != nil && len(list) < *s.minItems {
+		errs = append(errs, Error{path, fmt.Sprintf("must have at least %d items", *s.minItems)})
+	}
+	if s.maxItems != nil && len(list) > *s.maxItems {
+		errs = append(errs, Error{path, fmt.Sprintf("must have at most %d items", *s.maxItems)})
+	}
+	if s.uniqueItems {
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				if equal(list[i], list[j]) {
+					errs = append(errs, Error{path, fmt.Sprintf("items %d and %d are equal", i, j)})
+				}
+			}
+		}
+	}
+	if s.items != nil {
+		for i, item := range list {
+			errs = append(errs, s.items.validate(item, path+"/"+strconv.Itoa(i))...)
+		}
+	}
+	return errs
+}
+
+func (s *Schema) validateNumber(n float64, fail func(string, ...interface{})) {
+	if s.minimum != nil && n < *s.minimum {
+		fail("must be at least %v", *s.minimum)
+	}
+	if s.maximum != nil && n > *s.maximum {
+		fail("must be at most %v", *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum {
+		fail("must be greater than %v", *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum {
+		fail("must be less than %v", *s.exclusiveMaximum)
+	}
+	if s.multipleOf != nil {
+		if q := n / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("must be a multiple of %v", *s.multipleOf)
+		}
+	}
+}
+
+func (s *Schema) validateString(str string, fail func(string, ...interface{})) {
+	length := utf8.RuneCountInString(str)
+	if s.minLength != nil && length < *s.minLength {
+		fail("must be at least %d characters", *s.minLength)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		fail("must be at most %d characters", *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		fail("must match pattern %s", s.pattern)
+	}
+	if s.format != "" {
+		if err := checkFormat(s.format, str); err != nil {
+			fail("invalid %s: %v", s.format, err)
+		}
+	}
+}
+
+// checkedFormats are the formats checkFormat validates; others are
+// annotations only
+var checkedFormats = map[string]bool{"date-time": true, "date": true, "uri": true, "email": true, "duration": true}
+
+// checkFormat validates the formats in checkedFormats
+func checkFormat(format, s string) error {
+	var err error
+	switch format {
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, s)
+	case "date":
+		_, err = time.Parse("2006-01-02", s)
+	case "uri":
+		var u *url.URL
+		if u, err = url.Parse(s); err == nil && !u.IsAbs() {
+			err = fmt.Errorf("%q is not an absolute URI", s)
+		}
+	case "email":
+		_, err = mail.ParseAddress(s)
+	case "duration":
+		_, err = time.ParseDuration(s)
+	}
+	return err
+}
+
+func matchesType(types []string, v interface{}) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual || t == TypeNumber && actual == TypeInteger {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON type of a normalized value; integral numbers are
+// reported as integers
+func typeOf(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case string:
+		return TypeString
+	case float64:
+		if val == math.Trunc(val) && !math.IsInf(val, 0) {
+			return TypeInteger
+		}
+		return TypeNumber
+	case []interface{}:
+		return TypeArray
+	case map[string]interface{}:
+		return TypeObject
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if equal(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func render(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func stringList(v interface{}) ([]string, error) {
+	switch list := v.(type) {
+	case string:
+		return []string{list}, nil
+	case []string:
+		return list, nil
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be a string or array of strings")
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("must be a string or array of strings")
+}
+
+// toMap accepts the mapping types produced by JSON and YAML decoders
+func toMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			out[fmt.Sprint(k)] = val
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// normalize converts Go values into the types JSON decoding produces:
+// nil, bool, float64, string, []interface{} and map[string]interface{}.
+// time.Time becomes an RFC 3339 string.
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, bool, float64, string:
+		return val
+	case int:
+		return float64(val)
+	case int32:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case uint:
+		return float64(val)
+	case uint32:
+		return float64(val)
+	case uint64:
+		return float64(val)
+	case float32:
+		return float64(val)
+	case json.Number:
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val.String()
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano)
+	case []string:
+		out := make([]interface{}, len(val))
+		for i, s := range val {
+			out[i] = s
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalize(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = normalize(item)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[fmt.Sprint(k)] = normalize(item)
+		}
+		return out
+	}
+
+	// Structs and other types take their JSON form
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return fmt.Sprint(v)
+	}
+	return out
+}
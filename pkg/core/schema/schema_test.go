@@ -0,0 +1,172 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const photoSchema = `{
+	"type": "object",
+	"required": ["title", "width"],
+	"properties": {
+		"title": {"type": "string", "minLength": 1, "maxLength": 20},
+		"width": {"type": "integer", "minimum": 1},
+		"ratio": {"type": "number", "exclusiveMaximum": 10, "multipleOf": 0.5},
+		"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "uniqueItems": true, "maxItems": 3},
+		"captured_at": {"type": "string", "format": "date-time"},
+		"source_url": {"type": "string", "format": "uri"},
+		"visibility": {"enum": ["public", "private"]},
+		"kind": {"const": "photo"},
+		"location": {
+			"type": "object",
+			"properties": {"lat": {"type": "number", "minimum": -90, "maximum": 90}},
+			"additionalProperties": false
+		},
+		"note": {"type": ["string", "null"]},
+		"rating": {"anyOf": [{"type": "integer"}, {"type": "string", "pattern": "^[0-5]$"}]},
+		"alt": {"oneOf": [{"type": "string"}, {"type": "string", "minLength": 3}]},
+		"draft": {"not": {"const": true}}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(photoSchema))
+	require.NoError(t, err)
+
+	valid := map[string]interface{}{
+		"title":       "Sunset",
+		"width":       1280,
+		"ratio":       float32(1.5),
+		"tags":        []string{"beach", "sky"},
+		"captured_at": time.Date(2024, 2, 3, 10, 20, 30, 0, time.UTC),
+		"source_url":  "https://example.com/1",
+		"visibility":  "public",
+		"kind":        "photo",
+		"location":    map[string]interface{}{"lat": 48.8},
+		"note":        nil,
+		"rating":      "4",
+		"alt":         "ab",
+		"extra":       struct{ A int }{1},
+	}
+	assert.NoError(t, s.Validate(valid))
+
+	invalid := map[string]interface{}{
+		"title":       "",
+		"width":       12.5,
+		"ratio":       10,
+		"tags":        []interface{}{"Beach", "sky", "sky", "sea"},
+		"captured_at": "yesterday",
+		"source_url":  "/relative",
+		"visibility":  "friends",
+		"kind":        "video",
+		"location":    map[string]interface{}{"lat": 91, "lng": 2},
+		"note":        3,
+		"rating":      "9",
+		"alt":         "abcd",
+		"draft":       true,
+	}
+	err = s.Validate(invalid)
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+
+	paths := make(map[string]bool)
+	for _, e := range verr.Errors {
+		paths[e.Path] = true
+	}
+	for _, p := range []string{
+		"/title", "/width", "/ratio", "/tags", "/tags/0", "/captured_at", "/source_url",
+		"/visibility", "/kind", "/location/lat", "/location/lng", "/note", "/rating", "/alt", "/draft",
+	} {
+		assert.True(t, paths[p], "expected an error at %q in %v", p, err)
+	}
+
+	err = s.Validate(map[string]interface{}{"title": "Sunset"})
+	assert.EqualError(t, err, `schema validation failed: missing required property "width"`)
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for _, doc := range []string{
+		`"object"`,
+		`{"type": "text"}`,
+		`{"$ref": "#/defs/a"}`,
+		`{"properties": []}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`{"anyOf": []}`,
+		`{"multipleOf": 0}`,
+		`{"items": {"type": 1}}`,
+		`not json`,
+	} {
+		_, err := Parse([]byte(doc))
+		assert.Error(t, err, doc)
+	}
+
+	s, err := Compile(false)
+	require.NoError(t, err)
+	assert.Error(t, s.Validate("anything"))
+	assert.Panics(t, func() { MustCompile(map[string]interface{}{"type": 3}) })
+}
+
+func TestSatisfies(t *testing.T) {
+	in := MustCompile(map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"title"},
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "string", "maxLength": 100},
+			"width": map[string]interface{}{"type": "number", "minimum": 0},
+			"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"kind":  map[string]interface{}{"enum": []interface{}{"photo", "video"}},
+		},
+	})
+
+	compatible := MustCompile(map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"title", "width"},
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "string", "maxLength": 50},
+			"width": map[string]interface{}{"type": "integer", "minimum": 1},
+			"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "minLength": 1}},
+			"kind":  map[string]interface{}{"const": "photo"},
+			"extra": map[string]interface{}{"type": "boolean"},
+		},
+	})
+	assert.NoError(t, Satisfies(compatible, in))
+	assert.NoError(t, Satisfies(nil, in), "undeclared output is checked at runtime")
+	assert.NoError(t, Satisfies(compatible, nil))
+
+	incompatible := MustCompile(map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": []interface{}{"string", "null"}},
+			"width": map[string]interface{}{"type": "number", "minimum": -1},
+			"tags":  map[string]interface{}{"type": "array"},
+			"kind":  map[string]interface{}{"enum": []interface{}{"photo", "audio"}},
+		},
+	})
+	err := Satisfies(incompatible, in)
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, []Error{
+		{"", `required property "title" is not guaranteed by the output`},
+		{"/properties/kind", `output value "audio": must be one of ["photo","video"]`},
+		{"/properties/tags/items", "output array items are not described"},
+		{"/properties/title", "output may be null, input accepts string"},
+		{"/properties/title", "output strings may be longer than maxLength 100"},
+		{"/properties/width", "output numbers may be below the input minimum 0"},
+	}, verr.Errors)
+
+	closed := MustCompile(map[string]interface{}{"type": "object", "additionalProperties": false})
+	assert.Error(t, Satisfies(MustCompile(map[string]interface{}{"type": "object"}), closed))
+	assert.NoError(t, Satisfies(MustCompile(map[string]interface{}{"type": "object", "additionalProperties": false}), closed))
+
+	either := MustCompile(map[string]interface{}{"anyOf": []interface{}{
+		map[string]interface{}{"type": "string"},
+		map[string]interface{}{"type": "integer"},
+	}})
+	assert.NoError(t, Satisfies(MustCompile(map[string]interface{}{"type": "integer"}), either))
+	assert.Error(t, Satisfies(MustCompile(map[string]interface{}{"type": "number"}), either))
+}
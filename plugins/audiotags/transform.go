@@ -0,0 +1,273 @@
+// Package audiotags provides a transform that copies ID3, Vorbis comment
+// and MP4 atom tags of audio files into DataStream.Metadata and emits
+// embedded cover art as a derived photo stream.
+package audiotags
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // cover art dimensions
+	_ "image/jpeg" // cover art dimensions
+	_ "image/png"  // cover art dimensions
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/media/audiometa"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform besides the canonical title,
+// author and duration
+const (
+	KeyArtist      = "artist"
+	KeyAlbum       = "album"
+	KeyAlbumArtist = "album_artist"
+	KeyTrack       = "track_number"
+	KeyTrackTotal  = "track_total"
+	KeyDisc        = "disc_number"
+	KeyDiscTotal   = "disc_total"
+	KeyGenre       = "genre"
+	KeyReleaseDate = "release_date"
+	// KeyCoverArt is the ID of the derived cover art stream
+	KeyCoverArt = "cover_art"
+	// KeySources lists the tag formats that were found
+	KeySources = "metadata_sources"
+)
+
+// CoverArtSuffix is appended to the stream ID and filename of cover art
+const CoverArtSuffix = "cover"
+
+const defaultMaxBytes = 256 << 20
+
+// Transform reads audio tags from up to max_bytes of the content without
+// consuming it. Files larger than that are tagged from their beginning,
+// which holds ID3v2, FLAC and Ogg tags but not ID3v1 or trailing MP4
+// metadata.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu        sync.RWMutex
+	maxBytes  int
+	coverArt  bool
+	overwrite bool
+	now       func() time.Time
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates an audio tag transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin: plugins.NewBasePlugin(config, "transform", "Extracts ID3, Vorbis comment and MP4 tags and cover art from audio"),
+		maxBytes:   defaultMaxBytes,
+		coverArt:   true,
+		now:        time.Now,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	if maxBytes < 1024 {
+		return fmt.Errorf("%w: max_bytes must be at least 1024", plugins.ErrInvalidConfig)
+	}
+	coverArt, err := settings.Bool("cover_art", true)
+	if err != nil {
+		return err
+	}
+	overwrite, err := settings.Bool("overwrite", false)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxBytes = maxBytes
+	t.coverArt = coverArt
+	t.overwrite = overwrite
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types": []string{string(interfaces.MediaTypeAudio)},
+			"formats": []string{
+				audiometa.FormatMP3, audiometa.FormatFLAC, audiometa.FormatOgg, audiometa.FormatMP4,
+			},
+			"sources": []string{
+				audiometa.SourceID3v2, audiometa.SourceID3v1, audiometa.SourceVorbis, audiometa.SourceMP4,
+			},
+		}},
+	}
+}
+
+// ValidateSchema accepts audio streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypeAudio) {
+		return fmt.Errorf("audio tag extraction does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform adds tag metadata to audio streams and appends the cover art,
+// if any, to data.Derived. Keys already present are kept unless overwrite
+// is set; other streams and unrecognised files pass through.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypeAudio || data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	maxBytes, coverArt, overwrite := t.maxBytes, t.coverArt, t.overwrite
+	t.mu.RUnlock()
+
+	head, content, err := media.Peek(data.Content, maxBytes+1)
+	data.Content = content
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+	complete := len(head) <= maxBytes
+	tags, err := audiometa.Read(head[:min(len(head), maxBytes)], complete)
+	if errors.Is(err, audiometa.ErrUnknownFormat) {
+		return data, nil
+	}
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read tags of %s: %w", data.ID, err)
+	}
+
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	fields := Fields(tags)
+	if coverArt && tags.Picture != nil {
+		cover := t.cover(data, tags)
+		data.Derived = append(data.Derived, cover)
+		fields[KeyCoverArt] = cover.ID
+	}
+	for key, value := range fields {
+		if _, exists := data.Metadata[key]; exists && !overwrite {
+			continue
+		}
+		data.Metadata[key] = value
+	}
+	if len(tags.Sources) > 0 {
+		data.Metadata[KeySources] = tags.Sources
+	}
+	t.RecordError(nil)
+	return data, nil
+}
+
+// Fields converts tags to metadata keys, omitting absent values. The
+// artist, or else the album artist, becomes the canonical author.
+func Fields(tags *audiometa.Tags) map[string]interface{} {
+	fields := make(map[string]interface{})
+	setString := func(key, value string) {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	setInt := func(key string, value int) {
+		if value > 0 {
+			fields[key] = value
+		}
+	}
+	setString(interfaces.MetaTitle, tags.Title)
+	setString(KeyArtist, tags.Artist)
+	setString(KeyAlbum, tags.Album)
+	setString(KeyAlbumArtist, tags.AlbumArtist)
+	setString(KeyGenre, tags.Genre)
+	setString(KeyReleaseDate, tags.Date)
+	setInt(KeyTrack, tags.Track)
+	setInt(KeyTrackTotal, tags.TrackTotal)
+	setInt(KeyDisc, tags.Disc)
+	setInt(KeyDiscTotal, tags.DiscTotal)
+	if tags.Artist != "" {
+		fields[interfaces.MetaAuthor] = tags.Artist
+	} else {
+		setString(interfaces.MetaAuthor, tags.AlbumArtist)
+	}
+	if tags.Duration > 0 {
+		fields[interfaces.MetaDuration] = math.Round(tags.Duration.Seconds()*1000) / 1000
+	}
+	return fields
+}
+
+// cover builds the derived photo stream for the embedded picture
+func (t *Transform) cover(data *interfaces.DataStream, tags *audiometa.Tags) *interfaces.DataStream {
+	picture := tags.Picture
+	contentType := media.Sniff(picture.Data)
+	if media.TypeOf(contentType) != interfaces.MediaTypePhoto && picture.MIMEType != "" {
+		contentType = picture.MIMEType
+	}
+
+	metadata := map[string]interface{}{
+		"filename": coverFilename(data, media.Extension(contentType)),
+	}
+	if title := tags.Album; title != "" {
+		metadata[interfaces.MetaTitle] = title
+	} else if tags.Title != "" {
+		metadata[interfaces.MetaTitle] = tags.Title
+	}
+	if author, ok := Fields(tags)[interfaces.MetaAuthor]; ok {
+		metadata[interfaces.MetaAuthor] = author
+	}
+	if picture.Description != "" {
+		metadata[interfaces.MetaDescription] = picture.Description
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(picture.Data)); err == nil {
+		metadata[interfaces.MetaWidth] = config.Width
+		metadata[interfaces.MetaHeight] = config.Height
+	}
+
+	return &interfaces.DataStream{
+		ID:       data.ID + "/" + CoverArtSuffix,
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: metadata,
+		Content:  io.NopCloser(bytes.NewReader(picture.Data)),
+		Headers: map[string]string{
+			"Content-Type":   contentType,
+			"Content-Length": strconv.Itoa(len(picture.Data)),
+		},
+		Context: interfaces.StreamContext{
+			Source:    data.Context.Source,
+			CreatedAt: t.now(),
+			ParentID:  data.ID,
+		},
+	}
+}
+
+// coverFilename derives "<base>-cover<ext>" from the audio filename, or
+// from the stream ID when there is none
+func coverFilename(data *interfaces.DataStream, ext string) string {
+	base := data.ID
+	if filename, ok := data.Metadata["filename"].(string); ok && filename != "" {
+		base = strings.TrimSuffix(filename, path.Ext(filename))
+	}
+	return base + "-" + CoverArtSuffix + ext
+}
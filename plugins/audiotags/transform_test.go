@@ -0,0 +1,134 @@
+package audiotags
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+var testNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "audiotags", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	tr.now = func() time.Time { return testNow }
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+// frame builds an ID3v2.3 frame
+func frame(id string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append(append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(len(body)))...), append([]byte{0, 0}, body...)...)
+}
+
+// sampleMP3 builds an ID3v2.3 tagged MP3 with a Xing header of 1000 frames
+// and, when cover is set, a front cover picture
+func sampleMP3(t *testing.T, cover bool) []byte {
+	t.Helper()
+	frames := [][]byte{
+		frame("TIT2", []byte{3}, []byte("Episode 1")),
+		frame("TPE1", []byte{3}, []byte("Alice")),
+		frame("TALB", []byte{3}, []byte("The Show")),
+		frame("TRCK", []byte{3}, []byte("1/10")),
+	}
+	if cover {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 6, 4))))
+		frames = append(frames, frame("APIC", []byte{0}, []byte("image/png\x00"), []byte{3}, []byte("Front\x00"), buf.Bytes()))
+	}
+	body := bytes.Join(frames, nil)
+	n := len(body)
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0, byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}, body...)
+
+	audio := make([]byte, 417*3)
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x00})
+	copy(audio[36:], []byte("Xing\x00\x00\x00\x01\x00\x00\x03\xe8"))
+	return append(tag, audio...)
+}
+
+func audioStream(content []byte) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "ep-1",
+		Type:     interfaces.MediaTypeAudio,
+		Metadata: map[string]interface{}{"filename": "episode.mp3", interfaces.MetaTitle: "Kept"},
+		Content:  io.NopCloser(bytes.NewReader(content)),
+		Context:  interfaces.StreamContext{Source: "podcast"},
+	}
+}
+
+func TestTransform_Tags(t *testing.T) {
+	content := sampleMP3(t, true)
+	out, err := newTransform(t, nil).Transform(context.Background(), audioStream(content))
+	require.NoError(t, err)
+
+	md := out.Metadata
+	assert.Equal(t, "Kept", md[interfaces.MetaTitle], "existing values are kept")
+	assert.Equal(t, "Alice", md[interfaces.MetaAuthor])
+	assert.Equal(t, "Alice", md[KeyArtist])
+	assert.Equal(t, "The Show", md[KeyAlbum])
+	assert.Equal(t, 1, md[KeyTrack])
+	assert.Equal(t, 10, md[KeyTrackTotal])
+	assert.Equal(t, 26.122, md[interfaces.MetaDuration])
+	assert.Equal(t, []string{"id3v2", "stream"}, md[KeySources])
+	assert.NoError(t, interfaces.ValidateMetadata(md))
+
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+
+	require.Len(t, out.Derived, 1)
+	cover := out.Derived[0]
+	assert.Equal(t, "ep-1/cover", cover.ID)
+	assert.Equal(t, cover.ID, md[KeyCoverArt])
+	assert.Equal(t, interfaces.MediaTypePhoto, cover.Type)
+	assert.Equal(t, "image/png", cover.Headers["Content-Type"])
+	assert.Equal(t, "episode-cover.png", cover.Metadata["filename"])
+	assert.Equal(t, "The Show", cover.Metadata[interfaces.MetaTitle])
+	assert.Equal(t, 6, cover.Metadata[interfaces.MetaWidth])
+	assert.Equal(t, 4, cover.Metadata[interfaces.MetaHeight])
+	assert.Equal(t, "ep-1", cover.Context.ParentID)
+	assert.Equal(t, testNow, cover.Context.CreatedAt)
+}
+
+func TestTransform_Options(t *testing.T) {
+	ctx := context.Background()
+
+	out, err := newTransform(t, map[string]interface{}{"cover_art": false, "overwrite": true}).Transform(ctx, audioStream(sampleMP3(t, true)))
+	require.NoError(t, err)
+	assert.Empty(t, out.Derived)
+	assert.NotContains(t, out.Metadata, KeyCoverArt)
+	assert.Equal(t, "Episode 1", out.Metadata[interfaces.MetaTitle])
+
+	out, err = newTransform(t, nil).Transform(ctx, audioStream(sampleMP3(t, false)))
+	require.NoError(t, err)
+	assert.Empty(t, out.Derived)
+
+	tr := newTransform(t, nil)
+	unknown := audioStream([]byte("this is not audio"))
+	out, err = tr.Transform(ctx, unknown)
+	require.NoError(t, err)
+	assert.NotContains(t, out.Metadata, KeySources)
+
+	photo := audioStream(sampleMP3(t, false))
+	photo.Type = interfaces.MediaTypePhoto
+	out, err = tr.Transform(ctx, photo)
+	require.NoError(t, err)
+	assert.NotContains(t, out.Metadata, KeyArtist)
+
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"max_bytes": 10}), plugins.ErrInvalidConfig)
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "video"}))
+}
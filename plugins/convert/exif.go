@@ -0,0 +1,70 @@
+package convert
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+	"github.com/sho7650/media-sync/internal/media/imaging"
+)
+
+// maxAPP1Payload is the largest payload a JPEG marker segment can carry
+const maxAPP1Payload = 0xFFFF - 2
+
+// embedEXIF inserts a TIFF-structured EXIF block into an image produced by
+// imaging.Encode: as an APP1 segment directly after the JPEG SOI marker, or
+// as an eXIf chunk after the PNG IHDR chunk. It reports false, leaving b
+// unchanged, when the format cannot carry the block, such as EXIF larger
+// than a single JPEG segment.
+func embedEXIF(format string, b, exif []byte) ([]byte, bool) {
+	if len(exif) == 0 {
+		return b, false
+	}
+	switch format {
+	case imaging.FormatJPEG:
+		size := len(imagemeta.JPEGEXIFHeader) + len(exif)
+		if size > maxAPP1Payload || len(b) < 2 {
+			return b, false
+		}
+		out := make([]byte, 0, len(b)+size+4)
+		out = append(out, b[:2]...)
+		out = append(out, 0xFF, 0xE1)
+		out = binary.BigEndian.AppendUint16(out, uint16(size+2))
+		out = append(out, imagemeta.JPEGEXIFHeader...)
+		out = append(out, exif...)
+		return append(out, b[2:]...), true
+	case imaging.FormatPNG:
+		chunks := imagemeta.PNGChunks(b)
+		if len(chunks) == 0 || chunks[0].Type != "IHDR" {
+			return b, false
+		}
+		ihdrEnd := chunks[0].End
+		out := make([]byte, 0, len(b)+len(exif)+12)
+		out = append(out, b[:ihdrEnd]...)
+		out = binary.BigEndian.AppendUint32(out, uint32(len(exif)))
+		start := len(out)
+		out = append(out, "eXIf"...)
+		out = append(out, exif...)
+		out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+		return append(out, b[ihdrEnd:]...), true
+	}
+	return b, false
+}
+
+// uprightEXIF adapts an EXIF block to pixels that have had its orientation
+// applied and are w x h: the Orientation tag is reset to 1 and the pixel
+// dimensions are updated. Blocks that cannot be decoded are not carried over,
+// since they may still rotate the image.
+func uprightEXIF(block []byte, w, h int) []byte {
+	e, err := imagemeta.ParseEXIF(block)
+	if err != nil {
+		return nil
+	}
+	changed := e.SetUint(imagemeta.TagOrientation, 1)
+	changed = e.SetUint(imagemeta.TagPixelXDimension, uint32(w)) || changed
+	changed = e.SetUint(imagemeta.TagPixelYDimension, uint32(h)) || changed
+	if !changed {
+		return block
+	}
+	return e.Encode()
+}
@@ -0,0 +1,346 @@
+// Package convert provides a transform that converts photos to the formats
+// a destination accepts, in pure Go.
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+	"github.com/sho7650/media-sync/internal/media/imaging"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform
+const (
+	// KeyConvertedFrom is the format a converted photo was decoded from
+	KeyConvertedFrom = "converted_from"
+	// KeyEXIFKept reports whether the source EXIF block was carried over
+	KeyEXIFKept = "exif_kept"
+)
+
+// Target formats; TargetAuto picks JPEG for opaque or flattened images and
+// PNG for transparent ones, among the accepted formats
+const (
+	TargetAuto = "auto"
+)
+
+// Animated GIF handling
+const (
+	// AnimationPassthrough leaves animated GIFs unchanged
+	AnimationPassthrough = "passthrough"
+	// AnimationFirstFrame converts the first frame
+	AnimationFirstFrame = "first_frame"
+)
+
+// acceptable are the formats a destination can be declared to accept
+var acceptable = map[string]bool{
+	imaging.FormatJPEG: true, imaging.FormatPNG: true, imaging.FormatGIF: true, "webp": true,
+}
+
+const (
+	defaultQuality   = 90
+	defaultMaxBytes  = 256 << 20
+	defaultMaxPixels = 100_000_000
+)
+
+// Policy describes what one destination accepts and how other images are
+// converted for it. Outputs with different needs use separate instances of
+// the transform, each in the pipeline feeding that output.
+type Policy struct {
+	Accept     []string
+	Target     string
+	Quality    int
+	Flatten    bool
+	Background color.Color
+	Animation  string
+	KeepEXIF   bool
+}
+
+// Transform converts photos whose format the policy does not accept.
+// Accepted photos, and formats without a pure Go decoder, pass through.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu        sync.RWMutex
+	policy    Policy
+	maxBytes  int64
+	maxPixels int
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a format conversion transform; it matches
+// PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin: plugins.NewBasePlugin(config, "transform", "Converts photos to the formats a destination accepts"),
+		policy: Policy{
+			Accept:     []string{imaging.FormatJPEG, imaging.FormatPNG},
+			Target:     TargetAuto,
+			Quality:    defaultQuality,
+			Background: color.White,
+			Animation:  AnimationPassthrough,
+			KeepEXIF:   true,
+		},
+		maxBytes:  defaultMaxBytes,
+		maxPixels: defaultMaxPixels,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	accept, err := settings.StringSlice("accept")
+	if err != nil {
+		return err
+	}
+	if len(accept) == 0 {
+		accept = []string{imaging.FormatJPEG, imaging.FormatPNG}
+	}
+	for i, format := range accept {
+		format = strings.ToLower(format)
+		if format == "jpg" {
+			format = imaging.FormatJPEG
+		}
+		if !acceptable[format] {
+			return fmt.Errorf("%w: cannot accept %q; formats are jpeg, png, gif and webp", plugins.ErrInvalidConfig, format)
+		}
+		accept[i] = format
+	}
+	policy := Policy{Accept: accept, Target: settings.String("target", TargetAuto)}
+	switch policy.Target {
+	case TargetAuto:
+		if !contains(accept, imaging.FormatJPEG) && !contains(accept, imaging.FormatPNG) {
+			return fmt.Errorf("%w: target auto needs jpeg or png among the accepted formats", plugins.ErrInvalidConfig)
+		}
+	case imaging.FormatJPEG, imaging.FormatPNG:
+		if !contains(accept, policy.Target) {
+			return fmt.Errorf("%w: target %s is not an accepted format", plugins.ErrInvalidConfig, policy.Target)
+		}
+	default:
+		return fmt.Errorf("%w: target must be auto, jpeg or png", plugins.ErrInvalidConfig)
+	}
+	if policy.Quality, err = settings.Int("quality", defaultQuality); err != nil {
+		return err
+	}
+	if policy.Quality < 1 || policy.Quality > 100 {
+		return fmt.Errorf("%w: quality must be between 1 and 100", plugins.ErrInvalidConfig)
+	}
+	if policy.Flatten, err = settings.Bool("flatten", false); err != nil {
+		return err
+	}
+	if policy.Background, err = parseColor(settings.String("background", "#ffffff")); err != nil {
+		return fmt.Errorf("%w: background: %v", plugins.ErrInvalidConfig, err)
+	}
+	policy.Animation = settings.String("animation", AnimationPassthrough)
+	if policy.Animation != AnimationPassthrough && policy.Animation != AnimationFirstFrame {
+		return fmt.Errorf("%w: animation must be %s or %s", plugins.ErrInvalidConfig, AnimationPassthrough, AnimationFirstFrame)
+	}
+	if policy.KeepEXIF, err = settings.Bool("keep_exif", true); err != nil {
+		return err
+	}
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	maxPixels, err := settings.Int("max_pixels", defaultMaxPixels)
+	if err != nil {
+		return err
+	}
+	if maxBytes <= 0 || maxPixels <= 0 {
+		return fmt.Errorf("%w: max_bytes and max_pixels must be positive", plugins.ErrInvalidConfig)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.policy = policy
+	t.maxBytes = int64(maxBytes)
+	t.maxPixels = maxPixels
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types":    []string{string(interfaces.MediaTypePhoto)},
+			"input_formats":  []string{"jpeg", "png", "gif", "webp"},
+			"output_formats": []string{imaging.FormatJPEG, imaging.FormatPNG},
+			"animation":      []string{AnimationPassthrough, AnimationFirstFrame},
+		}},
+	}
+}
+
+// ValidateSchema accepts photo streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypePhoto) {
+		return fmt.Errorf("format conversion does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform replaces the content of photos in formats the policy does not
+// accept with an upright JPEG or PNG rendering, carrying the EXIF block over
+// with its orientation reset when the target format can hold it. Transparent images converted to JPEG, or
+// to any format with flatten set, are composited onto the background.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypePhoto || data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	policy, maxBytes, maxPixels := t.policy, t.maxBytes, t.maxPixels
+	t.mu.RUnlock()
+
+	content, err := media.ReadAll(data.Content, maxBytes)
+	if err != nil {
+		data.Content = nil
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+	data.Content = io.NopCloser(bytes.NewReader(content))
+
+	cfg, source, err := image.DecodeConfig(bytes.NewReader(content))
+	if errors.Is(err, image.ErrFormat) || (err == nil && !acceptable[source]) {
+		return data, nil
+	}
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to decode %s: %w", data.ID, err)
+	}
+	if contains(policy.Accept, source) {
+		return data, nil
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		err := fmt.Errorf("%w: %dx%d", imaging.ErrTooManyPixels, cfg.Width, cfg.Height)
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to decode %s: %w", data.ID, err)
+	}
+
+	var img image.Image
+	if source == imaging.FormatGIF {
+		g, err := gif.DecodeAll(bytes.NewReader(content))
+		if err != nil {
+			t.RecordError(err)
+			return nil, fmt.Errorf("failed to decode %s: %w", data.ID, err)
+		}
+		if len(g.Image) > 1 && policy.Animation == AnimationPassthrough {
+			return data, nil
+		}
+		img = firstFrame(g)
+	} else if img, _, err = imaging.Decode(content, maxPixels); err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to decode %s: %w", data.ID, err)
+	}
+	// The orientation is applied to the pixels, so the result displays
+	// upright whether or not the EXIF block is carried over
+	img = imaging.Orient(img, imaging.Orientation(content))
+
+	target := policy.Target
+	opaque := imaging.Opaque(img)
+	if target == TargetAuto {
+		target = imaging.FormatJPEG
+		if (!opaque && !policy.Flatten && contains(policy.Accept, imaging.FormatPNG)) || !contains(policy.Accept, imaging.FormatJPEG) {
+			target = imaging.FormatPNG
+		}
+	}
+	if !opaque && (policy.Flatten || target == imaging.FormatJPEG) {
+		img = flatten(img, policy.Background)
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, target, policy.Quality); err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to encode %s: %w", data.ID, err)
+	}
+	converted, exifKept := buf.Bytes(), false
+	if policy.KeepEXIF {
+		if blocks, err := imagemeta.Scan(content); err == nil && blocks.EXIF != nil {
+			b := img.Bounds()
+			converted, exifKept = embedEXIF(target, converted, uprightEXIF(blocks.EXIF, b.Dx(), b.Dy()))
+		}
+	}
+
+	contentType := imaging.ContentType(target)
+	data.Content = io.NopCloser(bytes.NewReader(converted))
+	if data.Headers == nil {
+		data.Headers = make(map[string]string)
+	}
+	data.Headers["Content-Type"] = contentType
+	data.Headers["Content-Length"] = strconv.Itoa(len(converted))
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	if filename, ok := data.Metadata["filename"].(string); ok && filename != "" {
+		data.Metadata["filename"] = strings.TrimSuffix(filename, path.Ext(filename)) + media.Extension(contentType)
+	}
+	data.Metadata[KeyConvertedFrom] = source
+	data.Metadata[KeyEXIFKept] = exifKept
+	t.RecordError(nil)
+	return data, nil
+}
+
+// firstFrame draws the first frame of g onto its logical screen
+func firstFrame(g *gif.GIF) image.Image {
+	frame := g.Image[0]
+	w, h := g.Config.Width, g.Config.Height
+	if w == 0 || h == 0 {
+		return frame
+	}
+	canvas := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+	return canvas
+}
+
+// flatten composites img onto an opaque background
+func flatten(img image.Image, background color.Color) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Over)
+	return dst
+}
+
+// parseColor reads #rgb and #rrggbb colors; backgrounds are opaque
+func parseColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return nil, fmt.Errorf("invalid color %q", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
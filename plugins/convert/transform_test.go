@@ -0,0 +1,260 @@
+package convert
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+var (
+	blue        = color.RGBA{B: 255, A: 255}
+	transparent = color.NRGBA{R: 255, A: 0}
+)
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "convert", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+func solid(w, h int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// animated encodes a GIF with one frame per color
+func animated(t *testing.T, colors ...color.Color) []byte {
+	t.Helper()
+	g := &gif.GIF{}
+	for _, c := range colors {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{c})
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, g))
+	return buf.Bytes()
+}
+
+// tiff is a minimal little-endian TIFF header with an empty IFD
+var tiff = []byte("II*\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+
+// withEXIF adds an eXIf chunk to a PNG
+func withEXIF(t *testing.T, b []byte) []byte {
+	t.Helper()
+	out, ok := embedEXIF("png", b, tiff)
+	require.True(t, ok)
+	return out
+}
+
+func photo(content []byte, filename string) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "photo-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"filename": filename},
+		Content:  io.NopCloser(bytes.NewReader(content)),
+		Headers:  map[string]string{},
+	}
+}
+
+func read(t *testing.T, data *interfaces.DataStream) []byte {
+	t.Helper()
+	b, err := io.ReadAll(data.Content)
+	require.NoError(t, err)
+	return b
+}
+
+func TestTransform_OpaquePNGToJPEG(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{"accept": []interface{}{"jpeg"}})
+
+	out, err := tr.Transform(context.Background(), photo(withEXIF(t, encodePNG(t, solid(32, 16, blue))), "photo.png"))
+	require.NoError(t, err)
+	b := read(t, out)
+
+	img, format, err := image.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, image.Rect(0, 0, 32, 16), img.Bounds())
+	assert.Equal(t, "image/jpeg", out.Headers["Content-Type"])
+	assert.Equal(t, "photo.jpg", out.Metadata["filename"])
+	assert.Equal(t, "png", out.Metadata[KeyConvertedFrom])
+	assert.Equal(t, true, out.Metadata[KeyEXIFKept])
+
+	blocks, err := imagemeta.Scan(b)
+	require.NoError(t, err)
+	assert.Equal(t, tiff, blocks.EXIF)
+}
+
+// orientedTIFF is an EXIF block rotating the picture 90° clockwise, as
+// cameras record portrait shots, for a w x h sensor image
+func orientedTIFF(w, h uint32) []byte {
+	le := binary.LittleEndian
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	entry := func(id, typ uint16, v uint32) {
+		b = le.AppendUint16(b, id)
+		b = le.AppendUint16(b, typ)
+		b = le.AppendUint32(b, 1)
+		b = le.AppendUint32(b, v)
+	}
+	b = le.AppendUint16(b, 2)
+	entry(imagemeta.TagOrientation, imagemeta.TypeShort, 6)
+	entry(imagemeta.TagExifIFD, imagemeta.TypeLong, 38)
+	b = le.AppendUint32(b, 0)
+	b = le.AppendUint16(b, 2)
+	entry(imagemeta.TagPixelXDimension, imagemeta.TypeLong, w)
+	entry(imagemeta.TagPixelYDimension, imagemeta.TypeLong, h)
+	return le.AppendUint32(b, 0)
+}
+
+func TestTransform_AppliesOrientation(t *testing.T) {
+	source, ok := embedEXIF("png", encodePNG(t, solid(32, 16, blue)), orientedTIFF(32, 16))
+	require.True(t, ok)
+
+	for _, keep := range []bool{true, false} {
+		tr := newTransform(t, map[string]interface{}{"accept": []interface{}{"jpeg"}, "keep_exif": keep})
+		out, err := tr.Transform(context.Background(), photo(source, "photo.png"))
+		require.NoError(t, err)
+		b := read(t, out)
+
+		img, _, err := image.Decode(bytes.NewReader(b))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 16, 32), img.Bounds(), "the pixels are upright with keep_exif %v", keep)
+		assert.Equal(t, keep, out.Metadata[KeyEXIFKept])
+		if !keep {
+			continue
+		}
+
+		blocks, err := imagemeta.Scan(b)
+		require.NoError(t, err)
+		e, err := imagemeta.ParseEXIF(blocks.EXIF)
+		require.NoError(t, err)
+		assert.Equal(t, 1, e.Orientation(), "the kept EXIF no longer rotates the picture")
+		for id, want := range map[uint16]uint32{imagemeta.TagPixelXDimension: 16, imagemeta.TagPixelYDimension: 32} {
+			tag, ok := e.Lookup(id)
+			require.True(t, ok)
+			v, _ := tag.Uint(0)
+			assert.Equal(t, want, v)
+		}
+	}
+}
+
+func TestTransform_AcceptedPassesThrough(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{})
+	content := encodePNG(t, solid(8, 8, transparent))
+
+	out, err := tr.Transform(context.Background(), photo(content, "photo.png"))
+	require.NoError(t, err)
+	assert.Equal(t, content, read(t, out))
+	assert.NotContains(t, out.Metadata, KeyConvertedFrom)
+}
+
+func TestTransform_Transparency(t *testing.T) {
+	keep := newTransform(t, map[string]interface{}{})
+	out, err := keep.Transform(context.Background(), photo(animated(t, transparent), "photo.gif"))
+	require.NoError(t, err)
+	img, format, err := image.Decode(bytes.NewReader(read(t, out)))
+	require.NoError(t, err)
+	assert.Equal(t, "png", format, "transparent images stay PNG")
+	_, _, _, a := img.At(0, 0).RGBA()
+	assert.Zero(t, a)
+
+	flat := newTransform(t, map[string]interface{}{
+		"accept": []interface{}{"png"}, "target": "png", "flatten": true, "background": "#00ff00",
+	})
+	out, err = flat.Transform(context.Background(), photo(animated(t, transparent), "photo.gif"))
+	require.NoError(t, err)
+	img, format, err = image.Decode(bytes.NewReader(read(t, out)))
+	require.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, color.RGBA{G: 255, A: 255}, color.RGBAModel.Convert(img.At(4, 4)), "flattened onto the background")
+	assert.Equal(t, false, out.Metadata[KeyEXIFKept])
+}
+
+func TestTransform_AnimatedGIF(t *testing.T) {
+	content := animated(t, color.RGBA{R: 255, A: 255}, blue)
+
+	passthrough := newTransform(t, map[string]interface{}{})
+	out, err := passthrough.Transform(context.Background(), photo(content, "anim.gif"))
+	require.NoError(t, err)
+	assert.Equal(t, content, read(t, out))
+
+	first := newTransform(t, map[string]interface{}{"animation": "first_frame", "target": "png"})
+	out, err = first.Transform(context.Background(), photo(content, "anim.gif"))
+	require.NoError(t, err)
+	img, format, err := image.Decode(bytes.NewReader(read(t, out)))
+	require.NoError(t, err)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, color.RGBAModel.Convert(img.At(0, 0)))
+	assert.Equal(t, "anim.png", out.Metadata["filename"])
+}
+
+func TestTransform_Unsupported(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{})
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+
+	out, err := tr.Transform(context.Background(), photo(heic, "photo.heic"))
+	require.NoError(t, err)
+	assert.Equal(t, heic, read(t, out))
+
+	video := &interfaces.DataStream{ID: "v", Type: interfaces.MediaTypeVideo, Content: io.NopCloser(bytes.NewReader(heic))}
+	out, err = tr.Transform(context.Background(), video)
+	require.NoError(t, err)
+	assert.Same(t, video, out)
+}
+
+func TestEmbedEXIF_TooLargeForJPEG(t *testing.T) {
+	jpegSOI := []byte{0xFF, 0xD8, 0xFF, 0xD9}
+	exif := make([]byte, maxAPP1Payload)
+	out, ok := embedEXIF("jpeg", jpegSOI, exif)
+	assert.False(t, ok)
+	assert.Equal(t, jpegSOI, out)
+
+	out, ok = embedEXIF("jpeg", jpegSOI, tiff)
+	require.True(t, ok)
+	assert.Equal(t, uint16(len(tiff)+len(imagemeta.JPEGEXIFHeader)+2), binary.BigEndian.Uint16(out[4:6]))
+}
+
+func TestConfigure_Invalid(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "convert", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+
+	for _, settings := range []map[string]interface{}{
+		{"accept": []interface{}{"heic"}},
+		{"accept": []interface{}{"gif"}},
+		{"accept": []interface{}{"jpeg"}, "target": "png"},
+		{"target": "webp"},
+		{"quality": 0},
+		{"background": "white"},
+		{"animation": "loop"},
+		{"max_pixels": 0},
+	} {
+		assert.ErrorIs(t, tr.Configure(settings), plugins.ErrInvalidConfig, settings)
+	}
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "video"}))
+}
@@ -0,0 +1,555 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expr is a compiled filter expression. Expressions combine comparisons of
+// fields with && (and), || (or) and ! (not):
+//
+//	type == "photo" && (width < 400 || height < 400)
+//	tags contains "nsfw" || reblog
+//	size > 20MB && age < 7d
+//	author in ["alice", "bob"] && metadata.title matches "(?i)draft"
+//
+// Numbers accept size suffixes (KB, MB, GB, in bytes) and duration
+// suffixes (s, m, h, d, w, in seconds). Ordered comparisons with a missing
+// value are false; a bare field is true when set and not false, zero or empty.
+type Expr struct {
+	src    string
+	root   node
+	fields []string
+}
+
+// Lookup resolves a field name to its value, or nil when it is missing
+type Lookup func(field string) interface{}
+
+// Compile parses an expression
+func Compile(src string) (*Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, fields: make(map[string]bool)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+
+	fields := make([]string, 0, len(p.fields))
+	for f := range p.fields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return &Expr{src: src, root: root, fields: fields}, nil
+}
+
+// String returns the expression source
+func (e *Expr) String() string { return e.src }
+
+// Fields returns the field names the expression reads, sorted
+func (e *Expr) Fields() []string { return e.fields }
+
+// Match evaluates the expression against the fields returned by lookup
+func (e *Expr) Match(lookup Lookup) bool {
+	return truthy(e.root.eval(lookup))
+}
+
+// Tokens
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+// units maps number suffixes to multipliers: sizes in bytes, durations in seconds
+var units = map[string]float64{
+	"b": 1, "kb": 1 << 10, "mb": 1 << 20, "gb": 1 << 30,
+	"s": 1, "m": 60, "h": 3600, "d": 86400, "w": 7 * 86400,
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: i})
+			i = j + 1
+
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", src[i:j], i)
+			}
+			k := j
+			for k < len(src) && unicode.IsLetter(rune(src[k])) {
+				k++
+			}
+			if k > j {
+				unit, ok := units[strings.ToLower(src[j:k])]
+				if !ok {
+					return nil, fmt.Errorf("unknown unit %q at offset %d", src[j:k], j)
+				}
+				n *= unit
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[i:k], num: n, pos: i})
+			i = k
+
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] == '.' || src[j] == '-' ||
+				unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	fields map[string]bool
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token when it is one of the given operators or keywords
+func (p *parser) accept(texts ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp && tok.kind != tokIdent {
+		return "", false
+	}
+	for _, text := range texts {
+		if tok.text == text {
+			p.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		tok := p.peek()
+		return fmt.Errorf("expected %q but found %q at offset %d", text, tok.text, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if _, ok := p.accept("!", "not"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "in", "contains", "matches")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	cmp := compareNode{op: op, left: left, right: right}
+	if op == "matches" {
+		lit, ok := right.(literalNode)
+		pattern, isString := lit.value.(string)
+		if !ok || !isString {
+			return nil, fmt.Errorf("matches requires a string pattern")
+		}
+		if cmp.re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return cmp, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return literalNode{tok.text}, nil
+	case tokNumber:
+		return literalNode{tok.num}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literalNode{true}, nil
+		case "false":
+			return literalNode{false}, nil
+		case "null":
+			return literalNode{nil}, nil
+		}
+		p.fields[tok.text] = true
+		return fieldNode{tok.text}, nil
+	case tokOp:
+		switch tok.text {
+		case "(":
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			var items []node
+			if _, ok := p.accept("]"); ok {
+				return listNode{items}, nil
+			}
+			for {
+				item, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+				if _, ok := p.accept(","); !ok {
+					return listNode{items}, p.expect("]")
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+// Evaluation
+
+type node interface {
+	eval(lookup Lookup) interface{}
+}
+
+type (
+	orNode      struct{ left, right node }
+	andNode     struct{ left, right node }
+	notNode     struct{ operand node }
+	literalNode struct{ value interface{} }
+	fieldNode   struct{ name string }
+	listNode    struct{ items []node }
+	compareNode struct {
+		op          string
+		left, right node
+		re          *regexp.Regexp
+	}
+)
+
+func (n orNode) eval(l Lookup) interface{} {
+	return truthy(n.left.eval(l)) || truthy(n.right.eval(l))
+}
+
+func (n andNode) eval(l Lookup) interface{} {
+	return truthy(n.left.eval(l)) && truthy(n.right.eval(l))
+}
+
+func (n notNode) eval(l Lookup) interface{} { return !truthy(n.operand.eval(l)) }
+
+func (n literalNode) eval(Lookup) interface{} { return n.value }
+
+func (n fieldNode) eval(l Lookup) interface{} { return normalize(l(n.name)) }
+
+func (n listNode) eval(l Lookup) interface{} {
+	items := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		items[i] = item.eval(l)
+	}
+	return items
+}
+
+func (n compareNode) eval(l Lookup) interface{} {
+	left, right := n.left.eval(l), n.right.eval(l)
+	switch n.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	case "in":
+		return contains(right, left)
+	case "contains":
+		return contains(left, right)
+	case "matches":
+		return matches(n.re, left)
+	}
+
+	a, aok := number(left)
+	b, bok := number(right)
+	if aok && bok {
+		return order(n.op, compareFloat(a, b))
+	}
+	as, aok := left.(string)
+	bs, bok := right.(string)
+	if aok && bok {
+		return order(n.op, strings.Compare(as, bs))
+	}
+	return false
+}
+
+func order(op string, c int) bool {
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// equal compares values, converting numeric strings when the other side is a number
+func equal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	_, aNum := a.(float64)
+	_, bNum := b.(float64)
+	if aNum || bNum {
+		x, xok := number(a)
+		y, yok := number(b)
+		return xok && yok && x == y
+	}
+	if as, ok := a.(string); ok {
+		bs, ok := b.(string)
+		return ok && as == bs
+	}
+	if ab, ok := a.(bool); ok {
+		bb, ok := b.(bool)
+		return ok && ab == bb
+	}
+	return false
+}
+
+// contains reports whether list has an element equal to v, or for strings
+// whether v is a substring; a list v matches when any element does
+func contains(list, v interface{}) bool {
+	if vs, ok := v.([]interface{}); ok {
+		for _, item := range vs {
+			if contains(list, item) {
+				return true
+			}
+		}
+		return false
+	}
+	switch l := list.(type) {
+	case []interface{}:
+		for _, item := range l {
+			if equal(item, v) {
+				return true
+			}
+		}
+	case string:
+		s, ok := v.(string)
+		return ok && strings.Contains(l, s)
+	}
+	return false
+}
+
+// matches reports whether v, or any element of a list v, matches re
+func matches(re *regexp.Regexp, v interface{}) bool {
+	switch val := v.(type) {
+	case string:
+		return re.MatchString(val)
+	case []interface{}:
+		for _, item := range val {
+			if s, ok := item.(string); ok && re.MatchString(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil && !math.IsNaN(f)
+	}
+	return 0, false
+}
+
+func truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case float64:
+		return val != 0
+	case string:
+		return val != "" && val != "false" && val != "0"
+	case []interface{}:
+		return len(val) > 0
+	}
+	return true
+}
+
+// normalize converts field values to the expression types: float64, string,
+// bool, []interface{} or nil
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, float64, string, bool:
+		return val
+	case int:
+		return float64(val)
+	case int32:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case uint:
+		return float64(val)
+	case uint32:
+		return float64(val)
+	case uint64:
+		return float64(val)
+	case float32:
+		return float64(val)
+	case json.Number:
+		f, err := val.Float64()
+		if err != nil {
+			return val.String()
+		}
+		return f
+	case time.Time:
+		return val.UTC().Format(time.RFC3339)
+	case fmt.Stringer:
+		return val.String()
+	case []string:
+		items := make([]interface{}, len(val))
+		for i, s := range val {
+			items[i] = s
+		}
+		return items
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, item := range val {
+			items[i] = normalize(item)
+		}
+		return items
+	}
+	return fmt.Sprint(v)
+}
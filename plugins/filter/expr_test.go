@@ -0,0 +1,86 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpr_Match(t *testing.T) {
+	values := map[string]interface{}{
+		"type":   "photo",
+		"width":  320,
+		"height": "1080",
+		"size":   "25165824",
+		"tags":   []string{"cats", "NSFW"},
+		"author": "alice",
+		"age":    float64(3 * 86400),
+		"reblog": true,
+		"title":  "Draft: holiday",
+		"empty":  "",
+	}
+	lookup := func(field string) interface{} { return values[field] }
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`type == "photo"`, true},
+		{`type != 'photo'`, false},
+		{`width < 400 || height < 400`, true},
+		{`width >= 320 and height > 1000`, true},
+		{`size > 20MB && size < 1GB`, true},
+		{`age < 7d`, true},
+		{`age > 48h`, true},
+		{`tags contains "NSFW"`, true},
+		{`"cats" in tags`, true},
+		{`tags in ["dogs", "cats"]`, true},
+		{`author in ["bob", "carol"]`, false},
+		{`title matches "(?i)^draft"`, true},
+		{`tags matches "^ns"`, false},
+		{`title contains "holiday"`, true},
+		{`reblog`, true},
+		{`!reblog`, false},
+		{`not (reblog && width < 100)`, true},
+		{`empty`, false},
+		{`missing`, false},
+		{`missing == null`, true},
+		{`missing != null`, false},
+		{`missing < 10`, false},
+		{`missing > 10`, false},
+		{`author == null`, false},
+		{`width == 320.0`, true},
+		{`height == 1080`, true},
+	}
+	for _, tt := range tests {
+		e, err := Compile(tt.expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, e.Match(lookup), tt.expr)
+	}
+}
+
+func TestExpr_Fields(t *testing.T) {
+	e, err := Compile(`width < 400 || (tags contains "x" && width > 0)`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tags", "width"}, e.Fields())
+	assert.Equal(t, `width < 400 || (tags contains "x" && width > 0)`, e.String())
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`type ==`,
+		`type == "photo`,
+		`(width < 10`,
+		`size > 10XB`,
+		`title matches "("`,
+		`title matches width`,
+		`width < 10 height`,
+		`width # 3`,
+		`[1, 2`,
+	} {
+		_, err := Compile(src)
+		assert.Error(t, err, src)
+	}
+}
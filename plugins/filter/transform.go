@@ -0,0 +1,342 @@
+// Package filter provides a transform that includes, excludes or routes
+// items according to configured expressions, recording why each decision
+// was made.
+package filter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform
+const (
+	MetaAction = "filter_action"
+	MetaRule   = "filter_rule"
+	MetaDryRun = "filter_dry_run"
+	MetaRoute  = interfaces.MetaRoute
+)
+
+// Action is what happens to an item matching a rule
+type Action string
+
+const (
+	ActionInclude Action = "include"
+	ActionExclude Action = "exclude"
+	ActionRoute   Action = "route"
+)
+
+// Decision records how one item was filtered. Values holds the fields the
+// deciding expression read, so a dry run can explain an exclusion.
+type Decision struct {
+	ItemID string                 `json:"item_id"`
+	Action Action                 `json:"action"`
+	Rule   string                 `json:"rule,omitempty"`
+	When   string                 `json:"when,omitempty"`
+	Route  string                 `json:"route,omitempty"`
+	Values map[string]interface{} `json:"values,omitempty"`
+	DryRun bool                   `json:"dry_run"`
+	At     time.Time              `json:"at"`
+}
+
+// Reason describes the decision for logs and dry-run reports
+func (d Decision) Reason() string {
+	if d.Rule == "" {
+		return fmt.Sprintf("%s: no rule matched, default %s", d.ItemID, d.Action)
+	}
+	reason := fmt.Sprintf("%s: %s by rule %q (%s)", d.ItemID, d.Action, d.Rule, d.When)
+	if d.Route != "" {
+		reason += " to " + d.Route
+	}
+	return reason
+}
+
+type rule struct {
+	name   string
+	when   *Expr
+	action Action
+	route  string
+}
+
+// Transform evaluates rules in order against each item; the first matching
+// rule decides. Excluded items are dropped with interfaces.ErrStreamDropped
+// unless dry_run is set, in which case every item passes through with its
+// decision recorded in Metadata.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu            sync.RWMutex
+	rules         []rule
+	defaultAction Action
+	dryRun        bool
+	historyMax    int
+	history       []Decision
+
+	now func() time.Time
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a filter transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin:    plugins.NewBasePlugin(config, "transform", "Includes, excludes or routes items by rule expressions"),
+		defaultAction: ActionInclude,
+		historyMax:    100,
+		now:           time.Now,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	ruleSettings, err := settings.MapSlice("rules")
+	if err != nil {
+		return err
+	}
+	rules := make([]rule, 0, len(ruleSettings))
+	for i, rs := range ruleSettings {
+		r, err := parseRule(rs, i)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+	}
+
+	defaultAction := Action(settings.String("default", string(ActionInclude)))
+	if defaultAction != ActionInclude && defaultAction != ActionExclude {
+		return fmt.Errorf("%w: default must be include or exclude, got %q", plugins.ErrInvalidConfig, defaultAction)
+	}
+	dryRun, err := settings.Bool("dry_run", false)
+	if err != nil {
+		return err
+	}
+	historyMax, err := settings.Int("history_size", 100)
+	if err != nil {
+		return err
+	}
+	if historyMax < 0 {
+		return fmt.Errorf("%w: history_size cannot be negative", plugins.ErrInvalidConfig)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules = rules
+	t.defaultAction = defaultAction
+	t.dryRun = dryRun
+	t.historyMax = historyMax
+	return nil
+}
+
+func parseRule(s plugins.Settings, index int) (rule, error) {
+	r := rule{
+		name:   s.String("name", "rule-"+strconv.Itoa(index+1)),
+		action: Action(s.String("action", string(ActionExclude))),
+		route:  s.String("route", ""),
+	}
+	src := s.String("when", "")
+	if src == "" {
+		return r, fmt.Errorf("%w: rule %q needs a when expression", plugins.ErrInvalidConfig, r.name)
+	}
+	when, err := Compile(src)
+	if err != nil {
+		return r, fmt.Errorf("%w: rule %q: %v", plugins.ErrInvalidConfig, r.name, err)
+	}
+	r.when = when
+
+	switch r.action {
+	case ActionInclude, ActionExclude:
+		if r.route != "" {
+			return r, fmt.Errorf("%w: rule %q sets a route but its action is %s", plugins.ErrInvalidConfig, r.name, r.action)
+		}
+	case ActionRoute:
+		if r.route == "" {
+			return r, fmt.Errorf("%w: rule %q needs a route", plugins.ErrInvalidConfig, r.name)
+		}
+	default:
+		return r, fmt.Errorf("%w: rule %q has unknown action %q", plugins.ErrInvalidConfig, r.name, r.action)
+	}
+	return r, nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"actions": []string{string(ActionInclude), string(ActionExclude), string(ActionRoute)},
+			"fields":  []string{"id", "type", "source", "size", "content_type", "filename", "tags", "width", "height", "author", "age", "metadata.<key>", "headers.<name>"},
+		}},
+	}
+}
+
+// ValidateSchema accepts any stream type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	return nil
+}
+
+// Transform decides what happens to data. Routed items carry the route name
+// in Metadata["route"], and the pipeline publishes them only to the outputs
+// it lists for that route.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+
+	t.mu.RLock()
+	rules, defaultAction, dryRun := t.rules, t.defaultAction, t.dryRun
+	t.mu.RUnlock()
+
+	now := t.now()
+	lookup := fields(data, now)
+	decision := Decision{ItemID: data.ID, Action: defaultAction, DryRun: dryRun, At: now}
+	for _, r := range rules {
+		if !r.when.Match(lookup) {
+			continue
+		}
+		decision.Action, decision.Rule, decision.When, decision.Route = r.action, r.name, r.when.String(), r.route
+		decision.Values = make(map[string]interface{}, len(r.when.Fields()))
+		for _, f := range r.when.Fields() {
+			decision.Values[f] = normalize(lookup(f))
+		}
+		break
+	}
+	t.record(decision)
+
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	data.Metadata[MetaAction] = string(decision.Action)
+	if decision.Rule != "" {
+		data.Metadata[MetaRule] = decision.Rule
+	}
+	if dryRun {
+		data.Metadata[MetaDryRun] = true
+		return data, nil
+	}
+
+	switch decision.Action {
+	case ActionExclude:
+		if data.Content != nil {
+			_ = data.Content.Close()
+		}
+		return nil, fmt.Errorf("%w: %s", interfaces.ErrStreamDropped, decision.Reason())
+	case ActionRoute:
+		data.Metadata[MetaRoute] = decision.Route
+	}
+	return data, nil
+}
+
+func (t *Transform) record(d Decision) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.history = append(t.history, d)
+	if over := len(t.history) - t.historyMax; over > 0 {
+		t.history = append([]Decision(nil), t.history[over:]...)
+	}
+}
+
+// Decisions returns the most recent decisions, oldest first
+func (t *Transform) Decisions() []Decision {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]Decision(nil), t.history...)
+}
+
+// fields resolves expression fields for data. Names other than the
+// built-in ones read top-level metadata, so "reblog" reads Metadata["reblog"].
+func fields(data *interfaces.DataStream, now time.Time) Lookup {
+	return func(name string) interface{} {
+		switch {
+		case strings.HasPrefix(name, "metadata."):
+			return metadataPath(data.Metadata, strings.TrimPrefix(name, "metadata."))
+		case strings.HasPrefix(name, "headers."):
+			return header(data.Headers, strings.TrimPrefix(name, "headers."))
+		}
+
+		switch name {
+		case "id":
+			return data.ID
+		case "type":
+			return string(data.Type)
+		case "source":
+			return data.Context.Source
+		case "content_type":
+			return header(data.Headers, "Content-Type")
+		case "size":
+			if v := header(data.Headers, "Content-Length"); v != nil {
+				return v
+			}
+			return data.Metadata["size_bytes"]
+		case "tags":
+			return firstOf(data.Metadata, interfaces.MetaTags, "keywords")
+		case "author":
+			return firstOf(data.Metadata, interfaces.MetaAuthor, "creator")
+		case "age":
+			created := createdAt(data)
+			if created.IsZero() {
+				return nil
+			}
+			return now.Sub(created).Seconds()
+		}
+		return data.Metadata[name]
+	}
+}
+
+func firstOf(metadata map[string]interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		if v, ok := metadata[key]; ok && v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// metadataPath follows a dotted path through nested metadata maps
+func metadataPath(metadata map[string]interface{}, path string) interface{} {
+	var v interface{} = metadata
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	return v
+}
+
+// header looks up a header case-insensitively
+func header(headers map[string]string, name string) interface{} {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// createdAt returns when the item was captured or published, falling back
+// to when the stream was created
+func createdAt(data *interfaces.DataStream) time.Time {
+	for _, key := range []string{interfaces.MetaCapturedAt, interfaces.MetaPublishedAt, "created_at"} {
+		switch v := data.Metadata[key].(type) {
+		case time.Time:
+			return v
+		case string:
+			if ts, err := time.Parse(time.RFC3339, v); err == nil {
+				return ts
+			}
+		}
+	}
+	return data.Context.CreatedAt
+}
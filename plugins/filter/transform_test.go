@@ -0,0 +1,156 @@
+package filter
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+var testNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "filter", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	tr.now = func() time.Time { return testNow }
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func item(id string, metadata map[string]interface{}) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       id,
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: metadata,
+		Content:  &closeTracker{Reader: strings.NewReader("image")},
+		Headers:  map[string]string{"Content-Type": "image/jpeg", "Content-Length": "2048"},
+		Context:  interfaces.StreamContext{Source: "tumblr", CreatedAt: testNow.Add(-time.Hour)},
+	}
+}
+
+var testRules = map[string]interface{}{
+	"rules": []interface{}{
+		map[string]interface{}{"name": "skip-reblogs", "when": "reblog"},
+		map[string]interface{}{"name": "tiny", "when": `width < 200 || height < 200`},
+		map[string]interface{}{"name": "old", "when": `age > 30d`, "action": "route", "route": "archive"},
+		map[string]interface{}{"name": "keep-small", "when": `size < 1KB`, "action": "include"},
+	},
+}
+
+func TestTransform_Decisions(t *testing.T) {
+	ctx := context.Background()
+	tr := newTransform(t, testRules)
+
+	reblog := item("reblog-1", map[string]interface{}{"reblog": true, "width": 800, "height": 600})
+	out, err := tr.Transform(ctx, reblog)
+	assert.ErrorIs(t, err, interfaces.ErrStreamDropped)
+	assert.Contains(t, err.Error(), `rule "skip-reblogs"`)
+	assert.Nil(t, out)
+	assert.True(t, reblog.Content.(*closeTracker).closed)
+
+	_, err = tr.Transform(ctx, item("tiny-1", map[string]interface{}{"width": 120, "height": 600}))
+	assert.ErrorIs(t, err, interfaces.ErrStreamDropped)
+
+	old := item("old-1", map[string]interface{}{"width": 800, "height": 600, "captured_at": "2020-01-01T00:00:00Z"})
+	out, err = tr.Transform(ctx, old)
+	require.NoError(t, err)
+	assert.Equal(t, "archive", out.Metadata[MetaRoute])
+	assert.Equal(t, "route", out.Metadata[MetaAction])
+	assert.Equal(t, "old", out.Metadata[MetaRule])
+
+	kept := item("kept-1", map[string]interface{}{"width": 800, "height": 600})
+	out, err = tr.Transform(ctx, kept)
+	require.NoError(t, err)
+	assert.Equal(t, "include", out.Metadata[MetaAction])
+	assert.NotContains(t, out.Metadata, MetaRule)
+	assert.False(t, kept.Content.(*closeTracker).closed)
+
+	decisions := tr.Decisions()
+	require.Len(t, decisions, 4)
+	assert.Equal(t, "tiny", decisions[1].Rule)
+	assert.Equal(t, map[string]interface{}{"width": float64(120), "height": float64(600)}, decisions[1].Values)
+	assert.Equal(t, testNow, decisions[1].At)
+	assert.Equal(t, `tiny-1: exclude by rule "tiny" (width < 200 || height < 200)`, decisions[1].Reason())
+	assert.Equal(t, "kept-1: no rule matched, default include", decisions[3].Reason())
+}
+
+func TestTransform_DryRunKeepsItems(t *testing.T) {
+	settings := map[string]interface{}{"dry_run": true, "default": "exclude", "history_size": 1}
+	for k, v := range testRules {
+		settings[k] = v
+	}
+	tr := newTransform(t, settings)
+
+	out, err := tr.Transform(context.Background(), item("reblog-1", map[string]interface{}{"reblog": true}))
+	require.NoError(t, err)
+	assert.Equal(t, "exclude", out.Metadata[MetaAction])
+	assert.Equal(t, true, out.Metadata[MetaDryRun])
+
+	out, err = tr.Transform(context.Background(), item("other-1", map[string]interface{}{"width": 800, "height": 600}))
+	require.NoError(t, err)
+	assert.Equal(t, "exclude", out.Metadata[MetaAction])
+
+	decisions := tr.Decisions()
+	require.Len(t, decisions, 1)
+	assert.True(t, decisions[0].DryRun)
+	assert.Equal(t, "other-1: no rule matched, default exclude", decisions[0].Reason())
+}
+
+func TestTransform_Fields(t *testing.T) {
+	data := item("id-1", map[string]interface{}{
+		"keywords": []string{"paris"},
+		"creator":  "alice",
+		"exif":     map[string]interface{}{"make": "Canon"},
+	})
+	lookup := fields(data, testNow)
+
+	assert.Equal(t, "photo", lookup("type"))
+	assert.Equal(t, "tumblr", lookup("source"))
+	assert.Equal(t, "2048", lookup("size"))
+	assert.Equal(t, "image/jpeg", lookup("content_type"))
+	assert.Equal(t, "image/jpeg", lookup("headers.content-type"))
+	assert.Equal(t, []string{"paris"}, lookup("tags"))
+	assert.Equal(t, "alice", lookup("author"))
+	assert.Equal(t, float64(3600), lookup("age"))
+	assert.Equal(t, "Canon", lookup("metadata.exif.make"))
+	assert.Nil(t, lookup("metadata.exif.model.name"))
+	assert.Nil(t, lookup("width"))
+}
+
+func TestTransform_ConfigureErrors(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "filter", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+
+	for _, settings := range []map[string]interface{}{
+		{"rules": []interface{}{map[string]interface{}{"name": "a"}}},
+		{"rules": []interface{}{map[string]interface{}{"when": "width <"}}},
+		{"rules": []interface{}{map[string]interface{}{"when": "reblog", "action": "route"}}},
+		{"rules": []interface{}{map[string]interface{}{"when": "reblog", "action": "drop"}}},
+		{"rules": []interface{}{map[string]interface{}{"when": "reblog", "route": "x"}}},
+		{"rules": "reblog"},
+		{"default": "route"},
+		{"history_size": -1},
+	} {
+		assert.ErrorIs(t, tr.Configure(settings), plugins.ErrInvalidConfig, settings)
+	}
+}
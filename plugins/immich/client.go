@@ -0,0 +1,230 @@
+package immich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Upload status values returned by the asset upload endpoint
+const (
+	StatusCreated   = "created"
+	StatusDuplicate = "duplicate"
+)
+
+// Asset describes the file and identifiers sent with an upload
+type Asset struct {
+	DeviceAssetID  string
+	DeviceID       string
+	Filename       string
+	ContentType    string
+	FileCreatedAt  time.Time
+	FileModifiedAt time.Time
+	IsFavorite     bool
+	Content        io.Reader
+}
+
+// UploadResult is the server response to an asset upload
+type UploadResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// Album is the subset of album fields used by the plugin
+type Album struct {
+	ID        string `json:"id"`
+	AlbumName string `json:"albumName"`
+}
+
+// Client talks to an Immich-compatible REST API using API-key authentication
+type Client struct {
+	baseURL    *url.URL
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the server at baseURL
+func NewClient(baseURL, apiKey string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server url: unsupported scheme %q", u.Scheme)
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("api key is required")
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 5 * time.Minute}
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &Client{baseURL: u, apiKey: apiKey, httpClient: httpClient}, nil
+}
+
+// Ping checks connectivity and the API key
+func (c *Client) Ping(ctx context.Context) error {
+	return c.doJSON(ctx, http.MethodGet, "/api/users/me", nil, nil)
+}
+
+// UploadAsset streams an asset as multipart form data
+func (c *Client) UploadAsset(ctx context.Context, asset Asset) (*UploadResult, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeAssetForm(mw, asset))
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/assets", pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		pr.Close()
+		return nil, fmt.Errorf("upload %s: %w", asset.DeviceAssetID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, statusError("upload "+asset.DeviceAssetID, resp)
+	}
+
+	var result UploadResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("upload %s: failed to decode response: %w", asset.DeviceAssetID, err)
+	}
+	if result.ID == "" {
+		return nil, fmt.Errorf("upload %s: response has no asset id", asset.DeviceAssetID)
+	}
+	return &result, nil
+}
+
+// ListAlbums returns all albums visible to the API key
+func (c *Client) ListAlbums(ctx context.Context) ([]Album, error) {
+	var albums []Album
+	if err := c.doJSON(ctx, http.MethodGet, "/api/albums", nil, &albums); err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+// CreateAlbum creates an album containing the given assets
+func (c *Client) CreateAlbum(ctx context.Context, name string, assetIDs []string) (*Album, error) {
+	body := map[string]interface{}{"albumName": name, "assetIds": assetIDs}
+	var album Album
+	if err := c.doJSON(ctx, http.MethodPost, "/api/albums", body, &album); err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// AddAssetsToAlbum adds assets to an existing album; assets already present are ignored by the server
+func (c *Client) AddAssetsToAlbum(ctx context.Context, albumID string, assetIDs []string) error {
+	body := map[string]interface{}{"ids": assetIDs}
+	return c.doJSON(ctx, http.MethodPut, "/api/albums/"+url.PathEscape(albumID)+"/assets", body, nil)
+}
+
+func (c *Client) doJSON(ctx context.Context, method, p string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(ctx, method, p, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, p, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(method+" "+p, resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: failed to decode response: %w", method, p, err)
+	}
+	return nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, p string, body io.Reader) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path = c.baseURL.Path + p
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+func writeAssetForm(mw *multipart.Writer, asset Asset) error {
+	fields := []struct{ name, value string }{
+		{"deviceAssetId", asset.DeviceAssetID},
+		{"deviceId", asset.DeviceID},
+		{"fileCreatedAt", asset.FileCreatedAt.UTC().Format(time.RFC3339)},
+		{"fileModifiedAt", asset.FileModifiedAt.UTC().Format(time.RFC3339)},
+		{"isFavorite", fmt.Sprint(asset.IsFavorite)},
+	}
+	for _, f := range fields {
+		if err := mw.WriteField(f.name, f.value); err != nil {
+			return err
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="assetData"; filename=%q`, asset.Filename))
+	contentType := asset.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, asset.Content); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+func statusError(op string, resp *http.Response) error {
+	var apiErr struct {
+		Message interface{} `json:"message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != nil {
+		return fmt.Errorf("%s: unexpected status %s: %v", op, resp.Status, apiErr.Message)
+	}
+	return fmt.Errorf("%s: unexpected status %s", op, resp.Status)
+}
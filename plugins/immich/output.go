@@ -0,0 +1,323 @@
+package immich
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys read and written by the output plugin. MetaAssetIDs maps
+// each server URL to the asset uploaded there and decides whether an upload
+// is skipped; MetaAssetID holds the asset of the most recent upload.
+const (
+	MetaAssetID      = "immich_asset_id"
+	MetaAssetIDs     = "immich_asset_ids"
+	MetaUploadStatus = "immich_status"
+	MetaAlbums       = "albums"
+	MetaAlbum        = "album"
+	MetaFavorite     = "favorite"
+	MetaCapturedAt   = "captured_at"
+)
+
+// Output uploads photos and videos to a self-hosted photo library exposing an
+// Immich-style asset API. The media item ID is sent as the device asset ID so
+// repeated uploads of the same item are recognised by the server.
+type Output struct {
+	*plugins.BasePlugin
+
+	mu            sync.Mutex
+	client        *Client
+	deviceID      string
+	defaultAlbums []string
+	albumIDs      map[string]string
+	// albumLocks make concurrent uploads to a new album create it once;
+	// mu is never held across network calls
+	albumLocks map[string]*sync.Mutex
+}
+
+// Ensure Output implements the required interfaces
+var (
+	_ plugins.Plugin           = (*Output)(nil)
+	_ interfaces.OutputService = (*Output)(nil)
+)
+
+// NewOutput creates a photo library output plugin; it matches PluginFactoryFunc
+func NewOutput(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Output{
+		BasePlugin: plugins.NewBasePlugin(config, "output", "Uploads media to an Immich-compatible photo library"),
+		deviceID:   "media-sync",
+		albumIDs:   make(map[string]string),
+		albumLocks: make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// Configure applies plugin settings
+func (o *Output) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	httpClient, err := o.HTTPClient(settings, httpclient.ClientOptions{DefaultTimeout: 5 * time.Minute})
+	if err != nil {
+		return err
+	}
+	albums, err := settings.StringSlice("albums")
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(settings.String("url", ""), settings.String("api_key", ""), httpClient)
+	if err != nil {
+		return fmt.Errorf("%w: %v", plugins.ErrInvalidConfig, err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.client = client
+	o.deviceID = settings.String("device_id", "media-sync")
+	o.defaultAlbums = albums
+	o.albumIDs = make(map[string]string)
+	return nil
+}
+
+// Start verifies the server accepts the API key
+func (o *Output) Start(ctx context.Context) error {
+	client := o.getClient()
+	if client == nil {
+		return fmt.Errorf("photo library output %s is not configured", o.GetMetadata().Name)
+	}
+	if err := client.Ping(ctx); err != nil {
+		return fmt.Errorf("photo library unreachable: %w", err)
+	}
+	return o.BasePlugin.Start(ctx)
+}
+
+// Capabilities describes the output features
+func (o *Output) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "output", Supported: true, Config: map[string]interface{}{
+			"api":         "immich",
+			"media_types": []string{string(interfaces.MediaTypePhoto), string(interfaces.MediaTypeVideo)},
+		}},
+		{Type: "albums", Supported: true},
+		{Type: "idempotent_upload", Supported: true},
+	}
+}
+
+// ConfigureDestination sets the default albums for subsequent uploads
+func (o *Output) ConfigureDestination(dest interfaces.DestinationConfig) error {
+	albums, err := plugins.Settings(dest.Config).StringSlice("albums")
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.defaultAlbums = albums
+	return nil
+}
+
+// Publish uploads the asset and adds it to the albums named in its metadata.
+// Streams that already carry an asset ID for this server are not uploaded
+// again.
+func (o *Output) Publish(ctx context.Context, data *interfaces.DataStream) error {
+	if data == nil {
+		return fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypePhoto && data.Type != interfaces.MediaTypeVideo {
+		return fmt.Errorf("unsupported media type %q for photo library", data.Type)
+	}
+	if data.ID == "" {
+		return fmt.Errorf("data stream has no ID")
+	}
+
+	client := o.getClient()
+	if client == nil {
+		return fmt.Errorf("photo library output %s is not configured", o.GetMetadata().Name)
+	}
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+
+	server := client.baseURL.String()
+	assetID := plugins.RemoteID(data.Metadata, MetaAssetIDs, server)
+	if assetID == "" {
+		if data.Content == nil {
+			return fmt.Errorf("data stream %s has no content", data.ID)
+		}
+		defer data.Content.Close()
+
+		o.mu.Lock()
+		deviceID := o.deviceID
+		o.mu.Unlock()
+
+		created := capturedAt(data)
+		result, err := client.UploadAsset(ctx, Asset{
+			DeviceAssetID:  data.ID,
+			DeviceID:       deviceID,
+			Filename:       filename(data),
+			ContentType:    data.Headers["Content-Type"],
+			FileCreatedAt:  created,
+			FileModifiedAt: created,
+			IsFavorite:     data.Metadata[MetaFavorite] == true,
+			Content:        data.Content,
+		})
+		if err != nil {
+			o.RecordError(err)
+			return err
+		}
+		assetID = result.ID
+		plugins.SetRemoteID(data.Metadata, MetaAssetIDs, server, result.ID)
+		data.Metadata[MetaAssetID] = result.ID
+		data.Metadata[MetaUploadStatus] = result.Status
+	}
+
+	for _, album := range o.albumsFor(data) {
+		if err := o.addToAlbum(ctx, client, album, assetID); err != nil {
+			o.RecordError(err)
+			return fmt.Errorf("asset %s uploaded but album %q update failed: %w", assetID, album, err)
+		}
+	}
+
+	o.RecordError(nil)
+	return nil
+}
+
+// addToAlbum adds the asset to the named album, creating the album if needed
+func (o *Output) addToAlbum(ctx context.Context, client *Client, name, assetID string) error {
+	albumID, created, err := o.album(ctx, client, name, assetID)
+	if err != nil || created {
+		return err
+	}
+	return client.AddAssetsToAlbum(ctx, albumID, []string{assetID})
+}
+
+// album returns the ID of the named album, creating it with the asset when
+// the library has none; created reports whether it did
+func (o *Output) album(ctx context.Context, client *Client, name, assetID string) (albumID string, created bool, err error) {
+	if albumID, ok := o.albumID(name); ok {
+		return albumID, false, nil
+	}
+
+	// Only one upload looks up or creates a given album at a time
+	o.mu.Lock()
+	lock, ok := o.albumLocks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		o.albumLocks[name] = lock
+	}
+	o.mu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	if albumID, ok := o.albumID(name); ok {
+		return albumID, false, nil
+	}
+	albums, err := client.ListAlbums(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	o.mu.Lock()
+	for _, a := range albums {
+		o.albumIDs[a.AlbumName] = a.ID
+	}
+	albumID, ok = o.albumIDs[name]
+	o.mu.Unlock()
+	if ok {
+		return albumID, false, nil
+	}
+
+	album, err := client.CreateAlbum(ctx, name, []string{assetID})
+	if err != nil {
+		return "", false, err
+	}
+	o.mu.Lock()
+	o.albumIDs[name] = album.ID
+	o.mu.Unlock()
+	return album.ID, true, nil
+}
+
+// albumID returns the cached ID of the named album
+func (o *Output) albumID(name string) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	id, ok := o.albumIDs[name]
+	return id, ok
+}
+
+// albumsFor merges the configured default albums with those named in metadata
+func (o *Output) albumsFor(data *interfaces.DataStream) []string {
+	o.mu.Lock()
+	names := append([]string(nil), o.defaultAlbums...)
+	o.mu.Unlock()
+
+	switch v := data.Metadata[MetaAlbums].(type) {
+	case []string:
+		names = append(names, v...)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				names = append(names, s)
+			}
+		}
+	case string:
+		names = append(names, v)
+	}
+	if album, ok := data.Metadata[MetaAlbum].(string); ok {
+		names = append(names, album)
+	}
+
+	seen := make(map[string]bool, len(names))
+	unique := names[:0]
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		unique = append(unique, name)
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+func (o *Output) getClient() *Client {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.client
+}
+
+// capturedAt picks the best available creation time for the asset
+func capturedAt(data *interfaces.DataStream) time.Time {
+	switch v := data.Metadata[MetaCapturedAt].(type) {
+	case time.Time:
+		return v
+	case string:
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t
+		}
+	}
+	if !data.Context.CreatedAt.IsZero() {
+		return data.Context.CreatedAt
+	}
+	return time.Now()
+}
+
+// filename returns the upload file name, deriving an extension from the content type
+func filename(data *interfaces.DataStream) string {
+	if name, ok := data.Metadata["filename"].(string); ok && name != "" {
+		return path.Base(name)
+	}
+	name := path.Base("/" + data.ID)
+	if path.Ext(name) == "" {
+		name += media.Extension(data.Headers["Content-Type"])
+	}
+	return name
+}
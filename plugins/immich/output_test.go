@@ -0,0 +1,337 @@
+package immich
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/config"
+	"github.com/sho7650/media-sync/internal/pipeline"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// fakeLibrary is a local stand-in for the photo library API
+type fakeLibrary struct {
+	mu       sync.Mutex
+	assets   map[string]string // deviceId/deviceAssetId -> asset id
+	uploads  []map[string]string
+	contents map[string]string
+	albums   map[string]*fakeAlbum
+	nextID   int
+}
+
+type fakeAlbum struct {
+	Name   string
+	Assets map[string]bool
+}
+
+func newFakeLibrary(t *testing.T) (*fakeLibrary, *httptest.Server) {
+	t.Helper()
+
+	lib := &fakeLibrary{
+		assets:   make(map[string]string),
+		contents: make(map[string]string),
+		albums:   make(map[string]*fakeAlbum),
+	}
+	server := httptest.NewServer(lib)
+	t.Cleanup(server.Close)
+	return lib, server
+}
+
+func (f *fakeLibrary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-api-key") != "test-key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "Invalid API key"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/users/me":
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "user-1"})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/api/assets":
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("assetData")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, _ := io.ReadAll(file)
+
+		fields := map[string]string{"filename": header.Filename, "contentType": header.Header.Get("Content-Type")}
+		for key := range r.MultipartForm.Value {
+			fields[key] = r.FormValue(key)
+		}
+		f.uploads = append(f.uploads, fields)
+
+		key := fields["deviceId"] + "/" + fields["deviceAssetId"]
+		if id, ok := f.assets[key]; ok {
+			_ = json.NewEncoder(w).Encode(UploadResult{ID: id, Status: StatusDuplicate})
+			return
+		}
+		f.nextID++
+		id := fmt.Sprintf("asset-%d", f.nextID)
+		f.assets[key] = id
+		f.contents[id] = string(content)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(UploadResult{ID: id, Status: StatusCreated})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/api/albums":
+		albums := []Album{}
+		for id, a := range f.albums {
+			albums = append(albums, Album{ID: id, AlbumName: a.Name})
+		}
+		_ = json.NewEncoder(w).Encode(albums)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/api/albums":
+		var body struct {
+			AlbumName string   `json:"albumName"`
+			AssetIDs  []string `json:"assetIds"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.nextID++
+		id := fmt.Sprintf("album-%d", f.nextID)
+		album := &fakeAlbum{Name: body.AlbumName, Assets: make(map[string]bool)}
+		for _, a := range body.AssetIDs {
+			album.Assets[a] = true
+		}
+		f.albums[id] = album
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(Album{ID: id, AlbumName: body.AlbumName})
+
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/api/albums/") && strings.HasSuffix(r.URL.Path, "/assets"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/albums/"), "/assets")
+		album, ok := f.albums[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		for _, a := range body.IDs {
+			album.Assets[a] = true
+		}
+		_ = json.NewEncoder(w).Encode([]map[string]interface{}{})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeLibrary) album(name string) *fakeAlbum {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, a := range f.albums {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func newOutput(t *testing.T, serverURL string, extra map[string]interface{}) *Output {
+	t.Helper()
+
+	p, err := NewOutput(plugins.PluginConfig{Name: "immich", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+
+	settings := map[string]interface{}{"url": serverURL, "api_key": "test-key", "device_id": "nas-sync"}
+	for k, v := range extra {
+		settings[k] = v
+	}
+	require.NoError(t, p.Configure(settings))
+	require.NoError(t, p.Start(context.Background()))
+	return p.(*Output)
+}
+
+func photo(id string, metadata map[string]interface{}) *interfaces.DataStream {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	return &interfaces.DataStream{
+		ID:       id,
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: metadata,
+		Content:  io.NopCloser(strings.NewReader("pixels-" + id)),
+		Headers:  map[string]string{"Content-Type": "image/jpeg"},
+		Context:  interfaces.StreamContext{CreatedAt: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)},
+	}
+}
+
+func TestOutput_UploadSetsDeviceAssetIDAndStoresRemoteID(t *testing.T) {
+	lib, server := newFakeLibrary(t)
+	out := newOutput(t, server.URL, nil)
+
+	data := photo("media-123", map[string]interface{}{"captured_at": "2023-12-24T18:30:00Z"})
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	assert.Equal(t, "asset-1", data.Metadata[MetaAssetID])
+	assert.Equal(t, StatusCreated, data.Metadata[MetaUploadStatus])
+
+	require.Len(t, lib.uploads, 1)
+	upload := lib.uploads[0]
+	assert.Equal(t, "media-123", upload["deviceAssetId"])
+	assert.Equal(t, "nas-sync", upload["deviceId"])
+	assert.Equal(t, "2023-12-24T18:30:00Z", upload["fileCreatedAt"])
+	assert.Equal(t, "media-123.jpg", upload["filename"])
+	assert.Equal(t, "image/jpeg", upload["contentType"])
+	assert.Equal(t, "pixels-media-123", lib.contents["asset-1"])
+}
+
+func TestOutput_RepeatedUploadIsIdempotent(t *testing.T) {
+	lib, server := newFakeLibrary(t)
+	out := newOutput(t, server.URL, nil)
+	ctx := context.Background()
+
+	first := photo("media-1", nil)
+	require.NoError(t, out.Publish(ctx, first))
+
+	// A fresh stream for the same item is reported as a duplicate of the same asset
+	again := photo("media-1", nil)
+	require.NoError(t, out.Publish(ctx, again))
+	assert.Equal(t, first.Metadata[MetaAssetID], again.Metadata[MetaAssetID])
+	assert.Equal(t, StatusDuplicate, again.Metadata[MetaUploadStatus])
+
+	// A stream that already carries the remote ID for this server is not
+	// uploaded at all
+	reprocessed := photo("media-1", map[string]interface{}{
+		MetaAssetIDs: map[string]interface{}{server.URL: "asset-1"},
+	})
+	require.NoError(t, out.Publish(ctx, reprocessed))
+	assert.Len(t, lib.uploads, 2)
+}
+
+// streamInput feeds one stream into a pipeline
+type streamInput struct {
+	data *interfaces.DataStream
+}
+
+func (s *streamInput) Start(ctx context.Context) error { return nil }
+func (s *streamInput) Stop(ctx context.Context) error  { return nil }
+func (s *streamInput) Health() interfaces.ServiceHealth {
+	return interfaces.ServiceHealth{Status: interfaces.StatusHealthy}
+}
+func (s *streamInput) Info() interfaces.ServiceInfo          { return interfaces.ServiceInfo{Name: "stream"} }
+func (s *streamInput) Capabilities() []interfaces.Capability { return nil }
+func (s *streamInput) Retrieve(ctx context.Context, req interfaces.RetrievalRequest) (*interfaces.DataStream, error) {
+	return s.data, nil
+}
+func (s *streamInput) SupportedModes() []interfaces.SyncMode { return nil }
+func (s *streamInput) Authenticate(ctx context.Context, creds interfaces.Credentials) error {
+	return nil
+}
+
+func TestOutput_TwoLibrariesInOnePipeline(t *testing.T) {
+	home, homeServer := newFakeLibrary(t)
+	family, familyServer := newFakeLibrary(t)
+	services := map[string]interfaces.Service{
+		"in":     &streamInput{data: photo("media-1", nil)},
+		"home":   newOutput(t, homeServer.URL, nil),
+		"family": newOutput(t, familyServer.URL, nil),
+	}
+
+	p, err := pipeline.Build("photos", config.PipelineConfig{Input: "in", Outputs: []string{"home", "family"}}, services)
+	require.NoError(t, err)
+	require.NoError(t, p.Run(context.Background(), interfaces.RetrievalRequest{}))
+
+	require.Len(t, home.uploads, 1)
+	require.Len(t, family.uploads, 1, "the asset ID from the first library must not skip the second")
+	assert.Equal(t, "pixels-media-1", family.contents["asset-1"])
+}
+
+func TestOutput_AlbumMembershipFromMetadata(t *testing.T) {
+	lib, server := newFakeLibrary(t)
+	out := newOutput(t, server.URL, map[string]interface{}{"albums": []interface{}{"Synced"}})
+	ctx := context.Background()
+
+	require.NoError(t, out.Publish(ctx, photo("m1", map[string]interface{}{
+		"albums": []interface{}{"Holidays", "Family"},
+	})))
+	require.NoError(t, out.Publish(ctx, photo("m2", map[string]interface{}{"album": "Holidays"})))
+
+	holidays := lib.album("Holidays")
+	require.NotNil(t, holidays)
+	assert.Len(t, holidays.Assets, 2)
+
+	family := lib.album("Family")
+	require.NotNil(t, family)
+	assert.Len(t, family.Assets, 1)
+
+	synced := lib.album("Synced")
+	require.NotNil(t, synced)
+	assert.Len(t, synced.Assets, 2)
+	assert.Len(t, lib.albums, 3, "albums must be reused rather than recreated")
+}
+
+func TestOutput_ConcurrentUploadsShareNewAlbum(t *testing.T) {
+	lib, server := newFakeLibrary(t)
+	out := newOutput(t, server.URL, map[string]interface{}{"albums": []interface{}{"Synced"}})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = out.Publish(ctx, photo(fmt.Sprintf("m%d", i), nil))
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, lib.albums, 1, "the album is created once")
+	synced := lib.album("Synced")
+	require.NotNil(t, synced)
+	assert.Len(t, synced.Assets, len(errs))
+}
+
+func TestOutput_RejectsInvalidAPIKey(t *testing.T) {
+	_, server := newFakeLibrary(t)
+
+	p, err := NewOutput(plugins.PluginConfig{Name: "immich", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+	require.NoError(t, p.Configure(map[string]interface{}{"url": server.URL, "api_key": "wrong"}))
+
+	err = p.Start(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid API key")
+}
+
+func TestOutput_RejectsUnsupportedMediaType(t *testing.T) {
+	_, server := newFakeLibrary(t)
+	out := newOutput(t, server.URL, nil)
+
+	data := photo("note", nil)
+	data.Type = interfaces.MediaTypeText
+	assert.Error(t, out.Publish(context.Background(), data))
+}
+
+func TestOutput_ConfigureRequiresAPIKey(t *testing.T) {
+	p, err := NewOutput(plugins.PluginConfig{Name: "immich", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+
+	err = p.Configure(map[string]interface{}{"url": "http://photos.local"})
+	assert.ErrorIs(t, err, plugins.ErrInvalidConfig)
+}
@@ -0,0 +1,104 @@
+package linkpreserve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
+)
+
+// ErrPrivateAddress is returned when a link resolves to a loopback, private
+// or link-local address and allow_private is not set
+var ErrPrivateAddress = errors.New("link resolves to a private address")
+
+// response is a fetched resource with its body read up to the size limit
+type response struct {
+	URL        *url.URL
+	Status     int
+	StatusText string
+	Proto      string
+	Header     http.Header
+	Body       []byte
+}
+
+// clientOptions describe the HTTP client used for pages and their
+// resources. The dialer refuses private addresses unless allowPrivate is
+// set, which also covers redirects and DNS names that resolve to internal
+// hosts; the host's proxy is bypassed, since it would connect on the
+// client's behalf, unless the service names one itself.
+func clientOptions(allowPrivate bool) httpclient.ClientOptions {
+	opts := httpclient.ClientOptions{
+		DefaultTimeout: defaultTimeout,
+		Proxy:          httpclient.ProxyDirect,
+		UserAgent:      defaultUserAgent,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	if !allowPrivate {
+		opts.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		}
+	}
+	return opts
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// fetch retrieves target, failing for non-2xx responses and bodies over
+// maxBytes
+func fetch(ctx context.Context, client *http.Client, target, accept string, maxBytes int64) (*response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", target, err)
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, fmt.Errorf("GET %s: response exceeds %d bytes", target, maxBytes)
+	}
+	return &response{
+		URL:        resp.Request.URL,
+		Status:     resp.StatusCode,
+		StatusText: http.StatusText(resp.StatusCode),
+		Proto:      resp.Proto,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
@@ -0,0 +1,153 @@
+package linkpreserve
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Page is the descriptive data found in an HTML document. OpenGraph and
+// Twitter hold the og: and twitter: properties without their prefix.
+type Page struct {
+	Title       string
+	Description string
+	Canonical   string
+	OEmbedURL   string
+	OpenGraph   map[string]string
+	Twitter     map[string]string
+}
+
+// parsePage reads the title, description, canonical link, oEmbed discovery
+// link and card properties of doc, resolving URLs against base
+func parsePage(doc *html.Node, base *url.URL) Page {
+	p := Page{OpenGraph: map[string]string{}, Twitter: map[string]string{}}
+	walk(doc, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Title:
+			if p.Title == "" {
+				p.Title = strings.TrimSpace(text(n))
+			}
+		case atom.Meta:
+			key := strings.ToLower(attr(n, "property"))
+			if key == "" {
+				key = strings.ToLower(attr(n, "name"))
+			}
+			content := strings.TrimSpace(attr(n, "content"))
+			if content == "" {
+				break
+			}
+			switch {
+			case strings.HasPrefix(key, "og:"):
+				setFirst(p.OpenGraph, strings.TrimPrefix(key, "og:"), content)
+			case strings.HasPrefix(key, "twitter:"):
+				setFirst(p.Twitter, strings.TrimPrefix(key, "twitter:"), content)
+			case key == "description" && p.Description == "":
+				p.Description = content
+			}
+		case atom.Link:
+			rel := strings.Fields(strings.ToLower(attr(n, "rel")))
+			href := resolve(base, attr(n, "href"))
+			if href == "" {
+				break
+			}
+			for _, r := range rel {
+				switch {
+				case r == "canonical" && p.Canonical == "":
+					p.Canonical = href
+				case r == "alternate" && strings.EqualFold(attr(n, "type"), "application/json+oembed") && p.OEmbedURL == "":
+					p.OEmbedURL = href
+				}
+			}
+		}
+		return true
+	})
+	for _, key := range []string{"image", "url"} {
+		if v, ok := p.OpenGraph[key]; ok {
+			p.OpenGraph[key] = resolve(base, v)
+		}
+	}
+	if v, ok := p.Twitter["image"]; ok {
+		p.Twitter["image"] = resolve(base, v)
+	}
+	return p
+}
+
+func setFirst(m map[string]string, key, value string) {
+	if _, ok := m[key]; !ok {
+		m[key] = value
+	}
+}
+
+// walk visits n and its descendants in document order, skipping the
+// children of nodes for which visit returns false
+func walk(n *html.Node, visit func(*html.Node) bool) {
+	if n.Type == html.ElementNode && !visit(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		walk(c, visit)
+		c = next
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+func removeAttr(n *html.Node, key string) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if !strings.EqualFold(a.Key, key) {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
+}
+
+func text(n *html.Node) string {
+	var buf bytes.Buffer
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return buf.String()
+}
+
+// resolve makes ref absolute against base, returning "" for references
+// that are not http(s) URLs
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
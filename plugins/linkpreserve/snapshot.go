@@ -0,0 +1,207 @@
+package linkpreserve
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/sho7650/media-sync/internal/media"
+)
+
+// Snapshot meta tags added to the head of HTML snapshots
+const (
+	MetaTagSource   = "media-sync:source"
+	MetaTagCaptured = "media-sync:captured"
+)
+
+// cssURL matches url(...) references with optional quotes
+var cssURL = regexp.MustCompile(`url\(\s*(?:'([^']*)'|"([^"]*)"|([^)'"\s]*))\s*\)`)
+
+// inliner fetches stylesheets and images once each and turns them into
+// data URIs, stopping after maxResources fetches. References it cannot
+// inline are left as absolute URLs.
+type inliner struct {
+	ctx       context.Context
+	client    *http.Client
+	maxBytes  int64
+	remaining int
+	cache     map[string]string
+	styles    map[string]string
+}
+
+// snapshot renders doc as a self-contained page: scripts and event
+// handlers are removed, stylesheets and images are inlined, other links are
+// made absolute and the source URL and capture time are recorded in meta
+// tags
+func (in *inliner) snapshot(doc *html.Node, base *url.URL, captured time.Time) ([]byte, error) {
+	var head *html.Node
+	walk(doc, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Script, atom.Noscript, atom.Base:
+			n.Parent.RemoveChild(n)
+			return false
+		case atom.Head:
+			if head == nil {
+				head = n
+			}
+		case atom.Link:
+			in.link(n, base)
+			return false
+		case atom.Style:
+			if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+				n.FirstChild.Data = in.css(n.FirstChild.Data, base)
+			}
+		case atom.Img:
+			if src := resolve(base, attr(n, "src")); src != "" {
+				setAttr(n, "src", in.dataURI(src))
+			}
+			removeAttr(n, "srcset")
+		case atom.Source:
+			if attr(n, "srcset") != "" {
+				n.Parent.RemoveChild(n)
+				return false
+			}
+		}
+		attrs := n.Attr[:0]
+		for _, a := range n.Attr {
+			if !strings.HasPrefix(strings.ToLower(a.Key), "on") {
+				attrs = append(attrs, a)
+			}
+		}
+		n.Attr = attrs
+		for _, key := range []string{"href", "action", "poster"} {
+			if v := attr(n, key); v != "" && n.DataAtom != atom.Link {
+				if abs := resolve(base, v); abs != "" {
+					setAttr(n, key, abs)
+				}
+			}
+		}
+		if style := attr(n, "style"); style != "" {
+			setAttr(n, "style", in.css(style, base))
+		}
+		return true
+	})
+
+	if head != nil {
+		for _, tag := range [][2]string{
+			{MetaTagSource, base.String()},
+			{MetaTagCaptured, captured.UTC().Format(time.RFC3339)},
+		} {
+			head.AppendChild(&html.Node{
+				Type:     html.ElementNode,
+				Data:     "meta",
+				DataAtom: atom.Meta,
+				Attr:     []html.Attribute{{Key: "name", Val: tag[0]}, {Key: "content", Val: tag[1]}},
+			})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// link replaces stylesheet links with inline style elements and inlines
+// icons; other links get absolute hrefs
+func (in *inliner) link(n *html.Node, base *url.URL) {
+	href := resolve(base, attr(n, "href"))
+	if href == "" {
+		return
+	}
+	rel := strings.Fields(strings.ToLower(attr(n, "rel")))
+	for _, r := range rel {
+		switch r {
+		case "stylesheet":
+			css, ok := in.stylesheet(href)
+			if !ok {
+				setAttr(n, "href", href)
+				return
+			}
+			style := &html.Node{Type: html.ElementNode, Data: "style", DataAtom: atom.Style}
+			if m := attr(n, "media"); m != "" {
+				style.Attr = []html.Attribute{{Key: "media", Val: m}}
+			}
+			style.AppendChild(&html.Node{Type: html.TextNode, Data: css})
+			n.Parent.InsertBefore(style, n)
+			n.Parent.RemoveChild(n)
+			return
+		case "icon", "apple-touch-icon":
+			setAttr(n, "href", in.dataURI(href))
+			return
+		case "preload", "prefetch", "modulepreload", "preconnect", "dns-prefetch":
+			n.Parent.RemoveChild(n)
+			return
+		}
+	}
+	setAttr(n, "href", href)
+}
+
+// stylesheet fetches the stylesheet at href with its url() references
+// inlined
+func (in *inliner) stylesheet(href string) (string, bool) {
+	if css, ok := in.styles[href]; ok {
+		return css, true
+	}
+	if in.remaining <= 0 {
+		return "", false
+	}
+	in.remaining--
+	resp, err := fetch(in.ctx, in.client, href, "text/css,*/*;q=0.1", in.maxBytes)
+	if err != nil {
+		return "", false
+	}
+	css := in.css(string(resp.Body), resp.URL)
+	in.styles[href] = css
+	return css, true
+}
+
+// css inlines the url() references of a stylesheet or style attribute
+func (in *inliner) css(css string, base *url.URL) string {
+	return cssURL.ReplaceAllStringFunc(css, func(match string) string {
+		groups := cssURL.FindStringSubmatch(match)
+		ref := groups[1] + groups[2] + groups[3]
+		if ref == "" || strings.HasPrefix(ref, "#") {
+			return match
+		}
+		abs := resolve(base, ref)
+		if abs == "" {
+			return match
+		}
+		return `url("` + in.dataURI(abs) + `")`
+	})
+}
+
+// dataURI returns the resource at abs as a data URI, or abs itself when it
+// cannot be fetched
+func (in *inliner) dataURI(abs string) string {
+	if uri, ok := in.cache[abs]; ok {
+		return uri
+	}
+	if in.remaining <= 0 {
+		return abs
+	}
+	in.remaining--
+	resp, err := fetch(in.ctx, in.client, abs, "", in.maxBytes)
+	if err != nil {
+		in.cache[abs] = abs
+		return abs
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" || contentType == media.OctetStream {
+		contentType = media.Sniff(resp.Body)
+	}
+	uri := "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(resp.Body)
+	in.cache[abs] = uri
+	return uri
+}
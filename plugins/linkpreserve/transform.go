@@ -0,0 +1,399 @@
+// Package linkpreserve provides a transform that fetches the page behind a
+// link post, records its OpenGraph, Twitter card and oEmbed data as
+// metadata and optionally replaces the content with an HTML or WARC
+// snapshot of the page.
+package linkpreserve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform besides the canonical title,
+// description, author and source URL
+const (
+	KeyOpenGraph   = "opengraph"
+	KeyTwitterCard = "twitter_card"
+	KeyOEmbed      = "oembed"
+	KeyCanonical   = "canonical_url"
+	KeyImage       = "link_image"
+	KeyFinalURL    = "link_final_url"
+	KeyStatus      = "link_status"
+	KeyFetchedAt   = "link_fetched_at"
+	// KeyError holds the reason a link could not be fetched
+	KeyError = "link_error"
+	// KeySnapshot names the snapshot format that replaced the content
+	KeySnapshot = "link_snapshot"
+)
+
+// Snapshot formats
+const (
+	SnapshotNone = "none"
+	SnapshotHTML = "html"
+	SnapshotWARC = "warc"
+)
+
+const (
+	defaultTimeout          = 30 * time.Second
+	defaultUserAgent        = "media-sync-linkpreserve"
+	defaultMaxBytes         = 8 << 20
+	defaultMaxResources     = 50
+	defaultMaxResourceBytes = 4 << 20
+	maxOEmbedBytes          = 1 << 20
+	// maxURLContent is the largest content read as a bare URL
+	maxURLContent = 4096
+)
+
+var defaultURLFields = []string{"link_url", "url"}
+
+// Transform resolves link streams. The target is the first of url_fields
+// found in the metadata, or else the content when it is a bare URL. Pages
+// that cannot be fetched pass through with KeyError set.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu               sync.RWMutex
+	client           *http.Client
+	urlFields        []string
+	maxBytes         int64
+	oembed           bool
+	snapshot         string
+	maxResources     int
+	maxResourceBytes int64
+	overwrite        bool
+	now              func() time.Time
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a link preservation transform; it matches
+// PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	base := plugins.NewBasePlugin(config, "transform", "Resolves link metadata and snapshots linked pages")
+	client, err := base.HTTPClient(nil, clientOptions(false))
+	if err != nil {
+		return nil, err
+	}
+	return &Transform{
+		BasePlugin:       base,
+		client:           client,
+		urlFields:        defaultURLFields,
+		maxBytes:         defaultMaxBytes,
+		oembed:           true,
+		snapshot:         SnapshotNone,
+		maxResources:     defaultMaxResources,
+		maxResourceBytes: defaultMaxResourceBytes,
+		now:              time.Now,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	urlFields, err := settings.StringSlice("url_fields")
+	if err != nil {
+		return err
+	}
+	if len(urlFields) == 0 {
+		urlFields = defaultURLFields
+	}
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	if maxBytes < 1024 {
+		return fmt.Errorf("%w: max_bytes must be at least 1024", plugins.ErrInvalidConfig)
+	}
+	allowPrivate, err := settings.Bool("allow_private", false)
+	if err != nil {
+		return err
+	}
+	oembed, err := settings.Bool("oembed", true)
+	if err != nil {
+		return err
+	}
+	snapshot := settings.String("snapshot", SnapshotNone)
+	switch snapshot {
+	case SnapshotNone, SnapshotHTML, SnapshotWARC:
+	default:
+		return fmt.Errorf("%w: snapshot must be %q, %q or %q", plugins.ErrInvalidConfig, SnapshotNone, SnapshotHTML, SnapshotWARC)
+	}
+	maxResources, err := settings.Int("max_resources", defaultMaxResources)
+	if err != nil {
+		return err
+	}
+	if maxResources < 0 {
+		return fmt.Errorf("%w: max_resources must not be negative", plugins.ErrInvalidConfig)
+	}
+	maxResourceBytes, err := settings.Int("max_resource_bytes", defaultMaxResourceBytes)
+	if err != nil {
+		return err
+	}
+	if maxResourceBytes < 1 {
+		return fmt.Errorf("%w: max_resource_bytes must be positive", plugins.ErrInvalidConfig)
+	}
+	overwrite, err := settings.Bool("overwrite", false)
+	if err != nil {
+		return err
+	}
+	client, err := t.HTTPClient(settings, clientOptions(allowPrivate))
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.client = client
+	t.urlFields = urlFields
+	t.maxBytes = int64(maxBytes)
+	t.oembed = oembed
+	t.snapshot = snapshot
+	t.maxResources = maxResources
+	t.maxResourceBytes = int64(maxResourceBytes)
+	t.overwrite = overwrite
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types": []string{string(interfaces.MediaTypeLink)},
+			"metadata":    []string{"opengraph", "twitter_card", "oembed"},
+			"snapshots":   []string{SnapshotHTML, SnapshotWARC},
+		}},
+	}
+}
+
+// ValidateSchema accepts link streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypeLink) {
+		return fmt.Errorf("link preservation does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform fetches the linked page and adds its metadata. Keys already
+// present are kept unless overwrite is set. With a snapshot format the
+// content is replaced by the snapshot; otherwise it passes through.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypeLink {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	client, urlFields, maxBytes := t.client, t.urlFields, t.maxBytes
+	oembed, snapshot, overwrite := t.oembed, t.snapshot, t.overwrite
+	in := &inliner{
+		ctx:       ctx,
+		client:    client,
+		maxBytes:  t.maxResourceBytes,
+		remaining: t.maxResources,
+		cache:     make(map[string]string),
+		styles:    make(map[string]string),
+	}
+	t.mu.RUnlock()
+
+	target, err := t.target(data, urlFields)
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+	if target == "" {
+		return data, nil
+	}
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+
+	fetchedAt := t.now()
+	resp, err := fetch(ctx, client, target, "text/html,application/xhtml+xml,*/*;q=0.8", maxBytes)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		t.RecordError(err)
+		data.Metadata[KeyError] = err.Error()
+		return data, nil
+	}
+
+	fields := map[string]interface{}{
+		KeyFinalURL:              resp.URL.String(),
+		KeyStatus:                resp.Status,
+		KeyFetchedAt:             fetchedAt.UTC().Format(time.RFC3339),
+		interfaces.MetaSourceURL: target,
+	}
+	var doc *html.Node
+	if isHTML(resp) {
+		if doc, err = html.Parse(bytes.NewReader(resp.Body)); err == nil {
+			page := parsePage(doc, resp.URL)
+			var embed map[string]interface{}
+			if oembed && page.OEmbedURL != "" {
+				embed = fetchOEmbed(ctx, client, page.OEmbedURL)
+			}
+			pageFields(fields, page, embed)
+		}
+	}
+	for key, value := range fields {
+		if _, exists := data.Metadata[key]; exists && !overwrite && key != KeyFinalURL && key != KeyStatus && key != KeyFetchedAt {
+			continue
+		}
+		data.Metadata[key] = value
+	}
+	delete(data.Metadata, KeyError)
+
+	var body []byte
+	var contentType, ext string
+	switch {
+	case snapshot == SnapshotWARC:
+		body = writeWARC(resp, snapshotFilename(data, resp.URL, ".warc"), fetchedAt)
+		contentType, ext = "application/warc", ".warc"
+	case snapshot == SnapshotHTML && doc != nil:
+		if body, err = in.snapshot(doc, resp.URL, fetchedAt); err != nil {
+			t.RecordError(err)
+			return nil, fmt.Errorf("failed to render snapshot of %s: %w", data.ID, err)
+		}
+		contentType, ext = "text/html; charset=utf-8", ".html"
+	}
+	if body != nil {
+		if data.Content != nil {
+			data.Content.Close()
+		}
+		data.Content = io.NopCloser(bytes.NewReader(body))
+		if data.Headers == nil {
+			data.Headers = make(map[string]string)
+		}
+		data.Headers["Content-Type"] = contentType
+		data.Headers["Content-Length"] = strconv.Itoa(len(body))
+		data.Metadata["filename"] = snapshotFilename(data, resp.URL, ext)
+		data.Metadata[KeySnapshot] = snapshot
+	}
+	t.RecordError(nil)
+	return data, nil
+}
+
+// target returns the link URL from the metadata, or from content holding
+// nothing but an absolute http(s) URL
+func (t *Transform) target(data *interfaces.DataStream, urlFields []string) (string, error) {
+	for _, field := range urlFields {
+		if s, ok := data.Metadata[field].(string); ok && isHTTPURL(s) {
+			return strings.TrimSpace(s), nil
+		}
+	}
+	if data.Content == nil {
+		return "", nil
+	}
+	head, content, err := media.Peek(data.Content, maxURLContent+1)
+	data.Content = content
+	if err != nil {
+		return "", err
+	}
+	if s := strings.TrimSpace(string(head)); len(head) <= maxURLContent && !strings.ContainsAny(s, " \t\r\n") && isHTTPURL(s) {
+		return s, nil
+	}
+	return "", nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func isHTML(resp *response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(media.Sniff(resp.Body))
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// pageFields adds the page data to fields. Titles and descriptions prefer
+// OpenGraph over Twitter cards, oEmbed and the document itself.
+func pageFields(fields map[string]interface{}, page Page, embed map[string]interface{}) {
+	first := func(values ...string) string {
+		for _, v := range values {
+			if v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	embedString := func(key string) string {
+		s, _ := embed[key].(string)
+		return s
+	}
+	set := func(key, value string) {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	set(interfaces.MetaTitle, first(page.OpenGraph["title"], page.Twitter["title"], embedString("title"), page.Title))
+	set(interfaces.MetaDescription, first(page.OpenGraph["description"], page.Twitter["description"], page.Description))
+	set(interfaces.MetaAuthor, first(embedString("author_name"), page.OpenGraph["article:author"], page.Twitter["creator"]))
+	set(KeyCanonical, first(page.Canonical, page.OpenGraph["url"]))
+	set(KeyImage, first(page.OpenGraph["image"], page.Twitter["image"], embedString("thumbnail_url")))
+	if len(page.OpenGraph) > 0 {
+		fields[KeyOpenGraph] = page.OpenGraph
+	}
+	if len(page.Twitter) > 0 {
+		fields[KeyTwitterCard] = page.Twitter
+	}
+	if len(embed) > 0 {
+		fields[KeyOEmbed] = embed
+	}
+}
+
+// fetchOEmbed retrieves a JSON oEmbed response, keeping its scalar
+// fields; failures leave the oEmbed data out
+func fetchOEmbed(ctx context.Context, client *http.Client, endpoint string) map[string]interface{} {
+	resp, err := fetch(ctx, client, endpoint, "application/json", maxOEmbedBytes)
+	if err != nil {
+		return nil
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(resp.Body, &raw); err != nil {
+		return nil
+	}
+	embed := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		switch value.(type) {
+		case string, float64, bool:
+			embed[key] = value
+		}
+	}
+	return embed
+}
+
+// snapshotFilename keeps the base of the stream filename, or else uses the
+// page host, with the snapshot extension
+func snapshotFilename(data *interfaces.DataStream, page *url.URL, ext string) string {
+	if filename, ok := data.Metadata["filename"].(string); ok && filename != "" {
+		return strings.TrimSuffix(filename, path.Ext(filename)) + ext
+	}
+	return page.Hostname() + ext
+}
@@ -0,0 +1,261 @@
+package linkpreserve
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+const articleHTML = `<!DOCTYPE html>
+<html><head>
+<title>Document title</title>
+<meta name="description" content="Plain description">
+<meta property="og:title" content="OpenGraph title">
+<meta property="og:description" content="OpenGraph description">
+<meta property="og:image" content="/img/cover.png">
+<meta property="og:type" content="article">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:creator" content="@writer">
+<link rel="canonical" href="/articles/1">
+<link rel="stylesheet" href="/style.css">
+<link rel="alternate" type="application/json+oembed" href="/oembed?url=/articles/1">
+<script>alert("tracking")</script>
+</head><body onload="track()">
+<h1>Article</h1>
+<img src="/img/cover.png" srcset="/img/cover@2x.png 2x" alt="cover">
+<a href="/about">About</a>
+</body></html>`
+
+// fixtureSite serves an article with a stylesheet, an image and an oEmbed
+// endpoint, and redirects /short to the article
+func fixtureSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 4))))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/articles/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, articleHTML)
+	})
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/articles/1", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		io.WriteString(w, `body { background: url('img/cover.png') }`)
+	})
+	mux.HandleFunc("/img/cover.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img.Bytes())
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"version":"1.0","type":"rich","title":"oEmbed title","author_name":"A. Writer","html":"<div></div>","nested":{"x":1}}`)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "linkpreserve", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	settings["allow_private"] = true
+	require.NoError(t, tr.Configure(settings))
+	tr.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	return tr
+}
+
+func link(target string) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "post-1",
+		Type:     interfaces.MediaTypeLink,
+		Metadata: map[string]interface{}{"link_url": target},
+	}
+}
+
+func TestTransform_Metadata(t *testing.T) {
+	site := fixtureSite(t)
+	tr := newTransform(t, nil)
+
+	out, err := tr.Transform(context.Background(), link(site.URL+"/short"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "OpenGraph title", out.Metadata[interfaces.MetaTitle])
+	assert.Equal(t, "OpenGraph description", out.Metadata[interfaces.MetaDescription])
+	assert.Equal(t, "A. Writer", out.Metadata[interfaces.MetaAuthor])
+	assert.Equal(t, site.URL+"/short", out.Metadata[interfaces.MetaSourceURL])
+	assert.Equal(t, site.URL+"/articles/1", out.Metadata[KeyFinalURL])
+	assert.Equal(t, site.URL+"/articles/1", out.Metadata[KeyCanonical])
+	assert.Equal(t, site.URL+"/img/cover.png", out.Metadata[KeyImage])
+	assert.Equal(t, http.StatusOK, out.Metadata[KeyStatus])
+	assert.Equal(t, "2024-05-01T12:00:00Z", out.Metadata[KeyFetchedAt])
+	assert.Equal(t, map[string]string{
+		"title": "OpenGraph title", "description": "OpenGraph description",
+		"image": site.URL + "/img/cover.png", "type": "article",
+	}, out.Metadata[KeyOpenGraph])
+	assert.Equal(t, map[string]string{"card": "summary_large_image", "creator": "@writer"}, out.Metadata[KeyTwitterCard])
+
+	embed := out.Metadata[KeyOEmbed].(map[string]interface{})
+	assert.Equal(t, "rich", embed["type"])
+	assert.NotContains(t, embed, "nested", "only scalar oEmbed fields are kept")
+	assert.Nil(t, out.Content)
+	assert.NoError(t, interfaces.ValidateMetadata(out.Metadata))
+}
+
+func TestTransform_KeepsExistingMetadata(t *testing.T) {
+	site := fixtureSite(t)
+	data := link(site.URL + "/articles/1")
+	data.Metadata[interfaces.MetaTitle] = "Shared title"
+
+	out, err := newTransform(t, nil).Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "Shared title", out.Metadata[interfaces.MetaTitle])
+
+	data = link(site.URL + "/articles/1")
+	data.Metadata[interfaces.MetaTitle] = "Shared title"
+	out, err = newTransform(t, map[string]interface{}{"overwrite": true, "oembed": false}).Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "OpenGraph title", out.Metadata[interfaces.MetaTitle])
+	assert.NotContains(t, out.Metadata, KeyOEmbed)
+}
+
+func TestTransform_URLFromContent(t *testing.T) {
+	site := fixtureSite(t)
+	data := &interfaces.DataStream{
+		ID:      "post-2",
+		Type:    interfaces.MediaTypeLink,
+		Content: io.NopCloser(strings.NewReader(site.URL + "/articles/1\n")),
+	}
+
+	out, err := newTransform(t, nil).Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "OpenGraph title", out.Metadata[interfaces.MetaTitle])
+
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, site.URL+"/articles/1\n", string(b), "content is passed on unconsumed")
+}
+
+func TestTransform_HTMLSnapshot(t *testing.T) {
+	site := fixtureSite(t)
+	tr := newTransform(t, map[string]interface{}{"snapshot": "html"})
+	data := link(site.URL + "/articles/1")
+	data.Metadata["filename"] = "post-1.txt"
+
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "text/html; charset=utf-8", out.Headers["Content-Type"])
+	assert.Equal(t, "post-1.html", out.Metadata["filename"])
+	assert.Equal(t, SnapshotHTML, out.Metadata[KeySnapshot])
+
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	page := string(b)
+	assert.NotContains(t, page, "<script")
+	assert.NotContains(t, page, "onload")
+	assert.NotContains(t, page, "srcset")
+	assert.NotContains(t, page, `rel="stylesheet"`)
+	assert.Contains(t, page, `<style>body { background: url("data:image/png;base64,`)
+	assert.Contains(t, page, `<img src="data:image/png;base64,`)
+	assert.Contains(t, page, `href="`+site.URL+`/about"`)
+	assert.Contains(t, page, `<meta name="media-sync:source" content="`+site.URL+`/articles/1"/>`)
+	assert.Contains(t, page, `<meta name="media-sync:captured" content="2024-05-01T12:00:00Z"/>`)
+}
+
+func TestTransform_ResourceLimit(t *testing.T) {
+	site := fixtureSite(t)
+	tr := newTransform(t, map[string]interface{}{"snapshot": "html", "max_resources": 0})
+
+	out, err := tr.Transform(context.Background(), link(site.URL+"/articles/1"))
+	require.NoError(t, err)
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "data:")
+	assert.Contains(t, string(b), `<img src="`+site.URL+`/img/cover.png"`, "references stay absolute")
+}
+
+func TestTransform_WARCSnapshot(t *testing.T) {
+	site := fixtureSite(t)
+	tr := newTransform(t, map[string]interface{}{"snapshot": "warc"})
+
+	out, err := tr.Transform(context.Background(), link(site.URL+"/short"))
+	require.NoError(t, err)
+	assert.Equal(t, "application/warc", out.Headers["Content-Type"])
+	assert.Equal(t, "127.0.0.1.warc", out.Metadata["filename"])
+
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	warc := string(b)
+	assert.True(t, strings.HasPrefix(warc, "WARC/1.1\r\nWARC-Type: warcinfo\r\n"))
+	assert.Contains(t, warc, "WARC-Type: response\r\n")
+	assert.Contains(t, warc, "WARC-Target-URI: "+site.URL+"/articles/1\r\n")
+	assert.Contains(t, warc, "WARC-Date: 2024-05-01T12:00:00Z\r\n")
+	assert.Contains(t, warc, "Content-Type: application/http;msgtype=response\r\n")
+	assert.Contains(t, warc, "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, warc, "WARC-Block-Digest: sha1:")
+	assert.Contains(t, warc, "\r\n\r\n"+articleHTML+"\r\n\r\n")
+}
+
+func TestTransform_FetchFailure(t *testing.T) {
+	site := fixtureSite(t)
+	tr := newTransform(t, map[string]interface{}{"snapshot": "warc"})
+
+	out, err := tr.Transform(context.Background(), link(site.URL+"/missing"))
+	require.NoError(t, err, "unreachable links pass through")
+	assert.Contains(t, out.Metadata[KeyError], "404")
+	assert.NotContains(t, out.Metadata, KeySnapshot)
+}
+
+func TestTransform_RefusesPrivateAddresses(t *testing.T) {
+	site := fixtureSite(t)
+	p, err := NewTransform(plugins.PluginConfig{Name: "linkpreserve", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+
+	out, err := p.(*Transform).Transform(context.Background(), link(site.URL+"/articles/1"))
+	require.NoError(t, err)
+	assert.Contains(t, out.Metadata[KeyError], ErrPrivateAddress.Error())
+	assert.NotContains(t, out.Metadata, interfaces.MetaTitle)
+}
+
+func TestTransform_PassThrough(t *testing.T) {
+	tr := newTransform(t, nil)
+	photo := &interfaces.DataStream{ID: "p", Type: interfaces.MediaTypePhoto}
+	out, err := tr.Transform(context.Background(), photo)
+	require.NoError(t, err)
+	assert.Same(t, photo, out)
+
+	_, err = tr.Transform(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestConfigure_Invalid(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "linkpreserve", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"snapshot": "pdf"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"max_resources": -1}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"max_bytes": 10}), plugins.ErrInvalidConfig)
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "photo"}))
+	assert.NoError(t, tr.ValidateSchema(interfaces.Schema{Type: "link"}))
+}
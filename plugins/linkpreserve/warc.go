@@ -0,0 +1,87 @@
+package linkpreserve
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// warcVersion is the WARC format written by writeWARC
+const warcVersion = "WARC/1.1"
+
+// writeWARC renders a warcinfo record followed by a response record for
+// the fetched page. The HTTP header block is rebuilt from the decoded
+// response, so transfer and content encodings are dropped and
+// Content-Length matches the stored body.
+func writeWARC(resp *response, filename string, fetchedAt time.Time) []byte {
+	date := fetchedAt.UTC().Format(time.RFC3339)
+	infoID := recordID()
+
+	var buf bytes.Buffer
+	info := []byte("software: media-sync linkpreserve\r\nformat: WARC File Format 1.1\r\n")
+	writeRecord(&buf, [][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", infoID},
+		{"WARC-Date", date},
+		{"WARC-Filename", filename},
+		{"Content-Type", "application/warc-fields"},
+	}, info)
+
+	var block bytes.Buffer
+	fmt.Fprintf(&block, "%s %d %s\r\n", resp.Proto, resp.Status, resp.StatusText)
+	header := resp.Header.Clone()
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(&block, "%s: %s\r\n", key, value)
+		}
+	}
+	block.WriteString("\r\n")
+	block.Write(resp.Body)
+
+	writeRecord(&buf, [][2]string{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", recordID()},
+		{"WARC-Warcinfo-ID", infoID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", resp.URL.String()},
+		{"WARC-Payload-Digest", digest(resp.Body)},
+		{"WARC-Block-Digest", digest(block.Bytes())},
+		{"Content-Type", "application/http;msgtype=response"},
+	}, block.Bytes())
+	return buf.Bytes()
+}
+
+func writeRecord(buf *bytes.Buffer, fields [][2]string, block []byte) {
+	buf.WriteString(warcVersion + "\r\n")
+	for _, f := range fields {
+		fmt.Fprintf(buf, "%s: %s\r\n", f[0], f[1])
+	}
+	fmt.Fprintf(buf, "Content-Length: %d\r\n\r\n", len(block))
+	buf.Write(block)
+	buf.WriteString("\r\n\r\n")
+}
+
+func recordID() string {
+	return "<urn:uuid:" + uuid.NewString() + ">"
+}
+
+// digest is the labelled base32 SHA-1 digest conventionally used in WARC
+// headers
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
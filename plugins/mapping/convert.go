@@ -0,0 +1,196 @@
+package mapping
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// timeLayouts are the date forms services and embedded metadata use
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05 MST", // Tumblr
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006:01:02 15:04:05", // EXIF
+	time.RFC1123Z,
+	time.RFC1123,
+	"2006-01-02",
+}
+
+// convert turns a source value into the Metadata representation of a
+// canonical field type. Empty values return nil without an error.
+func convert(ft interfaces.FieldType, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		v = strings.TrimSpace(s)
+		if v == "" {
+			return nil, nil
+		}
+	}
+
+	switch ft {
+	case interfaces.FieldString:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case fmt.Stringer:
+			return s.String(), nil
+		}
+		if f, err := number(v); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64), nil
+		}
+
+	case interfaces.FieldStrings:
+		return stringList(v)
+
+	case interfaces.FieldTime:
+		ts, err := timeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return ts.UTC().Format(time.RFC3339), nil
+
+	case interfaces.FieldNumber:
+		return number(v)
+
+	case interfaces.FieldInteger:
+		f, err := number(v)
+		if err != nil {
+			return nil, err
+		}
+		if f < 0 || f != math.Trunc(f) {
+			return nil, fmt.Errorf("%v is not a non-negative integer", v)
+		}
+		return int(f), nil
+
+	case interfaces.FieldSeconds:
+		d, err := seconds(v)
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("%v is negative", v)
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to %s", v, ft)
+}
+
+// stringList accepts lists and comma-separated strings, dropping empty entries
+func stringList(v interface{}) (interface{}, error) {
+	var items []string
+	switch list := v.(type) {
+	case string:
+		items = strings.Split(list, ",")
+	case []string:
+		items = list
+	case []interface{}:
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("list contains %T, not strings", item)
+			}
+			items = append(items, s)
+		}
+	default:
+		return nil, fmt.Errorf("cannot convert %T to a list of strings", v)
+	}
+
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// timeValue parses the layouts in timeLayouts, or Unix seconds or
+// milliseconds
+func timeValue(v interface{}) (time.Time, error) {
+	if ts, ok := v.(time.Time); ok {
+		return ts, nil
+	}
+	if s, ok := v.(string); ok {
+		for _, layout := range timeLayouts {
+			if ts, err := time.Parse(layout, s); err == nil {
+				return ts, nil
+			}
+		}
+	}
+	f, err := number(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unrecognized time %v", v)
+	}
+	if f > 1e12 {
+		return time.UnixMilli(int64(f)), nil
+	}
+	return time.Unix(int64(f), 0), nil
+}
+
+// seconds accepts numbers of seconds, Go durations ("1m30s") and clock
+// forms ("01:02:03.5", "02:03")
+func seconds(v interface{}) (float64, error) {
+	if d, ok := v.(time.Duration); ok {
+		return d.Seconds(), nil
+	}
+	if f, err := number(v); err == nil {
+		return f, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("cannot convert %T to seconds", v)
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return d.Seconds(), nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("unrecognized duration %q", s)
+	}
+	var total float64
+	for _, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("unrecognized duration %q", s)
+		}
+		total = total*60 + f
+	}
+	return total, nil
+}
+
+// number accepts numeric types and numeric strings
+func number(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint:
+		return float64(n), nil
+	case uint32:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
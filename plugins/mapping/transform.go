@@ -0,0 +1,290 @@
+// Package mapping provides a transform that fills the canonical metadata
+// model from the differently named fields each service produces.
+package mapping
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// MetaInvalid lists the canonical fields whose source values could not be
+// converted, as "<field>: <reason>"
+const MetaInvalid = "mapping_invalid"
+
+// builtinSources are the source keys tried, in order, for each canonical
+// field when no mapping names them
+var builtinSources = map[string][]string{
+	interfaces.MetaTitle:       {"title", "headline", "name"},
+	interfaces.MetaDescription: {"description", "caption", "summary", "body", "content", "text"},
+	interfaces.MetaAuthor:      {"author", "creator", "artist", "blog_name", "account.username", "owner.username"},
+	interfaces.MetaTags:        {"tags", "keywords"},
+	interfaces.MetaCapturedAt:  {"captured_at", "date_taken", "datetaken"},
+	interfaces.MetaPublishedAt: {"published_at", "date", "created_at", "timestamp"},
+	interfaces.MetaSourceURL:   {"source_url", "post_url", "url", "link"},
+	interfaces.MetaLatitude:    {"gps_latitude", "latitude", "location.latitude"},
+	interfaces.MetaLongitude:   {"gps_longitude", "longitude", "location.longitude"},
+	interfaces.MetaAltitude:    {"gps_altitude", "altitude"},
+	interfaces.MetaWidth:       {"width", "original_width"},
+	interfaces.MetaHeight:      {"height", "original_height"},
+	interfaces.MetaDuration:    {"duration", "duration_seconds"},
+}
+
+// mapping lists the source keys for each canonical field; dotted keys
+// reach into nested metadata
+type mapping map[string][]string
+
+// Transform writes each canonical field from the first source key with a
+// convertible value. The mapping for a stream is chosen by
+// Context.Source from services, falling back to default and then to
+// built-in aliases for each field.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu         sync.RWMutex
+	services   map[string]mapping
+	defaults   mapping
+	builtins   bool
+	overwrite  bool
+	keepSource bool
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+	_ interfaces.SchemaProvider   = (*Transform)(nil)
+)
+
+// NewTransform creates a metadata mapping transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin: plugins.NewBasePlugin(config, "transform", "Maps service metadata onto the canonical metadata model"),
+		services:   map[string]mapping{},
+		builtins:   true,
+		keepSource: true,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	serviceSettings, err := settings.Map("services")
+	if err != nil {
+		return err
+	}
+	services := make(map[string]mapping, len(serviceSettings))
+	for service := range serviceSettings {
+		fields, err := serviceSettings.Map(service)
+		if err != nil {
+			return err
+		}
+		if services[service], err = parseMapping(fields, "services."+service); err != nil {
+			return err
+		}
+	}
+
+	defaultSettings, err := settings.Map("default")
+	if err != nil {
+		return err
+	}
+	defaults, err := parseMapping(defaultSettings, "default")
+	if err != nil {
+		return err
+	}
+
+	builtins, err := settings.Bool("builtin_aliases", true)
+	if err != nil {
+		return err
+	}
+	overwrite, err := settings.Bool("overwrite", false)
+	if err != nil {
+		return err
+	}
+	keepSource, err := settings.Bool("keep_source", true)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.services = services
+	t.defaults = defaults
+	t.builtins = builtins
+	t.overwrite = overwrite
+	t.keepSource = keepSource
+	return nil
+}
+
+// parseMapping reads canonical field names to a source key or list of keys
+func parseMapping(s plugins.Settings, path string) (mapping, error) {
+	m := make(mapping, len(s))
+	for field := range s {
+		if _, ok := interfaces.CanonicalFields[field]; !ok {
+			return nil, fmt.Errorf("%w: %s.%s is not a canonical field", plugins.ErrInvalidConfig, path, field)
+		}
+		sources, err := s.StringSlice(field)
+		if err != nil || len(sources) == 0 {
+			return nil, fmt.Errorf("%w: %s.%s must name a source key or list of keys", plugins.ErrInvalidConfig, path, field)
+		}
+		m[field] = sources
+	}
+	return m, nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"canonical_fields": canonicalFields(),
+		}},
+	}
+}
+
+// ValidateSchema rejects schemas that give a canonical field another type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	return interfaces.CheckCanonicalSchema(schema)
+}
+
+// InputSchema accepts any metadata
+func (t *Transform) InputSchema() interfaces.Schema {
+	return interfaces.Schema{}
+}
+
+// OutputSchema declares the canonical metadata model for streams of any type
+func (t *Transform) OutputSchema() interfaces.Schema {
+	return interfaces.CanonicalSchema("")
+}
+
+// Transform fills the canonical fields of data.Metadata. A canonical value
+// already present is kept unless overwrite is set or it does not have its
+// canonical type. Fields whose sources cannot be converted are left unset
+// and listed under MetaInvalid.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if len(data.Metadata) == 0 {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	service, hasService := t.services[data.Context.Source]
+	defaults, builtins, overwrite, keepSource := t.defaults, t.builtins, t.overwrite, t.keepSource
+	t.mu.RUnlock()
+
+	md := data.Metadata
+	mapped := make(map[string]interface{})
+	used := make(map[string]bool)
+	var invalid []string
+
+	for _, field := range canonicalFields() {
+		ft := interfaces.CanonicalFields[field]
+		if current, ok := md[field]; ok && !overwrite && interfaces.ValidateMetadata(map[string]interface{}{field: current}) == nil {
+			// Normalize the representation, such as float64 widths from JSON
+			if v, err := convert(ft, current); err == nil && v != nil {
+				mapped[field] = v
+			}
+			continue
+		}
+
+		var sources []string
+		switch {
+		case hasService && len(service[field]) > 0:
+			sources = service[field]
+		case len(defaults[field]) > 0:
+			sources = defaults[field]
+		case builtins:
+			sources = builtinSources[field]
+		}
+
+		var reasons []string
+		for _, source := range sources {
+			raw, ok := lookup(md, source)
+			if !ok || raw == nil {
+				continue
+			}
+			v, err := convert(ft, raw)
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("%s: %v", source, err))
+				continue
+			}
+			if v == nil {
+				continue
+			}
+			mapped[field] = v
+			if source != field {
+				used[source] = true
+			}
+			reasons = nil
+			break
+		}
+		if _, ok := mapped[field]; !ok && len(reasons) > 0 {
+			invalid = append(invalid, field+": "+strings.Join(reasons, "; "))
+		}
+	}
+
+	for field, v := range mapped {
+		md[field] = v
+	}
+	// Values that are still not canonical, such as an unmappable field that
+	// was already present, are removed so the result always validates
+	for _, field := range canonicalFields() {
+		if v, ok := md[field]; ok && interfaces.ValidateMetadata(map[string]interface{}{field: v}) != nil {
+			delete(md, field)
+			invalid = append(invalid, fmt.Sprintf("%s: removed %T value", field, v))
+		}
+	}
+	if !keepSource {
+		for source := range used {
+			if _, canonical := interfaces.CanonicalFields[source]; !canonical {
+				delete(md, source)
+			}
+		}
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		md[MetaInvalid] = invalid
+	}
+
+	if err := interfaces.ValidateMetadata(md); err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("mapped metadata of %s is not canonical: %w", data.ID, err)
+	}
+	t.RecordError(nil)
+	return data, nil
+}
+
+// lookup reads key from metadata, following dots through nested maps when
+// the dotted key itself is absent
+func lookup(metadata map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := metadata[key]; ok {
+		return v, true
+	}
+	var v interface{} = metadata
+	for _, part := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func canonicalFields() []string {
+	fields := make([]string, 0, len(interfaces.CanonicalFields))
+	for field := range interfaces.CanonicalFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,173 @@
+package mapping
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+	"github.com/sho7650/media-sync/pkg/core/schema"
+)
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "mapping", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+func stream(source string, metadata map[string]interface{}) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "item-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: metadata,
+		Context:  interfaces.StreamContext{Source: source},
+	}
+}
+
+func TestTransform_ServiceMapping(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{
+		"services": map[string]interface{}{
+			"tumblr": map[string]interface{}{
+				"title":       []interface{}{"summary", "slug"},
+				"description": "caption",
+				"author":      "blog.name",
+			},
+		},
+		"keep_source": false,
+	})
+
+	data := stream("tumblr", map[string]interface{}{
+		"summary":   "  ",
+		"slug":      "sunset-at-the-beach",
+		"caption":   "<p>Lovely</p>",
+		"blog":      map[string]interface{}{"name": "alice"},
+		"tags":      "beach, sunset,,",
+		"date":      "2024-02-03 10:20:30 GMT",
+		"post_url":  "https://alice.tumblr.com/post/1",
+		"width":     "1280",
+		"height":    float64(720),
+		"duration":  "01:02.5",
+		"note_keep": 3,
+	})
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+
+	md := out.Metadata
+	assert.Equal(t, "sunset-at-the-beach", md[interfaces.MetaTitle])
+	assert.Equal(t, "<p>Lovely</p>", md[interfaces.MetaDescription])
+	assert.Equal(t, "alice", md[interfaces.MetaAuthor])
+	assert.Equal(t, []string{"beach", "sunset"}, md[interfaces.MetaTags])
+	assert.Equal(t, "2024-02-03T10:20:30Z", md[interfaces.MetaPublishedAt])
+	assert.Equal(t, "https://alice.tumblr.com/post/1", md[interfaces.MetaSourceURL])
+	assert.Equal(t, 1280, md[interfaces.MetaWidth])
+	assert.Equal(t, 720, md[interfaces.MetaHeight])
+	assert.Equal(t, 62.5, md[interfaces.MetaDuration])
+
+	assert.NotContains(t, md, "slug")
+	assert.NotContains(t, md, "caption")
+	assert.NotContains(t, md, "post_url")
+	assert.Contains(t, md, "summary", "skipped sources are kept")
+	assert.Contains(t, md, "note_keep")
+	assert.NoError(t, interfaces.ValidateMetadata(md))
+
+	declared, err := schema.Compile(tr.OutputSchema().Metadata)
+	require.NoError(t, err)
+	assert.NoError(t, declared.Validate(md))
+}
+
+func TestTransform_KeepsCanonicalValues(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{
+		"default": map[string]interface{}{"title": "name"},
+	})
+
+	out, err := tr.Transform(context.Background(), stream("flickr", map[string]interface{}{
+		"title":        "Original",
+		"name":         "Alias",
+		"tags":         []interface{}{"a", 2},
+		"keywords":     []string{"paris"},
+		"published_at": "not a date",
+		"gps_latitude": "48.5",
+		"creator":      "bob",
+		"captured_at":  "2024:02:03 10:20:30",
+	}))
+	require.NoError(t, err)
+
+	md := out.Metadata
+	assert.Equal(t, "Original", md[interfaces.MetaTitle])
+	assert.Equal(t, []string{"paris"}, md[interfaces.MetaTags])
+	assert.Equal(t, 48.5, md[interfaces.MetaLatitude])
+	assert.Equal(t, "bob", md[interfaces.MetaAuthor])
+	assert.Equal(t, "2024-02-03T10:20:30Z", md[interfaces.MetaCapturedAt])
+	assert.NotContains(t, md, interfaces.MetaPublishedAt)
+	assert.Equal(t, []string{
+		`published_at: published_at: unrecognized time not a date`,
+		`published_at: removed string value`,
+	}, md[MetaInvalid])
+
+	tr = newTransform(t, map[string]interface{}{
+		"default":   map[string]interface{}{"title": "name"},
+		"overwrite": true,
+	})
+	out, err = tr.Transform(context.Background(), stream("flickr", map[string]interface{}{"title": "Original", "name": "Alias"}))
+	require.NoError(t, err)
+	assert.Equal(t, "Alias", out.Metadata[interfaces.MetaTitle])
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		ft   interfaces.FieldType
+		in   interface{}
+		want interface{}
+	}{
+		{interfaces.FieldString, 42, "42"},
+		{interfaces.FieldTime, float64(1706955630), "2024-02-03T10:20:30Z"},
+		{interfaces.FieldTime, int64(1706955630000), "2024-02-03T10:20:30Z"},
+		{interfaces.FieldTime, "2024-02-03", "2024-02-03T00:00:00Z"},
+		{interfaces.FieldSeconds, "1m30s", 90.0},
+		{interfaces.FieldSeconds, "1:00:01", 3601.0},
+		{interfaces.FieldInteger, "640", 640},
+		{interfaces.FieldStrings, []interface{}{" a ", ""}, []string{"a"}},
+		{interfaces.FieldStrings, "", nil},
+	}
+	for _, tt := range tests {
+		got, err := convert(tt.ft, tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	for _, bad := range []struct {
+		ft interfaces.FieldType
+		in interface{}
+	}{
+		{interfaces.FieldInteger, "12.5"},
+		{interfaces.FieldNumber, "north"},
+		{interfaces.FieldSeconds, "1:xx"},
+		{interfaces.FieldString, []string{"a"}},
+	} {
+		_, err := convert(bad.ft, bad.in)
+		assert.Error(t, err, bad.in)
+	}
+}
+
+func TestTransform_ConfigureAndSchema(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "mapping", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{
+		"services": map[string]interface{}{"tumblr": map[string]interface{}{"caption": "body"}},
+	}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{
+		"default": map[string]interface{}{"title": []interface{}{}},
+	}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"services": "tumblr"}), plugins.ErrInvalidConfig)
+
+	assert.NoError(t, tr.ValidateSchema(interfaces.CanonicalSchema(interfaces.MediaTypeVideo)))
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Fields: map[string]interface{}{"width": "string"}}))
+}
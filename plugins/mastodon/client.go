@@ -0,0 +1,431 @@
+package mastodon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Attachment is a media attachment as returned by the media endpoints
+type Attachment struct {
+	ID          string  `json:"id"`
+	Type        string  `json:"type"`
+	URL         *string `json:"url"`
+	Description string  `json:"description"`
+}
+
+// Status is the subset of status fields the plugin records
+type Status struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// StatusRequest holds the parameters for creating a status
+type StatusRequest struct {
+	Status         string   `json:"status,omitempty"`
+	MediaIDs       []string `json:"media_ids,omitempty"`
+	Visibility     string   `json:"visibility,omitempty"`
+	SpoilerText    string   `json:"spoiler_text,omitempty"`
+	Sensitive      bool     `json:"sensitive,omitempty"`
+	Language       string   `json:"language,omitempty"`
+	IdempotencyKey string   `json:"-"`
+}
+
+// MediaUpload holds the file and accessibility text for an attachment
+type MediaUpload struct {
+	Filename    string
+	ContentType string
+	Description string
+	Content     io.Reader
+}
+
+// Client is a Mastodon-compatible API client that honours the server's rate
+// limit headers and waits for asynchronous media processing
+type Client struct {
+	baseURL    *url.URL
+	token      string
+	httpClient *http.Client
+	maxRetries int
+
+	// PollInterval is the delay between media processing checks
+	PollInterval time.Duration
+	// ProcessingTimeout bounds how long to wait for media processing
+	ProcessingTimeout time.Duration
+
+	mu        sync.Mutex
+	remaining int
+	resetAt   time.Time
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewClient creates a client for the server at baseURL
+func NewClient(baseURL, token string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server url: unsupported scheme %q", u.Scheme)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 2 * time.Minute}
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &Client{
+		baseURL:           u,
+		token:             token,
+		httpClient:        httpClient,
+		maxRetries:        3,
+		PollInterval:      time.Second,
+		ProcessingTimeout: 2 * time.Minute,
+		remaining:         -1,
+		sleep:             sleepContext,
+	}, nil
+}
+
+// SetToken replaces the access token
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// VerifyCredentials checks the access token
+func (c *Client) VerifyCredentials(ctx context.Context) error {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		return c.newRequest(ctx, http.MethodGet, "/api/v1/accounts/verify_credentials", nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer drain(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return statusError("verify credentials", resp)
+	}
+	return nil
+}
+
+// UploadMedia uploads an attachment and waits until the server has processed it.
+// The content is streamed rather than buffered; after a rate limit it is sent
+// again from the start when it can be reopened or rewound, and the upload
+// fails otherwise.
+func (c *Client) UploadMedia(ctx context.Context, media MediaUpload) (*Attachment, error) {
+	sent := false
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		content, err := rewind(media.Content, sent)
+		if err != nil {
+			return nil, err
+		}
+		sent = true
+		body, contentType := mediaForm(media, content)
+		req, err := c.newRequest(ctx, http.MethodPost, "/api/v2/media", body)
+		if err != nil {
+			_ = body.Close()
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return nil, statusError("upload media", resp)
+	}
+
+	var attachment Attachment
+	if err := json.NewDecoder(resp.Body).Decode(&attachment); err != nil {
+		return nil, fmt.Errorf("upload media: failed to decode response: %w", err)
+	}
+	if resp.StatusCode == http.StatusAccepted || attachment.URL == nil {
+		return c.waitForProcessing(ctx, attachment.ID)
+	}
+	return &attachment, nil
+}
+
+// waitForProcessing polls an attachment until the server reports it ready
+func (c *Client) waitForProcessing(ctx context.Context, id string) (*Attachment, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.ProcessingTimeout)
+	defer cancel()
+
+	for {
+		if err := c.sleep(ctx, c.PollInterval); err != nil {
+			return nil, fmt.Errorf("media %s still processing: %w", id, err)
+		}
+
+		resp, err := c.do(ctx, func() (*http.Request, error) {
+			return c.newRequest(ctx, http.MethodGet, "/api/v1/media/"+url.PathEscape(id), nil)
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("media %s still processing: %w", id, ctx.Err())
+			}
+			return nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			drain(resp.Body)
+			continue
+		case http.StatusOK:
+			var attachment Attachment
+			err := json.NewDecoder(resp.Body).Decode(&attachment)
+			drain(resp.Body)
+			if err != nil {
+				return nil, fmt.Errorf("media %s: failed to decode response: %w", id, err)
+			}
+			if attachment.URL == nil {
+				continue
+			}
+			return &attachment, nil
+		default:
+			defer drain(resp.Body)
+			return nil, statusError("media "+id, resp)
+		}
+	}
+}
+
+// CreateStatus posts a status; the idempotency key makes retries safe
+func (c *Client) CreateStatus(ctx context.Context, status StatusRequest) (*Status, error) {
+	payload, err := json.Marshal(status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode status: %w", err)
+	}
+
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/statuses", bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if status.IdempotencyKey != "" {
+			req.Header.Set("Idempotency-Key", status.IdempotencyKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer drain(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError("create status", resp)
+	}
+
+	var created Status
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("create status: failed to decode response: %w", err)
+	}
+	return &created, nil
+}
+
+// do sends the request built by build, waiting out exhausted rate limits
+// beforehand and retrying when the server answers 429
+func (c *Client) do(ctx context.Context, build func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.waitForRateLimit(ctx); err != nil {
+			return nil, err
+		}
+
+		req, err := build()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+		}
+		c.updateRateLimit(resp)
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+		drain(resp.Body)
+		if attempt >= c.maxRetries {
+			return nil, fmt.Errorf("%s %s: rate limited after %d retries", req.Method, req.URL.Path, attempt)
+		}
+
+		wait := retryAfter(resp, time.Now())
+		if err := c.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitForRateLimit blocks until the reset time when no requests remain
+func (c *Client) waitForRateLimit(ctx context.Context) error {
+	c.mu.Lock()
+	remaining, resetAt := c.remaining, c.resetAt
+	c.mu.Unlock()
+
+	if remaining != 0 {
+		return nil
+	}
+	if wait := time.Until(resetAt); wait > 0 {
+		if err := c.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.remaining = -1
+	c.mu.Unlock()
+	return nil
+}
+
+// updateRateLimit records the X-RateLimit-* headers of a response
+func (c *Client) updateRateLimit(resp *http.Response) {
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := time.Parse(time.RFC3339Nano, resp.Header.Get("X-RateLimit-Reset"))
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remaining = remaining
+	c.resetAt = reset
+}
+
+// RateLimit returns the last observed remaining request count and reset time
+func (c *Client) RateLimit() (int, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remaining, c.resetAt
+}
+
+func (c *Client) newRequest(ctx context.Context, method, p string, body io.Reader) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path = c.baseURL.Path + p
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// retryAfter derives the wait for a 429 response from Retry-After or X-RateLimit-Reset
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t.Sub(now)
+		}
+	}
+	if reset, err := time.Parse(time.RFC3339Nano, resp.Header.Get("X-RateLimit-Reset")); err == nil {
+		if wait := reset.Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	return time.Second
+}
+
+// rewind returns the content of an upload, from the start again once it has
+// been sent
+func rewind(content io.Reader, sent bool) (io.ReadCloser, error) {
+	if !sent {
+		return io.NopCloser(content), nil
+	}
+	if r, ok := content.(interfaces.ReopenableContent); ok {
+		return r.Reopen()
+	}
+	if s, ok := content.(io.Seeker); ok {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("upload media: failed to rewind content: %w", err)
+		}
+		return io.NopCloser(content), nil
+	}
+	return nil, fmt.Errorf("upload media: content cannot be sent again")
+}
+
+// mediaForm streams the multipart form of an upload through a pipe and
+// closes content once it is written
+func mediaForm(media MediaUpload, content io.ReadCloser) (io.ReadCloser, string) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		defer content.Close()
+		pw.CloseWithError(writeMediaForm(mw, media, content))
+	}()
+	return pr, mw.FormDataContentType()
+}
+
+func writeMediaForm(mw *multipart.Writer, media MediaUpload, content io.Reader) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, media.Filename))
+	contentType := media.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return fmt.Errorf("failed to read media content: %w", err)
+	}
+	if media.Description != "" {
+		if err := mw.WriteField("description", media.Description); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func statusError(op string, resp *http.Response) error {
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+		return fmt.Errorf("%s: unexpected status %s: %s", op, resp.Status, apiErr.Error)
+	}
+	return fmt.Errorf("%s: unexpected status %s", op, resp.Status)
+}
+
+func drain(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, 64*1024))
+	_ = body.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,409 @@
+package mastodon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys read and written by the output plugin. MetaStatusIDs maps
+// each instance URL to the status posted there and decides whether an item
+// is skipped; MetaStatusID holds the status of the most recent post.
+const (
+	MetaStatusID       = "mastodon_status_id"
+	MetaStatusIDs      = "mastodon_status_ids"
+	MetaStatusURL      = "mastodon_status_url"
+	MetaMediaIDs       = "mastodon_media_ids"
+	MetaVisibility     = "visibility"
+	MetaContentWarning = "content_warning"
+	MetaSensitive      = "sensitive"
+	MetaAltText        = "alt_text"
+	MetaLanguage       = "language"
+)
+
+// defaultStatusTemplate is used when no status_template setting is given.
+// Templates fail on missing keys rather than print "<no value>", so optional
+// keys are read with index, which yields nothing for them, and default.
+const defaultStatusTemplate = `{{with index . "title"}}{{.}}{{end}}{{with index . "source_url"}}
+{{.}}{{end}}{{with index . "tags"}}
+{{hashtags .}}{{end}}`
+
+var validVisibilities = map[string]bool{
+	"public":   true,
+	"unlisted": true,
+	"private":  true,
+	"direct":   true,
+}
+
+// Output cross-posts data streams to a Mastodon-compatible server. Media
+// content becomes an attachment with alt text and the status text is rendered
+// from a template over the stream metadata.
+type Output struct {
+	*plugins.BasePlugin
+
+	mu             sync.RWMutex
+	client         *Client
+	template       *template.Template
+	visibility     string
+	contentWarning string
+	maxCharacters  int
+	// attachments holds the media IDs uploaded for items whose status is
+	// not posted yet, so a retry does not upload them again
+	attachments map[string][]string
+}
+
+// Ensure Output implements the required interfaces
+var (
+	_ plugins.Plugin           = (*Output)(nil)
+	_ interfaces.OutputService = (*Output)(nil)
+)
+
+// NewOutput creates a Mastodon output plugin; it matches PluginFactoryFunc
+func NewOutput(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Output{
+		BasePlugin:    plugins.NewBasePlugin(config, "output", "Cross-posts media to Mastodon-compatible servers"),
+		visibility:    "public",
+		maxCharacters: 500,
+		attachments:   make(map[string][]string),
+	}, nil
+}
+
+// Configure applies plugin settings
+func (o *Output) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	tmpl, err := parseTemplate(settings.String("status_template", defaultStatusTemplate))
+	if err != nil {
+		return err
+	}
+
+	visibility := settings.String("visibility", "public")
+	if !validVisibilities[visibility] {
+		return fmt.Errorf("%w: unknown visibility %q", plugins.ErrInvalidConfig, visibility)
+	}
+
+	maxCharacters, err := settings.Int("max_characters", 500)
+	if err != nil {
+		return err
+	}
+	httpClient, err := o.HTTPClient(settings, httpclient.ClientOptions{DefaultTimeout: 2 * time.Minute})
+	if err != nil {
+		return err
+	}
+	pollInterval, err := settings.Duration("poll_interval", time.Second)
+	if err != nil {
+		return err
+	}
+	processingTimeout, err := settings.Duration("processing_timeout", 2*time.Minute)
+	if err != nil {
+		return err
+	}
+
+	client, err := NewClient(settings.String("url", ""), settings.String("access_token", ""), httpClient)
+	if err != nil {
+		return fmt.Errorf("%w: %v", plugins.ErrInvalidConfig, err)
+	}
+	client.PollInterval = pollInterval
+	client.ProcessingTimeout = processingTimeout
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.client = client
+	o.template = tmpl
+	o.visibility = visibility
+	o.contentWarning = settings.String("content_warning", "")
+	o.maxCharacters = maxCharacters
+	return nil
+}
+
+// Authenticate replaces the access token and verifies it with the server
+func (o *Output) Authenticate(ctx context.Context, creds interfaces.Credentials) error {
+	client := o.getClient()
+	if client == nil {
+		return fmt.Errorf("mastodon output %s is not configured", o.GetMetadata().Name)
+	}
+	if creds.Type != interfaces.AuthTypeOAuth2 && creds.Type != interfaces.AuthTypeAPIKey {
+		return fmt.Errorf("unsupported credential type: %s", creds.Type)
+	}
+	token, _ := creds.Data["access_token"].(string)
+	if token == "" {
+		return fmt.Errorf("%s credentials require an access_token", creds.Type)
+	}
+
+	client.SetToken(token)
+	return client.VerifyCredentials(ctx)
+}
+
+// Start verifies the access token
+func (o *Output) Start(ctx context.Context) error {
+	client := o.getClient()
+	if client == nil {
+		return fmt.Errorf("mastodon output %s is not configured", o.GetMetadata().Name)
+	}
+	if err := client.VerifyCredentials(ctx); err != nil {
+		return fmt.Errorf("credential check failed: %w", err)
+	}
+	return o.BasePlugin.Start(ctx)
+}
+
+// Capabilities describes the output features
+func (o *Output) Capabilities() []interfaces.Capability {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return []interfaces.Capability{
+		{Type: "output", Supported: true, Config: map[string]interface{}{
+			"api":            "mastodon",
+			"visibility":     o.visibility,
+			"max_characters": o.maxCharacters,
+		}},
+		{Type: "async_media", Supported: true},
+		{Type: "rate_limit", Supported: true},
+	}
+}
+
+// ConfigureDestination overrides the default visibility and content warning
+func (o *Output) ConfigureDestination(dest interfaces.DestinationConfig) error {
+	settings := plugins.Settings(dest.Config)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if v := settings.String("visibility", ""); v != "" {
+		if !validVisibilities[v] {
+			return fmt.Errorf("%w: unknown visibility %q", plugins.ErrInvalidConfig, v)
+		}
+		o.visibility = v
+	}
+	if cw := settings.String("content_warning", ""); cw != "" {
+		o.contentWarning = cw
+	}
+	return nil
+}
+
+// Publish uploads the stream content as an attachment, if any, and posts a
+// status. Streams already posted to this instance are skipped.
+func (o *Output) Publish(ctx context.Context, data *interfaces.DataStream) error {
+	if data == nil {
+		return fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Content != nil {
+		defer data.Content.Close()
+	}
+
+	client := o.getClient()
+	if client == nil {
+		return fmt.Errorf("mastodon output %s is not configured", o.GetMetadata().Name)
+	}
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	instance := client.baseURL.String()
+	if plugins.RemoteID(data.Metadata, MetaStatusIDs, instance) != "" {
+		return nil
+	}
+
+	request, err := o.statusRequest(data)
+	if err != nil {
+		return err
+	}
+
+	if data.Content != nil && hasAttachment(data.Type) {
+		mediaIDs := o.uploaded(data.ID)
+		if mediaIDs == nil {
+			attachment, err := client.UploadMedia(ctx, MediaUpload{
+				Filename:    attachmentName(data),
+				ContentType: data.Headers["Content-Type"],
+				Description: altText(data),
+				Content:     data.Content,
+			})
+			if err != nil {
+				o.recordResult(client, err)
+				return err
+			}
+			mediaIDs = []string{attachment.ID}
+			o.setUploaded(data.ID, mediaIDs)
+		}
+		request.MediaIDs = mediaIDs
+		data.Metadata[MetaMediaIDs] = request.MediaIDs
+	}
+
+	if request.Status == "" && len(request.MediaIDs) == 0 {
+		return fmt.Errorf("data stream %s renders an empty status", data.ID)
+	}
+
+	status, err := client.CreateStatus(ctx, request)
+	if err != nil {
+		o.recordResult(client, err)
+		return err
+	}
+
+	o.setUploaded(data.ID, nil)
+	plugins.SetRemoteID(data.Metadata, MetaStatusIDs, instance, status.ID)
+	data.Metadata[MetaStatusID] = status.ID
+	data.Metadata[MetaStatusURL] = status.URL
+	o.recordResult(client, nil)
+	return nil
+}
+
+// uploaded returns the media IDs already uploaded for an item
+func (o *Output) uploaded(id string) []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.attachments[id]
+}
+
+// setUploaded records the media IDs uploaded for an item, or forgets them
+// once its status is posted
+func (o *Output) setUploaded(id string, mediaIDs []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if mediaIDs == nil {
+		delete(o.attachments, id)
+		return
+	}
+	o.attachments[id] = mediaIDs
+}
+
+// statusRequest renders the status text and resolves per-item overrides
+func (o *Output) statusRequest(data *interfaces.DataStream) (StatusRequest, error) {
+	o.mu.RLock()
+	tmpl, visibility, contentWarning, maxCharacters := o.template, o.visibility, o.contentWarning, o.maxCharacters
+	o.mu.RUnlock()
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data.Metadata); err != nil {
+		return StatusRequest{}, fmt.Errorf("failed to render status for %s: %w", data.ID, err)
+	}
+
+	if v, ok := data.Metadata[MetaVisibility].(string); ok && v != "" {
+		if !validVisibilities[v] {
+			return StatusRequest{}, fmt.Errorf("data stream %s has unknown visibility %q", data.ID, v)
+		}
+		visibility = v
+	}
+	if cw, ok := data.Metadata[MetaContentWarning].(string); ok && cw != "" {
+		contentWarning = cw
+	}
+	sensitive, _ := data.Metadata[MetaSensitive].(bool)
+	language, _ := data.Metadata[MetaLanguage].(string)
+
+	return StatusRequest{
+		Status:         truncate(strings.TrimSpace(buf.String()), maxCharacters),
+		Visibility:     visibility,
+		SpoilerText:    contentWarning,
+		Sensitive:      sensitive || contentWarning != "",
+		Language:       language,
+		IdempotencyKey: data.ID,
+	}, nil
+}
+
+// recordResult updates health with the error and current rate limit state
+func (o *Output) recordResult(client *Client, err error) {
+	o.RecordError(err)
+	remaining, reset := client.RateLimit()
+	if remaining >= 0 {
+		o.SetHealthDetail("rate_limit_remaining", remaining)
+		o.SetHealthDetail("rate_limit_reset", reset)
+	}
+}
+
+func (o *Output) getClient() *Client {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.client
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("status").Option("missingkey=error").Funcs(template.FuncMap{
+		"hashtags": hashtags,
+		"join":     join,
+		"default":  defaultValue,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid status_template: %v", plugins.ErrInvalidConfig, err)
+	}
+	return tmpl, nil
+}
+
+// defaultValue returns v, or def when v is nil or empty, as in
+// {{index . "title" | default "Untitled"}}
+func defaultValue(def, v interface{}) interface{} {
+	if v == nil || v == "" {
+		return def
+	}
+	return v
+}
+
+// hashtags renders a list of tags as space separated hashtags
+func hashtags(v interface{}) string {
+	tags := toStrings(v)
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(strings.TrimPrefix(tag, "#")), "")
+		if tag != "" {
+			out = append(out, "#"+tag)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func join(sep string, v interface{}) string {
+	return strings.Join(toStrings(v), sep)
+}
+
+func toStrings(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			out = append(out, fmt.Sprint(item))
+		}
+		return out
+	case string:
+		return []string{list}
+	}
+	return nil
+}
+
+// truncate shortens text to max characters, ending with an ellipsis
+func truncate(text string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+func hasAttachment(t interfaces.MediaType) bool {
+	return t == interfaces.MediaTypePhoto || t == interfaces.MediaTypeVideo || t == interfaces.MediaTypeAudio
+}
+
+func altText(data *interfaces.DataStream) string {
+	for _, key := range []string{MetaAltText, "description", "title"} {
+		if v, ok := data.Metadata[key].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func attachmentName(data *interfaces.DataStream) string {
+	if name, ok := data.Metadata["filename"].(string); ok && name != "" {
+		return path.Base(name)
+	}
+	return path.Base("/"+data.ID) + media.Extension(data.Headers["Content-Type"])
+}
@@ -0,0 +1,444 @@
+package mastodon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// fakeServer is a local stand-in for a Mastodon-compatible API
+type fakeServer struct {
+	mu sync.Mutex
+
+	// processingPolls is how many times GET /api/v1/media/:id answers 206
+	processingPolls int
+	// throttleStatuses is how many status posts are answered with 429
+	throttleStatuses int
+	// throttleUploads is how many media uploads are answered with 429
+	throttleUploads int
+	// exhausted makes the next response report no remaining requests
+	exhausted bool
+
+	uploads  []map[string]string
+	statuses []map[string]interface{}
+	keys     []string
+	polls    int
+	idem     map[string]string
+	nextID   int
+}
+
+func newFakeServer(t *testing.T) (*fakeServer, *httptest.Server) {
+	t.Helper()
+
+	fake := &fakeServer{idem: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "The access token is invalid"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	remaining := 100
+	if f.exhausted {
+		remaining = 0
+		f.exhausted = false
+	}
+	w.Header().Set("X-RateLimit-Remaining", fmt.Sprint(remaining))
+	w.Header().Set("X-RateLimit-Reset", time.Now().Add(50*time.Millisecond).UTC().Format(time.RFC3339Nano))
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/accounts/verify_credentials":
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "1", "acct": "alice"})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v2/media":
+		if f.throttleUploads > 0 {
+			f.throttleUploads--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		content, _ := io.ReadAll(file)
+		f.nextID++
+		id := fmt.Sprintf("media-%d", f.nextID)
+		f.uploads = append(f.uploads, map[string]string{
+			"id":          id,
+			"filename":    header.Filename,
+			"contentType": header.Header.Get("Content-Type"),
+			"description": r.FormValue("description"),
+			"content":     string(content),
+		})
+
+		if f.processingPolls > 0 {
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "type": "image", "url": nil})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "type": "image", "url": "https://example.social/" + id})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/media/"):
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/media/")
+		f.polls++
+		if f.polls <= f.processingPolls {
+			w.WriteHeader(http.StatusPartialContent)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "url": nil})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "type": "image", "url": "https://example.social/" + id})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/statuses":
+		if f.throttleStatuses > 0 {
+			f.throttleStatuses--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		key := r.Header.Get("Idempotency-Key")
+		f.keys = append(f.keys, key)
+		if id, ok := f.idem[key]; ok && key != "" {
+			_ = json.NewEncoder(w).Encode(map[string]string{"id": id, "url": "https://example.social/@alice/" + id})
+			return
+		}
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.statuses = append(f.statuses, body)
+		id := fmt.Sprintf("%d", 1000+len(f.statuses))
+		f.idem[key] = id
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": id, "url": "https://example.social/@alice/" + id})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestOutput(t *testing.T, serverURL string, settings map[string]interface{}) *Output {
+	t.Helper()
+
+	p, err := NewOutput(plugins.PluginConfig{Name: "mastodon-test", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+	out := p.(*Output)
+
+	config := map[string]interface{}{
+		"url":           serverURL,
+		"access_token":  "test-token",
+		"poll_interval": "10ms",
+	}
+	for k, v := range settings {
+		config[k] = v
+	}
+	require.NoError(t, out.Configure(config))
+	require.NoError(t, out.Start(context.Background()))
+	return out
+}
+
+func photoStream(id string, metadata map[string]interface{}) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       id,
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: metadata,
+		Content:  io.NopCloser(strings.NewReader("jpeg-bytes")),
+		Headers:  map[string]string{"Content-Type": "image/jpeg"},
+	}
+}
+
+func TestOutput_PublishPhotoWithTemplate(t *testing.T) {
+	fake, server := newFakeServer(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{
+		"status_template": "{{.title}} {{hashtags .tags}}",
+		"visibility":      "unlisted",
+	})
+
+	data := photoStream("photo-1", map[string]interface{}{
+		"title":    "Sunset",
+		"tags":     []interface{}{"sky", "#golden hour"},
+		"alt_text": "An orange sky over the sea",
+	})
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	require.Len(t, fake.uploads, 1)
+	assert.Equal(t, "photo-1.jpg", fake.uploads[0]["filename"])
+	assert.Equal(t, "image/jpeg", fake.uploads[0]["contentType"])
+	assert.Equal(t, "An orange sky over the sea", fake.uploads[0]["description"])
+	assert.Equal(t, "jpeg-bytes", fake.uploads[0]["content"])
+
+	require.Len(t, fake.statuses, 1)
+	assert.Equal(t, "Sunset #sky #goldenhour", fake.statuses[0]["status"])
+	assert.Equal(t, "unlisted", fake.statuses[0]["visibility"])
+	assert.Equal(t, []interface{}{"media-1"}, fake.statuses[0]["media_ids"])
+	assert.Equal(t, []string{"photo-1"}, fake.keys)
+
+	assert.Equal(t, "1001", data.Metadata[MetaStatusID])
+	assert.Equal(t, "https://example.social/@alice/1001", data.Metadata[MetaStatusURL])
+	assert.Equal(t, []string{"media-1"}, data.Metadata[MetaMediaIDs])
+}
+
+func TestOutput_PerItemOverrides(t *testing.T) {
+	fake, server := newFakeServer(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{
+		"content_warning": "default warning",
+	})
+
+	data := &interfaces.DataStream{
+		ID:   "note-1",
+		Type: interfaces.MediaTypeText,
+		Metadata: map[string]interface{}{
+			"title":           "A private note",
+			"visibility":      "private",
+			"content_warning": "spoilers",
+			"language":        "en",
+		},
+	}
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	require.Len(t, fake.statuses, 1)
+	assert.Empty(t, fake.uploads)
+	assert.Equal(t, "A private note", fake.statuses[0]["status"])
+	assert.Equal(t, "private", fake.statuses[0]["visibility"])
+	assert.Equal(t, "spoilers", fake.statuses[0]["spoiler_text"])
+	assert.Equal(t, true, fake.statuses[0]["sensitive"])
+	assert.Equal(t, "en", fake.statuses[0]["language"])
+
+	data.Metadata["visibility"] = "everyone"
+	delete(data.Metadata, MetaStatusIDs)
+	assert.Error(t, out.Publish(context.Background(), data))
+}
+
+func TestOutput_WaitsForAsyncProcessing(t *testing.T) {
+	fake, server := newFakeServer(t)
+	fake.processingPolls = 2
+	out := newTestOutput(t, server.URL, nil)
+
+	data := photoStream("video-1", map[string]interface{}{"title": "Clip"})
+	data.Type = interfaces.MediaTypeVideo
+	data.Headers["Content-Type"] = "video/mp4"
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	assert.Equal(t, 3, fake.polls)
+	require.Len(t, fake.statuses, 1)
+	assert.Equal(t, []interface{}{"media-1"}, fake.statuses[0]["media_ids"])
+}
+
+func TestOutput_ProcessingTimeout(t *testing.T) {
+	fake, server := newFakeServer(t)
+	fake.processingPolls = 1 << 20
+	out := newTestOutput(t, server.URL, map[string]interface{}{"processing_timeout": "50ms"})
+
+	err := out.Publish(context.Background(), photoStream("photo-1", nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "still processing")
+	assert.Empty(t, fake.statuses)
+	assert.Equal(t, interfaces.StatusWarning, out.Health().Status)
+}
+
+func TestOutput_RetriesRateLimitedRequests(t *testing.T) {
+	fake, server := newFakeServer(t)
+	fake.throttleStatuses = 2
+	out := newTestOutput(t, server.URL, nil)
+
+	var waits []time.Duration
+	out.getClient().sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	data := &interfaces.DataStream{ID: "note-1", Type: interfaces.MediaTypeText, Metadata: map[string]interface{}{"title": "hello"}}
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	assert.Len(t, waits, 2)
+	require.Len(t, fake.statuses, 1)
+
+	fake.throttleStatuses = 10
+	data = &interfaces.DataStream{ID: "note-2", Type: interfaces.MediaTypeText, Metadata: map[string]interface{}{"title": "again"}}
+	err := out.Publish(context.Background(), data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rate limited")
+}
+
+// seekable is rewindable content, as read from a file
+type seekable struct{ *strings.Reader }
+
+func (seekable) Close() error { return nil }
+
+func TestOutput_RetriesRateLimitedUploads(t *testing.T) {
+	fake, server := newFakeServer(t)
+	out := newTestOutput(t, server.URL, nil)
+	out.getClient().sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	fake.throttleUploads = 1
+	data := photoStream("photo-1", nil)
+	data.Content = seekable{strings.NewReader("jpeg-bytes")}
+	require.NoError(t, out.Publish(context.Background(), data))
+	require.Len(t, fake.uploads, 1)
+	assert.Equal(t, "jpeg-bytes", fake.uploads[0]["content"], "the content is sent again from the start")
+
+	fake.throttleUploads = 1
+	err := out.Publish(context.Background(), photoStream("photo-2", nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot be sent again")
+}
+
+func TestOutput_RetryReusesUpload(t *testing.T) {
+	fake, server := newFakeServer(t)
+	out := newTestOutput(t, server.URL, nil)
+	out.getClient().sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	fake.throttleStatuses = 10
+	require.Error(t, out.Publish(context.Background(), photoStream("photo-1", nil)))
+	fake.throttleStatuses = 0
+	require.NoError(t, out.Publish(context.Background(), photoStream("photo-1", nil)))
+
+	assert.Len(t, fake.uploads, 1, "the attachment of the failed attempt is reused")
+	require.Len(t, fake.statuses, 1)
+	assert.Equal(t, []interface{}{"media-1"}, fake.statuses[0]["media_ids"])
+
+	require.NoError(t, out.Publish(context.Background(), photoStream("photo-1", nil)))
+	assert.Len(t, fake.uploads, 2, "attachments are forgotten once posted")
+}
+
+func TestOutput_TemplateMissingKeys(t *testing.T) {
+	fake, server := newFakeServer(t)
+	out := newTestOutput(t, server.URL, nil)
+	note := func(id string, metadata map[string]interface{}) *interfaces.DataStream {
+		return &interfaces.DataStream{ID: id, Type: interfaces.MediaTypeText, Metadata: metadata}
+	}
+
+	require.NoError(t, out.Publish(context.Background(), note("note-1", map[string]interface{}{"title": "Only a title"})))
+	require.Len(t, fake.statuses, 1)
+	assert.Equal(t, "Only a title", fake.statuses[0]["status"], "the default template skips missing keys")
+
+	out = newTestOutput(t, server.URL, map[string]interface{}{"status_template": `{{index . "title" | default "Untitled"}}`})
+	require.NoError(t, out.Publish(context.Background(), note("note-2", map[string]interface{}{})))
+	require.Len(t, fake.statuses, 2)
+	assert.Equal(t, "Untitled", fake.statuses[1]["status"])
+
+	out = newTestOutput(t, server.URL, map[string]interface{}{"status_template": "{{.title}}"})
+	err := out.Publish(context.Background(), note("note-3", map[string]interface{}{}))
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "<no value>")
+	assert.Len(t, fake.statuses, 2, "missing keys are not posted as <no value>")
+}
+
+func TestOutput_WaitsForRateLimitReset(t *testing.T) {
+	fake, server := newFakeServer(t)
+	out := newTestOutput(t, server.URL, nil)
+
+	var waits []time.Duration
+	out.getClient().sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	fake.mu.Lock()
+	fake.exhausted = true
+	fake.mu.Unlock()
+
+	first := &interfaces.DataStream{ID: "note-1", Type: interfaces.MediaTypeText, Metadata: map[string]interface{}{"title": "one"}}
+	require.NoError(t, out.Publish(context.Background(), first))
+	assert.Equal(t, 0, out.Health().Details["rate_limit_remaining"])
+
+	second := &interfaces.DataStream{ID: "note-2", Type: interfaces.MediaTypeText, Metadata: map[string]interface{}{"title": "two"}}
+	require.NoError(t, out.Publish(context.Background(), second))
+
+	require.Len(t, waits, 1)
+	assert.True(t, waits[0] > 0 && waits[0] <= 50*time.Millisecond)
+	assert.Equal(t, 100, out.Health().Details["rate_limit_remaining"])
+}
+
+func TestOutput_SkipsPublishedItems(t *testing.T) {
+	fake, server := newFakeServer(t)
+	out := newTestOutput(t, server.URL, nil)
+
+	data := photoStream("photo-1", map[string]interface{}{
+		MetaStatusIDs: map[string]interface{}{server.URL: "42"},
+	})
+	require.NoError(t, out.Publish(context.Background(), data))
+	assert.Empty(t, fake.uploads)
+	assert.Empty(t, fake.statuses)
+}
+
+func TestOutput_PostsToEachInstance(t *testing.T) {
+	first, firstServer := newFakeServer(t)
+	second, secondServer := newFakeServer(t)
+	home := newTestOutput(t, firstServer.URL, nil)
+	mirror := newTestOutput(t, secondServer.URL, nil)
+
+	// Outputs in one pipeline share the stream metadata
+	data := photoStream("photo-1", map[string]interface{}{})
+	require.NoError(t, home.Publish(context.Background(), data))
+	data.Content = io.NopCloser(strings.NewReader("jpeg-bytes"))
+	require.NoError(t, mirror.Publish(context.Background(), data))
+
+	assert.Len(t, first.statuses, 1)
+	assert.Len(t, second.statuses, 1, "the status posted to the first instance must not skip the second")
+
+	data.Content = io.NopCloser(strings.NewReader("jpeg-bytes"))
+	require.NoError(t, home.Publish(context.Background(), data))
+	assert.Len(t, first.statuses, 1, "a status is posted once per instance")
+}
+
+func TestOutput_Truncate(t *testing.T) {
+	fake, server := newFakeServer(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"max_characters": 10})
+
+	data := &interfaces.DataStream{ID: "note-1", Type: interfaces.MediaTypeText, Metadata: map[string]interface{}{"title": "ünïcode text that is long"}}
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	require.Len(t, fake.statuses, 1)
+	assert.Equal(t, "ünïcode t…", fake.statuses[0]["status"])
+}
+
+func TestOutput_ConfigValidation(t *testing.T) {
+	p, err := NewOutput(plugins.PluginConfig{Name: "mastodon-test", Type: "output"})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{"url": "ftp://example"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{"url": "https://example.social", "visibility": "everyone"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{"url": "https://example.social", "status_template": "{{.title"}), plugins.ErrInvalidConfig)
+	assert.Error(t, p.Start(context.Background()))
+}
+
+func TestOutput_Authenticate(t *testing.T) {
+	_, server := newFakeServer(t)
+
+	p, err := NewOutput(plugins.PluginConfig{Name: "mastodon-test", Type: "output"})
+	require.NoError(t, err)
+	out := p.(*Output)
+	require.NoError(t, out.Configure(map[string]interface{}{"url": server.URL, "access_token": "stale"}))
+
+	assert.Error(t, out.Start(context.Background()))
+	assert.Error(t, out.Authenticate(context.Background(), interfaces.Credentials{Type: interfaces.AuthTypeBasic}))
+
+	creds := interfaces.Credentials{Type: interfaces.AuthTypeOAuth2, Data: map[string]interface{}{"access_token": "test-token"}}
+	require.NoError(t, out.Authenticate(context.Background(), creds))
+	require.NoError(t, out.Start(context.Background()))
+}
@@ -0,0 +1,359 @@
+// Package metaextract provides a transform that copies EXIF, XMP and IPTC
+// metadata embedded in images into DataStream.Metadata.
+package metaextract
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Normalized metadata keys written by the transform
+const (
+	KeyCapturedAt   = "captured_at"
+	KeyCameraMake   = "camera_make"
+	KeyCameraModel  = "camera_model"
+	KeyLensModel    = "lens_model"
+	KeyOrientation  = "orientation"
+	KeyWidth        = "width"
+	KeyHeight       = "height"
+	KeyLatitude     = "gps_latitude"
+	KeyLongitude    = "gps_longitude"
+	KeyAltitude     = "gps_altitude"
+	KeyExposureTime = "exposure_time"
+	KeyFNumber      = "f_number"
+	KeyISO          = "iso"
+	KeyFocalLength  = "focal_length"
+	KeySoftware     = "software"
+	KeyTitle        = "title"
+	KeyDescription  = "description"
+	KeyKeywords     = "keywords"
+	KeyCreator      = "creator"
+	KeyCopyright    = "copyright"
+	KeyRating       = "rating"
+	KeyCity         = "city"
+	KeyState        = "state"
+	KeyCountry      = "country"
+	KeyHeadline     = "headline"
+
+	// KeySources lists which of exif, xmp and iptc were found
+	KeySources = "metadata_sources"
+)
+
+// defaultScanBytes covers the metadata segments of typical JPEG and HEIF files
+const defaultScanBytes = 1 << 20
+
+// Transform parses embedded image metadata from the first scan_bytes of the
+// content without consuming it: Content is replaced by a reader that yields
+// the complete original stream.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu        sync.RWMutex
+	scanBytes int
+	overwrite bool
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a metadata extraction transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin: plugins.NewBasePlugin(config, "transform", "Extracts EXIF, XMP and IPTC metadata from images"),
+		scanBytes:  defaultScanBytes,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	scanBytes, err := settings.Int("scan_bytes", defaultScanBytes)
+	if err != nil {
+		return err
+	}
+	if scanBytes < 64 {
+		return fmt.Errorf("%w: scan_bytes must be at least 64", plugins.ErrInvalidConfig)
+	}
+	overwrite, err := settings.Bool("overwrite", false)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scanBytes = scanBytes
+	t.overwrite = overwrite
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types": []string{string(interfaces.MediaTypePhoto)},
+			"formats": []string{
+				imagemeta.FormatJPEG, imagemeta.FormatTIFF, imagemeta.FormatHEIF,
+				imagemeta.FormatPNG, imagemeta.FormatWebP,
+			},
+			"sources": []string{"exif", "xmp", "iptc"},
+		}},
+	}
+}
+
+// ValidateSchema accepts photo streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypePhoto) {
+		return fmt.Errorf("metadata extraction does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform adds normalized metadata keys to photo streams. Keys already
+// present are kept unless overwrite is set; other streams pass through.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypePhoto || data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	scanBytes, overwrite := t.scanBytes, t.overwrite
+	t.mu.RUnlock()
+
+	head, content, err := media.Peek(data.Content, scanBytes)
+	data.Content = content
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+
+	blocks, err := imagemeta.Scan(head)
+	if err != nil {
+		// Not an image container we understand; nothing to extract
+		return data, nil
+	}
+
+	fields, sources := Extract(blocks)
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	for key, value := range fields {
+		if _, exists := data.Metadata[key]; exists && !overwrite {
+			if key != KeyKeywords {
+				continue
+			}
+			value = mergeKeywords(data.Metadata[key], value.([]string))
+		}
+		data.Metadata[key] = value
+	}
+	if len(sources) > 0 {
+		data.Metadata[KeySources] = sources
+	}
+	t.RecordError(nil)
+	return data, nil
+}
+
+// Extract normalizes the decoded blocks. EXIF takes precedence over XMP, and
+// XMP over IPTC; keywords from XMP and IPTC are merged.
+func Extract(blocks imagemeta.Blocks) (map[string]interface{}, []string) {
+	fields := make(map[string]interface{})
+	var sources []string
+
+	set := func(key string, value interface{}) {
+		if _, ok := fields[key]; ok {
+			return
+		}
+		if s, ok := value.(string); ok && s == "" {
+			return
+		}
+		fields[key] = value
+	}
+
+	if blocks.EXIF != nil {
+		if e, err := imagemeta.ParseEXIF(blocks.EXIF); err == nil {
+			sources = append(sources, "exif")
+			extractEXIF(e, set)
+		}
+	}
+
+	var keywords []string
+	if blocks.XMP != nil {
+		if x, err := imagemeta.ParseXMP(blocks.XMP); err == nil {
+			sources = append(sources, "xmp")
+			extractXMP(x, set)
+			keywords = append(keywords, x["dc:subject"]...)
+		}
+	}
+	if blocks.IPTC != nil {
+		if i := imagemeta.ParseIPTC(blocks.IPTC); len(i) > 0 {
+			sources = append(sources, "iptc")
+			extractIPTC(i, set)
+			keywords = append(keywords, i[imagemeta.IPTCKeywords]...)
+		}
+	}
+	if len(keywords) > 0 {
+		fields[KeyKeywords] = mergeKeywords(nil, keywords)
+	}
+	return fields, sources
+}
+
+func extractEXIF(e *imagemeta.EXIF, set func(string, interface{})) {
+	if captured, ok := e.DateTimeOriginal(); ok {
+		set(KeyCapturedAt, captured.Format(time.RFC3339))
+	}
+	set(KeyCameraMake, e.String(imagemeta.TagMake))
+	set(KeyCameraModel, e.String(imagemeta.TagModel))
+	set(KeyLensModel, e.String(imagemeta.TagLensModel))
+	set(KeySoftware, e.String(imagemeta.TagSoftware))
+	set(KeyCreator, e.String(imagemeta.TagArtist))
+	set(KeyCopyright, e.String(imagemeta.TagCopyright))
+	set(KeyDescription, e.String(imagemeta.TagImageDescription))
+	if tag, ok := e.Lookup(imagemeta.TagOrientation); ok {
+		if _, ok := tag.Uint(0); ok {
+			set(KeyOrientation, e.Orientation())
+		}
+	}
+
+	if lat, lon, ok := e.Coordinates(); ok {
+		set(KeyLatitude, round(lat, 6))
+		set(KeyLongitude, round(lon, 6))
+	}
+	if alt, ok := e.Altitude(); ok {
+		set(KeyAltitude, round(alt, 2))
+	}
+
+	if tag, ok := e.Lookup(imagemeta.TagExposureTime); ok {
+		if num, den, ok := tag.Rational(0); ok && num > 0 && den > 0 {
+			if num < den && den%num == 0 {
+				set(KeyExposureTime, fmt.Sprintf("1/%d", den/num))
+			} else {
+				set(KeyExposureTime, strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64))
+			}
+		}
+	}
+	if tag, ok := e.Lookup(imagemeta.TagFNumber); ok {
+		if v, ok := tag.Float(0); ok {
+			set(KeyFNumber, round(v, 1))
+		}
+	}
+	if tag, ok := e.Lookup(imagemeta.TagFocalLength); ok {
+		if v, ok := tag.Float(0); ok {
+			set(KeyFocalLength, round(v, 1))
+		}
+	}
+	if tag, ok := e.Lookup(imagemeta.TagISO); ok {
+		if v, ok := tag.Uint(0); ok {
+			set(KeyISO, int(v))
+		}
+	}
+	if tag, ok := e.Lookup(imagemeta.TagPixelXDimension); ok {
+		if v, ok := tag.Uint(0); ok {
+			set(KeyWidth, int(v))
+		}
+	}
+	if tag, ok := e.Lookup(imagemeta.TagPixelYDimension); ok {
+		if v, ok := tag.Uint(0); ok {
+			set(KeyHeight, int(v))
+		}
+	}
+}
+
+func extractXMP(x imagemeta.XMP, set func(string, interface{})) {
+	for _, name := range []string{"exif:DateTimeOriginal", "photoshop:DateCreated", "xmp:CreateDate"} {
+		if t, ok := parseXMPDate(x.First(name)); ok {
+			set(KeyCapturedAt, t.Format(time.RFC3339))
+			break
+		}
+	}
+	set(KeyTitle, x.First("dc:title"))
+	set(KeyDescription, x.First("dc:description"))
+	set(KeyCreator, strings.Join(x["dc:creator"], ", "))
+	set(KeyCopyright, x.First("dc:rights"))
+	set(KeyHeadline, x.First("photoshop:Headline"))
+	set(KeyCity, x.First("photoshop:City"))
+	set(KeyState, x.First("photoshop:State"))
+	set(KeyCountry, x.First("photoshop:Country"))
+	if rating, err := strconv.Atoi(x.First("xmp:Rating")); err == nil {
+		set(KeyRating, rating)
+	}
+}
+
+func extractIPTC(i imagemeta.IPTC, set func(string, interface{})) {
+	if date := i.First(imagemeta.IPTCDateCreated); date != "" {
+		if t, err := time.Parse("20060102", date); err == nil {
+			if clock, err := time.Parse("150405-0700", i.First(imagemeta.IPTCTimeCreated)); err == nil {
+				t = time.Date(t.Year(), t.Month(), t.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, clock.Location())
+			}
+			set(KeyCapturedAt, t.Format(time.RFC3339))
+		}
+	}
+	set(KeyTitle, i.First(imagemeta.IPTCObjectName))
+	set(KeyDescription, i.First(imagemeta.IPTCCaption))
+	set(KeyHeadline, i.First(imagemeta.IPTCHeadline))
+	set(KeyCreator, i.First(imagemeta.IPTCByline))
+	set(KeyCopyright, i.First(imagemeta.IPTCCopyright))
+	set(KeyCity, i.First(imagemeta.IPTCCity))
+	set(KeyState, i.First(imagemeta.IPTCProvinceState))
+	set(KeyCountry, i.First(imagemeta.IPTCCountry))
+}
+
+// parseXMPDate accepts the ISO 8601 subsets used by XMP date properties
+func parseXMPDate(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// mergeKeywords appends new keywords to an existing value, dropping duplicates
+func mergeKeywords(existing interface{}, keywords []string) []string {
+	var merged []string
+	switch v := existing.(type) {
+	case []string:
+		merged = append(merged, v...)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				merged = append(merged, s)
+			}
+		}
+	case string:
+		merged = append(merged, v)
+	}
+	merged = append(merged, keywords...)
+
+	seen := make(map[string]bool, len(merged))
+	unique := merged[:0]
+	for _, k := range merged {
+		k = strings.TrimSpace(k)
+		if k == "" || seen[strings.ToLower(k)] {
+			continue
+		}
+		seen[strings.ToLower(k)] = true
+		unique = append(unique, k)
+	}
+	return unique
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
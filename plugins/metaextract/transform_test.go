@@ -0,0 +1,240 @@
+package metaextract
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+type entry struct {
+	id    uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func ascii(id uint16, s string) entry {
+	return entry{id, imagemeta.TypeASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func rational(id uint16, pairs ...uint32) entry {
+	b := make([]byte, 0, len(pairs)*4)
+	for _, p := range pairs {
+		b = binary.BigEndian.AppendUint32(b, p)
+	}
+	return entry{id, imagemeta.TypeRational, uint32(len(pairs) / 2), b}
+}
+
+func short(id uint16, v uint16) entry {
+	return entry{id, imagemeta.TypeShort, 1, binary.BigEndian.AppendUint16(nil, v)}
+}
+
+// writeIFD appends a big-endian IFD at the end of buf, with value data after it
+func writeIFD(buf []byte, entries []entry) []byte {
+	dataOffset := len(buf) + 2 + len(entries)*12 + 4
+	var data []byte
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(entries)))
+	for _, e := range entries {
+		buf = binary.BigEndian.AppendUint16(buf, e.id)
+		buf = binary.BigEndian.AppendUint16(buf, e.typ)
+		buf = binary.BigEndian.AppendUint32(buf, e.count)
+		if len(e.value) <= 4 {
+			buf = append(buf, append(e.value, make([]byte, 4-len(e.value))...)...)
+			continue
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(dataOffset+len(data)))
+		data = append(data, e.value...)
+	}
+	buf = binary.BigEndian.AppendUint32(buf, 0)
+	return append(buf, data...)
+}
+
+// sampleEXIF builds IFD0 with a GPS pointer, then the GPS IFD
+func sampleEXIF() []byte {
+	ifd0 := []entry{
+		ascii(imagemeta.TagMake, "Canon"),
+		ascii(imagemeta.TagModel, "EOS R6"),
+		short(imagemeta.TagOrientation, 8),
+		ascii(imagemeta.TagDateTime, "2024:02:03 10:20:30"),
+		{imagemeta.TagGPSIFD, imagemeta.TypeLong, 1, make([]byte, 4)},
+	}
+	size := 2 + len(ifd0)*12 + 4
+	for _, e := range ifd0 {
+		if len(e.value) > 4 {
+			size += len(e.value)
+		}
+	}
+	binary.BigEndian.PutUint32(ifd0[4].value, uint32(8+size))
+
+	b := append([]byte("MM\x00\x2a"), 0, 0, 0, 8)
+	b = writeIFD(b, ifd0)
+	return writeIFD(b, []entry{
+		ascii(imagemeta.TagGPSLatitudeRef, "N"),
+		rational(imagemeta.TagGPSLatitude, 48, 1, 51, 1, 30, 1),
+		ascii(imagemeta.TagGPSLongitudeRef, "E"),
+		rational(imagemeta.TagGPSLongitude, 2, 1, 17, 1, 40, 1),
+	})
+}
+
+const sampleXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="5">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Eiffel Tower</rdf:li></rdf:Alt></dc:title>
+<dc:subject><rdf:Bag><rdf:li>paris</rdf:li><rdf:li>travel</rdf:li></rdf:Bag></dc:subject>
+</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+func sampleIPTC() []byte {
+	var b []byte
+	for _, ds := range []struct {
+		id    byte
+		value string
+	}{{imagemeta.IPTCKeywords, "Travel"}, {imagemeta.IPTCKeywords, "tower"}, {imagemeta.IPTCCity, "Paris"}, {imagemeta.IPTCCaption, "Caption from IPTC"}} {
+		b = append(b, 0x1C, 2, ds.id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(ds.value)))
+		b = append(b, ds.value...)
+	}
+	return b
+}
+
+func segment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func sampleJPEG(t *testing.T) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	require.NoError(t, jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 4, 4)), nil))
+
+	photoshop := append([]byte("Photoshop 3.0\x008BIM\x04\x04\x00\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(sampleIPTC())))...)
+	photoshop = append(photoshop, sampleIPTC()...)
+
+	out := []byte{0xFF, 0xD8}
+	out = append(out, segment(0xE1, append([]byte("Exif\x00\x00"), sampleEXIF()...))...)
+	out = append(out, segment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), sampleXMP...))...)
+	out = append(out, segment(0xED, photoshop)...)
+	return append(out, encoded.Bytes()[2:]...)
+}
+
+func newTestTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+
+	p, err := NewTransform(plugins.PluginConfig{Name: "metaextract-test", Type: "transform", Version: "1.0.0"})
+	require.NoError(t, err)
+	if settings != nil {
+		require.NoError(t, p.Configure(settings))
+	}
+	return p.(*Transform)
+}
+
+func TestTransform_ExtractsAndPreservesContent(t *testing.T) {
+	tr := newTestTransform(t, nil)
+	original := sampleJPEG(t)
+
+	data := &interfaces.DataStream{
+		ID:       "photo-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"title": "From the source API"},
+		Content:  io.NopCloser(bytes.NewReader(original)),
+	}
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+
+	content, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, original, content)
+	require.NoError(t, out.Content.Close())
+
+	md := out.Metadata
+	assert.Equal(t, "2024-02-03T10:20:30Z", md[KeyCapturedAt])
+	assert.Equal(t, "Canon", md[KeyCameraMake])
+	assert.Equal(t, "EOS R6", md[KeyCameraModel])
+	assert.Equal(t, 8, md[KeyOrientation])
+	assert.InDelta(t, 48.858333, md[KeyLatitude], 1e-6)
+	assert.InDelta(t, 2.294444, md[KeyLongitude], 1e-6)
+	assert.Equal(t, "From the source API", md[KeyTitle], "existing keys are kept")
+	assert.Equal(t, "Caption from IPTC", md[KeyDescription])
+	assert.Equal(t, "Paris", md[KeyCity])
+	assert.Equal(t, 5, md[KeyRating])
+	assert.Equal(t, []string{"paris", "travel", "tower"}, md[KeyKeywords])
+	assert.Equal(t, []string{"exif", "xmp", "iptc"}, md[KeySources])
+}
+
+func TestTransform_Overwrite(t *testing.T) {
+	tr := newTestTransform(t, map[string]interface{}{"overwrite": true})
+
+	data := &interfaces.DataStream{
+		ID:       "photo-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"title": "From the source API"},
+		Content:  io.NopCloser(bytes.NewReader(sampleJPEG(t))),
+	}
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "Eiffel Tower", out.Metadata[KeyTitle])
+}
+
+func TestTransform_KeywordsMergeWithExisting(t *testing.T) {
+	tr := newTestTransform(t, nil)
+
+	data := &interfaces.DataStream{
+		ID:       "photo-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"keywords": []interface{}{"favourite", "Paris"}},
+		Content:  io.NopCloser(bytes.NewReader(sampleJPEG(t))),
+	}
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"favourite", "Paris", "travel", "tower"}, out.Metadata[KeyKeywords])
+}
+
+func TestTransform_PassesThroughOtherStreams(t *testing.T) {
+	tr := newTestTransform(t, nil)
+
+	text := &interfaces.DataStream{ID: "note", Type: interfaces.MediaTypeText, Content: io.NopCloser(bytes.NewReader([]byte("hello")))}
+	out, err := tr.Transform(context.Background(), text)
+	require.NoError(t, err)
+	assert.Same(t, text, out)
+
+	unknown := &interfaces.DataStream{ID: "gif", Type: interfaces.MediaTypePhoto, Content: io.NopCloser(bytes.NewReader([]byte("GIF89a....")))}
+	out, err = tr.Transform(context.Background(), unknown)
+	require.NoError(t, err)
+	content, _ := io.ReadAll(out.Content)
+	assert.Equal(t, "GIF89a....", string(content))
+	assert.Empty(t, out.Metadata)
+}
+
+func TestTransform_ScanLimitKeepsStreamIntact(t *testing.T) {
+	tr := newTestTransform(t, map[string]interface{}{"scan_bytes": 64})
+	original := sampleJPEG(t)
+
+	data := &interfaces.DataStream{ID: "photo-1", Type: interfaces.MediaTypePhoto, Content: io.NopCloser(bytes.NewReader(original))}
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+
+	content, _ := io.ReadAll(out.Content)
+	assert.Equal(t, original, content)
+	assert.NotContains(t, out.Metadata, KeyCameraMake)
+}
+
+func TestTransform_ValidateSchemaAndConfig(t *testing.T) {
+	tr := newTestTransform(t, nil)
+
+	assert.NoError(t, tr.ValidateSchema(interfaces.Schema{Type: "photo"}))
+	assert.NoError(t, tr.ValidateSchema(interfaces.Schema{}))
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "video"}))
+
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"scan_bytes": 10}), plugins.ErrInvalidConfig)
+}
@@ -0,0 +1,152 @@
+// Package perceptual provides a transform that records perceptual hashes of
+// photos so storage can find near-duplicates across services.
+package perceptual
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/media/imaging"
+	"github.com/sho7650/media-sync/internal/media/phash"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+const (
+	defaultMaxBytes  = 256 << 20
+	defaultMaxPixels = 100_000_000
+)
+
+// Transform decodes photos and stores each configured hash in Metadata
+// under the algorithm name ("dhash", "phash") as 16 hex digits, the form
+// SQLiteStorage indexes when the item is stored.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu         sync.RWMutex
+	algorithms []string
+	maxBytes   int64
+	maxPixels  int
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a perceptual hashing transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin: plugins.NewBasePlugin(config, "transform", "Computes perceptual hashes of photos for near-duplicate detection"),
+		algorithms: phash.Algorithms,
+		maxBytes:   defaultMaxBytes,
+		maxPixels:  defaultMaxPixels,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	algorithms, err := settings.StringSlice("algorithms")
+	if err != nil {
+		return err
+	}
+	if len(algorithms) == 0 {
+		algorithms = phash.Algorithms
+	}
+	for _, algorithm := range algorithms {
+		if algorithm != phash.DHash && algorithm != phash.PHash {
+			return fmt.Errorf("%w: unknown algorithm %q", plugins.ErrInvalidConfig, algorithm)
+		}
+	}
+
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	maxPixels, err := settings.Int("max_pixels", defaultMaxPixels)
+	if err != nil {
+		return err
+	}
+	if maxBytes <= 0 || maxPixels <= 0 {
+		return fmt.Errorf("%w: max_bytes and max_pixels must be positive", plugins.ErrInvalidConfig)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.algorithms = algorithms
+	t.maxBytes = int64(maxBytes)
+	t.maxPixels = maxPixels
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types": []string{string(interfaces.MediaTypePhoto)},
+			"algorithms":  phash.Algorithms,
+		}},
+	}
+}
+
+// ValidateSchema accepts photo streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypePhoto) {
+		return fmt.Errorf("perceptual hashing does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform hashes the upright image, after applying EXIF orientation, so
+// a rotated re-encode matches its original. Content passes through
+// unchanged; photos no decoder supports are left unhashed.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypePhoto || data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	algorithms, maxBytes, maxPixels := t.algorithms, t.maxBytes, t.maxPixels
+	t.mu.RUnlock()
+
+	content, err := media.ReadAll(data.Content, maxBytes)
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+	data.Content = io.NopCloser(bytes.NewReader(content))
+
+	img, _, err := imaging.Decode(content, maxPixels)
+	if errors.Is(err, imaging.ErrUnsupportedFormat) {
+		return data, nil
+	}
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to decode %s: %w", data.ID, err)
+	}
+	img = imaging.Orient(img, imaging.Orientation(content))
+
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	for _, algorithm := range algorithms {
+		h, err := phash.Compute(algorithm, img)
+		if err != nil {
+			return nil, err
+		}
+		data.Metadata[algorithm] = phash.Format(h)
+	}
+	t.RecordError(nil)
+	return data, nil
+}
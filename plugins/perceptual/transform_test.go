@@ -0,0 +1,95 @@
+package perceptual
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media/phash"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "perceptual", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+// pattern draws a textured image; smooth gradients leave too little
+// structure in the low frequencies for a stable pHash
+func pattern() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 128, 96))
+	for y := 0; y < 96; y++ {
+		for x := 0; x < 128; x++ {
+			v := 0.5 + 0.25*math.Sin(float64(x)/9) + 0.25*math.Cos(float64(y)/7+float64(x)/30)
+			if math.Hypot(float64(x-40), float64(y-60)) < 20 {
+				v = 1 - v
+			}
+			img.Set(x, y, color.Gray{Y: uint8(v * 255)})
+		}
+	}
+	return img
+}
+
+func photo(content []byte) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:      "item-1",
+		Type:    interfaces.MediaTypePhoto,
+		Content: io.NopCloser(bytes.NewReader(content)),
+	}
+}
+
+func TestTransform_HashesSurviveReencoding(t *testing.T) {
+	var pngBuf, jpegBuf bytes.Buffer
+	require.NoError(t, png.Encode(&pngBuf, pattern()))
+	require.NoError(t, jpeg.Encode(&jpegBuf, pattern(), &jpeg.Options{Quality: 30}))
+
+	tr := newTransform(t, nil)
+	a, err := tr.Transform(context.Background(), photo(pngBuf.Bytes()))
+	require.NoError(t, err)
+	b, err := tr.Transform(context.Background(), photo(jpegBuf.Bytes()))
+	require.NoError(t, err)
+
+	passed, err := io.ReadAll(a.Content)
+	require.NoError(t, err)
+	assert.Equal(t, pngBuf.Bytes(), passed)
+
+	for _, algorithm := range phash.Algorithms {
+		ha, err := phash.Parse(a.Metadata[algorithm].(string))
+		require.NoError(t, err)
+		hb, err := phash.Parse(b.Metadata[algorithm].(string))
+		require.NoError(t, err)
+		assert.LessOrEqual(t, phash.Distance(ha, hb), 4, algorithm)
+	}
+}
+
+func TestTransform_AlgorithmsAndPassthrough(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, pattern()))
+
+	tr := newTransform(t, map[string]interface{}{"algorithms": []interface{}{"dhash"}})
+	out, err := tr.Transform(context.Background(), photo(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Contains(t, out.Metadata, phash.DHash)
+	assert.NotContains(t, out.Metadata, phash.PHash)
+
+	out, err = tr.Transform(context.Background(), photo([]byte("\x00\x00\x00\x18ftypheic")))
+	require.NoError(t, err)
+	assert.Empty(t, out.Metadata)
+
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"algorithms": "ahash"}), plugins.ErrInvalidConfig)
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "video"}))
+}
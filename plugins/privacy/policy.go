@@ -0,0 +1,192 @@
+package privacy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+)
+
+// Policy selects which metadata the transform removes
+type Policy string
+
+const (
+	// PolicyAll removes all metadata except the EXIF orientation and colour space
+	PolicyAll Policy = "all"
+	// PolicyGPS removes location data only
+	PolicyGPS Policy = "gps"
+	// PolicyPersonal removes location, device serials, maker notes, authorship
+	// contact details and free-form text chunks
+	PolicyPersonal Policy = "personal"
+	// PolicyAllowlist keeps only the entries named in the allowlist
+	PolicyAllowlist Policy = "allowlist"
+)
+
+// Entry names used in the allowlist and in the removal record have the
+// form "<source>/<name>", for example "exif/Make", "xmp/dc:title",
+// "iptc/Keywords" or "png/Title".
+const (
+	sourceEXIF = "exif"
+	sourceXMP  = "xmp"
+	sourceIPTC = "iptc"
+	sourcePNG  = "png"
+	sourceJPEG = "jpeg"
+)
+
+// personalEXIFTags identify the device or its owner
+var personalEXIFTags = map[uint16]bool{
+	imagemeta.TagMakerNote:        true,
+	imagemeta.TagUserComment:      true,
+	imagemeta.TagCameraOwnerName:  true,
+	imagemeta.TagBodySerialNumber: true,
+	imagemeta.TagLensSerialNumber: true,
+	0xA420:                        true, // ImageUniqueID
+	0x9C9C:                        true, // XPComment
+	0x9C9D:                        true, // XPAuthor
+}
+
+// personalXMP lists properties identifying the device, owner or location
+var personalXMP = map[string]bool{
+	"aux:SerialNumber":                true,
+	"aux:LensSerialNumber":            true,
+	"aux:OwnerName":                   true,
+	"exifEX:BodySerialNumber":         true,
+	"exifEX:LensSerialNumber":         true,
+	"exifEX:CameraOwnerName":          true,
+	"exif:UserComment":                true,
+	"photoshop:City":                  true,
+	"photoshop:State":                 true,
+	"photoshop:Country":               true,
+	"Iptc4xmpCore:Location":           true,
+	"Iptc4xmpCore:CountryCode":        true,
+	"Iptc4xmpCore:CreatorContactInfo": true,
+	"Iptc4xmpExt:LocationCreated":     true,
+	"Iptc4xmpExt:LocationShown":       true,
+}
+
+// personalIPTC lists datasets naming or locating people
+var personalIPTC = map[int]bool{
+	imagemeta.IPTCByline:        true,
+	85:                          true, // By-lineTitle
+	imagemeta.IPTCCity:          true,
+	92:                          true, // Sub-location
+	imagemeta.IPTCProvinceState: true,
+	100:                         true, // Country-PrimaryLocationCode
+	imagemeta.IPTCCountry:       true,
+	118:                         true, // Contact
+	122:                         true, // Writer-Editor
+}
+
+// rules decides, per metadata entry, whether it is kept
+type rules struct {
+	policy Policy
+	allow  map[string]bool
+}
+
+func newRules(policy Policy, allowlist []string) (rules, error) {
+	switch policy {
+	case PolicyAll, PolicyGPS, PolicyPersonal:
+	case PolicyAllowlist:
+		if len(allowlist) == 0 {
+			return rules{}, fmt.Errorf("allowlist policy requires a non-empty allowlist")
+		}
+	default:
+		return rules{}, fmt.Errorf("unknown policy %q", policy)
+	}
+
+	allow := make(map[string]bool, len(allowlist))
+	for _, name := range allowlist {
+		allow[strings.TrimSpace(name)] = true
+	}
+	return rules{policy: policy, allow: allow}, nil
+}
+
+// keepEXIF reports whether an EXIF tag survives. The orientation is always
+// kept so the image still displays the right way up; XMP and IPTC copies
+// embedded in IFD0 are dropped because they are not edited.
+func (r rules) keepEXIF(ifd imagemeta.IFD, id uint16) bool {
+	if ifd == imagemeta.IFD0 {
+		switch id {
+		case imagemeta.TagOrientation:
+			return true
+		case imagemeta.TagXMP, imagemeta.TagIPTC:
+			return r.policy == PolicyAllowlist && r.allow[sourceEXIF+"/"+imagemeta.TagName(ifd, id)]
+		}
+	}
+	switch r.policy {
+	case PolicyAll:
+		return ifd == imagemeta.IFDExif && id == 0xA001 // ColorSpace
+	case PolicyGPS:
+		return ifd != imagemeta.IFDGPS
+	case PolicyPersonal:
+		return ifd != imagemeta.IFDGPS && !personalEXIFTags[id]
+	default:
+		return r.allow[sourceEXIF+"/"+imagemeta.TagName(ifd, id)]
+	}
+}
+
+// keepXMPPacket reports whether an XMP packet is kept at all
+func (r rules) keepXMPPacket() bool {
+	return r.policy != PolicyAll
+}
+
+// removeXMP reports whether an XMP property is removed from a kept packet
+func (r rules) removeXMP(name string) bool {
+	gps := strings.HasPrefix(name, "exif:GPS")
+	switch r.policy {
+	case PolicyAll:
+		return true
+	case PolicyGPS:
+		return gps
+	case PolicyPersonal:
+		return gps || personalXMP[name]
+	default:
+		return !r.allow[sourceXMP+"/"+name]
+	}
+}
+
+// keepIPTCBlock reports whether the IPTC block is kept at all
+func (r rules) keepIPTCBlock() bool {
+	return r.policy != PolicyAll
+}
+
+// keepIPTC reports whether an application record dataset survives
+func (r rules) keepIPTC(dataset int) bool {
+	switch r.policy {
+	case PolicyAll:
+		return false
+	case PolicyGPS:
+		return true
+	case PolicyPersonal:
+		return !personalIPTC[dataset]
+	default:
+		return r.allow[sourceIPTC+"/"+imagemeta.IPTCName(dataset)]
+	}
+}
+
+// keepText reports whether a PNG text chunk with the given keyword survives
+func (r rules) keepText(keyword string) bool {
+	switch r.policy {
+	case PolicyGPS:
+		return true
+	case PolicyAllowlist:
+		return r.allow[sourcePNG+"/"+keyword]
+	}
+	return false
+}
+
+// keepComment reports whether JPEG COM segments survive
+func (r rules) keepComment() bool {
+	switch r.policy {
+	case PolicyGPS:
+		return true
+	case PolicyAllowlist:
+		return r.allow[sourceJPEG+"/COM"]
+	}
+	return false
+}
+
+// removesGPS reports whether location data is removed
+func (r rules) removesGPS() bool {
+	return r.policy != PolicyAllowlist || !r.allow[sourceEXIF+"/GPSLatitude"]
+}
@@ -0,0 +1,406 @@
+package privacy
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+)
+
+// maxSegmentPayload is the largest JPEG marker segment payload
+const maxSegmentPayload = 0xFFFF - 2
+
+// WebP VP8X feature flags for embedded metadata
+const (
+	vp8xFlagEXIF = 0x08
+	vp8xFlagXMP  = 0x04
+)
+
+// stripper rewrites one image, collecting the names of removed entries
+type stripper struct {
+	rules   rules
+	removed []string
+	seen    map[string]bool
+}
+
+func newStripper(r rules) *stripper {
+	return &stripper{rules: r, seen: make(map[string]bool)}
+}
+
+func (s *stripper) remove(source, name string) {
+	entry := source + "/" + name
+	if !s.seen[entry] {
+		s.seen[entry] = true
+		s.removed = append(s.removed, entry)
+	}
+}
+
+// strip rewrites b according to the rules. Only metadata containers are
+// touched; image data is copied byte for byte. b is returned unchanged when
+// nothing was removed.
+func (s *stripper) strip(format string, b []byte) ([]byte, error) {
+	var (
+		out []byte
+		err error
+	)
+	switch format {
+	case imagemeta.FormatJPEG:
+		out, err = s.jpeg(b)
+	case imagemeta.FormatPNG:
+		out, err = s.png(b)
+	case imagemeta.FormatWebP:
+		out, err = s.webp(b)
+	default:
+		return nil, fmt.Errorf("cannot rewrite %s images", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(s.removed) == 0 {
+		return b, nil
+	}
+	return out, nil
+}
+
+// exif filters a TIFF-structured EXIF block; nil means drop the block.
+// Unparseable blocks are dropped whole since their contents are unknown.
+func (s *stripper) exif(block []byte) []byte {
+	e, err := imagemeta.ParseEXIF(block)
+	if err != nil {
+		s.remove(sourceEXIF, "*")
+		return nil
+	}
+
+	before := len(s.removed)
+	filter := func(ifd imagemeta.IFD, tags []imagemeta.Tag) []imagemeta.Tag {
+		kept := tags[:0:0]
+		for _, t := range tags {
+			switch t.ID {
+			case imagemeta.TagExifIFD, imagemeta.TagGPSIFD, imagemeta.TagInteropIFD:
+				// Pointers are regenerated by Encode
+				kept = append(kept, t)
+				continue
+			}
+			if s.rules.keepEXIF(ifd, t.ID) {
+				kept = append(kept, t)
+			} else {
+				s.remove(sourceEXIF, imagemeta.TagName(ifd, t.ID))
+			}
+		}
+		return kept
+	}
+	e.IFD0 = filter(imagemeta.IFD0, e.IFD0)
+	e.Exif = filter(imagemeta.IFDExif, e.Exif)
+	e.GPS = filter(imagemeta.IFDGPS, e.GPS)
+
+	// The thumbnail is a second, unedited copy of the picture
+	if e.Thumbnail && s.rules.policy != PolicyGPS {
+		s.remove(sourceEXIF, "Thumbnail")
+	}
+	if len(s.removed) == before {
+		return block
+	}
+
+	if countTags(e.IFD0) == 0 && len(e.Exif) == 0 && len(e.GPS) == 0 {
+		return nil
+	}
+	return e.Encode()
+}
+
+// countTags counts entries other than sub-IFD pointers
+func countTags(tags []imagemeta.Tag) int {
+	n := 0
+	for _, t := range tags {
+		if t.ID != imagemeta.TagExifIFD && t.ID != imagemeta.TagGPSIFD {
+			n++
+		}
+	}
+	return n
+}
+
+// xmp filters an XMP packet; nil means drop the packet
+func (s *stripper) xmp(packet []byte) []byte {
+	if !s.rules.keepXMPPacket() {
+		s.remove(sourceXMP, "*")
+		return nil
+	}
+	out, removed, err := imagemeta.StripXMP(packet, s.rules.removeXMP)
+	if err != nil {
+		s.remove(sourceXMP, "*")
+		return nil
+	}
+	for _, name := range removed {
+		s.remove(sourceXMP, name)
+	}
+	return out
+}
+
+// photoshop filters the IPTC resource of a Photoshop image resource
+// section; nil means drop the section
+func (s *stripper) photoshop(section []byte) []byte {
+	if !s.rules.keepIPTCBlock() {
+		s.remove(sourceIPTC, "*")
+		return nil
+	}
+
+	resources := imagemeta.ParsePhotoshopResources(section)
+	before := len(s.removed)
+	kept := resources[:0:0]
+	for _, res := range resources {
+		switch res.ID {
+		case imagemeta.PhotoshopIPTCDigest:
+			// Dropped below if the IPTC block changes
+		case imagemeta.PhotoshopIPTC:
+			res.Data = s.iptc(res.Data)
+			if len(res.Data) == 0 {
+				continue
+			}
+		}
+		kept = append(kept, res)
+	}
+	if len(s.removed) == before {
+		return section
+	}
+
+	out := kept[:0]
+	for _, res := range kept {
+		if res.ID != imagemeta.PhotoshopIPTCDigest {
+			out = append(out, res)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return imagemeta.EncodePhotoshopResources(out)
+}
+
+// iptc filters the application record datasets of an IIM block
+func (s *stripper) iptc(block []byte) []byte {
+	datasets := imagemeta.ParseIPTCDatasets(block)
+	kept := datasets[:0:0]
+	for _, ds := range datasets {
+		if ds.Record == 2 && ds.Dataset != 0 && !s.rules.keepIPTC(ds.Dataset) {
+			s.remove(sourceIPTC, imagemeta.IPTCName(ds.Dataset))
+			continue
+		}
+		kept = append(kept, ds)
+	}
+	if len(kept) == len(datasets) {
+		return block
+	}
+	for _, ds := range kept {
+		// Record 1 (envelope) and the record version alone carry no content
+		if ds.Record == 2 && ds.Dataset != 0 {
+			return imagemeta.EncodeIPTC(kept)
+		}
+	}
+	return nil
+}
+
+func (s *stripper) jpeg(b []byte) ([]byte, error) {
+	segments, sos := imagemeta.JPEGSegments(b)
+	if sos < 0 {
+		return nil, fmt.Errorf("truncated jpeg")
+	}
+
+	out := make([]byte, 0, len(b))
+	out = append(out, b[:2]...)
+	for _, seg := range segments {
+		var (
+			header string
+			body   []byte
+		)
+		switch {
+		case seg.Marker == 0xE1 && bytes.HasPrefix(seg.Payload, []byte(imagemeta.JPEGEXIFHeader)):
+			header = imagemeta.JPEGEXIFHeader
+			body = s.exif(seg.Payload[len(header):])
+		case seg.Marker == 0xE1 && bytes.HasPrefix(seg.Payload, []byte(imagemeta.JPEGXMPHeader)):
+			header = imagemeta.JPEGXMPHeader
+			body = s.xmp(seg.Payload[len(header):])
+		case seg.Marker == 0xE1 && bytes.HasPrefix(seg.Payload, []byte(imagemeta.JPEGExtendedXMPHeader)):
+			// Extended XMP is split across segments and cannot be edited in place
+			s.remove(sourceXMP, "extended")
+			continue
+		case seg.Marker == 0xED && bytes.HasPrefix(seg.Payload, []byte(imagemeta.PhotoshopHeader)):
+			header = imagemeta.PhotoshopHeader
+			body = s.photoshop(seg.Payload[len(header):])
+		case seg.Marker == 0xFE:
+			if !s.rules.keepComment() {
+				s.remove(sourceJPEG, "COM")
+				continue
+			}
+			out = append(out, b[seg.Offset:seg.End]...)
+			continue
+		default:
+			out = append(out, b[seg.Offset:seg.End]...)
+			continue
+		}
+
+		if body == nil {
+			continue
+		}
+		size := len(header) + len(body)
+		if size > maxSegmentPayload {
+			return nil, fmt.Errorf("rewritten %s segment exceeds %d bytes", header[:len(header)-1], maxSegmentPayload)
+		}
+		out = append(out, 0xFF, seg.Marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(size+2))
+		out = append(out, header...)
+		out = append(out, body...)
+	}
+	return append(out, b[sos:]...), nil
+}
+
+func (s *stripper) png(b []byte) ([]byte, error) {
+	chunks := imagemeta.PNGChunks(b)
+	if len(chunks) == 0 || chunks[len(chunks)-1].Type != "IEND" {
+		return nil, fmt.Errorf("truncated png")
+	}
+
+	out := make([]byte, 0, len(b))
+	out = append(out, b[:chunks[0].Offset]...)
+	for _, chunk := range chunks {
+		switch chunk.Type {
+		case "eXIf":
+			if data := s.exif(chunk.Data); data != nil {
+				out = appendPNGChunk(out, chunk.Type, data)
+			}
+			continue
+		case "tEXt", "zTXt", "iTXt":
+			keyword := chunk.Data
+			if i := bytes.IndexByte(keyword, 0); i >= 0 {
+				keyword = keyword[:i]
+			}
+			if chunk.Type == "iTXt" && string(keyword) == imagemeta.PNGXMPKeyword {
+				data, err := s.pngXMP(chunk.Data)
+				if err != nil {
+					return nil, err
+				}
+				if data != nil {
+					out = appendPNGChunk(out, chunk.Type, data)
+				}
+				continue
+			}
+			if !s.rules.keepText(string(keyword)) {
+				s.remove(sourcePNG, string(keyword))
+				continue
+			}
+		}
+		out = append(out, b[chunk.Offset:chunk.End]...)
+	}
+	return append(out, b[chunks[len(chunks)-1].End:]...), nil
+}
+
+// pngXMP filters the XMP packet of an iTXt chunk, which is rewritten
+// uncompressed; nil means drop the chunk
+func (s *stripper) pngXMP(data []byte) ([]byte, error) {
+	// keyword NUL, compression flag, method, language NUL, translated keyword NUL, text
+	rest := data[len(imagemeta.PNGXMPKeyword)+1:]
+	if len(rest) < 2 {
+		return nil, fmt.Errorf("malformed png xmp chunk")
+	}
+	compressed := rest[0] == 1
+	fields := rest[2:]
+	var header []byte
+	for i := 0; i < 2; i++ {
+		idx := bytes.IndexByte(fields, 0)
+		if idx < 0 {
+			return nil, fmt.Errorf("malformed png xmp chunk")
+		}
+		header = append(header, fields[:idx+1]...)
+		fields = fields[idx+1:]
+	}
+
+	packet := fields
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(fields))
+		if err != nil {
+			return nil, fmt.Errorf("malformed png xmp chunk: %w", err)
+		}
+		packet, err = io.ReadAll(zr)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("malformed png xmp chunk: %w", err)
+		}
+	}
+
+	before := len(s.removed)
+	stripped := s.xmp(packet)
+	if stripped == nil {
+		return nil, nil
+	}
+	if len(s.removed) == before {
+		return data, nil
+	}
+	out := append([]byte(imagemeta.PNGXMPKeyword), 0, 0, 0)
+	out = append(out, header...)
+	return append(out, stripped...), nil
+}
+
+func appendPNGChunk(out []byte, chunkType string, data []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	start := len(out)
+	out = append(out, chunkType...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+}
+
+func (s *stripper) webp(b []byte) ([]byte, error) {
+	chunks := imagemeta.WebPChunks(b)
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("truncated webp")
+	}
+
+	type chunk struct {
+		fourCC string
+		data   []byte
+	}
+	var (
+		kept  []chunk
+		flags byte
+	)
+	for _, c := range chunks {
+		data := c.Data
+		switch c.FourCC {
+		case "EXIF":
+			prefixed := bytes.HasPrefix(data, []byte(imagemeta.JPEGEXIFHeader))
+			block := s.exif(bytes.TrimPrefix(data, []byte(imagemeta.JPEGEXIFHeader)))
+			if block == nil {
+				continue
+			}
+			if prefixed {
+				block = append([]byte(imagemeta.JPEGEXIFHeader), block...)
+			}
+			data = block
+			flags |= vp8xFlagEXIF
+		case "XMP ":
+			if data = s.xmp(data); data == nil {
+				continue
+			}
+			flags |= vp8xFlagXMP
+		}
+		kept = append(kept, chunk{fourCC: c.FourCC, data: data})
+	}
+
+	out := make([]byte, 12, len(b))
+	copy(out, b[:12])
+	for _, c := range kept {
+		data := c.data
+		if c.fourCC == "VP8X" && len(data) > 0 {
+			data = append([]byte(nil), data...)
+			data[0] = data[0]&^(vp8xFlagEXIF|vp8xFlagXMP) | flags
+		}
+		out = append(out, c.fourCC...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(data)))
+		out = append(out, data...)
+		if len(data)%2 != 0 {
+			out = append(out, 0)
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
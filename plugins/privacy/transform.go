@@ -0,0 +1,181 @@
+// Package privacy provides a transform that strips location and personal
+// metadata from images before they are published.
+package privacy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform
+const (
+	// KeyRemoved lists the removed entries as "<source>/<name>"
+	KeyRemoved = "privacy_removed"
+	// KeyPolicy records the policy that was applied
+	KeyPolicy = "privacy_policy"
+)
+
+// locationKeys are the metadata keys other transforms derive from GPS data
+var locationKeys = []string{"gps_latitude", "gps_longitude", "gps_altitude"}
+
+// defaultMaxBytes bounds the size of images held in memory while rewriting
+const defaultMaxBytes = 256 << 20
+
+// Transform rewrites JPEG, PNG and WebP images without the metadata the
+// configured policy removes. Pixel data is copied unchanged.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu                sync.RWMutex
+	rules             rules
+	maxBytes          int64
+	rejectUnsupported bool
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a metadata stripping transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin:        plugins.NewBasePlugin(config, "transform", "Strips location and personal metadata from images"),
+		rules:             rules{policy: PolicyPersonal},
+		maxBytes:          defaultMaxBytes,
+		rejectUnsupported: true,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	policy := settings.String("policy", string(PolicyPersonal))
+	allow, err := settings.StringSlice("allow")
+	if err != nil {
+		return err
+	}
+	r, err := newRules(Policy(policy), allow)
+	if err != nil {
+		return fmt.Errorf("%w: %v", plugins.ErrInvalidConfig, err)
+	}
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	if maxBytes <= 0 {
+		return fmt.Errorf("%w: max_bytes must be positive", plugins.ErrInvalidConfig)
+	}
+	rejectUnsupported, err := settings.Bool("reject_unsupported", true)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rules = r
+	t.maxBytes = int64(maxBytes)
+	t.rejectUnsupported = rejectUnsupported
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types": []string{string(interfaces.MediaTypePhoto)},
+			"formats":     []string{imagemeta.FormatJPEG, imagemeta.FormatPNG, imagemeta.FormatWebP},
+			"policies":    []string{string(PolicyAll), string(PolicyGPS), string(PolicyPersonal), string(PolicyAllowlist)},
+		}},
+	}
+}
+
+// ValidateSchema accepts photo streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypePhoto) {
+		return fmt.Errorf("privacy transform does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform strips metadata from photo streams and records what was removed.
+// TIFF and HEIF images cannot be rewritten and, like photos in a format that
+// is not recognised, are rejected unless reject_unsupported is disabled;
+// other streams pass through.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypePhoto || data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	r, maxBytes, rejectUnsupported := t.rules, t.maxBytes, t.rejectUnsupported
+	t.mu.RUnlock()
+
+	content, err := media.ReadAll(data.Content, maxBytes)
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+	data.Content = io.NopCloser(bytes.NewReader(content))
+
+	blocks, err := imagemeta.Scan(content)
+	if err != nil {
+		// Not an image container that carries metadata we know of
+		if rejectUnsupported {
+			err := fmt.Errorf("cannot strip metadata from %s: %w", data.ID, err)
+			t.RecordError(err)
+			return nil, err
+		}
+		return data, nil
+	}
+	switch blocks.Format {
+	case imagemeta.FormatJPEG, imagemeta.FormatPNG, imagemeta.FormatWebP:
+	default:
+		if rejectUnsupported {
+			err := fmt.Errorf("cannot strip metadata from %s image %s", blocks.Format, data.ID)
+			t.RecordError(err)
+			return nil, err
+		}
+		return data, nil
+	}
+
+	s := newStripper(r)
+	stripped, err := s.strip(blocks.Format, content)
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to strip metadata from %s: %w", data.ID, err)
+	}
+
+	data.Content = io.NopCloser(bytes.NewReader(stripped))
+	if data.Headers != nil {
+		if _, ok := data.Headers["Content-Length"]; ok {
+			data.Headers["Content-Length"] = strconv.Itoa(len(stripped))
+		}
+	}
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	if r.removesGPS() {
+		for _, key := range locationKeys {
+			delete(data.Metadata, key)
+		}
+	}
+	data.Metadata[KeyPolicy] = string(r.policy)
+	data.Metadata[KeyRemoved] = append([]string{}, s.removed...)
+	t.RecordError(nil)
+	return data, nil
+}
@@ -0,0 +1,383 @@
+package privacy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func ascii(id uint16, s string) imagemeta.Tag {
+	return imagemeta.Tag{ID: id, Type: imagemeta.TypeASCII, Count: uint32(len(s) + 1), Value: append([]byte(s), 0)}
+}
+
+func short(id uint16, v uint16) imagemeta.Tag {
+	return imagemeta.Tag{ID: id, Type: imagemeta.TypeShort, Count: 1, Value: binary.BigEndian.AppendUint16(nil, v)}
+}
+
+func rational(id uint16, pairs ...uint32) imagemeta.Tag {
+	var b []byte
+	for _, p := range pairs {
+		b = binary.BigEndian.AppendUint32(b, p)
+	}
+	return imagemeta.Tag{ID: id, Type: imagemeta.TypeRational, Count: uint32(len(pairs) / 2), Value: b}
+}
+
+func sampleEXIF() []byte {
+	e := &imagemeta.EXIF{
+		Order: binary.BigEndian,
+		IFD0: []imagemeta.Tag{
+			ascii(imagemeta.TagMake, "Canon"),
+			short(imagemeta.TagOrientation, 6),
+			ascii(imagemeta.TagArtist, "Alice"),
+		},
+		Exif: []imagemeta.Tag{
+			ascii(imagemeta.TagDateTimeOriginal, "2024:02:03 10:20:30"),
+			ascii(imagemeta.TagBodySerialNumber, "0123456789"),
+			{ID: imagemeta.TagMakerNote, Type: imagemeta.TypeUndefined, Count: 8, Value: []byte("private!")},
+		},
+		GPS: []imagemeta.Tag{
+			ascii(imagemeta.TagGPSLatitudeRef, "N"),
+			rational(imagemeta.TagGPSLatitude, 35, 1, 39, 1, 2940, 100),
+		},
+	}
+	return e.Encode()
+}
+
+const sampleXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/"
+    photoshop:City="Tokyo"
+    exif:GPSLatitude="35,39.49N">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Shibuya</rdf:li></rdf:Alt></dc:title>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func sampleIPTC() []byte {
+	var out []byte
+	add := func(dataset byte, value string) {
+		out = append(out, 0x1C, 2, dataset)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(value)))
+		out = append(out, value...)
+	}
+	add(imagemeta.IPTCKeywords, "street")
+	add(imagemeta.IPTCByline, "Alice")
+	add(imagemeta.IPTCCity, "Tokyo")
+	return out
+}
+
+func pixels() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 30), G: uint8(y * 60), B: 90, A: 255})
+		}
+	}
+	return img
+}
+
+func segment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+func buildJPEG(t *testing.T) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	require.NoError(t, jpeg.Encode(&encoded, pixels(), nil))
+
+	irb := imagemeta.EncodePhotoshopResources([]imagemeta.PhotoshopResource{
+		{ID: imagemeta.PhotoshopIPTC, Data: sampleIPTC()},
+		{ID: imagemeta.PhotoshopIPTCDigest, Data: make([]byte, 16)},
+	})
+	out := []byte{0xFF, 0xD8}
+	out = append(out, segment(0xE1, append([]byte(imagemeta.JPEGEXIFHeader), sampleEXIF()...))...)
+	out = append(out, segment(0xE1, append([]byte(imagemeta.JPEGXMPHeader), sampleXMP...))...)
+	out = append(out, segment(0xED, append([]byte(imagemeta.PhotoshopHeader), irb...))...)
+	out = append(out, segment(0xFE, []byte("shot at home"))...)
+	return append(out, encoded.Bytes()[2:]...)
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func buildPNG(t *testing.T) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, pixels()))
+	b := encoded.Bytes()
+
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(b[8:]))
+	out := append([]byte(nil), b[:ihdrEnd]...)
+	out = append(out, pngChunk("eXIf", sampleEXIF())...)
+	out = append(out, pngChunk("iTXt", append([]byte(imagemeta.PNGXMPKeyword+"\x00\x00\x00\x00\x00"), sampleXMP...))...)
+	out = append(out, pngChunk("tEXt", []byte("Author\x00Alice"))...)
+	return append(out, b[ihdrEnd:]...)
+}
+
+func riffChunk(fourCC string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(fourCC), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 != 0 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func buildWebP() []byte {
+	body := []byte("WEBP")
+	body = append(body, riffChunk("VP8X", []byte{0x0C, 0, 0, 0, 7, 0, 0, 3, 0, 0})...)
+	body = append(body, riffChunk("VP8L", []byte{0x2F, 7, 0xC0, 0, 0, 0x01, 0x02})...)
+	body = append(body, riffChunk("EXIF", sampleEXIF())...)
+	body = append(body, riffChunk("XMP ", []byte(sampleXMP))...)
+	out := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body)))
+	return append(out, body...)
+}
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "privacy", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+func run(t *testing.T, tr *Transform, content []byte) (*interfaces.DataStream, []byte) {
+	t.Helper()
+	data := &interfaces.DataStream{
+		ID:       "photo-1",
+		Type:     interfaces.MediaTypePhoto,
+		Content:  io.NopCloser(bytes.NewReader(content)),
+		Headers:  map[string]string{"Content-Length": "0"},
+		Metadata: map[string]interface{}{"gps_latitude": 35.6, "title": "Shibuya"},
+	}
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	return out, b
+}
+
+func scanOf(t *testing.T, b []byte) (*imagemeta.EXIF, imagemeta.XMP, imagemeta.IPTC) {
+	t.Helper()
+	blocks, err := imagemeta.Scan(b)
+	require.NoError(t, err)
+	var (
+		e    *imagemeta.EXIF
+		x    imagemeta.XMP
+		iptc imagemeta.IPTC
+	)
+	if blocks.EXIF != nil {
+		e, err = imagemeta.ParseEXIF(blocks.EXIF)
+		require.NoError(t, err)
+	}
+	if blocks.XMP != nil {
+		x, err = imagemeta.ParseXMP(blocks.XMP)
+		require.NoError(t, err)
+	}
+	if blocks.IPTC != nil {
+		iptc = imagemeta.ParseIPTC(blocks.IPTC)
+	}
+	return e, x, iptc
+}
+
+func TestTransform_PersonalPolicyJPEG(t *testing.T) {
+	original := buildJPEG(t)
+	out, b := run(t, newTransform(t, nil), original)
+
+	removed := out.Metadata[KeyRemoved].([]string)
+	assert.Subset(t, removed, []string{
+		"exif/GPSLatitude", "exif/MakerNote", "exif/BodySerialNumber",
+		"xmp/photoshop:City", "xmp/exif:GPSLatitude",
+		"iptc/By-line", "iptc/City", "jpeg/COM",
+	})
+	assert.NotContains(t, removed, "exif/Make")
+	assert.Equal(t, "personal", out.Metadata[KeyPolicy])
+	assert.NotContains(t, out.Metadata, "gps_latitude")
+	assert.Equal(t, "Shibuya", out.Metadata["title"])
+	assert.Equal(t, strconv.Itoa(len(b)), out.Headers["Content-Length"])
+
+	e, x, iptc := scanOf(t, b)
+	require.NotNil(t, e)
+	assert.Equal(t, "Canon", e.String(imagemeta.TagMake))
+	assert.Equal(t, 6, e.Orientation())
+	_, _, ok := e.Coordinates()
+	assert.False(t, ok)
+	_, ok = e.Lookup(imagemeta.TagMakerNote)
+	assert.False(t, ok)
+	assert.Equal(t, "Shibuya", x.First("dc:title"))
+	assert.Empty(t, x["photoshop:City"])
+	assert.Equal(t, []string{"street"}, iptc[imagemeta.IPTCKeywords])
+	assert.Empty(t, iptc[imagemeta.IPTCCity])
+	assert.NotContains(t, string(b), "shot at home")
+
+	// Scan data is copied byte for byte
+	_, sos := imagemeta.JPEGSegments(original)
+	_, newSOS := imagemeta.JPEGSegments(b)
+	assert.Equal(t, original[sos:], b[newSOS:])
+	assertSamePixels(t, original, b)
+}
+
+func TestTransform_GPSPolicy(t *testing.T) {
+	out, b := run(t, newTransform(t, map[string]interface{}{"policy": "gps"}), buildJPEG(t))
+
+	removed := out.Metadata[KeyRemoved].([]string)
+	assert.ElementsMatch(t, []string{
+		"exif/GPSLatitudeRef", "exif/GPSLatitude", "xmp/exif:GPSLatitude",
+	}, removed)
+
+	e, x, iptc := scanOf(t, b)
+	_, ok := e.Lookup(imagemeta.TagMakerNote)
+	assert.True(t, ok)
+	assert.Equal(t, "Tokyo", x.First("photoshop:City"))
+	assert.Equal(t, "Alice", iptc.First(imagemeta.IPTCByline))
+	assert.Contains(t, string(b), "shot at home")
+}
+
+func TestTransform_AllPolicy(t *testing.T) {
+	out, b := run(t, newTransform(t, map[string]interface{}{"policy": "all"}), buildJPEG(t))
+
+	assert.Contains(t, out.Metadata[KeyRemoved], "xmp/*")
+	assert.Contains(t, out.Metadata[KeyRemoved], "iptc/*")
+
+	blocks, err := imagemeta.Scan(b)
+	require.NoError(t, err)
+	assert.Nil(t, blocks.XMP)
+	assert.Nil(t, blocks.IPTC)
+
+	e, err := imagemeta.ParseEXIF(blocks.EXIF)
+	require.NoError(t, err)
+	assert.Len(t, e.IFD0, 1)
+	assert.Equal(t, 6, e.Orientation())
+	assert.Empty(t, e.Exif)
+}
+
+func TestTransform_Allowlist(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{
+		"policy": "allowlist",
+		"allow":  []interface{}{"exif/Make", "xmp/dc:title", "iptc/Keywords"},
+	})
+	out, b := run(t, tr, buildJPEG(t))
+
+	e, x, iptc := scanOf(t, b)
+	assert.Equal(t, "Canon", e.String(imagemeta.TagMake))
+	assert.Empty(t, e.String(imagemeta.TagArtist))
+	assert.Empty(t, e.Exif)
+	assert.Equal(t, "Shibuya", x.First("dc:title"))
+	assert.Empty(t, x["photoshop:City"])
+	assert.Equal(t, imagemeta.IPTC{imagemeta.IPTCKeywords: {"street"}}, iptc)
+	assert.Contains(t, out.Metadata[KeyRemoved], "exif/Artist")
+}
+
+func TestTransform_PNG(t *testing.T) {
+	original := buildPNG(t)
+	out, b := run(t, newTransform(t, nil), original)
+
+	assert.Subset(t, out.Metadata[KeyRemoved], []string{"png/Author", "exif/GPSLatitude", "xmp/photoshop:City"})
+	assert.NotContains(t, string(b), "Author\x00Alice")
+
+	e, x, _ := scanOf(t, b)
+	assert.Equal(t, "Canon", e.String(imagemeta.TagMake))
+	assert.Equal(t, "Shibuya", x.First("dc:title"))
+	assertSamePixels(t, original, b)
+}
+
+func TestTransform_WebP(t *testing.T) {
+	out, b := run(t, newTransform(t, map[string]interface{}{"policy": "all"}), buildWebP())
+	assert.Contains(t, out.Metadata[KeyRemoved], "xmp/*")
+
+	assert.Equal(t, len(b)-8, int(binary.LittleEndian.Uint32(b[4:])))
+	chunks := imagemeta.WebPChunks(b)
+	var fourCCs []string
+	for _, c := range chunks {
+		fourCCs = append(fourCCs, c.FourCC)
+	}
+	assert.Equal(t, []string{"VP8X", "VP8L", "EXIF"}, fourCCs)
+	assert.Equal(t, byte(0x08), chunks[0].Data[0], "XMP flag cleared")
+	assert.Equal(t, []byte{0x2F, 7, 0xC0, 0, 0, 0x01, 0x02}, chunks[1].Data)
+}
+
+func TestTransform_NothingToRemove(t *testing.T) {
+	var encoded bytes.Buffer
+	require.NoError(t, jpeg.Encode(&encoded, pixels(), nil))
+
+	out, b := run(t, newTransform(t, nil), encoded.Bytes())
+	assert.Equal(t, encoded.Bytes(), b)
+	assert.Empty(t, out.Metadata[KeyRemoved])
+}
+
+func TestTransform_UnsupportedAndPassthrough(t *testing.T) {
+	tiff := sampleEXIF()
+	data := &interfaces.DataStream{ID: "t", Type: interfaces.MediaTypePhoto, Content: io.NopCloser(bytes.NewReader(tiff))}
+	_, err := newTransform(t, nil).Transform(context.Background(), data)
+	assert.Error(t, err)
+
+	tr := newTransform(t, map[string]interface{}{"reject_unsupported": false})
+	_, b := run(t, tr, tiff)
+	assert.Equal(t, tiff, b)
+
+	text := &interfaces.DataStream{ID: "n", Type: interfaces.MediaTypeText, Content: io.NopCloser(bytes.NewReader([]byte("hi")))}
+	out, err := tr.Transform(context.Background(), text)
+	require.NoError(t, err)
+	assert.Same(t, text, out)
+}
+
+func TestTransform_UnknownFormat(t *testing.T) {
+	raw := []byte("not an image container")
+	data := &interfaces.DataStream{ID: "u", Type: interfaces.MediaTypePhoto, Content: io.NopCloser(bytes.NewReader(raw))}
+	_, err := newTransform(t, nil).Transform(context.Background(), data)
+	assert.ErrorIs(t, err, imagemeta.ErrUnknownFormat, "unrecognised photos are not passed on with their metadata")
+
+	_, b := run(t, newTransform(t, map[string]interface{}{"reject_unsupported": false}), raw)
+	assert.Equal(t, raw, b)
+}
+
+func TestTransform_Configure(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "privacy", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"policy": "everything"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"policy": "allowlist"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"max_bytes": 0}), plugins.ErrInvalidConfig)
+	assert.NoError(t, tr.ValidateSchema(interfaces.Schema{Type: "photo"}))
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "video"}))
+}
+
+func assertSamePixels(t *testing.T, a, b []byte) {
+	t.Helper()
+	imgA, _, err := image.Decode(bytes.NewReader(a))
+	require.NoError(t, err)
+	imgB, _, err := image.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, imgA.Bounds(), imgB.Bounds())
+	for y := imgA.Bounds().Min.Y; y < imgA.Bounds().Max.Y; y++ {
+		for x := imgA.Bounds().Min.X; x < imgA.Bounds().Max.X; x++ {
+			require.Equal(t, imgA.At(x, y), imgB.At(x, y))
+		}
+	}
+}
@@ -0,0 +1,333 @@
+// Package resize provides a transform that renders resized variants and
+// thumbnails of photos as derived data streams.
+package resize
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/media/imaging"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform
+const (
+	// KeyRenditions lists the rendition names on the original stream
+	KeyRenditions = "renditions"
+	// KeyRendition names the rendition on a derived stream
+	KeyRendition = "rendition"
+	KeyWidth     = "width"
+	KeyHeight    = "height"
+)
+
+// Resize modes
+const (
+	// ModeFit scales the image to fit inside the box, keeping its aspect ratio
+	ModeFit = "fit"
+	// ModeFill scales the image to cover the box and crops it to the box size
+	ModeFill = "fill"
+)
+
+// Output formats; FormatAuto keeps PNG for PNG and GIF sources, which may be
+// transparent, and uses JPEG otherwise
+const (
+	FormatAuto = "auto"
+)
+
+const (
+	defaultQuality   = 85
+	defaultMaxBytes  = 256 << 20
+	defaultMaxPixels = 100_000_000
+)
+
+// Rendition describes one derived variant
+type Rendition struct {
+	Name    string
+	Width   int
+	Height  int
+	Mode    string
+	Format  string
+	Quality int
+	Upscale bool
+}
+
+// Transform decodes photos once per stream and attaches one derived stream
+// per configured rendition. The original content passes through unchanged.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu         sync.RWMutex
+	renditions []Rendition
+	maxBytes   int64
+	maxPixels  int
+	now        func() time.Time
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a resize transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin: plugins.NewBasePlugin(config, "transform", "Generates resized renditions and thumbnails of photos"),
+		maxBytes:   defaultMaxBytes,
+		maxPixels:  defaultMaxPixels,
+		now:        time.Now,
+	}, nil
+}
+
+// Configure applies plugin settings. Renditions are configured as a mapping
+// from name to max_dimension (or width and height), mode, format, quality
+// and upscale.
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	renditionSettings, err := settings.Map("renditions")
+	if err != nil {
+		return err
+	}
+	if len(renditionSettings) == 0 {
+		return fmt.Errorf("%w: at least one rendition is required", plugins.ErrInvalidConfig)
+	}
+	names := make([]string, 0, len(renditionSettings))
+	for name := range renditionSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	renditions := make([]Rendition, 0, len(names))
+	for _, name := range names {
+		rs, err := renditionSettings.Map(name)
+		if err != nil {
+			return err
+		}
+		r, err := parseRendition(name, rs)
+		if err != nil {
+			return err
+		}
+		renditions = append(renditions, r)
+	}
+
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	maxPixels, err := settings.Int("max_pixels", defaultMaxPixels)
+	if err != nil {
+		return err
+	}
+	if maxBytes <= 0 || maxPixels <= 0 {
+		return fmt.Errorf("%w: max_bytes and max_pixels must be positive", plugins.ErrInvalidConfig)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renditions = renditions
+	t.maxBytes = int64(maxBytes)
+	t.maxPixels = maxPixels
+	return nil
+}
+
+func parseRendition(name string, s plugins.Settings) (Rendition, error) {
+	r := Rendition{Name: name}
+
+	maxDimension, err := s.Int("max_dimension", 0)
+	if err != nil {
+		return r, err
+	}
+	if r.Width, err = s.Int("width", maxDimension); err != nil {
+		return r, err
+	}
+	if r.Height, err = s.Int("height", maxDimension); err != nil {
+		return r, err
+	}
+	if r.Width <= 0 || r.Height <= 0 {
+		return r, fmt.Errorf("%w: rendition %s needs max_dimension or width and height", plugins.ErrInvalidConfig, name)
+	}
+
+	r.Mode = s.String("mode", ModeFit)
+	if r.Mode != ModeFit && r.Mode != ModeFill {
+		return r, fmt.Errorf("%w: rendition %s mode must be fit or fill", plugins.ErrInvalidConfig, name)
+	}
+	r.Format = s.String("format", FormatAuto)
+	switch r.Format {
+	case FormatAuto, imaging.FormatJPEG, imaging.FormatPNG:
+	default:
+		return r, fmt.Errorf("%w: rendition %s format must be auto, jpeg or png", plugins.ErrInvalidConfig, name)
+	}
+	if r.Quality, err = s.Int("quality", defaultQuality); err != nil {
+		return r, err
+	}
+	if r.Quality < 1 || r.Quality > 100 {
+		return r, fmt.Errorf("%w: rendition %s quality must be between 1 and 100", plugins.ErrInvalidConfig, name)
+	}
+	if r.Upscale, err = s.Bool("upscale", false); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// Start verifies that renditions are configured
+func (t *Transform) Start(ctx context.Context) error {
+	t.mu.RLock()
+	configured := len(t.renditions) > 0
+	t.mu.RUnlock()
+	if !configured {
+		return fmt.Errorf("resize transform not configured")
+	}
+	return t.BasePlugin.Start(ctx)
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types":    []string{string(interfaces.MediaTypePhoto)},
+			"input_formats":  []string{"jpeg", "png", "gif", "webp"},
+			"output_formats": []string{imaging.FormatJPEG, imaging.FormatPNG},
+			"modes":          []string{ModeFit, ModeFill},
+		}},
+	}
+}
+
+// ValidateSchema accepts photo streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypePhoto) {
+		return fmt.Errorf("resize does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform appends a derived stream per rendition to data.Derived. Photos
+// in formats without a pure Go decoder, such as HEIF, pass through without
+// renditions.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypePhoto || data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	renditions, maxBytes, maxPixels := t.renditions, t.maxBytes, t.maxPixels
+	t.mu.RUnlock()
+	if len(renditions) == 0 {
+		return nil, fmt.Errorf("resize transform not configured")
+	}
+
+	content, err := media.ReadAll(data.Content, maxBytes)
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+	data.Content = io.NopCloser(bytes.NewReader(content))
+
+	img, format, err := imaging.Decode(content, maxPixels)
+	if errors.Is(err, imaging.ErrUnsupportedFormat) {
+		return data, nil
+	}
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to decode %s: %w", data.ID, err)
+	}
+	img = imaging.Orient(img, imaging.Orientation(content))
+
+	names := make([]string, 0, len(renditions))
+	for _, r := range renditions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		derived, err := t.render(data, img, format, r)
+		if err != nil {
+			t.RecordError(err)
+			return nil, fmt.Errorf("failed to render %s of %s: %w", r.Name, data.ID, err)
+		}
+		data.Derived = append(data.Derived, derived)
+		names = append(names, r.Name)
+	}
+
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	data.Metadata[KeyRenditions] = names
+	t.RecordError(nil)
+	return data, nil
+}
+
+// render produces one rendition as a stream derived from data
+func (t *Transform) render(data *interfaces.DataStream, img image.Image, sourceFormat string, r Rendition) (*interfaces.DataStream, error) {
+	var resized image.Image
+	if r.Mode == ModeFill {
+		resized = imaging.Fill(img, r.Width, r.Height)
+	} else {
+		resized = imaging.Fit(img, r.Width, r.Height, r.Upscale)
+	}
+
+	format := r.Format
+	if format == FormatAuto {
+		format = imaging.FormatJPEG
+		if (sourceFormat == "png" || sourceFormat == "gif") && !imaging.Opaque(resized) {
+			format = imaging.FormatPNG
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, resized, format, r.Quality); err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]interface{}, len(data.Metadata)+3)
+	for k, v := range data.Metadata {
+		if k != KeyRenditions {
+			metadata[k] = v
+		}
+	}
+	contentType := imaging.ContentType(format)
+	bounds := resized.Bounds()
+	metadata[KeyRendition] = r.Name
+	metadata[KeyWidth] = bounds.Dx()
+	metadata[KeyHeight] = bounds.Dy()
+	metadata["filename"] = renditionFilename(data, r.Name, media.Extension(contentType))
+
+	return &interfaces.DataStream{
+		ID:       data.ID + "/" + r.Name,
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: metadata,
+		Content:  io.NopCloser(bytes.NewReader(buf.Bytes())),
+		Headers: map[string]string{
+			"Content-Type":   contentType,
+			"Content-Length": strconv.Itoa(buf.Len()),
+		},
+		Context: interfaces.StreamContext{
+			Source:    data.Context.Source,
+			CreatedAt: t.now(),
+			ParentID:  data.ID,
+		},
+	}, nil
+}
+
+// renditionFilename derives "<base>-<rendition><ext>" from the original
+// filename, or from the stream ID when there is none
+func renditionFilename(data *interfaces.DataStream, name, ext string) string {
+	base := data.ID
+	if filename, ok := data.Metadata["filename"].(string); ok && filename != "" {
+		base = strings.TrimSuffix(filename, path.Ext(filename))
+	}
+	return base + "-" + name + ext
+}
@@ -0,0 +1,189 @@
+package resize
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media/imagemeta"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "resize", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	tr.now = func() time.Time { return time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC) }
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+func defaultSettings() map[string]interface{} {
+	return map[string]interface{}{
+		"renditions": map[string]interface{}{
+			"web":   map[string]interface{}{"max_dimension": 16, "quality": 90},
+			"thumb": map[string]interface{}{"max_dimension": 10, "mode": "fill"},
+		},
+	}
+}
+
+// landscapeJPEG encodes a 40x20 image, red on the left half, tagged with
+// the given EXIF orientation
+func landscapeJPEG(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			c := color.RGBA{B: 255, A: 255}
+			if x < 20 {
+				c = color.RGBA{R: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}))
+
+	e := &imagemeta.EXIF{Order: binary.BigEndian, IFD0: []imagemeta.Tag{{
+		ID: imagemeta.TagOrientation, Type: imagemeta.TypeShort, Count: 1,
+		Value: binary.BigEndian.AppendUint16(nil, orientation),
+	}}}
+	app1 := append([]byte(imagemeta.JPEGEXIFHeader), e.Encode()...)
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(app1)+2))
+	out = append(out, app1...)
+	return append(out, buf.Bytes()[2:]...)
+}
+
+func photo(content []byte) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "item-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"filename": "IMG_0001.JPG", "title": "Harbour"},
+		Content:  io.NopCloser(bytes.NewReader(content)),
+		Headers:  map[string]string{"Content-Type": "image/jpeg"},
+		Context:  interfaces.StreamContext{Source: "webdav"},
+	}
+}
+
+func decode(t *testing.T, ds *interfaces.DataStream) image.Image {
+	t.Helper()
+	b, err := io.ReadAll(ds.Content)
+	require.NoError(t, err)
+	img, _, err := image.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	return img
+}
+
+func TestTransform_Renditions(t *testing.T) {
+	original := landscapeJPEG(t, 6)
+	out, err := newTransform(t, defaultSettings()).Transform(context.Background(), photo(original))
+	require.NoError(t, err)
+
+	passed, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, original, passed, "original content passes through")
+	assert.Equal(t, []string{"thumb", "web"}, out.Metadata[KeyRenditions])
+	require.Len(t, out.Derived, 2)
+
+	thumb, web := out.Derived[0], out.Derived[1]
+	assert.Equal(t, "item-1/web", web.ID)
+	assert.Equal(t, "item-1", web.Context.ParentID)
+	assert.Equal(t, "webdav", web.Context.Source)
+	assert.Equal(t, "image/jpeg", web.Headers["Content-Type"])
+	assert.Equal(t, "IMG_0001-web.jpg", web.Metadata["filename"])
+	assert.Equal(t, "Harbour", web.Metadata["title"])
+	assert.Equal(t, "web", web.Metadata[KeyRendition])
+	assert.NotContains(t, web.Metadata, KeyRenditions)
+
+	// Orientation 6 rotates the 40x20 landscape into a 20x40 portrait with
+	// the red half on top
+	webImg := decode(t, web)
+	assert.Equal(t, image.Pt(8, 16), webImg.Bounds().Size())
+	assert.Equal(t, 8, web.Metadata[KeyWidth])
+	assert.Equal(t, 16, web.Metadata[KeyHeight])
+	r, _, b, _ := webImg.At(4, 2).RGBA()
+	assert.Greater(t, r, b)
+	r, _, b, _ = webImg.At(4, 13).RGBA()
+	assert.Greater(t, b, r)
+
+	assert.Equal(t, image.Pt(10, 10), decode(t, thumb).Bounds().Size())
+}
+
+func TestTransform_AutoFormatKeepsTransparency(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 30))
+	img.Set(1, 1, color.NRGBA{R: 255, A: 128})
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	tr := newTransform(t, map[string]interface{}{
+		"renditions": map[string]interface{}{"small": map[string]interface{}{"width": 12, "height": 6}},
+	})
+	out, err := tr.Transform(context.Background(), photo(buf.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, out.Derived, 1)
+
+	small := out.Derived[0]
+	assert.Equal(t, "image/png", small.Headers["Content-Type"])
+	assert.Equal(t, image.Pt(6, 6), decode(t, small).Bounds().Size())
+}
+
+func TestTransform_Passthrough(t *testing.T) {
+	tr := newTransform(t, defaultSettings())
+
+	heif := photo([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"))
+	out, err := tr.Transform(context.Background(), heif)
+	require.NoError(t, err)
+	assert.Empty(t, out.Derived)
+
+	text := &interfaces.DataStream{ID: "t", Type: interfaces.MediaTypeText}
+	out, err = tr.Transform(context.Background(), text)
+	require.NoError(t, err)
+	assert.Same(t, text, out)
+
+	corrupt := photo(append([]byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 0x43}, make([]byte, 10)...))
+	_, err = tr.Transform(context.Background(), corrupt)
+	assert.Error(t, err)
+}
+
+func TestTransform_PixelLimit(t *testing.T) {
+	settings := defaultSettings()
+	settings["max_pixels"] = 100
+	_, err := newTransform(t, settings).Transform(context.Background(), photo(landscapeJPEG(t, 1)))
+	assert.Error(t, err)
+}
+
+func TestTransform_Configure(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "resize", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+
+	assert.Error(t, tr.Start(context.Background()), "not configured")
+
+	invalid := []map[string]interface{}{
+		{},
+		{"renditions": map[string]interface{}{"x": map[string]interface{}{}}},
+		{"renditions": map[string]interface{}{"x": map[string]interface{}{"max_dimension": 10, "mode": "stretch"}}},
+		{"renditions": map[string]interface{}{"x": map[string]interface{}{"max_dimension": 10, "format": "webp"}}},
+		{"renditions": map[string]interface{}{"x": map[string]interface{}{"max_dimension": 10, "quality": 0}}},
+	}
+	for _, settings := range invalid {
+		assert.ErrorIs(t, tr.Configure(settings), plugins.ErrInvalidConfig, "%v", settings)
+	}
+
+	require.NoError(t, tr.Configure(defaultSettings()))
+	assert.NoError(t, tr.ValidateSchema(interfaces.Schema{Type: "photo"}))
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "audio"}))
+}
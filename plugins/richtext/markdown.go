@@ -0,0 +1,289 @@
+package richtext
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+var (
+	whitespace   = regexp.MustCompile(`[ \t\r\n\f]+`)
+	orderedStart = regexp.MustCompile(`^(\d+)([.)])`)
+	escaper      = strings.NewReplacer(`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `<`, `\<`)
+	destEscaper  = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+)
+
+// hardBreak marks a line break in inline runs; paragraph turns it into a
+// CommonMark backslash break, which unlike trailing spaces survives trimming
+const hardBreak = "\x00\n"
+
+// toMarkdown converts sanitized HTML to CommonMark
+func toMarkdown(root *html.Node) string {
+	return strings.Join(blocks(root), "\n\n")
+}
+
+// blocks renders the children of n as Markdown blocks; runs of inline
+// content between block elements become paragraphs
+func blocks(n *html.Node) []string {
+	var out []string
+	var run strings.Builder
+	flush := func() {
+		if p := paragraph(run.String()); p != "" {
+			out = append(out, p)
+		}
+		run.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || !isBlock(c) {
+			run.WriteString(inline(c))
+			continue
+		}
+		flush()
+		out = append(out, block(c)...)
+	}
+	flush()
+	return out
+}
+
+func isBlock(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.P, atom.Div, atom.Blockquote, atom.Pre, atom.Ul, atom.Ol, atom.Li, atom.Hr,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Figure, atom.Figcaption,
+		atom.Table, atom.Tr, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main:
+		return true
+	}
+	return false
+}
+
+func block(n *html.Node) []string {
+	switch n.DataAtom {
+	case atom.P:
+		if p := paragraph(inlineChildren(n)); p != "" {
+			return []string{p}
+		}
+		return nil
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		level := int(n.Data[1] - '0')
+		heading := strings.ReplaceAll(paragraph(inlineChildren(n)), "\\\n", " ")
+		if heading == "" {
+			return nil
+		}
+		return []string{strings.Repeat("#", level) + " " + heading}
+	case atom.Hr:
+		return []string{"---"}
+	case atom.Pre:
+		code := strings.TrimSuffix(text(n), "\n")
+		fence := "```"
+		for strings.Contains(code, fence) {
+			fence += "`"
+		}
+		return []string{fence + "\n" + code + "\n" + fence}
+	case atom.Blockquote:
+		inner := strings.Join(blocks(n), "\n\n")
+		if inner == "" {
+			return nil
+		}
+		return []string{prefixLines(inner, "> ", "> ")}
+	case atom.Ul, atom.Ol:
+		return []string{list(n)}
+	}
+	return blocks(n)
+}
+
+// list renders the li children of n, indenting continuation lines under
+// the marker
+func list(n *html.Node) string {
+	ordered := n.DataAtom == atom.Ol
+	number := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil && ordered {
+		number = start
+	}
+	var items []string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		sep := "\n"
+		if hasChild(c, atom.P) {
+			sep = "\n\n"
+		}
+		content := strings.Join(blocks(c), sep)
+		items = append(items, prefixLines(content, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+// hasChild reports whether n has a child element a; items without
+// paragraphs form tight lists
+func hasChild(n *html.Node, a atom.Atom) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == a {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixLines puts first before the first line and rest before the others,
+// leaving blank lines unindented
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" && i > 0 {
+			lines[i] = strings.TrimRight(prefix, " ")
+			continue
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func inlineChildren(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(inline(c))
+	}
+	return b.String()
+}
+
+// inline renders n as inline Markdown; nested blocks flatten to their text
+func inline(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		return escaper.Replace(whitespace.ReplaceAllString(n.Data, " "))
+	case html.ElementNode:
+	default:
+		return ""
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		return hardBreak
+	case atom.Strong, atom.B:
+		return wrap(inlineChildren(n), "**")
+	case atom.Em, atom.I:
+		return wrap(inlineChildren(n), "*")
+	case atom.S, atom.Del, atom.Strike:
+		return wrap(inlineChildren(n), "~~")
+	case atom.Code:
+		return codeSpan(text(n))
+	case atom.Img:
+		src := attr(n, "src")
+		if src == "" {
+			return ""
+		}
+		return "![" + escaper.Replace(attr(n, "alt")) + "](" + destEscaper.Replace(src) + ")"
+	case atom.A:
+		label := inlineChildren(n)
+		href := attr(n, "href")
+		switch {
+		case href == "":
+			return label
+		case strings.TrimSpace(text(n)) == href:
+			return "<" + destEscaper.Replace(href) + ">"
+		case strings.TrimSpace(label) == "":
+			return ""
+		}
+		return "[" + strings.TrimSpace(label) + "](" + destEscaper.Replace(href) + ")"
+	}
+	return inlineChildren(n)
+}
+
+// wrap surrounds s with a delimiter, keeping outer spaces outside it as
+// emphasis cannot start or end with whitespace
+func wrap(s, delim string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	lead := s[:strings.Index(s, trimmed)]
+	trail := s[len(lead)+len(trimmed):]
+	return lead + delim + trimmed + delim + trail
+}
+
+func codeSpan(code string) string {
+	code = whitespace.ReplaceAllString(code, " ")
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+// paragraph trims an inline run, escapes characters that would start a
+// block at the beginning of a line and turns inner line breaks into
+// backslash breaks
+func paragraph(s string) string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(strings.ReplaceAll(whitespace.ReplaceAllString(line, " "), "\x00", ""))
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case '#', '>', '-', '+', '=', '|':
+			line = `\` + line
+		default:
+			if m := orderedStart.FindStringSubmatchIndex(line); m != nil {
+				line = line[:m[4]] + `\` + line[m[4]:]
+			}
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\\\n")
+}
+
+// frontMatterKeys are the canonical keys written to front matter, in order
+var frontMatterKeys = []string{
+	interfaces.MetaTitle, interfaces.MetaAuthor, interfaces.MetaDescription,
+	interfaces.MetaPublishedAt, interfaces.MetaCapturedAt, interfaces.MetaSourceURL,
+	interfaces.MetaTags,
+}
+
+// frontMatter renders the canonical metadata that is present as a YAML
+// front matter block, or "" when there is none
+func frontMatter(metadata map[string]interface{}) (string, error) {
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range frontMatterKeys {
+		v, ok := metadata[key]
+		if !ok || v == nil {
+			continue
+		}
+		var value yaml.Node
+		if err := value.Encode(v); err != nil {
+			return "", err
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &value)
+	}
+	if len(doc.Content) == 0 {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	return "---\n" + buf.String() + "---\n\n", nil
+}
@@ -0,0 +1,164 @@
+package richtext
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Policy is an allowlist of elements, each with the attributes it may
+// keep. Elements outside the policy are replaced by their children, except
+// for the elements in dropped, which are removed with their content.
+type Policy struct {
+	Elements map[string][]string
+	// Schemes are the URL schemes allowed in href, src and cite
+	Schemes []string
+}
+
+// DefaultPolicy allows basic formatting, links, lists, quotes, code and
+// images
+func DefaultPolicy() Policy {
+	return Policy{
+		Elements: map[string][]string{
+			"p": nil, "br": nil, "hr": nil, "div": nil, "span": nil,
+			"a":      {"href", "title"},
+			"img":    {"src", "alt", "title", "width", "height"},
+			"strong": nil, "b": nil, "em": nil, "i": nil, "u": nil, "s": nil, "del": nil,
+			"code": nil, "pre": nil, "blockquote": {"cite"},
+			"ul": nil, "ol": {"start"}, "li": nil,
+			"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+			"figure": nil, "figcaption": nil, "sub": nil, "sup": nil,
+		},
+		Schemes: []string{"http", "https", "mailto"},
+	}
+}
+
+// dropped elements are removed together with their content
+var dropped = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Template: true, atom.Iframe: true,
+	atom.Object: true, atom.Embed: true, atom.Noscript: true, atom.Head: true,
+	atom.Title: true, atom.Textarea: true, atom.Select: true, atom.Svg: true,
+	atom.Math: true, atom.Form: true, atom.Button: true,
+}
+
+// urlAttrs hold URLs that must use an allowed scheme
+var urlAttrs = map[string]bool{"href": true, "src": true, "cite": true}
+
+// parseFragment parses s as the content of a body element
+func parseFragment(s string) ([]*html.Node, error) {
+	return html.ParseFragment(strings.NewReader(s), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+}
+
+// sanitize applies the policy to the children of root in place. Relative
+// URLs are resolved against base when it is set and dropped otherwise.
+func (p Policy) sanitize(root *html.Node, base *url.URL) {
+	for c := root.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.ElementNode:
+			p.element(c, base)
+		case html.TextNode:
+		default:
+			root.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+func (p Policy) element(n *html.Node, base *url.URL) {
+	parent := n.Parent
+	if dropped[n.DataAtom] {
+		parent.RemoveChild(n)
+		return
+	}
+	p.sanitize(n, base)
+
+	allowed, ok := p.Elements[strings.ToLower(n.Data)]
+	if !ok || n.Namespace != "" {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			n.RemoveChild(c)
+			parent.InsertBefore(c, n)
+			c = next
+		}
+		parent.RemoveChild(n)
+		return
+	}
+
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" || !contains(allowed, key) {
+			continue
+		}
+		if urlAttrs[key] {
+			v, ok := p.url(a.Val, base)
+			if !ok {
+				continue
+			}
+			a.Val = v
+		}
+		a.Key = key
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+
+	switch n.DataAtom {
+	case atom.Img:
+		if attr(n, "src") == "" {
+			parent.RemoveChild(n)
+		}
+	case atom.A:
+		if attr(n, "href") != "" {
+			setAttr(n, "rel", "nofollow noopener noreferrer")
+		}
+	}
+}
+
+// url resolves ref and checks its scheme
+func (p Policy) url(ref string, base *url.URL) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", false
+	}
+	if !u.IsAbs() {
+		if base == nil {
+			return "", false
+		}
+		u = base.ResolveReference(u)
+	}
+	if !contains(p.Schemes, strings.ToLower(u.Scheme)) {
+		return "", false
+	}
+	return u.String(), true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, value string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && strings.EqualFold(a.Key, key) {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
@@ -0,0 +1,141 @@
+package richtext
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#/])#([\p{L}\p{N}_]+)`)
+	mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_/@.])@([A-Za-z0-9_]+(?:@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)+)?)`)
+)
+
+// extracted holds the hashtags, without "#", and mentions, with "@", in
+// order of appearance
+type extracted struct {
+	hashtags []string
+	mentions []string
+	seen     map[string]bool
+}
+
+// extractTags finds hashtags and mentions in the text of root. Links
+// marked up as hashtags or mentions, as Mastodon and other ActivityPub
+// servers do, are read from their text and href; code is skipped.
+func extractTags(root *html.Node) *extracted {
+	e := &extracted{seen: make(map[string]bool)}
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			e.scan(n.Data)
+			return
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Code, atom.Pre, atom.Script, atom.Style:
+				return
+			case atom.A:
+				e.link(n)
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(root)
+	return e
+}
+
+// link reads hashtag and mention links; other links only contribute when
+// their text is a hashtag or mention
+func (e *extracted) link(n *html.Node) {
+	class := " " + strings.ToLower(attr(n, "class")) + " "
+	label := strings.TrimSpace(text(n))
+	switch {
+	case strings.Contains(class, " hashtag ") || strings.EqualFold(attr(n, "rel"), "tag"):
+		e.addHashtag(strings.TrimPrefix(label, "#"))
+	case strings.Contains(class, " mention "):
+		if mention := mentionFromURL(attr(n, "href")); mention != "" {
+			e.addMention(mention)
+		} else {
+			e.addMention(strings.TrimPrefix(label, "@"))
+		}
+	case strings.HasPrefix(label, "#") || strings.HasPrefix(label, "@"):
+		e.scan(label)
+	}
+}
+
+func (e *extracted) scan(s string) {
+	for _, m := range hashtagPattern.FindAllStringSubmatch(s, -1) {
+		e.addHashtag(m[1])
+	}
+	for _, m := range mentionPattern.FindAllStringSubmatch(s, -1) {
+		e.addMention(m[1])
+	}
+}
+
+// addHashtag records tag unless it is empty, purely numeric or a case
+// variant of an earlier hashtag
+func (e *extracted) addHashtag(tag string) {
+	if strings.IndexFunc(tag, func(r rune) bool { return !unicode.IsDigit(r) && r != '_' }) < 0 {
+		return
+	}
+	key := "#" + strings.ToLower(tag)
+	if !e.seen[key] {
+		e.seen[key] = true
+		e.hashtags = append(e.hashtags, tag)
+	}
+}
+
+func (e *extracted) addMention(handle string) {
+	if handle == "" {
+		return
+	}
+	mention := "@" + handle
+	key := strings.ToLower(mention)
+	if !e.seen[key] {
+		e.seen[key] = true
+		e.mentions = append(e.mentions, mention)
+	}
+}
+
+// mentionFromURL derives "user@host" from profile URLs such as
+// https://host/@user and https://host/users/user
+func mentionFromURL(href string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	var user string
+	switch {
+	case len(parts) == 1 && strings.HasPrefix(parts[0], "@"):
+		user = strings.TrimPrefix(parts[0], "@")
+	case len(parts) == 2 && (parts[0] == "users" || parts[0] == "u"):
+		user = parts[1]
+	}
+	if user == "" || strings.Contains(user, "@") {
+		return user
+	}
+	return user + "@" + u.Hostname()
+}
+
+func text(n *html.Node) string {
+	var b strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return b.String()
+}
@@ -0,0 +1,430 @@
+// Package richtext provides a transform that sanitizes the HTML of text
+// posts, points embedded images at synced media items, extracts hashtags
+// and mentions into tags and can convert the post to Markdown with front
+// matter.
+package richtext
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform besides the canonical tags
+const (
+	// KeyMentions lists the mentioned accounts as "@user" or "@user@host"
+	KeyMentions = "mentions"
+	// KeyMediaRefs lists the IDs of media items the text embeds
+	KeyMediaRefs = "media_refs"
+)
+
+// Output formats
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+)
+
+const (
+	defaultMaxBytes   = 16 << 20
+	defaultImageURL   = "{filename}"
+	defaultMediaField = "media"
+)
+
+// mediaURLFields are the keys of media entries that may hold the URL an
+// image was embedded with
+var mediaURLFields = []string{"url", interfaces.MetaSourceURL, "remote_url", "preview_url"}
+
+// Transform rewrites HTML text streams. Plain text is only scanned for
+// tags unless Markdown output is configured; other text formats pass
+// through.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu          sync.RWMutex
+	policy      Policy
+	format      string
+	frontMatter bool
+	extractTags bool
+	imageURL    string
+	mediaField  string
+	maxBytes    int64
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a rich text transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin:  plugins.NewBasePlugin(config, "transform", "Sanitizes HTML text, extracts tags and converts to Markdown"),
+		policy:      DefaultPolicy(),
+		format:      FormatHTML,
+		frontMatter: true,
+		extractTags: true,
+		imageURL:    defaultImageURL,
+		mediaField:  defaultMediaField,
+		maxBytes:    defaultMaxBytes,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	policy := DefaultPolicy()
+	if settings.Has("allow") {
+		allow, err := settings.Map("allow")
+		if err != nil {
+			return err
+		}
+		policy.Elements = make(map[string][]string, len(allow))
+		for element := range allow {
+			attrs, err := allow.StringSlice(element)
+			if err != nil {
+				return err
+			}
+			for i := range attrs {
+				attrs[i] = strings.ToLower(attrs[i])
+			}
+			policy.Elements[strings.ToLower(element)] = attrs
+		}
+	}
+	if settings.Has("url_schemes") {
+		schemes, err := settings.StringSlice("url_schemes")
+		if err != nil {
+			return err
+		}
+		policy.Schemes = make([]string, len(schemes))
+		for i, scheme := range schemes {
+			policy.Schemes[i] = strings.ToLower(scheme)
+		}
+	}
+
+	format := settings.String("format", FormatHTML)
+	if format != FormatHTML && format != FormatMarkdown {
+		return fmt.Errorf("%w: format must be %q or %q", plugins.ErrInvalidConfig, FormatHTML, FormatMarkdown)
+	}
+	frontMatter, err := settings.Bool("front_matter", true)
+	if err != nil {
+		return err
+	}
+	extractTags, err := settings.Bool("extract_tags", true)
+	if err != nil {
+		return err
+	}
+	imageURL := settings.String("image_url", defaultImageURL)
+	if !strings.Contains(imageURL, "{id}") && !strings.Contains(imageURL, "{filename}") {
+		return fmt.Errorf("%w: image_url must contain {id} or {filename}", plugins.ErrInvalidConfig)
+	}
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	if maxBytes < 1 {
+		return fmt.Errorf("%w: max_bytes must be positive", plugins.ErrInvalidConfig)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.policy = policy
+	t.format = format
+	t.frontMatter = frontMatter
+	t.extractTags = extractTags
+	t.imageURL = imageURL
+	t.mediaField = settings.String("media_field", defaultMediaField)
+	t.maxBytes = int64(maxBytes)
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types":  []string{string(interfaces.MediaTypeText)},
+			"input_types":  []string{"text/html", "text/plain"},
+			"output_types": []string{"text/html", "text/markdown"},
+		}},
+	}
+}
+
+// ValidateSchema accepts text streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypeText) {
+		return fmt.Errorf("rich text conversion does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform sanitizes HTML content, records its hashtags and mentions and,
+// with Markdown output, replaces it by Markdown with front matter
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypeText || data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	policy, format, withFrontMatter, withTags := t.policy, t.format, t.frontMatter, t.extractTags
+	imageURL, mediaField, maxBytes := t.imageURL, t.mediaField, t.maxBytes
+	t.mu.RUnlock()
+
+	declared, _, _ := mime.ParseMediaType(data.Headers["Content-Type"])
+	if declared != "" && declared != "text/html" && declared != "application/xhtml+xml" && declared != "text/plain" {
+		return data, nil
+	}
+	content, err := media.ReadAll(data.Content, maxBytes)
+	if err != nil {
+		data.Content = nil
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+	contentType := declared
+	if contentType == "" {
+		contentType, _, _ = mime.ParseMediaType(media.Sniff(content))
+	}
+	isHTML := contentType == "text/html" || contentType == "application/xhtml+xml"
+
+	root := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	if isHTML {
+		nodes, err := parseFragment(string(content))
+		if err != nil {
+			t.RecordError(err)
+			return nil, fmt.Errorf("failed to parse %s: %w", data.ID, err)
+		}
+		for _, n := range nodes {
+			root.AppendChild(n)
+		}
+	} else {
+		plainToHTML(root, string(content))
+	}
+
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	if withTags {
+		found := extractTags(root)
+		tags := mergeTags(data.Metadata[interfaces.MetaTags], append(found.hashtags, found.mentions...))
+		if len(tags) > 0 {
+			data.Metadata[interfaces.MetaTags] = tags
+		}
+		if len(found.mentions) > 0 {
+			data.Metadata[KeyMentions] = found.mentions
+		}
+	}
+
+	if isHTML {
+		var base *url.URL
+		if s, ok := data.Metadata[interfaces.MetaSourceURL].(string); ok {
+			base, _ = url.Parse(s)
+		}
+		policy.sanitize(root, base)
+		if refs := rewriteImages(root, mediaRefs(data, mediaField), imageURL); len(refs) > 0 {
+			data.Metadata[KeyMediaRefs] = refs
+		}
+	}
+
+	var body []byte
+	switch {
+	case format == FormatMarkdown:
+		var buf bytes.Buffer
+		if withFrontMatter {
+			fm, err := frontMatter(data.Metadata)
+			if err != nil {
+				t.RecordError(err)
+				return nil, fmt.Errorf("failed to render front matter of %s: %w", data.ID, err)
+			}
+			buf.WriteString(fm)
+		}
+		buf.WriteString(toMarkdown(root))
+		buf.WriteString("\n")
+		body, contentType = buf.Bytes(), "text/markdown; charset=utf-8"
+	case isHTML:
+		var buf bytes.Buffer
+		for c := root.FirstChild; c != nil; c = c.NextSibling {
+			if err := html.Render(&buf, c); err != nil {
+				t.RecordError(err)
+				return nil, fmt.Errorf("failed to render %s: %w", data.ID, err)
+			}
+		}
+		body, contentType = buf.Bytes(), "text/html; charset=utf-8"
+	default:
+		body = content
+	}
+
+	data.Content = io.NopCloser(bytes.NewReader(body))
+	if data.Headers == nil {
+		data.Headers = make(map[string]string)
+	}
+	if format == FormatMarkdown || isHTML {
+		data.Headers["Content-Type"] = contentType
+		if filename, ok := data.Metadata["filename"].(string); ok && filename != "" {
+			data.Metadata["filename"] = strings.TrimSuffix(filename, path.Ext(filename)) + media.Extension(contentType)
+		}
+	}
+	data.Headers["Content-Length"] = strconv.Itoa(len(body))
+	t.RecordError(nil)
+	return data, nil
+}
+
+// plainToHTML appends plain text to root as paragraphs split at blank
+// lines, with line breaks inside them
+func plainToHTML(root *html.Node, s string) {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	for _, para := range strings.Split(s, "\n\n") {
+		para = strings.Trim(para, "\n")
+		if strings.TrimSpace(para) == "" {
+			continue
+		}
+		p := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+		for i, line := range strings.Split(para, "\n") {
+			if i > 0 {
+				p.AppendChild(&html.Node{Type: html.ElementNode, Data: "br", DataAtom: atom.Br})
+			}
+			p.AppendChild(&html.Node{Type: html.TextNode, Data: line})
+		}
+		root.AppendChild(p)
+	}
+}
+
+// mergeTags appends found to the existing tags value, skipping case
+// variants of tags already present
+func mergeTags(existing interface{}, found []string) []string {
+	var tags []string
+	switch v := existing.(type) {
+	case []string:
+		tags = append(tags, v...)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				tags = append(tags, s)
+			}
+		}
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				tags = append(tags, s)
+			}
+		}
+	}
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		seen[strings.ToLower(tag)] = true
+	}
+	for _, tag := range found {
+		if key := strings.ToLower(tag); !seen[key] {
+			seen[key] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// mediaRef is a synced media item an embedded image can point at
+type mediaRef struct {
+	ID       string
+	Filename string
+}
+
+// mediaRefs maps the source URLs of the derived streams and of the entries
+// listed under mediaField to their media items
+func mediaRefs(data *interfaces.DataStream, mediaField string) map[string]mediaRef {
+	refs := make(map[string]mediaRef)
+	add := func(id string, metadata map[string]interface{}) {
+		if id == "" {
+			return
+		}
+		ref := mediaRef{ID: id}
+		ref.Filename, _ = metadata["filename"].(string)
+		for _, key := range mediaURLFields {
+			if u, ok := metadata[key].(string); ok && u != "" {
+				refs[u] = ref
+			}
+		}
+	}
+	for _, derived := range data.Derived {
+		if derived != nil {
+			add(derived.ID, derived.Metadata)
+		}
+	}
+	var entries []map[string]interface{}
+	switch list := data.Metadata[mediaField].(type) {
+	case []map[string]interface{}:
+		entries = list
+	case []interface{}:
+		for _, item := range list {
+			if entry, ok := item.(map[string]interface{}); ok {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	for _, entry := range entries {
+		id, _ := entry["id"].(string)
+		add(id, entry)
+	}
+	return refs
+}
+
+// rewriteImages points images, and links around them, whose URL belongs to
+// a media item at the URL built from template. It returns the IDs of the
+// referenced items.
+func rewriteImages(root *html.Node, refs map[string]mediaRef, template string) []string {
+	if len(refs) == 0 {
+		return nil
+	}
+	var ids []string
+	seen := make(map[string]bool)
+	target := func(u string) (string, bool) {
+		ref, ok := refs[u]
+		if !ok {
+			return "", false
+		}
+		if !seen[ref.ID] {
+			seen[ref.ID] = true
+			ids = append(ids, ref.ID)
+		}
+		filename := ref.Filename
+		if filename == "" {
+			filename = path.Base(ref.ID)
+		}
+		return strings.NewReplacer("{id}", ref.ID, "{filename}", filename).Replace(template), true
+	}
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Img:
+				if u, ok := target(attr(n, "src")); ok {
+					setAttr(n, "src", u)
+				}
+			case atom.A:
+				if u, ok := target(attr(n, "href")); ok {
+					setAttr(n, "href", u)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(root)
+	return ids
+}
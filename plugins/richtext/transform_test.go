@@ -0,0 +1,210 @@
+package richtext
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// mastodonPost is status HTML as Mastodon renders it
+const mastodonPost = `<p>Trip notes with <span class="h-card"><a href="https://social.example/@alice" class="u-url mention">@<span>alice</span></a></span> ` +
+	`<a href="https://social.example/tags/Travel" class="mention hashtag" rel="tag">#<span>Travel</span></a> and #photos</p>` +
+	`<p><img src="https://files.example/media/1.jpg" alt="Harbour" onerror="steal()"></p>` +
+	`<script>alert(1)</script><p onclick="x()">Read <a href="javascript:alert(1)">this</a> or <a href="/about">that</a></p>`
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "richtext", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+func post(content, contentType string) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:   "status-1",
+		Type: interfaces.MediaTypeText,
+		Metadata: map[string]interface{}{
+			"filename":               "status-1.html",
+			interfaces.MetaSourceURL: "https://social.example/@bob/1",
+			interfaces.MetaTitle:     "Trip notes",
+			interfaces.MetaTags:      []interface{}{"travel", "archive"},
+		},
+		Content: io.NopCloser(strings.NewReader(content)),
+		Headers: map[string]string{"Content-Type": contentType},
+	}
+}
+
+func read(t *testing.T, data *interfaces.DataStream) string {
+	t.Helper()
+	b, err := io.ReadAll(data.Content)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestTransform_Sanitize(t *testing.T) {
+	out, err := newTransform(t, nil).Transform(context.Background(), post(mastodonPost, "text/html"))
+	require.NoError(t, err)
+
+	body := read(t, out)
+	assert.NotContains(t, body, "script")
+	assert.NotContains(t, body, "onerror")
+	assert.NotContains(t, body, "onclick")
+	assert.NotContains(t, body, "javascript:")
+	assert.NotContains(t, body, "class=")
+	assert.Contains(t, body, `<a>this</a>`)
+	assert.Contains(t, body, `<a href="https://social.example/about" rel="nofollow noopener noreferrer">that</a>`, "relative links resolve against the source URL")
+	assert.Contains(t, body, `<img src="https://files.example/media/1.jpg" alt="Harbour"/>`)
+	assert.Contains(t, body, `@alice`, "unknown elements keep their text")
+	assert.Equal(t, "text/html; charset=utf-8", out.Headers["Content-Type"])
+	assert.Equal(t, "status-1.html", out.Metadata["filename"])
+}
+
+func TestTransform_Tags(t *testing.T) {
+	out, err := newTransform(t, nil).Transform(context.Background(), post(mastodonPost, "text/html"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"travel", "archive", "photos", "@alice@social.example"}, out.Metadata[interfaces.MetaTags],
+		"existing tags are kept and case variants are not repeated")
+	assert.Equal(t, []string{"@alice@social.example"}, out.Metadata[KeyMentions])
+	assert.NoError(t, interfaces.ValidateMetadata(out.Metadata))
+
+	found := extractTags(fragment(t, `<p>#go and #2024, mail me@example.com, ping @bob@other.example. <code>#define</code> `+
+		`<a href="https://x.example/users/carol" class="mention">@carol</a> #Go</p>`))
+	assert.Equal(t, []string{"go"}, found.hashtags)
+	assert.Equal(t, []string{"@bob@other.example", "@carol@x.example"}, found.mentions)
+}
+
+func TestTransform_RewritesImages(t *testing.T) {
+	data := post(`<p><a href="https://files.example/media/1.jpg"><img src="https://files.example/media/1.jpg"></a>`+
+		`<img src="https://files.example/media/2.png"><img src="https://elsewhere.example/x.gif"></p>`, "text/html")
+	data.Metadata["media"] = []interface{}{
+		map[string]interface{}{"id": "media-1", "url": "https://files.example/media/1.jpg", "filename": "harbour.jpg"},
+	}
+	data.Derived = []*interfaces.DataStream{{
+		ID:       "status-1/media-2",
+		Metadata: map[string]interface{}{interfaces.MetaSourceURL: "https://files.example/media/2.png"},
+	}}
+
+	out, err := newTransform(t, map[string]interface{}{"image_url": "media/{filename}"}).Transform(context.Background(), data)
+	require.NoError(t, err)
+
+	body := read(t, out)
+	assert.Contains(t, body, `<a href="media/harbour.jpg" rel="nofollow noopener noreferrer"><img src="media/harbour.jpg"/></a>`)
+	assert.Contains(t, body, `<img src="media/media-2"/>`)
+	assert.Contains(t, body, `<img src="https://elsewhere.example/x.gif"/>`)
+	assert.Equal(t, []string{"media-1", "status-1/media-2"}, out.Metadata[KeyMediaRefs])
+}
+
+func TestTransform_Markdown(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{"format": "markdown"})
+	out, err := tr.Transform(context.Background(), post(mastodonPost, "text/html; charset=utf-8"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "text/markdown; charset=utf-8", out.Headers["Content-Type"])
+	assert.Equal(t, "status-1.md", out.Metadata["filename"])
+	assert.Equal(t, `---
+title: Trip notes
+source_url: https://social.example/@bob/1
+tags:
+  - travel
+  - archive
+  - photos
+  - '@alice@social.example'
+---
+
+Trip notes with [@alice](https://social.example/@alice) [#Travel](https://social.example/tags/Travel) and #photos
+
+![Harbour](https://files.example/media/1.jpg)
+
+Read this or [that](https://social.example/about)
+`, read(t, out))
+}
+
+func TestMarkdown_Blocks(t *testing.T) {
+	root := fragment(t, `<h2>Title <em>here</em></h2><p>Some <strong> bold </strong> text with <code>a`+"`"+`b</code> and `+
+		`<a href="https://example.com/a b">https://example.com/a b</a><br>next line</p>`+
+		`<ul><li>one</li><li>two<ol start="3"><li>three</li></ol></li></ul>`+
+		`<blockquote><p>quoted</p><p>- not a list</p></blockquote><pre><code>x := 1
+y := 2</code></pre><hr><p>1. not ordered * star</p>`)
+
+	assert.Equal(t, "## Title *here*\n\n"+
+		"Some **bold** text with ``a`b`` and <https://example.com/a%20b>\\\nnext line\n\n"+
+		"- one\n- two\n  3. three\n\n"+
+		"> quoted\n>\n> \\- not a list\n\n"+
+		"```\nx := 1\ny := 2\n```\n\n"+
+		"---\n\n"+
+		"1\\. not ordered \\* star", toMarkdown(root))
+}
+
+func TestTransform_PlainText(t *testing.T) {
+	data := post("Hello #world\n\nsecond line", "text/plain")
+	out, err := newTransform(t, nil).Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello #world\n\nsecond line", read(t, out), "plain text passes through")
+	assert.Contains(t, out.Metadata[interfaces.MetaTags], "world")
+
+	data = post("Hello #world\nsecond line", "text/plain")
+	out, err = newTransform(t, map[string]interface{}{"format": "markdown", "front_matter": false}).Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello #world\\\nsecond line\n", read(t, out))
+}
+
+func TestTransform_CustomPolicy(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{
+		"allow":        map[string]interface{}{"p": []interface{}{}, "a": []interface{}{"href"}},
+		"url_schemes":  []interface{}{"https"},
+		"extract_tags": false,
+	})
+	out, err := tr.Transform(context.Background(), post(`<p><b>bold</b> <a href="http://plain.example">x</a></p>`, "text/html"))
+	require.NoError(t, err)
+	assert.Equal(t, `<p>bold <a>x</a></p>`, read(t, out))
+	assert.Equal(t, []interface{}{"travel", "archive"}, out.Metadata[interfaces.MetaTags])
+}
+
+func TestTransform_PassThrough(t *testing.T) {
+	tr := newTransform(t, nil)
+	data := post("# Notes", "text/markdown")
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+	assert.Equal(t, "# Notes", read(t, out))
+
+	photo := &interfaces.DataStream{ID: "p", Type: interfaces.MediaTypePhoto}
+	out, err = tr.Transform(context.Background(), photo)
+	require.NoError(t, err)
+	assert.Same(t, photo, out)
+
+	_, err = tr.Transform(context.Background(), nil)
+	assert.Error(t, err)
+}
+
+func TestConfigure_Invalid(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "richtext", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"format": "rst"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"image_url": "media/"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"allow": "p"}), plugins.ErrInvalidConfig)
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "photo"}))
+}
+
+func fragment(t *testing.T, s string) *html.Node {
+	t.Helper()
+	nodes, err := parseFragment(s)
+	require.NoError(t, err)
+	root := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	return root
+}
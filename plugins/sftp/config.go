@@ -0,0 +1,164 @@
+package sftp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// config holds the parsed plugin settings
+type config struct {
+	Host       string
+	Port       int
+	Root       string
+	TempSuffix string
+	Timeout    time.Duration
+
+	// HostKeyCallback verifies the server key against known_hosts
+	HostKeyCallback ssh.HostKeyCallback
+
+	auth authConfig
+}
+
+// authConfig holds the credentials used to open the SSH connection
+type authConfig struct {
+	Username   string
+	Password   string
+	PrivateKey []byte
+	Passphrase string
+}
+
+func parseConfig(settings plugins.Settings) (config, error) {
+	cfg := config{
+		Host:       settings.String("host", ""),
+		Root:       path.Clean("/" + settings.String("root", "/")),
+		TempSuffix: settings.String("temp_suffix", ".part"),
+		auth: authConfig{
+			Username:   settings.String("username", ""),
+			Password:   settings.String("password", ""),
+			Passphrase: settings.String("passphrase", ""),
+		},
+	}
+	if cfg.Host == "" {
+		return cfg, fmt.Errorf("%w: host is required", plugins.ErrInvalidConfig)
+	}
+	if cfg.TempSuffix == "" {
+		return cfg, fmt.Errorf("%w: temp_suffix cannot be empty", plugins.ErrInvalidConfig)
+	}
+
+	port, err := settings.Int("port", 22)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Port = port
+
+	if cfg.Timeout, err = settings.Duration("timeout", 30*time.Second); err != nil {
+		return cfg, err
+	}
+
+	if key := settings.String("private_key", ""); key != "" {
+		cfg.auth.PrivateKey = []byte(key)
+	} else if keyFile := settings.String("private_key_file", ""); keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return cfg, fmt.Errorf("%w: failed to read private key: %v", plugins.ErrInvalidConfig, err)
+		}
+		cfg.auth.PrivateKey = data
+	}
+
+	insecure, err := settings.Bool("insecure_ignore_host_key", false)
+	if err != nil {
+		return cfg, err
+	}
+	knownHosts := settings.String("known_hosts", "")
+	switch {
+	case knownHosts != "":
+		callback, err := knownhosts.New(knownHosts)
+		if err != nil {
+			return cfg, fmt.Errorf("%w: failed to load known_hosts: %v", plugins.ErrInvalidConfig, err)
+		}
+		cfg.HostKeyCallback = callback
+	case insecure:
+		cfg.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return cfg, fmt.Errorf("%w: known_hosts is required unless insecure_ignore_host_key is set", plugins.ErrInvalidConfig)
+	}
+
+	return cfg, nil
+}
+
+// address returns the host:port pair to dial
+func (c config) address() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// clientConfig builds the SSH client configuration for the current credentials
+func (c config) clientConfig() (*ssh.ClientConfig, error) {
+	if c.auth.Username == "" {
+		return nil, fmt.Errorf("ssh username is required")
+	}
+
+	var methods []ssh.AuthMethod
+	if len(c.auth.PrivateKey) > 0 {
+		var (
+			signer ssh.Signer
+			err    error
+		)
+		if c.auth.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(c.auth.PrivateKey, []byte(c.auth.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(c.auth.PrivateKey)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if c.auth.Password != "" {
+		methods = append(methods, ssh.Password(c.auth.Password))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no ssh authentication method configured")
+	}
+
+	return &ssh.ClientConfig{
+		User:            c.auth.Username,
+		Auth:            methods,
+		HostKeyCallback: c.HostKeyCallback,
+		Timeout:         c.Timeout,
+	}, nil
+}
+
+// authFromCredentials converts service credentials into SSH authentication
+func authFromCredentials(creds interfaces.Credentials) (authConfig, error) {
+	username, _ := creds.Data["username"].(string)
+	if username == "" {
+		return authConfig{}, fmt.Errorf("%s credentials require a username", creds.Type)
+	}
+
+	switch creds.Type {
+	case interfaces.AuthTypeBasic:
+		password, _ := creds.Data["password"].(string)
+		if password == "" {
+			return authConfig{}, fmt.Errorf("basic credentials require a password")
+		}
+		return authConfig{Username: username, Password: password}, nil
+	case interfaces.AuthTypeSSHKey:
+		key, _ := creds.Data["private_key"].(string)
+		if key == "" {
+			return authConfig{}, fmt.Errorf("sshkey credentials require a private_key")
+		}
+		passphrase, _ := creds.Data["passphrase"].(string)
+		return authConfig{Username: username, PrivateKey: []byte(key), Passphrase: passphrase}, nil
+	}
+	return authConfig{}, fmt.Errorf("unsupported credential type: %s", creds.Type)
+}
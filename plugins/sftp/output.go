@@ -0,0 +1,392 @@
+package sftp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// posixRenameExtension is the OpenSSH extension for an atomic overwriting rename
+const posixRenameExtension = "posix-rename@openssh.com"
+
+// Metadata keys written by the output plugin
+const (
+	MetaRemotePath  = "sftp_path"
+	MetaSize        = "sftp_size"
+	MetaResumedFrom = "sftp_resumed_from"
+)
+
+// Output publishes data streams to a remote host over SFTP. Uploads are
+// written to a hidden temporary name that is stable per item, so an
+// interrupted transfer resumes from the bytes already on the server, and the
+// file is renamed into place once complete.
+type Output struct {
+	*plugins.BasePlugin
+
+	mu     sync.Mutex
+	cfg    config
+	ready  bool
+	ssh    *ssh.Client
+	client *pkgsftp.Client
+}
+
+// Ensure Output implements the required interfaces
+var (
+	_ plugins.Plugin           = (*Output)(nil)
+	_ interfaces.OutputService = (*Output)(nil)
+)
+
+// NewOutput creates an SFTP output plugin; it matches PluginFactoryFunc
+func NewOutput(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Output{
+		BasePlugin: plugins.NewBasePlugin(config, "output", "Uploads media to a remote host over SFTP"),
+	}, nil
+}
+
+// Configure applies plugin settings and drops any open connection
+func (o *Output) Configure(settings map[string]interface{}) error {
+	cfg, err := parseConfig(plugins.Settings(settings))
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.disconnectLocked()
+	o.cfg = cfg
+	o.ready = true
+	return nil
+}
+
+// Authenticate replaces the configured SSH credentials. Basic credentials
+// authenticate with a password, sshkey credentials with a private key.
+func (o *Output) Authenticate(ctx context.Context, creds interfaces.Credentials) error {
+	auth, err := authFromCredentials(creds)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.ready {
+		return fmt.Errorf("sftp output %s is not configured", o.GetMetadata().Name)
+	}
+	o.disconnectLocked()
+	o.cfg.auth = auth
+	_, err = o.connectLocked(ctx)
+	return err
+}
+
+// Start connects to the remote host
+func (o *Output) Start(ctx context.Context) error {
+	o.mu.Lock()
+	if !o.ready {
+		o.mu.Unlock()
+		return fmt.Errorf("sftp output %s is not configured", o.GetMetadata().Name)
+	}
+	_, err := o.connectLocked(ctx)
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return o.BasePlugin.Start(ctx)
+}
+
+// Stop closes the connection
+func (o *Output) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	o.disconnectLocked()
+	o.mu.Unlock()
+	return o.BasePlugin.Stop(ctx)
+}
+
+// Capabilities describes the output features
+func (o *Output) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "output", Supported: true, Config: map[string]interface{}{"protocol": "sftp"}},
+		{Type: "resumable_upload", Supported: true},
+		{Type: "atomic_rename", Supported: true},
+	}
+}
+
+// ConfigureDestination overrides the remote root directory
+func (o *Output) ConfigureDestination(dest interfaces.DestinationConfig) error {
+	root := plugins.Settings(dest.Config).String("root", "")
+	if root == "" {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.cfg.Root = path.Clean("/" + root)
+	return nil
+}
+
+// Publish uploads the stream content and renames it into place
+func (o *Output) Publish(ctx context.Context, data *interfaces.DataStream) error {
+	if data == nil {
+		return fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Content == nil {
+		return fmt.Errorf("data stream %s has no content", data.ID)
+	}
+	defer data.Content.Close()
+
+	// The session is shared, so uploads run without holding the lock
+	o.mu.Lock()
+	if !o.ready {
+		o.mu.Unlock()
+		return fmt.Errorf("sftp output %s is not configured", o.GetMetadata().Name)
+	}
+	cfg := o.cfg
+	client, err := o.connectLocked(ctx)
+	o.mu.Unlock()
+	if err != nil {
+		o.RecordError(err)
+		return err
+	}
+
+	rel, err := media.RemoteName(data)
+	if err != nil {
+		return err
+	}
+	target := path.Join(cfg.Root, rel)
+
+	written, resumedFrom, err := upload(ctx, client, cfg, data, target)
+	if err != nil {
+		// Drop the connection so the next attempt starts from a clean session
+		if !errors.Is(err, context.Canceled) {
+			o.disconnect(client)
+		}
+		o.RecordError(err)
+		return err
+	}
+
+	o.RecordError(nil)
+	media.SetMetadata(data, MetaRemotePath, target)
+	media.SetMetadata(data, MetaSize, written)
+	if resumedFrom > 0 {
+		media.SetMetadata(data, MetaResumedFrom, resumedFrom)
+	}
+	return nil
+}
+
+// upload writes data to the temporary name for target and renames it into
+// place, returning the final size and the offset the transfer resumed from
+func upload(ctx context.Context, client *pkgsftp.Client, cfg config, data *interfaces.DataStream, target string) (int64, int64, error) {
+	dir := path.Dir(target)
+	if err := client.MkdirAll(dir); err != nil {
+		return 0, 0, fmt.Errorf("failed to create remote directory %s: %w", dir, err)
+	}
+
+	temp := path.Join(dir, "."+path.Base(target)+"."+uploadKey(data)+cfg.TempSuffix)
+	length, known, err := contentLength(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var offset int64
+	if info, err := client.Stat(temp); err == nil {
+		offset = info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, 0, fmt.Errorf("failed to stat %s: %w", temp, err)
+	}
+
+	// Without an ETag or checksum the key names the item, not its content,
+	// and a partial file longer than the content cannot be a prefix of it
+	if offset > 0 && (!hasIdentity(data) || (known && offset > length)) {
+		offset = 0
+	}
+	if offset > 0 {
+		if err := skipContent(data.Content, offset); err != nil {
+			_ = client.Remove(temp)
+			return 0, 0, fmt.Errorf("discarded stale partial upload %s: %w", temp, err)
+		}
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := client.OpenFile(temp, flags)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open %s: %w", temp, err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return 0, 0, fmt.Errorf("failed to seek %s: %w", temp, err)
+	}
+
+	copied, copyErr := io.Copy(f, media.ContextReader(ctx, data.Content))
+	closeErr := f.Close()
+	if copyErr != nil {
+		return 0, 0, fmt.Errorf("upload of %s interrupted after %d bytes: %w", target, offset+copied, copyErr)
+	}
+	if closeErr != nil {
+		return 0, 0, fmt.Errorf("failed to close %s: %w", temp, closeErr)
+	}
+
+	size := offset + copied
+	if known && size != length {
+		// The partial file did not belong to this content
+		_ = client.Remove(temp)
+		return 0, 0, fmt.Errorf("size mismatch for %s: wrote %d bytes, expected %d", target, size, length)
+	}
+
+	if err := rename(client, temp, target); err != nil {
+		return 0, 0, err
+	}
+	return size, offset, nil
+}
+
+// connectLocked returns the open SFTP session, dialing if necessary
+func (o *Output) connectLocked(ctx context.Context) (*pkgsftp.Client, error) {
+	if o.client != nil {
+		return o.client, nil
+	}
+
+	clientConfig, err := o.cfg.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := o.cfg.address()
+	dialer := net.Dialer{Timeout: o.cfg.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, clientConfig)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s failed: %w", addr, err)
+	}
+	conn := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := pkgsftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+
+	o.ssh = conn
+	o.client = client
+	return client, nil
+}
+
+// disconnect drops client unless another caller has already replaced it
+func (o *Output) disconnect(client *pkgsftp.Client) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.client == client {
+		o.disconnectLocked()
+	}
+}
+
+func (o *Output) disconnectLocked() {
+	if o.client != nil {
+		_ = o.client.Close()
+		o.client = nil
+	}
+	if o.ssh != nil {
+		_ = o.ssh.Close()
+		o.ssh = nil
+	}
+}
+
+// rename moves temp over target, atomically when the server supports it
+func rename(client *pkgsftp.Client, temp, target string) error {
+	if _, ok := client.HasExtension(posixRenameExtension); ok {
+		if err := client.PosixRename(temp, target); err != nil {
+			return fmt.Errorf("failed to rename %s to %s: %w", temp, target, err)
+		}
+		return nil
+	}
+
+	// Plain SFTP rename refuses to overwrite, so remove the old file first
+	if err := client.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace %s: %w", target, err)
+	}
+	if err := client.Rename(temp, target); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", temp, target, err)
+	}
+	return nil
+}
+
+// skipContent advances r past the bytes already uploaded
+func skipContent(r io.Reader, offset int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		pos, err := seeker.Seek(offset, io.SeekStart)
+		if err == nil && pos != offset {
+			err = fmt.Errorf("seeked to %d instead of %d", pos, offset)
+		}
+		return err
+	}
+	n, err := io.CopyN(io.Discard, r, offset)
+	if err == io.EOF && n < offset {
+		return fmt.Errorf("content is shorter than partial upload (%d < %d bytes)", n, offset)
+	}
+	return err
+}
+
+// contentLength returns the size of the content from its Content-Length
+// header or, for seekable content such as a spooled blob, from its end
+func contentLength(data *interfaces.DataStream) (int64, bool, error) {
+	if v := data.Headers["Content-Length"]; v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return n, true, nil
+		}
+	}
+	seeker, ok := data.Content.(io.Seeker)
+	if !ok {
+		return 0, false, nil
+	}
+	pos, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Not every io.Seeker can seek, such as a pipe behind an *os.File
+		return 0, false, nil
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false, nil
+	}
+	if _, err := seeker.Seek(pos, io.SeekStart); err != nil {
+		return 0, false, fmt.Errorf("failed to rewind content: %w", err)
+	}
+	return end - pos, true, nil
+}
+
+// hasIdentity reports whether the stream names its content, through an ETag
+// or a checksum, so a partial upload can be known to be of the same bytes
+func hasIdentity(data *interfaces.DataStream) bool {
+	checksum, _ := data.Metadata["checksum"].(string)
+	return data.Headers["ETag"] != "" || checksum != ""
+}
+
+// uploadKey identifies the item so a partial upload is only resumed for the same content
+func uploadKey(data *interfaces.DataStream) string {
+	h := sha256.New()
+	h.Write([]byte(data.ID))
+	h.Write([]byte{0})
+	h.Write([]byte(data.Headers["ETag"]))
+	if checksum, ok := data.Metadata["checksum"].(string); ok {
+		h.Write([]byte{0})
+		h.Write([]byte(checksum))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
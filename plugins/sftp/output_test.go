@@ -0,0 +1,436 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pkgsftp "github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// testServer is an in-process SSH server exposing the sftp subsystem
+type testServer struct {
+	addr       string
+	hostKey    ssh.PublicKey
+	clientKey  []byte
+	knownHosts string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSSHPub, err := ssh.NewPublicKey(clientPub)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	require.NoError(t, err)
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "nas" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() == "nas" && bytes.Equal(key.Marshal(), clientSSHPub.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, serverConfig)
+		}
+	}()
+
+	addr := listener.Addr().String()
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{addr}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o600))
+
+	return &testServer{
+		addr:       addr,
+		hostKey:    hostSigner.PublicKey(),
+		clientKey:  pem.EncodeToMemory(block),
+		knownHosts: knownHostsFile,
+	}
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if ok {
+					server, err := pkgsftp.NewServer(channel)
+					if err == nil {
+						_ = server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func (s *testServer) settings(root string) map[string]interface{} {
+	host, port, _ := net.SplitHostPort(s.addr)
+	return map[string]interface{}{
+		"host":        host,
+		"port":        port,
+		"username":    "nas",
+		"password":    "secret",
+		"known_hosts": s.knownHosts,
+		"root":        root,
+	}
+}
+
+func newOutput(t *testing.T, settings map[string]interface{}) *Output {
+	t.Helper()
+
+	p, err := NewOutput(plugins.PluginConfig{Name: "nas", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+	require.NoError(t, p.Configure(settings))
+	require.NoError(t, p.Start(context.Background()))
+	t.Cleanup(func() { _ = p.Stop(context.Background()) })
+	return p.(*Output)
+}
+
+func stream(name string, r io.Reader) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "item-" + name,
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"path": name},
+		Content:  io.NopCloser(r),
+		Headers:  map[string]string{"Content-Type": "image/jpeg"},
+	}
+}
+
+func TestOutput_PublishWithPassword(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+
+	data := stream("2024/06/cat.jpg", strings.NewReader("meow"))
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	content, err := os.ReadFile(filepath.Join(root, "2024/06/cat.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "meow", string(content))
+	assert.Equal(t, filepath.ToSlash(filepath.Join(root, "2024/06/cat.jpg")), data.Metadata[MetaRemotePath])
+	assert.Equal(t, int64(4), data.Metadata[MetaSize])
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Join(root, "2024/06"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestOutput_PublishReplacesExistingFile(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+	ctx := context.Background()
+
+	require.NoError(t, out.Publish(ctx, stream("a.jpg", strings.NewReader("old"))))
+	replacement := stream("a.jpg", strings.NewReader("new content"))
+	replacement.ID = "item-a-v2"
+	require.NoError(t, out.Publish(ctx, replacement))
+
+	content, err := os.ReadFile(filepath.Join(root, "a.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "new content", string(content))
+}
+
+func TestOutput_SlowUploadDoesNotBlockOthers(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+	ctx := context.Background()
+
+	pr, pw := io.Pipe()
+	slow := make(chan error, 1)
+	go func() { slow <- out.Publish(ctx, stream("slow.jpg", pr)) }()
+	_, err := pw.Write([]byte("first half "))
+	require.NoError(t, err, "the slow upload has started")
+
+	fast := make(chan error, 1)
+	go func() { fast <- out.Publish(ctx, stream("fast.jpg", strings.NewReader("quick"))) }()
+	select {
+	case err := <-fast:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled upload blocked another publish")
+	}
+
+	_, err = pw.Write([]byte("second half"))
+	require.NoError(t, err)
+	require.NoError(t, pw.Close())
+	require.NoError(t, <-slow)
+
+	content, err := os.ReadFile(filepath.Join(root, "slow.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "first half second half", string(content))
+}
+
+func TestOutput_AuthenticateWithKey(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	settings := server.settings(root)
+	delete(settings, "password")
+	settings["private_key"] = string(server.clientKey)
+
+	out := newOutput(t, settings)
+	require.NoError(t, out.Publish(context.Background(), stream("key.jpg", strings.NewReader("k"))))
+
+	// Switching to password credentials reconnects with the new method
+	err := out.Authenticate(context.Background(), interfaces.Credentials{
+		Type: interfaces.AuthTypeBasic,
+		Data: map[string]interface{}{"username": "nas", "password": "wrong"},
+	})
+	assert.Error(t, err)
+
+	err = out.Authenticate(context.Background(), interfaces.Credentials{
+		Type: interfaces.AuthTypeSSHKey,
+		Data: map[string]interface{}{"username": "nas", "private_key": string(server.clientKey)},
+	})
+	assert.NoError(t, err)
+}
+
+func TestOutput_RejectsUnknownHostKey(t *testing.T) {
+	server := newTestServer(t)
+	other := newTestServer(t)
+
+	settings := server.settings(t.TempDir())
+	settings["known_hosts"] = other.knownHosts
+
+	p, err := NewOutput(plugins.PluginConfig{Name: "nas", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+	require.NoError(t, p.Configure(settings))
+
+	err = p.Start(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "key")
+}
+
+func TestOutput_RequiresHostKeyVerification(t *testing.T) {
+	p, err := NewOutput(plugins.PluginConfig{Name: "nas", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+
+	err = p.Configure(map[string]interface{}{"host": "nas.local", "username": "nas", "password": "x"})
+	assert.ErrorIs(t, err, plugins.ErrInvalidConfig)
+}
+
+// failingReader returns an error after limit bytes to simulate a dropped connection
+type failingReader struct {
+	r     io.Reader
+	limit int
+	read  int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.read >= f.limit {
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > f.limit-f.read {
+		p = p[:f.limit-f.read]
+	}
+	n, err := f.r.Read(p)
+	f.read += n
+	return n, err
+}
+
+// countingReader records how many bytes were consumed
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestOutput_ResumesInterruptedUpload(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+	ctx := context.Background()
+
+	payload := bytes.Repeat([]byte("0123456789"), 10000)
+
+	first := stream("video.mp4", &failingReader{r: bytes.NewReader(payload), limit: 40000})
+	first.Headers["ETag"] = `"v1"`
+	err := out.Publish(ctx, first)
+	require.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(root, "video.mp4"))
+	assert.True(t, os.IsNotExist(err), "incomplete upload must not be visible")
+
+	second := stream("video.mp4", bytes.NewReader(payload))
+	second.Headers["ETag"] = `"v1"`
+	second.Headers["Content-Length"] = "100000"
+	require.NoError(t, out.Publish(ctx, second))
+
+	content, err := os.ReadFile(filepath.Join(root, "video.mp4"))
+	require.NoError(t, err)
+	assert.Equal(t, payload, content)
+	assert.Equal(t, int64(40000), second.Metadata[MetaResumedFrom])
+}
+
+func TestOutput_ResumeSkipsNonSeekableContent(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+	ctx := context.Background()
+
+	payload := []byte("abcdefghijklmnopqrstuvwxyz")
+	item := stream("letters.txt", nil)
+	item.Metadata["checksum"] = "sha256:71c480df93d6ae2f1efad1447c66c9525e316218cf51fc8d9ed832f2daf18b73"
+	temp := filepath.Join(root, ".letters.txt."+uploadKey(item)+".part")
+	require.NoError(t, os.WriteFile(temp, payload[:10], 0o644))
+
+	counter := &countingReader{r: bytes.NewReader(payload)}
+	item.Content = io.NopCloser(struct{ io.Reader }{counter})
+	require.NoError(t, out.Publish(ctx, item))
+
+	content, err := os.ReadFile(filepath.Join(root, "letters.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, payload, content)
+	assert.Equal(t, int64(10), item.Metadata[MetaResumedFrom])
+
+	_, err = os.Stat(temp)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestOutput_DiscardsStalePartialUpload(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+	ctx := context.Background()
+
+	item := stream("short.txt", nil)
+	item.Headers["ETag"] = `"v1"`
+	temp := filepath.Join(root, ".short.txt."+uploadKey(item)+".part")
+	require.NoError(t, os.WriteFile(temp, []byte("this partial file is longer than the content"), 0o644))
+
+	item.Content = io.NopCloser(struct{ io.Reader }{strings.NewReader("tiny")})
+	require.Error(t, out.Publish(ctx, item))
+
+	retry := stream("short.txt", strings.NewReader("tiny"))
+	retry.Headers["ETag"] = `"v1"`
+	require.NoError(t, out.Publish(ctx, retry))
+
+	content, err := os.ReadFile(filepath.Join(root, "short.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "tiny", string(content))
+}
+
+func TestOutput_ResumeNeedsContentIdentity(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+	ctx := context.Background()
+
+	// Without an ETag or checksum the partial file may be of an older version
+	item := stream("notes.txt", strings.NewReader("new content"))
+	temp := filepath.Join(root, ".notes.txt."+uploadKey(item)+".part")
+	require.NoError(t, os.WriteFile(temp, []byte("old"), 0o644))
+	require.NoError(t, out.Publish(ctx, item))
+
+	content, err := os.ReadFile(filepath.Join(root, "notes.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new content", string(content))
+	assert.Nil(t, item.Metadata[MetaResumedFrom])
+}
+
+func TestOutput_ResumeChecksSeekableLength(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+	ctx := context.Background()
+
+	// Seeking a file past its end succeeds, so the length is checked first
+	src := filepath.Join(t.TempDir(), "src")
+	require.NoError(t, os.WriteFile(src, []byte("tiny"), 0o644))
+	f, err := os.Open(src)
+	require.NoError(t, err)
+
+	item := stream("short.txt", nil)
+	item.Headers["ETag"] = `"v1"`
+	item.Content = f
+	temp := filepath.Join(root, ".short.txt."+uploadKey(item)+".part")
+	require.NoError(t, os.WriteFile(temp, []byte("this partial file is longer than the content"), 0o644))
+	require.NoError(t, out.Publish(ctx, item))
+
+	content, err := os.ReadFile(filepath.Join(root, "short.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "tiny", string(content))
+	assert.Nil(t, item.Metadata[MetaResumedFrom])
+}
+
+func TestOutput_RejectsWrongSize(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	out := newOutput(t, server.settings(root))
+
+	item := stream("cut.txt", strings.NewReader("abc"))
+	item.Headers["Content-Length"] = "10"
+	require.Error(t, out.Publish(context.Background(), item))
+
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "a temp file of the wrong size is neither finalized nor kept")
+}
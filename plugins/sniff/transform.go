@@ -0,0 +1,256 @@
+// Package sniff provides a transform that identifies stream content by its
+// magic bytes, corrects the declared type and rejects truncated or corrupt
+// files before they reach storage.
+package sniff
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform
+const (
+	// MetaDeclaredType records the Content-Type a source declared when the
+	// content turned out to be something else
+	MetaDeclaredType = "declared_content_type"
+	// MetaValidated is true when the content structure was checked
+	MetaValidated = "content_validated"
+)
+
+// defaultMaxBytes bounds the content buffered for validation; larger files
+// are sniffed but not validated
+const defaultMaxBytes = 32 << 20
+
+// Transform sniffs the content type of each stream and validates the
+// structure of JPEG, PNG and MP4/QuickTime files. Streams that fail are
+// closed and rejected with interfaces.ErrStreamRejected.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu            sync.RWMutex
+	validate      bool
+	maxBytes      int64
+	fixExtension  bool
+	rejectUnknown bool
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a content sniffing transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin:   plugins.NewBasePlugin(config, "transform", "Detects content types from magic bytes and rejects corrupt files"),
+		validate:     true,
+		maxBytes:     defaultMaxBytes,
+		fixExtension: true,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	validate, err := settings.Bool("validate", true)
+	if err != nil {
+		return err
+	}
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	if maxBytes <= 0 {
+		return fmt.Errorf("%w: max_bytes must be positive", plugins.ErrInvalidConfig)
+	}
+	fixExtension, err := settings.Bool("fix_extension", true)
+	if err != nil {
+		return err
+	}
+	rejectUnknown, err := settings.Bool("reject_unknown", false)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.validate = validate
+	t.maxBytes = int64(maxBytes)
+	t.fixExtension = fixExtension
+	t.rejectUnknown = rejectUnknown
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"validated_types": []string{"image/jpeg", "image/png", "video/mp4", "video/quicktime", "audio/mp4"},
+		}},
+	}
+}
+
+// ValidateSchema accepts streams of any type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	return nil
+}
+
+// Transform sets the Content-Type header and Type of data from its content.
+// A media type found in the content replaces whatever was declared; a
+// declared media type whose content turns out to be text, such as an HTML
+// error page, is rejected. Only the head of the content is read to sniff
+// it, and only files of validated types are read further, up to max_bytes;
+// larger ones are sniffed but not validated. Re-openable content is read
+// through a second reader, so data keeps the original one.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	validate, maxBytes, fixExtension, rejectUnknown := t.validate, t.maxBytes, t.fixExtension, t.rejectUnknown
+	t.mu.RUnlock()
+
+	sniffHead, err := head(data, media.SniffLen)
+	if err != nil {
+		return nil, t.reject(data, fmt.Errorf("failed to read content: %w", err))
+	}
+
+	declared := baseType(data.Headers["Content-Type"])
+	sniffed := media.Sniff(sniffHead)
+	contentType, err := resolve(declared, sniffed, rejectUnknown)
+	if err != nil {
+		return nil, t.reject(data, err)
+	}
+
+	validated := false
+	if validate && media.Validatable(contentType) {
+		file, err := head(data, int(maxBytes)+1)
+		if err != nil {
+			return nil, t.reject(data, fmt.Errorf("failed to read content: %w", err))
+		}
+		if int64(len(file)) <= maxBytes {
+			if err := media.Validate(contentType, file); err != nil {
+				return nil, t.reject(data, err)
+			}
+			validated = true
+		}
+	}
+
+	if data.Headers == nil {
+		data.Headers = make(map[string]string)
+	}
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	data.Headers["Content-Type"] = contentType
+	if mediaType := media.TypeOf(contentType); mediaType != "" {
+		data.Type = mediaType
+	}
+	if declared != "" && declared != baseType(contentType) {
+		data.Metadata[MetaDeclaredType] = declared
+	}
+	if fixExtension && isMedia(contentType) {
+		if filename, ok := data.Metadata["filename"].(string); ok && filename != "" {
+			data.Metadata["filename"] = withExtension(filename, contentType)
+		}
+	}
+	data.Metadata[MetaValidated] = validated
+
+	t.RecordError(nil)
+	return data, nil
+}
+
+// head reads up to n bytes of the content of data. Re-openable content is
+// read from a second reader so data keeps its original one; other content
+// is replaced by a reader that yields it in full.
+func head(data *interfaces.DataStream, n int) ([]byte, error) {
+	if r, ok := data.Content.(interfaces.ReopenableContent); ok {
+		rc, err := r.Reopen()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(io.LimitReader(rc, int64(n)))
+	}
+	b, content, err := media.Peek(data.Content, n)
+	data.Content = content
+	return b, err
+}
+
+// resolve picks the content type of a stream from what was declared and
+// what was sniffed
+func resolve(declared, sniffed string, rejectUnknown bool) (string, error) {
+	switch {
+	case isMedia(sniffed):
+		return sniffed, nil
+	case sniffed == media.OctetStream:
+		if rejectUnknown {
+			return "", fmt.Errorf("content matches no known format")
+		}
+		if declared == "" {
+			return media.OctetStream, nil
+		}
+		return declared, nil
+	case isMedia(declared):
+		return "", fmt.Errorf("declared %s but content is %s", declared, baseType(sniffed))
+	case declared == "" || declared == media.OctetStream:
+		return sniffed, nil
+	}
+	// Keep declared text types, such as text/markdown, that sniff as plain text
+	return declared, nil
+}
+
+// reject closes the content of data and returns the rejection error
+func (t *Transform) reject(data *interfaces.DataStream, reason error) error {
+	_ = data.Content.Close()
+	t.RecordError(reason)
+	return fmt.Errorf("%w: %s: %w", interfaces.ErrStreamRejected, data.ID, reason)
+}
+
+// withExtension replaces the extension of filename unless it already names
+// contentType
+func withExtension(filename, contentType string) string {
+	want := media.Extension(contentType)
+	if want == "" {
+		return filename
+	}
+	ext := path.Ext(filename)
+	if strings.EqualFold(ext, want) || (ext != "" && baseType(mime.TypeByExtension(ext)) == baseType(contentType)) {
+		return filename
+	}
+	return strings.TrimSuffix(filename, ext) + want
+}
+
+func isMedia(contentType string) bool {
+	switch media.TypeOf(contentType) {
+	case interfaces.MediaTypePhoto, interfaces.MediaTypeVideo, interfaces.MediaTypeAudio:
+		return true
+	}
+	return false
+}
+
+func baseType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
@@ -0,0 +1,179 @@
+package sniff
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "sniff", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func pngBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))))
+	return buf.Bytes()
+}
+
+func stream(content []byte, contentType, filename string) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "item-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"filename": filename},
+		Content:  &closeTracker{Reader: bytes.NewReader(content)},
+		Headers:  map[string]string{"Content-Type": contentType},
+	}
+}
+
+func TestTransform_CorrectsType(t *testing.T) {
+	tr := newTransform(t, nil)
+	content := pngBytes(t)
+
+	out, err := tr.Transform(context.Background(), stream(content, "image/jpeg", "photo.jpg"))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", out.Headers["Content-Type"])
+	assert.Equal(t, interfaces.MediaTypePhoto, out.Type)
+	assert.Equal(t, "image/jpeg", out.Metadata[MetaDeclaredType])
+	assert.Equal(t, "photo.png", out.Metadata["filename"])
+	assert.Equal(t, true, out.Metadata[MetaValidated])
+
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, content, b, "content is passed on unconsumed")
+
+	video := stream([]byte("\x00\x00\x00\x10ftypisom\x00\x00\x02\x00\x00\x00\x00\x08moov"), "application/octet-stream", "clip")
+	out, err = tr.Transform(context.Background(), video)
+	require.NoError(t, err)
+	assert.Equal(t, "video/mp4", out.Headers["Content-Type"])
+	assert.Equal(t, interfaces.MediaTypeVideo, out.Type)
+	assert.Equal(t, "clip.mp4", out.Metadata["filename"])
+
+	text := stream([]byte("# Notes"), "text/markdown", "notes.md")
+	text.Type = interfaces.MediaTypeText
+	out, err = tr.Transform(context.Background(), text)
+	require.NoError(t, err)
+	assert.Equal(t, "text/markdown", out.Headers["Content-Type"])
+	assert.Equal(t, "notes.md", out.Metadata["filename"])
+	assert.NotContains(t, out.Metadata, MetaDeclaredType)
+	assert.Equal(t, false, out.Metadata[MetaValidated])
+
+	out, err = tr.Transform(context.Background(), stream(content, "image/png", "photo.PNG"))
+	require.NoError(t, err)
+	assert.Equal(t, "photo.PNG", out.Metadata["filename"])
+}
+
+func TestTransform_Rejects(t *testing.T) {
+	ctx := context.Background()
+	tr := newTransform(t, nil)
+	content := pngBytes(t)
+
+	truncated := stream(content[:len(content)-8], "image/png", "photo.png")
+	src := truncated.Content.(*closeTracker)
+	_, err := tr.Transform(ctx, truncated)
+	assert.ErrorIs(t, err, interfaces.ErrStreamRejected)
+	assert.ErrorIs(t, err, media.ErrTruncated)
+	assert.Contains(t, err.Error(), "item-1")
+	assert.True(t, src.closed)
+
+	errorPage := stream([]byte("<html><body>Not Found</body></html>"), "image/jpeg", "photo.jpg")
+	_, err = tr.Transform(ctx, errorPage)
+	assert.ErrorIs(t, err, interfaces.ErrStreamRejected)
+	assert.Contains(t, err.Error(), "declared image/jpeg but content is text/html")
+
+	unknown := stream([]byte{0x00, 0x01, 0x02}, "image/x-raw", "photo.raw")
+	out, err := tr.Transform(ctx, unknown)
+	require.NoError(t, err)
+	assert.Equal(t, "image/x-raw", out.Headers["Content-Type"])
+
+	strict := newTransform(t, map[string]interface{}{"reject_unknown": true})
+	_, err = strict.Transform(ctx, stream([]byte{0x00, 0x01, 0x02}, "image/x-raw", "photo.raw"))
+	assert.ErrorIs(t, err, interfaces.ErrStreamRejected)
+
+	lenient := newTransform(t, map[string]interface{}{"validate": false})
+	out, err = lenient.Transform(ctx, stream(content[:len(content)-8], "image/png", "photo.png"))
+	require.NoError(t, err)
+	assert.Equal(t, false, out.Metadata[MetaValidated])
+
+	small := newTransform(t, map[string]interface{}{"max_bytes": 64})
+	out, err = small.Transform(ctx, stream(content[:len(content)-8], "image/png", "photo.png"))
+	require.NoError(t, err, "content over max_bytes is not validated")
+	assert.Equal(t, "image/png", out.Headers["Content-Type"])
+}
+
+// reopenable is content that can be read again from the start, as a
+// stored blob can
+type reopenable struct {
+	*closeTracker
+	content []byte
+	reopens int
+}
+
+func (r *reopenable) Reopen() (io.ReadCloser, error) {
+	r.reopens++
+	return io.NopCloser(bytes.NewReader(r.content)), nil
+}
+
+func TestTransform_KeepsReopenableContent(t *testing.T) {
+	ctx := context.Background()
+	tr := newTransform(t, nil)
+	content := pngBytes(t)
+
+	data := stream(content, "image/png", "photo.png")
+	original := &reopenable{closeTracker: data.Content.(*closeTracker), content: content}
+	data.Content = original
+	out, err := tr.Transform(ctx, data)
+	require.NoError(t, err)
+	assert.Same(t, original, out.Content, "the original reader is passed on")
+	assert.Equal(t, 2, original.reopens, "the content is sniffed and validated from second readers")
+	assert.Equal(t, true, out.Metadata[MetaValidated])
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, content, b, "the original reader is not consumed")
+
+	truncated := stream(content[:len(content)-8], "image/png", "photo.png")
+	rotten := &reopenable{closeTracker: truncated.Content.(*closeTracker), content: content[:len(content)-8]}
+	truncated.Content = rotten
+	_, err = tr.Transform(ctx, truncated)
+	assert.ErrorIs(t, err, media.ErrTruncated)
+	assert.True(t, rotten.closed)
+}
+
+func TestTransform_Configure(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "sniff", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"max_bytes": 0}), plugins.ErrInvalidConfig)
+	assert.Error(t, tr.Configure(map[string]interface{}{"validate": "sometimes"}))
+
+	out, err := tr.Transform(context.Background(), &interfaces.DataStream{ID: "empty"})
+	require.NoError(t, err)
+	assert.Nil(t, out.Content)
+}
@@ -0,0 +1,263 @@
+// Package videoprobe provides a transform that reads duration, dimensions,
+// codecs, creation time and location from MP4, QuickTime and WebM/Matroska
+// containers into DataStream.Metadata without external tools.
+package videoprobe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/media/videometa"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the transform besides the canonical duration,
+// width, height, captured_at and GPS fields
+const (
+	KeyContainer  = "container"
+	KeyVideoCodec = "video_codec"
+	KeyAudioCodec = "audio_codec"
+	KeyRotation   = "rotation"
+)
+
+const (
+	defaultScanBytes = 4 << 20
+	defaultMaxBytes  = 4 << 30
+)
+
+// Transform probes video containers from the first scan_bytes of the
+// content. MP4 files whose moov box follows the media are spooled to a
+// temporary file so the whole container can be read; Content is then
+// served from that file, which is removed when Content is closed.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu        sync.RWMutex
+	scanBytes int
+	spool     bool
+	maxBytes  int64
+	tempDir   string
+	overwrite bool
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a video probing transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin: plugins.NewBasePlugin(config, "transform", "Reads container metadata from MP4, QuickTime and WebM videos"),
+		scanBytes:  defaultScanBytes,
+		spool:      true,
+		maxBytes:   defaultMaxBytes,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	scanBytes, err := settings.Int("scan_bytes", defaultScanBytes)
+	if err != nil {
+		return err
+	}
+	if scanBytes < 64 {
+		return fmt.Errorf("%w: scan_bytes must be at least 64", plugins.ErrInvalidConfig)
+	}
+	spool, err := settings.Bool("spool", true)
+	if err != nil {
+		return err
+	}
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	if maxBytes < scanBytes {
+		return fmt.Errorf("%w: max_bytes must be at least scan_bytes", plugins.ErrInvalidConfig)
+	}
+	tempDir := settings.String("temp_dir", "")
+	if tempDir != "" {
+		if info, err := os.Stat(tempDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("%w: temp_dir %s is not a directory", plugins.ErrInvalidConfig, tempDir)
+		}
+	}
+	overwrite, err := settings.Bool("overwrite", false)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scanBytes = scanBytes
+	t.spool = spool
+	t.maxBytes = int64(maxBytes)
+	t.tempDir = tempDir
+	t.overwrite = overwrite
+	return nil
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types": []string{string(interfaces.MediaTypeVideo)},
+			"formats": []string{
+				videometa.FormatMP4, videometa.FormatQuickTime, videometa.FormatMatroska, videometa.FormatWebM,
+			},
+		}},
+	}
+}
+
+// ValidateSchema accepts video streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypeVideo) {
+		return fmt.Errorf("video probing does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// Transform adds container metadata to video streams. Keys already present
+// are kept unless overwrite is set; other streams, and videos in
+// containers that cannot be read, pass through unchanged.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Type != interfaces.MediaTypeVideo || data.Content == nil {
+		return data, nil
+	}
+
+	t.mu.RLock()
+	scanBytes, spool, maxBytes, tempDir, overwrite := t.scanBytes, t.spool, t.maxBytes, t.tempDir, t.overwrite
+	t.mu.RUnlock()
+
+	head, content, err := media.Peek(data.Content, scanBytes)
+	data.Content = content
+	if err != nil {
+		t.RecordError(err)
+		return nil, fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+
+	info, err := videometa.Probe(bytes.NewReader(head), int64(len(head)))
+	if errors.Is(err, videometa.ErrIncomplete) && len(head) == scanBytes && spool {
+		var file *spooled
+		if file, err = spoolContent(data.Content, maxBytes, tempDir); err != nil {
+			t.RecordError(err)
+			return nil, fmt.Errorf("failed to spool %s: %w", data.ID, err)
+		}
+		data.Content = file
+		info, err = videometa.Probe(file.section, file.section.Size())
+	}
+	switch {
+	case errors.Is(err, videometa.ErrUnknownFormat), errors.Is(err, videometa.ErrIncomplete):
+		return data, nil
+	case err != nil:
+		// A damaged container is left for validation to reject
+		t.RecordError(fmt.Errorf("failed to probe %s: %w", data.ID, err))
+		return data, nil
+	}
+
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	for key, value := range Fields(info) {
+		if _, exists := data.Metadata[key]; exists && !overwrite {
+			continue
+		}
+		data.Metadata[key] = value
+	}
+	t.RecordError(nil)
+	return data, nil
+}
+
+// Fields converts probed container metadata to metadata keys, omitting
+// unknown values
+func Fields(info *videometa.Info) map[string]interface{} {
+	fields := map[string]interface{}{KeyContainer: info.Format}
+	if info.Duration > 0 {
+		fields[interfaces.MetaDuration] = round(info.Duration.Seconds(), 3)
+	}
+	if info.Width > 0 && info.Height > 0 {
+		fields[interfaces.MetaWidth] = info.Width
+		fields[interfaces.MetaHeight] = info.Height
+	}
+	if info.Rotation != 0 {
+		fields[KeyRotation] = info.Rotation
+	}
+	if info.VideoCodec != "" {
+		fields[KeyVideoCodec] = info.VideoCodec
+	}
+	if info.AudioCodec != "" {
+		fields[KeyAudioCodec] = info.AudioCodec
+	}
+	if !info.CreatedAt.IsZero() {
+		fields[interfaces.MetaCapturedAt] = info.CreatedAt.Format(time.RFC3339)
+	}
+	if loc := info.Location; loc != nil {
+		fields[interfaces.MetaLatitude] = round(loc.Latitude, 6)
+		fields[interfaces.MetaLongitude] = round(loc.Longitude, 6)
+		if loc.Altitude != nil {
+			fields[interfaces.MetaAltitude] = round(*loc.Altitude, 2)
+		}
+	}
+	return fields
+}
+
+// spooled is Content served from a temporary file, followed by whatever
+// of the original stream exceeded max_bytes. Closing it removes the file.
+type spooled struct {
+	io.Reader
+	section *io.SectionReader
+	file    *os.File
+	rest    io.Closer
+}
+
+func (s *spooled) Close() error {
+	err := s.rest.Close()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(s.file.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// spoolContent copies up to maxBytes of rc to a temporary file in dir
+func spoolContent(rc io.ReadCloser, maxBytes int64, dir string) (*spooled, error) {
+	file, err := os.CreateTemp(dir, "videoprobe-*")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(file, io.LimitReader(rc, maxBytes))
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		_ = rc.Close()
+		return nil, err
+	}
+	section := io.NewSectionReader(file, 0, n)
+	return &spooled{
+		Reader:  io.MultiReader(io.NewSectionReader(file, 0, n), rc),
+		section: section,
+		file:    file,
+		rest:    rc,
+	}, nil
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,135 @@
+package videoprobe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "videoprobe", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+func box(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	return append(binary.BigEndian.AppendUint32([]byte(nil), uint32(8+len(payload))), append([]byte(typ), payload...)...)
+}
+
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// sampleMP4 builds a 1280x720 H.264 file of 2.5 seconds recorded in Tokyo,
+// with the moov box after mdatSize bytes of media
+func sampleMP4(mdatSize int) []byte {
+	created := uint32(time.Date(2024, 2, 3, 10, 20, 30, 0, time.UTC).Sub(time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)) / time.Second)
+	matrix := make([]byte, 36)
+	binary.BigEndian.PutUint32(matrix, 1<<16)
+	binary.BigEndian.PutUint32(matrix[16:], 1<<16)
+	moov := box("moov",
+		box("mvhd", make([]byte, 4), u32(created), u32(created), u32(1000), u32(2500), make([]byte, 80)),
+		box("trak",
+			box("tkhd", make([]byte, 4), make([]byte, 20), make([]byte, 16), matrix, u32(1280<<16), u32(720<<16)),
+			box("mdia",
+				box("hdlr", make([]byte, 8), []byte("vide"), make([]byte, 12)),
+				box("minf", box("stbl", box("stsd", make([]byte, 4), u32(1), box("avc1", make([]byte, 78))))),
+			),
+		),
+		box("udta", box("\xa9xyz", []byte{0, 22, 0x15, 0xc7}, []byte("+35.658600+139.745400/"))),
+	)
+	return bytes.Join([][]byte{box("ftyp", []byte("isom"), u32(0)), box("mdat", make([]byte, mdatSize)), moov}, nil)
+}
+
+func videoStream(content []byte) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "clip-1",
+		Type:     interfaces.MediaTypeVideo,
+		Metadata: map[string]interface{}{"width": 640},
+		Content:  io.NopCloser(bytes.NewReader(content)),
+	}
+}
+
+func TestTransform_Probe(t *testing.T) {
+	content := sampleMP4(64)
+	out, err := newTransform(t, nil).Transform(context.Background(), videoStream(content))
+	require.NoError(t, err)
+
+	md := out.Metadata
+	assert.Equal(t, 2.5, md[interfaces.MetaDuration])
+	assert.Equal(t, 640, md[interfaces.MetaWidth], "existing values are kept")
+	assert.Equal(t, 720, md[interfaces.MetaHeight])
+	assert.Equal(t, "h264", md[KeyVideoCodec])
+	assert.Equal(t, "mp4", md[KeyContainer])
+	assert.Equal(t, "2024-02-03T10:20:30Z", md[interfaces.MetaCapturedAt])
+	assert.Equal(t, 35.6586, md[interfaces.MetaLatitude])
+	assert.Equal(t, 139.7454, md[interfaces.MetaLongitude])
+	assert.NoError(t, interfaces.ValidateMetadata(md))
+
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+}
+
+func TestTransform_SpoolsTrailingMoov(t *testing.T) {
+	dir := t.TempDir()
+	content := sampleMP4(4096)
+
+	tr := newTransform(t, map[string]interface{}{"scan_bytes": 1024, "temp_dir": dir, "overwrite": true})
+	out, err := tr.Transform(context.Background(), videoStream(content))
+	require.NoError(t, err)
+	assert.Equal(t, 1280, out.Metadata[interfaces.MetaWidth])
+
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+	require.NoError(t, out.Content.Close())
+	entries, _ = os.ReadDir(dir)
+	assert.Empty(t, entries, "the spool file is removed on close")
+
+	capped := newTransform(t, map[string]interface{}{"scan_bytes": 1024, "max_bytes": 2048, "temp_dir": dir})
+	out, err = capped.Transform(context.Background(), videoStream(content))
+	require.NoError(t, err)
+	assert.NotContains(t, out.Metadata, KeyContainer, "moov lies beyond max_bytes")
+	b, err = io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, content, b, "content beyond max_bytes still follows")
+	require.NoError(t, out.Content.Close())
+
+	noSpool := newTransform(t, map[string]interface{}{"scan_bytes": 1024, "spool": false})
+	out, err = noSpool.Transform(context.Background(), videoStream(content))
+	require.NoError(t, err)
+	assert.NotContains(t, out.Metadata, KeyContainer)
+}
+
+func TestTransform_PassThrough(t *testing.T) {
+	tr := newTransform(t, nil)
+
+	photo := videoStream(sampleMP4(8))
+	photo.Type = interfaces.MediaTypePhoto
+	out, err := tr.Transform(context.Background(), photo)
+	require.NoError(t, err)
+	assert.NotContains(t, out.Metadata, KeyContainer)
+
+	out, err = tr.Transform(context.Background(), videoStream([]byte("not a video at all")))
+	require.NoError(t, err)
+	assert.NotContains(t, out.Metadata, KeyContainer)
+
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"scan_bytes": 8}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, tr.Configure(map[string]interface{}{"temp_dir": "/does/not/exist"}), plugins.ErrInvalidConfig)
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "photo"}))
+}
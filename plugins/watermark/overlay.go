@@ -0,0 +1,125 @@
+package watermark
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Overlay positions
+const (
+	PositionTopLeft     = "top-left"
+	PositionTop         = "top"
+	PositionTopRight    = "top-right"
+	PositionLeft        = "left"
+	PositionCenter      = "center"
+	PositionRight       = "right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottom      = "bottom"
+	PositionBottomRight = "bottom-right"
+)
+
+var positions = map[string][2]int{
+	PositionTopLeft: {0, 0}, PositionTop: {1, 0}, PositionTopRight: {2, 0},
+	PositionLeft: {0, 1}, PositionCenter: {1, 1}, PositionRight: {2, 1},
+	PositionBottomLeft: {0, 2}, PositionBottom: {1, 2}, PositionBottomRight: {2, 2},
+}
+
+// textSize is the font size text overlays are rendered at before scaling
+const textSize = 96
+
+// Overlay is the mark composited onto images: a decoded image, or text
+// rendered once at a fixed size
+type Overlay struct {
+	Mark     image.Image
+	Position string
+	// Opacity multiplies the alpha of the mark, from 0 to 1
+	Opacity float64
+	// Scale is the width of the mark relative to the image width
+	Scale float64
+	// Margin is the distance from the edges relative to the shorter side
+	Margin float64
+}
+
+// renderText draws text in c with the bundled Go Bold font on a
+// transparent background cropped to the text
+func renderText(text string, c color.Color) (image.Image, error) {
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: textSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("text overlay %q renders empty", text)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+	d.DrawString(text)
+	return dst, nil
+}
+
+// parseColor reads #rgb, #rrggbb and #rrggbbaa colors
+func parseColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// Apply returns img with the mark composited at the configured position
+func (o *Overlay) Apply(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	mb := o.Mark.Bounds()
+	w := max(1, int(float64(b.Dx())*o.Scale+0.5))
+	h := max(1, int(float64(w)*float64(mb.Dy())/float64(mb.Dx())+0.5))
+	// Keep tall marks inside the image
+	if h > b.Dy() {
+		h = b.Dy()
+		w = max(1, int(float64(h)*float64(mb.Dx())/float64(mb.Dy())+0.5))
+	}
+	mark := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(mark, mark.Rect, o.Mark, mb, draw.Src, nil)
+
+	margin := int(o.Margin * float64(min(b.Dx(), b.Dy())))
+	pos := positions[o.Position]
+	x := margin + pos[0]*(b.Dx()-w-2*margin)/2
+	y := margin + pos[1]*(b.Dy()-h-2*margin)/2
+	at := image.Rect(x, y, x+w, y+h)
+
+	alpha := image.NewUniform(color.Alpha{A: uint8(o.Opacity*255 + 0.5)})
+	draw.DrawMask(dst, at, mark, image.Point{}, alpha, image.Point{}, draw.Over)
+	return dst
+}
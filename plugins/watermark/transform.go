@@ -0,0 +1,344 @@
+// Package watermark provides a transform that composites an image or text
+// overlay onto photos for pipelines that opt in, typically ones publishing
+// to public outputs.
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/media/imaging"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// KeyWatermark records the ID of the applied watermark; streams that carry
+// it are not watermarked again
+const KeyWatermark = "watermark"
+
+// FormatAuto keeps PNG for PNG and GIF sources that are transparent and
+// uses JPEG otherwise
+const FormatAuto = "auto"
+
+const (
+	defaultQuality   = 90
+	defaultOpacity   = 0.5
+	defaultScale     = 0.25
+	defaultMargin    = 0.03
+	defaultMaxBytes  = 256 << 20
+	defaultMaxPixels = 100_000_000
+)
+
+// Transform watermarks photos, and photos derived from them, in the
+// pipelines listed in its pipelines setting. Every other pipeline passes
+// streams through untouched, so sharing the service cannot watermark a
+// private archive by accident.
+type Transform struct {
+	*plugins.BasePlugin
+
+	mu        sync.RWMutex
+	overlay   *Overlay
+	id        string
+	pipelines map[string]bool
+	format    string
+	quality   int
+	derived   bool
+	required  bool
+	maxBytes  int64
+	maxPixels int
+}
+
+// Ensure Transform implements the required interfaces
+var (
+	_ plugins.Plugin              = (*Transform)(nil)
+	_ interfaces.TransformService = (*Transform)(nil)
+)
+
+// NewTransform creates a watermark transform; it matches PluginFactoryFunc
+func NewTransform(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Transform{
+		BasePlugin: plugins.NewBasePlugin(config, "transform", "Composites an image or text watermark onto photos"),
+		format:     FormatAuto,
+		quality:    defaultQuality,
+		derived:    true,
+		required:   true,
+		maxBytes:   defaultMaxBytes,
+		maxPixels:  defaultMaxPixels,
+	}, nil
+}
+
+// Configure applies plugin settings. Exactly one of image, a path to a PNG
+// or other decodable image, and text is required, as is the list of
+// pipelines that opt in.
+func (t *Transform) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	pipelineNames, err := settings.StringSlice("pipelines")
+	if err != nil {
+		return err
+	}
+	if len(pipelineNames) == 0 {
+		return fmt.Errorf("%w: pipelines must list the pipelines that opt in to watermarking", plugins.ErrInvalidConfig)
+	}
+	pipelines := make(map[string]bool, len(pipelineNames))
+	for _, name := range pipelineNames {
+		pipelines[name] = true
+	}
+
+	overlay := &Overlay{Position: settings.String("position", PositionBottomRight)}
+	if _, ok := positions[overlay.Position]; !ok {
+		return fmt.Errorf("%w: unknown position %q", plugins.ErrInvalidConfig, overlay.Position)
+	}
+	if overlay.Opacity, err = settings.Float("opacity", defaultOpacity); err != nil {
+		return err
+	}
+	if overlay.Opacity <= 0 || overlay.Opacity > 1 {
+		return fmt.Errorf("%w: opacity must be above 0 and at most 1", plugins.ErrInvalidConfig)
+	}
+	if overlay.Scale, err = settings.Float("scale", defaultScale); err != nil {
+		return err
+	}
+	if overlay.Scale <= 0 || overlay.Scale > 1 {
+		return fmt.Errorf("%w: scale must be above 0 and at most 1", plugins.ErrInvalidConfig)
+	}
+	if overlay.Margin, err = settings.Float("margin", defaultMargin); err != nil {
+		return err
+	}
+	if overlay.Margin < 0 || overlay.Margin >= 0.5 {
+		return fmt.Errorf("%w: margin must be at least 0 and below 0.5", plugins.ErrInvalidConfig)
+	}
+
+	// The fingerprint identifies the mark and its placement
+	fingerprint := sha256.New()
+	imagePath, text := settings.String("image", ""), settings.String("text", "")
+	switch {
+	case imagePath != "" && text != "":
+		return fmt.Errorf("%w: image and text are mutually exclusive", plugins.ErrInvalidConfig)
+	case imagePath != "":
+		b, err := os.ReadFile(imagePath)
+		if err != nil {
+			return fmt.Errorf("%w: image: %v", plugins.ErrInvalidConfig, err)
+		}
+		if overlay.Mark, _, err = imaging.Decode(b, defaultMaxPixels); err != nil {
+			return fmt.Errorf("%w: image %s: %v", plugins.ErrInvalidConfig, imagePath, err)
+		}
+		fingerprint.Write(b)
+	case text != "":
+		textColor, err := parseColor(settings.String("color", "#ffffff"))
+		if err != nil {
+			return fmt.Errorf("%w: %v", plugins.ErrInvalidConfig, err)
+		}
+		if overlay.Mark, err = renderText(text, textColor); err != nil {
+			return fmt.Errorf("%w: %v", plugins.ErrInvalidConfig, err)
+		}
+		fmt.Fprintf(fingerprint, "text\x00%s\x00%v", text, textColor)
+	default:
+		return fmt.Errorf("%w: image or text is required", plugins.ErrInvalidConfig)
+	}
+	fmt.Fprintf(fingerprint, "\x00%s\x00%g\x00%g\x00%g", overlay.Position, overlay.Opacity, overlay.Scale, overlay.Margin)
+	id := settings.String("id", "")
+	if id == "" {
+		id = "sha256:" + hex.EncodeToString(fingerprint.Sum(nil))[:16]
+	}
+
+	format := settings.String("format", FormatAuto)
+	switch format {
+	case FormatAuto, imaging.FormatJPEG, imaging.FormatPNG:
+	default:
+		return fmt.Errorf("%w: format must be auto, jpeg or png", plugins.ErrInvalidConfig)
+	}
+	quality, err := settings.Int("quality", defaultQuality)
+	if err != nil {
+		return err
+	}
+	if quality < 1 || quality > 100 {
+		return fmt.Errorf("%w: quality must be between 1 and 100", plugins.ErrInvalidConfig)
+	}
+	derived, err := settings.Bool("derived", true)
+	if err != nil {
+		return err
+	}
+	required, err := settings.Bool("required", true)
+	if err != nil {
+		return err
+	}
+	maxBytes, err := settings.Int("max_bytes", defaultMaxBytes)
+	if err != nil {
+		return err
+	}
+	maxPixels, err := settings.Int("max_pixels", defaultMaxPixels)
+	if err != nil {
+		return err
+	}
+	if maxBytes <= 0 || maxPixels <= 0 {
+		return fmt.Errorf("%w: max_bytes and max_pixels must be positive", plugins.ErrInvalidConfig)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.overlay = overlay
+	t.id = id
+	t.pipelines = pipelines
+	t.format = format
+	t.quality = quality
+	t.derived = derived
+	t.required = required
+	t.maxBytes = int64(maxBytes)
+	t.maxPixels = maxPixels
+	return nil
+}
+
+// Start verifies that a watermark is configured
+func (t *Transform) Start(ctx context.Context) error {
+	t.mu.RLock()
+	configured := t.overlay != nil
+	t.mu.RUnlock()
+	if !configured {
+		return fmt.Errorf("watermark transform not configured")
+	}
+	return t.BasePlugin.Start(ctx)
+}
+
+// Capabilities describes the transform features
+func (t *Transform) Capabilities() []interfaces.Capability {
+	return []interfaces.Capability{
+		{Type: "transform", Supported: true, Config: map[string]interface{}{
+			"media_types":    []string{string(interfaces.MediaTypePhoto)},
+			"input_formats":  []string{"jpeg", "png", "gif", "webp"},
+			"output_formats": []string{imaging.FormatJPEG, imaging.FormatPNG},
+			"overlays":       []string{"image", "text"},
+		}},
+	}
+}
+
+// ValidateSchema accepts photo streams, or schemas without a media type
+func (t *Transform) ValidateSchema(schema interfaces.Schema) error {
+	if schema.Type != "" && schema.Type != string(interfaces.MediaTypePhoto) {
+		return fmt.Errorf("watermark does not support %q streams", schema.Type)
+	}
+	return nil
+}
+
+// watermarkOptions is a snapshot of the settings used for one stream
+type watermarkOptions struct {
+	overlay   *Overlay
+	id        string
+	format    string
+	quality   int
+	required  bool
+	maxBytes  int64
+	maxPixels int
+}
+
+// Transform replaces the content of photos in opted-in pipelines with a
+// watermarked rendering. Streams that already record a watermark are left
+// alone, so reprocessing never stacks marks. Images that cannot be decoded
+// are rejected when required is set, which is the default, as publishing
+// them unmarked would defeat the purpose.
+func (t *Transform) Transform(ctx context.Context, data *interfaces.DataStream) (*interfaces.DataStream, error) {
+	if data == nil {
+		return nil, fmt.Errorf("data stream cannot be nil")
+	}
+
+	t.mu.RLock()
+	opts := watermarkOptions{
+		overlay: t.overlay, id: t.id, format: t.format, quality: t.quality,
+		required: t.required, maxBytes: t.maxBytes, maxPixels: t.maxPixels,
+	}
+	optedIn, derived := t.pipelines[data.Context.Pipeline], t.derived
+	t.mu.RUnlock()
+	if opts.overlay == nil {
+		return nil, fmt.Errorf("watermark transform not configured")
+	}
+	if !optedIn {
+		return data, nil
+	}
+
+	streams := []*interfaces.DataStream{data}
+	if derived {
+		streams = append(streams, data.Derived...)
+	}
+	for _, stream := range streams {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := t.apply(stream, opts); err != nil {
+			t.RecordError(err)
+			return nil, err
+		}
+	}
+	t.RecordError(nil)
+	return data, nil
+}
+
+// apply watermarks one photo stream in place
+func (t *Transform) apply(data *interfaces.DataStream, opts watermarkOptions) error {
+	if data == nil || data.Type != interfaces.MediaTypePhoto || data.Content == nil {
+		return nil
+	}
+	if _, done := data.Metadata[KeyWatermark]; done {
+		return nil
+	}
+
+	content, err := media.ReadAll(data.Content, opts.maxBytes)
+	if err != nil {
+		data.Content = nil
+		return fmt.Errorf("failed to read %s: %w", data.ID, err)
+	}
+	data.Content = io.NopCloser(bytes.NewReader(content))
+
+	img, sourceFormat, err := imaging.Decode(content, opts.maxPixels)
+	if errors.Is(err, imaging.ErrUnsupportedFormat) && !opts.required {
+		return nil
+	}
+	if errors.Is(err, imaging.ErrUnsupportedFormat) {
+		return fmt.Errorf("%w: %s cannot be watermarked: %w", interfaces.ErrStreamRejected, data.ID, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", data.ID, err)
+	}
+	// Re-encoding drops EXIF, so the orientation is applied to the pixels
+	img = imaging.Orient(img, imaging.Orientation(content))
+	marked := opts.overlay.Apply(img)
+
+	format := opts.format
+	if format == FormatAuto {
+		format = imaging.FormatJPEG
+		if (sourceFormat == "png" || sourceFormat == "gif") && !imaging.Opaque(marked) {
+			format = imaging.FormatPNG
+		}
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, marked, format, opts.quality); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", data.ID, err)
+	}
+
+	contentType := imaging.ContentType(format)
+	data.Content = io.NopCloser(bytes.NewReader(buf.Bytes()))
+	if data.Headers == nil {
+		data.Headers = make(map[string]string)
+	}
+	data.Headers["Content-Type"] = contentType
+	data.Headers["Content-Length"] = strconv.Itoa(buf.Len())
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	if filename, ok := data.Metadata["filename"].(string); ok && filename != "" {
+		data.Metadata["filename"] = strings.TrimSuffix(filename, path.Ext(filename)) + media.Extension(contentType)
+	}
+	data.Metadata[interfaces.MetaWidth] = marked.Rect.Dx()
+	data.Metadata[interfaces.MetaHeight] = marked.Rect.Dy()
+	data.Metadata[KeyWatermark] = opts.id
+	return nil
+}
@@ -0,0 +1,212 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+var blue = color.RGBA{B: 255, A: 255}
+
+func newTransform(t *testing.T, settings map[string]interface{}) *Transform {
+	t.Helper()
+	p, err := NewTransform(plugins.PluginConfig{Name: "watermark", Type: "transform", Enabled: true})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	require.NoError(t, tr.Configure(settings))
+	return tr
+}
+
+func solid(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func photo(content []byte, pipeline string) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       "photo-1",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"filename": "photo.png"},
+		Content:  io.NopCloser(bytes.NewReader(content)),
+		Headers:  map[string]string{"Content-Type": "image/png"},
+		Context:  interfaces.StreamContext{Pipeline: pipeline},
+	}
+}
+
+func decode(t *testing.T, data *interfaces.DataStream) image.Image {
+	t.Helper()
+	b, err := io.ReadAll(data.Content)
+	require.NoError(t, err)
+	img, _, err := image.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	return img
+}
+
+func markFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mark.png")
+	require.NoError(t, os.WriteFile(path, encodePNG(t, solid(10, 10, color.RGBA{R: 255, A: 255})), 0o600))
+	return path
+}
+
+func TestTransform_ImageOverlay(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{
+		"pipelines": []interface{}{"public"},
+		"image":     markFile(t),
+		"position":  "bottom-right",
+		"opacity":   1.0,
+		"scale":     0.25,
+		"margin":    0,
+		"format":    "png",
+	})
+
+	out, err := tr.Transform(context.Background(), photo(encodePNG(t, solid(200, 100, blue)), "public"))
+	require.NoError(t, err)
+
+	img := decode(t, out)
+	assert.Equal(t, image.Rect(0, 0, 200, 100), img.Bounds())
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, color.RGBAModel.Convert(img.At(190, 90)), "mark covers the bottom-right 50x50")
+	assert.Equal(t, blue, color.RGBAModel.Convert(img.At(140, 90)))
+	assert.Equal(t, blue, color.RGBAModel.Convert(img.At(190, 40)))
+	assert.Equal(t, "image/png", out.Headers["Content-Type"])
+	assert.Equal(t, tr.id, out.Metadata[KeyWatermark])
+	assert.Equal(t, 200, out.Metadata[interfaces.MetaWidth])
+}
+
+func TestTransform_TextOverlay(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{
+		"pipelines": "public",
+		"text":      "© Example",
+		"position":  "top-left",
+		"opacity":   0.8,
+		"quality":   95,
+	})
+
+	out, err := tr.Transform(context.Background(), photo(encodePNG(t, solid(400, 300, blue)), "public"))
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", out.Headers["Content-Type"], "opaque sources are re-encoded as JPEG")
+	assert.Equal(t, "photo.jpg", out.Metadata["filename"])
+
+	img := decode(t, out)
+	brightest := func(r image.Rectangle) uint32 {
+		var best uint32
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if red, _, _, _ := img.At(x, y).RGBA(); red > best {
+					best = red
+				}
+			}
+		}
+		return best
+	}
+	assert.Greater(t, brightest(image.Rect(0, 0, 200, 60)), uint32(0x8000), "white text in the top-left corner")
+	assert.Less(t, brightest(image.Rect(0, 200, 400, 300)), uint32(0x2000), "nothing drawn elsewhere")
+}
+
+func TestTransform_OnlyOptedInPipelines(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{"pipelines": []interface{}{"public"}, "text": "mark"})
+	content := encodePNG(t, solid(50, 50, blue))
+
+	data := photo(content, "archive")
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, content, b)
+	assert.NotContains(t, out.Metadata, KeyWatermark)
+}
+
+func TestTransform_Idempotent(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{"pipelines": []interface{}{"public"}, "text": "mark", "format": "png"})
+
+	out, err := tr.Transform(context.Background(), photo(encodePNG(t, solid(120, 80, blue)), "public"))
+	require.NoError(t, err)
+	first, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+
+	out.Content = io.NopCloser(bytes.NewReader(first))
+	again, err := tr.Transform(context.Background(), out)
+	require.NoError(t, err)
+	second, err := io.ReadAll(again.Content)
+	require.NoError(t, err)
+	assert.Equal(t, first, second, "a recorded watermark is not applied twice")
+}
+
+func TestTransform_Derived(t *testing.T) {
+	tr := newTransform(t, map[string]interface{}{"pipelines": []interface{}{"public"}, "image": markFile(t), "opacity": 1, "margin": 0})
+	var thumb bytes.Buffer
+	require.NoError(t, jpeg.Encode(&thumb, solid(40, 40, blue), nil))
+	data := photo(encodePNG(t, solid(80, 80, blue)), "public")
+	data.Derived = []*interfaces.DataStream{{
+		ID:       "photo-1/thumb",
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{},
+		Content:  io.NopCloser(bytes.NewReader(thumb.Bytes())),
+	}}
+
+	out, err := tr.Transform(context.Background(), data)
+	require.NoError(t, err)
+	derived := out.Derived[0]
+	assert.Equal(t, tr.id, derived.Metadata[KeyWatermark])
+	r, _, _, _ := decode(t, derived).At(38, 38).RGBA()
+	assert.Greater(t, r, uint32(0xC000))
+}
+
+func TestTransform_Unsupported(t *testing.T) {
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")
+
+	tr := newTransform(t, map[string]interface{}{"pipelines": []interface{}{"public"}, "text": "mark"})
+	_, err := tr.Transform(context.Background(), photo(heic, "public"))
+	assert.ErrorIs(t, err, interfaces.ErrStreamRejected)
+
+	lenient := newTransform(t, map[string]interface{}{"pipelines": []interface{}{"public"}, "text": "mark", "required": false})
+	out, err := lenient.Transform(context.Background(), photo(heic, "public"))
+	require.NoError(t, err)
+	b, err := io.ReadAll(out.Content)
+	require.NoError(t, err)
+	assert.Equal(t, heic, b)
+}
+
+func TestConfigure_Invalid(t *testing.T) {
+	p, err := NewTransform(plugins.PluginConfig{Name: "watermark", Type: "transform"})
+	require.NoError(t, err)
+	tr := p.(*Transform)
+	assert.Error(t, tr.Start(context.Background()), "a watermark must be configured")
+
+	for _, settings := range []map[string]interface{}{
+		{"text": "mark"},
+		{"pipelines": "public"},
+		{"pipelines": "public", "text": "mark", "image": "mark.png"},
+		{"pipelines": "public", "image": filepath.Join(t.TempDir(), "missing.png")},
+		{"pipelines": "public", "text": "mark", "position": "middle"},
+		{"pipelines": "public", "text": "mark", "opacity": 0},
+		{"pipelines": "public", "text": "mark", "scale": 1.5},
+		{"pipelines": "public", "text": "mark", "color": "white"},
+		{"pipelines": "public", "text": "mark", "format": "webp"},
+	} {
+		assert.ErrorIs(t, tr.Configure(settings), plugins.ErrInvalidConfig, settings)
+	}
+	assert.Error(t, tr.ValidateSchema(interfaces.Schema{Type: "video"}))
+}
@@ -0,0 +1,342 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when the remote resource does not exist
+var ErrNotFound = errors.New("webdav: resource not found")
+
+// ErrExists is returned when a PUT is refused because the target already exists
+var ErrExists = errors.New("webdav: resource already exists")
+
+// Resource describes a remote file or collection returned by PROPFIND
+type Resource struct {
+	Path         string
+	IsDir        bool
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
+// Client is a minimal WebDAV client covering the verbs used by the plugins
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+
+	// mu guards the credentials, which Authenticate may replace while
+	// requests are in flight
+	mu       sync.RWMutex
+	username string
+	password string
+	token    string
+}
+
+// NewClient creates a WebDAV client rooted at baseURL
+func NewClient(baseURL string, httpClient *http.Client) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webdav url: unsupported scheme %q", u.Scheme)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &Client{baseURL: u, httpClient: httpClient}, nil
+}
+
+// SetBasicAuth configures HTTP basic authentication
+func (c *Client) SetBasicAuth(username, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username = username
+	c.password = password
+	c.token = ""
+}
+
+// SetBearerToken configures bearer token authentication
+func (c *Client) SetBearerToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+	c.username = ""
+	c.password = ""
+}
+
+// Stat returns the properties of a single resource
+func (c *Client) Stat(ctx context.Context, p string) (*Resource, error) {
+	resources, err := c.Propfind(ctx, p, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) == 0 {
+		return nil, ErrNotFound
+	}
+	return &resources[0], nil
+}
+
+// Propfind lists resources below p down to the given depth ("0", "1" or "infinity")
+func (c *Client) Propfind(ctx context.Context, p, depth string) ([]Resource, error) {
+	req, err := c.newRequest(ctx, "PROPFIND", p, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("propfind %s: %w", p, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, statusError("propfind", p, resp)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("propfind %s: failed to decode response: %w", p, err)
+	}
+
+	resources := make([]Resource, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		res, ok := c.toResource(r)
+		if ok {
+			resources = append(resources, res)
+		}
+	}
+	return resources, nil
+}
+
+// Mkcol creates a single collection; an existing collection is not an error
+func (c *Client) Mkcol(ctx context.Context, p string) error {
+	req, err := c.newRequest(ctx, "MKCOL", p, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("mkcol %s: %w", p, err)
+	}
+	defer drain(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusOK:
+		return nil
+	case http.StatusMethodNotAllowed:
+		// RFC 4918: MKCOL on an existing resource returns 405
+		return nil
+	}
+	return statusError("mkcol", p, resp)
+}
+
+// MkdirAll creates the collection p and any missing parents
+func (c *Client) MkdirAll(ctx context.Context, p string) error {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return nil
+	}
+
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(p, "/"), "/") {
+		current += "/" + segment
+		if err := c.Mkcol(ctx, current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put uploads body to p and returns the ETag reported by the server. When
+// overwrite is false the request carries If-None-Match so servers that honour
+// it refuse to replace an existing resource.
+func (c *Client) Put(ctx context.Context, p string, body io.Reader, size int64, contentType string, overwrite bool) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodPut, p, body)
+	if err != nil {
+		return "", err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if !overwrite {
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("put %s: %w", p, err)
+	}
+	defer drain(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	case http.StatusPreconditionFailed:
+		return "", ErrExists
+	default:
+		return "", statusError("put", p, resp)
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		etag = resp.Header.Get("OC-ETag")
+	}
+	return etag, nil
+}
+
+// Get opens the content of p for reading
+func (c *Client) Get(ctx context.Context, p string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, p, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", p, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		drain(resp.Body)
+		return nil, ErrNotFound
+	}
+	defer drain(resp.Body)
+	return nil, statusError("get", p, resp)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, p string, body io.Reader) (*http.Request, error) {
+	u := *c.baseURL
+	u.Path = c.baseURL.Path + path.Clean("/"+p)
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return req, nil
+}
+
+// toResource converts a multistatus response into a Resource relative to the base URL
+func (c *Client) toResource(r response) (Resource, bool) {
+	href, err := url.Parse(r.Href)
+	if err != nil {
+		return Resource{}, false
+	}
+
+	p := href.Path
+	if !strings.HasPrefix(p, c.baseURL.Path) {
+		return Resource{}, false
+	}
+	p = path.Clean("/" + strings.TrimPrefix(p, c.baseURL.Path))
+
+	res := Resource{Path: p}
+	for _, ps := range r.Propstats {
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+		prop := ps.Prop
+		res.IsDir = prop.ResourceType.Collection != nil
+		res.ContentType = prop.ContentType
+		res.ETag = prop.ETag
+		if prop.ContentLength != "" {
+			if n, err := strconv.ParseInt(prop.ContentLength, 10, 64); err == nil {
+				res.Size = n
+			}
+		}
+		if prop.LastModified != "" {
+			if t, err := http.ParseTime(prop.LastModified); err == nil {
+				res.LastModified = t.UTC()
+			}
+		}
+	}
+	return res, true
+}
+
+func statusError(op, p string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	msg := strings.TrimSpace(string(bytes.ToValidUTF8(body, nil)))
+	if msg != "" {
+		return fmt.Errorf("%s %s: unexpected status %s: %s", op, p, resp.Status, msg)
+	}
+	return fmt.Errorf("%s %s: unexpected status %s", op, p, resp.Status)
+}
+
+func drain(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, 64*1024))
+	_ = body.Close()
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getcontenttype/>
+    <d:getetag/>
+    <d:getlastmodified/>
+  </d:prop>
+</d:propfind>`
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"DAV: multistatus"`
+	Responses []response `xml:"DAV: response"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   prop   `xml:"DAV: prop"`
+}
+
+type prop struct {
+	ResourceType  resourceType `xml:"DAV: resourcetype"`
+	ContentLength string       `xml:"DAV: getcontentlength"`
+	ContentType   string       `xml:"DAV: getcontenttype"`
+	ETag          string       `xml:"DAV: getetag"`
+	LastModified  string       `xml:"DAV: getlastmodified"`
+}
+
+type resourceType struct {
+	Collection *struct{} `xml:"DAV: collection"`
+}
@@ -0,0 +1,81 @@
+package webdav
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// connectionConfig holds the settings shared by the input and output plugins
+type connectionConfig struct {
+	URL      string
+	Root     string
+	Username string
+	Password string
+	Token    string
+	HTTP     *http.Client
+}
+
+func parseConnectionConfig(base *plugins.BasePlugin, settings plugins.Settings) (connectionConfig, error) {
+	cfg := connectionConfig{
+		URL:      settings.String("url", ""),
+		Root:     path.Clean("/" + settings.String("root", "/")),
+		Username: settings.String("username", ""),
+		Password: settings.String("password", ""),
+		Token:    settings.String("token", ""),
+	}
+	if cfg.URL == "" {
+		return cfg, fmt.Errorf("%w: url is required", plugins.ErrInvalidConfig)
+	}
+
+	client, err := base.HTTPClient(settings, httpclient.ClientOptions{DefaultTimeout: 60 * time.Second})
+	if err != nil {
+		return cfg, err
+	}
+	cfg.HTTP = client
+	return cfg, nil
+}
+
+func (cfg connectionConfig) newClient() (*Client, error) {
+	client, err := NewClient(cfg.URL, cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case cfg.Token != "":
+		client.SetBearerToken(cfg.Token)
+	case cfg.Username != "":
+		client.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+	return client, nil
+}
+
+// applyCredentials configures client authentication from service credentials
+func applyCredentials(client *Client, creds interfaces.Credentials) error {
+	switch creds.Type {
+	case interfaces.AuthTypeBasic:
+		username, _ := creds.Data["username"].(string)
+		password, _ := creds.Data["password"].(string)
+		if username == "" {
+			return fmt.Errorf("basic credentials require a username")
+		}
+		client.SetBasicAuth(username, password)
+	case interfaces.AuthTypeOAuth2, interfaces.AuthTypeJWT, interfaces.AuthTypeAPIKey:
+		token, _ := creds.Data["access_token"].(string)
+		if token == "" {
+			token, _ = creds.Data["token"].(string)
+		}
+		if token == "" {
+			return fmt.Errorf("%s credentials require a token", creds.Type)
+		}
+		client.SetBearerToken(token)
+	default:
+		return fmt.Errorf("unsupported credential type: %s", creds.Type)
+	}
+	return nil
+}
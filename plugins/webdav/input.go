@@ -0,0 +1,332 @@
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the input plugin
+const (
+	MetaNextCursor   = "next_cursor"
+	MetaLastModified = "webdav_last_modified"
+)
+
+// Input retrieves files from a WebDAV server, oldest modification first.
+// Each Retrieve call returns one item and the cursor to resume after it in
+// the "next_cursor" metadata key; io.EOF signals that nothing newer exists.
+// The sorted listing is kept between calls that continue from the cursor
+// last returned, so draining a directory takes one PROPFIND rather than one
+// per file.
+type Input struct {
+	*plugins.BasePlugin
+
+	mu      sync.RWMutex
+	conn    connectionConfig
+	depth   string
+	client  *Client
+	listing *listing
+}
+
+// listing is the sorted media files below dir, and the cursor of the last
+// one returned from it
+type listing struct {
+	dir   string
+	depth string
+	files []Resource
+	last  string
+}
+
+// Ensure Input implements the required interfaces
+var (
+	_ plugins.Plugin          = (*Input)(nil)
+	_ interfaces.InputService = (*Input)(nil)
+)
+
+// NewInput creates a WebDAV input plugin; it matches PluginFactoryFunc
+func NewInput(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Input{
+		BasePlugin: plugins.NewBasePlugin(config, "input", "Retrieves media from a WebDAV server"),
+		depth:      "infinity",
+	}, nil
+}
+
+// Configure applies plugin settings
+func (in *Input) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	conn, err := parseConnectionConfig(in.BasePlugin, settings)
+	if err != nil {
+		return err
+	}
+
+	depth := settings.String("depth", "infinity")
+	if depth != "1" && depth != "infinity" {
+		return fmt.Errorf("%w: depth must be 1 or infinity", plugins.ErrInvalidConfig)
+	}
+
+	client, err := conn.newClient()
+	if err != nil {
+		return fmt.Errorf("%w: %v", plugins.ErrInvalidConfig, err)
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.conn = conn
+	in.depth = depth
+	in.client = client
+	in.listing = nil
+	return nil
+}
+
+// Start verifies the plugin has been configured
+func (in *Input) Start(ctx context.Context) error {
+	if in.getClient() == nil {
+		return fmt.Errorf("webdav input %s is not configured", in.GetMetadata().Name)
+	}
+	return in.BasePlugin.Start(ctx)
+}
+
+// Capabilities describes the input features
+func (in *Input) Capabilities() []interfaces.Capability {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+
+	return []interfaces.Capability{
+		{Type: "input", Supported: true, Config: map[string]interface{}{
+			"protocol": "webdav",
+			"depth":    in.depth,
+		}},
+		{Type: "cursor", Supported: true, Config: map[string]interface{}{
+			"field": "getlastmodified",
+		}},
+	}
+}
+
+// SupportedModes returns the sync modes this input supports
+func (in *Input) SupportedModes() []interfaces.SyncMode {
+	return []interfaces.SyncMode{interfaces.SyncModeBatch}
+}
+
+// Authenticate replaces the configured credentials and verifies them against the root
+func (in *Input) Authenticate(ctx context.Context, creds interfaces.Credentials) error {
+	client := in.getClient()
+	if client == nil {
+		return fmt.Errorf("webdav input %s is not configured", in.GetMetadata().Name)
+	}
+	if err := applyCredentials(client, creds); err != nil {
+		return err
+	}
+
+	in.mu.RLock()
+	root := in.conn.Root
+	in.mu.RUnlock()
+
+	if _, err := client.Stat(ctx, root); err != nil {
+		return fmt.Errorf("authentication check failed: %w", err)
+	}
+	return nil
+}
+
+// Retrieve returns the first file modified after req.Cursor. A "path" filter
+// narrows the listing to a subdirectory of the configured root.
+func (in *Input) Retrieve(ctx context.Context, req interfaces.RetrievalRequest) (*interfaces.DataStream, error) {
+	client := in.getClient()
+	if client == nil {
+		return nil, fmt.Errorf("webdav input %s is not configured", in.GetMetadata().Name)
+	}
+
+	in.mu.RLock()
+	root, depth := in.conn.Root, in.depth
+	in.mu.RUnlock()
+
+	dir := root
+	if sub, ok := req.Filters["path"].(string); ok && sub != "" {
+		dir = path.Join(root, path.Clean("/"+sub))
+	}
+
+	after, err := parseCursor(req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := in.next(ctx, client, dir, depth, req.Cursor, after, req.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(ctx, next.Path)
+	if err != nil {
+		// The file may have changed since it was listed
+		in.dropListing()
+		in.RecordError(err)
+		return nil, err
+	}
+
+	contentType := next.ContentType
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		contentType = ct
+	}
+	headers := map[string]string{"Content-Type": contentType}
+	if resp.ContentLength >= 0 {
+		headers["Content-Length"] = strconv.FormatInt(resp.ContentLength, 10)
+	}
+	if next.ETag != "" {
+		headers["ETag"] = next.ETag
+	}
+
+	rel := strings.TrimPrefix(strings.TrimPrefix(next.Path, root), "/")
+	return &interfaces.DataStream{
+		ID:   next.Path,
+		Type: mediaTypeOf(next),
+		Metadata: map[string]interface{}{
+			"path":           rel,
+			"filename":       path.Base(next.Path),
+			"size":           next.Size,
+			MetaLastModified: next.LastModified.Format(time.RFC3339),
+			MetaETag:         next.ETag,
+			MetaNextCursor:   cursor{next.LastModified, next.Path}.String(),
+		},
+		Content: resp.Body,
+		Headers: headers,
+		Context: interfaces.StreamContext{
+			Source:      in.GetMetadata().Name,
+			CreatedAt:   next.LastModified,
+			ProcessedAt: time.Now(),
+		},
+	}, nil
+}
+
+// next returns the first file after the cursor, from the cached listing when
+// the request continues from the file last returned. A listing that has
+// nothing left is fetched again so files added meanwhile are found.
+func (in *Input) next(ctx context.Context, client *Client, dir, depth, position string, after cursor, r *interfaces.TimeRange) (Resource, error) {
+	in.mu.RLock()
+	cached := in.listing
+	in.mu.RUnlock()
+
+	if position != "" && cached != nil && cached.dir == dir && cached.depth == depth && cached.last == position {
+		if res, ok := cached.after(after, r); ok {
+			in.remember(cached, res)
+			return res, nil
+		}
+	}
+
+	resources, err := client.Propfind(ctx, dir, depth)
+	if err != nil {
+		in.RecordError(err)
+		return Resource{}, err
+	}
+	in.RecordError(nil)
+
+	fresh := &listing{dir: dir, depth: depth}
+	for _, res := range resources {
+		if !res.IsDir && mediaTypeOf(res) != "" {
+			fresh.files = append(fresh.files, res)
+		}
+	}
+	sort.Slice(fresh.files, func(i, j int) bool {
+		return cursor{fresh.files[i].LastModified, fresh.files[i].Path}.before(fresh.files[j])
+	})
+
+	res, ok := fresh.after(after, r)
+	if !ok {
+		in.dropListing()
+		return Resource{}, io.EOF
+	}
+	in.remember(fresh, res)
+	return res, nil
+}
+
+// after returns the first file in the listing past the cursor and within r
+func (l *listing) after(c cursor, r *interfaces.TimeRange) (Resource, bool) {
+	i := sort.Search(len(l.files), func(i int) bool { return c.before(l.files[i]) })
+	for ; i < len(l.files); i++ {
+		if r == nil || inRange(l.files[i].LastModified, r) {
+			return l.files[i], true
+		}
+	}
+	return Resource{}, false
+}
+
+// remember keeps l as the listing to continue from after res
+func (in *Input) remember(l *listing, res Resource) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.listing = &listing{dir: l.dir, depth: l.depth, files: l.files, last: cursor{res.LastModified, res.Path}.String()}
+}
+
+func (in *Input) dropListing() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.listing = nil
+}
+
+func (in *Input) getClient() *Client {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	return in.client
+}
+
+// cursor orders resources by modification time, then path to break ties
+// between files sharing the one-second resolution of getlastmodified
+type cursor struct {
+	modified time.Time
+	path     string
+}
+
+func parseCursor(s string) (cursor, error) {
+	if s == "" {
+		return cursor{}, nil
+	}
+	ts, p, _ := strings.Cut(s, "|")
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return cursor{}, fmt.Errorf("invalid cursor %q: %w", s, err)
+	}
+	return cursor{modified: t.UTC(), path: p}, nil
+}
+
+func (c cursor) String() string {
+	return c.modified.UTC().Format(time.RFC3339) + "|" + c.path
+}
+
+// before reports whether res sorts strictly after the cursor position
+func (c cursor) before(res Resource) bool {
+	if !res.LastModified.Equal(c.modified) {
+		return res.LastModified.After(c.modified)
+	}
+	return res.Path > c.path
+}
+
+func inRange(t time.Time, r *interfaces.TimeRange) bool {
+	if !r.Start.IsZero() && t.Before(r.Start) {
+		return false
+	}
+	if !r.End.IsZero() && t.After(r.End) {
+		return false
+	}
+	return true
+}
+
+// mediaTypeOf maps a resource to a media type, or "" when it is not media
+func mediaTypeOf(res Resource) interfaces.MediaType {
+	contentType := res.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(path.Ext(res.Path)); byExt != "" {
+			contentType = byExt
+		}
+	}
+	return media.TypeOf(contentType)
+}
@@ -0,0 +1,239 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// OverwritePolicy controls what Publish does when the target already exists
+type OverwritePolicy string
+
+const (
+	OverwriteReplace OverwritePolicy = "overwrite"
+	OverwriteSkip    OverwritePolicy = "skip"
+	OverwriteFail    OverwritePolicy = "fail"
+	OverwriteRename  OverwritePolicy = "rename"
+)
+
+// maxRenameAttempts bounds the search for a free name under OverwriteRename
+const maxRenameAttempts = 100
+
+// Metadata keys written by the output plugin
+const (
+	MetaRemotePath = "webdav_path"
+	MetaETag       = "webdav_etag"
+	MetaSkipped    = "webdav_skipped"
+)
+
+// Output publishes data streams to a WebDAV server such as Nextcloud
+type Output struct {
+	*plugins.BasePlugin
+
+	mu        sync.RWMutex
+	conn      connectionConfig
+	overwrite OverwritePolicy
+	client    *Client
+}
+
+// Ensure Output implements the required interfaces
+var (
+	_ plugins.Plugin           = (*Output)(nil)
+	_ interfaces.OutputService = (*Output)(nil)
+)
+
+// NewOutput creates a WebDAV output plugin; it matches PluginFactoryFunc
+func NewOutput(config plugins.PluginConfig) (plugins.Plugin, error) {
+	return &Output{
+		BasePlugin: plugins.NewBasePlugin(config, "output", "Uploads media to a WebDAV server"),
+		overwrite:  OverwriteReplace,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (o *Output) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	conn, err := parseConnectionConfig(o.BasePlugin, settings)
+	if err != nil {
+		return err
+	}
+
+	policy := OverwritePolicy(settings.String("overwrite", string(OverwriteReplace)))
+	switch policy {
+	case OverwriteReplace, OverwriteSkip, OverwriteFail, OverwriteRename:
+	default:
+		return fmt.Errorf("%w: unknown overwrite policy %q", plugins.ErrInvalidConfig, policy)
+	}
+
+	client, err := conn.newClient()
+	if err != nil {
+		return fmt.Errorf("%w: %v", plugins.ErrInvalidConfig, err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.conn = conn
+	o.overwrite = policy
+	o.client = client
+	return nil
+}
+
+// Start verifies the plugin has been configured
+func (o *Output) Start(ctx context.Context) error {
+	if o.getClient() == nil {
+		return fmt.Errorf("webdav output %s is not configured", o.GetMetadata().Name)
+	}
+	return o.BasePlugin.Start(ctx)
+}
+
+// Capabilities describes the output features
+func (o *Output) Capabilities() []interfaces.Capability {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return []interfaces.Capability{
+		{Type: "output", Supported: true, Config: map[string]interface{}{
+			"protocol":  "webdav",
+			"overwrite": string(o.overwrite),
+		}},
+		{Type: "mkcol", Supported: true},
+		{Type: "etag", Supported: true},
+	}
+}
+
+// ConfigureDestination overrides the root path and overwrite policy
+func (o *Output) ConfigureDestination(config interfaces.DestinationConfig) error {
+	settings := plugins.Settings(config.Config)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if root := settings.String("root", ""); root != "" {
+		o.conn.Root = path.Clean("/" + root)
+	}
+	if policy := settings.String("overwrite", ""); policy != "" {
+		switch OverwritePolicy(policy) {
+		case OverwriteReplace, OverwriteSkip, OverwriteFail, OverwriteRename:
+			o.overwrite = OverwritePolicy(policy)
+		default:
+			return fmt.Errorf("%w: unknown overwrite policy %q", plugins.ErrInvalidConfig, policy)
+		}
+	}
+	return nil
+}
+
+// Publish uploads the stream content, creating parent collections as needed.
+// The remote path and ETag are recorded in the stream metadata.
+func (o *Output) Publish(ctx context.Context, data *interfaces.DataStream) error {
+	if data == nil {
+		return fmt.Errorf("data stream cannot be nil")
+	}
+	if data.Content == nil {
+		return fmt.Errorf("data stream %s has no content", data.ID)
+	}
+	defer data.Content.Close()
+
+	client := o.getClient()
+	if client == nil {
+		return fmt.Errorf("webdav output %s is not configured", o.GetMetadata().Name)
+	}
+
+	o.mu.RLock()
+	root, policy := o.conn.Root, o.overwrite
+	o.mu.RUnlock()
+
+	rel, err := media.RemoteName(data)
+	if err != nil {
+		return err
+	}
+	target := path.Join(root, rel)
+
+	if err := client.MkdirAll(ctx, path.Dir(target)); err != nil {
+		o.RecordError(err)
+		return fmt.Errorf("failed to create remote directory: %w", err)
+	}
+
+	if policy != OverwriteReplace {
+		existing, err := client.Stat(ctx, target)
+		switch {
+		case err == nil && policy == OverwriteSkip:
+			media.SetMetadata(data, MetaRemotePath, target)
+			media.SetMetadata(data, MetaETag, existing.ETag)
+			media.SetMetadata(data, MetaSkipped, true)
+			return nil
+		case err == nil && policy == OverwriteFail:
+			return fmt.Errorf("%w: %s", ErrExists, target)
+		case err == nil && policy == OverwriteRename:
+			target, err = o.freeName(ctx, client, target)
+			if err != nil {
+				return err
+			}
+		case err != nil && !errors.Is(err, ErrNotFound):
+			o.RecordError(err)
+			return err
+		}
+	}
+
+	size := int64(-1)
+	if v := data.Headers["Content-Length"]; v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			size = n
+		}
+	}
+
+	etag, err := client.Put(ctx, target, data.Content, size, data.Headers["Content-Type"], policy == OverwriteReplace)
+	if err != nil {
+		if errors.Is(err, ErrExists) && policy == OverwriteSkip {
+			media.SetMetadata(data, MetaRemotePath, target)
+			media.SetMetadata(data, MetaSkipped, true)
+			return nil
+		}
+		o.RecordError(err)
+		return err
+	}
+
+	// Some servers omit the ETag on PUT; fall back to the stored properties
+	if etag == "" {
+		if res, err := client.Stat(ctx, target); err == nil {
+			etag = res.ETag
+		}
+	}
+
+	o.RecordError(nil)
+	media.SetMetadata(data, MetaRemotePath, target)
+	media.SetMetadata(data, MetaETag, etag)
+	return nil
+}
+
+// freeName finds the first "name-N.ext" variant of target that does not exist
+func (o *Output) freeName(ctx context.Context, client *Client, target string) (string, error) {
+	ext := path.Ext(target)
+	base := strings.TrimSuffix(target, ext)
+
+	for i := 1; i <= maxRenameAttempts; i++ {
+		candidate := fmt.Sprintf("%s-%d%s", base, i, ext)
+		_, err := client.Stat(ctx, candidate)
+		if errors.Is(err, ErrNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free name found for %s after %d attempts", target, maxRenameAttempts)
+}
+
+func (o *Output) getClient() *Client {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.client
+}
@@ -0,0 +1,345 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xwebdav "golang.org/x/net/webdav"
+
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, xwebdav.FileSystem) {
+	t.Helper()
+	server, fs, _ := newCountingServer(t)
+	return server, fs
+}
+
+// newCountingServer is newTestServer that also counts requests by method
+func newCountingServer(t *testing.T) (*httptest.Server, xwebdav.FileSystem, func(method string) int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	counts := map[string]int{}
+
+	fs := xwebdav.NewMemFS()
+	handler := &xwebdav.Handler{
+		Prefix:     "/remote.php/dav",
+		FileSystem: fs,
+		LockSystem: xwebdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[r.Method]++
+		mu.Unlock()
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "alice" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, fs, func(method string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return counts[method]
+	}
+}
+
+func newOutput(t *testing.T, serverURL string, settings map[string]interface{}) *Output {
+	t.Helper()
+
+	p, err := NewOutput(plugins.PluginConfig{Name: "nextcloud", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+
+	config := map[string]interface{}{
+		"url":      serverURL + "/remote.php/dav",
+		"username": "alice",
+		"password": "secret",
+		"root":     "/Photos",
+	}
+	for k, v := range settings {
+		config[k] = v
+	}
+	require.NoError(t, p.Configure(config))
+	require.NoError(t, p.Start(context.Background()))
+	return p.(*Output)
+}
+
+func stream(id, name, body string) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       id,
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"path": name},
+		Content:  io.NopCloser(strings.NewReader(body)),
+		Headers:  map[string]string{"Content-Type": "image/jpeg"},
+	}
+}
+
+func readRemote(t *testing.T, fs xwebdav.FileSystem, name string) string {
+	t.Helper()
+	f, err := fs.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestOutput_PublishCreatesDirectoriesAndCapturesETag(t *testing.T) {
+	server, fs := newTestServer(t)
+	out := newOutput(t, server.URL, nil)
+
+	data := stream("item-1", "2024/05/beach.jpg", "jpeg-bytes")
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	assert.Equal(t, "jpeg-bytes", readRemote(t, fs, "/Photos/2024/05/beach.jpg"))
+	assert.Equal(t, "/Photos/2024/05/beach.jpg", data.Metadata[MetaRemotePath])
+	assert.NotEmpty(t, data.Metadata[MetaETag])
+}
+
+func TestOutput_PublishFallsBackToIDAndContentType(t *testing.T) {
+	server, fs := newTestServer(t)
+	out := newOutput(t, server.URL, nil)
+
+	data := stream("item-42", "", "png")
+	delete(data.Metadata, "path")
+	data.Headers["Content-Type"] = "image/png"
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	assert.Equal(t, "png", readRemote(t, fs, "/Photos/item-42.png"))
+}
+
+func TestOutput_OverwritePolicies(t *testing.T) {
+	tests := []struct {
+		policy   string
+		wantErr  error
+		wantFile string
+		wantBody string
+		skipped  bool
+	}{
+		{policy: "overwrite", wantFile: "/Photos/a.jpg", wantBody: "second"},
+		{policy: "skip", wantFile: "/Photos/a.jpg", wantBody: "first", skipped: true},
+		{policy: "fail", wantErr: ErrExists, wantFile: "/Photos/a.jpg", wantBody: "first"},
+		{policy: "rename", wantFile: "/Photos/a-1.jpg", wantBody: "second"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			server, fs := newTestServer(t)
+			out := newOutput(t, server.URL, map[string]interface{}{"overwrite": tt.policy})
+			ctx := context.Background()
+
+			require.NoError(t, out.Publish(ctx, stream("1", "a.jpg", "first")))
+
+			second := stream("2", "a.jpg", "second")
+			err := out.Publish(ctx, second)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantFile, second.Metadata[MetaRemotePath])
+			}
+
+			assert.Equal(t, tt.wantBody, readRemote(t, fs, tt.wantFile))
+			if tt.skipped {
+				assert.Equal(t, true, second.Metadata[MetaSkipped])
+			}
+		})
+	}
+}
+
+func TestOutput_ConfigureValidation(t *testing.T) {
+	p, err := NewOutput(plugins.PluginConfig{Name: "dav", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+
+	err = p.Configure(map[string]interface{}{})
+	assert.ErrorIs(t, err, plugins.ErrInvalidConfig)
+
+	err = p.Configure(map[string]interface{}{"url": "http://localhost", "overwrite": "merge"})
+	assert.ErrorIs(t, err, plugins.ErrInvalidConfig)
+
+	assert.Error(t, p.Start(context.Background()), "unconfigured plugin must not start")
+}
+
+func TestOutput_RejectsBadCredentials(t *testing.T) {
+	server, _ := newTestServer(t)
+	out := newOutput(t, server.URL, map[string]interface{}{"password": "wrong"})
+
+	err := out.Publish(context.Background(), stream("1", "a.jpg", "x"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+	assert.Equal(t, interfaces.StatusWarning, out.Health().Status)
+}
+
+func newInput(t *testing.T, serverURL string) *Input {
+	t.Helper()
+
+	p, err := NewInput(plugins.PluginConfig{Name: "nextcloud-in", Type: "input", Version: "1.0.0"})
+	require.NoError(t, err)
+	require.NoError(t, p.Configure(map[string]interface{}{
+		"url":      serverURL + "/remote.php/dav",
+		"username": "alice",
+		"password": "secret",
+		"root":     "/Photos",
+	}))
+	require.NoError(t, p.Start(context.Background()))
+	return p.(*Input)
+}
+
+func TestInput_RetrieveWalksWithCursor(t *testing.T) {
+	server, _ := newTestServer(t)
+	out := newOutput(t, server.URL, nil)
+	ctx := context.Background()
+
+	for _, name := range []string{"b.jpg", "a.jpg", "nested/c.mp4", "notes.bin"} {
+		require.NoError(t, out.Publish(ctx, stream(name, name, "content-"+name)))
+	}
+
+	in := newInput(t, server.URL)
+
+	var seen []string
+	cursor := ""
+	for {
+		data, err := in.Retrieve(ctx, interfaces.RetrievalRequest{Cursor: cursor})
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(data.Content)
+		require.NoError(t, err)
+		require.NoError(t, data.Content.Close())
+
+		rel := data.Metadata["path"].(string)
+		assert.Equal(t, "content-"+rel, string(body))
+		seen = append(seen, rel)
+
+		cursor = data.Metadata[MetaNextCursor].(string)
+		require.NotEmpty(t, cursor)
+		require.Less(t, len(seen), 10, "cursor must advance")
+	}
+
+	assert.ElementsMatch(t, []string{"a.jpg", "b.jpg", "nested/c.mp4"}, seen)
+}
+
+func TestInput_RetrieveTypesAndPathFilter(t *testing.T) {
+	server, _ := newTestServer(t)
+	out := newOutput(t, server.URL, nil)
+	ctx := context.Background()
+
+	require.NoError(t, out.Publish(ctx, stream("1", "top.jpg", "x")))
+	require.NoError(t, out.Publish(ctx, stream("2", "clips/movie.mp4", "y")))
+
+	in := newInput(t, server.URL)
+	data, err := in.Retrieve(ctx, interfaces.RetrievalRequest{
+		Filters: map[string]interface{}{"path": "clips"},
+	})
+	require.NoError(t, err)
+	defer data.Content.Close()
+
+	assert.Equal(t, interfaces.MediaTypeVideo, data.Type)
+	assert.Equal(t, "clips/movie.mp4", data.Metadata["path"])
+	assert.Equal(t, "nextcloud-in", data.Context.Source)
+}
+
+func TestInput_Authenticate(t *testing.T) {
+	server, _ := newTestServer(t)
+	out := newOutput(t, server.URL, nil)
+	require.NoError(t, out.Publish(context.Background(), stream("1", "a.jpg", "x")))
+
+	in := newInput(t, server.URL)
+	ctx := context.Background()
+
+	err := in.Authenticate(ctx, interfaces.Credentials{
+		Type: interfaces.AuthTypeBasic,
+		Data: map[string]interface{}{"username": "alice", "password": "wrong"},
+	})
+	assert.Error(t, err)
+
+	err = in.Authenticate(ctx, interfaces.Credentials{
+		Type: interfaces.AuthTypeBasic,
+		Data: map[string]interface{}{"username": "alice", "password": "secret"},
+	})
+	assert.NoError(t, err)
+}
+
+func TestInput_RetrieveReusesListing(t *testing.T) {
+	server, _, count := newCountingServer(t)
+	out := newOutput(t, server.URL, nil)
+	ctx := context.Background()
+
+	names := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg"}
+	for _, name := range names {
+		require.NoError(t, out.Publish(ctx, stream(name, name, name)))
+	}
+
+	in := newInput(t, server.URL)
+	before := count("PROPFIND")
+	cursor, seen := "", 0
+	for {
+		data, err := in.Retrieve(ctx, interfaces.RetrievalRequest{Cursor: cursor})
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		require.NoError(t, data.Content.Close())
+		cursor = data.Metadata[MetaNextCursor].(string)
+		seen++
+		require.LessOrEqual(t, seen, len(names)+1)
+
+		if seen == 2 {
+			// Files added during the walk are found once the listing runs out
+			require.NoError(t, out.Publish(ctx, stream("z", "z.jpg", "z")))
+		}
+	}
+	assert.Equal(t, len(names)+1, seen)
+	assert.LessOrEqual(t, count("PROPFIND")-before, 3, "the listing is fetched once per walk, not per file")
+}
+
+func TestInput_AuthenticateDuringRetrieve(t *testing.T) {
+	server, _ := newTestServer(t)
+	out := newOutput(t, server.URL, nil)
+	ctx := context.Background()
+	require.NoError(t, out.Publish(ctx, stream("1", "a.jpg", "x")))
+
+	in := newInput(t, server.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if data, err := in.Retrieve(ctx, interfaces.RetrievalRequest{}); err == nil {
+				data.Content.Close()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = in.Authenticate(ctx, interfaces.Credentials{
+				Type: interfaces.AuthTypeBasic,
+				Data: map[string]interface{}{"username": "alice", "password": "secret"},
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCursor_RoundTrip(t *testing.T) {
+	c, err := parseCursor("2024-05-01T10:00:00Z|/Photos/a.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, "2024-05-01T10:00:00Z|/Photos/a.jpg", c.String())
+
+	_, err = parseCursor("yesterday")
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Envelope describes one synced item in a webhook payload
+type Envelope struct {
+	ID          string                 `json:"id"`
+	Type        interfaces.MediaType   `json:"type"`
+	Source      string                 `json:"source,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Headers     map[string]string      `json:"headers,omitempty"`
+	CreatedAt   *time.Time             `json:"created_at,omitempty"`
+	ContentPart string                 `json:"content_part,omitempty"`
+
+	content []byte
+}
+
+// Payload is the JSON document delivered for a batch of items
+type Payload struct {
+	DeliveryID string     `json:"delivery_id"`
+	Event      string     `json:"event"`
+	SentAt     time.Time  `json:"sent_at"`
+	Items      []Envelope `json:"items"`
+}
+
+// newEnvelope captures the stream fields, reading at most limit bytes of
+// content when it is to be delivered; the stream content is always closed
+func newEnvelope(data *interfaces.DataStream, withContent bool, limit int64) (Envelope, error) {
+	env := Envelope{
+		ID:       data.ID,
+		Type:     data.Type,
+		Source:   data.Context.Source,
+		Metadata: copyMetadata(data.Metadata),
+		Headers:  data.Headers,
+	}
+	if !data.Context.CreatedAt.IsZero() {
+		created := data.Context.CreatedAt.UTC()
+		env.CreatedAt = &created
+	}
+
+	if data.Content == nil {
+		return env, nil
+	}
+	if !withContent {
+		data.Content.Close()
+		return env, nil
+	}
+
+	content, err := media.ReadAll(data.Content, limit)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to read content of %s: %w", data.ID, err)
+	}
+	env.content = content
+	return env, nil
+}
+
+// encode renders the payload as JSON, or as multipart/form-data with a
+// "payload" part followed by one "content_N" file part per item with content
+func (p *Payload) encode(multipartMode bool) ([]byte, string, error) {
+	if !multipartMode {
+		body, err := json.Marshal(p)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode payload: %w", err)
+		}
+		return body, "application/json", nil
+	}
+
+	for i := range p.Items {
+		if p.Items[i].content != nil {
+			p.Items[i].ContentPart = "content_" + strconv.Itoa(i)
+		}
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="payload"`)
+	header.Set("Content-Type", "application/json")
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := part.Write(payload); err != nil {
+		return nil, "", err
+	}
+
+	for _, item := range p.Items {
+		if item.ContentPart == "" {
+			continue
+		}
+		contentType := item.Headers["Content-Type"]
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, item.ContentPart, item.ID))
+		header.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(item.content); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
+
+// Sign computes the signature header value for a request body. The timestamp
+// is part of the signed message so receivers can reject replayed deliveries.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign in constant time
+func Verify(secret []byte, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+func copyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if len(metadata) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,662 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sho7650/media-sync/internal/httpclient"
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+// Metadata keys written by the output plugin
+const (
+	MetaDeliveryID = "webhook_delivery_id"
+	MetaStatus     = "webhook_status"
+	MetaAttempts   = "webhook_attempts"
+)
+
+// DeliveryStatus is the outcome of a webhook delivery
+type DeliveryStatus string
+
+const (
+	DeliveryQueued    DeliveryStatus = "queued"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// maxBackoff caps the exponential delay between retries
+const maxBackoff = time.Minute
+
+// defaultMaxQueuedBytes bounds the content held for undelivered multipart items
+const defaultMaxQueuedBytes = 64 << 20
+
+// Delivery records one POST of a batch, including all of its retries
+type Delivery struct {
+	ID          string         `json:"id"`
+	ItemIDs     []string       `json:"item_ids"`
+	Status      DeliveryStatus `json:"status"`
+	Attempts    int            `json:"attempts"`
+	StatusCode  int            `json:"status_code,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	StartedAt   time.Time      `json:"started_at"`
+	CompletedAt time.Time      `json:"completed_at"`
+
+	// retryable marks failures a later flush may overcome: transport
+	// errors, 429 and 5xx responses
+	retryable bool
+}
+
+// batch is a queued set of envelopes and the delivery ID they are sent with
+type batch struct {
+	id    string
+	items []Envelope
+}
+
+// Output posts a signed JSON envelope per synced item, or per batch of items,
+// to a configured endpoint. With batch_size above one, items are queued and
+// sent once the batch is full, when flush_interval elapses, or on Stop. A
+// batch that fails with a retryable error stays queued, under the same
+// delivery ID, until a later flush succeeds; other failures are recorded and
+// the batch is dropped.
+type Output struct {
+	*plugins.BasePlugin
+
+	mu         sync.Mutex
+	endpoint   string
+	secret     []byte
+	headers    map[string]string
+	sigHeader  string
+	event      string
+	multipart  bool
+	batchSize  int
+	interval   time.Duration
+	maxRetries int
+	backoff    time.Duration
+	historyMax int
+	maxQueued  int
+	maxBytes   int64
+	httpClient *http.Client
+
+	pending   []Envelope
+	pendingID string
+	retry     []batch
+	history   []Delivery
+	delivered int
+	failed    int
+	stopFlush chan struct{}
+	flushDone chan struct{}
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+	sleep     func(ctx context.Context, d time.Duration) error
+	now       func() time.Time
+}
+
+// Ensure Output implements the required interfaces
+var (
+	_ plugins.Plugin           = (*Output)(nil)
+	_ interfaces.OutputService = (*Output)(nil)
+)
+
+var clientOptions = httpclient.ClientOptions{DefaultTimeout: 30 * time.Second, UserAgent: "media-sync-webhook"}
+
+// NewOutput creates a webhook output plugin; it matches PluginFactoryFunc
+func NewOutput(config plugins.PluginConfig) (plugins.Plugin, error) {
+	base := plugins.NewBasePlugin(config, "output", "Posts signed notifications for synced media to a webhook")
+	client, err := base.HTTPClient(nil, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &Output{
+		BasePlugin: base,
+		sigHeader:  "X-Webhook-Signature",
+		event:      "media.synced",
+		batchSize:  1,
+		maxRetries: 3,
+		backoff:    time.Second,
+		historyMax: 100,
+		maxQueued:  1000,
+		maxBytes:   defaultMaxQueuedBytes,
+		httpClient: client,
+		sleep:      sleepContext,
+		now:        time.Now,
+	}, nil
+}
+
+// Configure applies plugin settings
+func (o *Output) Configure(config map[string]interface{}) error {
+	settings := plugins.Settings(config)
+
+	endpoint := settings.String("url", "")
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http(s) URL", plugins.ErrInvalidConfig)
+	}
+
+	mode := settings.String("mode", "json")
+	if mode != "json" && mode != "multipart" {
+		return fmt.Errorf("%w: mode must be json or multipart", plugins.ErrInvalidConfig)
+	}
+
+	batchSize, err := settings.Int("batch_size", 1)
+	if err != nil {
+		return err
+	}
+	if batchSize < 1 {
+		return fmt.Errorf("%w: batch_size must be at least 1", plugins.ErrInvalidConfig)
+	}
+	interval, err := settings.Duration("flush_interval", 0)
+	if err != nil {
+		return err
+	}
+	maxRetries, err := settings.Int("max_retries", 3)
+	if err != nil {
+		return err
+	}
+	backoff, err := settings.Duration("retry_backoff", time.Second)
+	if err != nil {
+		return err
+	}
+	client, err := o.HTTPClient(settings, clientOptions)
+	if err != nil {
+		return err
+	}
+	historyMax, err := settings.Int("history_size", 100)
+	if err != nil {
+		return err
+	}
+	maxQueued, err := settings.Int("max_queued", 1000)
+	if err != nil {
+		return err
+	}
+	if maxQueued < batchSize {
+		return fmt.Errorf("%w: max_queued must be at least batch_size", plugins.ErrInvalidConfig)
+	}
+	maxBytes, err := settings.Int("max_queued_bytes", defaultMaxQueuedBytes)
+	if err != nil {
+		return err
+	}
+	if maxBytes < 1 {
+		return fmt.Errorf("%w: max_queued_bytes must be positive", plugins.ErrInvalidConfig)
+	}
+	rawHeaders, err := settings.Map("headers")
+	if err != nil {
+		return err
+	}
+	headers := make(map[string]string, len(rawHeaders))
+	for k, v := range rawHeaders {
+		headers[k] = fmt.Sprint(v)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.endpoint = endpoint
+	o.secret = []byte(settings.String("secret", ""))
+	o.headers = headers
+	o.sigHeader = settings.String("signature_header", "X-Webhook-Signature")
+	o.event = settings.String("event", "media.synced")
+	o.multipart = mode == "multipart"
+	o.batchSize = batchSize
+	o.interval = interval
+	o.maxRetries = maxRetries
+	o.backoff = backoff
+	o.historyMax = historyMax
+	o.maxQueued = maxQueued
+	o.maxBytes = int64(maxBytes)
+	o.httpClient = client
+	return nil
+}
+
+// Start begins the periodic flush when a flush interval is configured.
+// Batches are delivered under a context owned by the output, which lasts
+// until Stop, rather than under the context of the caller that filled them.
+func (o *Output) Start(ctx context.Context) error {
+	o.mu.Lock()
+	if o.endpoint == "" {
+		o.mu.Unlock()
+		return fmt.Errorf("webhook output %s is not configured", o.GetMetadata().Name)
+	}
+	if o.runCtx == nil {
+		o.runCtx, o.cancelRun = context.WithCancel(context.Background())
+	}
+	if o.interval > 0 && o.batchSize > 1 && o.stopFlush == nil {
+		o.stopFlush = make(chan struct{})
+		o.flushDone = make(chan struct{})
+		go o.flushLoop(o.interval, o.stopFlush, o.flushDone)
+	}
+	o.mu.Unlock()
+
+	return o.BasePlugin.Start(ctx)
+}
+
+// Stop ends the periodic flush and delivers any queued items; items that
+// still cannot be delivered are reported in the returned error
+func (o *Output) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	stop, done := o.stopFlush, o.flushDone
+	cancel := o.cancelRun
+	o.stopFlush, o.flushDone = nil, nil
+	o.runCtx, o.cancelRun = nil, nil
+	o.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	err := o.Flush(ctx)
+	if cancel != nil {
+		cancel()
+	}
+	if stopErr := o.BasePlugin.Stop(ctx); stopErr != nil {
+		return stopErr
+	}
+	return err
+}
+
+// Capabilities describes the output features
+func (o *Output) Capabilities() []interfaces.Capability {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	mode := "json"
+	if o.multipart {
+		mode = "multipart"
+	}
+	return []interfaces.Capability{
+		{Type: "output", Supported: true, Config: map[string]interface{}{
+			"protocol": "webhook",
+			"mode":     mode,
+		}},
+		{Type: "batching", Supported: true, Config: map[string]interface{}{
+			"batch_size":     o.batchSize,
+			"flush_interval": o.interval.String(),
+		}},
+		{Type: "signing", Supported: len(o.secret) > 0, Config: map[string]interface{}{
+			"algorithm": "hmac-sha256",
+			"header":    o.sigHeader,
+		}},
+	}
+}
+
+// ConfigureDestination overrides the endpoint and event name
+func (o *Output) ConfigureDestination(dest interfaces.DestinationConfig) error {
+	settings := plugins.Settings(dest.Config)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if endpoint := settings.String("url", ""); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: url must be an absolute http(s) URL", plugins.ErrInvalidConfig)
+		}
+		o.endpoint = endpoint
+	}
+	if event := settings.String("event", ""); event != "" {
+		o.event = event
+	}
+	return nil
+}
+
+// Publish queues the item and delivers the batch once it is full. The
+// delivery ID and status are recorded in the stream metadata; items left in
+// the queue are marked "queued" and their outcome is reported by Deliveries.
+// A failed delivery is returned to the caller, except that with batching a
+// retryable failure leaves the batch queued for the next flush; Publish
+// refuses new items once max_queued are waiting, or when their content would
+// take the queue past max_queued_bytes.
+func (o *Output) Publish(ctx context.Context, data *interfaces.DataStream) error {
+	if data == nil {
+		return fmt.Errorf("data stream cannot be nil")
+	}
+
+	o.mu.Lock()
+	configured, multipartMode := o.endpoint != "", o.multipart
+	budget := o.maxBytes - o.queuedBytesLocked()
+	o.mu.Unlock()
+	if !configured {
+		if data.Content != nil {
+			data.Content.Close()
+		}
+		return fmt.Errorf("webhook output %s is not configured", o.GetMetadata().Name)
+	}
+
+	env, err := newEnvelope(data, multipartMode, max(budget, 0))
+	if errors.Is(err, media.ErrTooLarge) {
+		return fmt.Errorf("webhook output %s cannot queue %s within max_queued_bytes: %w", o.GetMetadata().Name, data.ID, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	if queued := o.queuedLocked(); queued >= o.maxQueued {
+		o.mu.Unlock()
+		return fmt.Errorf("webhook output %s has %d undelivered items queued", o.GetMetadata().Name, queued)
+	}
+	if queuedBytes := o.queuedBytesLocked(); queuedBytes+int64(len(env.content)) > o.maxBytes {
+		o.mu.Unlock()
+		return fmt.Errorf("webhook output %s has %d bytes of undelivered content queued", o.GetMetadata().Name, queuedBytes)
+	}
+	if o.pendingID == "" {
+		o.pendingID = uuid.New().String()
+	}
+	deliveryID := o.pendingID
+	o.pending = append(o.pending, env)
+	full := len(o.pending) >= o.batchSize
+	batched := o.batchSize > 1
+	if batched {
+		ctx = o.deliveryContextLocked()
+	}
+	o.SetHealthDetail("queued", o.queuedLocked())
+	o.mu.Unlock()
+
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	data.Metadata[MetaDeliveryID] = deliveryID
+	data.Metadata[MetaStatus] = string(DeliveryQueued)
+
+	if !full {
+		return nil
+	}
+
+	deliveries, err := o.flush(ctx)
+	for _, delivery := range deliveries {
+		if delivery.ID != deliveryID {
+			continue
+		}
+		data.Metadata[MetaAttempts] = delivery.Attempts
+		if delivery.Status == DeliveryDelivered {
+			data.Metadata[MetaStatus] = string(delivery.Status)
+			return nil
+		}
+		if batched && delivery.retryable {
+			// The batch stays queued; the failure is in the health status
+			return nil
+		}
+		data.Metadata[MetaStatus] = string(delivery.Status)
+		return fmt.Errorf("webhook delivery %s failed after %d attempts: %s", delivery.ID, delivery.Attempts, delivery.Error)
+	}
+	if batched {
+		// Earlier batches that failed again are in the health status
+		return nil
+	}
+	return err
+}
+
+// Flush delivers any queued items immediately
+func (o *Output) Flush(ctx context.Context) error {
+	_, err := o.flush(ctx)
+	return err
+}
+
+// queuedLocked counts the items waiting for delivery; o.mu must be held
+func (o *Output) queuedLocked() int {
+	n := len(o.pending)
+	for _, b := range o.retry {
+		n += len(b.items)
+	}
+	return n
+}
+
+// queuedBytesLocked sums the content held for items waiting for delivery;
+// o.mu must be held
+func (o *Output) queuedBytesLocked() int64 {
+	var n int64
+	for _, env := range o.pending {
+		n += int64(len(env.content))
+	}
+	for _, b := range o.retry {
+		for _, env := range b.items {
+			n += int64(len(env.content))
+		}
+	}
+	return n
+}
+
+// deliveryContextLocked returns the context batches are delivered under;
+// o.mu must be held
+func (o *Output) deliveryContextLocked() context.Context {
+	if o.runCtx != nil {
+		return o.runCtx
+	}
+	return context.Background()
+}
+
+// Deliveries returns the most recent delivery records, oldest first
+func (o *Output) Deliveries() []Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Delivery(nil), o.history...)
+}
+
+// flush delivers the batches that failed before, oldest first, then the
+// pending one. With batching, batches that fail with a retryable error are
+// put back in the queue; the others are dropped.
+func (o *Output) flush(ctx context.Context) ([]Delivery, error) {
+	o.mu.Lock()
+	batches := o.retry
+	if len(o.pending) > 0 {
+		batches = append(batches, batch{id: o.pendingID, items: o.pending})
+	}
+	o.retry, o.pending, o.pendingID = nil, nil, ""
+	requeue := o.batchSize > 1
+	o.mu.Unlock()
+
+	if len(batches) == 0 {
+		return nil, nil
+	}
+
+	var (
+		deliveries []Delivery
+		failed     []batch
+		errs       []error
+	)
+	for i, b := range batches {
+		if ctx.Err() != nil {
+			failed = append(failed, batches[i:]...)
+			errs = append(errs, ctx.Err())
+			break
+		}
+		delivery := o.deliver(ctx, b.id, b.items)
+		o.record(delivery)
+		deliveries = append(deliveries, delivery)
+		if delivery.Status != DeliveryDelivered {
+			if delivery.retryable {
+				failed = append(failed, b)
+			}
+			errs = append(errs, fmt.Errorf("webhook delivery %s failed after %d attempts: %s", delivery.ID, delivery.Attempts, delivery.Error))
+		}
+	}
+
+	o.mu.Lock()
+	if requeue {
+		o.retry = append(failed, o.retry...)
+	}
+	o.SetHealthDetail("queued", o.queuedLocked())
+	o.mu.Unlock()
+
+	err := errors.Join(errs...)
+	o.RecordError(err)
+	return deliveries, err
+}
+
+// record adds a delivery to the history and the health counters
+func (o *Output) record(delivery Delivery) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.history = append(o.history, delivery)
+	if over := len(o.history) - o.historyMax; over > 0 {
+		o.history = append([]Delivery(nil), o.history[over:]...)
+	}
+	if delivery.Status == DeliveryDelivered {
+		o.delivered++
+	} else {
+		o.failed++
+	}
+	o.SetHealthDetail("deliveries_succeeded", o.delivered)
+	o.SetHealthDetail("deliveries_failed", o.failed)
+}
+
+// deliver posts the batch, retrying transport errors, 429 and 5xx responses
+// with exponential backoff. Every attempt carries the same delivery ID so
+// receivers can discard duplicates.
+func (o *Output) deliver(ctx context.Context, id string, items []Envelope) Delivery {
+	o.mu.Lock()
+	target := requestTarget{
+		endpoint:  o.endpoint,
+		secret:    o.secret,
+		sigHeader: o.sigHeader,
+		headers:   o.headers,
+		client:    o.httpClient,
+	}
+	event, multipartMode, maxRetries, backoff := o.event, o.multipart, o.maxRetries, o.backoff
+	o.mu.Unlock()
+
+	delivery := Delivery{ID: id, StartedAt: o.now()}
+	for _, item := range items {
+		delivery.ItemIDs = append(delivery.ItemIDs, item.ID)
+	}
+
+	payload := &Payload{DeliveryID: id, Event: event, SentAt: delivery.StartedAt.UTC(), Items: items}
+	body, contentType, err := payload.encode(multipartMode)
+	if err != nil {
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+		delivery.CompletedAt = o.now()
+		return delivery
+	}
+
+	for attempt := 0; ; attempt++ {
+		delivery.Attempts = attempt + 1
+
+		wait, retry, err := o.attempt(ctx, target, id, body, contentType, &delivery)
+		if err == nil {
+			delivery.Status = DeliveryDelivered
+			delivery.Error = ""
+			break
+		}
+		delivery.Error = err.Error()
+		delivery.retryable = retry || ctx.Err() != nil
+		if !retry || attempt >= maxRetries {
+			delivery.Status = DeliveryFailed
+			break
+		}
+
+		if wait <= 0 {
+			wait = backoff << attempt
+			if wait > maxBackoff || wait <= 0 {
+				wait = maxBackoff
+			}
+		}
+		if err := o.sleep(ctx, wait); err != nil {
+			delivery.Status = DeliveryFailed
+			delivery.Error = err.Error()
+			delivery.retryable = true
+			break
+		}
+	}
+
+	delivery.CompletedAt = o.now()
+	return delivery
+}
+
+// requestTarget is a snapshot of the endpoint settings used for one delivery
+type requestTarget struct {
+	endpoint  string
+	secret    []byte
+	sigHeader string
+	headers   map[string]string
+	client    *http.Client
+}
+
+// attempt sends one request and reports whether a failure may be retried
+// along with any server-requested delay
+func (o *Output) attempt(ctx context.Context, target requestTarget, id string, body []byte, contentType string, delivery *Delivery) (time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range target.headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Webhook-Delivery", id)
+	if len(target.secret) > 0 {
+		timestamp := o.now().Unix()
+		req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+		req.Header.Set(target.sigHeader, Sign(target.secret, timestamp, body))
+	}
+
+	resp, err := target.client.Do(req)
+	if err != nil {
+		return 0, ctx.Err() == nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+	}()
+	delivery.StatusCode = resp.StatusCode
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, false, nil
+	}
+
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return retryAfter(resp), true, err
+	}
+	return 0, false, err
+}
+
+func (o *Output) flushLoop(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Failed batches stay queued for the next tick, and failures are
+			// kept in the delivery history and health status
+			o.mu.Lock()
+			ctx := o.deliveryContextLocked()
+			o.mu.Unlock()
+			_ = o.Flush(ctx)
+		}
+	}
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
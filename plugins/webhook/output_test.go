@@ -0,0 +1,363 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sho7650/media-sync/internal/media"
+	"github.com/sho7650/media-sync/internal/plugins"
+	"github.com/sho7650/media-sync/pkg/core/interfaces"
+)
+
+const testSecret = "s3cret"
+
+// receiver records webhook requests and answers with scripted status codes
+type receiver struct {
+	mu        sync.Mutex
+	responses []int
+	requests  []receivedRequest
+}
+
+type receivedRequest struct {
+	header  http.Header
+	body    []byte
+	payload Payload
+	parts   map[string]string
+}
+
+func newReceiver(t *testing.T, responses ...int) (*receiver, *httptest.Server) {
+	t.Helper()
+
+	rec := &receiver{responses: responses}
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+	return rec, server
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	got := receivedRequest{header: req.Header.Clone(), parts: make(map[string]string)}
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got.body = []byte(req.FormValue("payload"))
+		for name, files := range req.MultipartForm.File {
+			f, _ := files[0].Open()
+			content, _ := io.ReadAll(f)
+			f.Close()
+			got.parts[name] = string(content)
+		}
+	} else {
+		got.body, _ = io.ReadAll(req.Body)
+	}
+	_ = json.Unmarshal(got.body, &got.payload)
+
+	r.mu.Lock()
+	r.requests = append(r.requests, got)
+	status := http.StatusNoContent
+	if len(r.responses) > 0 {
+		status, r.responses = r.responses[0], r.responses[1:]
+	}
+	r.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest(nil), r.requests...)
+}
+
+func newTestOutput(t *testing.T, serverURL string, settings map[string]interface{}) *Output {
+	t.Helper()
+
+	p, err := NewOutput(plugins.PluginConfig{Name: "webhook-test", Type: "output", Version: "1.0.0"})
+	require.NoError(t, err)
+	out := p.(*Output)
+
+	config := map[string]interface{}{"url": serverURL, "secret": testSecret}
+	for k, v := range settings {
+		config[k] = v
+	}
+	require.NoError(t, out.Configure(config))
+	out.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	require.NoError(t, out.Start(context.Background()))
+	return out
+}
+
+func stream(id string) *interfaces.DataStream {
+	return &interfaces.DataStream{
+		ID:       id,
+		Type:     interfaces.MediaTypePhoto,
+		Metadata: map[string]interface{}{"title": "item " + id},
+		Content:  io.NopCloser(strings.NewReader("content of " + id)),
+		Headers:  map[string]string{"Content-Type": "image/jpeg"},
+		Context:  interfaces.StreamContext{Source: "webdav", CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+	}
+}
+
+func TestOutput_PublishSignedEnvelope(t *testing.T) {
+	rec, server := newReceiver(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{
+		"headers": map[string]interface{}{"X-Team": "media"},
+	})
+
+	data := stream("photo-1")
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	requests := rec.received()
+	require.Len(t, requests, 1)
+	req := requests[0]
+
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	assert.Equal(t, "media", req.header.Get("X-Team"))
+	timestamp, err := strconv.ParseInt(req.header.Get("X-Webhook-Timestamp"), 10, 64)
+	require.NoError(t, err)
+	assert.True(t, Verify([]byte(testSecret), timestamp, req.body, req.header.Get("X-Webhook-Signature")))
+	assert.False(t, Verify([]byte("other"), timestamp, req.body, req.header.Get("X-Webhook-Signature")))
+
+	assert.Equal(t, "media.synced", req.payload.Event)
+	assert.Equal(t, req.header.Get("X-Webhook-Delivery"), req.payload.DeliveryID)
+	require.Len(t, req.payload.Items, 1)
+	item := req.payload.Items[0]
+	assert.Equal(t, "photo-1", item.ID)
+	assert.Equal(t, interfaces.MediaTypePhoto, item.Type)
+	assert.Equal(t, "webdav", item.Source)
+	assert.Equal(t, "item photo-1", item.Metadata["title"])
+	assert.Empty(t, item.ContentPart)
+
+	assert.Equal(t, req.payload.DeliveryID, data.Metadata[MetaDeliveryID])
+	assert.Equal(t, string(DeliveryDelivered), data.Metadata[MetaStatus])
+	assert.Equal(t, 1, data.Metadata[MetaAttempts])
+}
+
+func TestOutput_MultipartIncludesContent(t *testing.T) {
+	rec, server := newReceiver(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"mode": "multipart"})
+
+	require.NoError(t, out.Publish(context.Background(), stream("photo-1")))
+
+	requests := rec.received()
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0].payload.Items, 1)
+	assert.Equal(t, "content_0", requests[0].payload.Items[0].ContentPart)
+	assert.Equal(t, "content of photo-1", requests[0].parts["content_0"])
+}
+
+func TestOutput_Batching(t *testing.T) {
+	rec, server := newReceiver(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"batch_size": 2})
+
+	first, second, third := stream("a"), stream("b"), stream("c")
+	require.NoError(t, out.Publish(context.Background(), first))
+	assert.Empty(t, rec.received())
+	assert.Equal(t, string(DeliveryQueued), first.Metadata[MetaStatus])
+
+	require.NoError(t, out.Publish(context.Background(), second))
+	requests := rec.received()
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0].payload.Items, 2)
+	assert.Equal(t, first.Metadata[MetaDeliveryID], second.Metadata[MetaDeliveryID])
+	assert.Equal(t, string(DeliveryDelivered), second.Metadata[MetaStatus])
+
+	require.NoError(t, out.Publish(context.Background(), third))
+	assert.NotEqual(t, first.Metadata[MetaDeliveryID], third.Metadata[MetaDeliveryID])
+	require.NoError(t, out.Stop(context.Background()))
+
+	requests = rec.received()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "c", requests[1].payload.Items[0].ID)
+
+	deliveries := out.Deliveries()
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, []string{"a", "b"}, deliveries[0].ItemIDs)
+	assert.Equal(t, DeliveryDelivered, deliveries[1].Status)
+}
+
+func TestOutput_FlushInterval(t *testing.T) {
+	rec, server := newReceiver(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"batch_size": 10, "flush_interval": "20ms"})
+	defer out.Stop(context.Background())
+
+	require.NoError(t, out.Publish(context.Background(), stream("a")))
+	assert.Eventually(t, func() bool { return len(rec.received()) == 1 }, time.Second, 5*time.Millisecond)
+}
+
+func TestOutput_RetriesServerErrors(t *testing.T) {
+	rec, server := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	out := newTestOutput(t, server.URL, nil)
+
+	var waits []time.Duration
+	out.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	data := stream("photo-1")
+	require.NoError(t, out.Publish(context.Background(), data))
+
+	requests := rec.received()
+	require.Len(t, requests, 3)
+	for _, req := range requests {
+		assert.Equal(t, requests[0].header.Get("X-Webhook-Delivery"), req.header.Get("X-Webhook-Delivery"))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, waits)
+	assert.Equal(t, 3, data.Metadata[MetaAttempts])
+
+	deliveries := out.Deliveries()
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+	assert.Equal(t, 1, out.Health().Details["deliveries_succeeded"])
+}
+
+func TestOutput_RecordsFailedDelivery(t *testing.T) {
+	rec, server := newReceiver(t, http.StatusBadRequest)
+	out := newTestOutput(t, server.URL, nil)
+
+	data := stream("photo-1")
+	err := out.Publish(context.Background(), data)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400")
+
+	assert.Len(t, rec.received(), 1, "client errors are not retried")
+	assert.Equal(t, string(DeliveryFailed), data.Metadata[MetaStatus])
+
+	deliveries := out.Deliveries()
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, http.StatusBadRequest, deliveries[0].StatusCode)
+
+	health := out.Health()
+	assert.Equal(t, interfaces.StatusWarning, health.Status)
+	assert.Equal(t, 1, health.Details["deliveries_failed"])
+}
+
+func TestOutput_GivesUpAfterMaxRetries(t *testing.T) {
+	rec, server := newReceiver(t, 500, 500, 500, 500, 500)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"max_retries": 2})
+
+	require.Error(t, out.Publish(context.Background(), stream("photo-1")))
+	assert.Len(t, rec.received(), 3)
+	assert.Equal(t, 3, out.Deliveries()[0].Attempts)
+}
+
+func TestOutput_RequeuesFailedBatch(t *testing.T) {
+	rec, server := newReceiver(t, http.StatusServiceUnavailable)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"batch_size": 2, "max_queued": 3, "max_retries": 0})
+
+	first, second := stream("a"), stream("b")
+	require.NoError(t, out.Publish(context.Background(), first))
+	require.NoError(t, out.Publish(context.Background(), second), "the failed batch stays queued")
+	assert.Equal(t, string(DeliveryQueued), first.Metadata[MetaStatus])
+	assert.Equal(t, string(DeliveryQueued), second.Metadata[MetaStatus])
+	assert.Equal(t, 2, out.Health().Details["queued"])
+
+	require.NoError(t, out.Publish(context.Background(), stream("c")))
+	assert.Error(t, out.Publish(context.Background(), stream("d")), "the queue is full")
+
+	require.NoError(t, out.Stop(context.Background()))
+	requests := rec.received()
+	require.Len(t, requests, 3)
+	assert.Equal(t, requests[0].payload.DeliveryID, requests[1].payload.DeliveryID, "a retried batch keeps its delivery ID")
+	assert.Len(t, requests[1].payload.Items, 2)
+	assert.Equal(t, "c", requests[2].payload.Items[0].ID)
+	assert.Equal(t, 0, out.Health().Details["queued"])
+}
+
+func TestOutput_DropsRejectedBatch(t *testing.T) {
+	rec, server := newReceiver(t, http.StatusBadRequest)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"batch_size": 2, "max_queued": 2})
+
+	first, second := stream("a"), stream("b")
+	require.NoError(t, out.Publish(context.Background(), first))
+	err := out.Publish(context.Background(), second)
+	require.Error(t, err, "a rejected batch is reported to the caller")
+	assert.Contains(t, err.Error(), "400")
+	assert.Equal(t, string(DeliveryFailed), second.Metadata[MetaStatus])
+	assert.Equal(t, 0, out.Health().Details["queued"])
+
+	third, fourth := stream("c"), stream("d")
+	require.NoError(t, out.Publish(context.Background(), third))
+	require.NoError(t, out.Publish(context.Background(), fourth), "the rejected batch does not block later items")
+	assert.Equal(t, string(DeliveryDelivered), fourth.Metadata[MetaStatus])
+
+	requests := rec.received()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "c", requests[1].payload.Items[0].ID)
+
+	deliveries := out.Deliveries()
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, DeliveryDelivered, deliveries[1].Status)
+}
+
+func TestOutput_QueueIsBoundedByBytes(t *testing.T) {
+	rec, server := newReceiver(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"mode": "multipart", "batch_size": 3, "max_queued_bytes": 20})
+
+	require.NoError(t, out.Publish(context.Background(), stream("a")))
+	err := out.Publish(context.Background(), stream("b"))
+	require.Error(t, err, "the content would take the queue past max_queued_bytes")
+	assert.ErrorIs(t, err, media.ErrTooLarge)
+
+	require.NoError(t, out.Stop(context.Background()))
+	requests := rec.received()
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0].payload.Items, 1)
+	assert.Equal(t, "content of a", requests[0].parts["content_0"])
+}
+
+func TestOutput_BatchOutlivesCallerContext(t *testing.T) {
+	rec, server := newReceiver(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"batch_size": 2})
+	defer out.Stop(context.Background())
+
+	require.NoError(t, out.Publish(context.Background(), stream("a")))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, out.Publish(ctx, stream("b")))
+
+	requests := rec.received()
+	require.Len(t, requests, 1, "the batch is delivered although its last caller gave up")
+	assert.Len(t, requests[0].payload.Items, 2)
+}
+
+func TestOutput_HistoryIsBounded(t *testing.T) {
+	_, server := newReceiver(t)
+	out := newTestOutput(t, server.URL, map[string]interface{}{"history_size": 2})
+
+	for _, id := range []string{"a", "b", "c"} {
+		require.NoError(t, out.Publish(context.Background(), stream(id)))
+	}
+	deliveries := out.Deliveries()
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, []string{"b"}, deliveries[0].ItemIDs)
+	assert.Equal(t, []string{"c"}, deliveries[1].ItemIDs)
+}
+
+func TestOutput_ConfigValidation(t *testing.T) {
+	p, err := NewOutput(plugins.PluginConfig{Name: "webhook-test", Type: "output"})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{"url": "ftp://example.com"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{"url": "https://example.com", "mode": "xml"}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{"url": "https://example.com", "batch_size": 0}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{"url": "https://example.com", "batch_size": 5, "max_queued": 2}), plugins.ErrInvalidConfig)
+	assert.ErrorIs(t, p.Configure(map[string]interface{}{"url": "https://example.com", "max_queued_bytes": 0}), plugins.ErrInvalidConfig)
+	assert.Error(t, p.Start(context.Background()))
+}